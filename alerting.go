@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const alertStateFileName = "alerts.json"
+
+// envAlertKey holds the pagerduty routing key or opsgenie api key alerts are sent with, so it stays out of the config file.
+const envAlertKey = "VALIDATEBACKUPS_ALERT_KEY"
+
+// kinds of Alerting
+const (
+	alertingPagerDuty = "pagerduty"
+	alertingOpsgenie  = "opsgenie"
+)
+
+// defaultAlertURLs are the event apis alerts go to when Alerting.URL isn't set.
+var defaultAlertURLs = map[string]string{
+	alertingPagerDuty: "https://events.pagerduty.com/v2/enqueue",
+	alertingOpsgenie:  "https://api.opsgenie.com/v2/alerts",
+}
+
+// AlertState is the buckets with an alert open, and when it was triggered.
+type AlertState struct {
+	Open map[string]time.Time `json:"open"`
+}
+
+// alertSender triggers and resolves alerts about a bucket, the dedup key keeps one alert per bucket.
+type alertSender struct {
+	alerting Alerting
+	key      string
+	client   *http.Client
+}
+
+func getAlertStateFilePath(config Config) string {
+	return filepath.Join(getStateDirectory(config), alertStateFileName)
+}
+
+func loadAlertState(filePath string) (state *AlertState, err error) {
+	state = &AlertState{}
+	contents, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Annotatef(err, "Unable to open alert state file at %s", filePath)
+	}
+	if err == nil {
+		err = json.Unmarshal(contents, state)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to parse alert state file at %s", filePath)
+		}
+	}
+	if state.Open == nil {
+		state.Open = make(map[string]time.Time)
+	}
+	return state, nil
+}
+
+func saveAlertState(filePath string, state *AlertState) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open alert state file %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	return json.NewEncoder(jsonFile).Encode(state)
+}
+
+func validateAlerting(alerting Alerting, key string) error {
+	if _, known := defaultAlertURLs[alerting.Kind]; !known {
+		return errors.NotValidf("Alerting kind %q, expected %s or %s", alerting.Kind, alertingPagerDuty, alertingOpsgenie)
+	}
+	if len(key) == 0 {
+		return errors.NotFoundf("Alerting key in %s", envAlertKey)
+	}
+	return nil
+}
+
+func newAlertSender(alerting Alerting, key string) *alertSender {
+	if len(alerting.URL) == 0 {
+		alerting.URL = defaultAlertURLs[alerting.Kind]
+	}
+	alerting.URL = strings.TrimSuffix(alerting.URL, "/")
+	return &alertSender{alerting: alerting, key: key, client: &http.Client{Timeout: time.Minute}}
+}
+
+// getAlertDedupKey is the same every run for a bucket, so repeated failures update one alert and a pass can resolve it.
+func getAlertDedupKey(bucketName string) string {
+	return "validatebackups/" + bucketName
+}
+
+func (s *alertSender) post(ctx context.Context, target string, body interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.alerting.Kind == alertingOpsgenie {
+		req.Header.Set("Authorization", "GenieKey "+s.key)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to send alert to %s", target)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("Alerting at %s returned %s: %s", target, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// trigger opens an alert about the bucket failing validation, or updates the one already open.
+func (s *alertSender) trigger(ctx context.Context, bs *BucketSummary) error {
+	summary := fmt.Sprintf("Backups of bucket %s failed validation: %s", bs.BucketName, bs.ValidationError)
+	details := map[string]interface{}{"bucket": bs.BucketName, "type": bs.Type, "error": bs.ValidationError, "newest_object": bs.NewestObject}
+	if s.alerting.Kind == alertingOpsgenie {
+		//opsgenie caps messages at 130 characters, the error is in the description
+		return s.post(ctx, s.alerting.URL, map[string]interface{}{"message": fmt.Sprintf("Backups of bucket %s failed validation", bs.BucketName),
+			"alias": getAlertDedupKey(bs.BucketName), "description": summary, "priority": "P1", "source": "validatebackups", "details": map[string]string{
+				"bucket": bs.BucketName, "type": bs.Type}})
+	}
+	return s.post(ctx, s.alerting.URL, map[string]interface{}{"routing_key": s.key, "event_action": "trigger", "dedup_key": getAlertDedupKey(bs.BucketName),
+		"payload": map[string]interface{}{"summary": summary, "source": "validatebackups", "severity": "critical", "custom_details": details}})
+}
+
+// resolve closes the bucket's alert.
+func (s *alertSender) resolve(ctx context.Context, bucketName string) error {
+	if s.alerting.Kind == alertingOpsgenie {
+		return s.post(ctx, s.alerting.URL+"/"+url.PathEscape(getAlertDedupKey(bucketName))+"/close?identifierType=alias",
+			map[string]string{"source": "validatebackups", "note": "Bucket passed validation again."})
+	}
+	return s.post(ctx, s.alerting.URL, map[string]interface{}{"routing_key": s.key, "event_action": "resolve", "dedup_key": getAlertDedupKey(bucketName)})
+}
+
+// sendAlerts triggers an alert for every bucket of the alerted types that failed validation, every run it fails,
+// and resolves the alerts of those that pass again. Buckets that weren't validated are left as they are.
+func sendAlerts(ctx context.Context, sender *alertSender, state *AlertState, rs *RunSummary, now time.Time) error {
+	bucketTypes := sender.alerting.BucketTypes
+	if len(bucketTypes) == 0 {
+		bucketTypes = []string{"server-backup"}
+	}
+	var problems []string
+	for _, bs := range rs.Buckets {
+		if !slices.Contains(bucketTypes, bs.Type) {
+			continue
+		}
+		_, open := state.Open[bs.BucketName]
+		switch {
+		case bs.ValidationResult == validationFailed:
+			err := sender.trigger(ctx, bs)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", bs.BucketName, err))
+			} else if !open {
+				state.Open[bs.BucketName] = now
+			}
+		case bs.ValidationResult == validationPassed && open:
+			err := sender.resolve(ctx, bs.BucketName)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", bs.BucketName, err))
+			} else {
+				delete(state.Open, bs.BucketName)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("Unable to send alerts (%s)", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// alertOnFailures sends alerts about failing buckets when alerting is configured.
+// Problems sending them are warnings, they shouldn't hide the validation results.
+func alertOnFailures(ctx context.Context, config Config, rs *RunSummary, getenv func(string) string) {
+	if len(config.Alerting.Kind) == 0 {
+		return
+	}
+	key := getenv(envAlertKey)
+	err := validateAlerting(config.Alerting, key)
+	if err != nil {
+		rs.warn("unable to send alerts. %v", err)
+		return
+	}
+	filePath := getAlertStateFilePath(config)
+	state, err := loadAlertState(filePath)
+	if err != nil {
+		rs.warn("unable to send alerts. %v", err)
+		return
+	}
+	err = sendAlerts(ctx, newAlertSender(config.Alerting, key), state, rs, time.Now().UTC())
+	if err != nil {
+		rs.warn("%v", err)
+	}
+	err = saveAlertState(filePath, state)
+	if err != nil {
+		rs.warn("unable to save alert state. %v", err)
+	}
+}
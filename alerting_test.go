@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAlertNow = time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+
+func newTestAlertServer(t *testing.T) (server *httptest.Server, requests *[]string, bodies *[]map[string]interface{}) {
+	requests, bodies = &[]string{}, &[]map[string]interface{}{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		*bodies = append(*bodies, body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+	return
+}
+
+func TestSendAlertsPagerDuty(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	server, requests, bodies := newTestAlertServer(t)
+	sender := newAlertSender(Alerting{Kind: alertingPagerDuty, URL: server.URL}, "routing")
+	state := &AlertState{Open: make(map[string]time.Time)}
+	summary := getTestRunSummary()
+
+	is.NoError(sendAlerts(ctx, sender, state, summary, testAlertNow))
+	is.Len(*requests, 1, "Should only alert about server backups")
+	is.Equal("trigger", (*bodies)[0]["event_action"])
+	is.Equal("routing", (*bodies)[0]["routing_key"])
+	is.Equal("validatebackups/test-matt-server-backups", (*bodies)[0]["dedup_key"])
+	is.Equal("Backups of bucket test-matt-server-backups failed validation: too old", (*bodies)[0]["payload"].(map[string]interface{})["summary"])
+	is.Equal(map[string]time.Time{"test-matt-server-backups": testAlertNow}, state.Open)
+
+	is.NoError(sendAlerts(ctx, sender, state, summary, testAlertNow.Add(time.Hour)))
+	is.Len(*requests, 2, "Should keep triggering while the bucket fails")
+	is.Equal(testAlertNow, state.Open["test-matt-server-backups"], "Should remember when the alert opened")
+
+	summary.Buckets[1].setValidationResult(nil)
+	is.NoError(sendAlerts(ctx, sender, state, summary, testAlertNow.Add(2*time.Hour)))
+	is.Equal("resolve", (*bodies)[2]["event_action"])
+	is.Empty(state.Open)
+	is.NoError(sendAlerts(ctx, sender, state, summary, testAlertNow.Add(3*time.Hour)))
+	is.Len(*requests, 3, "Should only resolve alerts that are open")
+}
+
+func TestSendAlertsOpsgenie(t *testing.T) {
+	is := assert.New(t)
+	server, requests, bodies := newTestAlertServer(t)
+	sender := newAlertSender(Alerting{Kind: alertingOpsgenie, URL: server.URL + "/v2/alerts/", BucketTypes: []string{"media", "server-backup"}}, "genie")
+	state := &AlertState{Open: map[string]time.Time{"test-matt-media": testAlertNow}}
+
+	is.NoError(sendAlerts(context.Background(), sender, state, getTestRunSummary(), testAlertNow))
+	is.Equal([]string{
+		"POST /v2/alerts/validatebackups%2Ftest-matt-media/close?identifierType=alias GenieKey genie",
+		"POST /v2/alerts GenieKey genie",
+	}, *requests)
+	is.Equal("validatebackups/test-matt-server-backups", (*bodies)[1]["alias"])
+	is.Equal("Backups of bucket test-matt-server-backups failed validation: too old", (*bodies)[1]["description"])
+	is.Equal(map[string]time.Time{"test-matt-server-backups": testAlertNow}, state.Open)
+}
+
+func TestAlertOnFailures(t *testing.T) {
+	is := assert.New(t)
+	config := Config{StateDirectory: t.TempDir(), Alerting: Alerting{Kind: "pager"}}
+	summary := getTestRunSummary()
+	alertOnFailures(context.Background(), config, summary, func(string) string { return "key" })
+	is.Len(summary.Warnings, 1)
+	is.Contains(summary.Warnings[0], "Alerting kind \"pager\"")
+
+	server, _, _ := newTestAlertServer(t)
+	config.Alerting = Alerting{Kind: alertingPagerDuty, URL: server.URL}
+	alertOnFailures(context.Background(), config, summary, func(string) string { return "key" })
+	state, err := loadAlertState(filepath.Join(config.StateDirectory, alertStateFileName))
+	is.NoError(err)
+	is.Contains(state.Open, "test-matt-server-backups", "Should save which alerts are open")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// classes of storage operation, as they are billed
+const (
+	operationClassA = "A"
+	operationClassB = "B"
+)
+
+// operationCostPer1000 is what a thousand operations of each class cost, in US dollars, at standard storage list prices.
+var operationCostPer1000 = map[string]float64{
+	operationClassA: 0.005,
+	operationClassB: 0.0004,
+}
+
+// egressCostPerGiB is what downloading to the internet costs, in US dollars, at the list price for the first TiB a month.
+const egressCostPerGiB = 0.12
+
+// APIOperations counts a bucket's billed storage operations. Listing, uploads and changes are class A,
+// getting attributes or permissions and reading objects are class B, deletes are free.
+type APIOperations struct {
+	ClassA int `json:"class_a"`
+	ClassB int `json:"class_b"`
+}
+
+func (ops APIOperations) add(other APIOperations) APIOperations {
+	return APIOperations{ClassA: ops.ClassA + other.ClassA, ClassB: ops.ClassB + other.ClassB}
+}
+
+// cost estimates what the operations cost in US dollars.
+func (ops APIOperations) cost() float64 {
+	return (float64(ops.ClassA)*operationCostPer1000[operationClassA] + float64(ops.ClassB)*operationCostPer1000[operationClassB]) / 1000
+}
+
+func getEgressCost(bytes int64) float64 {
+	return egressCostPerGiB * float64(bytes) / (1 << 30)
+}
+
+// getOperationClass is the class of operation a request to cloud storage is billed as, blank when it is free.
+// Json api requests start /storage/v1/, media is read from /download/storage/v1/ or through the xml api from /bucket/object.
+func getOperationClass(req *http.Request) string {
+	switch req.Method {
+	case http.MethodDelete:
+		return ""
+	case http.MethodGet, http.MethodHead:
+	default:
+		return operationClassA
+	}
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	if strings.HasPrefix(path, storageAPIPathPrefix) || path+"/" == storageAPIPathPrefix {
+		//lists end in the collection, e.g. /b/bucket/o, gets name an item in it
+		for _, collection := range []string{"/b", "/o", "/acl", "/defaultObjectAcl", "/notificationConfigs"} {
+			if strings.HasSuffix(path, collection) {
+				return operationClassA
+			}
+		}
+		return operationClassB
+	}
+	//an xml api get of a bucket itself lists its objects
+	if !strings.HasPrefix(path, "/download/") && len(getXMLObjectName(req)) == 0 && len(getOperationBucket(req)) > 0 {
+		return operationClassA
+	}
+	return operationClassB
+}
+
+// getOperationBucket is the bucket a request to cloud storage is about, blank when it isn't about one, like listing buckets.
+func getOperationBucket(req *http.Request) string {
+	host := strings.ToLower(req.URL.Hostname())
+	if strings.HasSuffix(host, "."+storageHostSuffix) {
+		return strings.TrimSuffix(host, "."+storageHostSuffix)
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/download"), "/upload")
+	if strings.HasPrefix(path, storageAPIPathPrefix) {
+		bucketPath, found := strings.CutPrefix(strings.TrimPrefix(path, storageAPIPathPrefix), "b/")
+		if !found {
+			return ""
+		}
+		bucket, _, _ := strings.Cut(bucketPath, "/")
+		return bucket
+	}
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return bucket
+}
+
+// getXMLObjectName is the object named by an xml api request, blank for a request about the bucket itself.
+func getXMLObjectName(req *http.Request) string {
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if strings.HasSuffix(strings.ToLower(req.URL.Hostname()), "."+storageHostSuffix) {
+		return path
+	}
+	_, object, _ := strings.Cut(path, "/")
+	return object
+}
+
+// countOperation counts the request as an operation of its class, on the run and its bucket.
+func (c *apiCallCounter) countOperation(req *http.Request) {
+	var ops APIOperations
+	switch getOperationClass(req) {
+	case operationClassA:
+		ops.ClassA = 1
+	case operationClassB:
+		ops.ClassB = 1
+	default:
+		return
+	}
+	bucket := getOperationBucket(req)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buckets == nil {
+		c.buckets = make(map[string]APIOperations)
+	}
+	c.buckets[bucket] = c.buckets[bucket].add(ops)
+	c.stats.Operations = c.stats.Operations.add(ops)
+}
+
+func (c *apiCallCounter) bucketOperations() map[string]APIOperations {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	operations := make(map[string]APIOperations, len(c.buckets))
+	for bucket, ops := range c.buckets {
+		operations[bucket] = ops
+	}
+	return operations
+}
+
+// getAPICostSection estimates what each bucket's operations and downloads cost, then the whole run's.
+// Operations on no bucket in particular, like listing buckets, or on buckets not in the summary are listed as other operations.
+func getAPICostSection(rs *RunSummary) (section summarySection) {
+	section.heading = "Estimated api and egress cost"
+	var all APIOperations
+	var bytes int64
+	for _, bs := range rs.Buckets {
+		//downloads are operations too, a bucket without any wasn't touched
+		if bs.APIOperations == (APIOperations{}) {
+			continue
+		}
+		section.items = append(section.items, summaryItem{text: bs.BucketName + ": " + describeAPICost(bs.APIOperations, bs.BytesDownloaded)})
+		all = all.add(bs.APIOperations)
+		bytes += bs.BytesDownloaded
+	}
+	other := APIOperations{ClassA: max(rs.APICalls.Operations.ClassA-all.ClassA, 0), ClassB: max(rs.APICalls.Operations.ClassB-all.ClassB, 0)}
+	if other != (APIOperations{}) {
+		section.items = append(section.items, summaryItem{text: "other operations: " + describeAPICost(other, 0)})
+		all = all.add(other)
+	}
+	if len(section.items) > 0 {
+		section.items = append(section.items, summaryItem{text: "whole run: " + describeAPICost(all, bytes)})
+	}
+	return
+}
+
+func describeAPICost(ops APIOperations, bytes int64) string {
+	return fmt.Sprintf("%d class A and %d class B operations $%.4f, %s downloaded $%.2f, total $%.2f",
+		ops.ClassA, ops.ClassB, ops.cost(), formatBytes(bytes), getEgressCost(bytes), ops.cost()+getEgressCost(bytes))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testGetOperationClassCases = []struct {
+	method string
+	url    string
+	class  string
+	bucket string
+}{
+	{http.MethodGet, "https://storage.googleapis.com/storage/v1/b/test-matt-media/o?prefix=show%2F", operationClassA, "test-matt-media"},
+	{http.MethodGet, "https://storage.googleapis.com/storage/v1/b/test-matt-media/o/show%2Fepisode.mkv", operationClassB, "test-matt-media"},
+	{http.MethodGet, "https://storage.googleapis.com/storage/v1/b/test-matt-media", operationClassB, "test-matt-media"},
+	{http.MethodGet, "https://storage.googleapis.com/storage/v1/b/test-matt-media/iam/testPermissions?permissions=storage.objects.list", operationClassB, "test-matt-media"},
+	{http.MethodGet, "https://storage.googleapis.com/storage/v1/b?project=backups", operationClassA, ""},
+	{http.MethodPost, "https://storage.googleapis.com/upload/storage/v1/b/test-matt-media/o?uploadType=media", operationClassA, "test-matt-media"},
+	{http.MethodDelete, "https://storage.googleapis.com/storage/v1/b/test-matt-media/o/episode.mkv", "", "test-matt-media"},
+	{http.MethodGet, "https://storage.googleapis.com/test-matt-media/show/episode.mkv", operationClassB, "test-matt-media"},
+	{http.MethodGet, "https://storage.googleapis.com/download/storage/v1/b/test-matt-media/o/episode.mkv?alt=media", operationClassB, "test-matt-media"},
+	{http.MethodGet, "https://test-matt-media.storage.googleapis.com/show/episode.mkv", operationClassB, "test-matt-media"},
+	{http.MethodGet, "https://test-matt-media.storage.googleapis.com/?prefix=show", operationClassA, "test-matt-media"},
+	{http.MethodGet, "http://localhost:4443/storage/v1/b/test-matt-media/o", operationClassA, "test-matt-media"},
+}
+
+func TestGetOperationClass(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetOperationClassCases {
+		req, _ := http.NewRequest(tc.method, tc.url, nil)
+		is.Equal(tc.class, getOperationClass(req), "%s %s", tc.method, tc.url)
+		is.Equal(tc.bucket, getOperationBucket(req), "%s %s", tc.method, tc.url)
+	}
+}
+
+func TestCountOperation(t *testing.T) {
+	is := assert.New(t)
+	calls := &apiCallCounter{}
+	for _, tc := range testGetOperationClassCases {
+		req, _ := http.NewRequest(tc.method, tc.url, nil)
+		calls.countOperation(req)
+	}
+	is.Equal(APIOperations{ClassA: 5, ClassB: 6}, calls.snapshot().Operations)
+	operations := calls.bucketOperations()
+	is.Equal(APIOperations{ClassA: 4, ClassB: 6}, operations["test-matt-media"])
+	is.Equal(APIOperations{ClassA: 1}, operations[""], "Should count operations on no bucket in particular apart")
+}
+
+func TestGetAPICostSection(t *testing.T) {
+	is := assert.New(t)
+	is.Empty(getAPICostSection(newRunSummary()).items)
+	summary := getTestRunSummary()
+
+	summary.Buckets[0].APIOperations = APIOperations{ClassA: 2000, ClassB: 10000}
+	summary.Buckets[0].BytesDownloaded = 10 << 30
+	summary.APICalls.Operations = APIOperations{ClassA: 2001, ClassB: 10000}
+	section := getAPICostSection(summary)
+	is.Len(section.items, 3)
+	is.Equal("test-matt-media: 2000 class A and 10000 class B operations $0.0140, 10.0 GiB downloaded $1.20, total $1.21", section.items[0].text)
+	is.Equal("other operations: 1 class A and 0 class B operations $0.0000, 0 B downloaded $0.00, total $0.00", section.items[1].text)
+	is.Contains(section.items[2].text, "whole run: 2001 class A and 10000 class B operations")
+
+	var metrics bytes.Buffer
+	writeDownloadMetrics(&metrics, summary)
+	is.Contains(metrics.String(), `validatebackups_api_operations{bucket="test-matt-media",class="A"} 2000`)
+	is.Contains(metrics.String(), `validatebackups_estimated_cost_dollars{bucket="test-matt-media"} 1.21`)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// storageAPIPathPrefix starts the path of every json api call other than media downloads, e.g. listing objects or getting their attributes.
+const storageAPIPathPrefix = "/storage/v1/"
+
+// APICallStats counts the storage api calls a run made, other than downloads, and how long they waited for the api_qps limit.
+// QPSLimit is the limit, 0 when there isn't one. Operations counts every billed operation, downloads included, see APIOperations.
+type APICallStats struct {
+	QPSLimit      float64       `json:"qps_limit"`
+	Calls         int           `json:"calls"`
+	WaitedSeconds float64       `json:"waited_seconds"`
+	Operations    APIOperations `json:"operations"`
+}
+
+// apiCallCounter is shared by every client the process makes, so the limit holds across buckets listed in parallel.
+// Operations are also counted by bucket, operations on no bucket in particular, like listing buckets, are under a blank name.
+type apiCallCounter struct {
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	stats   APICallStats
+	buckets map[string]APIOperations
+}
+
+// apiCalls counts the api calls of the whole run, see apiLimitTransport.
+var apiCalls = &apiCallCounter{}
+
+// setLimit limits api calls to qps a second, allowing bursts of a second's worth. 0 or less takes the limit off.
+func (c *apiCallCounter) setLimit(qps float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.QPSLimit = math.Max(qps, 0)
+	c.limiter = nil
+	if qps > 0 {
+		c.limiter = rate.NewLimiter(rate.Limit(qps), int(math.Max(1, math.Ceil(qps))))
+	}
+}
+
+// wait holds a call to the limit, then counts it.
+func (c *apiCallCounter) wait(req *http.Request) error {
+	c.mu.Lock()
+	limiter := c.limiter
+	c.mu.Unlock()
+	start := time.Now()
+	if limiter != nil {
+		err := limiter.Wait(req.Context())
+		if err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Calls++
+	if limiter != nil {
+		c.stats.WaitedSeconds += time.Since(start).Seconds()
+	}
+	return nil
+}
+
+func (c *apiCallCounter) snapshot() APICallStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// apiLimitTransport counts the json api calls made to cloud storage and holds them to the api_qps limit.
+// Downloads aren't held to the limit but are counted as operations, requests to other hosts like fetching tokens go through untouched.
+// endpointHost is the host of a pinned endpoint, if any, which is treated like the storage hosts.
+type apiLimitTransport struct {
+	base         http.RoundTripper
+	endpointHost string
+	calls        *apiCallCounter
+}
+
+func (t apiLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isStorageAPICall(req, t.endpointHost) {
+		err := t.calls.wait(req)
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	if isStorageHost(req, t.endpointHost) {
+		t.calls.countOperation(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func isStorageAPICall(req *http.Request, endpointHost string) bool {
+	return isStorageHost(req, endpointHost) && strings.HasPrefix(req.URL.Path, storageAPIPathPrefix)
+}
+
+// recordAPICalls notes the api calls made so far on the summary, and each bucket's operations on its summary.
+// It is safe to call on a nil RunSummary.
+func (rs *RunSummary) recordAPICalls() {
+	if rs == nil {
+		return
+	}
+	rs.APICalls = apiCalls.snapshot()
+	operations := apiCalls.bucketOperations()
+	for _, bs := range rs.Buckets {
+		bs.APIOperations = operations[bs.BucketName]
+	}
+}
+
+// getAPICallSection notes how many api calls the run made, and how long they waited for the limit.
+func getAPICallSection(rs *RunSummary) (section summarySection) {
+	section.heading = "API calls"
+	stats := rs.APICalls
+	if stats.Calls == 0 {
+		return
+	}
+	text := fmt.Sprintf("%d calls, not limited", stats.Calls)
+	if stats.QPSLimit > 0 {
+		text = fmt.Sprintf("%d calls, limited to %g a second, %s waiting for the limit", stats.Calls, stats.QPSLimit,
+			(time.Duration(stats.WaitedSeconds * float64(time.Second))).Round(time.Millisecond))
+	}
+	section.items = append(section.items, summaryItem{text: text})
+	return
+}
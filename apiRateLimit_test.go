@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTripFunc is a fake http transport.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestApiLimitTransport(t *testing.T) {
+	is := assert.New(t)
+	calls := &apiCallCounter{}
+	sent := 0
+	transport := apiLimitTransport{calls: calls, endpointHost: "localhost", base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})}
+	get := func(url string) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		_, err := transport.RoundTrip(req)
+		is.NoError(err)
+	}
+
+	get("https://storage.googleapis.com/storage/v1/b/test-matt-media/o?prefix=show%2F")
+	get("https://storage.googleapis.com/test-matt-media/show/episode.mkv")
+	get("https://storage.googleapis.com/download/storage/v1/b/test-matt-media/o/episode.mkv?alt=media")
+	get("https://oauth2.googleapis.com/token")
+	get("http://localhost:4443/storage/v1/b/test-matt-media")
+	is.Equal(5, sent)
+	is.Equal(APICallStats{Calls: 2, Operations: APIOperations{ClassA: 1, ClassB: 3}}, calls.snapshot(),
+		"Should only count json api calls other than downloads as calls, but count downloads as operations")
+
+	calls.setLimit(50)
+	start := time.Now()
+	for i := 0; i < 60; i++ {
+		get("https://storage.googleapis.com/storage/v1/b/test-matt-media/o/episode.mkv")
+	}
+	is.GreaterOrEqual(time.Since(start), 150*time.Millisecond, "Should hold calls past the first second's worth to the limit")
+	stats := calls.snapshot()
+	is.Equal(62, stats.Calls)
+	is.Equal(APIOperations{ClassA: 1, ClassB: 63}, stats.Operations)
+	is.Equal(float64(50), stats.QPSLimit)
+	is.Greater(stats.WaitedSeconds, 0.1)
+
+	calls.setLimit(0)
+	is.Equal(float64(0), calls.snapshot().QPSLimit)
+}
+
+func TestGetAPICallSection(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	is.Empty(getAPICallSection(summary).items, "Should skip the section when no calls were counted")
+
+	summary.APICalls = APICallStats{Calls: 120}
+	is.Equal("120 calls, not limited", getAPICallSection(summary).items[0].text)
+	summary.APICalls = APICallStats{QPSLimit: 2.5, Calls: 120, WaitedSeconds: 41.25}
+	var output strings.Builder
+	is.NoError(writeSummaryTable(&output, summary))
+	is.Contains(output.String(), "120 calls, limited to 2.5 a second, 41.25s waiting for the limit")
+}
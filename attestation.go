@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+const (
+	inTotoStatementType     = "https://in-toto.io/Statement/v1"
+	inTotoPayloadType       = "application/vnd.in-toto+json"
+	verificationPredicateV1 = "https://github.com/mattgiltaji/validatebackups/verification/v1"
+	verifierID              = "https://github.com/mattgiltaji/validatebackups"
+)
+
+// results of a run, as its attestation puts them
+const (
+	verificationPassed = "PASSED"
+	verificationFailed = "FAILED"
+)
+
+// VerifiedObject is a sampled object whose download matched its size and checksums, MD5 in hex and CRC32C as 8 hex digits.
+type VerifiedObject struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5,omitempty"`
+	CRC32C string `json:"crc32c"`
+}
+
+// recordVerifiedObject notes a sampled object was verified on the bucket summary in ctx, if there is one.
+func recordVerifiedObject(ctx context.Context, objAttrs *storage.ObjectAttrs) {
+	bs := bucketSummaryFromContext(ctx)
+	if bs == nil {
+		return
+	}
+	object := VerifiedObject{Name: objAttrs.Name, Size: objAttrs.Size, CRC32C: fmt.Sprintf("%08x", objAttrs.CRC32C)}
+	if len(objAttrs.MD5) > 0 {
+		object.MD5 = hex.EncodeToString(objAttrs.MD5)
+	}
+	bs.VerifiedObjects = append(bs.VerifiedObjects, object)
+}
+
+// InTotoStatement is an in-toto attestation, https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md.
+type InTotoStatement struct {
+	Type          string                `json:"_type"`
+	Subject       []InTotoSubject       `json:"subject"`
+	PredicateType string                `json:"predicateType"`
+	Predicate     VerificationPredicate `json:"predicate"`
+}
+
+// InTotoSubject is an attested object by its gs:// URI, with its digests by algorithm.
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// VerificationPredicate says which build of validatebackups verified the subjects, when, and how each bucket fared.
+// Like a SLSA verification summary, VerificationResult is PASSED only when every bucket passed and every sample verified.
+type VerificationPredicate struct {
+	Verifier           AttestationVerifier `json:"verifier"`
+	TimeVerified       time.Time           `json:"timeVerified"`
+	RunID              string              `json:"runId"`
+	Profile            string              `json:"profile,omitempty"`
+	VerificationResult string              `json:"verificationResult"`
+	Error              string              `json:"error,omitempty"`
+	Buckets            []AttestedBucket    `json:"buckets"`
+}
+
+type AttestationVerifier struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version"`
+}
+
+type AttestedBucket struct {
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	ValidationResult  string `json:"validationResult"`
+	ValidationError   string `json:"validationError,omitempty"`
+	FilesVerified     int    `json:"filesVerified"`
+	FilesFailed       int    `json:"filesFailed"`
+	SignaturesChecked int    `json:"signaturesChecked"`
+	SignatureFailures int    `json:"signatureFailures"`
+}
+
+// DSSEEnvelope is a signed attestation, https://github.com/secure-systems-lab/dsse/blob/master/envelope.md.
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// getAttestation attests to every object the run verified, runErr being why the run stopped early, if it did.
+func getAttestation(rs *RunSummary, runID string, profile string, info BuildInfo, runErr error, now time.Time) (statement InTotoStatement) {
+	statement.Type = inTotoStatementType
+	statement.PredicateType = verificationPredicateV1
+	statement.Subject = []InTotoSubject{}
+	predicate := VerificationPredicate{
+		Verifier:     AttestationVerifier{ID: verifierID, Version: map[string]string{programName: info.Version, "go": info.GoVersion}},
+		TimeVerified: now, RunID: runID, Profile: profile, VerificationResult: verificationPassed, Buckets: []AttestedBucket{},
+	}
+	if len(info.Revision) > 0 {
+		predicate.Verifier.Version["revision"] = info.Revision
+	}
+	if runErr != nil {
+		predicate.VerificationResult, predicate.Error = verificationFailed, runErr.Error()
+	}
+	for _, bs := range rs.Buckets {
+		predicate.Buckets = append(predicate.Buckets, AttestedBucket{Name: bs.BucketName, Type: bs.Type, ValidationResult: bs.ValidationResult,
+			ValidationError: bs.ValidationError, FilesVerified: len(bs.VerifiedObjects), FilesFailed: bs.FilesFailed,
+			SignaturesChecked: bs.SignaturesChecked, SignatureFailures: len(bs.SignatureFailures)})
+		if bs.ValidationResult != validationPassed || bs.FilesFailed > 0 || len(bs.SignatureFailures) > 0 {
+			predicate.VerificationResult = verificationFailed
+		}
+		for _, object := range bs.VerifiedObjects {
+			digest := map[string]string{"crc32c": object.CRC32C}
+			if len(object.MD5) > 0 {
+				digest["md5"] = object.MD5
+			}
+			statement.Subject = append(statement.Subject, InTotoSubject{Name: "gs://" + bs.BucketName + "/" + object.Name, Digest: digest})
+		}
+	}
+	statement.Predicate = predicate
+	return
+}
+
+// loadAttestationKey reads an ed25519 private key in a PKCS #8 PEM file, e.g. from openssl genpkey -algorithm ed25519.
+func loadAttestationKey(keyFile string) (key ed25519.PrivateKey, err error) {
+	contents, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to read attestation signing key %s", keyFile)
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, errors.NotValidf("Attestation signing key %s, expected a PEM file", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to parse attestation signing key %s", keyFile)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.NotSupportedf("Attestation signing key %s of type %T, only ed25519", keyFile, parsed)
+	}
+	return key, nil
+}
+
+// getDSSEPreAuthEncoding is what a DSSE signature is made over, binding the payload to its type.
+func getDSSEPreAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// signAttestation wraps the statement in a DSSE envelope signed with key. The key id is the sha256 of the public key in PKIX form.
+func signAttestation(statement InTotoStatement, key ed25519.PrivateKey) (envelope DSSEEnvelope, err error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return envelope, errors.Annotate(err, "Unable to encode attestation")
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return envelope, errors.Annotate(err, "Unable to encode attestation public key")
+	}
+	keyID := sha256.Sum256(publicKey)
+	signature := ed25519.Sign(key, getDSSEPreAuthEncoding(inTotoPayloadType, payload))
+	return DSSEEnvelope{PayloadType: inTotoPayloadType, Payload: base64.StdEncoding.EncodeToString(payload),
+		Signatures: []DSSESignature{{KeyID: hex.EncodeToString(keyID[:]), Sig: base64.StdEncoding.EncodeToString(signature)}}}, nil
+}
+
+// saveAttestation writes the run's attestation to the attestation directory, a DSSE envelope in runID.intoto.jsonl when there is
+// a signing key, otherwise the bare statement in runID.intoto.json. It returns the file's path, blank when attestations are off.
+func saveAttestation(attestation Attestation, statement InTotoStatement, runID string) (filePath string, err error) {
+	if len(attestation.Directory) == 0 {
+		return "", nil
+	}
+	var document interface{} = statement
+	filePath = filepath.Join(attestation.Directory, runID+".intoto.json")
+	if len(attestation.SigningKeyFile) > 0 {
+		key, err := loadAttestationKey(attestation.SigningKeyFile)
+		if err != nil {
+			return "", err
+		}
+		document, err = signAttestation(statement, key)
+		if err != nil {
+			return "", err
+		}
+		filePath += "l"
+	}
+	contents, err := json.Marshal(document)
+	if err != nil {
+		return "", errors.Annotate(err, "Unable to encode attestation")
+	}
+	err = os.MkdirAll(attestation.Directory, 0755)
+	if err == nil {
+		err = os.WriteFile(filePath, append(contents, '\n'), 0644)
+	}
+	if err != nil {
+		return "", errors.Annotatef(err, "Unable to save attestation %s", filePath)
+	}
+	return filePath, nil
+}
+
+// exportAttestation saves the run's attestation when attestation is set, a problem saving it is only a warning.
+func exportAttestation(config Config, rs *RunSummary, runID string, runErr error) {
+	statement := getAttestation(rs, runID, config.ActiveProfile.Name, getBuildInfo(), runErr, time.Now().UTC())
+	filePath, err := saveAttestation(config.Attestation, statement, runID)
+	if err != nil {
+		rs.warn("%v", err)
+		return
+	}
+	if len(filePath) > 0 {
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Saved attestation for %d verified objects to %s.", len(statement.Subject), filePath))
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestAttestationSummary() *RunSummary {
+	rs := getTestRunSummary()
+	ctx := withBucketSummary(context.Background(), rs.bucket("test-matt-media", "media"))
+	recordVerifiedObject(ctx, &storage.ObjectAttrs{Name: "show 1/episode 1.mkv", Size: 1024, CRC32C: 0xe3069283, MD5: []byte{0xab, 0xcd}})
+	recordVerifiedObject(ctx, &storage.ObjectAttrs{Name: "show 2/episode 1.mkv", Size: 512, CRC32C: 42})
+	return rs
+}
+
+func writeTestKey(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "attestation.pem")
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	return keyFile
+}
+
+func TestRecordVerifiedObject(t *testing.T) {
+	is := assert.New(t)
+	rs := getTestAttestationSummary()
+	is.Equal([]VerifiedObject{
+		{Name: "show 1/episode 1.mkv", Size: 1024, MD5: "abcd", CRC32C: "e3069283"},
+		{Name: "show 2/episode 1.mkv", Size: 512, CRC32C: "0000002a"},
+	}, rs.bucket("test-matt-media", "media").VerifiedObjects)
+	recordVerifiedObject(context.Background(), &storage.ObjectAttrs{Name: "backup.tar.gz"})
+}
+
+func TestGetAttestation(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	info := BuildInfo{Version: "v1.2.3", Revision: "abc123", GoVersion: "go1.22.8"}
+	statement := getAttestation(getTestAttestationSummary(), "20180102-030405", "daily", info, nil, now)
+	is.Equal(inTotoStatementType, statement.Type)
+	is.Equal([]InTotoSubject{
+		{Name: "gs://test-matt-media/show 1/episode 1.mkv", Digest: map[string]string{"crc32c": "e3069283", "md5": "abcd"}},
+		{Name: "gs://test-matt-media/show 2/episode 1.mkv", Digest: map[string]string{"crc32c": "0000002a"}},
+	}, statement.Subject)
+	predicate := statement.Predicate
+	is.Equal(map[string]string{"validatebackups": "v1.2.3", "revision": "abc123", "go": "go1.22.8"}, predicate.Verifier.Version)
+	is.Equal(now, predicate.TimeVerified)
+	is.Equal("daily", predicate.Profile)
+	is.Equal(verificationFailed, predicate.VerificationResult, "Should fail when a bucket failed validation")
+	is.Len(predicate.Buckets, 2)
+	is.Equal(2, predicate.Buckets[0].FilesVerified)
+
+	passing := getTestAttestationSummary()
+	passing.Buckets = passing.Buckets[:1]
+	is.Equal(verificationPassed, getAttestation(passing, "", "", info, nil, now).Predicate.VerificationResult)
+	failed := getAttestation(passing, "", "", info, errors.New("Unable to download"), now).Predicate
+	is.Equal(verificationFailed, failed.VerificationResult, "Should fail when the run stopped early")
+	is.Equal("Unable to download", failed.Error)
+
+	empty := getAttestation(newRunSummary(), "", "", info, nil, now)
+	is.NotNil(empty.Subject, "Should list no subjects rather than null")
+}
+
+func TestSaveAttestation(t *testing.T) {
+	is := assert.New(t)
+	statement := getAttestation(getTestAttestationSummary(), "20180102-030405", "", getBuildInfo(), nil, time.Now().UTC())
+	filePath, err := saveAttestation(Attestation{}, statement, "20180102-030405")
+	is.NoError(err)
+	is.Empty(filePath, "Should do nothing without a directory")
+
+	dir := filepath.Join(t.TempDir(), "attestations")
+	filePath, err = saveAttestation(Attestation{Directory: dir}, statement, "20180102-030405")
+	is.NoError(err)
+	is.Equal(filepath.Join(dir, "20180102-030405.intoto.json"), filePath)
+	var saved InTotoStatement
+	contents, _ := os.ReadFile(filePath)
+	is.NoError(json.Unmarshal(contents, &saved))
+	is.Equal(statement.Subject, saved.Subject)
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	filePath, err = saveAttestation(Attestation{Directory: dir, SigningKeyFile: writeTestKey(t, key)}, statement, "20180102-030405")
+	is.NoError(err)
+	is.Equal(filepath.Join(dir, "20180102-030405.intoto.jsonl"), filePath)
+	var envelope DSSEEnvelope
+	contents, _ = os.ReadFile(filePath)
+	is.NoError(json.Unmarshal(contents, &envelope))
+	is.Equal(inTotoPayloadType, envelope.PayloadType)
+	payload, _ := base64.StdEncoding.DecodeString(envelope.Payload)
+	signature, _ := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	is.True(ed25519.Verify(key.Public().(ed25519.PublicKey), getDSSEPreAuthEncoding(inTotoPayloadType, payload), signature),
+		"Should sign the pre authentication encoding of the payload")
+	is.NoError(json.Unmarshal(payload, &saved))
+	is.Len(envelope.Signatures[0].KeyID, 64)
+
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = saveAttestation(Attestation{Directory: dir, SigningKeyFile: writeTestKey(t, ecdsaKey)}, statement, "20180102-030405")
+	is.True(errors.IsNotSupported(err), "Should only sign with ed25519 keys")
+	os.WriteFile(filepath.Join(dir, "not-a-key.pem"), []byte("not a key"), 0600)
+	_, err = saveAttestation(Attestation{Directory: dir, SigningKeyFile: filepath.Join(dir, "not-a-key.pem")}, statement, "20180102-030405")
+	is.True(errors.IsNotValid(err))
+}
+
+func TestExportAttestation(t *testing.T) {
+	is := assert.New(t)
+	rs := getTestAttestationSummary()
+	exportAttestation(Config{Attestation: Attestation{Directory: t.TempDir(), SigningKeyFile: "missing.pem"}}, rs, "20180102-030405", nil)
+	is.Len(rs.Warnings, 1, "Should warn rather than fail the run")
+}
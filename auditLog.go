@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const auditLogFileName = "audit.jsonl"
+
+// events of an AuditEntry
+const (
+	auditRunStarted  = "run started"
+	auditRunFinished = "run finished"
+)
+
+// AuditEntry is one line of the audit log. Each entry holds the hash of the one before it, and its own hash covers
+// everything else in it, so editing or removing an entry breaks the chain from there on.
+// ConfigHash and ManifestHash are sha256 digests of the config the run used and the objects it downloaded and verified.
+type AuditEntry struct {
+	Time         time.Time  `json:"time"`
+	RunID        string     `json:"run_id"`
+	Event        string     `json:"event"`
+	ConfigHash   string     `json:"config_hash"`
+	ManifestHash string     `json:"manifest_hash,omitempty"`
+	Result       *RunResult `json:"result,omitempty"`
+	PreviousHash string     `json:"previous_hash"`
+	Hash         string     `json:"hash"`
+}
+
+func getAuditLogFilePath(config Config) string {
+	return filepath.Join(getStateDirectory(config), auditLogFileName)
+}
+
+// hashJson is the hex sha256 digest of v marshalled as json.
+func hashJson(v interface{}) (string, error) {
+	contents, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(contents)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// getEntryHash hashes the entry as it would be without its own hash.
+func getEntryHash(entry AuditEntry) (string, error) {
+	entry.Hash = ""
+	return hashJson(entry)
+}
+
+// readAuditLog reads every entry, checking each is chained to the one before it. An empty log has no entries.
+func readAuditLog(r io.Reader) (entries []AuditEntry, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	previous := ""
+	for line := 1; scanner.Scan(); line++ {
+		var entry AuditEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return entries, errors.Annotatef(err, "Unable to parse audit log entry on line %d", line)
+		}
+		hash, err := getEntryHash(entry)
+		if err != nil {
+			return entries, err
+		}
+		if entry.Hash != hash {
+			return entries, errors.NotValidf("Audit log entry on line %d, its hash doesn't match, it was edited", line)
+		}
+		if entry.PreviousHash != previous {
+			return entries, errors.NotValidf("Audit log entry on line %d, it doesn't follow the entry before it, entries were removed or reordered", line)
+		}
+		previous = entry.Hash
+		entries = append(entries, entry)
+	}
+	return entries, errors.Annotate(scanner.Err(), "Unable to read audit log")
+}
+
+// appendAuditEntry chains the entry onto the end of the log and flushes it to disk. It refuses to add to a log
+// that doesn't check out, so a broken chain is noticed rather than buried.
+func appendAuditEntry(filePath string, entry AuditEntry) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open audit log %s", filePath)
+	}
+	defer file.Close()
+	entries, err := readAuditLog(file)
+	if err != nil {
+		return errors.Annotatef(err, "Not adding to audit log %s", filePath)
+	}
+	if len(entries) > 0 {
+		entry.PreviousHash = entries[len(entries)-1].Hash
+	}
+	entry.Hash, err = getEntryHash(entry)
+	if err != nil {
+		return err
+	}
+	var line bytes.Buffer
+	err = json.NewEncoder(&line).Encode(entry)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(line.Bytes())
+	if err != nil {
+		return errors.Annotatef(err, "Unable to add to audit log %s", filePath)
+	}
+	return errors.Annotatef(file.Sync(), "Unable to flush audit log %s to disk", filePath)
+}
+
+// auditedRun records a run's start and end in the audit log.
+// Problems writing the log shouldn't stop backups being validated, they are warnings on the summary,
+// or printed once the summary has been written.
+type auditedRun struct {
+	filePath     string
+	runID        string
+	configHash   string
+	manifestHash string
+	summary      *RunSummary
+	started      time.Time
+	finished     bool
+}
+
+// startAuditedRun logs the start of the run, and makes a fatal error log its end.
+func startAuditedRun(config Config, runID string, summary *RunSummary, now time.Time) *auditedRun {
+	run := &auditedRun{filePath: getAuditLogFilePath(config), runID: runID, summary: summary, started: now}
+	var err error
+	run.configHash, err = hashJson(config)
+	if err != nil {
+		summary.warn("unable to hash the config for the audit log. %v", err)
+	}
+	err = run.append(AuditEntry{Time: now.UTC(), Event: auditRunStarted})
+	if err != nil {
+		summary.warn("unable to write the audit log. %v", err)
+	}
+	previousFatalHook := fatalHook
+	fatalHook = func(err error) {
+		run.finish(err, time.Now())
+		previousFatalHook(err)
+	}
+	return run
+}
+
+// setManifest notes the objects the run downloads and verifies.
+func (run *auditedRun) setManifest(mapping []BucketAndFiles) {
+	hash, err := hashJson(mapping)
+	if err != nil {
+		run.summary.warn("unable to hash the downloads for the audit log. %v", err)
+		return
+	}
+	run.manifestHash = hash
+}
+
+// finish logs the end of the run and how it went, only the first time it is called.
+func (run *auditedRun) finish(err error, now time.Time) {
+	if run.finished {
+		return
+	}
+	run.finished = true
+	result := getRunResult(run.summary, now.Sub(run.started), err)
+	err = run.append(AuditEntry{Time: now.UTC(), Event: auditRunFinished, ManifestHash: run.manifestHash, Result: &result})
+	if err != nil {
+		fmt.Fprintln(console.stdout, "Warning: unable to write the end of the run to the audit log.", err)
+	}
+}
+
+func (run *auditedRun) append(entry AuditEntry) error {
+	entry.RunID, entry.ConfigHash = run.runID, run.configHash
+	return appendAuditEntry(run.filePath, entry)
+}
+
+// writeAuditLog lists each run in the log, one line per entry.
+func writeAuditLog(w io.Writer, entries []AuditEntry) {
+	for _, entry := range entries {
+		line := fmt.Sprintf("%s  %s  %s  config %.12s", entry.Time.Format(time.RFC3339), entry.RunID, entry.Event, entry.ConfigHash)
+		if len(entry.ManifestHash) > 0 {
+			line += fmt.Sprintf("  manifest %.12s", entry.ManifestHash)
+		}
+		if entry.Result != nil {
+			line += fmt.Sprintf("  %s, %d buckets failed, %d files downloaded", entry.Result.Status, entry.Result.BucketsFailed, entry.Result.FilesDownloaded)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// runAudit checks the audit log's chain of hashes and lists the runs in it, exiting with an error if it was edited.
+func runAudit(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	return func(ctx context.Context) {
+		if flags.NArg() != 1 || flags.Arg(0) != "verify" {
+			log.Fatal("The audit command takes verify.")
+		}
+		config, err := loadConfiguration(*configPath, os.Stdin, os.Getenv)
+		logFatalIfErr(err, "Unable to load configuration from file.")
+		filePath := getAuditLogFilePath(config)
+		file, err := os.Open(filePath)
+		logFatalIfErr(errors.Annotatef(err, "Unable to open audit log %s", filePath), "Unable to verify audit log.")
+		defer file.Close()
+		entries, err := readAuditLog(file)
+		writeAuditLog(console.stdout, entries)
+		logFatalIfErr(err, "The audit log doesn't check out.")
+		fmt.Fprintln(console.stdout, fmt.Sprintf("%s: %d entries, chain of hashes intact.", filePath, len(entries)))
+	}
+}
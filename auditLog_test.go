@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestAuditLog(t *testing.T) string {
+	filePath := filepath.Join(t.TempDir(), "state", auditLogFileName)
+	started := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	for i, event := range []string{auditRunStarted, auditRunFinished, auditRunStarted, auditRunFinished} {
+		err := appendAuditEntry(filePath, AuditEntry{Time: started.Add(time.Duration(i) * time.Hour), RunID: "run", Event: event})
+		assert.NoError(t, err)
+	}
+	return filePath
+}
+
+func TestAppendAuditEntry(t *testing.T) {
+	is := assert.New(t)
+	filePath := writeTestAuditLog(t)
+	file, err := os.Open(filePath)
+	is.NoError(err)
+	defer file.Close()
+	entries, err := readAuditLog(file)
+	is.NoError(err)
+	is.Len(entries, 4)
+	is.Empty(entries[0].PreviousHash, "Should start the chain with no previous hash")
+	for i := 1; i < len(entries); i++ {
+		is.Equal(entries[i-1].Hash, entries[i].PreviousHash)
+	}
+}
+
+var testReadAuditLogCases = []struct {
+	edit     func(lines []string) []string
+	expected string
+}{
+	{func(lines []string) []string {
+		lines[1] = strings.Replace(lines[1], "run finished", "run started", 1)
+		return lines
+	}, "line 2, its hash doesn't match"},
+	{func(lines []string) []string { return append(lines[:1], lines[2:]...) }, "line 2, it doesn't follow"},
+	{func(lines []string) []string { return lines[1:] }, "line 1, it doesn't follow"},
+	{func(lines []string) []string { return append([]string{lines[1], lines[0]}, lines[2:]...) }, "line 1, it doesn't follow"},
+}
+
+func TestReadAuditLogEdited(t *testing.T) {
+	is := assert.New(t)
+	for i, tc := range testReadAuditLogCases {
+		contents, err := os.ReadFile(writeTestAuditLog(t))
+		is.NoError(err)
+		lines := tc.edit(strings.Split(strings.TrimSpace(string(contents)), "\n"))
+		_, err = readAuditLog(strings.NewReader(strings.Join(lines, "\n")))
+		is.True(errors.IsNotValid(err), "case %d: %v", i, err)
+		is.ErrorContains(err, tc.expected, "case %d", i)
+	}
+
+	//a log that doesn't check out isn't added to
+	filePath := writeTestAuditLog(t)
+	contents, _ := os.ReadFile(filePath)
+	os.WriteFile(filePath, bytes.Replace(contents, []byte("06:00:00"), []byte("05:00:00"), 1), 0600)
+	err := appendAuditEntry(filePath, AuditEntry{Event: auditRunStarted})
+	is.True(errors.IsNotValid(err))
+	edited, _ := os.ReadFile(filePath)
+	is.Equal(len(contents), len(edited))
+}
+
+func TestAuditedRun(t *testing.T) {
+	is := assert.New(t)
+	defer func(hook func(error)) { fatalHook = hook }(fatalHook)
+	config := Config{StateDirectory: t.TempDir(), Buckets: []BucketToProcess{{Name: "test-matt-media", Type: "media"}}}
+	summary := getTestRunSummary()
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+
+	run := startAuditedRun(config, "20261017T060000Z-0001", summary, now)
+	run.setManifest([]BucketAndFiles{{BucketName: "test-matt-media", Files: []string{"show/s01e01.mkv"}}})
+	fatalHook(errors.New("out of disk space"))
+	run.finish(nil, now.Add(time.Hour))
+
+	file, err := os.Open(getAuditLogFilePath(config))
+	is.NoError(err)
+	defer file.Close()
+	entries, err := readAuditLog(file)
+	is.NoError(err)
+	is.Len(entries, 2, "Should only log the end of the run once")
+	is.Equal(auditRunStarted, entries[0].Event)
+	is.Equal(entries[0].ConfigHash, entries[1].ConfigHash)
+	is.Len(entries[0].ConfigHash, 64)
+	is.Empty(entries[0].ManifestHash)
+	is.Len(entries[1].ManifestHash, 64)
+	is.Equal(validationFailed, entries[1].Result.Status)
+	is.Equal("out of disk space", entries[1].Result.Error)
+	is.Empty(summary.Warnings)
+
+	var output bytes.Buffer
+	writeAuditLog(&output, entries)
+	is.Contains(output.String(), "2026-10-17T06:00:00Z  20261017T060000Z-0001  run started  config "+entries[0].ConfigHash[:12]+"\n")
+	is.Contains(output.String(), "manifest "+entries[1].ManifestHash[:12]+"  failed, 1 buckets failed")
+}
+
+func TestHashJsonConfig(t *testing.T) {
+	is := assert.New(t)
+	config := Config{Buckets: []BucketToProcess{{Name: "test-matt-media", Type: "media"}}}
+	before, err := hashJson(config)
+	is.NoError(err)
+	config.Buckets[0].Type = "photo"
+	after, err := hashJson(config)
+	is.NoError(err)
+	is.NotEqual(before, after, "Should hash every setting")
+}
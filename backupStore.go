@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// BackupStore is a bucket of backups, what validation, sampling and downloads list and read, so other storage providers
+// can be plugged in beside cloud storage and the checks tested without it. Every provider describes its buckets and objects
+// with the cloud storage types, filling in what it has.
+type BackupStore interface {
+	BucketName() string
+	Attrs(ctx context.Context) (*storage.BucketAttrs, error)
+	Objects(ctx context.Context, q *storage.Query) objectIterator
+	Object(name string) BackupObject
+}
+
+// BackupObject is one object in a BackupStore. Generation picks a version of it, for providers that keep them.
+type BackupObject interface {
+	Attrs(ctx context.Context) (*storage.ObjectAttrs, error)
+	Generation(gen int64) BackupObject
+	NewReader(ctx context.Context) (io.ReadCloser, error)
+	NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+}
+
+// openBackupStore is the bucket on its provider, on the client's connection for cloud storage buckets,
+// or the last one the credential refresher in ctx made, see credentialRefresher.bucket.
+// When ctx has a listing snapshot, the bucket's recorded listing is opened instead and client can be nil.
+func openBackupStore(ctx context.Context, client *storage.Client, config Config, bucketConfig BucketToProcess) (BackupStore, error) {
+	if replayed := listingSnapshotFromContext(ctx); replayed != nil {
+		return replayed.bucket(bucketConfig.Name)
+	}
+	switch bucketConfig.Provider {
+	case "", "gs":
+		if client == nil {
+			return nil, errors.NotValidf("Bucket %s on google cloud storage without a connection to it", bucketConfig.Name)
+		}
+		return credentialRefresherFromContext(ctx).bucket(client, bucketConfig.Name), nil
+	case "s3":
+		return newS3Store(config, bucketConfig.Name, os.Getenv)
+	}
+	return nil, errors.NotSupportedf("Storage provider %s of bucket %s", bucketConfig.Provider, bucketConfig.Name)
+}
+
+// usesGCS is whether any of the buckets is on google cloud storage, the provider of buckets that don't name one.
+func usesGCS(buckets []BucketToProcess) bool {
+	for _, bucketConfig := range buckets {
+		if bucketConfig.Provider == "" || bucketConfig.Provider == "gs" {
+			return true
+		}
+	}
+	return false
+}
+
+// openBackupStoreByName is openBackupStore for a bucket named in a mapping or plan, a cloud storage bucket if it isn't in the config.
+func openBackupStoreByName(ctx context.Context, client *storage.Client, config Config, bucketName string) (BackupStore, error) {
+	bucketConfig, err := getBucketConfigFromNameAndConfig(bucketName, config.Buckets)
+	if err != nil {
+		bucketConfig = BucketToProcess{Name: bucketName}
+	}
+	return openBackupStore(ctx, client, config, bucketConfig)
+}
+
+// gcsStore is a Google Cloud Storage bucket.
+type gcsStore struct {
+	bucket *storage.BucketHandle
+}
+
+func newGCSStore(bucket *storage.BucketHandle) BackupStore {
+	return gcsStore{bucket}
+}
+
+func (s gcsStore) BucketName() string {
+	return s.bucket.BucketName()
+}
+
+func (s gcsStore) Attrs(ctx context.Context) (*storage.BucketAttrs, error) {
+	return s.bucket.Attrs(ctx)
+}
+
+func (s gcsStore) Objects(ctx context.Context, q *storage.Query) objectIterator {
+	return s.bucket.Objects(ctx, q)
+}
+
+func (s gcsStore) Object(name string) BackupObject {
+	return gcsObject{s.bucket.Object(name)}
+}
+
+// gcsObject is an object in a Google Cloud Storage bucket.
+type gcsObject struct {
+	obj *storage.ObjectHandle
+}
+
+func (o gcsObject) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	return o.obj.Attrs(ctx)
+}
+
+func (o gcsObject) Generation(gen int64) BackupObject {
+	return gcsObject{o.obj.Generation(gen)}
+}
+
+func (o gcsObject) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	return o.obj.NewReader(ctx)
+}
+
+func (o gcsObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	return o.obj.NewRangeReader(ctx, offset, length)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore is a BackupStore of objects held in memory, for testing validation and downloads without a storage server.
+type memoryStore struct {
+	name     string
+	created  map[string]time.Time
+	contents map[string][]byte
+}
+
+func newMemoryStore(name string) *memoryStore {
+	return &memoryStore{name: name, created: make(map[string]time.Time), contents: make(map[string][]byte)}
+}
+
+func (s *memoryStore) put(name string, content []byte, created time.Time) {
+	s.contents[name], s.created[name] = content, created
+}
+
+func (s *memoryStore) attrs(name string) *storage.ObjectAttrs {
+	content := s.contents[name]
+	return &storage.ObjectAttrs{Bucket: s.name, Name: name, Size: int64(len(content)), Created: s.created[name], Updated: s.created[name],
+		CRC32C: crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)), Generation: 1}
+}
+
+func (s *memoryStore) BucketName() string {
+	return s.name
+}
+
+func (s *memoryStore) Attrs(ctx context.Context) (*storage.BucketAttrs, error) {
+	return &storage.BucketAttrs{Name: s.name}, nil
+}
+
+func (s *memoryStore) Objects(ctx context.Context, q *storage.Query) objectIterator {
+	it := &inventoryReportIterator{prefixesSeen: make(map[string]bool)}
+	if q != nil {
+		it.query = *q
+	}
+	for name := range s.contents {
+		it.objects = append(it.objects, s.attrs(name))
+	}
+	sort.Slice(it.objects, func(i, j int) bool { return it.objects[i].Name < it.objects[j].Name })
+	return it
+}
+
+func (s *memoryStore) Object(name string) BackupObject {
+	return memoryObject{s, name}
+}
+
+type memoryObject struct {
+	store *memoryStore
+	name  string
+}
+
+func (o memoryObject) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	if _, found := o.store.contents[o.name]; !found {
+		return nil, storage.ErrObjectNotExist
+	}
+	return o.store.attrs(o.name), nil
+}
+
+func (o memoryObject) Generation(gen int64) BackupObject {
+	return o
+}
+
+func (o memoryObject) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	return o.NewRangeReader(ctx, 0, -1)
+}
+
+func (o memoryObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	content, found := o.store.contents[o.name]
+	if !found {
+		return nil, storage.ErrObjectNotExist
+	}
+	content = content[offset:]
+	if length >= 0 && length < int64(len(content)) {
+		content = content[:length]
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func TestValidateServerBackupsFromMemoryStore(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	rules := ServerFileValidationRules{OldestFileMaxAgeInDays: 30, NewestFileMaxAgeInDays: 2}
+	store := newMemoryStore("test-matt-server-backups")
+	store.put("db/2026-10-01.dump", []byte("older"), now.AddDate(0, 0, -10))
+	store.put("db/2026-10-10.dump", []byte("newest"), now.AddDate(0, 0, -1))
+	is.NoError(validateServerBackups(ctx, store, rules, nil, nil, nil))
+
+	store.put("db/2019-01-01.dump", []byte("forgotten"), now.AddDate(-7, 0, 0))
+	err := validateServerBackups(ctx, store, rules, nil, nil, nil)
+	is.True(errors.IsNotValid(err), "Should fail on the forgotten backup")
+	is.Contains(err.Error(), "db/2019-01-01.dump")
+
+	matcher, err := newObjectNameMatcher(ObjectNameFilter{NamePattern: `\.tar\.gz$`})
+	is.NoError(err)
+	err = validateNewestServerBackup(ctx, store, rules, matcher)
+	is.True(errors.IsNotFound(err), "Should fail when the filter matches no backups: %v", err)
+	is.True(errors.IsNotFound(validateNewestServerBackup(ctx, newMemoryStore("test-matt-empty"), rules, nil)),
+		"Should fail on an empty bucket")
+}
+
+func TestDownloadFileFromMemoryStore(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	store := newMemoryStore("test-matt-server-backups")
+	store.put("db/nightly.dump", []byte("backup data"), time.Now())
+	localFilePath := filepath.Join(t.TempDir(), "nightly.dump")
+
+	is.NoError(downloadFile(ctx, store, "db/nightly.dump", localFilePath))
+	contents, err := os.ReadFile(localFilePath)
+	is.NoError(err)
+	is.Equal("backup data", string(contents))
+	is.True(errors.IsAlreadyExists(downloadFile(ctx, store, "db/nightly.dump", localFilePath)), "Should skip a verified download")
+	is.True(errors.IsNotFound(downloadFile(ctx, store, "db/missing.dump", localFilePath)))
+}
+
+func TestGCSStore(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	client, _ := newTestStorageServer(t, "test-matt-server-backups", map[string][]byte{"backup.tar.gz": []byte("backup data")})
+	store := newGCSStore(client.Bucket("test-matt-server-backups"))
+	is.Equal("test-matt-server-backups", store.BucketName())
+
+	attrs, err := store.Objects(ctx, nil).Next()
+	is.NoError(err)
+	is.Equal("backup.tar.gz", attrs.Name)
+	rc, err := store.Object("backup.tar.gz").Generation(attrs.Generation).NewRangeReader(ctx, 7, -1)
+	is.NoError(err)
+	defer rc.Close()
+	contents, err := io.ReadAll(rc)
+	is.NoError(err)
+	is.Equal("data", string(contents))
+}
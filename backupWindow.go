@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// maxOutsideWindowNamed is how many objects uploaded outside the backup window are named in the error, the rest are counted.
+const maxOutsideWindowNamed = 5
+
+// clockWindow is a BackupWindow parsed into minutes past midnight in its time zone. It wraps past midnight when end is before start.
+type clockWindow struct {
+	start, end int
+	location   *time.Location
+}
+
+// parseBackupWindow parses the window, found is false when it isn't set.
+func parseBackupWindow(window BackupWindow) (parsed clockWindow, found bool, err error) {
+	if len(window.Start) == 0 && len(window.End) == 0 {
+		return
+	}
+	start, err := time.Parse("15:04", window.Start)
+	if err != nil {
+		return parsed, false, errors.NotValidf("backup window start %q, it should be like 02:00", window.Start)
+	}
+	end, err := time.Parse("15:04", window.End)
+	if err != nil {
+		return parsed, false, errors.NotValidf("backup window end %q, it should be like 05:00", window.End)
+	}
+	if start.Equal(end) {
+		return parsed, false, errors.NotValidf("backup window %s-%s, it is empty", window.Start, window.End)
+	}
+	location := time.UTC
+	if len(window.Timezone) > 0 {
+		location, err = time.LoadLocation(window.Timezone)
+		if err != nil {
+			return parsed, false, errors.Annotatef(err, "Unknown backup window time zone %s", window.Timezone)
+		}
+	}
+	return clockWindow{start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), location}, true, nil
+}
+
+// contains says whether t falls inside the window on whichever day it is, start included and end not.
+func (w clockWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// validateBackupWindow checks every object accepted by matcher and created within the last recentDays days,
+// all of them if recentDays is 0, was created inside the backup window. Uploads at odd hours usually mean
+// the backed up machine's clock or scheduler is broken, even if the backups themselves look fine.
+// With a freshness window in ctx only the backups named within the last recentDays days are listed.
+func validateBackupWindow(ctx context.Context, bucket BackupStore, window clockWindow, recentDays int,
+	matcher *objectNameMatcher, now time.Time) (err error) {
+	var outside []string
+	count := 0
+	q := getRecentlyNamedObjectsQuery(ctx, recentDays, now)
+	it := listObjects(ctx, bucket, q)
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			if q == nil {
+				markInventoryComplete(ctx)
+			}
+			break
+		}
+		if err2 != nil {
+			return errors.Annotate(err2, "Unable to list backups")
+		}
+		countObjectExamined(ctx, objAttrs)
+		if !matcher.matches(objAttrs.Name) || (recentDays > 0 && now.Sub(objAttrs.Created) >= time.Duration(recentDays)*24*time.Hour) {
+			continue
+		}
+		if window.contains(objAttrs.Created) {
+			continue
+		}
+		count++
+		if len(outside) < maxOutsideWindowNamed {
+			outside = append(outside, fmt.Sprintf("%s was created at %s", objAttrs.Name,
+				objAttrs.Created.In(window.location).Format("2006-01-02 15:04 MST")))
+		}
+	}
+	if count > len(outside) {
+		outside = append(outside, fmt.Sprintf("and %d more", count-len(outside)))
+	}
+	if count > 0 {
+		return errors.NotValidf("Objects uploaded outside the backup window (%s). Check the clocks and schedulers of the backed up machines",
+			strings.Join(outside, "; "))
+	}
+	return nil
+}
+
+// validateBucketBackupWindow checks the bucket's recent uploads happened inside its backup_window, and does nothing
+// for buckets without one. Only objects the bucket's freshness filter accepts are checked, going back recent_days,
+// which defaults to newest_file_max_age_in_days so objects that old stop failing the bucket once a broken clock is fixed.
+func validateBucketBackupWindow(ctx context.Context, bucket BackupStore, bucketConfig BucketToProcess,
+	rules ServerFileValidationRules, now time.Time) (err error) {
+	window, found, err := parseBackupWindow(bucketConfig.BackupWindow)
+	if err != nil || !found {
+		return
+	}
+	matcher, err := newObjectNameMatcher(bucketConfig.FreshnessFilter)
+	if err != nil {
+		return errors.Annotate(err, "Invalid freshness filter")
+	}
+	recentDays := bucketConfig.BackupWindow.RecentDays
+	if recentDays == 0 {
+		recentDays = rules.NewestFileMaxAgeInDays
+	}
+	return validateBackupWindow(ctx, bucket, window, recentDays, matcher, now)
+}
+
+// isInsideBackupWindow says whether t is inside the backup window of the named bucket, false when it has none.
+func isInsideBackupWindow(bucketName string, configs []BucketToProcess, t time.Time) bool {
+	bucketConfig, err := getBucketConfigFromNameAndConfig(bucketName, configs)
+	if err != nil {
+		return false
+	}
+	window, found, err := parseBackupWindow(bucketConfig.BackupWindow)
+	return err == nil && found && window.contains(t)
+}
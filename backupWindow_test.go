@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testBackupWindowNow = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+var testParseBackupWindowCases = []struct {
+	window   BackupWindow
+	at       time.Time
+	inside   bool
+	expected string
+}{
+	{BackupWindow{Start: "02:00", End: "05:00"}, time.Date(2026, 10, 16, 2, 0, 0, 0, time.UTC), true, ""},
+	{BackupWindow{Start: "02:00", End: "05:00"}, time.Date(2026, 10, 16, 5, 0, 0, 0, time.UTC), false, ""},
+	//wraps past midnight
+	{BackupWindow{Start: "22:00", End: "02:00"}, time.Date(2026, 10, 16, 23, 30, 0, 0, time.UTC), true, ""},
+	{BackupWindow{Start: "22:00", End: "02:00"}, time.Date(2026, 10, 16, 1, 59, 0, 0, time.UTC), true, ""},
+	{BackupWindow{Start: "22:00", End: "02:00"}, time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), false, ""},
+	{BackupWindow{Start: "02:00", End: "05:00", Timezone: "America/New_York"}, time.Date(2026, 10, 16, 7, 0, 0, 0, time.UTC), true, ""},
+	{BackupWindow{Start: "2am", End: "05:00"}, time.Time{}, false, "start"},
+	{BackupWindow{Start: "02:00"}, time.Time{}, false, "end"},
+	{BackupWindow{Start: "02:00", End: "02:00"}, time.Time{}, false, "empty"},
+	{BackupWindow{Start: "02:00", End: "05:00", Timezone: "Nowhere/Special"}, time.Time{}, false, "time zone"},
+}
+
+func TestParseBackupWindow(t *testing.T) {
+	is := assert.New(t)
+	_, found, err := parseBackupWindow(BackupWindow{})
+	is.NoError(err)
+	is.False(found, "Should be off when not set")
+	for _, tc := range testParseBackupWindowCases {
+		window, found, err := parseBackupWindow(tc.window)
+		if len(tc.expected) > 0 {
+			is.ErrorContains(err, tc.expected)
+			continue
+		}
+		is.NoError(err)
+		is.True(found)
+		is.Equal(tc.inside, window.contains(tc.at), "%v at %v", tc.window, tc.at)
+	}
+}
+
+func TestValidateBackupWindow(t *testing.T) {
+	is := assert.New(t)
+	night := time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+	ctx := withInventoryReport(context.Background(), &inventoryReport{objects: []*storage.ObjectAttrs{
+		{Name: "host-a/2026-10-16.tar.gz", Created: night},
+		{Name: "host-a/2026-10-15.tar.gz", Created: night.AddDate(0, 0, -1).Add(11 * time.Hour)},
+		{Name: "host-a/2026-09-01.tar.gz", Created: night.AddDate(0, -1, 0).Add(11 * time.Hour)},
+		{Name: "host-a/README", Created: testBackupWindowNow},
+	}})
+	matcher, err := newObjectNameMatcher(ObjectNameFilter{ExcludePatterns: []string{"README$"}})
+	is.NoError(err)
+	window, _, err := parseBackupWindow(BackupWindow{Start: "02:00", End: "05:00"})
+	is.NoError(err)
+
+	err = validateBackupWindow(ctx, nil, window, 7, matcher, testBackupWindowNow)
+	is.True(errors.IsNotValid(err))
+	is.ErrorContains(err, "host-a/2026-10-15.tar.gz was created at 2026-10-15 14:00 UTC")
+	is.NotContains(err.Error(), "2026-09-01", "Should only check recent objects")
+
+	err = validateBackupWindow(ctx, nil, window, 0, matcher, testBackupWindowNow)
+	is.ErrorContains(err, "2026-09-01", "Should check every object without recent days")
+
+	err = validateBackupWindow(ctx, nil, window, 1, matcher, testBackupWindowNow)
+	is.NoError(err)
+
+	bs := &BucketSummary{}
+	ctx, err = withFreshnessWindow(withBucketSummary(ctx, bs), FreshnessWindow{NameLayout: "host-a/2006-01-02"}, ServerFileValidationRules{})
+	is.NoError(err)
+	err = validateBackupWindow(ctx, nil, window, 7, matcher, testBackupWindowNow)
+	is.ErrorContains(err, "host-a/2026-10-15.tar.gz")
+	is.Equal(3, bs.ObjectsExamined, "Should only list the backups named within recent days")
+	is.False(bs.Inventory.Complete, "Should not count a windowed listing as the whole bucket")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// envSMTPPassword holds the password emails are sent with, so it stays out of the config file.
+const envSMTPPassword = "VALIDATEBACKUPS_SMTP_PASSWORD"
+
+// statuses of a group's buckets in a run, see getGroupStatus
+const (
+	groupPassed     = "passed"
+	groupFailed     = "failed"
+	groupIncomplete = "incomplete"
+)
+
+// mailSender sends an email, like smtp.SendMail.
+type mailSender func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+
+// getBucketGroups lists the configured bucket groups, then one more, with a blank name, of the buckets in no group
+// and the top level notification settings.
+func getBucketGroups(config Config) []BucketGroup {
+	grouped := make(map[string]bool)
+	for _, group := range config.BucketGroups {
+		for _, name := range group.Buckets {
+			grouped[name] = true
+		}
+	}
+	rest := BucketGroup{Email: config.Email, IssueTracker: config.IssueTracker, Alerting: config.Alerting}
+	for _, bucketConfig := range config.Buckets {
+		if !grouped[bucketConfig.Name] {
+			rest.Buckets = append(rest.Buckets, bucketConfig.Name)
+		}
+	}
+	return append(slices.Clone(config.BucketGroups), rest)
+}
+
+// validateBucketGroups checks every group has a name of its own, and only names configured buckets that are in no other group.
+func validateBucketGroups(config Config) error {
+	groupOf := make(map[string]string)
+	names := make(map[string]bool)
+	for _, group := range config.BucketGroups {
+		if len(group.Name) == 0 || names[group.Name] {
+			return errors.NotValidf("Bucket group name %q, every group needs a name of its own", group.Name)
+		}
+		names[group.Name] = true
+		err := validateRedaction(group.Redaction)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid bucket group %s", group.Name)
+		}
+		for _, name := range group.Buckets {
+			if _, err := getBucketConfigFromNameAndConfig(name, config.Buckets); err != nil {
+				return errors.NotFoundf("Bucket %s of bucket group %s in buckets", name, group.Name)
+			}
+			if other, found := groupOf[name]; found {
+				return errors.NotValidf("Bucket %s in both bucket groups %s and %s", name, other, group.Name)
+			}
+			groupOf[name] = group.Name
+		}
+	}
+	return nil
+}
+
+// forGroup is the part of the run summary about the group's buckets. Warnings and api calls of the run as a whole
+// only go to the group of buckets in no group, warnings can name anyone's buckets.
+func (rs *RunSummary) forGroup(group BucketGroup) *RunSummary {
+	subset := &RunSummary{Generated: rs.Generated}
+	for _, bs := range rs.Buckets {
+		if slices.Contains(group.Buckets, bs.BucketName) {
+			subset.Buckets = append(subset.Buckets, bs)
+		}
+	}
+	if len(group.Name) == 0 {
+		subset.Warnings = slices.Clone(rs.Warnings)
+		subset.APICalls = rs.APICalls
+	}
+	return subset
+}
+
+// reportForGroup is what the group is sent about the run, its part of the summary redacted as it asks.
+func (rs *RunSummary) reportForGroup(group BucketGroup) *RunSummary {
+	subset := rs.forGroup(group)
+	if group.Redaction == redactionNames {
+		return redactRunSummary(subset)
+	}
+	return subset
+}
+
+// getGroupStatus is failed when any of the group's buckets failed validation, and incomplete when the run stopped
+// before validating them all.
+func getGroupStatus(rs *RunSummary, group BucketGroup, stopped bool) string {
+	subset := rs.forGroup(group)
+	status := groupPassed
+	if stopped && len(subset.Buckets) < len(group.Buckets) {
+		status = groupIncomplete
+	}
+	for _, bs := range subset.Buckets {
+		switch {
+		case bs.ValidationResult == validationFailed || len(bs.SignatureFailures) > 0 || bs.FilesFailed > 0:
+			return groupFailed
+		case bs.ValidationResult == validationNotRun && stopped:
+			status = groupIncomplete
+		}
+	}
+	return status
+}
+
+// notifyGroupsOfFailures files issues and sends alerts about each group's failing buckets with the group's own settings.
+// Problems doing so are warnings on the run summary.
+func notifyGroupsOfFailures(ctx context.Context, config Config, rs *RunSummary, getenv func(string) string) {
+	for _, group := range getBucketGroups(config) {
+		groupConfig := config
+		groupConfig.IssueTracker, groupConfig.Alerting = group.IssueTracker, group.Alerting
+		subset := rs.reportForGroup(group)
+		warnings := len(subset.Warnings)
+		reportFailuresToIssueTracker(ctx, groupConfig, subset, getenv)
+		alertOnFailures(ctx, groupConfig, subset, getenv)
+		rs.Warnings = append(rs.Warnings, subset.Warnings[warnings:]...)
+	}
+}
+
+// reportToGroups saves each group's summary to its summary file, and emails it to the group. stopped is set when the run
+// stopped early. Problems are printed, by now the summary has been written.
+func reportToGroups(config Config, rs *RunSummary, format string, stopped bool, getenv func(string) string, send mailSender) {
+	for _, group := range getBucketGroups(config) {
+		if len(group.Buckets) == 0 {
+			continue
+		}
+		subset := rs.reportForGroup(group)
+		if len(group.SummaryFile) > 0 {
+			err := saveSummaryFile(group.SummaryFile, subset, format)
+			if err != nil {
+				fmt.Fprintln(console.stdout, "Warning: unable to save summary of bucket group "+group.Name+".", err)
+			}
+		}
+		status := getGroupStatus(rs, group, stopped)
+		if len(group.Email.To) == 0 || (group.Email.OnlyFailures && status == groupPassed) {
+			continue
+		}
+		err := sendGroupEmail(group, subset, status, getenv, send, time.Now())
+		if err != nil {
+			fmt.Fprintln(console.stdout, "Warning: unable to email summary of bucket group "+group.Name+".", err)
+		}
+	}
+}
+
+// sendGroupEmail mails the group's summary table, with its status in the subject.
+func sendGroupEmail(group BucketGroup, rs *RunSummary, status string, getenv func(string) string, send mailSender, now time.Time) error {
+	subject := "Backups " + status
+	if len(group.Name) > 0 {
+		subject = fmt.Sprintf("Backups of %s %s", group.Name, status)
+	}
+	var body bytes.Buffer
+	err := writeSummary(&body, rs, "table")
+	if err != nil {
+		return err
+	}
+	return sendEmail(group.Email, subject, body.Bytes(), getenv, send, now)
+}
+
+// sendEmail mails body as plain text through the email settings.
+func sendEmail(email EmailNotification, subject string, body []byte, getenv func(string) string, send mailSender, now time.Time) error {
+	if len(email.SMTPServer) == 0 || len(email.From) == 0 {
+		return errors.NotValidf("Email without smtp_server and from")
+	}
+	var auth smtp.Auth
+	if len(email.Username) > 0 {
+		host, _, _ := strings.Cut(email.SMTPServer, ":")
+		auth = smtp.PlainAuth("", email.Username, getenv(envSMTPPassword), host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n",
+		email.From, strings.Join(email.To, ", "), subject, now.Format(time.RFC1123Z))
+	msg.Write(body)
+	return errors.Annotatef(send(email.SMTPServer, auth, email.From, email.To, msg.Bytes()), "Unable to send email through %s", email.SMTPServer)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testBucketGroupsConfig = Config{
+	Email: EmailNotification{SMTPServer: "smtp.example.com:587", From: "backups@example.com", To: []string{"matt@example.com"}},
+	Buckets: []BucketToProcess{
+		{Name: "test-matt-media", Type: "media"},
+		{Name: "test-matt-server-backups", Type: "server-backup"},
+		{Name: "test-parents-photos", Type: "photo"},
+	},
+	BucketGroups: []BucketGroup{{Name: "parents", Buckets: []string{"test-parents-photos"},
+		Email: EmailNotification{SMTPServer: "smtp.example.com:587", From: "backups@example.com", To: []string{"mum@example.com", "dad@example.com"}}}},
+}
+
+func getTestGroupRunSummary() *RunSummary {
+	summary := getTestRunSummary()
+	summary.bucket("test-parents-photos", "photo").ValidationResult = validationPassed
+	summary.warn("stale in progress file")
+	return summary
+}
+
+func TestGetBucketGroups(t *testing.T) {
+	is := assert.New(t)
+	groups := getBucketGroups(testBucketGroupsConfig)
+	is.Len(groups, 2)
+	is.Equal("parents", groups[0].Name)
+	is.Equal("", groups[1].Name)
+	is.Equal([]string{"test-matt-media", "test-matt-server-backups"}, groups[1].Buckets, "Should put the buckets in no group last")
+	is.Equal(testBucketGroupsConfig.Email, groups[1].Email, "Should use the top level settings for buckets in no group")
+}
+
+var testValidateBucketGroupsCases = []struct {
+	groups   []BucketGroup
+	expected func(error) bool
+}{
+	{nil, func(err error) bool { return err == nil }},
+	{[]BucketGroup{{Name: "parents", Buckets: []string{"test-parents-photos"}}}, func(err error) bool { return err == nil }},
+	{[]BucketGroup{{Buckets: []string{"test-parents-photos"}}}, errors.IsNotValid},
+	{[]BucketGroup{{Name: "parents"}, {Name: "parents"}}, errors.IsNotValid},
+	{[]BucketGroup{{Name: "parents", Buckets: []string{"not-a-bucket"}}}, errors.IsNotFound},
+	{[]BucketGroup{{Name: "parents", Buckets: []string{"test-parents-photos"}}, {Name: "sister", Buckets: []string{"test-parents-photos"}}},
+		errors.IsNotValid},
+}
+
+func TestValidateBucketGroups(t *testing.T) {
+	is := assert.New(t)
+	for i, tc := range testValidateBucketGroupsCases {
+		config := testBucketGroupsConfig
+		config.BucketGroups = tc.groups
+		err := validateBucketGroups(config)
+		is.True(tc.expected(err), "case %d: %v", i, err)
+	}
+}
+
+func TestRunSummaryForGroup(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestGroupRunSummary()
+	groups := getBucketGroups(testBucketGroupsConfig)
+
+	parents := summary.forGroup(groups[0])
+	is.Len(parents.Buckets, 1)
+	is.Equal("test-parents-photos", parents.Buckets[0].BucketName)
+	is.Empty(parents.Warnings, "Should keep warnings about the whole run from other groups")
+	rest := summary.forGroup(groups[1])
+	is.Len(rest.Buckets, 2)
+	is.Equal([]string{"stale in progress file"}, rest.Warnings)
+}
+
+func TestGetGroupStatus(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestGroupRunSummary()
+	groups := getBucketGroups(testBucketGroupsConfig)
+	is.Equal(groupPassed, getGroupStatus(summary, groups[0], false))
+	is.Equal(groupFailed, getGroupStatus(summary, groups[1], false))
+
+	stopped := getTestRunSummary()
+	is.Equal(groupIncomplete, getGroupStatus(stopped, groups[0], true), "Should be incomplete when the run stopped before the group's buckets")
+	stopped.Buckets[0].FilesFailed = 1
+	is.Equal(groupFailed, getGroupStatus(stopped, BucketGroup{Buckets: []string{"test-matt-media"}}, true))
+}
+
+func TestNotifyGroupsOfFailures(t *testing.T) {
+	is := assert.New(t)
+	config := testBucketGroupsConfig
+	config.BucketGroups = []BucketGroup{{Name: "parents", Buckets: []string{"test-parents-photos"}, Alerting: Alerting{Kind: "pager"}}}
+	summary := getTestGroupRunSummary()
+	notifyGroupsOfFailures(context.Background(), config, summary, func(string) string { return "" })
+	is.Len(summary.Warnings, 2, "Should warn on the whole run about problems notifying a group")
+	is.Contains(summary.Warnings[1], "unable to send alerts")
+}
+
+func TestReportToGroups(t *testing.T) {
+	is := assert.New(t)
+	config := testBucketGroupsConfig
+	config.BucketGroups = append([]BucketGroup{}, config.BucketGroups...)
+	config.BucketGroups[0].SummaryFile = filepath.Join(t.TempDir(), "parents.md")
+	config.Email.OnlyFailures = true
+	type sentMail struct {
+		to  []string
+		msg string
+	}
+	var sent []sentMail
+	send := func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		is.Equal("smtp.example.com:587", addr)
+		is.Nil(auth, "Should not log in without a username")
+		sent = append(sent, sentMail{to, string(msg)})
+		return nil
+	}
+
+	reportToGroups(config, getTestGroupRunSummary(), "table", false, func(string) string { return "" }, send)
+	is.Len(sent, 2)
+	is.Equal([]string{"mum@example.com", "dad@example.com"}, sent[0].to)
+	is.Contains(sent[0].msg, "Subject: Backups of parents passed\r\n")
+	is.Contains(sent[0].msg, "test-parents-photos")
+	is.NotContains(sent[0].msg, "test-matt-server-backups", "Should only report the group's own buckets")
+	is.NotContains(sent[0].msg, "stale in progress file")
+	is.Contains(sent[1].msg, "Subject: Backups failed\r\n")
+	saved, err := os.ReadFile(config.BucketGroups[0].SummaryFile)
+	is.NoError(err)
+	is.True(strings.HasPrefix(string(saved), "| Bucket |"), "Should save markdown to a .md summary file")
+
+	//the only failure is in a bucket in no group, which only emails on failures
+	sent = nil
+	summary := getTestGroupRunSummary()
+	summary.Buckets[1].ValidationResult = validationPassed
+	reportToGroups(config, summary, "table", false, func(string) string { return "" }, send)
+	is.Len(sent, 1)
+}
+
+func TestSendGroupEmail(t *testing.T) {
+	is := assert.New(t)
+	group := BucketGroup{Name: "parents", Email: EmailNotification{SMTPServer: "smtp.example.com:587", Username: "backups",
+		From: "backups@example.com", To: []string{"mum@example.com"}}}
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	var sentAuth smtp.Auth
+	send := func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		sentAuth = auth
+		return errors.New("connection refused")
+	}
+	err := sendGroupEmail(group, newRunSummary(), groupFailed, func(string) string { return "secret" }, send, now)
+	is.Error(err)
+	is.Contains(err.Error(), "Unable to send email through smtp.example.com:587")
+	is.NotNil(sentAuth, "Should log in with a username")
+
+	group.Email.From = ""
+	err = sendGroupEmail(group, newRunSummary(), groupFailed, func(string) string { return "" }, send, now)
+	is.True(errors.IsNotValid(err), "Should error without a sender")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// byteSizeUnits are the suffixes parseByteSize understands, in binary units to match formatBytes.
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TIB", 1 << 40}, {"TB", 1 << 40}, {"T", 1 << 40},
+	{"GIB", 1 << 30}, {"GB", 1 << 30}, {"G", 1 << 30},
+	{"MIB", 1 << 20}, {"MB", 1 << 20}, {"M", 1 << 20},
+	{"KIB", 1 << 10}, {"KB", 1 << 10}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize reads sizes like 1536, 500MB, 1.5G or 20GiB. Units are binary, so 1K is 1024 bytes.
+func parseByteSize(value string) (size int64, err error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	parsed, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed < 0 {
+		return 0, errors.NotValidf("Size %q", value)
+	}
+	scaled := parsed * float64(multiplier)
+	//float64(math.MaxInt64) rounds up to 2^63, which doesn't fit
+	if scaled >= math.MaxInt64 {
+		return 0, errors.NotValidf("Size %q, it is too big", value)
+	}
+	return int64(scaled), nil
+}
+
+// byteSizeFlag is a command line flag holding a size in bytes, given in any form parseByteSize understands.
+type byteSizeFlag int64
+
+func (f *byteSizeFlag) String() string {
+	if f == nil || *f == 0 {
+		return ""
+	}
+	return formatBytes(int64(*f))
+}
+
+func (f *byteSizeFlag) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*f = byteSizeFlag(size)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testParseByteSizeCases = []struct {
+	value    string
+	expected int64
+}{
+	{value: "1536", expected: 1536},
+	{value: "10B", expected: 10},
+	{value: "2k", expected: 2048},
+	{value: "1.5 KiB", expected: 1536},
+	{value: "500MB", expected: 500 << 20},
+	{value: "20GiB", expected: 20 << 30},
+	{value: "1.5G", expected: 3 << 29},
+	{value: "2T", expected: 2 << 40},
+	{value: "0", expected: 0},
+	{value: "8388607T", expected: 8388607 << 40},
+}
+
+func TestParseByteSize(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testParseByteSizeCases {
+		actual, err := parseByteSize(tc.value)
+		is.NoError(err, tc.value)
+		is.Equal(tc.expected, actual, tc.value)
+	}
+	for _, value := range []string{"", "big", "-1G", "10XB", "G", "NaN", "nanG", "Inf", "+infT", "-Inf", "8388608T", "9223372036854775808", "1e30"} {
+		_, err := parseByteSize(value)
+		is.Error(err, value)
+	}
+}
+
+func TestByteSizeFlag(t *testing.T) {
+	is := assert.New(t)
+	var size byteSizeFlag
+	is.Equal("", size.String())
+	is.NoError(size.Set("20GiB"))
+	is.Equal(byteSizeFlag(20<<30), size)
+	is.Equal("20.0 GiB", size.String())
+	is.Error(size.Set("lots"))
+	is.Equal(byteSizeFlag(20<<30), size, "Should keep the old value when the new one is invalid")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+const catalogDirectoryName = "catalog"
+
+// kinds of CatalogChange. A rewritten object has a new generation under the same name,
+// an anomaly is an object whose CRC32C or size changed while its generation stayed the same, which storage should never do.
+// Changed is for local copies that no longer match the catalog.
+const (
+	catalogAdded     = "added"
+	catalogRewritten = "rewritten"
+	catalogAnomaly   = "anomaly"
+	catalogChanged   = "changed"
+	catalogRemoved   = "removed"
+)
+
+// catalogSaveEvery is how many objects an update lists between saving the catalog, so an interrupted update of a big bucket
+// only has to list the last few again.
+const catalogSaveEvery = 10000
+
+// CatalogEntry is what the catalog knows about an object. Seen is when the listing pass that last found it started.
+type CatalogEntry struct {
+	Generation int64     `json:"generation"`
+	Size       int64     `json:"size"`
+	CRC32C     uint32    `json:"crc32c"`
+	Updated    time.Time `json:"updated"`
+	Seen       time.Time `json:"seen"`
+}
+
+// ObjectCatalog is every live object of a bucket, under its prefix if it has one, kept up to date by listing it in name order
+// a slice at a time. PassStarted is when the pass now under way started and Cursor the last object name it reached,
+// both blank between passes. Completed is when the last pass got to the end, objects it didn't see had been deleted.
+type ObjectCatalog struct {
+	Bucket      string                  `json:"bucket"`
+	Prefix      string                  `json:"prefix"`
+	Completed   time.Time               `json:"completed"`
+	PassStarted time.Time               `json:"pass_started"`
+	Cursor      string                  `json:"cursor"`
+	Objects     map[string]CatalogEntry `json:"objects"`
+}
+
+// CatalogChange is an object that was added, rewritten or removed since the catalog last listed it,
+// or whose metadata changed when it shouldn't have. When is when a rewritten object's new generation was written.
+type CatalogChange struct {
+	Bucket string
+	Object string
+	Kind   string
+	Detail string
+	When   time.Time
+}
+
+func getCatalogFilePath(config Config, bucketName string) string {
+	return filepath.Join(getStateDirectory(config), catalogDirectoryName, bucketName+".json")
+}
+
+// loadCatalog loads the bucket's catalog, or starts an empty one if it hasn't been built yet.
+// A catalog listed under another prefix is started over.
+func loadCatalog(filePath string, bucketConfig BucketToProcess) (catalog *ObjectCatalog, err error) {
+	catalog = &ObjectCatalog{}
+	contents, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Annotatef(err, "Unable to open catalog file at %s", filePath)
+	}
+	if err == nil {
+		err = json.Unmarshal(contents, catalog)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to parse catalog file at %s", filePath)
+		}
+	}
+	if catalog.Bucket != bucketConfig.Name || catalog.Prefix != bucketConfig.Prefix || catalog.Objects == nil {
+		catalog = &ObjectCatalog{Bucket: bucketConfig.Name, Prefix: bucketConfig.Prefix, Objects: make(map[string]CatalogEntry)}
+	}
+	return catalog, nil
+}
+
+func saveCatalog(filePath string, catalog *ObjectCatalog) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open catalog file %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	return json.NewEncoder(jsonFile).Encode(catalog)
+}
+
+// updateCatalog lists up to maxObjects objects from where the last update stopped, all of them when maxObjects is 0,
+// noting what changed since they were last listed. Once a pass reaches the end of the bucket, objects it didn't see are removed.
+// The first pass only builds the catalog, so adding every object isn't reported. save is called every catalogSaveEvery objects.
+func updateCatalog(ctx context.Context, bucket BackupStore, catalog *ObjectCatalog, maxObjects int, now time.Time,
+	save func() error) (changes []CatalogChange, err error) {
+	if catalog.PassStarted.IsZero() {
+		catalog.PassStarted, catalog.Cursor = now, ""
+	}
+	building := catalog.Completed.IsZero()
+	change := func(name, kind string, when time.Time, format string, args ...interface{}) {
+		if !building {
+			changes = append(changes, CatalogChange{catalog.Bucket, name, kind, fmt.Sprintf(format, args...), when})
+		}
+	}
+	q := &storage.Query{StartOffset: catalog.Cursor}
+	err = q.SetAttrSelection([]string{"Name", "Generation", "Size", "CRC32C", "Updated"})
+	if err != nil {
+		return nil, errors.Annotate(err, "Unable to select object attributes")
+	}
+	it := bucket.Objects(ctx, scopeQuery(withBucketPrefix(ctx, catalog.Prefix), q))
+	listed := 0
+	for maxObjects <= 0 || listed < maxObjects {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			changes = append(changes, finishCatalogPass(catalog, now, building)...)
+			return
+		}
+		if err2 != nil {
+			return changes, errors.Annotatef(err2, "Unable to list bucket %s", catalog.Bucket)
+		}
+		if objAttrs.Name == catalog.Cursor {
+			//the start offset is inclusive
+			continue
+		}
+		entry := CatalogEntry{Generation: objAttrs.Generation, Size: objAttrs.Size, CRC32C: objAttrs.CRC32C, Updated: objAttrs.Updated,
+			Seen: catalog.PassStarted}
+		previous, found := catalog.Objects[objAttrs.Name]
+		switch {
+		case !found:
+			change(objAttrs.Name, catalogAdded, entry.Updated, "%s", formatBytes(entry.Size))
+		case previous.Generation != entry.Generation:
+			change(objAttrs.Name, catalogRewritten, entry.Updated, "generation %d written %s, %s, crc32c %d, was generation %d, %s, crc32c %d",
+				entry.Generation, entry.Updated.Format(time.RFC3339), formatBytes(entry.Size), entry.CRC32C,
+				previous.Generation, formatBytes(previous.Size), previous.CRC32C)
+		case previous.CRC32C != entry.CRC32C || previous.Size != entry.Size:
+			change(objAttrs.Name, catalogAnomaly, time.Time{}, "generation %d is now %s, crc32c %d, was %s, crc32c %d",
+				entry.Generation, formatBytes(entry.Size), entry.CRC32C, formatBytes(previous.Size), previous.CRC32C)
+		}
+		catalog.Objects[objAttrs.Name] = entry
+		catalog.Cursor = objAttrs.Name
+		listed++
+		if listed%catalogSaveEvery == 0 {
+			err = save()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// finishCatalogPass removes the objects the pass that just ended didn't see.
+func finishCatalogPass(catalog *ObjectCatalog, now time.Time, building bool) (changes []CatalogChange) {
+	var removed []string
+	for name, entry := range catalog.Objects {
+		if entry.Seen.Before(catalog.PassStarted) {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		if !building {
+			changes = append(changes, CatalogChange{catalog.Bucket, name, catalogRemoved, formatBytes(catalog.Objects[name].Size), time.Time{}})
+		}
+		delete(catalog.Objects, name)
+	}
+	catalog.Completed, catalog.PassStarted, catalog.Cursor = now, time.Time{}, ""
+	return
+}
+
+// updateCatalogsInConfig advances the catalog of every bucket by up to maxObjects objects each.
+func updateCatalogsInConfig(ctx context.Context, client *storage.Client, config Config, maxObjects int, now time.Time) (
+	changes []CatalogChange, err error) {
+	for _, bucketConfig := range config.Buckets {
+		filePath := getCatalogFilePath(config, bucketConfig.Name)
+		catalog, err := loadCatalog(filePath, bucketConfig)
+		if err != nil {
+			return changes, err
+		}
+		bucket, err := openBackupStore(ctx, client, config, bucketConfig)
+		if err != nil {
+			return changes, err
+		}
+		save := func() error { return saveCatalog(filePath, catalog) }
+		bucketChanges, err := updateCatalog(ctx, bucket, catalog, maxObjects, now, save)
+		changes = append(changes, bucketChanges...)
+		//save what was listed even if the listing failed part way
+		err2 := save()
+		if err != nil {
+			return changes, err
+		}
+		if err2 != nil {
+			return changes, err2
+		}
+	}
+	return
+}
+
+// auditCatalog checks the local copies of cataloged objects still match the catalog, without listing the bucket.
+// A mismatch is either a local file that changed since it was downloaded, or an object that changed since.
+func auditCatalog(ctx context.Context, config Config, catalog *ObjectCatalog) (mismatches []CatalogChange, checked int, err error) {
+	names := make([]string, 0, len(catalog.Objects))
+	for name := range catalog.Objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := catalog.Objects[name]
+		localFile := getLocalFilePath(config.FileDownloadLocation, catalog.Bucket, name, config.SanitizeFileNames)
+		fileInfo, err2 := os.Stat(localFile)
+		if err2 != nil || !fileInfo.Mode().IsRegular() {
+			continue
+		}
+		checked++
+		if fileInfo.Size() != entry.Size {
+			mismatches = append(mismatches, CatalogChange{catalog.Bucket, name, catalogChanged,
+				fmt.Sprintf("local copy is %s, catalog has %s", formatBytes(fileInfo.Size()), formatBytes(entry.Size)), time.Time{}})
+			continue
+		}
+		crc, err2 := getCachedCrc32CFromFile(ctx, localFile, fileInfo)
+		if err2 != nil {
+			return mismatches, checked, err2
+		}
+		if crc != entry.CRC32C {
+			mismatches = append(mismatches, CatalogChange{catalog.Bucket, name, catalogChanged,
+				fmt.Sprintf("local copy has crc32c %d, catalog has %d", crc, entry.CRC32C), time.Time{}})
+		}
+	}
+	return
+}
+
+// warnCatalogChanges warns about objects rewritten under the same name since the catalog last listed them,
+// which a backup job shouldn't do outside the bucket's backup window, and about anomalies, which point at api problems or corrupt metadata.
+// Added and removed objects are the usual comings and goings of backups.
+func warnCatalogChanges(rs *RunSummary, config Config, changes []CatalogChange) {
+	for _, change := range changes {
+		bucketType, _ := getBucketValidationTypeFromNameAndConfig(change.Bucket, config.Buckets)
+		ctx := withBucketSummary(context.Background(), rs.bucket(change.Bucket, bucketType))
+		switch change.Kind {
+		case catalogRewritten:
+			if isInsideBackupWindow(change.Bucket, config.Buckets, change.When) {
+				continue
+			}
+			recordWarning(ctx, "object %s was rewritten since it was cataloged, %s.", change.Object, change.Detail)
+		case catalogAnomaly:
+			recordWarning(ctx, "object %s changed without a new generation, check it hasn't been tampered with, %s.",
+				change.Object, change.Detail)
+		}
+	}
+}
+
+// countCatalogAnomalies counts the changes no object should ever go through.
+func countCatalogAnomalies(changes []CatalogChange) (anomalies int) {
+	for _, change := range changes {
+		if change.Kind == catalogAnomaly {
+			anomalies++
+		}
+	}
+	return
+}
+
+func writeCatalogChanges(w io.Writer, changes []CatalogChange) {
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s: %s %s, %s\n", change.Bucket, change.Kind, change.Object, change.Detail)
+	}
+}
+
+// runCatalog builds and keeps up to date a local catalog of every object in each bucket,
+// for spotting drift between runs and auditing local copies without listing the buckets again.
+func runCatalog(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	maxObjects := flags.Int("max-objects", 0,
+		"list at most this many objects of each bucket, carrying on from there next time, 0 lists them all")
+	return func(ctx context.Context) {
+		if flags.NArg() != 1 || (flags.Arg(0) != "update" && flags.Arg(0) != "audit") {
+			log.Fatal("The catalog command takes update or audit.")
+		}
+		if flags.Arg(0) == "audit" {
+			config, err := loadConfiguration(*configPath, os.Stdin, os.Getenv)
+			logFatalIfErr(err, "Unable to load configuration from file.")
+			hashes, err := openHashCache(config, false)
+			logFatalIfErr(err, "Unable to load hash cache.")
+			ctx = withHashCache(ctx, hashes)
+			defer saveHashCache(config, hashes)
+			failed := false
+			for _, bucketConfig := range config.Buckets {
+				catalog, err := loadCatalog(getCatalogFilePath(config, bucketConfig.Name), bucketConfig)
+				logFatalIfErr(err, fmt.Sprintf("Unable to load catalog of bucket %s.", bucketConfig.Name))
+				mismatches, checked, err := auditCatalog(ctx, config, catalog)
+				logFatalIfErr(err, fmt.Sprintf("Unable to audit bucket %s.", bucketConfig.Name))
+				fmt.Fprintln(console.stdout, fmt.Sprintf("%s: %d objects cataloged, %d local copies checked, %d mismatched.",
+					bucketConfig.Name, len(catalog.Objects), checked, len(mismatches)))
+				writeCatalogChanges(console.stdout, mismatches)
+				failed = failed || len(mismatches) > 0
+			}
+			if failed {
+				saveHashCache(config, hashes)
+				flushTracing()
+				os.Exit(1)
+			}
+			return
+		}
+		config, client := loadConfigAndConnect(ctx, *configPath)
+		changes, err := updateCatalogsInConfig(ctx, client, config, *maxObjects, time.Now().UTC())
+		writeCatalogChanges(console.stdout, changes)
+		logFatalIfErr(err, "Unable to update catalogs.")
+		if len(changes) == 0 {
+			fmt.Fprintln(console.stdout, "No changes.")
+		}
+		if anomalies := countCatalogAnomalies(changes); anomalies > 0 {
+			fmt.Fprintln(console.stdout, fmt.Sprintf("%d objects changed without a new generation.", anomalies))
+			flushTracing()
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCatalog(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	objects := map[string][]byte{
+		"host-a/2026-10-15.tar.gz": []byte("first"),
+		"host-a/2026-10-16.tar.gz": []byte("second"),
+		"host-b/2026-10-16.tar.gz": []byte("third"),
+		"other/skipped.txt":        []byte("not under the prefix"),
+	}
+	client, _ := newTestStorageServer(t, "test-matt-server-backups", objects)
+	bucket := newGCSStore(client.Bucket("test-matt-server-backups"))
+	catalog, err := loadCatalog(filepath.Join(t.TempDir(), "missing.json"), BucketToProcess{Name: "test-matt-server-backups", Prefix: "host-"})
+	is.NoError(err)
+	saves := 0
+	save := func() error { saves++; return nil }
+	first := time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC)
+
+	//the first pass is built two objects at a time and doesn't report every object as added
+	changes, err := updateCatalog(ctx, bucket, catalog, 2, first, save)
+	is.NoError(err)
+	is.Empty(changes)
+	is.Equal("host-a/2026-10-16.tar.gz", catalog.Cursor)
+	is.True(catalog.Completed.IsZero())
+	changes, err = updateCatalog(ctx, bucket, catalog, 2, first.Add(time.Hour), save)
+	is.NoError(err)
+	is.Empty(changes)
+	is.Len(catalog.Objects, 3, "Should only catalog objects under the prefix")
+	is.Equal(first.Add(time.Hour), catalog.Completed)
+	is.Equal("", catalog.Cursor)
+	is.Equal(0, saves, "Should only save part way through big buckets")
+
+	delete(objects, "host-a/2026-10-15.tar.gz")
+	objects["host-a/2026-10-16.tar.gz"] = []byte("overwritten")
+	objects["host-c/2026-10-17.tar.gz"] = []byte("new host")
+	changes, err = updateCatalog(ctx, bucket, catalog, 0, first.Add(24*time.Hour), save)
+	is.NoError(err)
+	var kinds []string
+	for _, change := range changes {
+		kinds = append(kinds, change.Kind+" "+change.Object)
+	}
+	is.Equal([]string{"rewritten host-a/2026-10-16.tar.gz", "added host-c/2026-10-17.tar.gz", "removed host-a/2026-10-15.tar.gz"}, kinds)
+	is.Len(catalog.Objects, 3)
+	is.Equal(int64(len("overwritten")), catalog.Objects["host-a/2026-10-16.tar.gz"].Size)
+
+	//the same generation with a different crc32c or size is an anomaly, not a rewrite
+	entry := catalog.Objects["host-b/2026-10-16.tar.gz"]
+	entry.CRC32C++
+	catalog.Objects["host-b/2026-10-16.tar.gz"] = entry
+	changes, err = updateCatalog(ctx, bucket, catalog, 0, first.Add(48*time.Hour), save)
+	is.NoError(err)
+	is.Len(changes, 1)
+	is.Equal(catalogAnomaly, changes[0].Kind)
+	is.Equal("host-b/2026-10-16.tar.gz", changes[0].Object)
+	is.Equal(1, countCatalogAnomalies(changes))
+}
+
+func TestLoadCatalog(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), catalogDirectoryName, "test-matt-media.json")
+	bucketConfig := BucketToProcess{Name: "test-matt-media"}
+	catalog, err := loadCatalog(filePath, bucketConfig)
+	is.NoError(err)
+	catalog.Objects["show/episode.ogv"] = CatalogEntry{Size: 10, CRC32C: 42}
+	is.NoError(saveCatalog(filePath, catalog))
+
+	loaded, err := loadCatalog(filePath, bucketConfig)
+	is.NoError(err)
+	is.Equal(catalog, loaded)
+	bucketConfig.Prefix = "show/"
+	loaded, err = loadCatalog(filePath, bucketConfig)
+	is.NoError(err)
+	is.Empty(loaded.Objects, "Should start over when the prefix changed")
+
+	is.NoError(os.WriteFile(filePath, []byte("not json"), 0644))
+	_, err = loadCatalog(filePath, bucketConfig)
+	is.Error(err)
+}
+
+func TestAuditCatalog(t *testing.T) {
+	is := assert.New(t)
+	config := Config{FileDownloadLocation: t.TempDir()}
+	write := func(name string, content string) {
+		localFile := getLocalFilePath(config.FileDownloadLocation, "test-matt-photos", name, false)
+		is.NoError(os.MkdirAll(filepath.Dir(localFile), os.ModePerm))
+		is.NoError(os.WriteFile(localFile, []byte(content), 0644))
+	}
+	write("2014-11/IMG_01.gif", "good")
+	write("2014-11/IMG_02.gif", "bad!")
+	write("2014-11/IMG_03.gif", "short")
+	good, _ := getCrc32CFromFile(getLocalFilePath(config.FileDownloadLocation, "test-matt-photos", "2014-11/IMG_01.gif", false))
+	catalog := &ObjectCatalog{Bucket: "test-matt-photos", Objects: map[string]CatalogEntry{
+		"2014-11/IMG_01.gif": {Size: 4, CRC32C: good},
+		"2014-11/IMG_02.gif": {Size: 4, CRC32C: good},
+		"2014-11/IMG_03.gif": {Size: 50},
+		"2014-11/IMG_04.gif": {Size: 4},
+	}}
+
+	mismatches, checked, err := auditCatalog(context.Background(), config, catalog)
+	is.NoError(err)
+	is.Equal(3, checked, "Should only check objects with local copies")
+	is.Len(mismatches, 2)
+	is.Equal("2014-11/IMG_02.gif", mismatches[0].Object)
+	is.Contains(mismatches[1].Detail, "local copy is 5 B")
+
+	var output bytes.Buffer
+	writeCatalogChanges(&output, mismatches)
+	is.Contains(output.String(), "test-matt-photos: changed 2014-11/IMG_02.gif, local copy has crc32c")
+}
+
+func TestWarnCatalogChanges(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	config := Config{Buckets: []BucketToProcess{{Name: "test-matt-server-backups", Type: "server-backup",
+		BackupWindow: BackupWindow{Start: "02:00", End: "05:00"}}}}
+	warnCatalogChanges(summary, config, []CatalogChange{
+		{"test-matt-server-backups", "a.tar.gz", catalogAdded, "1 B", time.Time{}},
+		{"test-matt-server-backups", "inside-window.tar.gz", catalogRewritten, "generation 4", time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)},
+		{"test-matt-server-backups", "b.tar.gz", catalogRewritten, "generation 2", time.Time{}},
+		{"test-matt-server-backups", "c.tar.gz", catalogAnomaly, "generation 3", time.Time{}},
+		{"test-matt-server-backups", "d.tar.gz", catalogRemoved, "1 B", time.Time{}},
+	})
+	is.Equal([]Warning{
+		{Bucket: "test-matt-server-backups", Message: "object b.tar.gz was rewritten since it was cataloged, generation 2."},
+		{Bucket: "test-matt-server-backups", Message: "object c.tar.gz changed without a new generation, check it hasn't been tampered with, generation 3."},
+	}, summary.getWarnings())
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// defaultMaxClockSkew is how far the local clock can be from cloud storage's without max_clock_skew_seconds
+// before freshness results are in doubt.
+const defaultMaxClockSkew = time.Minute
+
+// clockSkew is how far the local clock is ahead of cloud storage's, negative when it is behind, as of connecting.
+// measured is false when the connectivity check was skipped or its response had no Date header, max is the most
+// skew allowed before freshness results are annotated with it.
+type clockSkew struct {
+	measured bool
+	ahead    time.Duration
+	max      time.Duration
+}
+
+// startupClockSkew is the skew measured when connecting to cloud storage, see checkClockSkew.
+var startupClockSkew clockSkew
+
+// measureClockSkew compares the Date header of resp with the local time halfway between sending the request and getting it.
+// The header is only to the second, so the server's time is taken as halfway through that second.
+func measureClockSkew(resp *http.Response, sent, received time.Time) (skew clockSkew) {
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	return clockSkew{measured: true, ahead: local.Sub(serverTime.Add(time.Second / 2))}
+}
+
+// excessive is whether the skew is more than its max.
+func (s clockSkew) excessive() bool {
+	return s.measured && (s.ahead > s.max || -s.ahead > s.max)
+}
+
+func (s clockSkew) String() string {
+	if !s.measured {
+		return "local clock not compared with cloud storage"
+	}
+	if s.ahead < 0 {
+		return fmt.Sprintf("local clock is %s behind cloud storage", (-s.ahead).Round(time.Second))
+	}
+	return fmt.Sprintf("local clock is %s ahead of cloud storage", s.ahead.Round(time.Second))
+}
+
+// getMaxClockSkew is max_clock_skew_seconds from the config, or defaultMaxClockSkew when it isn't set.
+func getMaxClockSkew(config Config) time.Duration {
+	if config.MaxClockSkewSeconds > 0 {
+		return time.Duration(config.MaxClockSkewSeconds) * time.Second
+	}
+	return defaultMaxClockSkew
+}
+
+// checkClockSkew keeps the skew measured when connecting, for annotating freshness results. Skew past the config's max
+// fails with fail_on_clock_skew, a wrong clock makes stale backups look fresh or fresh ones stale, and is printed otherwise.
+func checkClockSkew(skew clockSkew, config Config) error {
+	skew.max = getMaxClockSkew(config)
+	startupClockSkew = skew
+	if !skew.excessive() {
+		return nil
+	}
+	if config.FailOnClockSkew {
+		return errors.NotValidf("Clock, %s, more than %s", skew, skew.max)
+	}
+	fmt.Fprintln(console.stdout, fmt.Sprintf("Warning: %s, more than %s, freshness checks may be wrong. Check the time is synced.", skew, skew.max))
+	return nil
+}
+
+// recordClockSkew notes the skew measured when connecting on the summary, and warns when it is excessive.
+func recordClockSkew(rs *RunSummary) {
+	if rs == nil || !startupClockSkew.measured {
+		return
+	}
+	rs.ClockSkewSeconds = startupClockSkew.ahead.Round(time.Second).Seconds()
+	if startupClockSkew.excessive() {
+		rs.warn("%s, more than %s, freshness checks may be wrong. Check the time is synced.", startupClockSkew, startupClockSkew.max)
+	}
+}
+
+// annotateClockSkew adds the skew to a failed freshness check when it is excessive, as the clock may be why it failed.
+func annotateClockSkew(err error) error {
+	if err == nil || !startupClockSkew.excessive() {
+		return err
+	}
+	return errors.Annotatef(err, "%s", startupClockSkew)
+}
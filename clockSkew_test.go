@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testMeasureClockSkewCases = []struct {
+	date     string
+	measured bool
+	ahead    time.Duration
+}{
+	{"Sat, 17 Oct 2026 06:00:00 GMT", true, 0},
+	{"Sat, 17 Oct 2026 05:55:00 GMT", true, 5 * time.Minute},
+	{"Sat, 17 Oct 2026 06:02:00 GMT", true, -2 * time.Minute},
+	{"", false, 0},
+	{"yesterday", false, 0},
+}
+
+func TestMeasureClockSkew(t *testing.T) {
+	is := assert.New(t)
+	//the request took a second, and the server's time is taken as halfway through the second in its Date header
+	sent := time.Date(2026, time.October, 17, 6, 0, 0, 0, time.UTC)
+	received := sent.Add(time.Second)
+	for _, tc := range testMeasureClockSkewCases {
+		resp := &http.Response{Header: http.Header{}}
+		if len(tc.date) > 0 {
+			resp.Header.Set("Date", tc.date)
+		}
+		skew := measureClockSkew(resp, sent, received)
+		is.Equal(tc.measured, skew.measured, "case %s", tc.date)
+		is.Equal(tc.ahead, skew.ahead, "case %s", tc.date)
+	}
+}
+
+var testClockSkewStringCases = []struct {
+	skew     clockSkew
+	expected string
+}{
+	{clockSkew{}, "local clock not compared with cloud storage"},
+	{clockSkew{measured: true, ahead: 90*time.Second + 200*time.Millisecond}, "local clock is 1m30s ahead of cloud storage"},
+	{clockSkew{measured: true, ahead: -2 * time.Hour}, "local clock is 2h0m0s behind cloud storage"},
+}
+
+func TestClockSkewString(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testClockSkewStringCases {
+		is.Equal(tc.expected, tc.skew.String())
+	}
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	is := assert.New(t)
+	defer func() { startupClockSkew = clockSkew{} }()
+	behind := clockSkew{measured: true, ahead: -10 * time.Minute}
+
+	is.NoError(checkClockSkew(clockSkew{measured: true, ahead: 30 * time.Second}, Config{}))
+	is.False(startupClockSkew.excessive(), "Should allow a minute by default")
+	is.NoError(annotateClockSkew(nil))
+	freshnessErr := errors.NotValidf("Newest file db/nightly.dump was created on 2026-10-14, too long in the past")
+	is.Equal(freshnessErr, annotateClockSkew(freshnessErr), "Should leave freshness results alone when the clock is close enough")
+
+	is.NoError(checkClockSkew(behind, Config{MaxClockSkewSeconds: 900}))
+	is.False(startupClockSkew.excessive())
+
+	is.NoError(checkClockSkew(behind, Config{}), "Should only warn without fail_on_clock_skew")
+	is.True(startupClockSkew.excessive())
+	err := annotateClockSkew(freshnessErr)
+	is.True(errors.IsNotValid(err))
+	is.Equal("local clock is 10m0s behind cloud storage: Newest file db/nightly.dump was created on 2026-10-14, too long in the past not valid",
+		err.Error())
+
+	summary := newRunSummary()
+	recordClockSkew(summary)
+	is.Equal(-600.0, summary.ClockSkewSeconds)
+	is.Len(summary.Warnings, 1)
+	is.Contains(summary.Warnings[0], "local clock is 10m0s behind cloud storage, more than 1m0s")
+
+	err = checkClockSkew(behind, Config{FailOnClockSkew: true})
+	is.True(errors.IsNotValid(err), "Should fail with fail_on_clock_skew")
+
+	is.NoError(checkClockSkew(clockSkew{}, Config{FailOnClockSkew: true}), "Should pass when the clock couldn't be compared")
+	summary = newRunSummary()
+	recordClockSkew(summary)
+	is.Zero(summary.ClockSkewSeconds)
+	is.Empty(summary.Warnings)
+}
+
+func TestCheckClock(t *testing.T) {
+	is := assert.New(t)
+	check := checkClock(clockSkew{measured: true, ahead: 5 * time.Second}, Config{})
+	is.True(check.passed())
+	is.Equal("local clock is 5s ahead of cloud storage", check.Detail)
+	check = checkClock(clockSkew{measured: true, ahead: 5 * time.Minute}, Config{MaxClockSkewSeconds: 120})
+	is.False(check.passed())
+	is.Contains(check.Advice, "NTP")
+}
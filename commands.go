@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const programName = "validatebackups"
+
+// command is one of the things validatebackups can do, the default command has a blank name.
+// define registers the command's flags on the flag set and returns what to run once they are parsed,
+// so help and shell completion can be generated from the same flags the command uses.
+// flagValues lists the values a flag accepts, for completing them.
+// args describes the arguments the command takes after its flags, commands without it take none.
+type command struct {
+	name       string
+	summary    string
+	define     func(flags *flag.FlagSet) (run func(ctx context.Context))
+	flagValues map[string][]string
+	args       string
+}
+
+// commands are listed in the order help shows them.
+var commands = []command{
+	{"", "validate every bucket, then download and check a random sample, resuming an interrupted run", runAll,
+		map[string][]string{"summary-format": {"table", "csv", "json", "markdown"}}, ""},
+	{"plan", "pick the random sample and write it out for review without downloading anything", runPlan,
+		map[string][]string{"format": {"json", "csv"}}, ""},
+	{"download", "download and check exactly the objects listed in a plan", runDownload,
+		map[string][]string{"summary-format": {"table", "csv", "json", "markdown"}}, ""},
+	{"coverage", "report how much of each bucket has been spot checked over all runs", runCoverage,
+		map[string][]string{"format": {"table", "csv"}}, ""},
+	{"newest", "download and verify only the newest backup of each server-backup bucket, a quick daily check", runNewest, nil, ""},
+	{"verify-remote", "check the newest objects' checksums against the backup tool's manifest without downloading them", runVerifyRemote, nil, ""},
+	{"drill", "restore the newest backup of each server-backup bucket with a restore_drill", runDrill, nil, ""},
+	{"compare", "compare the objects of two buckets or prefixes, e.g. before a migration cutover", runCompare,
+		map[string][]string{"format": {"table", "csv"}}, "<source gs://bucket/prefix> <destination gs://bucket/prefix>"},
+	{"catalog", "build and update a local catalog of every object in each bucket, or audit local copies against it offline", runCatalog,
+		nil, "update|audit"},
+	{"report", "diff two runs' json summaries, listing buckets that flipped, got less fresh, shrank or started failing", runReport, nil,
+		"diff <old.json> <new.json>"},
+	{"digest", "summarise the last week of runs, freshness, failures, bytes verified and coverage, for posting or emailing", runDigest,
+		map[string][]string{"format": {"table", "markdown"}}, ""},
+	{"audit", "check the audit log of every run hasn't been edited since, and list the runs in it", runAudit, nil, "verify"},
+	{"config", "print the config as a run would use it, after the environment overrides, defaults and run profile", runConfig, nil,
+		"print-effective"},
+	{"doctor", "check the config, credentials, buckets and local directories, with advice on fixing problems", runDoctor, nil, ""},
+	{"version", "print the version, go version and enabled storage providers", runVersion, nil, ""},
+}
+
+// builtinCommands aren't in commands as they only describe the others.
+var builtinCommands = map[string]string{
+	"help":       "show help for a command",
+	"completion": "print a bash, zsh or fish completion script",
+}
+
+// completeCommand is run by the completion scripts, it isn't listed in help.
+const completeCommand = "__complete"
+
+// configFlagValues complete flags from the config, e.g. --bucket from the configured buckets.
+var configFlagValues = map[string]func(config Config) []string{
+	"bucket":  getConfiguredBucketNames,
+	"profile": getRunProfileNames,
+}
+
+// runCommand runs the command named by the first argument, or the default command, and returns the exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "help":
+			return runHelp(args[1:], stdout, stderr)
+		case "completion":
+			return runCompletion(args[1:], stdout, stderr)
+		case completeCommand:
+			for _, candidate := range getCompletions(args[1:], loadCompletionConfig) {
+				fmt.Fprintln(stdout, candidate)
+			}
+			return 0
+		}
+	}
+	cmd, rest := findCommand(args)
+	flags := newCommandFlagSet(cmd, stderr)
+	run := cmd.define(flags)
+	err := flags.Parse(rest)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 && len(cmd.args) == 0 {
+		fmt.Fprintf(stderr, "Unknown command %s.\n\n", flags.Arg(0))
+		writeCommandList(stderr)
+		return 2
+	}
+	ctx := context.Background()
+	if globalFlags.faultInjection != nil {
+		fmt.Fprintf(stderr, "Warning: injecting faults into downloads, %s.\n", globalFlags.faultInjection)
+		ctx = withFaultInjector(ctx, globalFlags.faultInjection)
+	}
+	err = setupTracing(ctx, os.Getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, "Warning: tracing is off.", err)
+	}
+	defer flushTracing()
+	ctx, span := startSpan(ctx, flags.Name())
+	defer span.End()
+	run(ctx)
+	return 0
+}
+
+// findCommand picks the command named by the first argument, falling back to the default command.
+func findCommand(args []string) (cmd command, rest []string) {
+	if len(args) > 0 {
+		for _, cmd = range commands {
+			if len(cmd.name) > 0 && cmd.name == args[0] {
+				return cmd, args[1:]
+			}
+		}
+	}
+	return commands[0], args
+}
+
+func newCommandFlagSet(cmd command, output io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(strings.TrimSpace(programName+" "+cmd.name), flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.BoolVar(&globalFlags.readOnly, "read-only", os.Getenv(envReadOnly) == "true",
+		"refuse any request that would change cloud storage, as read_only in the config does")
+	globalFlags.faultInjection = nil
+	flags.Func(faultInjectionFlag, "fail, truncate or delay downloads on purpose, e.g. fail=10,truncate=5,delay=2s", func(spec string) (err error) {
+		globalFlags.faultInjection, err = parseFaultInjection(spec)
+		return
+	})
+	flags.Usage = func() {
+		writeCommandHelp(flags.Output(), cmd, flags)
+	}
+	return flags
+}
+
+func runHelp(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		writeCommandList(stdout)
+		return 0
+	}
+	if summary, found := builtinCommands[args[0]]; found {
+		fmt.Fprintf(stdout, "Usage: %s %s\n\n%s.\n", programName, args[0], capitalize(summary))
+		return 0
+	}
+	cmd, rest := findCommand(args)
+	if len(rest) == len(args) {
+		fmt.Fprintf(stderr, "Unknown command %s.\n\n", args[0])
+		writeCommandList(stderr)
+		return 2
+	}
+	flags := newCommandFlagSet(cmd, stdout)
+	cmd.define(flags)
+	writeCommandHelp(stdout, cmd, flags)
+	return 0
+}
+
+// writeCommandList is the top level help, every command with its summary then the default command's flags.
+func writeCommandList(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\n", programName)
+	fmt.Fprintf(w, "With no command, %s.\n\nCommands:\n", commands[0].summary)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands[1:] {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	var builtins []string
+	for name := range builtinCommands {
+		builtins = append(builtins, name)
+	}
+	sort.Strings(builtins)
+	for _, name := range builtins {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, builtinCommands[name])
+	}
+	tw.Flush()
+
+	flags := newCommandFlagSet(commands[0], w)
+	commands[0].define(flags)
+	fmt.Fprintln(w)
+	writeFlagHelp(w, commands[0], flags)
+	fmt.Fprintf(w, "\nRun '%s help <command>' for a command's flags.\n", programName)
+}
+
+func writeCommandHelp(w io.Writer, cmd command, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: %s\n\n%s.\n\n", strings.TrimSpace(flags.Name()+" [flags] "+cmd.args), capitalize(cmd.summary))
+	writeFlagHelp(w, cmd, flags)
+}
+
+// writeFlagHelp lists the flags in alphabetical order, with their accepted values and defaults.
+func writeFlagHelp(w io.Writer, cmd command, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "Flags:")
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == faultInjectionFlag {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		if values := cmd.flagValues[f.Name]; len(values) > 0 {
+			name = strings.Join(values, "|")
+		}
+		if isBoolFlag(f) {
+			fmt.Fprintf(w, "  --%s\n", f.Name)
+		} else {
+			fmt.Fprintf(w, "  --%s %s\n", f.Name, name)
+		}
+		fmt.Fprintf(w, "      %s", usage)
+		switch {
+		case isBoolFlag(f) && f.DefValue == "true":
+			fmt.Fprint(w, " (default true)")
+		case len(f.DefValue) > 0 && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s":
+			fmt.Fprintf(w, " (default %q)", f.DefValue)
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+func capitalize(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindCommand(t *testing.T) {
+	is := assert.New(t)
+	cmd, rest := findCommand([]string{"plan", "--format", "csv"})
+	is.Equal("plan", cmd.name)
+	is.Equal([]string{"--format", "csv"}, rest)
+
+	cmd, rest = findCommand([]string{"--smoke"})
+	is.Equal("", cmd.name, "Should fall back to the default command")
+	is.Equal([]string{"--smoke"}, rest)
+
+	cmd, _ = findCommand(nil)
+	is.Equal("", cmd.name)
+}
+
+func TestRunHelp(t *testing.T) {
+	is := assert.New(t)
+	var stdout, stderr bytes.Buffer
+	is.Equal(0, runHelp(nil, &stdout, &stderr))
+	for _, cmd := range commands[1:] {
+		is.Contains(stdout.String(), cmd.name, "Should list every command")
+	}
+	is.Contains(stdout.String(), "--smoke", "Should list the default command's flags")
+
+	stdout.Reset()
+	is.Equal(0, runHelp([]string{"plan"}, &stdout, &stderr))
+	is.Contains(stdout.String(), "Usage: validatebackups plan [flags]")
+	is.Contains(stdout.String(), "--format json|csv", "Should show the values a flag accepts")
+	is.Contains(stdout.String(), "--max-file-size size")
+	is.NotContains(stdout.String(), "--smoke bool", "Should not show a value for bool flags")
+
+	is.Equal(2, runHelp([]string{"bogus"}, &stdout, &stderr), "Should fail on unknown commands")
+	is.Contains(stderr.String(), "Unknown command bogus")
+}
+
+func TestRunCommandFlagErrors(t *testing.T) {
+	is := assert.New(t)
+	var stdout, stderr bytes.Buffer
+	is.Equal(0, runCommand([]string{"drill", "--help"}, &stdout, &stderr), "Should not run the command for --help")
+	is.Contains(stderr.String(), "Usage: validatebackups drill [flags]")
+
+	stderr.Reset()
+	is.Equal(2, runCommand([]string{"coverage", "--bogus"}, &stdout, &stderr), "Should fail on unknown flags")
+	is.Equal(2, runCommand([]string{"bogus"}, &stdout, &stderr), "Should fail on unknown commands")
+	is.Contains(stderr.String(), "Unknown command bogus")
+
+	stderr.Reset()
+	is.Equal(2, runCommand([]string{"newest", "--fault-injection", "fail=200"}, &stdout, &stderr), "Should fail on a bad fault spec")
+	is.Contains(stderr.String(), "expected 0 to 100")
+	is.NotContains(stderr.String(), "fault-injection fail=", "Should hide fault injection from help")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	companionNamePlaceholder = "{name}"
+	// maxCompanionSize is plenty for a checksum or signature, and stops a misconfigured rule reading a whole backup into memory.
+	maxCompanionSize = 64 * 1024
+)
+
+type compiledCompanionRule struct {
+	CompanionRule
+	pattern *regexp.Regexp
+}
+
+func compileCompanionRules(rules []CompanionRule) (compiled []compiledCompanionRule, err error) {
+	for _, rule := range rules {
+		if !strings.Contains(rule.Companion, companionNamePlaceholder) {
+			return nil, errors.NotValidf("Companion %q, it must contain %s", rule.Companion, companionNamePlaceholder)
+		}
+		if _, err = newCompanionHash(rule.Verify); err != nil {
+			return nil, err
+		}
+		pattern, err2 := regexp.Compile(rule.Pattern)
+		if err2 != nil {
+			return nil, errors.Annotatef(err2, "Unable to parse companion pattern %s", rule.Pattern)
+		}
+		compiled = append(compiled, compiledCompanionRule{CompanionRule: rule, pattern: pattern})
+	}
+	return
+}
+
+func (rule compiledCompanionRule) companionName(objectName string) string {
+	return strings.ReplaceAll(rule.Companion, companionNamePlaceholder, objectName)
+}
+
+// newCompanionHash gets the hash used to check a companion's contents, nil when the rule only checks the companion exists.
+func newCompanionHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "":
+		return nil, nil
+	case "sha256":
+		return sha256.New(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, errors.NotSupportedf("Companion verify algorithm %s", algorithm)
+}
+
+// validateCompanions checks every object matching a rule has its companion object, e.g. a detached checksum or signature.
+func validateCompanions(ctx context.Context, bucket BackupStore, rules []CompanionRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	compiled, err := compileCompanionRules(rules)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	it := listObjects(ctx, bucket, &storage.Query{Versions: false})
+	for {
+		objAttrs, err := it.Next()
+		if err == iterator.Done {
+			markInventoryComplete(ctx)
+			break
+		}
+		if err != nil {
+			return errors.Annotate(err, "Unable to list objects to check companions")
+		}
+		countObjectExamined(ctx, objAttrs)
+		names[objAttrs.Name] = true
+	}
+
+	missing := findMissingCompanions(names, compiled)
+	if len(missing) == 0 {
+		return nil
+	}
+	reported := missing
+	if len(reported) > maxReportedOffenders {
+		reported = append(reported[:maxReportedOffenders:maxReportedOffenders],
+			fmt.Sprintf("and %d more", len(missing)-maxReportedOffenders))
+	}
+	return errors.NotFoundf("%d companion objects: %s", len(missing), strings.Join(reported, ", "))
+}
+
+// findMissingCompanions lists the companions that should exist alongside the named objects but don't, sorted by name.
+func findMissingCompanions(names map[string]bool, rules []compiledCompanionRule) (missing []string) {
+	for name := range names {
+		for _, rule := range rules {
+			if !rule.pattern.MatchString(name) {
+				continue
+			}
+			if companion := rule.companionName(name); !names[companion] {
+				missing = append(missing, companion)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return
+}
+
+// verifyCompanionChecksums checks a downloaded file against the checksums in its companion objects, for rules with verify set.
+func verifyCompanionChecksums(ctx context.Context, bucket BackupStore, rules []CompanionRule, remoteFile string, localFile string) error {
+	compiled, err := compileCompanionRules(rules)
+	if err != nil {
+		return err
+	}
+	for _, rule := range compiled {
+		if len(rule.Verify) == 0 || !rule.pattern.MatchString(remoteFile) {
+			continue
+		}
+		companion := rule.companionName(remoteFile)
+		contents, err := readSmallObject(ctx, bucket, companion, maxCompanionSize)
+		if err != nil {
+			return errors.Annotatef(err, "Unable to read companion %s", companion)
+		}
+		expected, err := parseCompanionDigest(contents, rule.Verify)
+		if err != nil {
+			return errors.Annotatef(err, "Unable to read checksum from companion %s", companion)
+		}
+		actual, err := getFileDigest(localFile, rule.Verify)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(expected, actual) {
+			return errors.NotValidf("%s %s checksum of %s, companion %s has %x but the file is %x",
+				remoteFile, rule.Verify, localFile, companion, expected, actual)
+		}
+	}
+	return nil
+}
+
+// readSmallObject reads a whole object into memory, failing if it is bigger than maxSize.
+func readSmallObject(ctx context.Context, bucket BackupStore, objectName string, maxSize int64) ([]byte, error) {
+	rc, err := bucket.Object(objectName).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	contents, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(contents)) > maxSize {
+		return nil, errors.NotValidf("Object %s bigger than %d bytes", objectName, maxSize)
+	}
+	return contents, nil
+}
+
+// parseCompanionDigest reads the digest from a checksum file, either the bare hex digest or sha256sum/md5sum output ("digest  filename").
+func parseCompanionDigest(contents []byte, algorithm string) ([]byte, error) {
+	h, err := newCompanionHash(algorithm)
+	if err != nil || h == nil {
+		return nil, errors.NotValidf("Verify algorithm %q", algorithm)
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return nil, errors.NotValidf("Empty checksum file")
+	}
+	digest, err := hex.DecodeString(strings.ToLower(fields[0]))
+	if err != nil || len(digest) != h.Size() {
+		return nil, errors.NotValidf("%s digest %q", algorithm, fields[0])
+	}
+	return digest, nil
+}
+
+func getFileDigest(filePath string, algorithm string) ([]byte, error) {
+	h, err := newCompanionHash(algorithm)
+	if err != nil || h == nil {
+		return nil, errors.NotValidf("Verify algorithm %q", algorithm)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to open file %s to calculate %s", filePath, algorithm)
+	}
+	defer file.Close()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to hash file %s to calculate %s", filePath, algorithm)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileCompanionRules(t *testing.T) {
+	is := assert.New(t)
+	compiled, err := compileCompanionRules([]CompanionRule{
+		{Pattern: `\.tar\.gz$`, Companion: "{name}.sha256", Verify: "sha256"},
+		{Pattern: `\.tar\.gz$`, Companion: "signatures/{name}.sig"},
+	})
+	is.NoError(err)
+	is.Len(compiled, 2)
+	is.Equal("signatures/2018-01-20.tar.gz.sig", compiled[1].companionName("2018-01-20.tar.gz"))
+
+	_, err = compileCompanionRules([]CompanionRule{{Pattern: `\.tar\.gz$`, Companion: "checksums.txt"}})
+	is.True(errors.IsNotValid(err), "Should error when the companion doesn't depend on the object name")
+	_, err = compileCompanionRules([]CompanionRule{{Pattern: `\.tar\.gz$`, Companion: "{name}.sha1", Verify: "sha1"}})
+	is.True(errors.IsNotSupported(err), "Should error on an unsupported verify algorithm")
+	_, err = compileCompanionRules([]CompanionRule{{Pattern: `(`, Companion: "{name}.sha256"}})
+	is.Error(err, "Should error on a bad pattern")
+}
+
+func TestFindMissingCompanions(t *testing.T) {
+	is := assert.New(t)
+	rules, err := compileCompanionRules([]CompanionRule{
+		{Pattern: `\.tar\.gz$`, Companion: "{name}.sha256"},
+		{Pattern: `^db/`, Companion: "{name}.sig"},
+	})
+	is.NoError(err)
+	names := map[string]bool{
+		"2018-01-19.tar.gz":        true,
+		"2018-01-19.tar.gz.sha256": true,
+		"2018-01-20.tar.gz":        true,
+		"db/dump.sql":              true,
+		"README.txt":               true,
+	}
+	is.Equal([]string{"2018-01-20.tar.gz.sha256", "db/dump.sql.sig"}, findMissingCompanions(names, rules))
+	is.Empty(findMissingCompanions(names, nil), "Should not require anything without rules")
+}
+
+var testParseCompanionDigestCases = []struct {
+	contents  string
+	algorithm string
+	expected  string
+}{
+	{"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n", "sha256",
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	{"E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855  2018-01-20.tar.gz\n", "sha256",
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	{"d41d8cd98f00b204e9800998ecf8427e *dump.sql", "md5", "d41d8cd98f00b204e9800998ecf8427e"},
+}
+
+func TestParseCompanionDigest(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testParseCompanionDigestCases {
+		actual, err := parseCompanionDigest([]byte(tc.contents), tc.algorithm)
+		is.NoError(err, "Should parse %q", tc.contents)
+		is.Equal(tc.expected, hexString(actual))
+	}
+
+	_, err := parseCompanionDigest([]byte(""), "sha256")
+	is.Error(err, "Should error on an empty checksum file")
+	_, err = parseCompanionDigest([]byte("d41d8cd98f00b204e9800998ecf8427e"), "sha256")
+	is.Error(err, "Should error when the digest is the wrong length")
+	_, err = parseCompanionDigest([]byte("not hex at all"), "md5")
+	is.Error(err, "Should error when the digest isn't hex")
+	_, err = parseCompanionDigest([]byte("d41d8cd98f00b204e9800998ecf8427e"), "")
+	is.Error(err, "Should error without an algorithm")
+}
+
+func TestGetFileDigest(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), "empty.txt")
+	os.WriteFile(filePath, nil, 0644)
+
+	actual, err := getFileDigest(filePath, "sha256")
+	is.NoError(err)
+	is.Equal("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hexString(actual))
+	actual, err = getFileDigest(filePath, "md5")
+	is.NoError(err)
+	is.Equal("d41d8cd98f00b204e9800998ecf8427e", hexString(actual))
+
+	_, err = getFileDigest(filepath.Join(t.TempDir(), "doesNotExist.txt"), "sha256")
+	is.Error(err, "Should error when the file doesn't exist")
+}
+
+func hexString(digest []byte) string {
+	return fmt.Sprintf("%x", digest)
+}
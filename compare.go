@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// kinds of difference between the two sides of a compare, in the order the report lists them
+const (
+	compareKindMissing  = "missing"
+	compareKindExtra    = "extra"
+	compareKindSize     = "size"
+	compareKindCrc32c   = "crc32c"
+	compareKindMetadata = "metadata"
+)
+
+var compareKinds = []string{compareKindMissing, compareKindExtra, compareKindSize, compareKindCrc32c, compareKindMetadata}
+
+var compareKindDescriptions = map[string]string{
+	compareKindMissing:  "Missing from destination",
+	compareKindExtra:    "Only in destination",
+	compareKindSize:     "Size mismatches",
+	compareKindCrc32c:   "CRC32C mismatches",
+	compareKindMetadata: "Metadata differences",
+}
+
+var compareCsvHeader = []string{"kind", "object", "detail"}
+
+// CompareDifference is one object that differs between the source and destination, named relative to their prefixes.
+type CompareDifference struct {
+	Kind   string
+	Object string
+	Detail string
+}
+
+// CompareResult is the outcome of comparing a source bucket or prefix with the destination it was copied to.
+// Matched counts the objects found on both sides with nothing different.
+type CompareResult struct {
+	Source            StorageURI
+	Destination       StorageURI
+	SourceTotals      InventoryTotals
+	DestinationTotals InventoryTotals
+	Matched           int
+	Differences       []CompareDifference
+}
+
+func (r CompareResult) count(kind string) (n int) {
+	for _, d := range r.Differences {
+		if d.Kind == kind {
+			n++
+		}
+	}
+	return
+}
+
+// runCompare lists two buckets or prefixes side by side and reports every object that differs, exiting non zero if any do.
+func runCompare(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	prefix := flags.String("prefix", "", "only compare objects under this prefix, relative to each side's prefix")
+	format := flags.String("format", "table", "format of the report, table or csv")
+	checkMetadata := flags.Bool("metadata", true,
+		"also compare content type, encoding, language, disposition, cache control and custom metadata")
+	return func(ctx context.Context) {
+		if flags.NArg() != 2 {
+			log.Fatal("The compare command takes a source and a destination, e.g. gs://old-backups gs://new-backups.")
+		}
+		source, err := parseStorageURI(flags.Arg(0))
+		logFatalIfErr(err, "Unable to use source.")
+		destination, err := parseStorageURI(flags.Arg(1))
+		logFatalIfErr(err, "Unable to use destination.")
+		source.Prefix += *prefix
+		destination.Prefix += *prefix
+
+		config, err := loadConfiguration(*configPath, os.Stdin, os.Getenv)
+		logFatalIfErr(err, "Unable to load configuration from file.")
+		client := connectToStorage(ctx, config, []BucketToProcess{
+			{Name: source.Bucket, Provider: source.Provider}, {Name: destination.Bucket, Provider: destination.Provider}})
+		result, err := compareBuckets(ctx, client, config, source, destination, *checkMetadata)
+		logFatalIfErr(err, "Unable to compare buckets.")
+		err = writeCompareResult(console.stdout, result, *format)
+		logFatalIfErr(err, "Unable to print comparison.")
+		if len(result.Differences) > 0 {
+			flushTracing()
+			os.Exit(1)
+		}
+	}
+}
+
+// compareBuckets lists the source and destination at the same time, then compares them object by object.
+func compareBuckets(ctx context.Context, client *storage.Client, config Config, source, destination StorageURI, checkMetadata bool) (
+	result CompareResult, err error) {
+	uris := []StorageURI{source, destination}
+	listings := make([]map[string]*storage.ObjectAttrs, len(uris))
+	errs := make([]error, len(uris))
+	var wg sync.WaitGroup
+	for i, uri := range uris {
+		wg.Add(1)
+		go func(i int, uri StorageURI) {
+			defer wg.Done()
+			bucket, err := openBackupStore(ctx, client, config, BucketToProcess{Name: uri.Bucket, Provider: uri.Provider})
+			if err == nil {
+				listings[i], err = listObjectsByRelativeName(ctx, bucket, uri.Prefix)
+			}
+			errs[i] = errors.Annotatef(err, "Unable to list %s", uri)
+		}(i, uri)
+	}
+	wg.Wait()
+	for _, err = range errs {
+		if err != nil {
+			return
+		}
+	}
+	result = compareObjectListings(listings[0], listings[1], checkMetadata)
+	result.Source, result.Destination = source, destination
+	return
+}
+
+// listObjectsByRelativeName lists the live objects under prefix, keyed by their names with the prefix taken off.
+func listObjectsByRelativeName(ctx context.Context, bucket BackupStore, prefix string) (objects map[string]*storage.ObjectAttrs, err error) {
+	objects = make(map[string]*storage.ObjectAttrs)
+	it := listObjects(withBucketPrefix(ctx, prefix), bucket, nil)
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			break
+		}
+		if err2 != nil {
+			return nil, err2
+		}
+		objects[strings.TrimPrefix(objAttrs.Name, prefix)] = objAttrs
+	}
+	return
+}
+
+// compareObjectListings finds the objects missing from either side, and those whose size, CRC32C or, if asked, metadata differ.
+// Differences are sorted by object name within each kind.
+func compareObjectListings(source, destination map[string]*storage.ObjectAttrs, checkMetadata bool) (result CompareResult) {
+	for name, sourceAttrs := range source {
+		result.SourceTotals.Objects++
+		result.SourceTotals.Bytes += sourceAttrs.Size
+		destinationAttrs, found := destination[name]
+		if !found {
+			result.Differences = append(result.Differences, CompareDifference{compareKindMissing, name, formatBytes(sourceAttrs.Size)})
+			continue
+		}
+		differences := getObjectDifferences(name, sourceAttrs, destinationAttrs, checkMetadata)
+		if len(differences) == 0 {
+			result.Matched++
+		}
+		result.Differences = append(result.Differences, differences...)
+	}
+	for name, destinationAttrs := range destination {
+		result.DestinationTotals.Objects++
+		result.DestinationTotals.Bytes += destinationAttrs.Size
+		if _, found := source[name]; !found {
+			result.Differences = append(result.Differences, CompareDifference{compareKindExtra, name, formatBytes(destinationAttrs.Size)})
+		}
+	}
+
+	kindOrder := make(map[string]int)
+	for i, kind := range compareKinds {
+		kindOrder[kind] = i
+	}
+	sort.Slice(result.Differences, func(i, j int) bool {
+		a, b := result.Differences[i], result.Differences[j]
+		if a.Kind != b.Kind {
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		return a.Object < b.Object
+	})
+	return
+}
+
+// getObjectDifferences compares an object with its copy.
+// The CRC32C is only compared when the sizes match, a size mismatch already says the contents differ.
+// S3 listings have no CRC32C, so against one the MD5s are compared instead, when both sides have one.
+func getObjectDifferences(name string, source, destination *storage.ObjectAttrs, checkMetadata bool) (differences []CompareDifference) {
+	withoutCRC32C := source.CRC32C == 0 || destination.CRC32C == 0
+	if source.Size != destination.Size {
+		differences = append(differences, CompareDifference{compareKindSize, name,
+			fmt.Sprintf("source %d bytes, destination %d bytes", source.Size, destination.Size)})
+	} else if withoutCRC32C && len(source.MD5) > 0 && len(destination.MD5) > 0 {
+		if !bytes.Equal(source.MD5, destination.MD5) {
+			differences = append(differences, CompareDifference{compareKindCrc32c, name,
+				fmt.Sprintf("source md5 %x, destination md5 %x", source.MD5, destination.MD5)})
+		}
+	} else if !withoutCRC32C && source.CRC32C != destination.CRC32C {
+		differences = append(differences, CompareDifference{compareKindCrc32c, name,
+			fmt.Sprintf("source %d, destination %d", source.CRC32C, destination.CRC32C)})
+	}
+	if !checkMetadata {
+		return
+	}
+	var changed []string
+	fields := []struct {
+		name                string
+		source, destination string
+	}{
+		{"content type", source.ContentType, destination.ContentType},
+		{"content encoding", source.ContentEncoding, destination.ContentEncoding},
+		{"content language", source.ContentLanguage, destination.ContentLanguage},
+		{"content disposition", source.ContentDisposition, destination.ContentDisposition},
+		{"cache control", source.CacheControl, destination.CacheControl},
+	}
+	for _, field := range fields {
+		if field.source != field.destination {
+			changed = append(changed, fmt.Sprintf("%s %q now %q", field.name, field.source, field.destination))
+		}
+	}
+	var keys []string
+	for key := range source.Metadata {
+		keys = append(keys, key)
+	}
+	for key := range destination.Metadata {
+		if _, found := source.Metadata[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sourceValue, inSource := source.Metadata[key]
+		destinationValue, inDestination := destination.Metadata[key]
+		switch {
+		case !inDestination:
+			changed = append(changed, fmt.Sprintf("metadata %s missing", key))
+		case !inSource:
+			changed = append(changed, fmt.Sprintf("metadata %s added", key))
+		case sourceValue != destinationValue:
+			changed = append(changed, fmt.Sprintf("metadata %s %q now %q", key, sourceValue, destinationValue))
+		}
+	}
+	if len(changed) > 0 {
+		differences = append(differences, CompareDifference{compareKindMetadata, name, strings.Join(changed, ", ")})
+	}
+	return
+}
+
+// writeCompareResult prints a checklist of the totals and counts of each kind of difference, then every difference.
+// The csv format only has the differences, for working through them in a spreadsheet.
+func writeCompareResult(w io.Writer, result CompareResult, format string) error {
+	switch format {
+	case "", "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Source\t%s\t%d objects\t%s\t\n", result.Source, result.SourceTotals.Objects, formatBytes(result.SourceTotals.Bytes))
+		fmt.Fprintf(tw, "Destination\t%s\t%d objects\t%s\t\n", result.Destination, result.DestinationTotals.Objects,
+			formatBytes(result.DestinationTotals.Bytes))
+		fmt.Fprintf(tw, "Matched\t%d\t\t\t\n", result.Matched)
+		for _, kind := range compareKinds {
+			fmt.Fprintf(tw, "%s\t%d\t\t\t\n", compareKindDescriptions[kind], result.count(kind))
+		}
+		ready := "yes"
+		if len(result.Differences) > 0 {
+			ready = "no"
+		}
+		fmt.Fprintf(tw, "Ready for cutover\t%s\t\t\t\n", ready)
+		err := tw.Flush()
+		if err != nil || len(result.Differences) == 0 {
+			return err
+		}
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, d := range result.Differences {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\n", d.Kind, d.Object, d.Detail)
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(compareCsvHeader)
+		for _, d := range result.Differences {
+			cw.Write([]string{d.Kind, d.Object, d.Detail})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return errors.NotSupportedf("Compare format %s", format)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCompareSource = map[string]*storage.ObjectAttrs{
+	"same.tar.gz":     {Size: 10, CRC32C: 1, ContentType: "application/gzip"},
+	"missing.tar.gz":  {Size: 2048},
+	"resized.tar.gz":  {Size: 10, CRC32C: 1},
+	"corrupt.tar.gz":  {Size: 10, CRC32C: 1},
+	"retagged.tar.gz": {Size: 10, CRC32C: 1, ContentType: "application/gzip", Metadata: map[string]string{"host": "db", "owner": "matt"}},
+	"nested/a/b.txt":  {Size: 1, CRC32C: 7},
+}
+
+var testCompareDestination = map[string]*storage.ObjectAttrs{
+	"same.tar.gz":     {Size: 10, CRC32C: 1, ContentType: "application/gzip"},
+	"resized.tar.gz":  {Size: 12, CRC32C: 2},
+	"corrupt.tar.gz":  {Size: 10, CRC32C: 2},
+	"retagged.tar.gz": {Size: 10, CRC32C: 1, ContentType: "application/x-tar", Metadata: map[string]string{"host": "web", "tier": "cold"}},
+	"nested/a/b.txt":  {Size: 1, CRC32C: 7},
+	"extra.txt":       {Size: 5},
+}
+
+func TestCompareObjectListings(t *testing.T) {
+	is := assert.New(t)
+	actual := compareObjectListings(testCompareSource, testCompareDestination, true)
+	is.Equal(InventoryTotals{Objects: 6, Bytes: 2089}, actual.SourceTotals)
+	is.Equal(InventoryTotals{Objects: 6, Bytes: 48}, actual.DestinationTotals)
+	is.Equal(2, actual.Matched)
+	is.Equal([]CompareDifference{
+		{compareKindMissing, "missing.tar.gz", "2.0 KiB"},
+		{compareKindExtra, "extra.txt", "5 B"},
+		{compareKindSize, "resized.tar.gz", "source 10 bytes, destination 12 bytes"},
+		{compareKindCrc32c, "corrupt.tar.gz", "source 1, destination 2"},
+		{compareKindMetadata, "retagged.tar.gz",
+			`content type "application/gzip" now "application/x-tar", metadata host "db" now "web", metadata owner missing, metadata tier added`},
+	}, actual.Differences)
+
+	actual = compareObjectListings(testCompareSource, testCompareDestination, false)
+	is.Equal(3, actual.Matched, "Should ignore metadata when not asked to compare it")
+	is.Equal(0, actual.count(compareKindMetadata))
+
+	actual = compareObjectListings(testCompareSource, testCompareSource, true)
+	is.Empty(actual.Differences, "Should match itself")
+	is.Equal(6, actual.Matched)
+}
+
+func TestWriteCompareResult(t *testing.T) {
+	is := assert.New(t)
+	result := compareObjectListings(testCompareSource, testCompareDestination, true)
+	result.Source = StorageURI{"gs", "old-backups", "nightly/"}
+	result.Destination = StorageURI{"gs", "new-backups", ""}
+
+	var actual bytes.Buffer
+	is.NoError(writeCompareResult(&actual, result, "table"))
+	is.Contains(actual.String(), "Source                    gs://old-backups/nightly/  6 objects  2.0 KiB")
+	is.Contains(actual.String(), "Missing from destination  1")
+	is.Contains(actual.String(), "Ready for cutover         no")
+	is.Contains(actual.String(), "crc32c    corrupt.tar.gz   source 1, destination 2")
+
+	actual.Reset()
+	is.NoError(writeCompareResult(&actual, CompareResult{Matched: 1}, "table"))
+	is.Contains(actual.String(), "Ready for cutover         yes")
+
+	actual.Reset()
+	is.NoError(writeCompareResult(&actual, result, "csv"))
+	is.True(strings.HasPrefix(actual.String(), "kind,object,detail\nmissing,missing.tar.gz,2.0 KiB\n"), "Should list differences in order")
+
+	is.Error(writeCompareResult(&actual, result, "xml"), "Should error on unknown formats")
+}
+
+func TestGetObjectDifferencesWithoutCRC32C(t *testing.T) {
+	is := assert.New(t)
+	gcs := &storage.ObjectAttrs{Size: 10, CRC32C: 1, MD5: []byte{0xab, 0xcd}}
+	s3 := &storage.ObjectAttrs{Size: 10, MD5: []byte{0xab, 0xcd}}
+	is.Empty(getObjectDifferences("copied.tar.gz", gcs, s3, false), "Should compare MD5s against an S3 listing")
+	s3.MD5 = []byte{0xab, 0xce}
+	is.Equal([]CompareDifference{{compareKindCrc32c, "copied.tar.gz", "source md5 abcd, destination md5 abce"}},
+		getObjectDifferences("copied.tar.gz", gcs, s3, false))
+	s3.MD5 = nil
+	is.Empty(getObjectDifferences("multipart.tar.gz", gcs, s3, false), "Should only compare sizes without a checksum in common")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// completionScripts ask validatebackups for the candidates of the word being completed, one per line.
+// When there are none, e.g. for --config or --plan, they fall back to completing file names.
+var completionScripts = map[string]string{
+	"bash": `_validatebackups() {
+    local IFS=$'\n'
+    COMPREPLY=($(validatebackups __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _validatebackups validatebackups
+`,
+	"zsh": `#compdef validatebackups
+_validatebackups() {
+    local -a candidates
+    candidates=("${(@f)$(validatebackups __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ -z "${candidates[1]}" ]]; then
+        _files
+    else
+        compadd -a candidates
+    fi
+}
+compdef _validatebackups validatebackups
+`,
+	"fish": `function __validatebackups_complete
+    set -l candidates (validatebackups __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)
+    if test (count $candidates) -eq 0
+        __fish_complete_path (commandline -ct)
+    else
+        printf '%s\n' $candidates
+    end
+end
+complete -c validatebackups -f -a '(__validatebackups_complete)'
+`,
+}
+
+func runCompletion(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 || len(completionScripts[args[0]]) == 0 {
+		fmt.Fprintf(stderr, "Usage: %s completion bash|zsh|fish\n", programName)
+		return 2
+	}
+	fmt.Fprint(stdout, completionScripts[args[0]])
+	return 0
+}
+
+// getCompletions lists the candidates for the last of words, the command line after the program name.
+// Flags that take a value are completed from the command's flagValues or from the config,
+// which is loaded from --config if it is on the command line.
+func getCompletions(words []string, loadConfig func(configPath string) (Config, error)) (candidates []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	cmd, rest := findCommand(words[:len(words)-1])
+	flags := newCommandFlagSet(cmd, io.Discard)
+	cmd.define(flags)
+
+	if len(rest) > 0 {
+		previous := strings.TrimLeft(rest[len(rest)-1], "-")
+		if f := flags.Lookup(previous); f != nil && strings.HasPrefix(rest[len(rest)-1], "-") && !isBoolFlag(f) {
+			return filterCompletions(getFlagValueCompletions(cmd, f.Name, rest, loadConfig), current)
+		}
+	}
+	if strings.HasPrefix(current, "-") {
+		flags.VisitAll(func(f *flag.Flag) {
+			if f.Name != faultInjectionFlag {
+				candidates = append(candidates, "--"+f.Name)
+			}
+		})
+		return filterCompletions(candidates, current)
+	}
+	if len(words) == 1 {
+		for _, c := range commands[1:] {
+			candidates = append(candidates, c.name)
+		}
+		for name := range builtinCommands {
+			candidates = append(candidates, name)
+		}
+		sort.Strings(candidates)
+		return filterCompletions(candidates, current)
+	}
+	if len(words) == 2 && (words[0] == "help" || words[0] == "completion") {
+		if words[0] == "completion" {
+			for shell := range completionScripts {
+				candidates = append(candidates, shell)
+			}
+		} else {
+			for _, c := range commands[1:] {
+				candidates = append(candidates, c.name)
+			}
+		}
+		sort.Strings(candidates)
+		return filterCompletions(candidates, current)
+	}
+	return nil
+}
+
+func getFlagValueCompletions(cmd command, flagName string, words []string, loadConfig func(configPath string) (Config, error)) []string {
+	if values := cmd.flagValues[flagName]; len(values) > 0 {
+		return values
+	}
+	fromConfig, found := configFlagValues[flagName]
+	if !found {
+		return nil
+	}
+	config, err := loadConfig(getCompletionConfigPath(words))
+	if err != nil {
+		return nil
+	}
+	return fromConfig(config)
+}
+
+// getCompletionConfigPath finds --config on the command line, or the path the command would default to.
+func getCompletionConfigPath(words []string) string {
+	for i, word := range words {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+		if !strings.HasPrefix(word, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(words) {
+			return words[i+1]
+		}
+	}
+	return getEnvOrDefault(os.Getenv, envConfigPath, defaultConfigPath)
+}
+
+// loadCompletionConfig loads the config for completing flag values, never from stdin as that is the terminal.
+func loadCompletionConfig(configPath string) (Config, error) {
+	if configPath == stdinConfigPath {
+		return Config{}, errors.NotSupportedf("Completing from a config on stdin")
+	}
+	return loadConfiguration(configPath, strings.NewReader(""), os.Getenv)
+}
+
+func getConfiguredBucketNames(config Config) (names []string) {
+	for _, bucket := range config.Buckets {
+		names = append(names, bucket.Name)
+	}
+	return
+}
+
+func filterCompletions(candidates []string, prefix string) (matches []string) {
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	return
+}
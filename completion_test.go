@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testCompletionConfig = Config{
+	Buckets: []BucketToProcess{
+		{Name: "test-matt-media", Type: "media"},
+		{Name: "test-matt-server-backups", Type: "server-backup"},
+	},
+	Profiles: map[string]RunProfile{"nightly": {SkipDownloads: true}},
+}
+
+var testGetCompletionsCases = []struct {
+	words    []string
+	expected []string
+}{
+	{[]string{""}, []string{"audit", "catalog", "compare", "completion", "config", "coverage", "digest", "doctor", "download", "drill", "help", "newest", "plan", "report", "verify-remote", "version"}},
+	{[]string{"d"}, []string{"digest", "doctor", "download", "drill"}},
+	{[]string{"--sm"}, []string{"--smoke"}},
+	{[]string{"plan", "--fo"}, []string{"--format"}},
+	{[]string{"plan", "--format", ""}, []string{"json", "csv"}},
+	{[]string{"coverage", "-format", "t"}, []string{"table"}},
+	{[]string{"drill", "--bucket", "test-matt-s"}, []string{"test-matt-server-backups"}},
+	{[]string{"--profile", ""}, []string{"audit", "deep", "nightly", "shallow"}},
+	{[]string{"completion", "z"}, []string{"zsh"}},
+	{[]string{"help", "co"}, []string{"compare", "config", "coverage"}},
+	//file names are left to the shell
+	{[]string{"download", "--plan", ""}, nil},
+	{[]string{"--smoke", ""}, nil},
+	{[]string{"plan", ""}, nil},
+}
+
+func TestGetCompletions(t *testing.T) {
+	is := assert.New(t)
+	loadConfig := func(configPath string) (Config, error) {
+		return testCompletionConfig, nil
+	}
+	for _, tc := range testGetCompletionsCases {
+		is.Equal(tc.expected, getCompletions(tc.words, loadConfig), "Should complete %v", tc.words)
+	}
+
+	failingLoad := func(configPath string) (Config, error) {
+		return Config{}, errors.New("no config")
+	}
+	is.Empty(getCompletions([]string{"drill", "--bucket", ""}, failingLoad), "Should complete nothing without a config")
+}
+
+func TestGetCompletionConfigPath(t *testing.T) {
+	is := assert.New(t)
+	is.Equal("my.json", getCompletionConfigPath([]string{"--config", "my.json", "--bucket"}))
+	is.Equal("my.json", getCompletionConfigPath([]string{"-config=my.json", "--bucket"}))
+	t.Setenv(envConfigPath, "env.json")
+	is.Equal("env.json", getCompletionConfigPath([]string{"--bucket"}), "Should default like the config flag")
+}
+
+func TestRunCompletion(t *testing.T) {
+	is := assert.New(t)
+	for shell := range completionScripts {
+		var stdout, stderr bytes.Buffer
+		is.Equal(0, runCompletion([]string{shell}, &stdout, &stderr))
+		is.Contains(stdout.String(), "validatebackups __complete", "Should ask validatebackups for candidates in %s", shell)
+	}
+	var stdout, stderr bytes.Buffer
+	is.Equal(2, runCompletion([]string{"powershell"}, &stdout, &stderr), "Should fail on unknown shells")
+	is.Equal(2, runCompletion(nil, &stdout, &stderr))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// configDefaults are the rules a config starts from, a field left out of the config file keeps its default
+// while one given, even as zero, replaces it. Settings that turn a feature on, such as max_in_progress_age_in_days
+// or sampling_memory_days, default to off.
+var configDefaults = Config{
+	MaxDownloadRetries: 3,
+	ServerBackupRules: ServerFileValidationRules{
+		OldestFileMaxAgeInDays: 32,
+		NewestFileMaxAgeInDays: 2,
+	},
+	FilesToDownload: FileDownloadRules{
+		ServerBackups:        1,
+		EpisodesFromEachShow: 1,
+		PhotosFromThisMonth:  1,
+		PhotosFromEachYear:   1,
+	},
+	AdaptiveDownloads: AdaptiveDownloads{
+		MaxWorkers:     defaultMaxWorkers,
+		NetworkProfile: defaultNetworkProfile,
+	},
+	IssueTracker:        IssueTracker{FailureThreshold: defaultIssueFailureThreshold},
+	MaxClockSkewSeconds: int(defaultMaxClockSkew / time.Second),
+}
+
+// decodeConfiguration reads a json config on top of configDefaults.
+func decodeConfiguration(r io.Reader) (config Config, err error) {
+	config = configDefaults
+	err = json.NewDecoder(r).Decode(&config)
+	return
+}
+
+// effectiveConfig is the config as a run will use it. ActiveProfile isn't read from config files, so it is only written here.
+type effectiveConfig struct {
+	Config
+	ActiveProfile *RunProfile `json:"active_profile,omitempty"`
+}
+
+// writeEffectiveConfig writes the config as indented json, with the alerting webhook url withheld as it works as a password.
+func writeEffectiveConfig(w io.Writer, config Config) error {
+	effective := effectiveConfig{Config: config}
+	if len(config.ActiveProfile.Name) > 0 {
+		effective.ActiveProfile = &config.ActiveProfile
+	}
+	if len(effective.Alerting.URL) > 0 {
+		effective.Alerting.URL = redacted
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(effective)
+}
+
+// runConfig prints the fully resolved config, after the environment overrides, defaults and run profile are applied.
+func runConfig(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	profile := flags.String("profile", "", "run profile to apply, as a run with --profile would")
+	oneshot := flags.Bool("oneshot", os.Getenv(envOneshot) == "true",
+		"build the config from VALIDATEBACKUPS_* environment variables instead of a config file")
+	return func(ctx context.Context) {
+		if flags.NArg() != 1 || flags.Arg(0) != "print-effective" {
+			log.Fatal("The config command takes print-effective.")
+		}
+		var config Config
+		var err error
+		if *oneshot {
+			config, err = getConfigFromEnv(os.Getenv)
+			logFatalIfErr(err, "Unable to load configuration from the environment.")
+		} else {
+			config, err = loadConfiguration(*configPath, os.Stdin, os.Getenv)
+			logFatalIfErr(err, "Unable to load configuration from file.")
+		}
+		config, err = applyRunProfile(config, *profile)
+		logFatalIfErr(err, "Unable to use run profile.")
+		logFatalIfErr(writeEffectiveConfig(console.stdout, config), "Unable to print configuration.")
+	}
+}
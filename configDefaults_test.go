@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDecodeConfigurationCases = []struct {
+	json     string
+	expected func(config *Config)
+}{
+	{`{}`, func(config *Config) {}},
+	{`{"max_download_retries": 0}`, func(config *Config) { config.MaxDownloadRetries = 0 }},
+	{`{"files_to_download": {"server_backups": 4}}`, func(config *Config) { config.FilesToDownload.ServerBackups = 4 }},
+	{`{"adaptive_downloads": {"enabled": true}}`, func(config *Config) { config.AdaptiveDownloads.Enabled = true }},
+	{`{"issue_tracker": {"kind": "github", "failure_threshold": 1}}`, func(config *Config) {
+		config.IssueTracker = IssueTracker{Kind: "github", FailureThreshold: 1}
+	}},
+}
+
+func TestDecodeConfiguration(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testDecodeConfigurationCases {
+		expected := configDefaults
+		tc.expected(&expected)
+		actual, err := decodeConfiguration(strings.NewReader(tc.json))
+		is.NoError(err)
+		is.Equal(expected, actual, "case %s", tc.json)
+	}
+	is.Equal(3, configDefaults.MaxDownloadRetries, "Should leave the defaults alone")
+	is.Equal(1, configDefaults.FilesToDownload.ServerBackups)
+
+	_, err := decodeConfiguration(strings.NewReader("{not json"))
+	is.Error(err)
+}
+
+func TestWriteEffectiveConfig(t *testing.T) {
+	is := assert.New(t)
+	config := configDefaults
+	config.Alerting = Alerting{Kind: "pagerduty", URL: "https://events.example.com/secret-key"}
+	config, err := applyRunProfile(config, "audit")
+	is.NoError(err)
+
+	var out bytes.Buffer
+	is.NoError(writeEffectiveConfig(&out, config))
+	is.NotContains(out.String(), "secret-key", "Should withhold the alerting url")
+	is.Contains(out.String(), "\n  \"max_download_retries\": 3,\n")
+	var printed map[string]any
+	is.NoError(json.Unmarshal(out.Bytes(), &printed))
+	is.Equal(map[string]any{"skip_downloads": false, "checksum_only": true, "sample_multiplier": 10.0, "full_downloads": false},
+		printed["active_profile"])
+	is.Equal(10.0, printed["files_to_download"].(map[string]any)["server_backups"], "Should show the counts after the profile")
+	is.Equal("https://events.example.com/secret-key", config.Alerting.URL, "Should leave the config alone")
+
+	//printed configs can be loaded again
+	reloaded, err := decodeConfiguration(&out)
+	is.NoError(err)
+	is.Equal(config.FilesToDownload, reloaded.FilesToDownload)
+
+	out.Reset()
+	is.NoError(writeEffectiveConfig(&out, configDefaults))
+	is.NotContains(out.String(), "active_profile", "Should leave out the profile when none is used")
+}
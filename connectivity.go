@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// envEmulatorHost points the storage client library at an emulator, which it connects to without a proxy or TLS.
+const envEmulatorHost = "STORAGE_EMULATOR_HOST"
+
+// defaultStorageURL is what the connectivity check connects to when no endpoint is pinned.
+const defaultStorageURL = "https://storage.googleapis.com/"
+
+// connectivityTimeout bounds the connectivity check, a proxy that swallows connections fails it quickly.
+const connectivityTimeout = 15 * time.Second
+
+// checkConnectivity makes one request to the storage endpoint before anything else, through the same proxy and
+// http settings the client uses, so proxy and TLS problems are reported as such rather than as a deadline exceeded
+// during the first listing. Any http response will do, an error status still means the endpoint was reached.
+// Its Date header is compared with the local clock, skew isn't measured when the check is skipped.
+func checkConnectivity(ctx context.Context, transport ClientTransport, getenv func(string) string) (skew clockSkew, err error) {
+	if transport.SkipConnectivityCheck || transport.API == clientAPIGRPC {
+		return
+	}
+	target := transport.Endpoint
+	if len(target) == 0 {
+		if len(getenv(envEmulatorHost)) > 0 {
+			return
+		}
+		target = defaultStorageURL
+	}
+	base, err := getBaseTransport(transport, "gs")
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return skew, errors.Annotatef(err, "Invalid endpoint %s", target)
+	}
+	sent := time.Now()
+	resp, err := (&http.Client{Transport: base}).Do(req)
+	if err != nil {
+		return skew, describeConnectivityError(err, req.URL.Host, getRequestProxy(base, req))
+	}
+	resp.Body.Close()
+	return measureClockSkew(resp, sent, time.Now()), nil
+}
+
+// getRequestProxy is the host of the proxy base sends req through, blank when it connects directly.
+func getRequestProxy(base http.RoundTripper, req *http.Request) string {
+	transport, ok := base.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		return ""
+	}
+	proxy, err := transport.Proxy(req)
+	if err != nil || proxy == nil {
+		return ""
+	}
+	return proxy.Host
+}
+
+// describeConnectivityError says what went wrong connecting to host in terms of what to fix.
+func describeConnectivityError(err error, host string, proxy string) error {
+	via := "directly"
+	if len(proxy) > 0 {
+		via = "through proxy " + proxy
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+		return errors.Annotatef(err, "Unable to connect to proxy %s", proxy)
+	case errors.As(err, &verifyErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr):
+		return errors.Annotatef(err, "Untrusted TLS certificate connecting to %s %s, a proxy that intercepts TLS needs its CA certificate trusted",
+			host, via)
+	case errors.As(err, &dnsErr):
+		return errors.Annotatef(err, "Unable to resolve %s", dnsErr.Name)
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return errors.Annotatef(err, "Timed out connecting to %s %s", host, via)
+	}
+	return errors.Annotatef(err, "Unable to connect to %s %s", host, via)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConnectivity(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	noEnv := func(string) string { return "" }
+	skew, err := checkConnectivity(ctx, ClientTransport{Endpoint: server.URL + "/storage/v1/", Proxy: proxyDirect}, noEnv)
+	is.NoError(err, "Any response should mean the endpoint was reached")
+	is.True(skew.measured, "Should compare the clock with the response's Date header")
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+	_, err = checkConnectivity(ctx, ClientTransport{Endpoint: tlsServer.URL, Proxy: proxyDirect}, noEnv)
+	is.ErrorContains(err, "Untrusted TLS certificate")
+
+	//nothing listens on a closed listener's port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(err)
+	closedAddress := listener.Addr().String()
+	listener.Close()
+	_, err = checkConnectivity(ctx, ClientTransport{Endpoint: server.URL, Proxy: "http://" + closedAddress}, noEnv)
+	is.ErrorContains(err, "Unable to connect to proxy "+closedAddress)
+	_, err = checkConnectivity(ctx, ClientTransport{Endpoint: "http://" + closedAddress, Proxy: proxyDirect}, noEnv)
+	is.ErrorContains(err, "Unable to connect to "+closedAddress+" directly")
+
+	skew, err = checkConnectivity(ctx, ClientTransport{Endpoint: "http://" + closedAddress, SkipConnectivityCheck: true}, noEnv)
+	is.NoError(err)
+	is.False(skew.measured)
+	_, err = checkConnectivity(ctx, ClientTransport{API: clientAPIGRPC}, noEnv)
+	is.NoError(err)
+	emulator := func(key string) string {
+		if key == envEmulatorHost {
+			return "localhost:4443"
+		}
+		return ""
+	}
+	_, err = checkConnectivity(ctx, ClientTransport{}, emulator)
+	is.NoError(err, "Should leave emulators to the client library")
+}
+
+func TestDescribeConnectivityError(t *testing.T) {
+	is := assert.New(t)
+	err := describeConnectivityError(&net.DNSError{Name: "storage.googleapis.com", IsNotFound: true}, "storage.googleapis.com", "")
+	is.True(strings.HasPrefix(err.Error(), "Unable to resolve storage.googleapis.com"))
+	err = describeConnectivityError(context.DeadlineExceeded, "storage.googleapis.com", "proxy:3128")
+	is.True(strings.HasPrefix(err.Error(), "Timed out connecting to storage.googleapis.com through proxy proxy:3128"))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress bars are redrawn at most every consoleRefreshInterval, and names longer than consoleMaxNameLength are cut
+// from the front so a bar never wraps, which would throw out the count of lines to clear.
+const (
+	consoleRefreshInterval = 100 * time.Millisecond
+	consoleMaxNameLength   = 40
+)
+
+// console is where every stage prints to, so log lines from any goroutine and the progress bars of downloads in flight
+// don't garble each other.
+var console = newConsoleOutput(os.Stdout, os.Stderr, isTerminal(os.Stdout))
+
+// consoleOutput serializes writes to stdout and stderr a whole line at a time, so each line should be written in one call,
+// as fmt.Fprintln does. On a terminal the progress bars are drawn as a region of lines below everything else, one line per bar,
+// and that region is cleared while a line is written above it and redrawn after. Anywhere else, e.g. a log file or a pipe,
+// bars aren't drawn and each prints a single line when it finishes.
+type consoleOutput struct {
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+	stdout   io.Writer
+	stderr   io.Writer
+	pending  map[io.Writer][]byte
+	bars     []*progressBar
+	drawn    int
+	lastDraw time.Time
+	now      func() time.Time
+}
+
+func newConsoleOutput(stdout, stderr io.Writer, terminal bool) *consoleOutput {
+	c := &consoleOutput{out: stdout, terminal: terminal, pending: make(map[io.Writer][]byte), now: time.Now}
+	c.stdout = consoleStream{c, stdout}
+	c.stderr = consoleStream{c, stderr}
+	return c
+}
+
+// consoleStream is stdout or stderr of a consoleOutput.
+type consoleStream struct {
+	console *consoleOutput
+	w       io.Writer
+}
+
+// Write holds on to anything after the last newline until the rest of its line is written, or the console is flushed.
+func (s consoleStream) Write(p []byte) (n int, err error) {
+	c := s.console
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := append(c.pending[s.w], p...)
+	end := bytes.LastIndexByte(pending, '\n') + 1
+	if end == 0 {
+		c.pending[s.w] = pending
+		return len(p), nil
+	}
+	c.pending[s.w] = append([]byte(nil), pending[end:]...)
+	c.clearBars()
+	_, err = s.w.Write(pending[:end])
+	c.drawBars()
+	return len(p), err
+}
+
+// flush writes out any partial lines, e.g. before exiting.
+func (c *consoleOutput) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for w, pending := range c.pending {
+		if len(pending) > 0 {
+			c.clearBars()
+			w.Write(pending)
+			c.drawBars()
+		}
+		delete(c.pending, w)
+	}
+}
+
+// clearBars moves the cursor back to the top of the bar region and clears it. It must be called holding mu.
+func (c *consoleOutput) clearBars() {
+	if c.drawn > 0 {
+		fmt.Fprintf(c.out, "\x1b[%dA\r\x1b[J", c.drawn)
+		c.drawn = 0
+	}
+}
+
+// drawBars draws every bar below the cursor, on a terminal. It must be called holding mu.
+func (c *consoleOutput) drawBars() {
+	if !c.terminal || len(c.bars) == 0 {
+		return
+	}
+	now := c.now()
+	var region strings.Builder
+	for _, bar := range c.bars {
+		region.WriteString(bar.describe(now, false))
+		region.WriteString("\n")
+	}
+	io.WriteString(c.out, region.String())
+	c.drawn = len(c.bars)
+	c.lastDraw = now
+}
+
+// refresh redraws the bars if they haven't been drawn for a while.
+func (c *consoleOutput) refresh() {
+	if !c.terminal {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.now().Sub(c.lastDraw) < consoleRefreshInterval {
+		return
+	}
+	c.clearBars()
+	c.drawBars()
+}
+
+// progressBar shows how much of a download is done. It is safe to add to from several goroutines,
+// e.g. the workers of a chunked download sharing one bar.
+type progressBar struct {
+	console *consoleOutput
+	name    string
+	total   int64
+	started int64
+	current atomic.Int64
+	start   time.Time
+}
+
+// newProgressBar adds a bar for name to the bottom of the bar region, current bytes of total are already done.
+func (c *consoleOutput) newProgressBar(name string, total int64, current int64) *progressBar {
+	bar := &progressBar{console: c, name: name, total: total, started: current, start: c.now()}
+	bar.current.Store(current)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clearBars()
+	c.bars = append(c.bars, bar)
+	c.drawBars()
+	return bar
+}
+
+func (bar *progressBar) add(n int64) {
+	bar.current.Add(n)
+	bar.console.refresh()
+}
+
+// proxyReader adds everything read from r to the bar.
+func (bar *progressBar) proxyReader(r io.Reader) io.Reader {
+	return progressReader{r, bar}
+}
+
+// finish takes the bar out of the region and prints where it got to, it is done with whether or not the download worked.
+func (bar *progressBar) finish() {
+	c := bar.console
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clearBars()
+	for i := range c.bars {
+		if c.bars[i] == bar {
+			c.bars = append(c.bars[:i], c.bars[i+1:]...)
+			break
+		}
+	}
+	fmt.Fprintln(c.out, bar.describe(c.now(), true))
+	c.drawBars()
+}
+
+// describe is the bar's line, e.g. "db/nightly.dump  1.5 GiB / 3.0 GiB  50%  12.0 MiB/s",
+// or with the time taken in place of the speed once it is finished.
+func (bar *progressBar) describe(now time.Time, finished bool) string {
+	current := bar.current.Load()
+	name := bar.name
+	if runes := []rune(name); len(runes) > consoleMaxNameLength {
+		name = "..." + string(runes[len(runes)-consoleMaxNameLength+3:])
+	}
+	line := fmt.Sprintf("%s  %s / %s  %.0f%%", name, formatBytes(current), formatBytes(bar.total), percentOf(current, bar.total))
+	elapsed := now.Sub(bar.start)
+	if finished {
+		return line + fmt.Sprintf("  in %s", elapsed.Round(time.Second))
+	}
+	if elapsed < time.Second {
+		return line
+	}
+	return line + fmt.Sprintf("  %s/s", formatBytes(int64(float64(current-bar.started)/elapsed.Seconds())))
+}
+
+type progressReader struct {
+	r   io.Reader
+	bar *progressBar
+}
+
+func (pr progressReader) Read(p []byte) (n int, err error) {
+	n, err = pr.r.Read(p)
+	pr.bar.add(int64(n))
+	return
+}
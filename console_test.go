@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestProgressBar is a bar on a console that isn't a terminal, writing nowhere.
+func newTestProgressBar(total int64) *progressBar {
+	return newConsoleOutput(io.Discard, io.Discard, false).newProgressBar("test", total, 0)
+}
+
+// newTestConsole is a console whose clock only moves when the test says so.
+func newTestConsole(terminal bool) (c *consoleOutput, stdout, stderr *bytes.Buffer, now *time.Time) {
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	c = newConsoleOutput(stdout, stderr, terminal)
+	clock := time.Date(2026, time.October, 17, 6, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return clock }
+	return c, stdout, stderr, &clock
+}
+
+var testProgressBarDescribeCases = []struct {
+	name     string
+	current  int64
+	elapsed  time.Duration
+	finished bool
+	expected string
+}{
+	{"db/nightly.dump", 0, 0, false, "db/nightly.dump  0 B / 4.0 MiB  0%"},
+	{"db/nightly.dump", 1 << 20, 500 * time.Millisecond, false, "db/nightly.dump  1.0 MiB / 4.0 MiB  25%"},
+	{"db/nightly.dump", 2 << 20, 2 * time.Second, false, "db/nightly.dump  2.0 MiB / 4.0 MiB  50%  1.0 MiB/s"},
+	{"db/nightly.dump", 4 << 20, 2600 * time.Millisecond, true, "db/nightly.dump  4.0 MiB / 4.0 MiB  100%  in 3s"},
+	{"shows/a show with a very long name/season 1/episode 1.mkv", 0, 0, false,
+		"...very long name/season 1/episode 1.mkv  0 B / 4.0 MiB  0%"},
+	{"photos/2026/10/über straße café ünïcödé 1.jpg", 0, 0, false, "...026/10/über straße café ünïcödé 1.jpg  0 B / 4.0 MiB  0%"},
+}
+
+func TestProgressBarDescribe(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testProgressBarDescribeCases {
+		c, _, _, now := newTestConsole(false)
+		bar := c.newProgressBar(tc.name, 4<<20, 0)
+		bar.current.Store(tc.current)
+		is.Equal(tc.expected, bar.describe(now.Add(tc.elapsed), tc.finished))
+	}
+}
+
+func TestConsoleOutput(t *testing.T) {
+	is := assert.New(t)
+	c, stdout, stderr, _ := newTestConsole(false)
+	fmt.Fprint(c.stdout, "Downloading 1 of 2, ")
+	is.Empty(stdout.String(), "Should hold on to a partial line")
+	fmt.Fprintln(c.stdout, "db/nightly.dump")
+	is.Equal("Downloading 1 of 2, db/nightly.dump\n", stdout.String())
+
+	bar := c.newProgressBar("db/nightly.dump", 11, 0)
+	io.Copy(io.Discard, bar.proxyReader(strings.NewReader("hello world")))
+	fmt.Fprintln(c.stderr, "Warning: slow")
+	is.Equal("Downloading 1 of 2, db/nightly.dump\n", stdout.String(), "Should not draw bars when not a terminal")
+	is.Equal("Warning: slow\n", stderr.String())
+	bar.finish()
+	is.Equal("Downloading 1 of 2, db/nightly.dump\ndb/nightly.dump  11 B / 11 B  100%  in 0s\n", stdout.String())
+
+	fmt.Fprint(c.stdout, "Done")
+	c.flush()
+	is.True(strings.HasSuffix(stdout.String(), "in 0s\nDone"), "Should write partial lines when flushed")
+}
+
+func TestConsoleOutputTerminal(t *testing.T) {
+	is := assert.New(t)
+	c, stdout, stderr, now := newTestConsole(true)
+	first := c.newProgressBar("a.dump", 100, 0)
+	second := c.newProgressBar("b.dump", 100, 50)
+	is.Equal("a.dump  0 B / 100 B  0%\n"+
+		"\x1b[1A\r\x1b[J"+"a.dump  0 B / 100 B  0%\nb.dump  50 B / 100 B  50%\n", stdout.String())
+
+	stdout.Reset()
+	first.add(10)
+	is.Empty(stdout.String(), "Should not redraw more often than the refresh interval")
+	*now = now.Add(consoleRefreshInterval)
+	first.add(10)
+	is.Equal("\x1b[2A\r\x1b[J"+"a.dump  20 B / 100 B  20%\nb.dump  50 B / 100 B  50%\n", stdout.String())
+
+	stdout.Reset()
+	fmt.Fprintln(c.stderr, "Warning: slow")
+	is.Equal("Warning: slow\n", stderr.String(), "Should write log lines above the bars")
+	is.Equal("\x1b[2A\r\x1b[J"+"a.dump  20 B / 100 B  20%\nb.dump  50 B / 100 B  50%\n", stdout.String())
+
+	stdout.Reset()
+	first.finish()
+	is.Equal("\x1b[2A\r\x1b[J"+"a.dump  20 B / 100 B  20%  in 0s\nb.dump  50 B / 100 B  50%\n", stdout.String(),
+		"Should leave the finished bar's line above the others")
+	stdout.Reset()
+	second.finish()
+	is.Equal("\x1b[1A\r\x1b[J"+"b.dump  50 B / 100 B  50%  in 0s\n", stdout.String())
+	is.Zero(c.drawn)
+}
+
+func TestConsoleOutputConcurrent(t *testing.T) {
+	is := assert.New(t)
+	c, stdout, _, _ := newTestConsole(false)
+	bar := c.newProgressBar("db/nightly.dump", 800, 0)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				fmt.Fprintf(c.stdout, "worker %d line %d\n", w, i)
+				bar.add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	bar.finish()
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	is.Len(lines, 801)
+	for _, line := range lines[:800] {
+		is.Regexp(`^worker \d line \d+$`, line)
+	}
+	is.Equal("db/nightly.dump  800 B / 800 B  100%  in 0s", lines[800])
+}
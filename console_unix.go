@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// isTerminal is whether f is a terminal, which can draw progress bars.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// isTerminal is whether f is a console that can draw progress bars. Escape sequences are turned on for it,
+// older consoles that can't handle them get lines only.
+func isTerminal(f *os.File) bool {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(handle, &mode) != nil {
+		return false
+	}
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// BucketCoverage is how much of a bucket has ever been spot checked, according to the sampling history.
+// Only objects still in the bucket count, so deleted objects don't inflate the coverage.
+type BucketCoverage struct {
+	BucketName      string `json:"bucket_name"`
+	ObjectsVerified int    `json:"objects_verified"`
+	TotalObjects    int    `json:"total_objects"`
+	BytesVerified   int64  `json:"bytes_verified"`
+	TotalBytes      int64  `json:"total_bytes"`
+}
+
+func getCoverageOfBucketsInConfig(ctx context.Context, client *storage.Client, config Config, history *SamplingHistory) (coverage []BucketCoverage, err error) {
+	for _, bucketConfig := range config.Buckets {
+		bucketCtx, err2 := withBucketInventoryReport(withBucketPrefix(ctx, bucketConfig.Prefix), bucketConfig)
+		if err2 != nil {
+			return nil, err2
+		}
+		bucket, err2 := openBackupStore(ctx, client, config, bucketConfig)
+		if err2 != nil {
+			return nil, err2
+		}
+		bucketCoverage, err2 := getBucketCoverage(bucketCtx, bucket, bucketConfig.Name, history.Buckets[bucketConfig.Name])
+		if err2 != nil {
+			err = errors.Annotatef(err2, "Unable to get coverage of bucket %s", bucketConfig.Name)
+			return
+		}
+		coverage = append(coverage, bucketCoverage)
+	}
+	return
+}
+
+// getBucketCoverage lists the whole bucket to compare it against the objects verified so far.
+func getBucketCoverage(ctx context.Context, bucket BackupStore, bucketName string, verified map[string]time.Time) (coverage BucketCoverage, err error) {
+	coverage.BucketName = bucketName
+	it := listObjects(ctx, bucket, nil)
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			markInventoryComplete(ctx)
+			break
+		}
+		if err2 != nil {
+			err = errors.Annotate(err2, "Unable to list bucket to calculate coverage")
+			return
+		}
+		countObjectExamined(ctx, objAttrs)
+		_, wasVerified := verified[objAttrs.Name]
+		coverage.addObject(objAttrs.Size, wasVerified)
+	}
+	return
+}
+
+func (c *BucketCoverage) addObject(size int64, verified bool) {
+	c.TotalObjects++
+	c.TotalBytes += size
+	if verified {
+		c.ObjectsVerified++
+		c.BytesVerified += size
+	}
+}
+
+func (c BucketCoverage) objectPercent() float64 {
+	return percentOf(int64(c.ObjectsVerified), int64(c.TotalObjects))
+}
+
+func (c BucketCoverage) bytePercent() float64 {
+	return percentOf(c.BytesVerified, c.TotalBytes)
+}
+
+func percentOf(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+var coverageHeader = []string{"Bucket", "Objects Verified", "Total Objects", "Objects %", "Bytes Verified", "Total Bytes", "Bytes %"}
+
+// writeCoverage renders the coverage report in the requested format, either an aligned "table" or "csv".
+func writeCoverage(w io.Writer, coverage []BucketCoverage, format string) error {
+	switch format {
+	case "", "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Coverage")
+		for _, heading := range coverageHeader {
+			fmt.Fprint(tw, heading, "\t")
+		}
+		fmt.Fprintln(tw)
+		for _, c := range coverage {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%s\t%s\t%.2f%%\t\n", c.BucketName,
+				c.ObjectsVerified, c.TotalObjects, c.objectPercent(),
+				formatBytes(c.BytesVerified), formatBytes(c.TotalBytes), c.bytePercent())
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(coverageHeader)
+		for _, c := range coverage {
+			cw.Write([]string{c.BucketName,
+				strconv.Itoa(c.ObjectsVerified), strconv.Itoa(c.TotalObjects), strconv.FormatFloat(c.objectPercent(), 'f', 2, 64),
+				strconv.FormatInt(c.BytesVerified, 10), strconv.FormatInt(c.TotalBytes, 10), strconv.FormatFloat(c.bytePercent(), 'f', 2, 64)})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return errors.NotSupportedf("Coverage format %s", format)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketCoverageAddObject(t *testing.T) {
+	is := assert.New(t)
+	coverage := BucketCoverage{BucketName: "test-matt-photos"}
+	is.Equal(0.0, coverage.objectPercent(), "Should not divide by zero for an empty bucket")
+
+	coverage.addObject(300, true)
+	coverage.addObject(100, false)
+	coverage.addObject(600, false)
+	coverage.addObject(0, false)
+	expected := BucketCoverage{BucketName: "test-matt-photos", ObjectsVerified: 1, TotalObjects: 4, BytesVerified: 300, TotalBytes: 1000}
+	is.Equal(expected, coverage)
+	is.Equal(25.0, coverage.objectPercent())
+	is.Equal(30.0, coverage.bytePercent())
+}
+
+func TestWriteCoverage(t *testing.T) {
+	is := assert.New(t)
+	coverage := []BucketCoverage{
+		{BucketName: "test-matt-photos", ObjectsVerified: 1, TotalObjects: 4, BytesVerified: 300, TotalBytes: 1000},
+	}
+
+	var table bytes.Buffer
+	is.NoError(writeCoverage(&table, coverage, "table"))
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	is.Equal(3, len(lines), "Should have a title, header and one line per bucket")
+	is.Contains(lines[2], "25.00%")
+	is.Contains(lines[2], "30.00%")
+
+	var csvOutput bytes.Buffer
+	is.NoError(writeCoverage(&csvOutput, coverage, "csv"))
+	is.Equal("Bucket,Objects Verified,Total Objects,Objects %,Bytes Verified,Total Bytes,Bytes %\n"+
+		"test-matt-photos,1,4,25.00,300,1000,30.00\n", csvOutput.String())
+
+	is.Error(writeCoverage(&table, coverage, "xml"), "Should error on unknown formats")
+}
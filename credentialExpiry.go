@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// incompleteCredentialsExpired is why a run stopped early when its credentials expired and couldn't be refreshed.
+const incompleteCredentialsExpired = "credentials expired"
+
+// isCredentialExpiredError is whether err is cloud storage refusing the credentials, or them failing to refresh,
+// e.g. a short lived federated token outliving its run.
+func isCredentialExpiredError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+		return true
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "oauth2: token expired")
+}
+
+// getObjectAttrsError is why a sampled object's attributes couldn't be read. Only the object not existing, on cloud storage
+// or S3, is treated as it not being found, anything else, e.g. a timeout or expired credentials, is kept so it can be retried
+// or the credentials refreshed, rather than substituted.
+func getObjectAttrsError(err error, remoteFile string) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return errors.NotFoundf("Unable to find file in bucket at %s", remoteFile)
+	}
+	return errors.Annotatef(err, "Unable to get attributes of %s", remoteFile)
+}
+
+// getObjectReaderError is getObjectAttrsError for a sampled object that couldn't be opened to download it.
+func getObjectReaderError(err error, remoteFile string) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return errors.NotFoundf("Unable to download file at %s", remoteFile)
+	}
+	return errors.Annotatef(err, "Unable to download file at %s", remoteFile)
+}
+
+// credentialRefresher reconnects to cloud storage, reading the credentials again from wherever they came from,
+// so a run can get past its first token expiring. connect is newStorageClient outside tests.
+type credentialRefresher struct {
+	connect func(ctx context.Context) (*storage.Client, error)
+	mu      sync.Mutex
+	client  *storage.Client
+}
+
+type credentialRefresherKey struct{}
+
+func newCredentialRefresher(config Config) *credentialRefresher {
+	return &credentialRefresher{connect: func(ctx context.Context) (*storage.Client, error) {
+		return newStorageClient(ctx, config)
+	}}
+}
+
+func withCredentialRefresher(ctx context.Context, refresher *credentialRefresher) context.Context {
+	return context.WithValue(ctx, credentialRefresherKey{}, refresher)
+}
+
+func credentialRefresherFromContext(ctx context.Context) *credentialRefresher {
+	refresher, _ := ctx.Value(credentialRefresherKey{}).(*credentialRefresher)
+	return refresher
+}
+
+// refreshBucket reconnects after expired credentials, err, and returns the bucket on the new connection.
+// It fails with an Unauthorized error when the credentials can't be refreshed. It is safe to call on a nil credentialRefresher,
+// which can't refresh anything.
+func (r *credentialRefresher) refreshBucket(ctx context.Context, bucket BackupStore, err error) (BackupStore, error) {
+	if r == nil {
+		return nil, errors.NewUnauthorized(err, "Credentials expired")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, err2 := r.connect(ctx)
+	if err2 != nil {
+		return nil, errors.NewUnauthorized(err, "Credentials expired and couldn't be refreshed, "+err2.Error())
+	}
+	if r.client != nil {
+		r.client.Close()
+	}
+	r.client = client
+	recordWarning(ctx, "credentials expired, reconnected to cloud storage with refreshed credentials")
+	return newGCSStore(client.Bucket(bucket.BucketName())), nil
+}
+
+// bucket is the named bucket on the last connection refreshBucket made, or on client when it hasn't made one,
+// so buckets after the one whose credentials expired don't start out with them. It is safe to call on a nil credentialRefresher.
+func (r *credentialRefresher) bucket(client *storage.Client, bucketName string) BackupStore {
+	if r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.client != nil {
+			client = r.client
+		}
+	}
+	return newGCSStore(client.Bucket(bucketName))
+}
+
+// close closes the last connection refreshBucket made. It is safe to call on a nil credentialRefresher.
+func (r *credentialRefresher) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+// markIncomplete flags the run as having stopped early for reason, e.g. its credentials expiring.
+// It is safe to call on a nil RunSummary.
+func (rs *RunSummary) markIncomplete(reason string) {
+	if rs != nil {
+		rs.Incomplete = reason
+	}
+}
+
+// markIncompleteIfCredentialsExpired flags the run incomplete when err is its credentials expiring, so the partial
+// summary says why it stopped and a rerun resumes from the in progress file.
+func markIncompleteIfCredentialsExpired(rs *RunSummary, err error) {
+	if errors.IsUnauthorized(err) || isCredentialExpiredError(err) {
+		rs.markIncomplete(incompleteCredentialsExpired)
+	}
+}
+
+// getIncompleteSection says the run stopped early, and that the summary only covers what it got through.
+func getIncompleteSection(rs *RunSummary) (section summarySection) {
+	section.heading = "Incomplete"
+	if len(rs.Incomplete) > 0 {
+		section.items = append(section.items, summaryItem{text: "incomplete: " + rs.Incomplete +
+			", this summary only covers what the run got through before it stopped, rerun to carry on where it stopped"})
+	}
+	return
+}
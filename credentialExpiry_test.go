@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+var testIsCredentialExpiredErrorCases = []struct {
+	err      error
+	expected bool
+}{
+	{nil, false},
+	{errors.New("connection reset by peer"), false},
+	{errors.Annotate(&googleapi.Error{Code: http.StatusUnauthorized}, "Unable to get attributes"), true},
+	{&googleapi.Error{Code: http.StatusForbidden}, false},
+	{errors.Annotate(&oauth2.RetrieveError{}, "Unable to download"), true},
+	{errors.New("Get \"https://storage.googleapis.com/b\": oauth2: token expired and refresh token is not set"), true},
+}
+
+func TestIsCredentialExpiredError(t *testing.T) {
+	is := assert.New(t)
+	for i, tc := range testIsCredentialExpiredErrorCases {
+		is.Equal(tc.expected, isCredentialExpiredError(tc.err), "case %d: %v", i, tc.err)
+	}
+}
+
+var testGetObjectAttrsErrorCases = []struct {
+	err      error
+	notFound bool
+}{
+	{storage.ErrObjectNotExist, true},
+	{errors.Annotate(storage.ErrObjectNotExist, "Unable to list"), true},
+	{errors.New("connection reset by peer"), false},
+	{&googleapi.Error{Code: http.StatusServiceUnavailable}, false},
+	{errors.NewUnauthorized(nil, "S3 refused access"), false},
+}
+
+func TestGetObjectAttrsError(t *testing.T) {
+	is := assert.New(t)
+	for i, tc := range testGetObjectAttrsErrorCases {
+		is.Equal(tc.notFound, errors.IsNotFound(getObjectAttrsError(tc.err, "backup.tar.gz")), "case %d: %v", i, tc.err)
+		is.Equal(tc.notFound, errors.IsNotFound(getObjectReaderError(tc.err, "backup.tar.gz")), "case %d: %v", i, tc.err)
+	}
+}
+
+// flakyStore fails reading the attributes and contents of its objects the first failures times, as a dropped connection would.
+type flakyStore struct {
+	*memoryStore
+	failures int
+}
+
+func (s *flakyStore) Object(name string) BackupObject {
+	return flakyObject{s.memoryStore.Object(name), s}
+}
+
+type flakyObject struct {
+	BackupObject
+	store *flakyStore
+}
+
+func (o flakyObject) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	if o.store.failures > 0 {
+		o.store.failures--
+		return nil, errors.New("connection reset by peer")
+	}
+	return o.BackupObject.Attrs(ctx)
+}
+
+func (o flakyObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if o.store.failures > 0 {
+		o.store.failures--
+		return nil, errors.New("connection reset by peer")
+	}
+	return o.BackupObject.NewRangeReader(ctx, offset, length)
+}
+
+func (o flakyObject) Generation(gen int64) BackupObject {
+	return flakyObject{o.BackupObject.Generation(gen), o.store}
+}
+
+func TestDownloadFilesFromBucketRetriesTransientErrors(t *testing.T) {
+	is := assert.New(t)
+	memory := newMemoryStore("test-matt-server-backups")
+	memory.put("backup.tar.gz", []byte("backup data"), time.Now())
+	memory.put("other.tar.gz", []byte("other data"), time.Now())
+	bs := &BucketSummary{BucketName: "test-matt-server-backups"}
+	ctx := withBucketSummary(context.Background(), bs)
+	config := Config{FileDownloadLocation: t.TempDir(), MaxDownloadRetries: 2, SubstituteMissingObjects: true}
+
+	err := downloadFilesFromBucket(ctx, &flakyStore{memory, 2}, []string{"backup.tar.gz"}, config)
+	is.NoError(err, "Should retry rather than treat the file as missing")
+	is.Equal(1, bs.FilesDownloaded)
+	is.Empty(bs.Substitutions, "Should not substitute a file that is there")
+	_, err = os.Stat(getLocalFilePath(config.FileDownloadLocation, "test-matt-server-backups", "backup.tar.gz", false))
+	is.NoError(err)
+
+	err = downloadFilesFromBucket(ctx, &flakyStore{memory, 5}, []string{"other.tar.gz"}, config)
+	is.Error(err)
+	is.False(errors.IsNotFound(err), "Should give up after the retries, not as missing: %v", err)
+}
+
+// newExpiredTestClient connects to a storage server that refuses every request as unauthorized.
+func newExpiredTestClient(t *testing.T) *storage.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": {"code": 401, "message": "Invalid Credentials"}}`))
+	}))
+	t.Cleanup(server.Close)
+	newClient, err := getClientConstructor(ClientTransport{Endpoint: server.URL + "/storage/v1/"}, false)
+	if err != nil {
+		t.Fatal("Could not connect to expired test storage server", err)
+	}
+	client, err := newClient(context.Background(), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal("Could not connect to expired test storage server", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDownloadFilesFromBucketRefreshesExpiredCredentials(t *testing.T) {
+	is := assert.New(t)
+	content := []byte("backup data")
+	refreshed, _ := newTestStorageServer(t, "test-matt-server-backups", map[string][]byte{"backup.tar.gz": content})
+	refresher := &credentialRefresher{connect: func(ctx context.Context) (*storage.Client, error) { return refreshed, nil }}
+	bs := &BucketSummary{BucketName: "test-matt-server-backups"}
+	ctx := withBucketSummary(withCredentialRefresher(context.Background(), refresher), bs)
+	config := Config{FileDownloadLocation: t.TempDir(), MaxDownloadRetries: 1}
+
+	expired := newGCSStore(newExpiredTestClient(t).Bucket("test-matt-server-backups"))
+	err := downloadFilesFromBucket(ctx, expired, []string{"backup.tar.gz"}, config)
+	is.NoError(err, "Should download with the refreshed credentials")
+	is.Equal(1, bs.FilesDownloaded)
+	is.Len(bs.Warnings, 1)
+	is.Contains(bs.Warnings[0], "credentials expired")
+	is.Equal("test-matt-server-backups", refresher.bucket(nil, "test-matt-server-backups").BucketName())
+	_, err = os.Stat(filepath.Join(config.FileDownloadLocation, "backup.tar.gz"))
+	is.NoError(err)
+}
+
+func TestDownloadFilesFromBucketStopsWhenCredentialsCantRefresh(t *testing.T) {
+	is := assert.New(t)
+	refresher := &credentialRefresher{connect: func(ctx context.Context) (*storage.Client, error) {
+		return nil, errors.New("subject token file expired")
+	}}
+	summary := newRunSummary()
+	bs := summary.bucket("test-matt-server-backups", "server-backup")
+	ctx := withBucketSummary(withCredentialRefresher(context.Background(), refresher), bs)
+	config := Config{FileDownloadLocation: t.TempDir(), MaxDownloadRetries: 3}
+
+	expired := newGCSStore(newExpiredTestClient(t).Bucket("test-matt-server-backups"))
+	err := downloadFilesFromBucket(ctx, expired, []string{"backup.tar.gz", "other.tar.gz"}, config)
+	is.True(errors.IsUnauthorized(err), "Should stop the run: %v", err)
+	is.ErrorContains(err, "subject token file expired")
+	is.Equal(0, bs.FilesFailed, "Should leave the file to the next run rather than fail it")
+
+	markIncompleteIfCredentialsExpired(summary, err)
+	is.Equal(incompleteCredentialsExpired, summary.Incomplete)
+	section := getIncompleteSection(summary)
+	is.Len(section.items, 1)
+	is.Contains(section.items[0].text, "incomplete: credentials expired")
+	is.Equal(runIncomplete, getRunResult(summary, 0, err).Status)
+
+	markIncompleteIfCredentialsExpired(nil, err)
+	other := newRunSummary()
+	markIncompleteIfCredentialsExpired(other, errors.New("disk full"))
+	is.Empty(other.Incomplete)
+	is.Empty(getIncompleteSection(other).items)
+}
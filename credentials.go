@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/juju/errors"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+)
+
+// Environment variables for authenticating without a long lived service account key file,
+// e.g. from a CI job that has exchanged its own identity for a short lived token.
+const (
+	envAccessToken     = "VALIDATEBACKUPS_ACCESS_TOKEN"
+	envCredentialsJson = "VALIDATEBACKUPS_CREDENTIALS_JSON"
+)
+
+// supportedCredentialTypes are the credential json "type" values the google client libraries can use.
+// external_account is workload identity federation, e.g. from GitHub Actions or AWS.
+var supportedCredentialTypes = []string{
+	"service_account", "authorized_user", "external_account", "external_account_authorized_user", "impersonated_service_account",
+}
+
+// getCredentialOptions picks credentials from the environment, an access token first and then credential json.
+// No options means falling back to application default credentials and then the config's google_auth_file_location.
+func getCredentialOptions(getenv func(string) string) (options []option.ClientOption, err error) {
+	if token := getenv(envAccessToken); len(token) > 0 {
+		return []option.ClientOption{option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))}, nil
+	}
+	if credentials := getenv(envCredentialsJson); len(credentials) > 0 {
+		_, err = getCredentialsType([]byte(credentials))
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unusable credentials in %s", envCredentialsJson)
+		}
+		return []option.ClientOption{option.WithCredentialsJSON([]byte(credentials))}, nil
+	}
+	return nil, nil
+}
+
+// getCredentialsType reads the type of a credential json file so a bad one fails clearly rather than deep in the client library.
+func getCredentialsType(credentials []byte) (credentialsType string, err error) {
+	var parsed struct {
+		Type string `json:"type"`
+	}
+	err = json.Unmarshal(credentials, &parsed)
+	if err != nil {
+		return "", errors.NotValidf("Credentials json")
+	}
+	for _, supported := range supportedCredentialTypes {
+		if parsed.Type == supported {
+			return parsed.Type, nil
+		}
+	}
+	return parsed.Type, errors.NotSupportedf("Credentials type %q", parsed.Type)
+}
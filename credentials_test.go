@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testGetCredentialsTypeCases = []struct {
+	credentials string
+	expected    string
+}{
+	{`{"type": "service_account", "project_id": "test"}`, "service_account"},
+	{`{"type": "external_account", "audience": "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/github/providers/github"}`, "external_account"},
+	{`{"type": "authorized_user"}`, "authorized_user"},
+}
+
+func TestGetCredentialsType(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetCredentialsTypeCases {
+		actual, err := getCredentialsType([]byte(tc.credentials))
+		is.NoError(err, "Should accept %s", tc.credentials)
+		is.Equal(tc.expected, actual)
+	}
+
+	_, err := getCredentialsType([]byte("not json"))
+	is.True(errors.IsNotValid(err), "Should error on bad json")
+	_, err = getCredentialsType([]byte(`{"type": "api_key"}`))
+	is.True(errors.IsNotSupported(err), "Should error on a type the client can't use")
+}
+
+func TestGetCredentialOptions(t *testing.T) {
+	is := assert.New(t)
+	options, err := getCredentialOptions(fakeGetenv(nil))
+	is.NoError(err)
+	is.Empty(options, "Should leave it to application default credentials without anything in the environment")
+
+	options, err = getCredentialOptions(fakeGetenv(map[string]string{envAccessToken: "ya29.token"}))
+	is.NoError(err)
+	is.Len(options, 1, "Should use the access token")
+
+	options, err = getCredentialOptions(fakeGetenv(map[string]string{envCredentialsJson: `{"type": "external_account"}`}))
+	is.NoError(err)
+	is.Len(options, 1, "Should use the credentials json")
+
+	_, err = getCredentialOptions(fakeGetenv(map[string]string{envCredentialsJson: `{"type": "api_key"}`}))
+	is.Error(err, "Should error on unusable credentials json")
+}
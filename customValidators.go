@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+const validatorKindExec = "exec"
+
+// customValidatorFunc checks the objects a bucket lists, returning a NotValid error when they fail the check.
+type customValidatorFunc func(ctx context.Context, objects objectIterator) error
+
+// validatorRegistry makes a validator of each kind from its config.
+var validatorRegistry = map[string]func(validator CustomValidator) (customValidatorFunc, error){
+	validatorKindExec: newExecValidator,
+}
+
+// ValidatorObject is an object as a custom validator sees it, one json object per line.
+type ValidatorObject struct {
+	Name         string            `json:"name"`
+	Size         int64             `json:"size"`
+	CRC32C       uint32            `json:"crc32c"`
+	MD5          string            `json:"md5,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	Generation   int64             `json:"generation"`
+	Created      time.Time         `json:"created"`
+	Updated      time.Time         `json:"updated"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// ValidatorOutput is what an exec validator can print, errors fail the bucket and warnings are added to its summary.
+type ValidatorOutput struct {
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+func getValidatorObject(attrs *storage.ObjectAttrs) ValidatorObject {
+	object := ValidatorObject{Name: attrs.Name, Size: attrs.Size, CRC32C: attrs.CRC32C, ContentType: attrs.ContentType,
+		StorageClass: attrs.StorageClass, Generation: attrs.Generation, Created: attrs.Created, Updated: attrs.Updated, Metadata: attrs.Metadata}
+	if len(attrs.MD5) > 0 {
+		object.MD5 = hex.EncodeToString(attrs.MD5)
+	}
+	return object
+}
+
+// getValidatorName is the validator's name, or its command's when it doesn't have one.
+func getValidatorName(validator CustomValidator) string {
+	if len(validator.Name) > 0 || len(validator.Command) == 0 {
+		return validator.Name
+	}
+	return filepath.Base(validator.Command[0])
+}
+
+// runCustomValidators runs each of the bucket's custom validators against a listing of the bucket, stopping at the first that fails.
+func runCustomValidators(ctx context.Context, bucket BackupStore, validators []CustomValidator) error {
+	for _, validator := range validators {
+		newValidator, found := validatorRegistry[validator.Kind]
+		if !found {
+			return errors.NotValidf("Validator %s of kind %q", getValidatorName(validator), validator.Kind)
+		}
+		validate, err := newValidator(validator)
+		if err != nil {
+			return errors.Annotatef(err, "Unable to set up validator %s", getValidatorName(validator))
+		}
+		err = validate(ctx, listObjects(ctx, bucket, nil))
+		if err != nil {
+			return errors.Annotatef(err, "Validator %s", getValidatorName(validator))
+		}
+	}
+	return nil
+}
+
+// newExecValidator pipes the listing to an external command, so checks can be written in any language.
+func newExecValidator(validator CustomValidator) (customValidatorFunc, error) {
+	if len(validator.Command) == 0 {
+		return nil, errors.NotValidf("Exec validator without a command")
+	}
+	timeout := defaultHookTimeout
+	if validator.TimeoutSeconds > 0 {
+		timeout = time.Duration(validator.TimeoutSeconds) * time.Second
+	}
+	return func(ctx context.Context, objects objectIterator) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, validator.Command[0], validator.Command[1:]...)
+		listing, writer := io.Pipe()
+		cmd.Stdin = listing
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		//don't wait on a slow listing once the command has been killed
+		cmd.WaitDelay = time.Second
+		listed := make(chan error, 1)
+		go func() {
+			err := writeValidatorObjects(writer, objects)
+			writer.CloseWithError(err)
+			listed <- err
+		}()
+		err := cmd.Run()
+		//unblock the listing if the command exited without reading all of it
+		listing.Close()
+		if listErr := <-listed; listErr != nil && listErr != io.ErrClosedPipe {
+			return errors.Annotate(listErr, "Unable to list objects for validator")
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.Timeoutf("Validator after %v", timeout)
+		}
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return errors.Annotate(err, "Unable to run validator")
+		}
+		return getExecValidatorResult(ctx, stdout.Bytes(), stderr.String(), cmd.ProcessState.ExitCode())
+	}, nil
+}
+
+func writeValidatorObjects(w io.Writer, objects objectIterator) error {
+	encoder := json.NewEncoder(w)
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(getValidatorObject(attrs))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// getExecValidatorResult fails the bucket when the command exited non zero or printed errors, and records any warnings it printed.
+// Output that isn't json is only shown when the command failed.
+func getExecValidatorResult(ctx context.Context, stdout []byte, stderr string, exitCode int) error {
+	var output ValidatorOutput
+	if len(bytes.TrimSpace(stdout)) > 0 && json.Unmarshal(stdout, &output) != nil {
+		output = ValidatorOutput{}
+		stderr = strings.TrimSpace(string(stdout) + "\n" + stderr)
+	}
+	for _, warning := range output.Warnings {
+		recordWarning(ctx, "%s", warning)
+	}
+	if exitCode == 0 && len(output.Errors) == 0 {
+		return nil
+	}
+	reasons := output.Errors
+	if len(reasons) == 0 {
+		reasons = []string{strings.TrimSpace(stderr)}
+	}
+	message := strings.Join(reasons, "; ")
+	if len(message) > maxHookOutput {
+		message = message[:maxHookOutput] + "..."
+	}
+	return errors.NewNotValid(nil, fmt.Sprintf("Objects failed validation, exit code %d: %s", exitCode, message))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidatorHelperProcess isn't a real test, it stands in for exec validators.
+func TestValidatorHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_VALIDATOR_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	var output ValidatorOutput
+	scanner := bufio.NewScanner(os.Stdin)
+	switch args[1] {
+	case "count":
+		objects := 0
+		for ; scanner.Scan(); objects++ {
+		}
+		output.Warnings = []string{fmt.Sprintf("%d objects listed", objects)}
+	case "reject-tmp":
+		for scanner.Scan() {
+			var object ValidatorObject
+			json.Unmarshal(scanner.Bytes(), &object)
+			if strings.HasSuffix(object.Name, ".tmp") {
+				output.Errors = append(output.Errors, "left over "+object.Name)
+			}
+		}
+	case "crash":
+		fmt.Fprintln(os.Stderr, "boom")
+		os.Exit(3)
+	case "ignore":
+		os.Exit(0)
+	case "hang":
+		select {}
+	}
+	json.NewEncoder(os.Stdout).Encode(output)
+	os.Exit(0)
+}
+
+func getHelperValidator(mode string, timeoutSeconds int) CustomValidator {
+	return CustomValidator{Kind: validatorKindExec, TimeoutSeconds: timeoutSeconds,
+		Command: []string{os.Args[0], "-test.run=TestValidatorHelperProcess", "--", mode}}
+}
+
+func getTestValidatorContext(bs *BucketSummary, names ...string) context.Context {
+	report := &inventoryReport{}
+	for _, name := range names {
+		report.objects = append(report.objects, &storage.ObjectAttrs{Name: name, Size: 10, MD5: []byte{0xab, 0xcd}})
+	}
+	return withInventoryReport(withBucketSummary(context.Background(), bs), report)
+}
+
+// testRunCustomValidatorsCases time out after 30s, long enough for a loaded machine, except hang, which waits for its timeout.
+var testRunCustomValidatorsCases = []struct {
+	mode           string
+	timeoutSeconds int
+	expected       func(error) bool
+	message        string
+}{
+	{"count", 30, func(err error) bool { return err == nil }, ""},
+	{"reject-tmp", 30, errors.IsNotValid, "Validator reject: Objects failed validation, exit code 0: left over 2018-02/upload.tmp"},
+	{"crash", 30, errors.IsNotValid, "exit code 3: boom"},
+	{"ignore", 30, func(err error) bool { return err == nil }, ""},
+	{"hang", 1, errors.IsTimeout, "Validator after 1s"},
+}
+
+func TestRunCustomValidators(t *testing.T) {
+	is := assert.New(t)
+	os.Setenv("GO_WANT_VALIDATOR_HELPER_PROCESS", "1")
+	defer os.Unsetenv("GO_WANT_VALIDATOR_HELPER_PROCESS")
+	names := []string{"2018-01/IMG_01.jpg", "2018-02/upload.tmp"}
+	for i := 0; i < 5000; i++ {
+		names = append(names, fmt.Sprintf("2018-03/IMG_%04d.jpg", i))
+	}
+	for _, tc := range testRunCustomValidatorsCases {
+		validator := getHelperValidator(tc.mode, tc.timeoutSeconds)
+		validator.Name = strings.Split(tc.mode, "-")[0]
+		err := runCustomValidators(getTestValidatorContext(&BucketSummary{}, names...), nil, []CustomValidator{validator})
+		is.True(tc.expected(err), "case %s: %v", tc.mode, err)
+		if len(tc.message) > 0 {
+			is.ErrorContains(err, tc.message, "case %s", tc.mode)
+		}
+	}
+
+	bs := &BucketSummary{}
+	err := runCustomValidators(getTestValidatorContext(bs, names...), nil, []CustomValidator{getHelperValidator("count", 0)})
+	is.NoError(err)
+	is.Equal([]string{"5002 objects listed"}, bs.Warnings, "Should pipe every object and record the validator's warnings")
+
+	err = runCustomValidators(getTestValidatorContext(bs), nil, []CustomValidator{{Name: "lint", Kind: "lua"}})
+	is.True(errors.IsNotValid(err), "Should error on a kind of validator that doesn't exist")
+	err = runCustomValidators(getTestValidatorContext(bs), nil, []CustomValidator{{Kind: validatorKindExec}})
+	is.True(errors.IsNotValid(err), "Should error on an exec validator without a command")
+}
+
+func TestGetValidatorObject(t *testing.T) {
+	is := assert.New(t)
+	object := getValidatorObject(&storage.ObjectAttrs{Name: "backup.tar.gz", Size: 10, CRC32C: 42, MD5: []byte{0xab, 0xcd}, StorageClass: "NEARLINE"})
+	is.Equal(ValidatorObject{Name: "backup.tar.gz", Size: 10, CRC32C: 42, MD5: "abcd", StorageClass: "NEARLINE"}, object)
+	is.Equal("check.py", getValidatorName(CustomValidator{Command: []string{"/opt/checks/check.py", "--strict"}}))
+	is.Equal("lint", getValidatorName(CustomValidator{Name: "lint", Command: []string{"check.py"}}))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// validateDeletedVersionRestorable reads back a random noncurrent version deleted in the last maxAgeInDays days,
+// proving an overwritten or deleted backup can actually be recovered rather than just that the current objects exist.
+func validateDeletedVersionRestorable(ctx context.Context, bucket BackupStore, maxAgeInDays int, now time.Time) error {
+	bucketAttrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return errors.Annotate(err, "Unable to load bucket details to check versioning")
+	}
+	if !bucketAttrs.VersioningEnabled {
+		return errors.NotValidf("Bucket %s does not have versioning enabled, deleted backups cannot be restored", bucketAttrs.Name)
+	}
+
+	var versions []*storage.ObjectAttrs
+	it := listObjects(ctx, bucket, &storage.Query{Versions: true})
+	for {
+		objAttrs, err := it.Next()
+		if err == iterator.Done {
+			markInventoryComplete(ctx)
+			break
+		}
+		if err != nil {
+			return errors.Annotate(err, "Unable to list object versions")
+		}
+		countObjectExamined(ctx, objAttrs)
+		versions = append(versions, objAttrs)
+	}
+
+	version := pickDeletedVersion(versions, now.AddDate(0, 0, -maxAgeInDays))
+	if version == nil {
+		return errors.NotFoundf("Noncurrent version deleted in the last %d days", maxAgeInDays)
+	}
+	fmt.Fprintln(console.stdout, fmt.Sprintf("Checking deleted version %d of %s can be restored.", version.Generation, version.Name))
+	return verifyObjectVersionReadable(ctx, bucket, version)
+}
+
+// pickDeletedVersion chooses a random noncurrent version deleted after since, or nil if there are none.
+func pickDeletedVersion(versions []*storage.ObjectAttrs, since time.Time) *storage.ObjectAttrs {
+	var candidates []*storage.ObjectAttrs
+	for _, version := range versions {
+		if !version.Deleted.IsZero() && version.Deleted.After(since) {
+			candidates = append(candidates, version)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// verifyObjectVersionReadable reads the whole of a specific object version and checks it against the stored CRC32C.
+func verifyObjectVersionReadable(ctx context.Context, bucket BackupStore, version *storage.ObjectAttrs) error {
+	rc, err := bucket.Object(version.Name).Generation(version.Generation).NewReader(ctx)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to read version %d of %s", version.Generation, version.Name)
+	}
+	defer rc.Close()
+
+	hash := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	written, err := io.Copy(hash, rc)
+	if err != nil {
+		return errors.Annotatef(err, "Error reading version %d of %s", version.Generation, version.Name)
+	}
+	countBytesDownloaded(ctx, written)
+	if hash.Sum32() != version.CRC32C {
+		return errors.NotValidf("Version %d of %s CRC32C", version.Generation, version.Name)
+	}
+	return nil
+}
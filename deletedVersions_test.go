@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickDeletedVersion(t *testing.T) {
+	is := assert.New(t)
+	since := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := &storage.ObjectAttrs{Name: "old.tar.gz", Generation: 2, Deleted: since.AddDate(0, 0, 5)}
+	versions := []*storage.ObjectAttrs{
+		{Name: "current.tar.gz", Generation: 1},
+		{Name: "ancient.tar.gz", Generation: 3, Deleted: since.AddDate(0, 0, -5)},
+		recent,
+	}
+
+	for i := 0; i < 10; i++ {
+		is.Equal(recent, pickDeletedVersion(versions, since), "Should only pick versions deleted after since")
+	}
+	is.Nil(pickDeletedVersion(versions[:2], since), "Should pick nothing when no versions were deleted recently")
+	is.Nil(pickDeletedVersion(nil, since), "Should pick nothing from an empty bucket")
+}
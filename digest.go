@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// digestRenderers write the digest's sections in one format, by name.
+var digestRenderers = map[string]func(w io.Writer, section summarySection){
+	"table":    writeSummarySection,
+	"markdown": writeMarkdownSection,
+}
+
+// runDigest summarises the runs of the last few days from the audit log and sampling history, without connecting to cloud storage,
+// for a weekly post or email rather than one per run.
+func runDigest(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	days := flags.Int("days", 7, "how many days back the digest covers")
+	format := flags.String("format", "table", "format of the digest, table or markdown")
+	email := flags.Bool("email", false, "also email the digest to the configured email recipients")
+	return func(ctx context.Context) {
+		if *days <= 0 {
+			log.Fatal("The digest needs --days of 1 or more.")
+		}
+		config, err := loadConfiguration(*configPath, os.Stdin, os.Getenv)
+		logFatalIfErr(err, "Unable to load configuration from file.")
+		entries, auditErr := loadAuditLogForDigest(getAuditLogFilePath(config))
+		history, err := loadSamplingHistory(getSamplingHistoryFilePath(config))
+		logFatalIfErr(err, "Unable to load sampling history.")
+
+		now := time.Now()
+		sections := getDigestSections(entries, auditErr, history, now.AddDate(0, 0, -*days), now)
+		err = writeDigest(console.stdout, sections, *format)
+		logFatalIfErr(err, "Unable to print digest.")
+		if *email {
+			var body bytes.Buffer
+			writeDigest(&body, sections, "table")
+			subject := fmt.Sprintf("Backups digest, %d days to %s", *days, formatTime(now))
+			err = sendEmail(config.Email, subject, body.Bytes(), os.Getenv, smtp.SendMail, now)
+			logFatalIfErr(err, "Unable to email digest.")
+		}
+	}
+}
+
+// loadAuditLogForDigest reads the audit log, a missing one having no runs. Entries before any break in the chain are kept.
+func loadAuditLogForDigest(filePath string) ([]AuditEntry, error) {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to open audit log %s", filePath)
+	}
+	defer file.Close()
+	return readAuditLog(file)
+}
+
+// writeDigest writes each of the digest's sections in the requested format, an aligned "table" by default, or "markdown".
+func writeDigest(w io.Writer, sections []summarySection, format string) error {
+	if len(format) == 0 {
+		format = "table"
+	}
+	render, found := digestRenderers[format]
+	if !found {
+		return errors.NotSupportedf("Digest format %s", format)
+	}
+	for _, section := range sections {
+		render(w, section)
+	}
+	return nil
+}
+
+// getDigestSections summarises the runs finished between from and to, then each bucket's freshness, growth and coverage
+// from the snapshots the sampling history took of it in that time. auditErr is why the audit log couldn't be read in full.
+func getDigestSections(entries []AuditEntry, auditErr error, history *SamplingHistory, from, to time.Time) []summarySection {
+	inWindow := func(when time.Time) bool {
+		return !when.Before(from) && !when.After(to)
+	}
+	runs := summarySection{heading: fmt.Sprintf("Runs from %s to %s", formatTime(from), formatTime(to))}
+	failures := summarySection{heading: "Failed runs"}
+	if auditErr != nil {
+		failures.items = append(failures.items, summaryItem{text: "the audit log doesn't check out, runs after the problem are left out", detail: auditErr.Error()})
+	}
+	var total RunResult
+	statuses := make(map[string]int)
+	finished := 0
+	for _, entry := range entries {
+		if entry.Event != auditRunFinished || entry.Result == nil || !inWindow(entry.Time) {
+			continue
+		}
+		finished++
+		result := entry.Result
+		statuses[result.Status]++
+		total.FilesDownloaded += result.FilesDownloaded
+		total.Bytes += result.Bytes
+		total.Warnings += result.Warnings
+		if result.Status != validationPassed {
+			text := fmt.Sprintf("%s %s: %s, %d buckets failed", formatTime(entry.Time), entry.RunID, result.Status, result.BucketsFailed)
+			failures.items = append(failures.items, summaryItem{text: text, detail: result.Error})
+		}
+	}
+	runs.items = append(runs.items,
+		summaryItem{text: fmt.Sprintf("%d runs, %d passed, %d failed, %d incomplete",
+			finished, statuses[validationPassed], finished-statuses[validationPassed]-statuses[runIncomplete], statuses[runIncomplete])},
+		summaryItem{text: fmt.Sprintf("%d files downloaded and verified, %s", total.FilesDownloaded, formatBytes(total.Bytes))},
+		summaryItem{text: fmt.Sprintf("%d warnings", total.Warnings)})
+
+	freshness := summarySection{heading: "Freshness"}
+	growth := summarySection{heading: "Growth"}
+	coverage := summarySection{heading: "Coverage"}
+	for _, bucketName := range getDigestBucketNames(history) {
+		var snapshots []InventorySnapshot
+		for _, snapshot := range history.Inventories[bucketName] {
+			if inWindow(snapshot.Taken) {
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+		if len(snapshots) > 0 {
+			freshness.items = append(freshness.items, describeDigestFreshness(bucketName, snapshots)...)
+			growth.items = append(growth.items, describeDigestGrowth(bucketName, snapshots))
+		}
+		coverage.items = append(coverage.items, describeDigestCoverage(bucketName, history, snapshots, inWindow))
+	}
+	return []summarySection{runs, failures, freshness, growth, coverage}
+}
+
+// getDigestBucketNames lists every bucket the history has verified objects in or snapshots of, sorted.
+func getDigestBucketNames(history *SamplingHistory) (names []string) {
+	seen := make(map[string]bool)
+	for name := range history.Buckets {
+		seen[name] = true
+	}
+	for name := range history.Inventories {
+		seen[name] = true
+	}
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// describeDigestFreshness compares how old the bucket's newest object was at its first and last snapshots,
+// and how many of the snapshots failed validation.
+func describeDigestFreshness(bucketName string, snapshots []InventorySnapshot) (items []summaryItem) {
+	var dated []InventorySnapshot
+	failed := 0
+	for _, snapshot := range snapshots {
+		if !snapshot.NewestObject.IsZero() {
+			dated = append(dated, snapshot)
+		}
+		if snapshot.ValidationResult == validationFailed {
+			failed++
+		}
+	}
+	if len(dated) > 0 {
+		first, last := dated[0], dated[len(dated)-1]
+		firstAge, lastAge := first.Taken.Sub(first.NewestObject), last.Taken.Sub(last.NewestObject)
+		trend := "steady"
+		switch {
+		case len(dated) == 1:
+			trend = "one run"
+		case lastAge > firstAge+time.Hour:
+			trend = "less fresh"
+		case lastAge < firstAge-time.Hour:
+			trend = "fresher"
+		}
+		items = append(items, summaryItem{text: fmt.Sprintf("%s: newest object %s old, was %s, %s",
+			bucketName, formatAge(lastAge), formatAge(firstAge), trend)})
+	}
+	if failed > 0 {
+		items = append(items, summaryItem{text: fmt.Sprintf("%s: failed validation in %d of %d runs", bucketName, failed, len(snapshots))})
+	}
+	return
+}
+
+// describeDigestGrowth is the change between the bucket's first and last snapshots taken by the same profile as the last one,
+// so a deep run listing more of the bucket isn't mistaken for growth.
+func describeDigestGrowth(bucketName string, snapshots []InventorySnapshot) summaryItem {
+	last := snapshots[len(snapshots)-1]
+	first := last
+	for _, snapshot := range snapshots {
+		if snapshot.Profile == last.Profile {
+			first = snapshot
+			break
+		}
+	}
+	return summaryItem{text: fmt.Sprintf("%s: %d objects, %s, %s", bucketName, last.Objects, formatBytes(last.Bytes),
+		describeInventoryChange(first.InventoryTotals, last.InventoryTotals, true))}
+}
+
+// describeDigestCoverage counts the bucket's objects verified in the digest's days, and overall against its last complete inventory.
+func describeDigestCoverage(bucketName string, history *SamplingHistory, snapshots []InventorySnapshot, inWindow func(time.Time) bool) summaryItem {
+	recent := 0
+	for _, verified := range history.Buckets[bucketName] {
+		if inWindow(verified) {
+			recent++
+		}
+	}
+	text := fmt.Sprintf("%s: %d objects verified", bucketName, recent)
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Complete {
+			overall := len(history.Buckets[bucketName])
+			text += fmt.Sprintf(", %d of %d verified so far, %.2f%%", overall, snapshots[i].Objects,
+				percentOf(int64(overall), int64(snapshots[i].Objects)))
+			break
+		}
+	}
+	return summaryItem{text: text}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestDigestHistory(now time.Time) *SamplingHistory {
+	history := &SamplingHistory{Buckets: map[string]map[string]time.Time{
+		"test-bucket": {"show/a": now.AddDate(0, 0, -2), "show/b": now.AddDate(0, 0, -20)},
+	}}
+	for i, objects := range []int{1, 2, 3} {
+		summary := getTestInventorySummary(objects, int64(objects*100))
+		bs := summary.Buckets[0]
+		taken := now.AddDate(0, 0, 2*i-6)
+		bs.NewestObject = taken.Add(-time.Duration(i+1) * 12 * time.Hour)
+		bs.Inventory.Complete = true
+		bs.ValidationResult = validationPassed
+		if i == 1 {
+			bs.ValidationResult = validationFailed
+		}
+		history.recordInventory(summary, string(rune('a'+i)), "", taken)
+	}
+	history.recordInventory(getTestInventorySummary(9, 900), "old", "", now.AddDate(0, 0, -30))
+	return history
+}
+
+func TestGetDigestSections(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	entries := []AuditEntry{
+		{Time: now.AddDate(0, 0, -10), RunID: "old", Event: auditRunFinished, Result: &RunResult{Status: validationFailed}},
+		{Time: now.AddDate(0, 0, -5), RunID: "a", Event: auditRunStarted},
+		{Time: now.AddDate(0, 0, -5), RunID: "a", Event: auditRunFinished,
+			Result: &RunResult{Status: validationPassed, FilesDownloaded: 3, Bytes: 2048, Warnings: 1}},
+		{Time: now.AddDate(0, 0, -3), RunID: "b", Event: auditRunFinished,
+			Result: &RunResult{Status: validationFailed, BucketsFailed: 1, FilesDownloaded: 1, Bytes: 1024, Error: "bucket failed"}},
+		{Time: now.AddDate(0, 0, -1), RunID: "c", Event: auditRunFinished, Result: &RunResult{Status: runIncomplete}},
+	}
+	sections := getDigestSections(entries, nil, getTestDigestHistory(now), now.AddDate(0, 0, -7), now)
+	is.Len(sections, 5)
+
+	runs, failures, freshness, growth, coverage := sections[0], sections[1], sections[2], sections[3], sections[4]
+	is.Equal("3 runs, 1 passed, 1 failed, 1 incomplete", runs.items[0].text)
+	is.Equal("4 files downloaded and verified, 3.0 KiB", runs.items[1].text)
+	is.Equal("1 warnings", runs.items[2].text)
+
+	is.Len(failures.items, 2, "Should leave out passed runs and runs before the digest")
+	is.Contains(failures.items[0].text, "b: failed, 1 buckets failed")
+	is.Equal("bucket failed", failures.items[0].detail)
+	is.Contains(failures.items[1].text, "c: incomplete")
+
+	is.Equal([]summaryItem{
+		{text: "test-bucket: newest object 36h0m0s old, was 12h0m0s, less fresh"},
+		{text: "test-bucket: failed validation in 1 of 3 runs"},
+	}, freshness.items)
+	is.Equal([]summaryItem{{text: "test-bucket: 3 objects, 300 B, +2 objects, +200 B"}}, growth.items,
+		"Should compare the first and last snapshots in the digest")
+	is.Equal([]summaryItem{{text: "test-bucket: 1 objects verified, 2 of 3 verified so far, 66.67%"}}, coverage.items)
+}
+
+func TestGetDigestSectionsWithoutHistory(t *testing.T) {
+	is := assert.New(t)
+	now := time.Now()
+	auditErr := errors.NotValidf("Audit log entry on line 3, its hash doesn't match, it was edited")
+	sections := getDigestSections(nil, auditErr, &SamplingHistory{}, now.AddDate(0, 0, -7), now)
+	is.Equal("0 runs, 0 passed, 0 failed, 0 incomplete", sections[0].items[0].text)
+	is.Len(sections[1].items, 1, "Should say the audit log doesn't check out")
+	is.Contains(sections[1].items[0].detail, "line 3")
+	is.Empty(sections[2].items)
+	is.Empty(sections[4].items)
+}
+
+func TestWriteDigest(t *testing.T) {
+	is := assert.New(t)
+	sections := []summarySection{{heading: "Growth", items: []summaryItem{{text: "test_bucket: 3 objects"}}}, {heading: "Coverage"}}
+	var output bytes.Buffer
+	is.NoError(writeDigest(&output, sections, ""))
+	is.Equal("\nGrowth:\ntest_bucket: 3 objects\n", output.String())
+
+	output.Reset()
+	is.NoError(writeDigest(&output, sections, "markdown"))
+	is.Equal("\n### Growth\n\n- test\\_bucket: 3 objects\n", output.String())
+
+	is.True(errors.IsNotSupported(writeDigest(&output, sections, "csv")))
+}
+
+func TestLoadAuditLogForDigest(t *testing.T) {
+	is := assert.New(t)
+	entries, err := loadAuditLogForDigest(t.TempDir() + "/audit.jsonl")
+	is.NoError(err, "Should treat a missing audit log as no runs")
+	is.Empty(entries)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// getFreeDiskSpace is how many bytes an unprivileged user can still write to the file system dir is on.
+func getFreeDiskSpace(dir string) (free uint64, err error) {
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
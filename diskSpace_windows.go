@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// getFreeDiskSpace is how many bytes the current user can still write to the volume dir is on, honouring quotas.
+func getFreeDiskSpace(dir string) (free uint64, err error) {
+	dirPtr, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var total, totalFree uint64
+	err = windows.GetDiskFreeSpaceEx(dirPtr, &free, &total, &totalFree)
+	return
+}
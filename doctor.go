@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// doctorMinFreeBytes is the least free space in the download location that doesn't get a warning,
+// enough for a typical sample but not for every configured max_file_size_bytes.
+const doctorMinFreeBytes = 1 << 30
+
+// doctorBucketTimeout stops an unreachable endpoint from hanging the diagnostics.
+const doctorBucketTimeout = 30 * time.Second
+
+// DoctorCheck is the outcome of one diagnostic. Detail says what was found,
+// Err is set when the check failed and Advice then says what to do about it.
+type DoctorCheck struct {
+	Name   string
+	Detail string
+	Err    error
+	Advice string
+}
+
+func (c DoctorCheck) passed() bool {
+	return c.Err == nil
+}
+
+// runDoctor checks the config, credentials, buckets and local directories, exiting non zero if anything is wrong.
+func runDoctor(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	oneshot := flags.Bool("oneshot", os.Getenv(envOneshot) == "true",
+		"check the config taken from VALIDATEBACKUPS_* environment variables instead of a config file")
+	return func(ctx context.Context) {
+		err := writeVersion(console.stdout, getBuildInfo(), getEnabledProviders())
+		logFatalIfErr(err, "Unable to print version.")
+		fmt.Fprintln(console.stdout)
+
+		checks := runDoctorChecks(ctx, *configPath, *oneshot)
+		err = writeDoctorResults(console.stdout, checks)
+		logFatalIfErr(err, "Unable to print diagnostics.")
+		for _, check := range checks {
+			if !check.passed() {
+				flushTracing()
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// runVersion prints build details of the binary and the storage providers it supports.
+func runVersion(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	return func(ctx context.Context) {
+		err := writeVersion(console.stdout, getBuildInfo(), getEnabledProviders())
+		logFatalIfErr(err, "Unable to print version.")
+	}
+}
+
+// runDoctorChecks runs every diagnostic it can, a check is skipped when one it depends on failed.
+func runDoctorChecks(ctx context.Context, configPath string, oneshot bool) (checks []DoctorCheck) {
+	var config Config
+	var err error
+	if oneshot {
+		config, err = getConfigFromEnv(os.Getenv)
+	} else {
+		config, err = loadConfiguration(configPath, os.Stdin, os.Getenv)
+	}
+	configCheck := DoctorCheck{Name: "config", Detail: fmt.Sprintf("%d buckets", len(config.Buckets)), Err: err}
+	if err != nil {
+		configCheck.Detail = configPath
+		configCheck.Advice = "Check the config file exists and is valid json, or pass --config with its path."
+		if oneshot {
+			configCheck.Detail = "environment"
+			configCheck.Advice = "Check VALIDATEBACKUPS_BUCKETS lists buckets as name=type."
+		}
+		return append(checks, configCheck)
+	}
+	checks = append(checks, configCheck)
+
+	//the connectivity, clock and credentials checks are of google cloud storage, s3 buckets are only checked themselves
+	var client *storage.Client
+	gcsReachable := false
+	if usesGCS(config.Buckets) {
+		skew, err := checkConnectivity(ctx, config.Transport, os.Getenv)
+		connectivityCheck := DoctorCheck{Name: "connectivity", Err: err}
+		if err == nil {
+			connectivityCheck.Detail = "reachable"
+		} else {
+			connectivityCheck.Advice = "Check HTTPS_PROXY or the transport proxy in the config, and that a proxy intercepting TLS has its CA certificate trusted."
+		}
+		checks = append(checks, connectivityCheck)
+		if skew.measured {
+			checks = append(checks, checkClock(skew, config))
+		}
+
+		client, err = newStorageClient(ctx, config)
+		credentialsCheck := DoctorCheck{Name: "credentials", Err: err}
+		if err == nil {
+			credentialsCheck.Detail = "loaded"
+			defer client.Close()
+		} else {
+			credentialsCheck.Advice = "Set GOOGLE_APPLICATION_CREDENTIALS or google_auth_file_location to a credentials file, " +
+				"or run gcloud auth application-default login."
+		}
+		checks = append(checks, credentialsCheck)
+		//each bucket would only time out without connectivity
+		gcsReachable = connectivityCheck.passed() && credentialsCheck.passed()
+	}
+	for _, bucketConfig := range config.Buckets {
+		if bucketConfig.Provider == "s3" || gcsReachable {
+			checks = append(checks, checkBucketReachable(ctx, client, config, bucketConfig))
+		}
+	}
+
+	downloadDirectory := config.FileDownloadLocation
+	if len(downloadDirectory) == 0 {
+		downloadDirectory = "."
+	}
+	checks = append(checks, checkDownloadLocation(downloadDirectory, config.AllowSyncedDownloadLocation))
+	checks = append(checks, checkDirectoryWritable("state directory", getStateDirectory(config), "state_directory"))
+	checks = append(checks, checkFreeSpace(downloadDirectory, doctorMinFreeBytes, getFreeDiskSpace))
+	return
+}
+
+func checkBucketReachable(ctx context.Context, client *storage.Client, config Config, bucketConfig BucketToProcess) (check DoctorCheck) {
+	check.Name = "bucket " + bucketConfig.Name
+	ctx, cancel := context.WithTimeout(ctx, doctorBucketTimeout)
+	defer cancel()
+	reachAdvice := fmt.Sprintf("Check this machine can reach storage.googleapis.com and the credentials can read bucket %s.", bucketConfig.Name)
+	if bucketConfig.Provider == "s3" {
+		reachAdvice = fmt.Sprintf("Check the s3 region and endpoint in the config, and that %s and %s can read bucket %s.",
+			envAWSAccessKeyID, envAWSSecretAccessKey, bucketConfig.Name)
+	}
+	bucket, err := openBackupStore(ctx, client, config, bucketConfig)
+	if err != nil {
+		check.Err = err
+		check.Advice = reachAdvice
+		return
+	}
+	attrs, err := bucket.Attrs(ctx)
+	switch {
+	case err == storage.ErrBucketNotExist:
+		check.Err = errors.NotFoundf("Bucket %s", bucketConfig.Name)
+		check.Advice = "Check the bucket name in the config."
+	case err != nil:
+		check.Err = err
+		check.Advice = reachAdvice
+	case len(attrs.StorageClass) == 0:
+		check.Detail = strings.ToLower(attrs.Location)
+	default:
+		check.Detail = fmt.Sprintf("%s, %s", strings.ToLower(attrs.Location), attrs.StorageClass)
+	}
+	return
+}
+
+// checkDirectoryWritable creates dir if needed and writes a scratch file to it, as downloads and state saves would.
+func checkDirectoryWritable(name string, dir string, configKey string) (check DoctorCheck) {
+	check.Name = name
+	check.Detail = dir
+	check.Advice = fmt.Sprintf("Create %s or set %s to a directory this user can write to.", dir, configKey)
+	check.Err = os.MkdirAll(dir, 0755)
+	if check.Err != nil {
+		return
+	}
+	check.Err = checkWritable(dir)
+	return
+}
+
+// checkDownloadLocation runs the checks a run makes of the download location before it starts, see validateDownloadLocation.
+func checkDownloadLocation(dir string, allowSynced bool) (check DoctorCheck) {
+	check.Name = "download directory"
+	check.Detail, check.Err = validateDownloadLocation(dir, allowSynced)
+	if check.Err != nil {
+		check.Detail = dir
+		check.Advice = "Set file_download_location to a local directory this user can write to."
+	}
+	return
+}
+
+func checkFreeSpace(dir string, minFree uint64, getFree func(dir string) (uint64, error)) (check DoctorCheck) {
+	check.Name = "free space"
+	free, err := getFree(dir)
+	if err != nil {
+		check.Err = err
+		check.Advice = fmt.Sprintf("Check %s exists.", dir)
+		return
+	}
+	check.Detail = fmt.Sprintf("%s in %s", formatBytes(int64(free)), dir)
+	if free < minFree {
+		check.Err = errors.NotValidf("Only %s free", formatBytes(int64(free)))
+		check.Advice = fmt.Sprintf("Free up at least %s, or set file_download_location to a bigger disk.", formatBytes(int64(minFree)))
+	}
+	return
+}
+
+// checkClock fails when the local clock is further from cloud storage's than max_clock_skew_seconds allows.
+func checkClock(skew clockSkew, config Config) (check DoctorCheck) {
+	check.Name = "clock"
+	skew.max = getMaxClockSkew(config)
+	check.Detail = skew.String()
+	if skew.excessive() {
+		check.Err = errors.NotValidf("Clock, more than %s off", skew.max)
+		check.Advice = "Sync the time, e.g. turn on NTP, freshness checks judge backup ages by the local clock."
+	}
+	return
+}
+
+func writeDoctorResults(w io.Writer, checks []DoctorCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Check\tResult\tDetails\t")
+	for _, check := range checks {
+		outcome := validationPassed
+		if !check.passed() {
+			outcome = validationFailed
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t\n", check.Name, outcome, check.Detail)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if check.passed() {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s: %s\n  %s\n", check.Name, check.Err, check.Advice)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDirectoryWritable(t *testing.T) {
+	is := assert.New(t)
+	dir := filepath.Join(t.TempDir(), "downloads")
+	check := checkDirectoryWritable("state directory", dir, "state_directory")
+	is.True(check.passed(), "Should create a missing directory")
+	is.DirExists(dir)
+	entries, _ := os.ReadDir(dir)
+	is.Empty(entries, "Should clean up the scratch file")
+
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, []byte("x"), 0644)
+	check = checkDirectoryWritable("state directory", file, "state_directory")
+	is.False(check.passed(), "Should fail when the directory is a file")
+	is.Contains(check.Advice, "state_directory")
+}
+
+func TestCheckDownloadLocation(t *testing.T) {
+	is := assert.New(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	is.NoError(err)
+	check := checkDownloadLocation(dir, false)
+	is.True(check.passed())
+	is.Equal(dir, check.Detail)
+
+	check = checkDownloadLocation(filepath.Join(dir, "Dropbox"), false)
+	is.False(check.passed(), "Should fail in a synced folder")
+	is.Contains(check.Advice, "file_download_location")
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	is := assert.New(t)
+	plenty := func(dir string) (uint64, error) { return 10 << 30, nil }
+	is.True(checkFreeSpace("downloads", 1<<30, plenty).passed())
+
+	little := func(dir string) (uint64, error) { return 1 << 20, nil }
+	check := checkFreeSpace("downloads", 1<<30, little)
+	is.True(errors.IsNotValid(check.Err), "Should fail when there is too little space")
+	is.Contains(check.Advice, "1.0 GiB")
+
+	broken := func(dir string) (uint64, error) { return 0, os.ErrNotExist }
+	is.False(checkFreeSpace("downloads", 1<<30, broken).passed())
+
+	free, err := getFreeDiskSpace(t.TempDir())
+	is.NoError(err, "Should find the free space of a real directory")
+	is.NotZero(free)
+}
+
+func TestRunDoctorChecksBadConfig(t *testing.T) {
+	is := assert.New(t)
+	checks := runDoctorChecks(context.Background(), filepath.Join("testdata", "doesNotExist.json"), false)
+	is.Len(checks, 1, "Should stop when the config can't be loaded")
+	is.Equal("config", checks[0].Name)
+	is.False(checks[0].passed())
+	is.Contains(checks[0].Advice, "--config")
+}
+
+func TestWriteDoctorResults(t *testing.T) {
+	is := assert.New(t)
+	var actual bytes.Buffer
+	err := writeDoctorResults(&actual, []DoctorCheck{
+		{Name: "config", Detail: "3 buckets"},
+		{Name: "free space", Detail: "1.0 MiB in downloads", Err: errors.NotValidf("Only 1.0 MiB free"), Advice: "Free up some space."},
+	})
+	is.NoError(err)
+	is.Equal(`Check       Result  Details               
+config      passed  3 buckets             
+free space  failed  1.0 MiB in downloads  
+
+free space: Only 1.0 MiB free not valid
+  Free up some space.
+`, actual.String())
+}
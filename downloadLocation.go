@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// syncedFolderNames are folders that sync clients keep in the cloud. Downloading every sampled backup into one
+// uploads it all again, and the sync client can lock or replace files while they are being verified.
+// OneDrive folders of work accounts are named like "OneDrive - Company", so names match by prefix.
+var syncedFolderNames = []string{"dropbox", "onedrive", "google drive", "my drive", "icloud drive", "mobile documents", "box sync", "pcloud drive"}
+
+// syncedFolderMarkers are files sync clients leave in the root of the folder they sync, for when it has been renamed.
+var syncedFolderMarkers = []string{".dropbox", ".dropbox.cache"}
+
+// validateDownloadLocation checks the download location can be saved to before the run starts, rather than when
+// the first download fails. It is created if need be, and symlinks and junctions are followed to where files really go,
+// which must be a writable directory and not in a cloud synced folder unless allowSynced.
+func validateDownloadLocation(dir string, allowSynced bool) (resolved string, err error) {
+	if len(dir) == 0 {
+		dir = "."
+	}
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", errors.Annotatef(err, "Unable to create download location %s", dir)
+	}
+	resolved, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", errors.Annotatef(err, "Unable to follow download location %s", dir)
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", errors.Annotatef(err, "Unable to follow download location %s", dir)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", errors.Annotatef(err, "Unable to find download location %s", resolved)
+	}
+	if !info.IsDir() {
+		return "", errors.NotValidf("Download location %s, it resolves to %s which is not a directory", dir, resolved)
+	}
+	err = checkWritable(resolved)
+	if err != nil {
+		return "", errors.Annotatef(err, "Download location %s, resolved to %s, is not writable", dir, resolved)
+	}
+	if synced := getSyncedFolder(resolved); len(synced) > 0 && !allowSynced {
+		return "", errors.NotValidf("Download location %s, it is in the cloud synced folder %s, "+
+			"set allow_synced_download_location to use it anyway", resolved, synced)
+	}
+	return resolved, nil
+}
+
+// checkWritable writes a scratch file to dir, as a download would.
+func checkWritable(dir string) error {
+	scratch, err := os.CreateTemp(dir, ".validatebackups-check-*")
+	if err != nil {
+		return err
+	}
+	scratch.Close()
+	return os.Remove(scratch.Name())
+}
+
+// getSyncedFolder is the folder dir is in that a sync client looks after, blank if there isn't one.
+func getSyncedFolder(dir string) string {
+	for current := dir; ; current = filepath.Dir(current) {
+		name := strings.ToLower(filepath.Base(current))
+		for _, synced := range syncedFolderNames {
+			if name == synced || strings.HasPrefix(name, synced+" - ") {
+				return current
+			}
+		}
+		for _, marker := range syncedFolderMarkers {
+			if _, err := os.Stat(filepath.Join(current, marker)); err == nil {
+				return current
+			}
+		}
+		if filepath.Dir(current) == current {
+			return ""
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDownloadLocation(t *testing.T) {
+	is := assert.New(t)
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	is.NoError(err)
+
+	created := filepath.Join(root, "downloads", "nested")
+	resolved, err := validateDownloadLocation(created, false)
+	is.NoError(err, "Should create a missing download location")
+	is.Equal(created, resolved)
+
+	link := filepath.Join(root, "link")
+	if os.Symlink(created, link) == nil {
+		resolved, err = validateDownloadLocation(link, false)
+		is.NoError(err)
+		is.Equal(created, resolved, "Should follow symlinks to where files really go")
+	}
+
+	file := filepath.Join(root, "file.txt")
+	is.NoError(os.WriteFile(file, []byte("not a directory"), 0644))
+	_, err = validateDownloadLocation(file, false)
+	is.Error(err)
+	_, err = validateDownloadLocation(filepath.Join(file, "downloads"), false)
+	is.Error(err, "Should fail when the location can't be created")
+
+	synced := filepath.Join(root, "OneDrive - Example", "backups")
+	_, err = validateDownloadLocation(synced, false)
+	is.ErrorContains(err, "cloud synced folder "+filepath.Join(root, "OneDrive - Example"))
+	_, err = validateDownloadLocation(synced, true)
+	is.NoError(err, "Should allow a synced folder when asked to")
+}
+
+func TestGetSyncedFolder(t *testing.T) {
+	is := assert.New(t)
+	root := t.TempDir()
+	is.Equal("", getSyncedFolder(root))
+	is.Equal(filepath.Join(root, "Dropbox"), getSyncedFolder(filepath.Join(root, "Dropbox", "backups")))
+	is.Equal("", getSyncedFolder(filepath.Join(root, "OneDriveBackups")))
+
+	renamed := filepath.Join(root, "shared")
+	is.NoError(os.MkdirAll(filepath.Join(renamed, "backups"), os.ModePerm))
+	is.NoError(os.WriteFile(filepath.Join(renamed, ".dropbox"), []byte("{}"), 0644))
+	is.Equal(renamed, getSyncedFolder(filepath.Join(renamed, "backups")), "Should find a renamed synced folder by its marker")
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"google.golang.org/api/googleapi"
+)
+
+// throughputHistogramBounds are the upper bounds, in bytes per second, of the buckets file throughputs are counted in.
+var throughputHistogramBounds = []float64{256 * 1024, 1024 * 1024, 4 * 1024 * 1024, 16 * 1024 * 1024, 64 * 1024 * 1024}
+
+// DownloadStats times a bucket's downloads, so a slow run can be pinned on the network or on storage.
+// ErrorTypes counts every failed attempt by what went wrong, see getDownloadErrorType.
+// LowestFreeBytes is the least free space seen on the download volume, when min_free_bytes has it checked, see freeSpaceGuard.
+type DownloadStats struct {
+	Files           []FileDownloadStat `json:"files"`
+	Retries         int                `json:"retries"`
+	ErrorTypes      map[string]int     `json:"error_types"`
+	LowestFreeBytes int64              `json:"lowest_free_bytes"`
+}
+
+// FileDownloadStat is one downloaded file, Bytes and Seconds cover every attempt at it, retries included.
+type FileDownloadStat struct {
+	Name    string  `json:"name"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Retries int     `json:"retries"`
+}
+
+// downloadTimer measures the attempts at downloading one file.
+type downloadTimer struct {
+	bs         *BucketSummary
+	started    time.Time
+	startBytes int64
+}
+
+func startDownloadTimer(ctx context.Context, now time.Time) *downloadTimer {
+	bs := bucketSummaryFromContext(ctx)
+	if bs == nil {
+		return &downloadTimer{}
+	}
+	return &downloadTimer{bs: bs, started: now, startBytes: bs.BytesDownloaded}
+}
+
+// failed counts an attempt that failed, and whether it is being retried.
+func (t *downloadTimer) failed(err error, retrying bool) {
+	if t.bs == nil {
+		return
+	}
+	if t.bs.DownloadStats.ErrorTypes == nil {
+		t.bs.DownloadStats.ErrorTypes = make(map[string]int)
+	}
+	t.bs.DownloadStats.ErrorTypes[getDownloadErrorType(err)]++
+	if retrying {
+		t.bs.DownloadStats.Retries++
+	}
+}
+
+// done records the file as downloaded, with the bytes counted since the timer started.
+func (t *downloadTimer) done(name string, retries int, now time.Time) {
+	if t.bs == nil {
+		return
+	}
+	t.bs.DownloadStats.Files = append(t.bs.DownloadStats.Files, FileDownloadStat{Name: name, Bytes: t.bs.BytesDownloaded - t.startBytes,
+		Seconds: now.Sub(t.started).Seconds(), Retries: retries})
+}
+
+// getDownloadErrorType sorts a failed download attempt into a few kinds worth telling apart when a run is slow.
+func getDownloadErrorType(err error) string {
+	var apiErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case errors.IsNotFound(err):
+		return "not found"
+	case errors.IsNotValid(err):
+		return "verification"
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("http %d", apiErr.Code)
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "unexpected eof"
+	}
+	return "other"
+}
+
+func (s FileDownloadStat) bytesPerSecond() float64 {
+	if s.Seconds <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Seconds
+}
+
+// totals adds up the stats, seconds being the time spent downloading rather than the run's duration.
+func (s DownloadStats) totals() (bytes int64, seconds float64) {
+	for _, file := range s.Files {
+		bytes += file.Bytes
+		seconds += file.Seconds
+	}
+	return
+}
+
+// getThroughputHistogram counts the files that downloaded any bytes by throughput, one count per bound and one past the last.
+func getThroughputHistogram(files []FileDownloadStat) []int {
+	counts := make([]int, len(throughputHistogramBounds)+1)
+	for _, file := range files {
+		if file.Bytes == 0 {
+			continue
+		}
+		counts[sort.SearchFloat64s(throughputHistogramBounds, file.bytesPerSecond())]++
+	}
+	return counts
+}
+
+func formatThroughput(bytesPerSecond float64) string {
+	return formatBytes(int64(bytesPerSecond)) + "/s"
+}
+
+// formatErrorTypes lists error types with their counts, most common first.
+func formatErrorTypes(errorTypes map[string]int) string {
+	types := make([]string, 0, len(errorTypes))
+	for errorType := range errorTypes {
+		types = append(types, errorType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if errorTypes[types[i]] != errorTypes[types[j]] {
+			return errorTypes[types[i]] > errorTypes[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	for i, errorType := range types {
+		types[i] = fmt.Sprintf("%s %d", errorType, errorTypes[errorType])
+	}
+	return strings.Join(types, ", ")
+}
+
+// formatThroughputHistogram draws the histogram a line per bucket, with a bar scaled to the biggest count.
+func formatThroughputHistogram(counts []int) string {
+	most := 0
+	for _, count := range counts {
+		most = max(most, count)
+	}
+	lines := make([]string, len(counts))
+	for i, count := range counts {
+		var label string
+		switch i {
+		case 0:
+			label = "under " + formatThroughput(throughputHistogramBounds[0])
+		case len(throughputHistogramBounds):
+			label = formatThroughput(throughputHistogramBounds[i-1]) + " and up"
+		default:
+			label = formatThroughput(throughputHistogramBounds[i-1]) + " to " + formatThroughput(throughputHistogramBounds[i])
+		}
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("#", (count*20+most-1)/most)
+		}
+		lines[i] = strings.TrimSpace(fmt.Sprintf("%-24s %4d %s", label, count, bar))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// getDownloadStatsSection sums up each bucket's downloads, then the whole run's with a histogram of file throughputs.
+func getDownloadStatsSection(rs *RunSummary) (section summarySection) {
+	section.heading = "Download throughput"
+	var all DownloadStats
+	all.ErrorTypes = make(map[string]int)
+	for _, bs := range rs.Buckets {
+		stats := bs.DownloadStats
+		if len(stats.Files) == 0 && len(stats.ErrorTypes) == 0 {
+			continue
+		}
+		section.items = append(section.items, summaryItem{text: bs.BucketName + ": " + describeDownloadStats(stats)})
+		all.Files = append(all.Files, stats.Files...)
+		all.Retries += stats.Retries
+		if all.LowestFreeBytes == 0 || (stats.LowestFreeBytes > 0 && stats.LowestFreeBytes < all.LowestFreeBytes) {
+			all.LowestFreeBytes = stats.LowestFreeBytes
+		}
+		for errorType, count := range stats.ErrorTypes {
+			all.ErrorTypes[errorType] += count
+		}
+	}
+	if len(section.items) > 0 {
+		section.items = append(section.items, summaryItem{"all buckets: " + describeDownloadStats(all),
+			formatThroughputHistogram(getThroughputHistogram(all.Files))})
+	}
+	return
+}
+
+func describeDownloadStats(stats DownloadStats) string {
+	bytes, seconds := stats.totals()
+	description := fmt.Sprintf("%d files, %s in %s", len(stats.Files), formatBytes(bytes),
+		(time.Duration(seconds * float64(time.Second))).Round(time.Second))
+	if seconds > 0 {
+		description += ", " + formatThroughput(float64(bytes)/seconds)
+	}
+	description += fmt.Sprintf(", %d retries", stats.Retries)
+	if len(stats.ErrorTypes) > 0 {
+		description += " (" + formatErrorTypes(stats.ErrorTypes) + ")"
+	}
+	if stats.LowestFreeBytes > 0 {
+		description += ", " + formatBytes(stats.LowestFreeBytes) + " free at the lowest"
+	}
+	return description
+}
+
+// writeDownloadMetrics writes the run's download stats in the prometheus text format, for node exporter's textfile collector.
+func writeDownloadMetrics(w io.Writer, rs *RunSummary) {
+	fmt.Fprintln(w, "# HELP validatebackups_download_bytes Bytes downloaded in the last run.")
+	fmt.Fprintln(w, "# TYPE validatebackups_download_bytes gauge")
+	for _, bs := range rs.Buckets {
+		bytes, _ := bs.DownloadStats.totals()
+		fmt.Fprintf(w, "validatebackups_download_bytes{bucket=%q} %d\n", bs.BucketName, bytes)
+	}
+	fmt.Fprintln(w, "# HELP validatebackups_download_seconds Time spent downloading in the last run.")
+	fmt.Fprintln(w, "# TYPE validatebackups_download_seconds gauge")
+	for _, bs := range rs.Buckets {
+		_, seconds := bs.DownloadStats.totals()
+		fmt.Fprintf(w, "validatebackups_download_seconds{bucket=%q} %g\n", bs.BucketName, seconds)
+	}
+	fmt.Fprintln(w, "# HELP validatebackups_download_retries Download attempts retried in the last run.")
+	fmt.Fprintln(w, "# TYPE validatebackups_download_retries gauge")
+	for _, bs := range rs.Buckets {
+		fmt.Fprintf(w, "validatebackups_download_retries{bucket=%q} %d\n", bs.BucketName, bs.DownloadStats.Retries)
+	}
+	fmt.Fprintln(w, "# HELP validatebackups_download_errors Failed download attempts in the last run, by type.")
+	fmt.Fprintln(w, "# TYPE validatebackups_download_errors gauge")
+	for _, bs := range rs.Buckets {
+		for errorType, count := range bs.DownloadStats.ErrorTypes {
+			fmt.Fprintf(w, "validatebackups_download_errors{bucket=%q,type=%q} %d\n", bs.BucketName, errorType, count)
+		}
+	}
+	fmt.Fprintln(w, "# HELP validatebackups_download_throughput_bytes_per_second Throughput of each file downloaded in the last run.")
+	fmt.Fprintln(w, "# TYPE validatebackups_download_throughput_bytes_per_second histogram")
+	for _, bs := range rs.Buckets {
+		counts := getThroughputHistogram(bs.DownloadStats.Files)
+		cumulative, sum := 0, 0.0
+		for i, bound := range throughputHistogramBounds {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "validatebackups_download_throughput_bytes_per_second_bucket{bucket=%q,le=\"%g\"} %d\n", bs.BucketName, bound, cumulative)
+		}
+		cumulative += counts[len(throughputHistogramBounds)]
+		for _, file := range bs.DownloadStats.Files {
+			if file.Bytes > 0 {
+				sum += file.bytesPerSecond()
+			}
+		}
+		fmt.Fprintf(w, "validatebackups_download_throughput_bytes_per_second_bucket{bucket=%q,le=\"+Inf\"} %d\n", bs.BucketName, cumulative)
+		fmt.Fprintf(w, "validatebackups_download_throughput_bytes_per_second_sum{bucket=%q} %g\n", bs.BucketName, sum)
+		fmt.Fprintf(w, "validatebackups_download_throughput_bytes_per_second_count{bucket=%q} %d\n", bs.BucketName, cumulative)
+	}
+	fmt.Fprintln(w, "# HELP validatebackups_api_operations Billed storage operations in the last run, by class.")
+	fmt.Fprintln(w, "# TYPE validatebackups_api_operations gauge")
+	for _, bs := range rs.Buckets {
+		fmt.Fprintf(w, "validatebackups_api_operations{bucket=%q,class=%q} %d\n", bs.BucketName, operationClassA, bs.APIOperations.ClassA)
+		fmt.Fprintf(w, "validatebackups_api_operations{bucket=%q,class=%q} %d\n", bs.BucketName, operationClassB, bs.APIOperations.ClassB)
+	}
+	fmt.Fprintln(w, "# HELP validatebackups_estimated_cost_dollars Estimated cost of the last run's operations and downloads, in US dollars.")
+	fmt.Fprintln(w, "# TYPE validatebackups_estimated_cost_dollars gauge")
+	for _, bs := range rs.Buckets {
+		fmt.Fprintf(w, "validatebackups_estimated_cost_dollars{bucket=%q} %g\n", bs.BucketName, bs.APIOperations.cost()+getEgressCost(bs.BytesDownloaded))
+	}
+}
+
+// saveDownloadMetrics writes the metrics to a temporary file renamed over filePath, so the collector never reads half a file.
+func saveDownloadMetrics(filePath string, rs *RunSummary) error {
+	if len(filePath) == 0 || rs == nil {
+		return nil
+	}
+	temp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return errors.Annotatef(err, "Unable to create metrics file next to %s", filePath)
+	}
+	writeDownloadMetrics(temp, rs)
+	err = temp.Close()
+	if err == nil {
+		err = os.Rename(temp.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return errors.Annotatef(err, "Unable to save metrics file %s", filePath)
+	}
+	return nil
+}
+
+// exportDownloadMetrics saves the metrics file when metrics_file is set, a problem saving it is only a warning.
+func exportDownloadMetrics(config Config, rs *RunSummary) {
+	rs.recordAPICalls()
+	err := saveDownloadMetrics(config.MetricsFile, rs)
+	if err != nil {
+		rs.warn("%v", err)
+	}
+}
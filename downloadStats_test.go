@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+var testGetDownloadErrorTypeCases = []struct {
+	err      error
+	expected string
+}{
+	{errors.NotFoundf("backup.tar.gz"), "not found"},
+	{errors.NotValidf("Downloaded file size"), "verification"},
+	{errors.Annotate(context.DeadlineExceeded, "Unable to download"), "timeout"},
+	{errors.Annotate(&googleapi.Error{Code: 503}, "Unable to download"), "http 503"},
+	{errors.Annotate(syscall.ECONNRESET, "read tcp"), "connection reset"},
+	{io.ErrUnexpectedEOF, "unexpected eof"},
+	{errors.New("something else"), "other"},
+}
+
+func TestGetDownloadErrorType(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetDownloadErrorTypeCases {
+		is.Equal(tc.expected, getDownloadErrorType(tc.err), tc.err.Error())
+	}
+}
+
+func TestDownloadTimer(t *testing.T) {
+	is := assert.New(t)
+	bs := &BucketSummary{BucketName: "test-matt-media", BytesDownloaded: 100}
+	ctx := withBucketSummary(context.Background(), bs)
+	started := time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC)
+
+	timer := startDownloadTimer(ctx, started)
+	timer.failed(io.ErrUnexpectedEOF, true)
+	timer.failed(&googleapi.Error{Code: 503}, false)
+	bs.BytesDownloaded += 2048
+	timer.done("show/episode.mkv", 1, started.Add(2*time.Second))
+	is.Equal([]FileDownloadStat{{"show/episode.mkv", 2048, 2, 1}}, bs.DownloadStats.Files)
+	is.Equal(1, bs.DownloadStats.Retries, "Should only count attempts that are retried as retries")
+	is.Equal(map[string]int{"unexpected eof": 1, "http 503": 1}, bs.DownloadStats.ErrorTypes)
+
+	//without a bucket summary there's nothing to record to
+	startDownloadTimer(context.Background(), started).done("show/episode.mkv", 0, started)
+}
+
+func TestGetThroughputHistogram(t *testing.T) {
+	is := assert.New(t)
+	files := []FileDownloadStat{
+		{"slow.tar.gz", 100 * 1024, 1, 0},
+		{"medium.tar.gz", 8 * 1024 * 1024, 1, 0},
+		{"fast.tar.gz", 100 * 1024 * 1024, 1, 0},
+		{"already.tar.gz", 0, 0.1, 0},
+	}
+	is.Equal([]int{1, 0, 0, 1, 0, 1}, getThroughputHistogram(files), "Should skip files that downloaded nothing")
+	histogram := formatThroughputHistogram(getThroughputHistogram(files))
+	is.Contains(histogram, "under 256.0 KiB/s")
+	is.Contains(histogram, "64.0 MiB/s and up")
+}
+
+func TestGetDownloadStatsSection(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestRunSummary()
+	is.Empty(getDownloadStatsSection(summary).items, "Should skip the section when nothing was downloaded")
+
+	summary.Buckets[0].DownloadStats = DownloadStats{Files: []FileDownloadStat{{"show/episode.mkv", 4 * 1024 * 1024, 2, 1}},
+		Retries: 1, ErrorTypes: map[string]int{"timeout": 1}}
+	section := getDownloadStatsSection(summary)
+	is.Equal("Download throughput", section.heading)
+	is.Len(section.items, 2)
+	is.Equal("test-matt-media: 1 files, 4.0 MiB in 2s, 2.0 MiB/s, 1 retries (timeout 1)", section.items[0].text)
+	is.True(strings.HasPrefix(section.items[1].text, "all buckets: 1 files"))
+	is.Contains(section.items[1].detail, "1.0 MiB/s to 4.0 MiB/s      1 ####################")
+
+	summary.Buckets[0].DownloadStats.LowestFreeBytes = 3 * 1024 * 1024
+	section = getDownloadStatsSection(summary)
+	is.Equal("test-matt-media: 1 files, 4.0 MiB in 2s, 2.0 MiB/s, 1 retries (timeout 1), 3.0 MiB free at the lowest", section.items[0].text)
+	is.Contains(section.items[1].text, ", 3.0 MiB free at the lowest")
+}
+
+func TestFormatErrorTypes(t *testing.T) {
+	is := assert.New(t)
+	is.Equal("timeout 3, http 503 1, unexpected eof 1", formatErrorTypes(map[string]int{"unexpected eof": 1, "timeout": 3, "http 503": 1}))
+}
+
+func TestWriteDownloadMetrics(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestRunSummary()
+	summary.Buckets[0].DownloadStats = DownloadStats{Files: []FileDownloadStat{{"show/episode.mkv", 4 * 1024 * 1024, 2, 1}},
+		Retries: 1, ErrorTypes: map[string]int{"timeout": 1}}
+	var output bytes.Buffer
+	writeDownloadMetrics(&output, summary)
+	is.Contains(output.String(), "validatebackups_download_bytes{bucket=\"test-matt-media\"} 4194304\n")
+	is.Contains(output.String(), "validatebackups_download_retries{bucket=\"test-matt-media\"} 1\n")
+	is.Contains(output.String(), "validatebackups_download_errors{bucket=\"test-matt-media\",type=\"timeout\"} 1\n")
+	is.Contains(output.String(), "validatebackups_download_throughput_bytes_per_second_bucket{bucket=\"test-matt-media\",le=\"1.048576e+06\"} 0\n")
+	is.Contains(output.String(), "validatebackups_download_throughput_bytes_per_second_bucket{bucket=\"test-matt-media\",le=\"4.194304e+06\"} 1\n")
+	is.Contains(output.String(), "validatebackups_download_throughput_bytes_per_second_count{bucket=\"test-matt-server-backups\"} 0\n")
+}
+
+func TestSaveDownloadMetrics(t *testing.T) {
+	is := assert.New(t)
+	filePath := t.TempDir() + "/validatebackups.prom"
+	is.NoError(saveDownloadMetrics("", getTestRunSummary()), "Should do nothing without a metrics file")
+	is.NoError(saveDownloadMetrics(filePath, getTestRunSummary()))
+	is.FileExists(filePath)
+
+	summary := newRunSummary()
+	exportDownloadMetrics(Config{MetricsFile: t.TempDir() + "/missing/validatebackups.prom"}, summary)
+	is.Len(summary.Warnings, 1, "Should warn when the metrics file can't be saved")
+}
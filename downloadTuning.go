@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const downloadTuningFileName = "downloadTuning.json"
+
+// envNetworkProfile picks the network profile learned download settings are kept under, e.g. home or office,
+// overriding network_profile in the config.
+const envNetworkProfile = "VALIDATEBACKUPS_NETWORK_PROFILE"
+
+// Bounds of the adaptive download settings. Downloads smaller than tuningMinBytes finish too quickly to measure,
+// and tuning stops after tuningMaxDownloads measurements whether or not throughput stopped improving.
+const (
+	defaultNetworkProfile = "default"
+	defaultMaxWorkers     = 8
+	minChunkBytes         = 8 << 20
+	maxChunkBytes         = 256 << 20
+	tuningMinBytes        = 32 << 20
+	tuningMaxDownloads    = 8
+	//each chunk should take a worker about this long, long enough that starting a range read is a small overhead
+	chunkSeconds = 2
+)
+
+// TunedDownloads are the download settings learned for a network profile. BytesPerSecond is the throughput they measured.
+type TunedDownloads struct {
+	Workers        int       `json:"workers"`
+	ChunkBytes     int64     `json:"chunk_bytes"`
+	BytesPerSecond int64     `json:"bytes_per_second"`
+	Updated        time.Time `json:"updated"`
+}
+
+// downloadTuning is the file learned download settings are kept in, by network profile.
+type downloadTuning struct {
+	Profiles map[string]TunedDownloads `json:"profiles"`
+}
+
+// downloadTuner measures the throughput of the first large downloads of a run and adjusts the workers and chunk size
+// of the ones after, adding a worker while that raises throughput towards the target and dropping one if it goes over.
+// It settles on the best settings once another worker stops helping, the target is reached or it has measured enough.
+// It is safe to call on a nil downloadTuner, which downloads every file as a single stream.
+type downloadTuner struct {
+	mu         sync.Mutex
+	target     int64
+	maxWorkers int
+	current    TunedDownloads
+	best       TunedDownloads
+	measured   int
+	settled    bool
+}
+
+type downloadTunerKey struct{}
+
+func getDownloadTuningFilePath(config Config) string {
+	return filepath.Join(getStateDirectory(config), downloadTuningFileName)
+}
+
+func getNetworkProfile(adaptive AdaptiveDownloads, getenv func(string) string) string {
+	if profile := getenv(envNetworkProfile); len(profile) > 0 {
+		return profile
+	}
+	if len(adaptive.NetworkProfile) > 0 {
+		return adaptive.NetworkProfile
+	}
+	return defaultNetworkProfile
+}
+
+// newDownloadTuner starts from the settings learned before, if there are any, or else a single stream.
+func newDownloadTuner(adaptive AdaptiveDownloads, learned TunedDownloads, found bool) *downloadTuner {
+	tuner := &downloadTuner{target: adaptive.TargetBytesPerSecond, maxWorkers: adaptive.MaxWorkers}
+	if tuner.maxWorkers <= 0 {
+		tuner.maxWorkers = defaultMaxWorkers
+	}
+	tuner.current = TunedDownloads{Workers: 1, ChunkBytes: minChunkBytes}
+	if found && learned.Workers > 0 {
+		tuner.current = learned
+		tuner.current.Workers = min(learned.Workers, tuner.maxWorkers)
+		tuner.current.ChunkBytes = min(max(learned.ChunkBytes, minChunkBytes), maxChunkBytes)
+	}
+	return tuner
+}
+
+func withDownloadTuner(ctx context.Context, tuner *downloadTuner) context.Context {
+	if tuner == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, downloadTunerKey{}, tuner)
+}
+
+func downloadTunerFromContext(ctx context.Context) *downloadTuner {
+	tuner, _ := ctx.Value(downloadTunerKey{}).(*downloadTuner)
+	return tuner
+}
+
+// settings are the workers and chunk size to download the next file with.
+func (t *downloadTuner) settings() (workers int, chunkBytes int64) {
+	if t == nil {
+		return 1, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current.Workers, t.current.ChunkBytes
+}
+
+// record measures a download made with the current settings and adjusts them for the next one.
+func (t *downloadTuner) record(bytes int64, elapsed time.Duration, now time.Time) {
+	if t == nil || bytes < tuningMinBytes || elapsed <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.settled {
+		return
+	}
+	t.measured++
+	measured := t.current
+	measured.BytesPerSecond = int64(float64(bytes) / elapsed.Seconds())
+	measured.Updated = now
+	switch {
+	case t.target > 0 && measured.BytesPerSecond > t.target && measured.Workers > 1:
+		//over the target, back off a worker and stay there
+		t.best = measured
+		t.current.Workers--
+		t.settled = true
+	case t.target > 0 && measured.BytesPerSecond*100 >= t.target*95:
+		t.best = measured
+		t.settled = true
+	case measured.BytesPerSecond*10 > t.best.BytesPerSecond*11:
+		t.best = measured
+		if t.current.Workers < t.maxWorkers {
+			t.current.Workers++
+		} else {
+			t.settled = true
+		}
+	default:
+		//the last worker added didn't help enough to be worth it
+		t.current = t.best
+		t.settled = true
+	}
+	if t.measured >= tuningMaxDownloads && !t.settled {
+		t.current = t.best
+		t.settled = true
+	}
+	t.current.ChunkBytes = getChunkBytes(t.best.BytesPerSecond, t.best.Workers)
+	t.best.ChunkBytes = t.current.ChunkBytes
+}
+
+// getChunkBytes sizes chunks so each takes a worker about chunkSeconds at the throughput measured, in whole MiB.
+func getChunkBytes(bytesPerSecond int64, workers int) int64 {
+	if workers <= 0 {
+		return minChunkBytes
+	}
+	chunk := bytesPerSecond / int64(workers) * chunkSeconds
+	chunk = chunk >> 20 << 20
+	return min(max(chunk, minChunkBytes), maxChunkBytes)
+}
+
+// learned is the best settings measured this run, found is false when no download was big enough to measure.
+func (t *downloadTuner) learned() (settings TunedDownloads, found bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.settled {
+		settings = t.current
+		settings.BytesPerSecond, settings.Updated = t.best.BytesPerSecond, t.best.Updated
+		return settings, true
+	}
+	return t.best, t.measured > 0
+}
+
+// loadDownloadTuning loads the learned settings, or starts without any if there aren't any yet.
+// A file that can't be parsed is started over, the settings are relearned in a few downloads.
+func loadDownloadTuning(filePath string) (tuning *downloadTuning, err error) {
+	tuning = &downloadTuning{Profiles: make(map[string]TunedDownloads)}
+	contents, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return tuning, nil
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to open download tuning file at %s", filePath)
+	}
+	if json.Unmarshal(contents, tuning) != nil || tuning.Profiles == nil {
+		tuning.Profiles = make(map[string]TunedDownloads)
+	}
+	return tuning, nil
+}
+
+func saveDownloadTuning(filePath string, tuning *downloadTuning) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open download tuning file %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	return json.NewEncoder(jsonFile).Encode(tuning)
+}
+
+// startDownloadTuning attaches a tuner for the network profile to ctx when adaptive downloads are enabled,
+// and returns the func that saves what it learned.
+func startDownloadTuning(ctx context.Context, config Config, getenv func(string) string) (
+	tunedCtx context.Context, save func() error, err error) {
+	save = func() error { return nil }
+	if !config.AdaptiveDownloads.Enabled {
+		return ctx, save, nil
+	}
+	filePath := getDownloadTuningFilePath(config)
+	tuning, err := loadDownloadTuning(filePath)
+	if err != nil {
+		return ctx, save, err
+	}
+	profile := getNetworkProfile(config.AdaptiveDownloads, getenv)
+	learned, found := tuning.Profiles[profile]
+	tuner := newDownloadTuner(config.AdaptiveDownloads, learned, found)
+	save = func() error {
+		settings, found := tuner.learned()
+		if !found {
+			return nil
+		}
+		tuning.Profiles[profile] = settings
+		return saveDownloadTuning(filePath, tuning)
+	}
+	return withDownloadTuner(ctx, tuner), save, nil
+}
+
+// downloadChunks downloads the first size bytes of obj into localFile as range reads of chunkBytes on up to workers goroutines.
+// The first chunk to fail cancels the rest.
+func downloadChunks(ctx context.Context, obj BackupObject, localFile *os.File, size int64, chunkBytes int64, workers int,
+	bar *progressBar) (written int64, err error) {
+	if chunkBytes <= 0 || workers <= 0 {
+		return 0, errors.NotValidf("Chunk size %d and workers %d", chunkBytes, workers)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	numChunks := int((size + chunkBytes - 1) / chunkBytes)
+	chunkWritten := make([]int64, numChunks)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	chunks := make(chan int)
+	for w := 0; w < min(workers, numChunks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range chunks {
+				offset := int64(i) * chunkBytes
+				n, err2 := downloadChunk(ctx, obj, localFile, offset, min(chunkBytes, size-offset), bar)
+				chunkWritten[i] = n
+				if err2 != nil {
+					mu.Lock()
+					if err == nil {
+						err = errors.Annotatef(err2, "Unable to download bytes %d to %d", offset, offset+n)
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+	for i := 0; i < numChunks; i++ {
+		chunks <- i
+	}
+	close(chunks)
+	wg.Wait()
+	for _, n := range chunkWritten {
+		written += n
+	}
+	return
+}
+
+// downloadChunk saves length bytes from offset, checking free space and injecting faults the way a single stream download does.
+func downloadChunk(ctx context.Context, obj BackupObject, localFile *os.File, offset int64, length int64,
+	bar *progressBar) (written int64, err error) {
+	rc, err := obj.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	guard := freeSpaceGuardFromContext(ctx)
+	injector := faultInjectorFromContext(ctx)
+	return io.Copy(guard.wrapWriter(ctx, injector.wrapWriter(io.NewOffsetWriter(localFile, offset), length), length),
+		bar.proxyReader(injector.wrapReader(rc)))
+}
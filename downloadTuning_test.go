@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+)
+
+var testDownloadTunerCases = []struct {
+	name string
+	//throughput in MiB/s of each measured download, made with the workers the tuner picked for it
+	throughputs []int64
+	target      int64
+	workers     []int
+	settled     bool
+	learned     int
+}{
+	{"more workers help until they don't", []int64{10, 20, 30, 31}, 0, []int{1, 2, 3, 4, 3}, true, 3},
+	{"stop at the target", []int64{10, 20, 39}, 40, []int{1, 2, 3, 3}, true, 3},
+	{"back off when over the target", []int64{10, 20, 45}, 40, []int{1, 2, 3, 2}, true, 2},
+	{"stop at max workers", []int64{10, 20, 30, 40}, 0, []int{1, 2, 3, 4, 4}, true, 4},
+	{"still tuning", []int64{10, 20}, 0, []int{1, 2, 3}, false, 2},
+}
+
+func TestDownloadTuner(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2026, 10, 17, 1, 2, 3, 0, time.UTC)
+	for _, tc := range testDownloadTunerCases {
+		tuner := newDownloadTuner(AdaptiveDownloads{TargetBytesPerSecond: tc.target << 20, MaxWorkers: 4}, TunedDownloads{}, false)
+		workers, _ := tuner.settings()
+		picked := []int{workers}
+		for _, throughput := range tc.throughputs {
+			tuner.record(throughput<<22, 4*time.Second, now)
+			workers, _ = tuner.settings()
+			picked = append(picked, workers)
+		}
+		is.Equal(tc.workers, picked, tc.name)
+		is.Equal(tc.settled, tuner.settled, tc.name)
+		learned, found := tuner.learned()
+		is.True(found, tc.name)
+		is.Equal(tc.learned, learned.Workers, tc.name)
+	}
+
+	tuner := newDownloadTuner(AdaptiveDownloads{}, TunedDownloads{}, false)
+	tuner.record(tuningMinBytes-1, time.Second, now)
+	_, found := tuner.learned()
+	is.False(found, "Should not measure small downloads")
+
+	tuner = newDownloadTuner(AdaptiveDownloads{MaxWorkers: 4}, TunedDownloads{Workers: 6, ChunkBytes: 1 << 30}, true)
+	workers, chunkBytes := tuner.settings()
+	is.Equal(4, workers, "Should start from what was learned, within max_workers")
+	is.Equal(int64(maxChunkBytes), chunkBytes)
+
+	var nilTuner *downloadTuner
+	workers, _ = nilTuner.settings()
+	is.Equal(1, workers)
+	nilTuner.record(1<<30, time.Second, now)
+}
+
+func TestGetChunkBytes(t *testing.T) {
+	is := assert.New(t)
+	is.Equal(int64(minChunkBytes), getChunkBytes(1<<20, 1))
+	is.Equal(int64(20<<20), getChunkBytes(40<<20+1234, 4))
+	is.Equal(int64(maxChunkBytes), getChunkBytes(1<<30, 1))
+	is.Equal(int64(minChunkBytes), getChunkBytes(1<<30, 0))
+}
+
+func TestStartDownloadTuning(t *testing.T) {
+	is := assert.New(t)
+	config := Config{StateDirectory: t.TempDir(), AdaptiveDownloads: AdaptiveDownloads{Enabled: true, NetworkProfile: "home"}}
+	getenv := func(string) string { return "" }
+	ctx, save, err := startDownloadTuning(context.Background(), config, getenv)
+	is.NoError(err)
+	is.NoError(save(), "Should not save when nothing was measured")
+	_, err = os.Stat(getDownloadTuningFilePath(config))
+	is.True(os.IsNotExist(err))
+
+	downloadTunerFromContext(ctx).record(64<<20, time.Second, time.Now())
+	is.NoError(save())
+	tuning, err := loadDownloadTuning(getDownloadTuningFilePath(config))
+	is.NoError(err)
+	is.Equal(int64(64<<20), tuning.Profiles["home"].BytesPerSecond)
+
+	office := func(key string) string {
+		if key == envNetworkProfile {
+			return "office"
+		}
+		return ""
+	}
+	ctx, _, err = startDownloadTuning(context.Background(), config, office)
+	is.NoError(err)
+	workers, _ := downloadTunerFromContext(ctx).settings()
+	is.Equal(1, workers, "Should learn each network profile separately")
+
+	config.AdaptiveDownloads.Enabled = false
+	ctx, save, err = startDownloadTuning(context.Background(), config, getenv)
+	is.NoError(err)
+	is.Nil(downloadTunerFromContext(ctx))
+	is.NoError(save())
+
+	is.NoError(os.WriteFile(filepath.Join(config.StateDirectory, downloadTuningFileName), []byte("not json"), 0644))
+	tuning, err = loadDownloadTuning(getDownloadTuningFilePath(config))
+	is.NoError(err, "Should start over from a file that can't be parsed")
+	is.Empty(tuning.Profiles)
+}
+
+func TestDownloadChunks(t *testing.T) {
+	is := assert.New(t)
+	content := []byte(strings.Repeat("0123456789abcdef", 1000))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "backup.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	newClient, err := getClientConstructor(ClientTransport{Endpoint: server.URL + "/storage/v1/"}, false)
+	is.NoError(err)
+	client, err := newClient(ctx, option.WithoutAuthentication())
+	is.NoError(err)
+	defer client.Close()
+
+	localFile, err := os.Create(filepath.Join(t.TempDir(), "backup.tar.gz"))
+	is.NoError(err)
+	defer localFile.Close()
+	obj := newGCSStore(client.Bucket("test-matt-server-backups")).Object("backup.tar.gz")
+	written, err := downloadChunks(ctx, obj, localFile, int64(len(content)), 3000, 3, newTestProgressBar(int64(len(content))))
+	is.NoError(err)
+	is.Equal(int64(len(content)), written)
+	saved, err := os.ReadFile(localFile.Name())
+	is.NoError(err)
+	is.Equal(content, saved)
+
+	_, err = downloadChunks(ctx, obj, localFile, int64(len(content)), 0, 3, newTestProgressBar(int64(len(content))))
+	is.Error(err)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// drillDirectory keeps drill downloads apart from the regular spot check downloads.
+const drillDirectory = "drill"
+
+// DrillResult records whether the newest backup in a bucket could actually be restored.
+type DrillResult struct {
+	BucketName string
+	ObjectName string
+	Hook       HookResult
+}
+
+// getDrillBuckets picks the server-backup buckets with a restore drill configured, or just the named bucket if bucketName is set.
+// bucketName can be a URI like gs://bucket/prefix to drill the newest backup under that prefix.
+func getDrillBuckets(config Config, bucketName string) (buckets []BucketToProcess, err error) {
+	if len(bucketName) > 0 {
+		bucketConfig, err2 := getBucketConfigFromURI(bucketName, config.Buckets)
+		if err2 != nil {
+			return nil, err2
+		}
+		bucketName = bucketConfig.Name
+		if bucketConfig.Type != "server-backup" || len(bucketConfig.RestoreDrill.Command) == 0 {
+			return nil, errors.NotValidf("Bucket %s has no restore drill, only server-backup buckets with restore_drill", bucketName)
+		}
+		return []BucketToProcess{bucketConfig}, nil
+	}
+	for _, bucketConfig := range config.Buckets {
+		if bucketConfig.Type == "server-backup" && len(bucketConfig.RestoreDrill.Command) > 0 {
+			buckets = append(buckets, bucketConfig)
+		}
+	}
+	if len(buckets) == 0 {
+		err = errors.NotFoundf("Server-backup buckets with a restore_drill")
+	}
+	return
+}
+
+// drillBucket downloads the newest backup in the bucket and runs its restore command against it.
+// A failed restore is reported in the result, err is only set when the drill could not be attempted.
+func drillBucket(ctx context.Context, client *storage.Client, config Config, bucketConfig BucketToProcess) (result DrillResult, err error) {
+	result.BucketName = bucketConfig.Name
+	ctx = withBucketPrefix(ctx, bucketConfig.Prefix)
+	bucket, err := openBackupStore(ctx, client, config, bucketConfig)
+	if err != nil {
+		return
+	}
+	matcher, err := newObjectNameMatcher(bucketConfig.FreshnessFilter)
+	if err != nil {
+		err = errors.Annotatef(err, "Invalid freshness filter for bucket %s", bucketConfig.Name)
+		return
+	}
+	ctx, err = withFreshnessWindow(ctx, bucketConfig.FreshnessWindow, config.ServerBackupRules)
+	if err != nil {
+		err = errors.Annotatef(err, "Invalid freshness window for bucket %s", bucketConfig.Name)
+		return
+	}
+	newest, err := getNewestObjectFromBucket(ctx, bucket, matcher)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to find the newest backup in bucket %s", bucketConfig.Name)
+		return
+	}
+	if newest == nil {
+		err = errors.NotFoundf("Backups in bucket %s", bucketConfig.Name)
+		return
+	}
+	result.ObjectName = newest.Name
+
+	localName := newest.Name
+	if config.SanitizeFileNames {
+		localName = sanitizeObjectPath(localName)
+	}
+	localFile := filepath.Join(config.FileDownloadLocation, drillDirectory, bucketConfig.Name, localName)
+	fmt.Fprintln(console.stdout, fmt.Sprintf("Downloading %s from %s for a restore drill.", newest.Name, bucketConfig.Name))
+	err = downloadFile(ctx, bucket, newest.Name, localFile)
+	if errors.IsAlreadyExists(err) {
+		err = nil
+	}
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to download %s for a restore drill", newest.Name)
+		return
+	}
+
+	fmt.Fprintln(console.stdout, fmt.Sprintf("Running restore drill against %s.", localFile))
+	result.Hook = runPostDownloadHook(ctx, bucketConfig.RestoreDrill, localFile)
+	return
+}
+
+// writeDrillResults lists each drill's outcome, followed by the output of any that failed.
+func writeDrillResults(w io.Writer, results []DrillResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Bucket\tBackup\tRestore\tDuration\t")
+	for _, result := range results {
+		outcome := validationPassed
+		if !result.Hook.passed() {
+			outcome = validationFailed
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", result.BucketName, result.ObjectName, outcome,
+			result.Hook.Duration.Round(time.Second).String())
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Hook.passed() {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s restore of %s: exit code %d %s\n", result.BucketName, result.ObjectName,
+			result.Hook.ExitCode, result.Hook.Error)
+		if len(result.Hook.Output) > 0 {
+			fmt.Fprintln(w, "  "+strings.ReplaceAll(result.Hook.Output, "\n", "\n  "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDrillConfig = Config{Buckets: []BucketToProcess{
+	{Name: "test-matt-media", Type: "media"},
+	{Name: "test-matt-server-backups", Type: "server-backup",
+		RestoreDrill: PostDownloadHook{Command: []string{"tar", "-tzf"}}},
+	{Name: "test-matt-server-backups-undrilled", Type: "server-backup"},
+}}
+
+func TestGetDrillBuckets(t *testing.T) {
+	is := assert.New(t)
+	actual, err := getDrillBuckets(testDrillConfig, "")
+	is.NoError(err)
+	is.Equal([]BucketToProcess{testDrillConfig.Buckets[1]}, actual, "Should only drill server backups with a restore drill")
+
+	actual, err = getDrillBuckets(testDrillConfig, "test-matt-server-backups")
+	is.NoError(err)
+	is.Equal([]BucketToProcess{testDrillConfig.Buckets[1]}, actual)
+
+	actual, err = getDrillBuckets(testDrillConfig, "gs://test-matt-server-backups/nightly/")
+	is.NoError(err)
+	is.Equal("test-matt-server-backups", actual[0].Name)
+	is.Equal("nightly/", actual[0].Prefix, "Should drill under the prefix in the URI")
+	_, err = getDrillBuckets(testDrillConfig, "s3://test-matt-server-backups")
+	is.True(errors.IsNotFound(err), "Should error when the bucket is on another provider")
+	_, err = getDrillBuckets(testDrillConfig, "az://test-matt-server-backups")
+	is.True(errors.IsNotSupported(err), "Should error on other storage providers")
+
+	_, err = getDrillBuckets(testDrillConfig, "test-matt-server-backups-undrilled")
+	is.True(errors.IsNotValid(err), "Should error when the named bucket has no restore drill")
+	_, err = getDrillBuckets(testDrillConfig, "test-matt-media")
+	is.True(errors.IsNotValid(err), "Should error when the named bucket is not a server backup")
+	_, err = getDrillBuckets(testDrillConfig, "not-a-bucket")
+	is.True(errors.IsNotFound(err), "Should error when the named bucket is not in the config")
+	_, err = getDrillBuckets(Config{}, "")
+	is.True(errors.IsNotFound(err), "Should error when no buckets have a restore drill")
+}
+
+func TestDrillBucketWithoutBackups(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	config := Config{FileDownloadLocation: t.TempDir()}
+	bucketConfig := testDrillConfig.Buckets[1]
+	client, _ := newTestStorageServer(t, bucketConfig.Name, nil)
+	_, err := drillBucket(ctx, client, config, bucketConfig)
+	is.True(errors.IsNotFound(err), "Should error when the bucket is empty: %v", err)
+
+	client, _ = newTestStorageServer(t, bucketConfig.Name, map[string][]byte{"README": []byte("not a backup")})
+	bucketConfig.FreshnessFilter = ObjectNameFilter{ExcludePatterns: []string{"README$"}}
+	_, err = drillBucket(ctx, client, config, bucketConfig)
+	is.True(errors.IsNotFound(err), "Should error when the freshness filter matches nothing: %v", err)
+}
+
+func TestWriteDrillResults(t *testing.T) {
+	is := assert.New(t)
+	results := []DrillResult{
+		{BucketName: "test-matt-server-backups", ObjectName: "newest.tar.gz",
+			Hook: HookResult{Duration: 3 * time.Second}},
+		{BucketName: "test-matt-db-backups", ObjectName: "dump.sql",
+			Hook: HookResult{ExitCode: 2, Output: "ERROR: relation missing", Duration: time.Minute}},
+	}
+	var out bytes.Buffer
+	is.NoError(writeDrillResults(&out, results))
+	actual := out.String()
+	is.Regexp(`test-matt-server-backups\s+newest\.tar\.gz\s+passed\s+3s`, actual)
+	is.Regexp(`test-matt-db-backups\s+dump\.sql\s+failed\s+1m0s`, actual)
+	is.Contains(actual, "test-matt-db-backups restore of dump.sql: exit code 2")
+	is.Contains(actual, "  ERROR: relation missing")
+	is.NotContains(actual, "newest.tar.gz: exit code", "Should not list output of drills that passed")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Environment variables for running in containers, where mounting a config file or passing flags is awkward.
+const (
+	envConfigPath  = "VALIDATEBACKUPS_CONFIG"
+	envConfigJson  = "VALIDATEBACKUPS_CONFIG_JSON"
+	envStateDir    = "VALIDATEBACKUPS_STATE_DIR"
+	envDownloadDir = "VALIDATEBACKUPS_DOWNLOAD_DIR"
+	envSummaryFile = "VALIDATEBACKUPS_SUMMARY_FILE"
+	envHealthAddr  = "VALIDATEBACKUPS_HEALTH_ADDR"
+)
+
+// stdinConfigPath passed as --config reads the config from stdin.
+const stdinConfigPath = "-"
+
+func getEnvOrDefault(getenv func(string) string, name string, fallback string) string {
+	if value := getenv(name); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// loadConfiguration reads the config from stdin when configPath is -, from the VALIDATEBACKUPS_CONFIG_JSON environment variable when it is set,
+// and from the file at configPath otherwise. Environment overrides are applied on top.
+func loadConfiguration(configPath string, stdin io.Reader, getenv func(string) string) (config Config, err error) {
+	switch {
+	case configPath == stdinConfigPath:
+		config, err = loadConfigurationFromReader(stdin)
+		err = errors.Annotate(err, "Unable to read config from stdin")
+	case len(getenv(envConfigJson)) > 0:
+		config, err = loadConfigurationFromReader(strings.NewReader(getenv(envConfigJson)))
+		err = errors.Annotatef(err, "Unable to read config from %s", envConfigJson)
+	default:
+		config, err = loadConfigurationFromFile(configPath)
+	}
+	if err != nil {
+		return
+	}
+	config, err = normalizeBucketNames(applyEnvOverrides(config, getenv))
+	if err != nil {
+		return
+	}
+	err = validateBucketGroups(config)
+	if err != nil {
+		return
+	}
+	return config, setTimeDisplay(config.TimeDisplay)
+}
+
+func loadConfigurationFromReader(r io.Reader) (config Config, err error) {
+	return decodeConfiguration(r)
+}
+
+// applyEnvOverrides points the state and download directories at paths from the environment, e.g. a mounted volume.
+func applyEnvOverrides(config Config, getenv func(string) string) Config {
+	config.StateDirectory = getEnvOrDefault(getenv, envStateDir, config.StateDirectory)
+	config.FileDownloadLocation = getEnvOrDefault(getenv, envDownloadDir, config.FileDownloadLocation)
+	return config
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeGetenv(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	is := assert.New(t)
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Error("Could not determine current directory")
+	}
+	configPath := filepath.Join(workingDir, "testdata", "fullConfig.json")
+	expected, err := loadConfigurationFromFile(configPath)
+	is.NoError(err)
+
+	actual, err := loadConfiguration(configPath, nil, fakeGetenv(nil))
+	is.NoError(err)
+	is.Equal(expected, actual, "Should load from the file by default")
+
+	contents, _ := os.ReadFile(configPath)
+	actual, err = loadConfiguration(stdinConfigPath, strings.NewReader(string(contents)), fakeGetenv(nil))
+	is.NoError(err)
+	is.Equal(expected, actual, "Should load from stdin when the path is -")
+
+	actual, err = loadConfiguration("doesNotExist.json", nil, fakeGetenv(map[string]string{envConfigJson: string(contents)}))
+	is.NoError(err)
+	is.Equal(expected, actual, "Should load from the environment when the json is set")
+
+	_, err = loadConfiguration(stdinConfigPath, strings.NewReader("{not json"), fakeGetenv(nil))
+	is.Error(err, "Should error on bad json from stdin")
+	_, err = loadConfiguration("doesNotExist.json", nil, fakeGetenv(nil))
+	is.Error(err, "Should error when the file does not exist")
+
+	actual, err = loadConfiguration(configPath, nil,
+		fakeGetenv(map[string]string{envStateDir: "/var/lib/validatebackups", envDownloadDir: "/downloads"}))
+	is.NoError(err)
+	is.Equal("/var/lib/validatebackups", actual.StateDirectory, "Should take the state directory from the environment")
+	is.Equal("/downloads", actual.FileDownloadLocation, "Should take the download directory from the environment")
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	is := assert.New(t)
+	config := Config{StateDirectory: "state", FileDownloadLocation: "downloads"}
+	is.Equal(config, applyEnvOverrides(config, fakeGetenv(nil)), "Should leave the config alone without overrides")
+	is.Equal(Config{StateDirectory: "other", FileDownloadLocation: "downloads"},
+		applyEnvOverrides(config, fakeGetenv(map[string]string{envStateDir: "other"})))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// faultInjectionFlag is hidden from help and completion, it's for exercising retries and resumes in tests and staging.
+const faultInjectionFlag = "fault-injection"
+
+// faultInjector makes downloads fail the way flaky networks and disks do. FailPercent of downloads fail before reading anything,
+// TruncatePercent stop writing part way through, leaving a .partial file for the retry to resume, and every download waits
+// up to MaxDelay before its first read. random picks a number in [0, n), rand.Int63n unless a test sets it.
+type faultInjector struct {
+	FailPercent     int64
+	TruncatePercent int64
+	MaxDelay        time.Duration
+	mu              sync.Mutex
+	random          func(n int64) int64
+}
+
+type faultInjectorKey struct{}
+
+// parseFaultInjection reads a spec like fail=10,truncate=5%,delay=2s, every part of which is optional.
+func parseFaultInjection(spec string) (*faultInjector, error) {
+	injector := &faultInjector{random: rand.Int63n}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, errors.NotValidf("Fault %q, expected fail=, truncate= or delay=", part)
+		}
+		switch key {
+		case "fail", "truncate":
+			percent, err := strconv.ParseInt(strings.TrimSuffix(value, "%"), 10, 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return nil, errors.NotValidf("Fault %s percentage %q, expected 0 to 100", key, value)
+			}
+			if key == "fail" {
+				injector.FailPercent = percent
+			} else {
+				injector.TruncatePercent = percent
+			}
+		case "delay":
+			delay, err := time.ParseDuration(value)
+			if err != nil || delay < 0 {
+				return nil, errors.NotValidf("Fault delay %q, expected a duration like 500ms", value)
+			}
+			injector.MaxDelay = delay
+		default:
+			return nil, errors.NotValidf("Fault %q, expected fail, truncate or delay", key)
+		}
+	}
+	return injector, nil
+}
+
+// withFaultInjector makes downloads under ctx fail as injector says. A nil injector injects nothing.
+func withFaultInjector(ctx context.Context, injector *faultInjector) context.Context {
+	return context.WithValue(ctx, faultInjectorKey{}, injector)
+}
+
+func faultInjectorFromContext(ctx context.Context) *faultInjector {
+	injector, _ := ctx.Value(faultInjectorKey{}).(*faultInjector)
+	return injector
+}
+
+func (f *faultInjector) String() string {
+	return fmt.Sprintf("failing %d%%, truncating %d%%, delaying up to %s", f.FailPercent, f.TruncatePercent, f.MaxDelay)
+}
+
+func (f *faultInjector) roll(n int64) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.random(n)
+}
+
+// failDownload is an error for the download to fail with, or nil when it goes ahead. It is safe to call on a nil faultInjector.
+func (f *faultInjector) failDownload(remoteFile string) error {
+	if f == nil || f.FailPercent == 0 || f.roll(100) >= f.FailPercent {
+		return nil
+	}
+	fmt.Fprintln(console.stdout, fmt.Sprintf("Injected fault, failing the download of %s.", remoteFile))
+	return errors.Errorf("injected fault, download of %s failed", remoteFile)
+}
+
+// wrapReader delays the first read of a download. It is safe to call on a nil faultInjector.
+func (f *faultInjector) wrapReader(r io.Reader) io.Reader {
+	if f == nil || f.MaxDelay <= 0 {
+		return r
+	}
+	return &delayedReader{r: r, delay: time.Duration(f.roll(int64(f.MaxDelay)))}
+}
+
+// wrapWriter cuts the download of an object of size bytes off at a random byte, for the ones picked to be truncated.
+// It is safe to call on a nil faultInjector.
+func (f *faultInjector) wrapWriter(w io.Writer, size int64) io.Writer {
+	if f == nil || f.TruncatePercent == 0 || size < 2 || f.roll(100) >= f.TruncatePercent {
+		return w
+	}
+	return &truncatingWriter{w: w, remaining: 1 + f.roll(size-1)}
+}
+
+type delayedReader struct {
+	r       io.Reader
+	delay   time.Duration
+	delayed bool
+}
+
+func (r *delayedReader) Read(p []byte) (int, error) {
+	if !r.delayed {
+		r.delayed = true
+		time.Sleep(r.delay)
+	}
+	return r.r.Read(p)
+}
+
+// truncatingWriter writes the first remaining bytes, then fails like a full disk or a dropped connection would.
+type truncatingWriter struct {
+	w         io.Writer
+	written   int64
+	remaining int64
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.remaining {
+		n, err := w.w.Write(p)
+		w.written += int64(n)
+		w.remaining -= int64(n)
+		return n, err
+	}
+	n, err := w.w.Write(p[:w.remaining])
+	w.written += int64(n)
+	w.remaining = 0
+	if err == nil {
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Injected fault, truncating the download at byte %d.", w.written))
+		err = errors.Errorf("injected fault, write truncated at byte %d", w.written)
+	}
+	return n, err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testParseFaultInjectionCases = []struct {
+	spec     string
+	fail     int64
+	truncate int64
+	delay    time.Duration
+	valid    bool
+}{
+	{"fail=10", 10, 0, 0, true},
+	{"fail=10%, truncate=5%,delay=2s", 10, 5, 2 * time.Second, true},
+	{"truncate=100", 0, 100, 0, true},
+	{"fail=101", 0, 0, 0, false},
+	{"truncate=-1", 0, 0, 0, false},
+	{"delay=soon", 0, 0, 0, false},
+	{"corrupt=10", 0, 0, 0, false},
+	{"fail", 0, 0, 0, false},
+	{"", 0, 0, 0, false},
+}
+
+func TestParseFaultInjection(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testParseFaultInjectionCases {
+		injector, err := parseFaultInjection(tc.spec)
+		if !tc.valid {
+			is.True(errors.IsNotValid(err), "case %q: %v", tc.spec, err)
+			continue
+		}
+		is.NoError(err, "case %q", tc.spec)
+		is.Equal(tc.fail, injector.FailPercent, "case %q", tc.spec)
+		is.Equal(tc.truncate, injector.TruncatePercent, "case %q", tc.spec)
+		is.Equal(tc.delay, injector.MaxDelay, "case %q", tc.spec)
+	}
+}
+
+// getTestFaultInjector rolls low, so every fault it has a percentage for is injected, and truncates at cutoff bytes.
+func getTestFaultInjector(failPercent, truncatePercent int64, cutoff int64) *faultInjector {
+	return &faultInjector{FailPercent: failPercent, TruncatePercent: truncatePercent, random: func(n int64) int64 {
+		if n == 100 {
+			return 0
+		}
+		return cutoff - 1
+	}}
+}
+
+func TestFaultInjector(t *testing.T) {
+	is := assert.New(t)
+	var injector *faultInjector
+	is.NoError(injector.failDownload("backup.tar.gz"), "Should inject nothing without an injector")
+	var output bytes.Buffer
+	is.Equal(&output, injector.wrapWriter(&output, 100))
+
+	injector = getTestFaultInjector(10, 0, 0)
+	is.ErrorContains(injector.failDownload("backup.tar.gz"), "injected fault, download of backup.tar.gz failed")
+	injector.random = func(n int64) int64 { return 10 }
+	is.NoError(injector.failDownload("backup.tar.gz"), "Should only fail the percentage asked for")
+
+	w := getTestFaultInjector(0, 100, 5).wrapWriter(&output, 100)
+	n, err := w.Write([]byte("abc"))
+	is.Equal(3, n)
+	is.NoError(err)
+	n, err = w.Write([]byte("defg"))
+	is.Equal(2, n)
+	is.ErrorContains(err, "write truncated at byte 5")
+	is.Equal("abcde", output.String())
+
+	injector = &faultInjector{MaxDelay: time.Second, random: func(n int64) int64 { return int64(20 * time.Millisecond) }}
+	start := time.Now()
+	r := injector.wrapReader(strings.NewReader("backup"))
+	buf := make([]byte, 3)
+	r.Read(buf)
+	r.Read(buf)
+	is.True(time.Since(start) >= 20*time.Millisecond && time.Since(start) < time.Second, "Should only delay the first read")
+}
+
+func TestDownloadFileWithInjectedTruncation(t *testing.T) {
+	is := assert.New(t)
+	content := []byte(strings.Repeat("backup data ", 1000))
+	client, ranges := newTestStorageServer(t, "test-matt-server-backups", map[string][]byte{"backup.tar.gz": content})
+	bucket := newGCSStore(client.Bucket("test-matt-server-backups"))
+	localFilePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+
+	injector := getTestFaultInjector(0, 100, 5000)
+	ctx := withFaultInjector(context.Background(), injector)
+	err := downloadFile(ctx, bucket, "backup.tar.gz", localFilePath)
+	is.ErrorContains(err, "injected fault, write truncated at byte 5000")
+	info, err := os.Stat(getPartialFilePath(localFilePath))
+	is.NoError(err)
+	is.Equal(int64(5000), info.Size(), "Should leave the partial file for the retry")
+
+	injector.TruncatePercent = 0
+	is.NoError(downloadFile(ctx, bucket, "backup.tar.gz", localFilePath))
+	is.Equal([]string{"", "bytes=5000-"}, *ranges, "Should resume from where the fault cut it off")
+	saved, _ := os.ReadFile(localFilePath)
+	is.Equal(content, saved)
+}
+
+func TestDownloadFileWithInjectedTruncationInChunks(t *testing.T) {
+	is := assert.New(t)
+	content := []byte(strings.Repeat("backup data ", 1000))
+	client, ranges := newTestStorageServer(t, "test-matt-server-backups", map[string][]byte{"backup.tar.gz": content})
+	bucket := newGCSStore(client.Bucket("test-matt-server-backups"))
+	localFilePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+
+	injector := getTestFaultInjector(0, 100, 1000)
+	tuner := &downloadTuner{current: TunedDownloads{Workers: 2, ChunkBytes: 3000}, settled: true}
+	ctx := withFaultInjector(withDownloadTuner(context.Background(), tuner), injector)
+	err := downloadFile(ctx, bucket, "backup.tar.gz", localFilePath)
+	is.ErrorContains(err, "injected fault, write truncated at byte 1000")
+	_, err = os.Stat(getPartialFilePath(localFilePath))
+	is.True(os.IsNotExist(err), "Should not leave a chunked partial file to resume from")
+
+	injector.TruncatePercent = 0
+	*ranges = nil
+	is.NoError(downloadFile(ctx, bucket, "backup.tar.gz", localFilePath))
+	is.NotContains(*ranges, "", "Should download in chunks again rather than resume")
+	saved, _ := os.ReadFile(localFilePath)
+	is.Equal(content, saved)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// metadataSidecarSuffix is added to a downloaded file's name for the sidecar holding the object's metadata.
+const metadataSidecarSuffix = ".metadata.json"
+
+// ObjectMetadataSidecar is saved next to a downloaded file, so the verified sample keeps everything the bucket knew about it.
+// VerifiedAt is when the snapshot was taken, for audits of what the object looked like when it was checked.
+// ACL is empty for buckets with uniform bucket level access, access is then granted on the whole bucket.
+type ObjectMetadataSidecar struct {
+	Bucket                  string            `json:"bucket"`
+	Name                    string            `json:"name"`
+	Generation              int64             `json:"generation"`
+	Metageneration          int64             `json:"metageneration"`
+	Size                    int64             `json:"size"`
+	ContentType             string            `json:"content_type,omitempty"`
+	ContentEncoding         string            `json:"content_encoding,omitempty"`
+	StorageClass            string            `json:"storage_class,omitempty"`
+	KMSKeyName              string            `json:"kms_key_name,omitempty"`
+	CustomerKeySHA256       string            `json:"customer_key_sha256,omitempty"`
+	Created                 time.Time         `json:"created"`
+	Updated                 time.Time         `json:"updated"`
+	RetentionExpirationTime time.Time         `json:"retention_expiration_time,omitempty"`
+	EventBasedHold          bool              `json:"event_based_hold,omitempty"`
+	TemporaryHold           bool              `json:"temporary_hold,omitempty"`
+	CRC32C                  uint32            `json:"crc32c"`
+	MD5                     string            `json:"md5,omitempty"`
+	Metadata                map[string]string `json:"metadata,omitempty"`
+	ACL                     []storage.ACLRule `json:"acl,omitempty"`
+	VerifiedAt              time.Time         `json:"verified_at"`
+}
+
+// getObjectModTime is when the object's contents were last written, falling back to when it was created.
+func getObjectModTime(attrs *storage.ObjectAttrs) time.Time {
+	if !attrs.Updated.IsZero() {
+		return attrs.Updated
+	}
+	return attrs.Created
+}
+
+// setLocalFileTimes gives the downloaded file the object's modification time, so it is a faithful copy for archival.
+func setLocalFileTimes(attrs *storage.ObjectAttrs, localFilePath string) error {
+	modTime := getObjectModTime(attrs)
+	if modTime.IsZero() {
+		return nil
+	}
+	err := os.Chtimes(localFilePath, modTime, modTime)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to set modification time of %s", localFilePath)
+	}
+	return nil
+}
+
+func getObjectMetadataSidecar(attrs *storage.ObjectAttrs, verifiedAt time.Time) ObjectMetadataSidecar {
+	sidecar := ObjectMetadataSidecar{
+		Bucket:                  attrs.Bucket,
+		Name:                    attrs.Name,
+		Generation:              attrs.Generation,
+		Metageneration:          attrs.Metageneration,
+		Size:                    attrs.Size,
+		ContentType:             attrs.ContentType,
+		ContentEncoding:         attrs.ContentEncoding,
+		StorageClass:            attrs.StorageClass,
+		KMSKeyName:              attrs.KMSKeyName,
+		CustomerKeySHA256:       attrs.CustomerKeySHA256,
+		Created:                 attrs.Created,
+		Updated:                 attrs.Updated,
+		RetentionExpirationTime: attrs.RetentionExpirationTime,
+		EventBasedHold:          attrs.EventBasedHold,
+		TemporaryHold:           attrs.TemporaryHold,
+		CRC32C:                  attrs.CRC32C,
+		Metadata:                attrs.Metadata,
+		ACL:                     attrs.ACL,
+		VerifiedAt:              verifiedAt.UTC(),
+	}
+	if len(attrs.MD5) > 0 {
+		sidecar.MD5 = base64.StdEncoding.EncodeToString(attrs.MD5)
+	}
+	return sidecar
+}
+
+// writeMetadataSidecar saves the object's metadata as of verifiedAt next to its downloaded file, with the same modification time.
+func writeMetadataSidecar(attrs *storage.ObjectAttrs, localFilePath string, verifiedAt time.Time) error {
+	sidecarPath := localFilePath + metadataSidecarSuffix
+	sidecarFile, err := os.Create(sidecarPath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open metadata sidecar %s for saving data.", sidecarPath)
+	}
+	encoder := json.NewEncoder(sidecarFile)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(getObjectMetadataSidecar(attrs, verifiedAt))
+	sidecarFile.Close()
+	if err != nil {
+		return errors.Annotatef(err, "Unable to save metadata sidecar %s", sidecarPath)
+	}
+	return setLocalFileTimes(attrs, sidecarPath)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetObjectModTime(t *testing.T) {
+	is := assert.New(t)
+	created := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	is.Equal(updated, getObjectModTime(&storage.ObjectAttrs{Created: created, Updated: updated}))
+	is.Equal(created, getObjectModTime(&storage.ObjectAttrs{Created: created}), "Should fall back to the creation time")
+}
+
+func TestSetLocalFileTimes(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), "episode.mkv")
+	is.NoError(os.WriteFile(filePath, []byte("episode"), 0644))
+	updated := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	is.NoError(setLocalFileTimes(&storage.ObjectAttrs{Updated: updated}, filePath))
+	fileInfo, err := os.Stat(filePath)
+	is.NoError(err)
+	is.True(updated.Equal(fileInfo.ModTime()), "Should match the object's modification time")
+
+	is.NoError(setLocalFileTimes(&storage.ObjectAttrs{}, filePath), "Should leave files alone without object times")
+	is.Error(setLocalFileTimes(&storage.ObjectAttrs{Updated: updated}, filepath.Join(t.TempDir(), "missing.mkv")))
+}
+
+func TestWriteMetadataSidecar(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), "episode.mkv")
+	attrs := &storage.ObjectAttrs{
+		Bucket:       "test-matt-media",
+		Name:         "show 1/episode.mkv",
+		Generation:   42,
+		Size:         7,
+		ContentType:  "video/x-matroska",
+		Created:      time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		Updated:      time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC),
+		CRC32C:       1234,
+		MD5:          []byte{1, 2, 3},
+		Metadata:     map[string]string{"source": "camera"},
+		StorageClass: "NEARLINE",
+		KMSKeyName:   "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		ACL:          []storage.ACLRule{{Entity: storage.AllAuthenticatedUsers, Role: storage.RoleReader}},
+	}
+	verifiedAt := time.Date(2018, 1, 20, 1, 2, 3, 0, time.UTC)
+	is.NoError(writeMetadataSidecar(attrs, filePath, verifiedAt))
+
+	contents, err := os.ReadFile(filePath + metadataSidecarSuffix)
+	is.NoError(err)
+	var sidecar ObjectMetadataSidecar
+	is.NoError(json.Unmarshal(contents, &sidecar))
+	is.Equal(getObjectMetadataSidecar(attrs, verifiedAt), sidecar)
+	is.Equal("AQID", sidecar.MD5)
+	is.Equal("camera", sidecar.Metadata["source"])
+	is.Equal("NEARLINE", sidecar.StorageClass)
+	is.Equal(attrs.KMSKeyName, sidecar.KMSKeyName)
+	is.Equal(storage.RoleReader, sidecar.ACL[0].Role)
+	is.Equal(verifiedAt, sidecar.VerifiedAt)
+
+	fileInfo, err := os.Stat(filePath + metadataSidecarSuffix)
+	is.NoError(err)
+	is.True(attrs.Updated.Equal(fileInfo.ModTime()))
+
+	is.Error(writeMetadataSidecar(attrs, filepath.Join(t.TempDir(), "missing", "episode.mkv"), verifiedAt))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// fileNameManifestName is kept in each bucket's download directory, mapping sanitized local paths back to object names.
+const fileNameManifestName = "fileNames.json"
+
+// windowsReservedNames can't be used as file names on Windows, with or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeObjectPath makes each part of an object name safe to use as a file name on Windows as well as elsewhere.
+// Unsafe characters are percent encoded, e.g. : becomes %3A, and so is % itself, so the original name can always be recovered.
+func sanitizeObjectPath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = sanitizeFileName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func sanitizeFileName(name string) string {
+	var sanitized strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"\|?*%`, r) {
+			fmt.Fprintf(&sanitized, "%%%02X", r)
+			continue
+		}
+		sanitized.WriteRune(r)
+	}
+	result := sanitized.String()
+	//windows drops trailing dots and spaces
+	if last := len(result) - 1; last >= 0 && (result[last] == '.' || result[last] == ' ') {
+		result = fmt.Sprintf("%s%%%02X", result[:last], result[last])
+	}
+	base := strings.ToUpper(strings.SplitN(result, ".", 2)[0])
+	if windowsReservedNames[base] {
+		result = fmt.Sprintf("%%%02X%s", result[0], result[1:])
+	}
+	return result
+}
+
+func getFileNameManifestPath(downloadLocation string, bucketName string) string {
+	return filepath.Join(downloadLocation, bucketName, fileNameManifestName)
+}
+
+// loadFileNameManifest loads the map of sanitized local paths, relative to the bucket's download directory, to object names.
+// It starts an empty manifest if there isn't one yet.
+func loadFileNameManifest(filePath string) (manifest map[string]string, err error) {
+	manifest = make(map[string]string)
+	contents, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to open file name manifest at %s", filePath)
+		return
+	}
+	err = json.Unmarshal(contents, &manifest)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to parse file name manifest at %s", filePath)
+	}
+	return
+}
+
+func saveFileNameManifest(filePath string, manifest map[string]string) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open file name manifest %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	encoder := json.NewEncoder(jsonFile)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+// recordSanitizedFileName adds the object to the manifest when its local path had to be sanitized, returning whether it did.
+func recordSanitizedFileName(manifest map[string]string, downloadLocation string, bucketName string, remoteFile string) bool {
+	localFile := getLocalFilePath(downloadLocation, bucketName, remoteFile, true)
+	if localFile == getLocalFilePath(downloadLocation, bucketName, remoteFile, false) {
+		return false
+	}
+	relative, err := filepath.Rel(filepath.Join(downloadLocation, bucketName), localFile)
+	if err != nil {
+		return false
+	}
+	manifest[filepath.ToSlash(relative)] = remoteFile
+	return true
+}
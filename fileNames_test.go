@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testSanitizeObjectPathCases = []struct {
+	name     string
+	expected string
+}{
+	{name: "show 1/episode.ogv", expected: "show 1/episode.ogv"},
+	{name: "backup-2018-01-01T01:02:03.tar.gz", expected: "backup-2018-01-01T01%3A02%3A03.tar.gz"},
+	{name: "what?/why*.txt", expected: "what%3F/why%2A.txt"},
+	{name: `a<b>c"d\e|f`, expected: "a%3Cb%3Ec%22d%5Ce%7Cf"},
+	{name: "100%.txt", expected: "100%25.txt"},
+	{name: "tab\there", expected: "tab%09here"},
+	{name: "trailing./dot", expected: "trailing%2E/dot"},
+	{name: "trailing space ", expected: "trailing space%20"},
+	{name: "CON", expected: "%43ON"},
+	{name: "dir/nul.txt", expected: "dir/%6Eul.txt"},
+	{name: "CONSOLE.txt", expected: "CONSOLE.txt"},
+	{name: "unicode/ünïcödé.txt", expected: "unicode/ünïcödé.txt"},
+}
+
+func TestSanitizeObjectPath(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testSanitizeObjectPathCases {
+		actual := sanitizeObjectPath(tc.name)
+		is.Equal(tc.expected, actual, tc.name)
+		original, err := url.PathUnescape(actual)
+		is.NoError(err)
+		is.Equal(tc.name, original, "Should be reversible")
+	}
+}
+
+func TestFileNameManifest(t *testing.T) {
+	is := assert.New(t)
+	dir := t.TempDir()
+	manifestPath := getFileNameManifestPath(dir, "test-matt-server-backups")
+
+	manifest, err := loadFileNameManifest(manifestPath)
+	is.NoError(err, "Should start an empty manifest when there isn't a file")
+	is.Empty(manifest)
+
+	is.False(recordSanitizedFileName(manifest, dir, "test-matt-server-backups", "backup.tar.gz"),
+		"Should not record names that didn't need sanitizing")
+	is.True(recordSanitizedFileName(manifest, dir, "test-matt-server-backups", "daily/backup-01:02:03.tar.gz"))
+	is.Equal(map[string]string{"daily/backup-01%3A02%3A03.tar.gz": "daily/backup-01:02:03.tar.gz"}, manifest)
+
+	is.NoError(saveFileNameManifest(manifestPath, manifest))
+	loaded, err := loadFileNameManifest(manifestPath)
+	is.NoError(err)
+	is.Equal(manifest, loaded)
+
+	is.NoError(os.WriteFile(manifestPath, []byte("not json"), 0644))
+	_, err = loadFileNameManifest(manifestPath)
+	is.Error(err, "Should not overwrite a manifest it can't parse")
+	is.Equal(filepath.Join(dir, "test-matt-server-backups", fileNameManifestName), manifestPath)
+}
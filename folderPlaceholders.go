@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// hadoopFolderSuffix ends the placeholders Hadoop and older S3 tools make, e.g. backups_$folder$.
+const hadoopFolderSuffix = "_$folder$"
+
+// isFolderPlaceholder is true for the empty objects some tools and the console make to stand in for a directory,
+// e.g. 2018-01/ or 2018-01_$folder$. They aren't backups, so they are never sampled, downloaded or validated.
+func isFolderPlaceholder(objAttrs *storage.ObjectAttrs) bool {
+	if objAttrs == nil || len(objAttrs.Prefix) > 0 || objAttrs.Size != 0 {
+		return false
+	}
+	return strings.HasSuffix(objAttrs.Name, "/") || strings.HasSuffix(objAttrs.Name, hadoopFolderSuffix)
+}
+
+// placeholderSkippingIterator lists objects without their folder placeholders, at any depth.
+// Prefixes from a delimited listing are kept, see prefixHasObjects for telling whether one holds anything.
+type placeholderSkippingIterator struct {
+	it objectIterator
+}
+
+func (it placeholderSkippingIterator) Next() (*storage.ObjectAttrs, error) {
+	for {
+		objAttrs, err := it.it.Next()
+		if err != nil || !isFolderPlaceholder(objAttrs) {
+			return objAttrs, err
+		}
+	}
+}
+
+// prefixHasObjects is true when there is at least one object under prefix other than folder placeholders.
+// A directory a tool made but never put anything in is still listed as a prefix, this tells them apart.
+func prefixHasObjects(ctx context.Context, bucket BackupStore, prefix string) (bool, error) {
+	_, err := listObjects(ctx, bucket, &storage.Query{Prefix: prefix}).Next()
+	if err == iterator.Done {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+)
+
+var testIsFolderPlaceholderCases = []struct {
+	objAttrs *storage.ObjectAttrs
+	expected bool
+}{
+	{&storage.ObjectAttrs{Name: "show 1/"}, true},
+	{&storage.ObjectAttrs{Name: "show 1/season 1/"}, true},
+	{&storage.ObjectAttrs{Name: "backups_$folder$"}, true},
+	{&storage.ObjectAttrs{Name: "show 1/", Size: 10}, false},
+	{&storage.ObjectAttrs{Name: "show 1/episode 1.mkv"}, false},
+	{&storage.ObjectAttrs{Prefix: "show 1/"}, false},
+	{nil, false},
+}
+
+func TestIsFolderPlaceholder(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testIsFolderPlaceholderCases {
+		is.Equal(tc.expected, isFolderPlaceholder(tc.objAttrs), "case %+v", tc.objAttrs)
+	}
+}
+
+func getTestPlaceholderContext() context.Context {
+	return withInventoryReport(context.Background(), &inventoryReport{objects: []*storage.ObjectAttrs{
+		{Name: "empty show/"},
+		{Name: "empty show/season 1/"},
+		{Name: "show 1/"},
+		{Name: "show 1/episode 1.mkv", Size: 10},
+		{Name: "show 1/season 2/"},
+		{Name: "show 1/season 2/episode 1.mkv", Size: 10},
+		{Name: "show 2_$folder$"},
+	}})
+}
+
+func TestListObjectsSkipsFolderPlaceholders(t *testing.T) {
+	is := assert.New(t)
+	it := listObjects(getTestPlaceholderContext(), nil, nil)
+	var names []string
+	for {
+		objAttrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		is.NoError(err)
+		names = append(names, objAttrs.Name)
+	}
+	is.Equal([]string{"show 1/episode 1.mkv", "show 1/season 2/episode 1.mkv"}, names)
+
+	files, err := getRandomFilesFromBucket(getTestPlaceholderContext(), nil, 2, "show 1/", samplingOptions{})
+	is.NoError(err)
+	is.ElementsMatch([]string{"show 1/episode 1.mkv", "show 1/season 2/episode 1.mkv"}, files, "Should never sample a placeholder")
+}
+
+func TestGetBucketTopLevelDirsSkipsPlaceholders(t *testing.T) {
+	is := assert.New(t)
+	dirs, err := getBucketTopLevelDirs(getTestPlaceholderContext(), nil)
+	is.NoError(err)
+	is.Equal([]string{"show 1/"}, dirs, "Should not find a show in a directory with only placeholders")
+
+	hasObjects, err := prefixHasObjects(getTestPlaceholderContext(), nil, "empty show/")
+	is.NoError(err)
+	is.False(hasObjects)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// freeSpaceCheckBytes is how much of a download is written between checks of the free space left.
+	freeSpaceCheckBytes = 64 << 20
+	// freeSpacePollInterval is how often free space is checked again while waiting for some to be freed.
+	freeSpacePollInterval = 30 * time.Second
+)
+
+// freeSpaceGuard keeps downloads from taking the download volume below minFree bytes, so a run can't fill the disk
+// other services on the box need. When a download would, it waits up to wait for space to be freed, then stops
+// the run with a QuotaLimitExceeded error, leaving the in progress file and any .partial file for the next run to resume.
+type freeSpaceGuard struct {
+	dir     string
+	minFree int64
+	wait    time.Duration
+	getFree func(dir string) (uint64, error)
+	sleep   func(d time.Duration)
+}
+
+type freeSpaceGuardKey struct{}
+
+// newFreeSpaceGuard guards the download location with min_free_bytes, nil when that isn't set.
+func newFreeSpaceGuard(config Config) *freeSpaceGuard {
+	if config.MinFreeBytes <= 0 {
+		return nil
+	}
+	return &freeSpaceGuard{dir: config.FileDownloadLocation, minFree: config.MinFreeBytes,
+		wait: time.Duration(config.LowSpaceWaitMinutes) * time.Minute, getFree: getFreeDiskSpace, sleep: time.Sleep}
+}
+
+func withFreeSpaceGuard(ctx context.Context, guard *freeSpaceGuard) context.Context {
+	return context.WithValue(ctx, freeSpaceGuardKey{}, guard)
+}
+
+func freeSpaceGuardFromContext(ctx context.Context) *freeSpaceGuard {
+	guard, _ := ctx.Value(freeSpaceGuardKey{}).(*freeSpaceGuard)
+	return guard
+}
+
+// check makes sure needed more bytes can be written without going below the floor, waiting for space when they can't.
+// The free space seen is recorded on the bucket summary in ctx. It is safe to call on a nil freeSpaceGuard.
+func (g *freeSpaceGuard) check(ctx context.Context, needed int64) error {
+	if g == nil {
+		return nil
+	}
+	for polls := 0; ; polls++ {
+		free, err := g.getFree(g.dir)
+		if err != nil {
+			return errors.Annotatef(err, "Unable to check free space in %s", g.dir)
+		}
+		recordFreeSpace(ctx, int64(free))
+		if int64(free)-needed >= g.minFree {
+			return nil
+		}
+		if time.Duration(polls)*freeSpacePollInterval >= g.wait {
+			return errors.QuotaLimitExceededf("Only %s free in %s, %s more would go below min_free_bytes %s",
+				formatBytes(int64(free)), g.dir, formatBytes(needed), formatBytes(g.minFree))
+		}
+		if polls == 0 {
+			recordWarning(ctx, "only %s free in %s, below min_free_bytes %s, waiting up to %s for space",
+				formatBytes(int64(free)), g.dir, formatBytes(g.minFree), g.wait)
+		}
+		g.sleep(freeSpacePollInterval)
+	}
+}
+
+// wrapWriter checks free space every freeSpaceCheckBytes written, remaining being how much more the download will write.
+// It is safe to call on a nil freeSpaceGuard.
+func (g *freeSpaceGuard) wrapWriter(ctx context.Context, w io.Writer, remaining int64) io.Writer {
+	if g == nil {
+		return w
+	}
+	return &freeSpaceWriter{ctx: ctx, w: w, guard: g, remaining: remaining}
+}
+
+type freeSpaceWriter struct {
+	ctx       context.Context
+	w         io.Writer
+	guard     *freeSpaceGuard
+	remaining int64
+	unchecked int64
+}
+
+func (w *freeSpaceWriter) Write(p []byte) (int, error) {
+	if w.unchecked >= freeSpaceCheckBytes {
+		w.unchecked = 0
+		err := w.guard.check(w.ctx, w.remaining)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.w.Write(p)
+	w.unchecked += int64(n)
+	w.remaining -= int64(n)
+	return n, err
+}
+
+// recordFreeSpace keeps the least free space seen on the download volume on the bucket summary in ctx, if there is one.
+func recordFreeSpace(ctx context.Context, free int64) {
+	bs := bucketSummaryFromContext(ctx)
+	if bs != nil && (bs.DownloadStats.LowestFreeBytes == 0 || free < bs.DownloadStats.LowestFreeBytes) {
+		//0 means free space wasn't checked
+		bs.DownloadStats.LowestFreeBytes = max(free, 1)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestFreeSpaceGuard reports each of frees in turn, then the last one forever, and counts the times it slept.
+func getTestFreeSpaceGuard(minFree int64, wait time.Duration, frees ...uint64) (guard *freeSpaceGuard, sleeps *int) {
+	sleeps = new(int)
+	guard = &freeSpaceGuard{dir: "downloads", minFree: minFree, wait: wait,
+		getFree: func(dir string) (uint64, error) {
+			free := frees[0]
+			if len(frees) > 1 {
+				frees = frees[1:]
+			}
+			return free, nil
+		},
+		sleep: func(d time.Duration) { *sleeps++ }}
+	return
+}
+
+var testFreeSpaceGuardCheckCases = []struct {
+	frees    []uint64
+	needed   int64
+	wait     time.Duration
+	sleeps   int
+	lowest   int64
+	warnings int
+	stopped  bool
+}{
+	{[]uint64{1000}, 400, 0, 0, 1000, 0, false},
+	{[]uint64{1000}, 600, 0, 0, 1000, 0, true},
+	{[]uint64{500, 700, 1100}, 600, 5 * time.Minute, 2, 500, 1, false},
+	{[]uint64{500}, 100, 90 * time.Second, 3, 500, 1, true},
+}
+
+func TestFreeSpaceGuardCheck(t *testing.T) {
+	is := assert.New(t)
+	for i, tc := range testFreeSpaceGuardCheckCases {
+		bs := &BucketSummary{BucketName: "test-matt-media"}
+		ctx := withBucketSummary(context.Background(), bs)
+		guard, sleeps := getTestFreeSpaceGuard(500, tc.wait, tc.frees...)
+		err := guard.check(ctx, tc.needed)
+		if tc.stopped {
+			is.True(errors.IsQuotaLimitExceeded(err), "case %d: %v", i, err)
+		} else {
+			is.NoError(err, "case %d", i)
+		}
+		is.Equal(tc.sleeps, *sleeps, "case %d", i)
+		is.Equal(tc.lowest, bs.DownloadStats.LowestFreeBytes, "case %d", i)
+		is.Len(bs.Warnings, tc.warnings, "case %d", i)
+	}
+}
+
+func TestFreeSpaceGuardNil(t *testing.T) {
+	is := assert.New(t)
+	var guard *freeSpaceGuard
+	is.NoError(guard.check(context.Background(), 1<<40))
+	var buf bytes.Buffer
+	is.Equal(&buf, guard.wrapWriter(context.Background(), &buf, 1<<40))
+	is.Nil(newFreeSpaceGuard(Config{FileDownloadLocation: "downloads"}), "Should only guard when min_free_bytes is set")
+	is.Nil(freeSpaceGuardFromContext(context.Background()))
+}
+
+func TestFreeSpaceWriter(t *testing.T) {
+	is := assert.New(t)
+	checks := 0
+	guard := &freeSpaceGuard{dir: "downloads", minFree: 100, getFree: func(dir string) (uint64, error) {
+		checks++
+		if checks > 1 {
+			return 50, nil
+		}
+		return freeSpaceCheckBytes * 4, nil
+	}, sleep: func(d time.Duration) {}}
+	var buf bytes.Buffer
+	w := guard.wrapWriter(context.Background(), &buf, freeSpaceCheckBytes*2)
+	chunk := make([]byte, freeSpaceCheckBytes/2)
+	for i := 0; i < 2; i++ {
+		_, err := w.Write(chunk)
+		is.NoError(err)
+	}
+	is.Equal(0, checks, "Should not check before freeSpaceCheckBytes are written")
+	_, err := w.Write(chunk)
+	is.NoError(err)
+	is.Equal(1, checks)
+	_, err = w.Write(chunk)
+	is.NoError(err)
+	is.Equal(1, checks)
+	n, err := w.Write(chunk)
+	is.True(errors.IsQuotaLimitExceeded(err), "Should stop once free space drops below the floor: %v", err)
+	is.Equal(0, n)
+	is.Equal(freeSpaceCheckBytes*2, buf.Len())
+}
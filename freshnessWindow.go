@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+type freshnessWindowKey struct{}
+
+// withFreshnessWindow attaches the bucket's freshness window to ctx, with Days defaulted from the rules, so finding the
+// newest and oldest backups lists by name instead of the whole bucket. ctx is returned as is when the window isn't set.
+func withFreshnessWindow(ctx context.Context, window FreshnessWindow, rules ServerFileValidationRules) (context.Context, error) {
+	if len(window.NameLayout) == 0 {
+		return ctx, nil
+	}
+	//a layout without a year in it names the same day of every year the same, so it can't sort by date
+	sample := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	if sample.Format(window.NameLayout) == sample.AddDate(-1, 0, 0).Format(window.NameLayout) {
+		return ctx, errors.NotValidf("Freshness window name layout %q without a year in it", window.NameLayout)
+	}
+	if window.Days <= 0 {
+		window.Days = rules.NewestFileMaxAgeInDays
+	}
+	return context.WithValue(ctx, freshnessWindowKey{}, window), nil
+}
+
+func freshnessWindowFromContext(ctx context.Context) (window FreshnessWindow, found bool) {
+	window, found = ctx.Value(freshnessWindowKey{}).(FreshnessWindow)
+	return
+}
+
+// getObjectNameOn is the name a backup from the day would sort from, under the bucket prefix in ctx.
+func getObjectNameOn(ctx context.Context, window FreshnessWindow, day time.Time) string {
+	return bucketPrefixFromContext(ctx) + day.UTC().Format(window.NameLayout)
+}
+
+// getRecentObjectsQuery lists the backups named within the window, or is nil to list everything when there's no window in ctx.
+func getRecentObjectsQuery(ctx context.Context, now time.Time) *storage.Query {
+	window, found := freshnessWindowFromContext(ctx)
+	if !found {
+		return nil
+	}
+	return &storage.Query{StartOffset: getObjectNameOn(ctx, window, now.AddDate(0, 0, -window.Days))}
+}
+
+// getRecentlyNamedObjectsQuery lists the backups named within the last days days, or is nil to list everything
+// when there's no window in ctx or days is 0.
+func getRecentlyNamedObjectsQuery(ctx context.Context, days int, now time.Time) *storage.Query {
+	window, found := freshnessWindowFromContext(ctx)
+	if !found || days <= 0 {
+		return nil
+	}
+	return &storage.Query{StartOffset: getObjectNameOn(ctx, window, now.AddDate(0, 0, -days))}
+}
+
+// getArchivedObjectsQuery lists the backups named maxAgeDays or more ago, the ones that should have been archived,
+// or is nil to list everything when there's no window in ctx.
+func getArchivedObjectsQuery(ctx context.Context, maxAgeDays int, now time.Time) *storage.Query {
+	window, found := freshnessWindowFromContext(ctx)
+	if !found {
+		return nil
+	}
+	return &storage.Query{EndOffset: getObjectNameOn(ctx, window, now.AddDate(0, 0, -maxAgeDays))}
+}
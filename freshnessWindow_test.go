@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestDatedBackups has a backup named for each of the last days days, created on the day it is named for.
+func getTestDatedBackups(now time.Time, days int) (objects []*storage.ObjectAttrs) {
+	for i := days - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i)
+		objects = append(objects, &storage.ObjectAttrs{Name: "nightly/" + day.UTC().Format("2006-01-02") + ".tar.gz", Created: day, Size: 10})
+	}
+	return
+}
+
+func TestWithFreshnessWindow(t *testing.T) {
+	is := assert.New(t)
+	rules := ServerFileValidationRules{NewestFileMaxAgeInDays: 3}
+	ctx, err := withFreshnessWindow(context.Background(), FreshnessWindow{}, rules)
+	is.NoError(err)
+	_, found := freshnessWindowFromContext(ctx)
+	is.False(found, "Should leave ctx alone without a name layout")
+
+	ctx, err = withFreshnessWindow(context.Background(), FreshnessWindow{NameLayout: "2006-01-02"}, rules)
+	is.NoError(err)
+	window, found := freshnessWindowFromContext(ctx)
+	is.True(found)
+	is.Equal(3, window.Days, "Should default to newest_file_max_age_in_days")
+
+	_, err = withFreshnessWindow(context.Background(), FreshnessWindow{NameLayout: "backup-01-02"}, rules)
+	is.True(errors.IsNotValid(err), "Should error on a layout without a year")
+}
+
+func TestGetFreshnessWindowQueries(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	is.Nil(getRecentObjectsQuery(context.Background(), now), "Should list everything without a window")
+	is.Nil(getArchivedObjectsQuery(context.Background(), 30, now))
+
+	ctx, _ := withFreshnessWindow(withBucketPrefix(context.Background(), "nightly/"), FreshnessWindow{NameLayout: "2006-01/", Days: 40},
+		ServerFileValidationRules{})
+	is.Equal("nightly/2026-09/", getRecentObjectsQuery(ctx, now).StartOffset)
+	is.Equal("nightly/2025-10/", getArchivedObjectsQuery(ctx, 365, now).EndOffset)
+	is.Equal("nightly/2026-10/", getRecentlyNamedObjectsQuery(ctx, 7, now).StartOffset)
+	is.Nil(getRecentlyNamedObjectsQuery(ctx, 0, now), "Should list everything when every object is checked")
+	is.Nil(getRecentlyNamedObjectsQuery(context.Background(), 7, now))
+}
+
+func TestGetNewestObjectFromBucketInWindow(t *testing.T) {
+	is := assert.New(t)
+	now := time.Now()
+	bs := &BucketSummary{}
+	report := &inventoryReport{objects: getTestDatedBackups(now, 100)}
+	ctx, err := withFreshnessWindow(withInventoryReport(withBucketSummary(context.Background(), bs), report),
+		FreshnessWindow{NameLayout: "nightly/2006-01-02", Days: 5}, ServerFileValidationRules{})
+	is.NoError(err)
+
+	newest, err := getNewestObjectFromBucket(ctx, nil, nil)
+	is.NoError(err)
+	is.Equal(report.objects[99].Name, newest.Name)
+	is.Equal(6, bs.ObjectsExamined, "Should only list the backups named within the window")
+	is.False(bs.Inventory.Complete, "Should not count a windowed listing as the whole bucket")
+
+	//backups stopped 10 days ago, so nothing is named inside the window
+	bs.ObjectsExamined = 0
+	report.objects = report.objects[:90]
+	newest, err = getNewestObjectFromBucket(ctx, nil, nil)
+	is.NoError(err)
+	is.Equal(report.objects[89].Name, newest.Name, "Should list everything to find the real newest backup")
+	is.Equal(90, bs.ObjectsExamined)
+}
+
+func TestValidateServerBackupsInWindow(t *testing.T) {
+	is := assert.New(t)
+	now := time.Now()
+	rules := ServerFileValidationRules{OldestFileMaxAgeInDays: 30, NewestFileMaxAgeInDays: 2}
+	report := &inventoryReport{objects: getTestDatedBackups(now, 20)}
+	ctx, err := withFreshnessWindow(withInventoryReport(context.Background(), report), FreshnessWindow{NameLayout: "nightly/2006-01-02"}, rules)
+	is.NoError(err)
+	is.NoError(validateServerBackups(ctx, nil, rules, nil, nil, nil), "Should pass without backups named past the archive cutoff")
+
+	report.objects = getTestDatedBackups(now, 40)
+	err = validateServerBackups(ctx, nil, rules, nil, nil, nil)
+	is.True(errors.IsNotValid(err), "Should fail when backups named past the archive cutoff are still there")
+	is.Contains(err.Error(), "Oldest file")
+
+	report.objects = getTestDatedBackups(now, 20)[:15]
+	err = validateServerBackups(ctx, nil, rules, nil, nil, nil)
+	is.True(errors.IsNotValid(err), "Should fail when nothing was backed up within the window")
+	is.Contains(err.Error(), "Newest file")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/juju/errors"
+)
+
+type fsyncDownloadsKey struct{}
+
+// withFsyncDownloads makes downloads flush each file, and the directory entry it is renamed to, to disk before they
+// count as verified, see fsync_downloads.
+func withFsyncDownloads(ctx context.Context, enabled bool) context.Context {
+	if !enabled {
+		return ctx
+	}
+	return context.WithValue(ctx, fsyncDownloadsKey{}, true)
+}
+
+func fsyncDownloadsFromContext(ctx context.Context) bool {
+	enabled, _ := ctx.Value(fsyncDownloadsKey{}).(bool)
+	return enabled
+}
+
+// syncFile flushes the file's data and attributes, including the modification time it was given, out of the write back cache.
+func syncFile(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open %s to flush it to disk", filePath)
+	}
+	defer file.Close()
+	err = file.Sync()
+	if err != nil {
+		return errors.Annotatef(err, "Unable to flush %s to disk", filePath)
+	}
+	return file.Close()
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/juju/errors"
+)
+
+// renameDurably renames the file then fsyncs the directory it was renamed into, without which the rename
+// can be lost on power loss even though the file's data was flushed.
+func renameDurably(from string, to string) error {
+	err := os.Rename(from, to)
+	if err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(to))
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open directory of %s to flush it to disk", to)
+	}
+	defer dir.Close()
+	err = dir.Sync()
+	if err != nil {
+		return errors.Annotatef(err, "Unable to flush directory of %s to disk", to)
+	}
+	return nil
+}
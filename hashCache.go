@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const hashCacheFileName = "hashCache.json"
+
+// hashCache remembers the CRC32C of local files, so re-runs don't re-hash downloads that haven't changed since.
+// A file is unchanged when its size and modification time match what they were when it was hashed.
+type hashCache struct {
+	Files map[string]hashCacheEntry `json:"files"`
+	dirty bool
+}
+
+type hashCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	CRC32C  uint32    `json:"crc32c"`
+}
+
+type hashCacheKey struct{}
+
+func getHashCacheFilePath(config Config) string {
+	return filepath.Join(getStateDirectory(config), hashCacheFileName)
+}
+
+// withHashCache attaches the cache to ctx so file verification can use it. Without one every file is hashed.
+func withHashCache(ctx context.Context, cache *hashCache) context.Context {
+	return context.WithValue(ctx, hashCacheKey{}, cache)
+}
+
+func hashCacheFromContext(ctx context.Context) *hashCache {
+	cache, _ := ctx.Value(hashCacheKey{}).(*hashCache)
+	return cache
+}
+
+// loadHashCache loads the cache file, or starts an empty cache if there isn't one yet.
+// A cache that can't be parsed is started over, it only saves time.
+func loadHashCache(filePath string) (cache *hashCache, err error) {
+	cache = &hashCache{Files: make(map[string]hashCacheEntry)}
+	contents, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to open hash cache file at %s", filePath)
+		return
+	}
+	if json.Unmarshal(contents, cache) != nil || cache.Files == nil {
+		cache.Files = make(map[string]hashCacheEntry)
+	}
+	return cache, nil
+}
+
+// openHashCache loads the config's hash cache, unless noCache is set for a paranoid run that hashes every file.
+func openHashCache(config Config, noCache bool) (*hashCache, error) {
+	if noCache {
+		return nil, nil
+	}
+	return loadHashCache(getHashCacheFilePath(config))
+}
+
+// saveHashCache saves the cache, only warning on failure since the cache just saves time.
+func saveHashCache(config Config, cache *hashCache) {
+	err := cache.save(getHashCacheFilePath(config))
+	if err != nil {
+		fmt.Fprintln(console.stdout, "Warning: unable to save hash cache.", err)
+	}
+}
+
+// save writes the cache back to filePath if anything was added to it. It is safe to call on a nil cache.
+func (c *hashCache) save(filePath string) error {
+	if c == nil || !c.dirty {
+		return nil
+	}
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open hash cache file %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	err = json.NewEncoder(jsonFile).Encode(c)
+	if err == nil {
+		c.dirty = false
+	}
+	return err
+}
+
+func (c *hashCache) lookup(filePath string, fileInfo os.FileInfo) (crc uint32, found bool) {
+	if c == nil {
+		return
+	}
+	entry, found := c.Files[getHashCacheKey(filePath)]
+	if !found || entry.Size != fileInfo.Size() || !entry.ModTime.Equal(fileInfo.ModTime()) {
+		return 0, false
+	}
+	return entry.CRC32C, true
+}
+
+func (c *hashCache) store(filePath string, fileInfo os.FileInfo, crc uint32) {
+	if c == nil {
+		return
+	}
+	c.Files[getHashCacheKey(filePath)] = hashCacheEntry{Size: fileInfo.Size(), ModTime: fileInfo.ModTime(), CRC32C: crc}
+	c.dirty = true
+}
+
+func getHashCacheKey(filePath string) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		return abs
+	}
+	return filePath
+}
+
+// getCachedCrc32CFromFile gets the file's CRC32C from the hash cache in ctx when the file is unchanged,
+// otherwise it hashes the file and caches the result.
+func getCachedCrc32CFromFile(ctx context.Context, filePath string, fileInfo os.FileInfo) (crc uint32, err error) {
+	cache := hashCacheFromContext(ctx)
+	crc, found := cache.lookup(filePath, fileInfo)
+	if found {
+		return
+	}
+	crc, err = getCrc32CFromFile(filePath)
+	if err == nil {
+		cache.store(filePath, fileInfo, crc)
+	}
+	return
+}
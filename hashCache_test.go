@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCachedCrc32CFromFile(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), "file.txt")
+	is.NoError(os.WriteFile(filePath, []byte("hello"), 0644))
+	expected, err := getCrc32CFromFile(filePath)
+	is.NoError(err)
+	fileInfo, err := os.Stat(filePath)
+	is.NoError(err)
+
+	crc, err := getCachedCrc32CFromFile(context.Background(), filePath, fileInfo)
+	is.NoError(err)
+	is.Equal(expected, crc, "Should hash the file without a cache")
+
+	cache := &hashCache{Files: make(map[string]hashCacheEntry)}
+	ctx := withHashCache(context.Background(), cache)
+	crc, err = getCachedCrc32CFromFile(ctx, filePath, fileInfo)
+	is.NoError(err)
+	is.Equal(expected, crc)
+	is.True(cache.dirty, "Should cache the hash")
+
+	cache.store(filePath, fileInfo, 42)
+	crc, err = getCachedCrc32CFromFile(ctx, filePath, fileInfo)
+	is.NoError(err)
+	is.Equal(uint32(42), crc, "Should trust the cache for unchanged files")
+
+	later := fileInfo.ModTime().Add(time.Minute)
+	is.NoError(os.Chtimes(filePath, later, later))
+	fileInfo, err = os.Stat(filePath)
+	is.NoError(err)
+	crc, err = getCachedCrc32CFromFile(ctx, filePath, fileInfo)
+	is.NoError(err)
+	is.Equal(expected, crc, "Should re-hash files modified since they were cached")
+}
+
+func TestHashCacheLookup(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), "file.txt")
+	is.NoError(os.WriteFile(filePath, []byte("hello"), 0644))
+	fileInfo, err := os.Stat(filePath)
+	is.NoError(err)
+
+	var nilCache *hashCache
+	nilCache.store(filePath, fileInfo, 1)
+	_, found := nilCache.lookup(filePath, fileInfo)
+	is.False(found, "Should not find anything in a nil cache")
+
+	cache := &hashCache{Files: make(map[string]hashCacheEntry)}
+	_, found = cache.lookup(filePath, fileInfo)
+	is.False(found)
+	cache.Files[getHashCacheKey(filePath)] = hashCacheEntry{Size: 6, ModTime: fileInfo.ModTime(), CRC32C: 1}
+	_, found = cache.lookup(filePath, fileInfo)
+	is.False(found, "Should not use entries with a different size")
+	cache.store(filePath, fileInfo, 7)
+	crc, found := cache.lookup(filePath, fileInfo)
+	is.True(found)
+	is.Equal(uint32(7), crc)
+}
+
+func TestSaveAndLoadHashCache(t *testing.T) {
+	is := assert.New(t)
+	dir := t.TempDir()
+	cacheFilePath := filepath.Join(dir, "state", hashCacheFileName)
+	filePath := filepath.Join(dir, "file.txt")
+	is.NoError(os.WriteFile(filePath, []byte("hello"), 0644))
+	fileInfo, err := os.Stat(filePath)
+	is.NoError(err)
+
+	cache, err := loadHashCache(cacheFilePath)
+	is.NoError(err, "Should start an empty cache when there isn't a file")
+	is.NoError(cache.save(cacheFilePath))
+	_, err = os.Stat(cacheFilePath)
+	is.True(os.IsNotExist(err), "Should not save an unchanged cache")
+
+	cache.store(filePath, fileInfo, 7)
+	is.NoError(cache.save(cacheFilePath))
+	loaded, err := loadHashCache(cacheFilePath)
+	is.NoError(err)
+	crc, found := loaded.lookup(filePath, fileInfo)
+	is.True(found)
+	is.Equal(uint32(7), crc)
+
+	is.NoError(os.WriteFile(cacheFilePath, []byte("not json"), 0644))
+	loaded, err = loadHashCache(cacheFilePath)
+	is.NoError(err, "Should start over when the cache is corrupt")
+	is.Empty(loaded.Files)
+
+	var nilCache *hashCache
+	is.NoError(nilCache.save(cacheFilePath))
+	cache, err = openHashCache(Config{StateDirectory: filepath.Dir(cacheFilePath)}, true)
+	is.NoError(err)
+	is.Nil(cache, "Should not use a cache with --no-cache")
+}
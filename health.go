@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// healthServer answers liveness and readiness probes while a run is going.
+// /healthz is ok as long as the process is serving, /readyz only once the run has its config, a connection and the state lock.
+type healthServer struct {
+	ready  atomic.Bool
+	server *http.Server
+}
+
+// startHealthServer listens on addr in the background. An empty addr turns the probes off and returns nil, which is safe to use.
+func startHealthServer(addr string) (*healthServer, error) {
+	if len(addr) == 0 {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to listen for health checks on %s", addr)
+	}
+	hs := &healthServer{}
+	hs.server = &http.Server{Handler: hs.handler(), ReadHeaderTimeout: 5 * time.Second}
+	go hs.server.Serve(listener)
+	return hs, nil
+}
+
+func (hs *healthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !hs.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+func (hs *healthServer) setReady(ready bool) {
+	if hs != nil {
+		hs.ready.Store(ready)
+	}
+}
+
+func (hs *healthServer) close() {
+	if hs != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		hs.server.Shutdown(ctx)
+	}
+}
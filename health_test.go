@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthServerHandler(t *testing.T) {
+	is := assert.New(t)
+	hs := &healthServer{}
+	handler := hs.handler()
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	is.Equal(http.StatusOK, get("/healthz"), "Should be live straight away")
+	is.Equal(http.StatusServiceUnavailable, get("/readyz"), "Should not be ready until told so")
+	hs.setReady(true)
+	is.Equal(http.StatusOK, get("/readyz"))
+}
+
+func TestStartHealthServer(t *testing.T) {
+	is := assert.New(t)
+	hs, err := startHealthServer("")
+	is.NoError(err)
+	is.Nil(hs, "Should not serve anything without an address")
+	hs.setReady(true)
+	hs.close()
+
+	hs, err = startHealthServer("127.0.0.1:0")
+	is.NoError(err)
+	hs.close()
+
+	_, err = startHealthServer("not an address")
+	is.Error(err, "Should error on a bad address")
+}
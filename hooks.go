@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	defaultHookTimeout = 5 * time.Minute
+	// maxHookOutput keeps a chatty hook from flooding the report.
+	maxHookOutput       = 2048
+	hookFilePlaceholder = "{file}"
+)
+
+// HookResult captures what happened when a post download hook ran against a downloaded file.
+type HookResult struct {
+	File     string        `json:"file"`
+	ExitCode int           `json:"exit_code"`
+	Output   string        `json:"output"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (r HookResult) passed() bool {
+	return r.ExitCode == 0 && len(r.Error) == 0
+}
+
+// getHookCommandArgs substitutes the file into the hook command, or appends it when there is no {file} placeholder.
+func getHookCommandArgs(command []string, filePath string) []string {
+	args := make([]string, 0, len(command)+1)
+	substituted := false
+	for _, arg := range command {
+		if strings.Contains(arg, hookFilePlaceholder) {
+			arg = strings.ReplaceAll(arg, hookFilePlaceholder, filePath)
+			substituted = true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, filePath)
+	}
+	return args
+}
+
+// runPostDownloadHook runs the hook against a downloaded file, killing it if it runs past the timeout.
+// Failures are captured in the result rather than returned, so one bad file doesn't stop the run.
+func runPostDownloadHook(ctx context.Context, hook PostDownloadHook, filePath string) (result HookResult) {
+	result.File = filePath
+	if len(hook.Command) == 0 {
+		result.Error = "hook has no command"
+		return
+	}
+	timeout := defaultHookTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := getHookCommandArgs(hook.Command, filePath)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	err := cmd.Run()
+	result.Duration = time.Since(start)
+	result.Output = strings.TrimSpace(output.String())
+	if len(result.Output) > maxHookOutput {
+		result.Output = result.Output[:maxHookOutput] + "..."
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Error = errors.Timeoutf("Hook after %v", timeout).Error()
+	} else if err != nil && result.ExitCode <= 0 {
+		result.Error = err.Error()
+	}
+	return
+}
+
+// recordHookResult adds the result to the bucket summary in ctx, if there is one.
+func recordHookResult(ctx context.Context, result HookResult) {
+	if bs := bucketSummaryFromContext(ctx); bs != nil {
+		bs.HookResults = append(bs.HookResults, result)
+	}
+}
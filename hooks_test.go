@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHookHelperProcess isn't a real test, it stands in for the external hook commands.
+func TestHookHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HOOK_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	switch args[1] {
+	case "pass":
+		fmt.Println("checked", args[2])
+		os.Exit(0)
+	case "fail":
+		fmt.Println("corrupt", args[2])
+		os.Exit(2)
+	case "hang":
+		select {}
+	}
+}
+
+func getHelperHook(mode string, timeoutSeconds int) PostDownloadHook {
+	return PostDownloadHook{
+		Command:        []string{os.Args[0], "-test.run=TestHookHelperProcess", "--", mode},
+		TimeoutSeconds: timeoutSeconds,
+	}
+}
+
+func TestGetHookCommandArgs(t *testing.T) {
+	is := assert.New(t)
+	is.Equal([]string{"tar", "-tzf", "backup.tar.gz"}, getHookCommandArgs([]string{"tar", "-tzf"}, "backup.tar.gz"))
+	is.Equal([]string{"vlc", "--play-and-exit", "file:///ep.mkv", "vlc://quit"},
+		getHookCommandArgs([]string{"vlc", "--play-and-exit", "file://{file}", "vlc://quit"}, "/ep.mkv"))
+}
+
+func TestRunPostDownloadHook(t *testing.T) {
+	is := assert.New(t)
+	os.Setenv("GO_WANT_HOOK_HELPER_PROCESS", "1")
+	defer os.Unsetenv("GO_WANT_HOOK_HELPER_PROCESS")
+	ctx := context.Background()
+
+	result := runPostDownloadHook(ctx, getHelperHook("pass", 0), "good.tar.gz")
+	is.True(result.passed(), "Should pass when the hook exits cleanly: %v", result)
+	is.Contains(result.Output, "checked good.tar.gz", "Should capture the hook output")
+
+	result = runPostDownloadHook(ctx, getHelperHook("fail", 0), "bad.tar.gz")
+	is.False(result.passed(), "Should fail when the hook exits with an error")
+	is.Equal(2, result.ExitCode)
+	is.Contains(result.Output, "corrupt bad.tar.gz")
+
+	result = runPostDownloadHook(ctx, getHelperHook("hang", 1), "slow.mkv")
+	is.False(result.passed(), "Should fail when the hook times out")
+	is.Contains(result.Error, "timeout")
+
+	result = runPostDownloadHook(ctx, PostDownloadHook{Command: []string{"does-not-exist-validatebackups-hook"}}, "file")
+	is.False(result.passed(), "Should fail when the hook command can't be found")
+	is.NotEmpty(result.Error)
+
+	result = runPostDownloadHook(ctx, PostDownloadHook{}, "file")
+	is.False(result.passed(), "Should fail when the hook has no command")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// validateHostFreshness applies NewestFileMaxAgeInDays to each host in a shared server-backup bucket,
+// a host being the first path segment of the object names, so one busy host can't make silent ones look fresh.
+// Objects at the top level belong to no host and are ignored. Every stale host is named in the error.
+func validateHostFreshness(ctx context.Context, bucket BackupStore, rules ServerFileValidationRules,
+	freshnessMatcher *objectNameMatcher, now time.Time) (err error) {
+	newestByHost, err := getNewestObjectPerHost(ctx, bucket, freshnessMatcher)
+	if err != nil {
+		return errors.Annotate(err, "Unable to get newest object of each host")
+	}
+	var hosts []string
+	for host := range newestByHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	var stale []string
+	for _, host := range hosts {
+		newest := newestByHost[host]
+		ageInDays := int(now.Sub(newest.Created) / (time.Hour * 24))
+		if ageInDays >= rules.NewestFileMaxAgeInDays {
+			stale = append(stale, fmt.Sprintf("%s newest file %s was created on %s", host, newest.Name, formatTime(newest.Created)))
+		}
+	}
+	if len(stale) > 0 {
+		return errors.NotValidf("Hosts (%s), too long in the past. Make sure backups are running on them", strings.Join(stale, "; "))
+	}
+	return nil
+}
+
+// getNewestObjectPerHost lists the bucket once, keeping the newest object accepted by matcher under each host,
+// with host names taken relative to the bucket prefix in ctx.
+func getNewestObjectPerHost(ctx context.Context, bucket BackupStore, matcher *objectNameMatcher) (newestByHost map[string]*storage.ObjectAttrs, err error) {
+	newestByHost = make(map[string]*storage.ObjectAttrs)
+	prefix := bucketPrefixFromContext(ctx)
+	it := listObjects(ctx, bucket, nil)
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			break
+		}
+		if err2 != nil {
+			return nil, err2
+		}
+		if !matcher.matches(objAttrs.Name) {
+			continue
+		}
+		host, _, found := strings.Cut(strings.TrimPrefix(objAttrs.Name, prefix), "/")
+		if !found {
+			continue
+		}
+		if newest := newestByHost[host]; newest == nil || objAttrs.Created.After(newest.Created) {
+			newestByHost[host] = objAttrs
+		}
+	}
+	return
+}
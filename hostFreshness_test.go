@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testHostFreshnessNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+var testHostFreshnessObjects = []*storage.ObjectAttrs{
+	{Name: "hosts/alpha/2026-03-10.tar.gz", Created: testHostFreshnessNow},
+	{Name: "hosts/alpha/2026-03-01.tar.gz", Created: testHostFreshnessNow.AddDate(0, 0, -9)},
+	{Name: "hosts/beta/2026-03-01.tar.gz", Created: testHostFreshnessNow.AddDate(0, 0, -9)},
+	{Name: "hosts/beta/README", Created: testHostFreshnessNow},
+	{Name: "hosts/gamma/2026-02-01.tar.gz", Created: testHostFreshnessNow.AddDate(0, -1, -9)},
+	{Name: "hosts/index.html", Created: testHostFreshnessNow.AddDate(-1, 0, 0)},
+}
+
+func TestGetNewestObjectPerHost(t *testing.T) {
+	is := assert.New(t)
+	ctx := withBucketPrefix(withInventoryReport(context.Background(), &inventoryReport{objects: testHostFreshnessObjects}), "hosts/")
+	newestByHost, err := getNewestObjectPerHost(ctx, nil, nil)
+	is.NoError(err)
+	is.Equal(3, len(newestByHost), "Top level objects should not count as a host")
+	is.Equal("hosts/alpha/2026-03-10.tar.gz", newestByHost["alpha"].Name)
+	is.Equal("hosts/beta/README", newestByHost["beta"].Name)
+	is.Equal("hosts/gamma/2026-02-01.tar.gz", newestByHost["gamma"].Name)
+}
+
+func TestValidateHostFreshness(t *testing.T) {
+	is := assert.New(t)
+	ctx := withBucketPrefix(withInventoryReport(context.Background(), &inventoryReport{objects: testHostFreshnessObjects}), "hosts/")
+	matcher, err := newObjectNameMatcher(ObjectNameFilter{ExcludePatterns: []string{"README$"}})
+	is.NoError(err)
+	rules := ServerFileValidationRules{OldestFileMaxAgeInDays: 365, NewestFileMaxAgeInDays: 2}
+
+	err = validateHostFreshness(ctx, nil, rules, matcher, testHostFreshnessNow)
+	is.True(errors.IsNotValid(err), "Stale hosts should fail even with one host up to date")
+	is.Contains(err.Error(), "beta newest file hosts/beta/2026-03-01.tar.gz")
+	is.Contains(err.Error(), "gamma newest file")
+	is.NotContains(err.Error(), "alpha")
+
+	//the readme is the only thing keeping beta fresh without the freshness filter
+	rules.NewestFileMaxAgeInDays = 30
+	err = validateHostFreshness(ctx, nil, rules, nil, testHostFreshnessNow)
+	is.True(errors.IsNotValid(err))
+	is.NotContains(err.Error(), "beta")
+	is.Contains(err.Error(), "gamma")
+
+	rules.NewestFileMaxAgeInDays = 60
+	is.NoError(validateHostFreshness(ctx, nil, rules, matcher, testHostFreshnessNow))
+}
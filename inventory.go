@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// maxInventorySnapshots keeps about a year of daily runs per bucket in the history file.
+const maxInventorySnapshots = 400
+
+// InventoryTotals counts objects and their bytes.
+type InventoryTotals struct {
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// BucketInventory totals every distinct live object listed while processing a bucket, overall and by top level prefix.
+// Objects listed more than once, e.g. by validation and then sampling, are only counted once.
+// Complete is set once the whole bucket has been listed, otherwise the totals only cover the prefixes that were looked at.
+// ZeroByteObjects counts empty objects, other than the ones ending in a slash that stand in for directories.
+type BucketInventory struct {
+	InventoryTotals
+	Complete        bool                       `json:"complete"`
+	Prefixes        map[string]InventoryTotals `json:"prefixes"`
+	ZeroByteObjects int                        `json:"zero_byte_objects,omitempty"`
+	seen            map[string]bool
+}
+
+// InventorySnapshot is a bucket's inventory as of one run, kept in the history to spot growth or shrinkage over time.
+// NewestObject and ValidationResult are from the bucket's summary, for the digest to follow freshness and failures across runs.
+type InventorySnapshot struct {
+	Taken            time.Time `json:"taken"`
+	RunID            string    `json:"run_id"`
+	Profile          string    `json:"profile,omitempty"`
+	NewestObject     time.Time `json:"newest_object,omitempty"`
+	ValidationResult string    `json:"validation_result,omitempty"`
+	BucketInventory
+}
+
+func (inv *BucketInventory) add(attrs *storage.ObjectAttrs) {
+	if attrs == nil || !attrs.Deleted.IsZero() || len(attrs.Prefix) > 0 || inv.seen[attrs.Name] {
+		return
+	}
+	if inv.seen == nil {
+		inv.seen = make(map[string]bool)
+		inv.Prefixes = make(map[string]InventoryTotals)
+	}
+	inv.seen[attrs.Name] = true
+	inv.Objects++
+	inv.Bytes += attrs.Size
+	if attrs.Size == 0 && !strings.HasSuffix(attrs.Name, "/") {
+		inv.ZeroByteObjects++
+	}
+	prefix := getTopLevelPrefix(attrs.Name)
+	totals := inv.Prefixes[prefix]
+	totals.Objects++
+	totals.Bytes += attrs.Size
+	inv.Prefixes[prefix] = totals
+}
+
+// getTopLevelPrefix is the first directory of an object name including its slash, or blank for objects at the top of the bucket.
+func getTopLevelPrefix(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i+1]
+	}
+	return ""
+}
+
+// markInventoryComplete notes the whole bucket has been listed, for the bucket summary in ctx if there is one.
+func markInventoryComplete(ctx context.Context) {
+	if bs := bucketSummaryFromContext(ctx); bs != nil {
+		bs.Inventory.Complete = true
+	}
+}
+
+// recordInventory adds a snapshot of each bucket's inventory from the run to the history, dropping the oldest beyond maxInventorySnapshots.
+// Buckets that weren't listed at all are skipped, and a resumed run replaces its earlier snapshot.
+func (h *SamplingHistory) recordInventory(rs *RunSummary, runID string, profile string, when time.Time) {
+	if rs == nil {
+		return
+	}
+	if h.Inventories == nil {
+		h.Inventories = make(map[string][]InventorySnapshot)
+	}
+	for _, bs := range rs.Buckets {
+		if bs.Inventory.Objects == 0 {
+			continue
+		}
+		snapshots := h.Inventories[bs.BucketName]
+		if len(snapshots) > 0 && snapshots[len(snapshots)-1].RunID == runID {
+			snapshots = snapshots[:len(snapshots)-1]
+		}
+		snapshots = append(snapshots, InventorySnapshot{Taken: when, RunID: runID, Profile: profile,
+			NewestObject: bs.NewestObject, ValidationResult: bs.ValidationResult, BucketInventory: bs.Inventory})
+		if len(snapshots) > maxInventorySnapshots {
+			snapshots = snapshots[len(snapshots)-maxInventorySnapshots:]
+		}
+		h.Inventories[bs.BucketName] = snapshots
+	}
+}
+
+// getPreviousInventory finds the latest snapshot of the bucket taken by an earlier run with the same profile, so like is compared with like.
+func (h *SamplingHistory) getPreviousInventory(bucketName string, profile string, runID string) (snapshot InventorySnapshot, found bool) {
+	snapshots := h.Inventories[bucketName]
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Profile == profile && snapshots[i].RunID != runID {
+			return snapshots[i], true
+		}
+	}
+	return
+}
+
+// writeInventory lists each bucket's inventory by top level prefix, with the change since the previous run of the same profile.
+func writeInventory(w io.Writer, rs *RunSummary, history *SamplingHistory, runID string, profile string) error {
+	if rs == nil {
+		return nil
+	}
+	fmt.Fprintln(w, "\nInventory:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Bucket\tPrefix\tObjects\tSize\tChange Since Last Run\t")
+	for _, bs := range rs.Buckets {
+		if bs.Inventory.Objects == 0 {
+			continue
+		}
+		previous, found := history.getPreviousInventory(bs.BucketName, profile, runID)
+		total := "(all)"
+		if !bs.Inventory.Complete {
+			total = "(listed prefixes)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t\n", bs.BucketName, total, bs.Inventory.Objects, formatBytes(bs.Inventory.Bytes),
+			describeInventoryChange(previous.InventoryTotals, bs.Inventory.InventoryTotals, found))
+
+		prefixes := make([]string, 0, len(bs.Inventory.Prefixes))
+		for prefix := range bs.Inventory.Prefixes {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		for _, prefix := range prefixes {
+			totals := bs.Inventory.Prefixes[prefix]
+			previousTotals, previousFound := previous.Prefixes[prefix]
+			label := prefix
+			if len(label) == 0 {
+				label = "(top level)"
+			}
+			fmt.Fprintf(tw, "\t%s\t%d\t%s\t%s\t\n", label, totals.Objects, formatBytes(totals.Bytes),
+				describeInventoryChange(previousTotals, totals, found && previousFound))
+		}
+	}
+	return tw.Flush()
+}
+
+func describeInventoryChange(previous InventoryTotals, current InventoryTotals, found bool) string {
+	if !found {
+		return "new"
+	}
+	bytesChange := current.Bytes - previous.Bytes
+	sign := "+"
+	if bytesChange < 0 {
+		sign, bytesChange = "-", -bytesChange
+	}
+	return fmt.Sprintf("%+d objects, %s%s", current.Objects-previous.Objects, sign, formatBytes(bytesChange))
+}
+
+// warnBucketSizes warns about buckets holding max_total_bytes_warn or more, e.g. runaway log backups running up the bill.
+// An incomplete inventory only undercounts, so it can still show a bucket is over.
+func warnBucketSizes(rs *RunSummary, buckets []BucketToProcess) {
+	if rs == nil {
+		return
+	}
+	for _, bucketConfig := range buckets {
+		if bucketConfig.MaxTotalBytesWarn <= 0 {
+			continue
+		}
+		for _, bs := range rs.Buckets {
+			if bs.BucketName == bucketConfig.Name && bs.Inventory.Bytes >= bucketConfig.MaxTotalBytesWarn {
+				recordWarning(withBucketSummary(context.Background(), bs), "bucket %s holds %s, over its max_total_bytes_warn of %s.",
+					bs.BucketName, formatBytes(bs.Inventory.Bytes), formatBytes(bucketConfig.MaxTotalBytesWarn))
+			}
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// objectIterator is what listing a bucket gives back, either from the live API or an inventory report.
+type objectIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+// inventoryReport is a bucket's object listing read from a Storage Insights inventory report or a listing dump,
+// so huge buckets can be validated and sampled without listing millions of objects through the API.
+// Objects are sorted by name, the same order the API lists them in.
+type inventoryReport struct {
+	objects []*storage.ObjectAttrs
+}
+
+type inventoryReportKey struct{}
+
+// withInventoryReport attaches the report to ctx so listing functions read from it instead of the bucket.
+func withInventoryReport(ctx context.Context, report *inventoryReport) context.Context {
+	return context.WithValue(ctx, inventoryReportKey{}, report)
+}
+
+func inventoryReportFromContext(ctx context.Context) *inventoryReport {
+	report, _ := ctx.Value(inventoryReportKey{}).(*inventoryReport)
+	return report
+}
+
+// withBucketInventoryReport loads the bucket's inventory report into ctx when one is configured, otherwise ctx is returned as is.
+func withBucketInventoryReport(ctx context.Context, bucketConfig BucketToProcess) (context.Context, error) {
+	if len(bucketConfig.InventoryFile) == 0 {
+		return ctx, nil
+	}
+	report, err := loadInventoryReport(bucketConfig.InventoryFile)
+	if err != nil {
+		return ctx, errors.Annotatef(err, "Unable to load inventory report for bucket %s", bucketConfig.Name)
+	}
+	return withInventoryReport(ctx, report), nil
+}
+
+// listObjects lists the bucket, from the inventory report in ctx if there is one, and only under the bucket prefix in ctx.
+// Inventory reports only hold live objects, so listing versions always goes to the API. Folder placeholders are left out either way.
+func listObjects(ctx context.Context, bucket BackupStore, q *storage.Query) objectIterator {
+	q = scopeQuery(ctx, q)
+	report := inventoryReportFromContext(ctx)
+	if report == nil || (q != nil && q.Versions) {
+		return placeholderSkippingIterator{bucket.Objects(ctx, q)}
+	}
+	var query storage.Query
+	if q != nil {
+		query = *q
+	}
+	return placeholderSkippingIterator{&inventoryReportIterator{objects: report.objects, query: query, prefixesSeen: make(map[string]bool)}}
+}
+
+// inventoryReportIterator lists a report like the API would, honouring the query's Prefix, Delimiter, StartOffset and EndOffset.
+type inventoryReportIterator struct {
+	objects      []*storage.ObjectAttrs
+	next         int
+	query        storage.Query
+	prefixesSeen map[string]bool
+}
+
+func (it *inventoryReportIterator) Next() (*storage.ObjectAttrs, error) {
+	for it.next < len(it.objects) {
+		obj := it.objects[it.next]
+		it.next++
+		if !strings.HasPrefix(obj.Name, it.query.Prefix) || obj.Name < it.query.StartOffset {
+			continue
+		}
+		if len(it.query.EndOffset) > 0 && obj.Name >= it.query.EndOffset {
+			//objects are sorted by name, so nothing after this is before the end either
+			it.next = len(it.objects)
+			break
+		}
+		if len(it.query.Delimiter) > 0 {
+			rest := obj.Name[len(it.query.Prefix):]
+			if i := strings.Index(rest, it.query.Delimiter); i >= 0 {
+				prefix := it.query.Prefix + rest[:i+len(it.query.Delimiter)]
+				if it.prefixesSeen[prefix] {
+					continue
+				}
+				it.prefixesSeen[prefix] = true
+				return &storage.ObjectAttrs{Prefix: prefix}, nil
+			}
+		}
+		return obj, nil
+	}
+	return nil, iterator.Done
+}
+
+// loadInventoryReport reads a Storage Insights inventory report exported as CSV,
+// or an object listing dump in JSON, either an array or one object per line, e.g. from gcloud storage objects list --format=json.
+// Both use the JSON API field names such as name, size, timeCreated and crc32c.
+func loadInventoryReport(filePath string) (report *inventoryReport, err error) {
+	var read func(io.Reader) (*inventoryReport, error)
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".csv":
+		read = readInventoryReportCsv
+	case ".json", ".jsonl", ".ndjson":
+		read = readInventoryReportJson
+	default:
+		err = errors.NotSupportedf("Inventory report format of %s, export it as csv instead of parquet", filePath)
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to open inventory report %s", filePath)
+		return
+	}
+	defer file.Close()
+
+	report, err = read(file)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to read inventory report %s", filePath)
+		return
+	}
+	sort.Slice(report.objects, func(i, j int) bool { return report.objects[i].Name < report.objects[j].Name })
+	return
+}
+
+func readInventoryReportCsv(r io.Reader) (report *inventoryReport, err error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		err = errors.Annotate(err, "Unable to read inventory report header")
+		return
+	}
+	columns := make(map[string]int)
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	if _, found := columns["name"]; !found {
+		err = errors.NotValidf("Inventory report without a name column")
+		return
+	}
+	report = &inventoryReport{}
+	for line := 2; ; line++ {
+		record, err2 := reader.Read()
+		if err2 == io.EOF {
+			break
+		}
+		if err2 != nil {
+			return nil, errors.Annotatef(err2, "Unable to read inventory report line %d", line)
+		}
+		fields := make(map[string]string)
+		for column, i := range columns {
+			if i < len(record) {
+				fields[column] = record[i]
+			}
+		}
+		attrs, err2 := getInventoryObjectAttrs(fields)
+		if err2 != nil {
+			return nil, errors.Annotatef(err2, "Invalid inventory report line %d", line)
+		}
+		report.objects = append(report.objects, attrs)
+	}
+	return
+}
+
+func readInventoryReportJson(r io.Reader) (report *inventoryReport, err error) {
+	buffered := bufio.NewReader(r)
+	decoder := json.NewDecoder(buffered)
+	//dumps are either one big array or one object per line
+	first, err := firstNonSpaceByte(buffered)
+	if err != nil && err != io.EOF {
+		return
+	}
+	if first == '[' {
+		if _, err = decoder.Token(); err != nil {
+			return
+		}
+	}
+	report = &inventoryReport{}
+	for decoder.More() {
+		var raw map[string]interface{}
+		err = decoder.Decode(&raw)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to parse inventory object %d", len(report.objects)+1)
+		}
+		fields := make(map[string]string)
+		for key, value := range raw {
+			switch v := value.(type) {
+			case string:
+				fields[key] = v
+			case float64:
+				fields[key] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		attrs, err2 := getInventoryObjectAttrs(fields)
+		if err2 != nil {
+			return nil, errors.Annotatef(err2, "Invalid inventory object %d", len(report.objects)+1)
+		}
+		report.objects = append(report.objects, attrs)
+	}
+	return report, nil
+}
+
+func firstNonSpaceByte(r *bufio.Reader) (b byte, err error) {
+	for {
+		var peeked []byte
+		peeked, err = r.Peek(1)
+		if err != nil {
+			return
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(peeked[0])) {
+			return peeked[0], nil
+		}
+		r.ReadByte()
+	}
+}
+
+// getInventoryObjectAttrs builds the attributes validation and sampling use from an inventory entry's fields.
+// Only name is required, other fields are used when present.
+func getInventoryObjectAttrs(fields map[string]string) (attrs *storage.ObjectAttrs, err error) {
+	attrs = &storage.ObjectAttrs{Name: fields["name"], ContentType: fields["contentType"], KMSKeyName: fields["kmsKeyName"]}
+	if len(attrs.Name) == 0 {
+		return nil, errors.NotValidf("Inventory entry without a name")
+	}
+	if size := fields["size"]; len(size) > 0 {
+		attrs.Size, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, errors.NotValidf("Size %s of %s", size, attrs.Name)
+		}
+	}
+	if generation := fields["generation"]; len(generation) > 0 {
+		attrs.Generation, err = strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return nil, errors.NotValidf("Generation %s of %s", generation, attrs.Name)
+		}
+	}
+	attrs.Created, err = parseInventoryTime(fields["timeCreated"])
+	if err != nil {
+		return nil, errors.Annotatef(err, "Invalid timeCreated of %s", attrs.Name)
+	}
+	attrs.Updated, err = parseInventoryTime(fields["updated"])
+	if err != nil {
+		return nil, errors.Annotatef(err, "Invalid updated time of %s", attrs.Name)
+	}
+	if crc := fields["crc32c"]; len(crc) > 0 {
+		decoded, err2 := base64.StdEncoding.DecodeString(crc)
+		if err2 != nil || len(decoded) != 4 {
+			return nil, errors.NotValidf("crc32c %s of %s", crc, attrs.Name)
+		}
+		attrs.CRC32C = binary.BigEndian.Uint32(decoded)
+	}
+	if md5 := fields["md5Hash"]; len(md5) > 0 {
+		attrs.MD5, err = base64.StdEncoding.DecodeString(md5)
+		if err != nil {
+			return nil, errors.NotValidf("md5Hash %s of %s", md5, attrs.Name)
+		}
+	}
+	return
+}
+
+func parseInventoryTime(value string) (t time.Time, err error) {
+	if len(value) == 0 {
+		return
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+)
+
+func TestLoadInventoryReport(t *testing.T) {
+	is := assert.New(t)
+	report, err := loadInventoryReport("testdata/inventory.csv")
+	is.NoError(err)
+	is.Len(report.objects, 4)
+	is.Equal("readme.txt", report.objects[0].Name, "Should sort objects by name")
+	last := report.objects[3]
+	is.Equal("show-two/episode1.mkv", last.Name)
+	is.Equal(int64(2048), last.Size)
+	is.Equal(uint32(1), last.CRC32C)
+	is.Len(last.MD5, 16)
+	is.Equal("video/x-matroska", last.ContentType)
+	is.Equal(int64(1514862245123000), last.Generation)
+	is.Equal(time.Date(2018, 1, 2, 3, 4, 5, 123000000, time.UTC), last.Created)
+
+	report, err = loadInventoryReport("testdata/inventory.json")
+	is.NoError(err)
+	is.Len(report.objects, 2)
+	is.Equal(int64(2048), report.objects[0].Size, "Should accept sizes as numbers")
+	is.Equal(int64(4096), report.objects[1].Size, "Should accept sizes as strings")
+
+	_, err = loadInventoryReport("testdata/inventory.parquet")
+	is.True(errors.IsNotSupported(err), "Should not support parquet")
+	_, err = loadInventoryReport("testdata/missing.csv")
+	is.Error(err, "Should error on a missing file")
+	_, err = loadInventoryReport("testdata/fullConfig.json")
+	is.Error(err, "Should error on json that isn't a listing")
+}
+
+func TestReadInventoryReportJsonLines(t *testing.T) {
+	is := assert.New(t)
+	report, err := readInventoryReportJson(strings.NewReader(
+		"{\"name\": \"a.txt\", \"size\": \"1\"}\n{\"name\": \"b.txt\", \"size\": \"2\"}\n"))
+	is.NoError(err)
+	is.Len(report.objects, 2)
+	report, err = readInventoryReportJson(strings.NewReader("  \n"))
+	is.NoError(err)
+	is.Len(report.objects, 0, "Should accept an empty listing")
+}
+
+var testReadInventoryReportCsvErrorCases = []string{
+	"",
+	"bucket,size\nb,1\n",
+	"name,size\na.txt,big\n",
+	"name,generation\na.txt,first\n",
+	"name,crc32c\na.txt,AAAA\n",
+	"name,md5Hash\na.txt,!!\n",
+	"name,timeCreated\na.txt,yesterday\n",
+	"name,updated\na.txt,yesterday\n",
+	"name,size\n,1\n",
+	"name,size\n\"a.txt,1\n",
+}
+
+func TestReadInventoryReportCsvErrors(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testReadInventoryReportCsvErrorCases {
+		_, err := readInventoryReportCsv(strings.NewReader(tc))
+		is.Error(err, tc)
+	}
+}
+
+func getTestInventoryReportListing(q *storage.Query) (listed []string, err error) {
+	report, err := loadInventoryReport("testdata/inventory.csv")
+	if err != nil {
+		return
+	}
+	it := listObjects(withInventoryReport(context.Background(), report), nil, q)
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			return
+		}
+		if err2 != nil {
+			return nil, err2
+		}
+		listed = append(listed, objAttrs.Name+objAttrs.Prefix)
+	}
+}
+
+var testListObjectsFromInventoryReportCases = []struct {
+	query    *storage.Query
+	expected []string
+}{
+	{query: nil, expected: []string{"readme.txt", "show-one/episode1.mkv", "show-one/episode2.mkv", "show-two/episode1.mkv"}},
+	{query: &storage.Query{Prefix: "show-one/"}, expected: []string{"show-one/episode1.mkv", "show-one/episode2.mkv"}},
+	{query: &storage.Query{Delimiter: "/"}, expected: []string{"readme.txt", "show-one/", "show-two/"}},
+	{query: &storage.Query{Prefix: "show-", Delimiter: "/"}, expected: []string{"show-one/", "show-two/"}},
+	{query: &storage.Query{Prefix: "nothing/"}, expected: nil},
+}
+
+func TestListObjectsFromInventoryReport(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testListObjectsFromInventoryReportCases {
+		listed, err := getTestInventoryReportListing(tc.query)
+		is.NoError(err)
+		is.Equal(tc.expected, listed, "%+v", tc.query)
+	}
+}
+
+func TestWithBucketInventoryReport(t *testing.T) {
+	is := assert.New(t)
+	ctx, err := withBucketInventoryReport(context.Background(), BucketToProcess{Name: "test-matt-media"})
+	is.NoError(err)
+	is.Nil(inventoryReportFromContext(ctx), "Should not attach a report when none is configured")
+
+	ctx, err = withBucketInventoryReport(context.Background(),
+		BucketToProcess{Name: "test-matt-media", InventoryFile: "testdata/inventory.csv"})
+	is.NoError(err)
+	is.NotNil(inventoryReportFromContext(ctx))
+
+	_, err = withBucketInventoryReport(context.Background(),
+		BucketToProcess{Name: "test-matt-media", InventoryFile: "testdata/missing.csv"})
+	is.Error(err)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testGetTopLevelPrefixCases = []struct {
+	name     string
+	expected string
+}{
+	{name: "2018/01/photo.jpg", expected: "2018/"},
+	{name: "show/episode.mkv", expected: "show/"},
+	{name: "backup.tar.gz", expected: ""},
+	{name: "empty-dir/", expected: "empty-dir/"},
+}
+
+func TestGetTopLevelPrefix(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetTopLevelPrefixCases {
+		is.Equal(tc.expected, getTopLevelPrefix(tc.name), tc.name)
+	}
+}
+
+func TestBucketInventoryAdd(t *testing.T) {
+	is := assert.New(t)
+	var inv BucketInventory
+	inv.add(&storage.ObjectAttrs{Name: "2018/a.jpg", Size: 100})
+	inv.add(&storage.ObjectAttrs{Name: "2018/a.jpg", Size: 100})
+	inv.add(&storage.ObjectAttrs{Name: "2018/b.jpg", Size: 50})
+	inv.add(&storage.ObjectAttrs{Name: "2018/old.jpg", Size: 70, Deleted: time.Now()})
+	inv.add(&storage.ObjectAttrs{Prefix: "2019/"})
+	inv.add(&storage.ObjectAttrs{Name: "top.txt", Size: 5})
+	inv.add(nil)
+
+	is.Equal(InventoryTotals{Objects: 3, Bytes: 155}, inv.InventoryTotals,
+		"Should count each live object once and skip noncurrent versions and prefixes")
+	is.Equal(map[string]InventoryTotals{
+		"2018/": {Objects: 2, Bytes: 150},
+		"":      {Objects: 1, Bytes: 5},
+	}, inv.Prefixes)
+	is.False(inv.Complete)
+	is.Equal(0, inv.ZeroByteObjects)
+
+	inv.add(&storage.ObjectAttrs{Name: "2018/empty.jpg"})
+	inv.add(&storage.ObjectAttrs{Name: "2018/empty.jpg"})
+	inv.add(&storage.ObjectAttrs{Name: "2020/"})
+	is.Equal(1, inv.ZeroByteObjects, "Should count empty objects once and skip directory placeholders")
+}
+
+func TestMarkInventoryComplete(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	bs := summary.bucket("test-bucket", "media")
+	markInventoryComplete(withBucketSummary(context.Background(), bs))
+	is.True(bs.Inventory.Complete)
+	markInventoryComplete(context.Background())
+}
+
+func getTestInventorySummary(objects int, bytes int64) *RunSummary {
+	summary := newRunSummary()
+	bs := summary.bucket("test-bucket", "media")
+	for i := 0; i < objects; i++ {
+		bs.Inventory.add(&storage.ObjectAttrs{Name: "show/" + string(rune('a'+i)), Size: bytes / int64(objects)})
+	}
+	summary.bucket("unlisted-bucket", "photo")
+	return summary
+}
+
+func TestRecordInventory(t *testing.T) {
+	is := assert.New(t)
+	history := &SamplingHistory{}
+	now := time.Date(2018, 1, 20, 0, 0, 0, 0, time.UTC)
+
+	history.recordInventory(getTestInventorySummary(2, 200), "run-1", "", now)
+	is.Len(history.Inventories["test-bucket"], 1)
+	is.NotContains(history.Inventories, "unlisted-bucket", "Should skip buckets that weren't listed")
+
+	history.recordInventory(getTestInventorySummary(3, 300), "run-1", "", now)
+	is.Len(history.Inventories["test-bucket"], 1, "Should replace the snapshot of a resumed run")
+	is.Equal(3, history.Inventories["test-bucket"][0].Objects)
+
+	history.recordInventory(getTestInventorySummary(4, 400), "run-2", "deep", now)
+	_, found := history.getPreviousInventory("test-bucket", "", "run-3")
+	is.True(found)
+	previous, found := history.getPreviousInventory("test-bucket", "deep", "run-3")
+	is.True(found)
+	is.Equal("run-2", previous.RunID)
+	_, found = history.getPreviousInventory("test-bucket", "deep", "run-2")
+	is.False(found, "Should not compare a run with itself")
+
+	history.recordInventory(nil, "run-4", "", now)
+	for i := 0; i < maxInventorySnapshots+5; i++ {
+		history.recordInventory(getTestInventorySummary(1, 1), string(rune(i)), "", now)
+	}
+	is.Len(history.Inventories["test-bucket"], maxInventorySnapshots, "Should drop the oldest snapshots")
+}
+
+var testDescribeInventoryChangeCases = []struct {
+	previous InventoryTotals
+	current  InventoryTotals
+	found    bool
+	expected string
+}{
+	{current: InventoryTotals{Objects: 2, Bytes: 10}, found: false, expected: "new"},
+	{previous: InventoryTotals{Objects: 2, Bytes: 10}, current: InventoryTotals{Objects: 3, Bytes: 2058}, found: true, expected: "+1 objects, +2.0 KiB"},
+	{previous: InventoryTotals{Objects: 3, Bytes: 2058}, current: InventoryTotals{Objects: 2, Bytes: 10}, found: true, expected: "-1 objects, -2.0 KiB"},
+	{previous: InventoryTotals{Objects: 2, Bytes: 10}, current: InventoryTotals{Objects: 2, Bytes: 10}, found: true, expected: "+0 objects, +0 B"},
+}
+
+func TestDescribeInventoryChange(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testDescribeInventoryChangeCases {
+		is.Equal(tc.expected, describeInventoryChange(tc.previous, tc.current, tc.found))
+	}
+}
+
+func TestWriteInventory(t *testing.T) {
+	is := assert.New(t)
+	history := &SamplingHistory{}
+	history.recordInventory(getTestInventorySummary(2, 200), "run-1", "", time.Now())
+
+	var output bytes.Buffer
+	summary := getTestInventorySummary(3, 300)
+	summary.Buckets[0].Inventory.Complete = true
+	is.NoError(writeInventory(&output, summary, history, "run-2", ""))
+	is.Contains(output.String(), "(all)")
+	is.Contains(output.String(), "show/")
+	is.Contains(output.String(), "+1 objects, +100 B")
+	is.NotContains(output.String(), "unlisted-bucket")
+
+	output.Reset()
+	is.NoError(writeInventory(&output, getTestInventorySummary(1, 100), &SamplingHistory{}, "run-2", ""))
+	is.Contains(output.String(), "(listed prefixes)")
+	is.Contains(output.String(), "new")
+	is.NoError(writeInventory(&output, nil, history, "run-2", ""))
+}
+
+func TestWarnBucketSizes(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	summary.bucket("test-matt-server-backups", "server-backup").Inventory.Bytes = 3 << 30
+	summary.bucket("test-matt-media", "media").Inventory.Bytes = 1 << 30
+	buckets := []BucketToProcess{
+		{Name: "test-matt-server-backups", MaxTotalBytesWarn: 2 << 30},
+		{Name: "test-matt-media", MaxTotalBytesWarn: 2 << 30},
+		{Name: "test-matt-photos"},
+	}
+	warnBucketSizes(summary, buckets)
+	is.Equal([]Warning{{Bucket: "test-matt-server-backups",
+		Message: "bucket test-matt-server-backups holds 3.0 GiB, over its max_total_bytes_warn of 2.0 GiB."}},
+		summary.getWarnings())
+	warnBucketSizes(nil, buckets)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const issueStateFileName = "issues.json"
+
+// envIssueToken holds the token the issue tracker is called with, so it stays out of the config file.
+const envIssueToken = "VALIDATEBACKUPS_ISSUE_TOKEN"
+
+// kinds of IssueTracker, gitea's api follows github's
+const (
+	issueTrackerGitHub = "github"
+	issueTrackerGitea  = "gitea"
+	issueTrackerGitLab = "gitlab"
+)
+
+// defaultIssueFailureThreshold is how many runs in a row a bucket fails validation before an issue is filed.
+const defaultIssueFailureThreshold = 3
+
+// issuesPerPage is how many open issues are looked through at a time for one to add to.
+const issuesPerPage = 100
+
+// IssueState is what the issue tracker remembers between runs, keyed by bucket name.
+type IssueState struct {
+	Buckets map[string]BucketIssueState `json:"buckets"`
+}
+
+// BucketIssueState counts the runs in a row a bucket has failed validation, and links the issue filed about it, if one has been.
+type BucketIssueState struct {
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	IssueURL            string `json:"issue_url"`
+}
+
+// trackedIssue is an issue on the tracker, Number is its iid on gitlab.
+type trackedIssue struct {
+	Number int
+	URL    string
+}
+
+// issueClient calls the api of the configured issue tracker.
+type issueClient struct {
+	tracker IssueTracker
+	token   string
+	client  *http.Client
+}
+
+func getIssueStateFilePath(config Config) string {
+	return filepath.Join(getStateDirectory(config), issueStateFileName)
+}
+
+func loadIssueState(filePath string) (state *IssueState, err error) {
+	state = &IssueState{}
+	contents, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Annotatef(err, "Unable to open issue state file at %s", filePath)
+	}
+	if err == nil {
+		err = json.Unmarshal(contents, state)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to parse issue state file at %s", filePath)
+		}
+	}
+	if state.Buckets == nil {
+		state.Buckets = make(map[string]BucketIssueState)
+	}
+	return state, nil
+}
+
+func saveIssueState(filePath string, state *IssueState) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open issue state file %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	return json.NewEncoder(jsonFile).Encode(state)
+}
+
+// validateIssueTracker checks the tracker can be called, the api url only has a default for github and gitlab.com.
+func validateIssueTracker(tracker IssueTracker, token string) error {
+	switch tracker.Kind {
+	case issueTrackerGitHub, issueTrackerGitLab:
+	case issueTrackerGitea:
+		if len(tracker.URL) == 0 {
+			return errors.NotValidf("Gitea issue tracker without a url")
+		}
+	default:
+		return errors.NotValidf("Issue tracker kind %q, expected %s, %s or %s", tracker.Kind, issueTrackerGitHub, issueTrackerGitea,
+			issueTrackerGitLab)
+	}
+	if len(tracker.Repository) == 0 {
+		return errors.NotValidf("Issue tracker without a repository")
+	}
+	if len(token) == 0 {
+		return errors.NotFoundf("Issue tracker token in %s", envIssueToken)
+	}
+	return nil
+}
+
+func newIssueClient(tracker IssueTracker, token string) *issueClient {
+	if len(tracker.URL) == 0 {
+		tracker.URL = map[string]string{issueTrackerGitHub: "https://api.github.com", issueTrackerGitLab: "https://gitlab.com/api/v4"}[tracker.Kind]
+	}
+	tracker.URL = strings.TrimSuffix(tracker.URL, "/")
+	return &issueClient{tracker: tracker, token: token, client: &http.Client{Timeout: time.Minute}}
+}
+
+// getIssueTitle is the same every time a bucket fails, so an open issue about it can be found again.
+func getIssueTitle(bucketName string) string {
+	return fmt.Sprintf("validatebackups: bucket %s is failing validation", bucketName)
+}
+
+// issuesURL is where the repository's issues are listed and filed.
+func (c *issueClient) issuesURL() string {
+	if c.tracker.Kind == issueTrackerGitLab {
+		return c.tracker.URL + "/projects/" + url.PathEscape(c.tracker.Repository) + "/issues"
+	}
+	return c.tracker.URL + "/repos/" + c.tracker.Repository + "/issues"
+}
+
+func (c *issueClient) call(ctx context.Context, method string, target string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to call issue tracker at %s", target)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("Issue tracker at %s returned %s: %s", target, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Annotatef(json.NewDecoder(resp.Body).Decode(result), "Unable to parse issue tracker response from %s", target)
+}
+
+// findOpenIssue looks through the open issues for one with the title, found is false if there isn't one.
+func (c *issueClient) findOpenIssue(ctx context.Context, title string) (issue trackedIssue, found bool, err error) {
+	for page := 1; ; page++ {
+		query := url.Values{"page": {fmt.Sprint(page)}, "per_page": {fmt.Sprint(issuesPerPage)}, "limit": {fmt.Sprint(issuesPerPage)}}
+		if c.tracker.Kind == issueTrackerGitLab {
+			query.Set("state", "opened")
+			query.Set("search", title)
+			query.Set("in", "title")
+		} else {
+			query.Set("state", "open")
+			query.Set("type", "issues")
+		}
+		var issues []struct {
+			Number      int             `json:"number"`
+			IID         int             `json:"iid"`
+			Title       string          `json:"title"`
+			HTMLURL     string          `json:"html_url"`
+			WebURL      string          `json:"web_url"`
+			PullRequest json.RawMessage `json:"pull_request"`
+		}
+		err = c.call(ctx, http.MethodGet, c.issuesURL()+"?"+query.Encode(), nil, &issues)
+		if err != nil {
+			return
+		}
+		for _, candidate := range issues {
+			//github lists pull requests as issues too
+			if candidate.Title != title || len(candidate.PullRequest) > 0 {
+				continue
+			}
+			if c.tracker.Kind == issueTrackerGitLab {
+				return trackedIssue{candidate.IID, candidate.WebURL}, true, nil
+			}
+			return trackedIssue{candidate.Number, candidate.HTMLURL}, true, nil
+		}
+		if len(issues) < issuesPerPage {
+			return
+		}
+	}
+}
+
+// createIssue files an issue. Labels are only set on github and gitlab, gitea wants label ids rather than names.
+func (c *issueClient) createIssue(ctx context.Context, title string, body string) (issue trackedIssue, err error) {
+	request := map[string]interface{}{"title": title}
+	var created struct {
+		Number  int    `json:"number"`
+		IID     int    `json:"iid"`
+		HTMLURL string `json:"html_url"`
+		WebURL  string `json:"web_url"`
+	}
+	switch c.tracker.Kind {
+	case issueTrackerGitLab:
+		request["description"] = body
+		if len(c.tracker.Labels) > 0 {
+			request["labels"] = strings.Join(c.tracker.Labels, ",")
+		}
+	case issueTrackerGitHub:
+		request["body"] = body
+		if len(c.tracker.Labels) > 0 {
+			request["labels"] = c.tracker.Labels
+		}
+	default:
+		request["body"] = body
+	}
+	err = c.call(ctx, http.MethodPost, c.issuesURL(), request, &created)
+	if c.tracker.Kind == issueTrackerGitLab {
+		return trackedIssue{created.IID, created.WebURL}, err
+	}
+	return trackedIssue{created.Number, created.HTMLURL}, err
+}
+
+func (c *issueClient) comment(ctx context.Context, issue trackedIssue, body string) error {
+	if c.tracker.Kind == issueTrackerGitLab {
+		return c.call(ctx, http.MethodPost, fmt.Sprintf("%s/%d/notes", c.issuesURL(), issue.Number), map[string]string{"body": body}, nil)
+	}
+	return c.call(ctx, http.MethodPost, fmt.Sprintf("%s/%d/comments", c.issuesURL(), issue.Number), map[string]string{"body": body}, nil)
+}
+
+// getIssueBody says how the bucket failed, followed by the whole run summary as markdown.
+func getIssueBody(rs *RunSummary, bs *BucketSummary, failures int) string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "Bucket **%s** has failed validation %d runs in a row.\n\n```\n%s\n```\n\n<details><summary>Run summary</summary>\n\n",
+		markdownEscaper.Replace(bs.BucketName), failures, strings.ReplaceAll(bs.ValidationError, "```", "'''"))
+	writeSummaryMarkdown(&report, rs)
+	report.WriteString("\n</details>\n")
+	return report.String()
+}
+
+// fileIssuesForFailures counts the runs in a row each validated bucket has failed, and once a bucket reaches the threshold
+// files an issue about it with the run summary. An open issue with the same title is commented on instead, so a bucket
+// that keeps failing gets one issue, and only once per run of failures. A bucket passing validation again starts over.
+func fileIssuesForFailures(ctx context.Context, client *issueClient, state *IssueState, rs *RunSummary) (err error) {
+	threshold := client.tracker.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultIssueFailureThreshold
+	}
+	var problems []string
+	for _, bs := range rs.Buckets {
+		bucketState := state.Buckets[bs.BucketName]
+		switch bs.ValidationResult {
+		case validationPassed:
+			delete(state.Buckets, bs.BucketName)
+			continue
+		case validationFailed:
+			bucketState.ConsecutiveFailures++
+		default:
+			continue
+		}
+		if bucketState.ConsecutiveFailures >= threshold && len(bucketState.IssueURL) == 0 {
+			issue, err2 := fileIssue(ctx, client, getIssueTitle(bs.BucketName), getIssueBody(rs, bs, bucketState.ConsecutiveFailures))
+			if err2 != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", bs.BucketName, err2))
+			} else {
+				bucketState.IssueURL = issue.URL
+				fmt.Fprintln(console.stdout, fmt.Sprintf("Reported bucket %s failing validation at %s", bs.BucketName, issue.URL))
+			}
+		}
+		state.Buckets[bs.BucketName] = bucketState
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("Unable to file issues (%s)", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func fileIssue(ctx context.Context, client *issueClient, title string, body string) (issue trackedIssue, err error) {
+	issue, found, err := client.findOpenIssue(ctx, title)
+	if err != nil {
+		return
+	}
+	if found {
+		return issue, client.comment(ctx, issue, body)
+	}
+	return client.createIssue(ctx, title, body)
+}
+
+// reportFailuresToIssueTracker files issues about the buckets that keep failing validation when an issue tracker is configured.
+// Problems with the tracker are warnings, they shouldn't hide the validation results.
+func reportFailuresToIssueTracker(ctx context.Context, config Config, rs *RunSummary, getenv func(string) string) {
+	if len(config.IssueTracker.Kind) == 0 {
+		return
+	}
+	token := getenv(envIssueToken)
+	err := validateIssueTracker(config.IssueTracker, token)
+	if err != nil {
+		rs.warn("unable to report failures to the issue tracker. %v", err)
+		return
+	}
+	filePath := getIssueStateFilePath(config)
+	state, err := loadIssueState(filePath)
+	if err != nil {
+		rs.warn("unable to report failures to the issue tracker. %v", err)
+		return
+	}
+	err = fileIssuesForFailures(ctx, newIssueClient(config.IssueTracker, token), state, rs)
+	if err != nil {
+		rs.warn("%v", err)
+	}
+	err = saveIssueState(filePath, state)
+	if err != nil {
+		rs.warn("unable to save issue tracker state. %v", err)
+	}
+}
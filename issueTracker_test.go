@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestIssueServer serves a github or gitlab like issues api, with the open issues given, recording each request made.
+func newTestIssueServer(t *testing.T, openIssues []map[string]interface{}) (server *httptest.Server, requests *[]string, bodies *[]map[string]interface{}) {
+	requests, bodies = &[]string{}, &[]map[string]interface{}{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.Method+" "+r.URL.EscapedPath())
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(openIssues)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		*bodies = append(*bodies, body)
+		json.NewEncoder(w).Encode(map[string]interface{}{"number": 7, "html_url": "https://github.com/matt/backups/issues/7",
+			"iid": 8, "web_url": "https://gitlab.com/matt/backups/-/issues/8"})
+	}))
+	t.Cleanup(server.Close)
+	return
+}
+
+func TestFileIssuesForFailures(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	server, requests, bodies := newTestIssueServer(t, []map[string]interface{}{
+		{"number": 3, "title": getIssueTitle("test-matt-media"), "pull_request": map[string]string{"url": "pr"}},
+	})
+	client := newIssueClient(IssueTracker{Kind: issueTrackerGitHub, URL: server.URL + "/", Repository: "matt/backups",
+		FailureThreshold: 2, Labels: []string{"backups"}}, "secret")
+	state := &IssueState{Buckets: make(map[string]BucketIssueState)}
+
+	summary := getTestRunSummary()
+	is.NoError(fileIssuesForFailures(ctx, client, state, summary))
+	is.Empty(*requests, "Should not file an issue before the threshold")
+	is.Equal(BucketIssueState{ConsecutiveFailures: 1}, state.Buckets["test-matt-server-backups"])
+
+	is.NoError(fileIssuesForFailures(ctx, client, state, summary))
+	is.Equal([]string{"GET /repos/matt/backups/issues", "POST /repos/matt/backups/issues"}, *requests,
+		"Should file a new issue when only a pull request has the title")
+	is.Equal(getIssueTitle("test-matt-server-backups"), (*bodies)[0]["title"])
+	is.Contains((*bodies)[0]["body"], "has failed validation 2 runs in a row.\n\n```\ntoo old\n```")
+	is.Contains((*bodies)[0]["body"], "| **test-matt-server-backups** |", "Should attach the run summary")
+	is.Equal([]interface{}{"backups"}, (*bodies)[0]["labels"])
+	is.Equal("https://github.com/matt/backups/issues/7", state.Buckets["test-matt-server-backups"].IssueURL)
+
+	is.NoError(fileIssuesForFailures(ctx, client, state, summary))
+	is.Len(*requests, 2, "Should only file one issue while the bucket keeps failing")
+
+	summary.Buckets[1].setValidationResult(nil)
+	is.NoError(fileIssuesForFailures(ctx, client, state, summary))
+	is.Empty(state.Buckets, "Should start over once the bucket passes")
+}
+
+func TestFileIssuesForFailuresGitLab(t *testing.T) {
+	is := assert.New(t)
+	server, requests, bodies := newTestIssueServer(t, []map[string]interface{}{
+		{"iid": 4, "title": getIssueTitle("test-matt-server-backups"), "web_url": "https://gitlab.com/matt/backups/-/issues/4"},
+	})
+	client := newIssueClient(IssueTracker{Kind: issueTrackerGitLab, URL: server.URL, Repository: "matt/backups", FailureThreshold: 1}, "secret")
+	state := &IssueState{Buckets: make(map[string]BucketIssueState)}
+
+	is.NoError(fileIssuesForFailures(context.Background(), client, state, getTestRunSummary()))
+	is.Equal([]string{"GET /projects/matt%2Fbackups/issues", "POST /projects/matt%2Fbackups/issues/4/notes"}, *requests,
+		"Should comment on the open issue rather than file another")
+	is.Contains((*bodies)[0]["body"], "failed validation 1 runs in a row")
+	is.Equal("https://gitlab.com/matt/backups/-/issues/4", state.Buckets["test-matt-server-backups"].IssueURL)
+
+	client.token = "wrong"
+	state.Buckets = make(map[string]BucketIssueState)
+	err := fileIssuesForFailures(context.Background(), client, state, getTestRunSummary())
+	is.ErrorContains(err, "401 Unauthorized")
+	is.Equal(BucketIssueState{ConsecutiveFailures: 1}, state.Buckets["test-matt-server-backups"], "Should try again next run")
+}
+
+var testValidateIssueTrackerCases = []struct {
+	tracker IssueTracker
+	token   string
+	valid   bool
+}{
+	{IssueTracker{Kind: issueTrackerGitHub, Repository: "matt/backups"}, "secret", true},
+	{IssueTracker{Kind: issueTrackerGitea, Repository: "matt/backups"}, "secret", false},
+	{IssueTracker{Kind: issueTrackerGitea, URL: "https://gitea.example.com/api/v1", Repository: "matt/backups"}, "secret", true},
+	{IssueTracker{Kind: "jira", Repository: "BACKUPS"}, "secret", false},
+	{IssueTracker{Kind: issueTrackerGitLab}, "secret", false},
+	{IssueTracker{Kind: issueTrackerGitLab, Repository: "matt/backups"}, "", false},
+}
+
+func TestValidateIssueTracker(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testValidateIssueTrackerCases {
+		err := validateIssueTracker(tc.tracker, tc.token)
+		is.Equal(tc.valid, err == nil, "%+v: %v", tc.tracker, err)
+	}
+}
+
+func TestLoadIssueState(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), issueStateFileName)
+	state, err := loadIssueState(filePath)
+	is.NoError(err)
+	state.Buckets["test-matt-photos"] = BucketIssueState{ConsecutiveFailures: 2}
+	is.NoError(saveIssueState(filePath, state))
+	loaded, err := loadIssueState(filePath)
+	is.NoError(err)
+	is.Equal(state, loaded)
+
+	summary := getTestRunSummary()
+	reportFailuresToIssueTracker(context.Background(), Config{IssueTracker: IssueTracker{Kind: issueTrackerGitHub, Repository: "matt/backups"}},
+		summary, func(string) string { return "" })
+	is.Len(summary.Warnings, 1)
+	is.Contains(summary.Warnings[0], envIssueToken, "Should warn rather than fail without a token")
+}
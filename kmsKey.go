@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// kmsProbeCandidates is how many objects encrypted with the key are looked at to find a small one to read back,
+// so a bucket of big backups isn't listed in full just to pick the smallest.
+const kmsProbeCandidates = 100
+
+// validateBucketKMSKey checks the bucket's default customer managed key is expectedKey, and that the credentials
+// can still decrypt with it, by reading the first byte of a small object encrypted with the key.
+// Key permissions can be revoked or the key disabled long before anyone tries a restore, this catches it first.
+func validateBucketKMSKey(ctx context.Context, bucket BackupStore, expectedKey string) (err error) {
+	if len(expectedKey) == 0 {
+		return nil
+	}
+	bucketAttrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return errors.Annotate(err, "Unable to get bucket attributes")
+	}
+	var defaultKey string
+	if bucketAttrs.Encryption != nil {
+		defaultKey = bucketAttrs.Encryption.DefaultKMSKeyName
+	}
+	if !isSameKMSKey(defaultKey, expectedKey) {
+		return errors.NotValidf("Default KMS key %q, expected %q", defaultKey, expectedKey)
+	}
+
+	probe, err := getKMSProbeObject(ctx, bucket, expectedKey)
+	if err != nil {
+		return
+	}
+	reader, err := bucket.Object(probe.Name).NewRangeReader(ctx, 0, 1)
+	if err == nil {
+		_, err = io.Copy(io.Discard, reader)
+		reader.Close()
+	}
+	if err != nil {
+		return errors.Annotatef(err, "Unable to decrypt %s with KMS key %s, check the key is enabled "+
+			"and the bucket's service agent has roles/cloudkms.cryptoKeyEncrypterDecrypter on it", probe.Name, expectedKey)
+	}
+	return nil
+}
+
+// getKMSProbeObject picks the smallest non empty object encrypted with key among the first kmsProbeCandidates found.
+func getKMSProbeObject(ctx context.Context, bucket BackupStore, key string) (probe *storage.ObjectAttrs, err error) {
+	it := listObjects(ctx, bucket, nil)
+	candidates := 0
+	for candidates < kmsProbeCandidates {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			break
+		}
+		if err2 != nil {
+			return nil, errors.Annotate(err2, "Unable to list objects to check the KMS key")
+		}
+		if objAttrs.Size == 0 || !isSameKMSKey(objAttrs.KMSKeyName, key) {
+			continue
+		}
+		candidates++
+		if probe == nil || objAttrs.Size < probe.Size {
+			probe = objAttrs
+		}
+	}
+	if probe == nil {
+		return nil, errors.NotFoundf("Objects encrypted with KMS key %s", key)
+	}
+	return
+}
+
+// isSameKMSKey compares key names ignoring the key version, objects record the version they were encrypted with
+// but buckets and the config name the key itself.
+func isSameKMSKey(a, b string) bool {
+	trimVersion := func(key string) string {
+		if i := strings.Index(key, "/cryptoKeyVersions/"); i >= 0 {
+			return key[:i]
+		}
+		return key
+	}
+	return len(a) > 0 && trimVersion(a) == trimVersion(b)
+}
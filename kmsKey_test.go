@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKMSKey = "projects/matt/locations/us/keyRings/backups/cryptoKeys/nightly"
+
+var testIsSameKMSKeyCases = []struct {
+	a, b     string
+	expected bool
+}{
+	{testKMSKey, testKMSKey, true},
+	{testKMSKey + "/cryptoKeyVersions/3", testKMSKey, true},
+	{testKMSKey + "/cryptoKeyVersions/3", testKMSKey + "/cryptoKeyVersions/4", true},
+	{"projects/matt/locations/us/keyRings/backups/cryptoKeys/weekly", testKMSKey, false},
+	//google managed encryption has no key name
+	{"", testKMSKey, false},
+	{"", "", false},
+}
+
+func TestIsSameKMSKey(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testIsSameKMSKeyCases {
+		is.Equal(tc.expected, isSameKMSKey(tc.a, tc.b), "Should compare %q with %q", tc.a, tc.b)
+	}
+}
+
+func TestValidateBucketKMSKeyNotConfigured(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(validateBucketKMSKey(context.Background(), nil, ""), "Should not check buckets without a kms_key")
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lock a single byte well past the end of the file, so the holder details written to the file can still be read.
+const lockFileOffset = 0x7fffffff
+
+// tryLockFile takes an exclusive lock on f without blocking, returning false if someone else holds it.
+func tryLockFile(f *os.File) (locked bool, err error) {
+	overlapped := windows.Overlapped{Offset: lockFileOffset}
+	err = windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	overlapped := windows.Overlapped{Offset: lockFileOffset}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &overlapped)
+}
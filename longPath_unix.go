@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+// maxLocalPathLength is PATH_MAX less the terminating null.
+const maxLocalPathLength = 4095
+
+// getLocalPathLength measures a path in bytes, as the kernel does.
+func getLocalPathLength(path string) int {
+	return len(path)
+}
+
+// getMaxLocalPathLength is the same whether or not long paths are allowed, there is no way around PATH_MAX.
+func getMaxLocalPathLength(_ bool) int {
+	return maxLocalPathLength
+}
+
+// toLongPath has nothing to do outside Windows.
+func toLongPath(path string) string {
+	return path
+}
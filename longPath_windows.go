@@ -0,0 +1,45 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxLocalPathLength is MAX_PATH less the terminating null, the longest path most Windows programs can open.
+// Paths with the \\?\ prefix can be up to maxExtendedPathLength long instead.
+const (
+	maxLocalPathLength    = 259
+	maxExtendedPathLength = 32767
+)
+
+// getLocalPathLength measures an absolute path the way Windows does, in UTF-16 code units.
+func getLocalPathLength(path string) int {
+	return len(utf16.Encode([]rune(path)))
+}
+
+func getMaxLocalPathLength(allowLongPaths bool) int {
+	if allowLongPaths {
+		return maxExtendedPathLength
+	}
+	return maxLocalPathLength
+}
+
+// toLongPath adds the \\?\ prefix to paths over MAX_PATH, so Windows APIs and most tools accept them.
+// Shorter paths are left as they are, they work everywhere without it.
+func toLongPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil || getLocalPathLength(abs) <= maxLocalPathLength {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		//network shares use a UNC flavour of the prefix
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToLongPath(t *testing.T) {
+	is := assert.New(t)
+	short := `C:\downloads\test-matt-media\episode.mkv`
+	is.Equal(short, toLongPath(short), "Should leave short paths alone")
+
+	long := `C:\downloads\` + strings.Repeat("d", 200) + `\` + strings.Repeat("e", 100)
+	is.Equal(`\\?\`+long, toLongPath(long))
+	is.Equal(`\\?\`+long, toLongPath(`\\?\`+long), "Should not prefix twice")
+
+	share := `\\nas\backups\` + strings.Repeat("d", 200) + `\` + strings.Repeat("e", 100)
+	is.Equal(`\\?\UNC\nas\backups\`+strings.Repeat("d", 200)+`\`+strings.Repeat("e", 100), toLongPath(share))
+
+	is.Equal(maxLocalPathLength, getMaxLocalPathLength(false))
+	is.Equal(maxExtendedPathLength, getMaxLocalPathLength(true))
+}
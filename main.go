@@ -4,75 +4,533 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net/smtp"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
 	"google.golang.org/api/option"
 )
 
+const defaultConfigPath = `D:\Matt\go\src\github.com\mattgiltaji\validatebackups\config.json`
+
+// exitCodeAlreadyRunning lets schedulers tell "previous run still going" apart from a failed run.
+const exitCodeAlreadyRunning = 3
+
 // separated out to exclude from coverage calculations as it's not testable
 func main() {
-	configPath := flag.String("config",
-		`D:\Matt\go\src\github.com\mattgiltaji\validatebackups\config.json`,
-		"path to config file")
-	flag.Parse()
+	log.SetOutput(console.stderr)
+	code := runCommand(os.Args[1:], os.Stdout, os.Stderr)
+	console.flush()
+	os.Exit(code)
+}
 
-	const inProgressFilePath = "./downloadsInProgress.json"
+// runAll validates the buckets then downloads a random sample, resuming a previous run if one was interrupted.
+func runAll(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	summaryFormat := flags.String("summary-format", "table",
+		"format of the summary printed at the end of the run, table, csv, json or markdown")
+	smoke := flags.Bool("smoke", false,
+		"quick end to end check, sample a single small file from each bucket")
+	showCoverage := flags.Bool("coverage", false,
+		"also report how much of each bucket has been spot checked over all runs, this lists every bucket in full")
+	discardProgress := flags.Bool("discard-progress", false,
+		"throw away the in progress file from an earlier run and pick a new sample")
+	waitForLock := flags.Duration("wait-for-lock", 0,
+		"how long to wait for a previous run to finish before giving up, e.g. 30m")
+	healthAddr := flags.String("health-addr", os.Getenv(envHealthAddr),
+		"address to serve /healthz and /readyz on while running, e.g. :8080")
+	noCache := flags.Bool("no-cache", false,
+		"hash every local file instead of trusting cached hashes of files whose size and modification time are unchanged")
+	oneshot := flags.Bool("oneshot", os.Getenv(envOneshot) == "true",
+		"take the whole config from VALIDATEBACKUPS_* environment variables instead of a config file")
+	profile := flags.String("profile", "",
+		"run profile from the config, or one of the defaults shallow, deep or audit")
+	var maxFileSize byteSizeFlag
+	flags.Var(&maxFileSize, "max-file-size",
+		"never sample files bigger than this `size`, e.g. 20GiB, overriding max_file_size_bytes from the config")
+	metadataSidecars := flags.Bool("metadata-sidecars", false,
+		"save each downloaded object's attributes, acl and metadata as json next to it, as write_metadata_sidecars does")
+	recordSnapshot := flags.String("record-snapshot", "",
+		"save every bucket's listing to this `file` before validating, for replaying the run offline with --from-snapshot")
+	fromSnapshot := flags.String("from-snapshot", "",
+		"validate and pick the sample from the listings recorded in this `file` with --record-snapshot, offline and without downloading")
+	return func(ctx context.Context) {
+		summary := newRunSummary()
+		defer reportRunResult(console.stdout, summary, time.Now())()
+		health, err := startHealthServer(*healthAddr)
+		logFatalIfErr(err, "Unable to start health checks.")
+		defer health.close()
 
-	//load config from file
-	config, err := loadConfigurationFromFile(*configPath)
-	logFatalIfErr(err, "Unable to load configuration from file.")
+		var config Config
+		var client *storage.Client
+		if *oneshot {
+			config, err = getConfigFromEnv(os.Getenv)
+			logFatalIfErr(err, "Unable to load configuration from the environment.")
+		} else {
+			config, err = loadConfiguration(*configPath, os.Stdin, os.Getenv)
+			logFatalIfErr(err, "Unable to load configuration from file.")
+		}
+		if len(*fromSnapshot) == 0 {
+			client = connectToStorage(ctx, config, config.Buckets)
+		}
+		recordClockSkew(summary)
+		config, err = applyRunProfile(config, *profile)
+		logFatalIfErr(err, "Unable to use run profile.")
+		config = applyMaxFileSize(config, int64(maxFileSize))
+		config.WriteMetadataSidecars = config.WriteMetadataSidecars || *metadataSidecars
+		if *smoke {
+			config = applySmokeOverrides(config)
+		}
+		_, err = registerValidatorPlugins(config.ValidatorPluginsDirectory)
+		logFatalIfErr(err, "Unable to load validator plugins.")
+		if len(*fromSnapshot) > 0 {
+			_, err = replayListingSnapshot(ctx, console.stdout, config, *fromSnapshot, summary, time.Now())
+			writeSummaryOutputs(summary, *summaryFormat)
+			logFatalIfErr(err, "Unable to replay listing snapshot.")
+			return
+		}
+		if !config.ActiveProfile.SkipDownloads && !config.ActiveProfile.ChecksumOnly {
+			_, err = validateDownloadLocation(config.FileDownloadLocation, config.AllowSyncedDownloadLocation)
+			logFatalIfErr(err, "Unable to save downloads to file_download_location.")
+		}
+		inProgressFilePath := getInProgressFilePath(config)
 
-	//connect to gcs
-	ctx := context.Background()
-	//try ADC first
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		client, err = storage.NewClient(ctx, option.WithCredentialsFile(config.GoogleAuthFileLocation))
-		logFatalIfErr(err, "Unable to connect to google cloud storage.")
-	}
+		rand.Seed(time.Now().UTC().UnixNano())
+		runID := newRunID(time.Now())
+		releaseLock, err := acquireStateLock(getStateDirectory(config), runID, *waitForLock)
+		if errors.IsAlreadyExists(err) {
+			fmt.Fprintln(console.stdout, "Another run is still in progress, exiting.", err)
+			writeRunResult(console.stdout, RunResult{Status: runAlreadyRunning})
+			flushTracing()
+			os.Exit(exitCodeAlreadyRunning)
+		}
+		logFatalIfErr(err, "Unable to lock the state directory.")
+		defer releaseLock()
+		audit := startAuditedRun(config, runID, summary, time.Now())
+		defer func() { audit.finish(nil, time.Now()) }()
+		health.setReady(true)
+
+		//print whatever we have so far before bailing out, so it's clear which bucket failed
+		summaryFatalIfErr := func(err error, msg string) {
+			if err != nil {
+				markIncompleteIfCredentialsExpired(summary, err)
+				exportAttestation(config, summary, runID, err)
+				writeSummaryOutputs(summary, *summaryFormat)
+				reportToGroups(config, summary, *summaryFormat, true, os.Getenv, smtp.SendMail)
+				audit.finish(err, time.Now())
+				releaseLock()
+				logFatalIfErr(err, msg)
+			}
+		}
+
+		if len(*recordSnapshot) > 0 {
+			_, err = recordListingSnapshot(ctx, client, config, *recordSnapshot, time.Now())
+			summaryFatalIfErr(err, "Unable to record listing snapshot.")
+		}
+
+		fmt.Fprintln(console.stdout, "Validating buckets.")
+		success, err := validateBucketsInConfig(ctx, client, config, summary)
+		//before anything reports the summary, so the warnings are in every copy of it
+		warnBucketSizes(summary, config.Buckets)
+		notifyGroupsOfFailures(ctx, config, summary, os.Getenv)
+		summaryFatalIfErr(err, "Unable to validate all buckets.")
+		if success {
+			fmt.Fprintln(console.stdout, "All buckets have passed validation.")
+		}
+		if config.ActiveProfile.SkipDownloads {
+			history, err := loadSamplingHistory(getSamplingHistoryFilePath(config))
+			summaryFatalIfErr(err, "Unable to load sampling history.")
+			history.recordInventory(summary, runID, config.ActiveProfile.Name, time.Now().UTC())
+			err = saveSamplingHistory(getSamplingHistoryFilePath(config), history)
+			summaryFatalIfErr(err, "Unable to save sampling history.")
+			exportAttestation(config, summary, runID, nil)
+			err = writeSummaryOutputs(summary, *summaryFormat)
+			logFatalIfErr(err, "Unable to print run summary.")
+			reportToGroups(config, summary, *summaryFormat, false, os.Getenv, smtp.SendMail)
+			err = writeInventory(console.stdout, summary, history, runID, config.ActiveProfile.Name)
+			logFatalIfErr(err, "Unable to print inventory.")
+			err = checkDeepValidationAge(history, config, time.Now())
+			logFatalIfErr(err, "Buckets are overdue a deep validation. Check the deep run schedule.")
+			return
+		}
+
+		//now see if we have files to download already, and whether they are still worth downloading
+		staleReasons, err := checkInProgressFile(inProgressFilePath, config, *discardProgress, time.Now())
+		for _, reason := range staleReasons {
+			summary.warn("in progress run looks stale, %s.", reason)
+		}
+		summaryFatalIfErr(err, "Not resuming the in progress run. Rerun with --discard-progress to pick a new sample.")
+		historyFilePath := getSamplingHistoryFilePath(config)
+		history, err := loadSamplingHistory(historyFilePath)
+		summaryFatalIfErr(err, "Unable to load sampling history.")
+
+		_, err = os.Stat(inProgressFilePath)
+		if os.IsNotExist(err) {
+			fmt.Fprintln(console.stdout, fmt.Sprintf("No in progress file found, determining random files to download for run %s.", runID))
+			//we don't have any in progress files, so make it
+			bucketToFilesMapping, err := getObjectsToDownloadFromBucketsInConfig(ctx, client, config, history, summary)
+			summaryFatalIfErr(err, "Unable to get objects to download from all buckets.")
+			//serialize bucketToFilesMapping to json file
+			err = saveInProgressFile(inProgressFilePath,
+				InProgressState{RunID: runID, Profile: config.ActiveProfile.Name, Started: time.Now().UTC(), Buckets: bucketToFilesMapping})
+			summaryFatalIfErr(err, "Unable to get save in progress file.")
+		}
+
+		state, err := loadInProgressFile(inProgressFilePath)
+		summaryFatalIfErr(err, "Unable to load data from progress file. Rerun with --discard-progress to start over.")
+		if state.RunID != runID {
+			fmt.Fprintln(console.stdout, fmt.Sprintf("In progress file found, resuming run %s.", state.RunID))
+		}
+		mapping := state.Buckets
+		audit.setManifest(mapping)
+		if state.Progress.TotalBytes == 0 {
+			state.Progress.TotalBytes, err = getTotalPlannedBytes(ctx, client, config, mapping)
+			if err != nil {
+				summary.warn("unable to work out the total download size, no ETA will be shown. %v", err)
+			}
+		}
+		if state.Progress.BytesDownloaded > 0 {
+			fmt.Fprintln(console.stdout, fmt.Sprintf("Previous sessions of this run: %s.", state.Progress.describe()))
+		}
+		tracker := newDownloadProgressTracker(state.Progress, time.Now(), func(progress DownloadProgress) error {
+			state.Progress = progress
+			return saveInProgressFile(inProgressFilePath, state)
+		})
+
+		hashes, err := openHashCache(config, *noCache)
+		summaryFatalIfErr(err, "Unable to load hash cache. Rerun with --no-cache to skip it.")
+
+		//now go over the file contents and download the objects locally
+		fmt.Fprintln(console.stdout, "Downloading files.")
+		err = downloadFilesFromBucketAndFiles(withHashCache(withDownloadProgress(ctx, tracker), hashes), client, config, mapping, summary)
+		saveHashCache(config, hashes)
+		exportDownloadMetrics(config, summary)
+		summaryFatalIfErr(err, "Error while downloading files. Please rerun to try again.")
+		history.recordVerified(mapping, time.Now().UTC())
+		history.recordDeepValidation(config.Buckets, time.Now().UTC())
+		history.recordInventory(summary, state.RunID, config.ActiveProfile.Name, time.Now().UTC())
+		err = saveSamplingHistory(historyFilePath, history)
+		summaryFatalIfErr(err, "Unable to save sampling history.")
+
+		if config.CatalogObjectsPerRun > 0 {
+			changes, err := updateCatalogsInConfig(ctx, client, config, config.CatalogObjectsPerRun, time.Now().UTC())
+			warnCatalogChanges(summary, config, changes)
+			if err != nil {
+				summary.warn("unable to update catalogs. %v", err)
+			}
+		}
 
-	fmt.Println("Validating buckets.")
-	success, err := validateBucketsInConfig(ctx, client, config)
-	logFatalIfErr(err, "Unable to validate all buckets.")
-	if success {
-		fmt.Println("All buckets have passed validation.")
+		//everything successful, delete the in progress file.
+		err = removeRunArtifact(config, inProgressFilePath, time.Now())
+		summaryFatalIfErr(err, fmt.Sprintf("Unable to delete progress file. Delete %s manually.", inProgressFilePath))
+
+		exportAttestation(config, summary, runID, nil)
+		err = writeSummaryOutputs(summary, *summaryFormat)
+		logFatalIfErr(err, "Unable to print run summary.")
+		reportToGroups(config, summary, *summaryFormat, false, os.Getenv, smtp.SendMail)
+		err = writeInventory(console.stdout, summary, history, state.RunID, config.ActiveProfile.Name)
+		logFatalIfErr(err, "Unable to print inventory.")
+
+		if *showCoverage {
+			coverage, err := getCoverageOfBucketsInConfig(ctx, client, config, history)
+			logFatalIfErr(err, "Unable to calculate coverage.")
+			fmt.Fprintln(console.stdout)
+			err = writeCoverage(console.stdout, coverage, *summaryFormat)
+			logFatalIfErr(err, "Unable to print coverage.")
+		}
+
+		//a resumed run skips whatever earlier sessions downloaded, that's expected
+		if state.RunID == runID {
+			err = checkSkippedDownloads(summary, config.MaxSkippedPercent)
+			logFatalIfErr(err, "The sample was mostly files already downloaded. Check sampling_memory_days or clear the download location.")
+		}
 	}
+}
+
+// runPlan selects the random sample and writes it out for review without downloading anything.
+func runPlan(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	format := flags.String("format", "json", "format of the plan, json or csv")
+	outputPath := flags.String("output", "", "file to write the plan to, defaults to stdout")
+	smoke := flags.Bool("smoke", false, "plan a single small file from each bucket")
+	profile := flags.String("profile", "", "run profile whose sample size to plan for")
+	var maxFileSize byteSizeFlag
+	flags.Var(&maxFileSize, "max-file-size", "never plan files bigger than this `size`, e.g. 20GiB")
+	fromSnapshot := flags.String("from-snapshot", "", "plan from the listings recorded in this `file` with --record-snapshot, offline")
+	return func(ctx context.Context) {
+		var config Config
+		var client *storage.Client
+		if len(*fromSnapshot) > 0 {
+			var err error
+			config, err = loadConfiguration(*configPath, os.Stdin, os.Getenv)
+			logFatalIfErr(err, "Unable to load configuration from file.")
+			snapshot, err := loadListingSnapshot(*fromSnapshot)
+			logFatalIfErr(err, "Unable to load listing snapshot.")
+			ctx = withListingSnapshot(ctx, snapshot, time.Now())
+		} else {
+			config, client = loadConfigAndConnect(ctx, *configPath)
+		}
+		config, err := applyRunProfile(config, *profile)
+		logFatalIfErr(err, "Unable to use run profile.")
+		config = applyMaxFileSize(config, int64(maxFileSize))
+		if *smoke {
+			config = applySmokeOverrides(config)
+		}
 
-	//now see if we have files to download already
-	_, err = os.Stat(inProgressFilePath)
-	if os.IsNotExist(err) {
-		fmt.Println("No in progress file found, determining random files to download.")
 		rand.Seed(time.Now().UTC().UnixNano())
-		//we don't have any in progress files, so make it
-		bucketToFilesMapping, err := getObjectsToDownloadFromBucketsInConfig(ctx, client, config)
+		history, err := loadSamplingHistory(getSamplingHistoryFilePath(config))
+		logFatalIfErr(err, "Unable to load sampling history.")
+		mapping, err := getObjectsToDownloadFromBucketsInConfig(ctx, client, config, history, nil)
 		logFatalIfErr(err, "Unable to get objects to download from all buckets.")
-		//serialize bucketToFilesMapping to json file
-		err = saveInProgressFile(inProgressFilePath, bucketToFilesMapping)
-		logFatalIfErr(err, "Unable to get save in progress file.")
-	} else {
-		fmt.Println("In progress file found, resuming from last run.")
+
+		var out io.Writer = console.stdout
+		if len(*outputPath) > 0 {
+			outFile, err := os.Create(*outputPath)
+			logFatalIfErr(err, fmt.Sprintf("Unable to create plan file %s.", *outputPath))
+			defer outFile.Close()
+			out = outFile
+		}
+
+		files, err := getPlannedFileDetails(ctx, client, config, mapping)
+		logFatalIfErr(err, "Unable to get details of planned files.")
+		switch *format {
+		case "json":
+			err = writePlanJson(out, Plan{Buckets: mapping, Files: files})
+		case "csv":
+			err = writePlanCsv(out, files)
+		default:
+			err = fmt.Errorf("unknown plan format %s", *format)
+		}
+		logFatalIfErr(err, "Unable to write plan.")
+	}
+}
+
+// runDownload downloads exactly the files listed in a plan file.
+func runDownload(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	planPath := flags.String("plan", "", "plan file listing the objects to download, csv or json as written by the plan command")
+	summaryFormat := flags.String("summary-format", "table",
+		"format of the summary printed at the end of the run, table, csv, json or markdown")
+	force := flags.Bool("force", false,
+		"download even if objects changed or were deleted since the plan was made, skipping deleted ones")
+	noCache := flags.Bool("no-cache", false,
+		"hash every local file instead of trusting cached hashes of files whose size and modification time are unchanged")
+	metadataSidecars := flags.Bool("metadata-sidecars", false,
+		"save each downloaded object's attributes, acl and metadata as json next to it, as write_metadata_sidecars does")
+	return func(ctx context.Context) {
+		if len(*planPath) == 0 {
+			log.Fatal("The download command requires --plan.")
+		}
+		summary := newRunSummary()
+		defer reportRunResult(console.stdout, summary, time.Now())()
+		plan, err := loadPlanFile(*planPath)
+		logFatalIfErr(err, fmt.Sprintf("Unable to load plan file %s.", *planPath))
+		err = validatePlan(plan.Buckets)
+		logFatalIfErr(err, fmt.Sprintf("Plan file %s is not valid.", *planPath))
+
+		config, client := loadConfigAndConnect(ctx, *configPath)
+		config.WriteMetadataSidecars = config.WriteMetadataSidecars || *metadataSidecars
+		_, err = validateDownloadLocation(config.FileDownloadLocation, config.AllowSyncedDownloadLocation)
+		logFatalIfErr(err, "Unable to save downloads to file_download_location.")
+		mapping, err := validatePlanAgainstBuckets(ctx, client, config, plan, *force)
+		logFatalIfErr(err, fmt.Sprintf("Plan file %s does not match the buckets. Make a new plan, or rerun with --force.", *planPath))
+		runID := newRunID(time.Now())
+		audit := startAuditedRun(config, runID, summary, time.Now())
+		defer func() { audit.finish(nil, time.Now()) }()
+		audit.setManifest(mapping)
+
+		hashes, err := openHashCache(config, *noCache)
+		logFatalIfErr(err, "Unable to load hash cache. Rerun with --no-cache to skip it.")
+
+		fmt.Fprintln(console.stdout, "Downloading files.")
+		err = downloadFilesFromBucketAndFiles(withHashCache(ctx, hashes), client, config, mapping, summary)
+		saveHashCache(config, hashes)
+		exportDownloadMetrics(config, summary)
+		markIncompleteIfCredentialsExpired(summary, err)
+		exportAttestation(config, summary, runID, err)
+		summary.recordAPICalls()
+		writeSummary(console.stdout, summary, *summaryFormat)
+		logFatalIfErr(err, "Error while downloading files. Please rerun to try again.")
+
+		historyFilePath := getSamplingHistoryFilePath(config)
+		history, err := loadSamplingHistory(historyFilePath)
+		logFatalIfErr(err, "Unable to load sampling history.")
+		history.recordVerified(mapping, time.Now().UTC())
+		err = saveSamplingHistory(historyFilePath, history)
+		logFatalIfErr(err, "Unable to save sampling history.")
+	}
+}
+
+// runCoverage reports how much of each bucket has been spot checked over all previous runs.
+func runCoverage(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	format := flags.String("format", "table", "format of the report, table or csv")
+	return func(ctx context.Context) {
+		config, client := loadConfigAndConnect(ctx, *configPath)
+		history, err := loadSamplingHistory(getSamplingHistoryFilePath(config))
+		logFatalIfErr(err, "Unable to load sampling history.")
+
+		coverage, err := getCoverageOfBucketsInConfig(ctx, client, config, history)
+		logFatalIfErr(err, "Unable to calculate coverage.")
+		err = writeCoverage(console.stdout, coverage, *format)
+		logFatalIfErr(err, "Unable to print coverage.")
 	}
+}
+
+// runDrill restores the newest backup of each server-backup bucket with a restore drill, exiting non zero if any fail.
+func runDrill(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	bucketName := flags.String("bucket", "", "only drill this bucket, or gs://bucket/prefix, defaults to every bucket with a restore_drill")
+	return func(ctx context.Context) {
+		config, client := loadConfigAndConnect(ctx, *configPath)
+		buckets, err := getDrillBuckets(config, *bucketName)
+		logFatalIfErr(err, "Nothing to drill.")
+
+		var results []DrillResult
+		failed := false
+		for _, bucketConfig := range buckets {
+			result, err := drillBucket(ctx, client, config, bucketConfig)
+			if err != nil {
+				fmt.Fprintln(console.stdout, fmt.Sprintf("Unable to drill bucket %s.", bucketConfig.Name), err)
+				result.Hook.Error = err.Error()
+			}
+			failed = failed || !result.Hook.passed()
+			results = append(results, result)
+		}
+		err = writeDrillResults(console.stdout, results)
+		logFatalIfErr(err, "Unable to print drill results.")
+		if failed {
+			flushTracing()
+			os.Exit(1)
+		}
+	}
+}
+
+// runNewest downloads and verifies only the newest backup of each server-backup bucket, a quick daily check
+// between full runs. It exits non zero if any newest backup is missing, stale, corrupt or, with --readable, unreadable.
+func runNewest(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	bucketName := flags.String("bucket", "", "only check this bucket, or gs://bucket/prefix, defaults to every server-backup bucket")
+	readable := flags.Bool("readable", false, "also read tar, tar.gz, zip and gzip backups through to the end")
+	keep := flags.Bool("keep", false, "keep the downloaded backups instead of removing them once checked")
+	return func(ctx context.Context) {
+		config, client := loadConfigAndConnect(ctx, *configPath)
+		buckets, err := getNewestBuckets(config, *bucketName)
+		logFatalIfErr(err, "Nothing to check.")
+
+		var results []NewestResult
+		failed := false
+		for _, bucketConfig := range buckets {
+			bucket, err := openBackupStore(ctx, client, config, bucketConfig)
+			logFatalIfErr(err, "Unable to open bucket.")
+			result := verifyNewestBackup(ctx, bucket, config, bucketConfig, *readable, *keep, time.Now())
+			failed = failed || !result.passed()
+			results = append(results, result)
+		}
+		err = writeNewestResults(console.stdout, results, time.Now())
+		logFatalIfErr(err, "Unable to print results.")
+		if failed {
+			flushTracing()
+			os.Exit(1)
+		}
+	}
+}
+
+// writeSummaryOutputs prints the summary, and also saves it to the VALIDATEBACKUPS_SUMMARY_FILE file when that is set.
+func writeSummaryOutputs(summary *RunSummary, format string) error {
+	summary.recordAPICalls()
+	err := writeSummary(console.stdout, summary, format)
+	if err != nil {
+		return err
+	}
+	summaryPath := os.Getenv(envSummaryFile)
+	if len(summaryPath) == 0 {
+		return nil
+	}
+	return saveSummaryFile(summaryPath, summary, format)
+}
+
+// saveSummaryFile writes the summary to a file, in the format its extension calls for or else format.
+func saveSummaryFile(summaryPath string, summary *RunSummary, format string) error {
+	summaryFile, err := os.Create(summaryPath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to create summary file %s", summaryPath)
+	}
+	defer summaryFile.Close()
+	//a json summary file is the full report, for diffing runs, and a markdown one is ready for notification hooks to post
+	switch strings.ToLower(filepath.Ext(summaryPath)) {
+	case ".json":
+		return writeSummaryJson(summaryFile, summary, time.Now().UTC())
+	case ".md":
+		format = "markdown"
+	}
+	return writeSummary(summaryFile, summary, format)
+}
 
-	mapping, err := loadInProgressFile(inProgressFilePath)
-	logFatalIfErr(err, fmt.Sprintf("Unable to load data from progress file. Delete %s manually and rerun.", inProgressFilePath))
+// configFlag registers --config, which defaults to the VALIDATEBACKUPS_CONFIG environment variable when that is set.
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", getEnvOrDefault(os.Getenv, envConfigPath, defaultConfigPath),
+		"path to config file, - to read it from stdin")
+}
 
-	//now go over the file contents and download the objects locally
-	fmt.Println("Downloading files.")
-	err = downloadFilesFromBucketAndFiles(ctx, client, config, mapping)
-	logFatalIfErr(err, "Error while downloading files. Please rerun to try again.")
+func loadConfigAndConnect(ctx context.Context, configPath string) (config Config, client *storage.Client) {
+	//load config from file, stdin or the environment
+	config, err := loadConfiguration(configPath, os.Stdin, os.Getenv)
+	logFatalIfErr(err, "Unable to load configuration from file.")
+	client = connectToStorage(ctx, config, config.Buckets)
+	return
+}
+
+// connectToStorage connects to google cloud storage, after checking it can be reached and the clock agrees with it,
+// if any of buckets is there. The client is nil when they are all with other providers.
+func connectToStorage(ctx context.Context, config Config, buckets []BucketToProcess) (client *storage.Client) {
+	if !usesGCS(buckets) {
+		return nil
+	}
+	skew, err := checkConnectivity(ctx, config.Transport, os.Getenv)
+	logFatalIfErr(err, "Unable to reach google cloud storage.")
+	err = checkClockSkew(skew, config)
+	logFatalIfErr(err, "The local clock is too far off to judge freshness. Check the time is synced.")
+	client, err = newStorageClient(ctx, config)
+	logFatalIfErr(err, "Unable to connect to google cloud storage.")
+	warnings := checkLeastPrivilege(config.Buckets, newIamPermissionTester(ctx, client))
+	writePermissionWarnings(console.stdout, warnings, config.ReadOnly || globalFlags.readOnly)
+	return
+}
 
-	//everything successful, delete the in progress file.
-	err = os.Remove(inProgressFilePath)
-	logFatalIfErr(err, fmt.Sprintf("Unable to delete progress file. Delete %s manually.", inProgressFilePath))
+func newStorageClient(ctx context.Context, config Config) (client *storage.Client, err error) {
+	//connect to gcs
+	//credentials in the environment win, for keyless auth from CI
+	options, err := getCredentialOptions(os.Getenv)
+	if err != nil {
+		return nil, errors.Annotate(err, "Unable to load credentials from the environment")
+	}
+	newClient, err := getClientConstructor(config.Transport, config.ReadOnly || globalFlags.readOnly)
+	if err != nil {
+		return nil, err
+	}
+	if len(options) > 0 {
+		return newClient(ctx, options...)
+	}
+	//try ADC first
+	client, err = newClient(ctx)
+	if err != nil {
+		client, err = newClient(ctx, option.WithCredentialsFile(config.GoogleAuthFileLocation))
+	}
 	return
 }
 
 func logFatalIfErr(err error, msg string) {
 	if err != nil {
+		fatalHook(err)
+		flushTracing()
 		log.Fatal(msg, " Error: ", err.Error())
 	}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// maxReportedOffenders keeps the error readable when a whole bucket was uploaded wrong.
+const maxReportedOffenders = 10
+
+// validateObjectMetadata checks every object covered by a rule has the expected content type and custom metadata.
+// All offenders are collected before failing so a single run shows the whole extent of an uploader bug.
+func validateObjectMetadata(ctx context.Context, bucket BackupStore, rules []MetadataRule) error {
+	var offenders []string
+	for _, rule := range rules {
+		it := listObjects(ctx, bucket, &storage.Query{Prefix: rule.Prefix, Versions: false})
+		for {
+			objAttrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return errors.Annotatef(err, "Unable to list objects under %s to check metadata", rule.Prefix)
+			}
+			countObjectExamined(ctx, objAttrs)
+			problems, err := getObjectMetadataProblems(objAttrs, rule)
+			if err != nil {
+				return errors.Annotatef(err, "Invalid metadata rule for prefix %s", rule.Prefix)
+			}
+			for _, problem := range problems {
+				offenders = append(offenders, fmt.Sprintf("%s %s", objAttrs.Name, problem))
+			}
+		}
+	}
+	if len(offenders) == 0 {
+		return nil
+	}
+	reported := offenders
+	if len(reported) > maxReportedOffenders {
+		reported = append(reported[:maxReportedOffenders:maxReportedOffenders],
+			fmt.Sprintf("and %d more", len(offenders)-maxReportedOffenders))
+	}
+	return errors.NotValidf("Object metadata, %d problems: %s", len(offenders), strings.Join(reported, "; "))
+}
+
+// getObjectMetadataProblems lists the ways a single object breaks the rule, if any.
+func getObjectMetadataProblems(objAttrs *storage.ObjectAttrs, rule MetadataRule) (problems []string, err error) {
+	if len(rule.ContentType) > 0 {
+		matched, err2 := path.Match(rule.ContentType, objAttrs.ContentType)
+		if err2 != nil {
+			err = errors.Annotatef(err2, "Unable to parse content type pattern %s", rule.ContentType)
+			return
+		}
+		if !matched {
+			problems = append(problems, fmt.Sprintf("has content type %q, expected %s", objAttrs.ContentType, rule.ContentType))
+		}
+	}
+	for _, key := range rule.RequiredMetadataKeys {
+		key = strings.TrimPrefix(strings.ToLower(key), "x-goog-meta-")
+		if !hasMetadataKey(objAttrs.Metadata, key) {
+			problems = append(problems, fmt.Sprintf("is missing metadata %s", key))
+		}
+	}
+	return
+}
+
+func hasMetadataKey(metadata map[string]string, key string) bool {
+	for k := range metadata {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
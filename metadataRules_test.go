@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testGetObjectMetadataProblemsCases = []struct {
+	attrs    storage.ObjectAttrs
+	rule     MetadataRule
+	expected int
+}{
+	{storage.ObjectAttrs{ContentType: "video/ogg"}, MetadataRule{ContentType: "video/*"}, 0},
+	{storage.ObjectAttrs{ContentType: "application/octet-stream"}, MetadataRule{ContentType: "video/*"}, 1},
+	{storage.ObjectAttrs{ContentType: ""}, MetadataRule{ContentType: "video/*"}, 1},
+	//no content type in the rule means anything goes
+	{storage.ObjectAttrs{ContentType: "text/plain"}, MetadataRule{}, 0},
+	{storage.ObjectAttrs{Metadata: map[string]string{"source-host": "alpha"}},
+		MetadataRule{RequiredMetadataKeys: []string{"x-goog-meta-source-host"}}, 0},
+	{storage.ObjectAttrs{Metadata: map[string]string{"Source-Host": "alpha"}},
+		MetadataRule{RequiredMetadataKeys: []string{"source-host"}}, 0},
+	{storage.ObjectAttrs{},
+		MetadataRule{RequiredMetadataKeys: []string{"source-host", "backup-tool"}}, 2},
+	{storage.ObjectAttrs{ContentType: "text/plain"},
+		MetadataRule{ContentType: "application/gzip", RequiredMetadataKeys: []string{"source-host"}}, 2},
+}
+
+func TestGetObjectMetadataProblems(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetObjectMetadataProblemsCases {
+		actual, err := getObjectMetadataProblems(&tc.attrs, tc.rule)
+		is.NoError(err)
+		is.Equal(tc.expected, len(actual), "Unexpected problems %v for rule %v", actual, tc.rule)
+	}
+
+	_, err := getObjectMetadataProblems(&storage.ObjectAttrs{ContentType: "video/ogg"}, MetadataRule{ContentType: "video/["})
+	is.Error(err, "Should error when the content type pattern cannot be parsed")
+}
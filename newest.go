@@ -0,0 +1,211 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// newestDirectory keeps newest backup downloads apart from the regular spot check downloads.
+const newestDirectory = "newest"
+
+// NewestResult records whether the newest backup in a bucket is fresh, downloads intact and, if checked, reads as an archive.
+// Entries counts the files in the archive, and Error is why the check failed, blank when it passed.
+type NewestResult struct {
+	BucketName string
+	ObjectName string
+	Size       int64
+	Created    time.Time
+	Entries    int
+	Error      string
+}
+
+func (r NewestResult) passed() bool {
+	return len(r.Error) == 0
+}
+
+// getNewestBuckets picks every server-backup bucket, or just the named bucket if bucketName is set.
+// bucketName can be a URI like gs://bucket/prefix to check the newest backup under that prefix.
+func getNewestBuckets(config Config, bucketName string) (buckets []BucketToProcess, err error) {
+	if len(bucketName) > 0 {
+		bucketConfig, err2 := getBucketConfigFromURI(bucketName, config.Buckets)
+		if err2 != nil {
+			return nil, err2
+		}
+		if bucketConfig.Type != "server-backup" {
+			return nil, errors.NotValidf("Bucket %s is a %s bucket, only server-backup buckets have a newest backup", bucketConfig.Name, bucketConfig.Type)
+		}
+		return []BucketToProcess{bucketConfig}, nil
+	}
+	for _, bucketConfig := range config.Buckets {
+		if bucketConfig.Type == "server-backup" {
+			buckets = append(buckets, bucketConfig)
+		}
+	}
+	if len(buckets) == 0 {
+		err = errors.NotFoundf("Server-backup buckets")
+	}
+	return
+}
+
+// verifyNewestBackup downloads the newest backup in the bucket, which verifies its size and checksum,
+// and fails it when it is older than newest_file_max_age_in_days. With readable set the whole archive is read too, see checkArchiveReadable.
+// The download is removed afterwards unless keep is set, a daily check shouldn't fill the disk with copies of the same backups.
+func verifyNewestBackup(ctx context.Context, bucket BackupStore, config Config, bucketConfig BucketToProcess,
+	readable, keep bool, now time.Time) (result NewestResult) {
+	result.BucketName = bucketConfig.Name
+	err := func() error {
+		ctx = withBucketPrefix(ctx, bucketConfig.Prefix)
+		matcher, err := newObjectNameMatcher(bucketConfig.FreshnessFilter)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid freshness filter for bucket %s", bucketConfig.Name)
+		}
+		ctx, err = withFreshnessWindow(ctx, bucketConfig.FreshnessWindow, config.ServerBackupRules)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid freshness window for bucket %s", bucketConfig.Name)
+		}
+		newest, err := getNewestObjectFromBucket(ctx, bucket, matcher)
+		if err != nil {
+			return errors.Annotatef(err, "Unable to find the newest backup in bucket %s", bucketConfig.Name)
+		}
+		if newest == nil {
+			return errors.NotFoundf("Backups in bucket %s", bucketConfig.Name)
+		}
+		result.ObjectName, result.Size, result.Created = newest.Name, newest.Size, newest.Created
+
+		localName := newest.Name
+		if config.SanitizeFileNames {
+			localName = sanitizeObjectPath(localName)
+		}
+		localFile := filepath.Join(config.FileDownloadLocation, newestDirectory, bucketConfig.Name, localName)
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Downloading %s from %s.", newest.Name, bucketConfig.Name))
+		err = downloadFile(ctx, bucket, newest.Name, localFile)
+		if errors.IsAlreadyExists(err) {
+			err = nil
+		}
+		if err != nil {
+			return errors.Annotatef(err, "Unable to download %s", newest.Name)
+		}
+		if !keep {
+			defer os.Remove(localFile)
+		}
+		if readable {
+			result.Entries, err = checkArchiveReadable(localFile)
+			if err != nil {
+				return errors.Annotatef(err, "Unable to read %s as an archive", newest.Name)
+			}
+		}
+		maxAgeDays := config.ServerBackupRules.NewestFileMaxAgeInDays
+		if maxAgeDays > 0 && int(now.Sub(newest.Created)/(time.Hour*24)) >= maxAgeDays {
+			return errors.Errorf("Newest backup %s was created on %s, more than %d days ago", newest.Name, formatTime(newest.Created), maxAgeDays)
+		}
+		return nil
+	}()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return
+}
+
+// checkArchiveReadable reads every entry of a tar, gzipped tar, zip or gzip file through to the end,
+// which catches truncation and corruption inside the archive that the object's checksum can't, as it was computed from the corrupt file.
+// Other files aren't archives and pass with no entries.
+func checkArchiveReadable(filePath string) (entries int, err error) {
+	name := strings.ToLower(filePath)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		archive, err := zip.OpenReader(filePath)
+		if err != nil {
+			return 0, err
+		}
+		defer archive.Close()
+		for _, file := range archive.File {
+			rc, err := file.Open()
+			if err != nil {
+				return entries, errors.Annotatef(err, "Unable to open %s", file.Name)
+			}
+			//reading to the end checks the entry's crc
+			_, err = io.Copy(io.Discard, rc)
+			rc.Close()
+			if err != nil {
+				return entries, errors.Annotatef(err, "Unable to read %s", file.Name)
+			}
+			entries++
+		}
+		return entries, nil
+	case !strings.HasSuffix(name, ".tar") && !strings.HasSuffix(name, ".tgz") && !strings.HasSuffix(name, ".gz"):
+		return 0, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if !strings.HasSuffix(name, ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		if !strings.HasSuffix(name, ".tgz") && !strings.HasSuffix(name, ".tar.gz") {
+			//a plain gzip file is one entry, read to the end for its checksum
+			_, err = io.Copy(io.Discard, gz)
+			return 1, err
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		_, err = io.Copy(io.Discard, tr)
+		if err != nil {
+			return entries, errors.Annotatef(err, "Unable to read %s", header.Name)
+		}
+		entries++
+	}
+}
+
+// writeNewestResults lists each bucket's newest backup and whether it checked out, followed by why any failed.
+func writeNewestResults(w io.Writer, results []NewestResult, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Bucket\tBackup\tSize\tAge\tEntries\tResult\t")
+	for _, result := range results {
+		outcome := validationPassed
+		if !result.passed() {
+			outcome = validationFailed
+		}
+		age := ""
+		if !result.Created.IsZero() {
+			age = now.Sub(result.Created).Round(time.Minute).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t\n", result.BucketName, result.ObjectName, formatBytes(result.Size), age,
+			result.Entries, outcome)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if !result.passed() {
+			fmt.Fprintf(w, "\n%s: %s\n", result.BucketName, result.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, contents := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})
+		if err == nil {
+			_, err = tw.Write([]byte(contents))
+		}
+		if err != nil {
+			t.Fatal("Could not write test archive", err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestGetNewestBuckets(t *testing.T) {
+	is := assert.New(t)
+	actual, err := getNewestBuckets(testDrillConfig, "")
+	is.NoError(err)
+	is.Equal(testDrillConfig.Buckets[1:], actual, "Should check every server backup bucket")
+
+	actual, err = getNewestBuckets(testDrillConfig, "gs://test-matt-server-backups-undrilled/nightly/")
+	is.NoError(err)
+	is.Equal("test-matt-server-backups-undrilled", actual[0].Name)
+	is.Equal("nightly/", actual[0].Prefix, "Should check under the prefix in the URI")
+
+	_, err = getNewestBuckets(testDrillConfig, "test-matt-media")
+	is.True(errors.IsNotValid(err), "Should error when the named bucket is not a server backup")
+	_, err = getNewestBuckets(testDrillConfig, "not-a-bucket")
+	is.True(errors.IsNotFound(err), "Should error when the named bucket is not in the config")
+	_, err = getNewestBuckets(Config{}, "")
+	is.True(errors.IsNotFound(err), "Should error when there are no server backup buckets")
+}
+
+func TestCheckArchiveReadable(t *testing.T) {
+	is := assert.New(t)
+	dir := t.TempDir()
+	archive := getTestTarGz(t, map[string]string{"etc/hosts": "127.0.0.1 localhost\n", "etc/hostname": "matt-server\n"})
+	writeFile := func(name string, contents []byte) string {
+		filePath := filepath.Join(dir, name)
+		is.NoError(os.WriteFile(filePath, contents, 0644))
+		return filePath
+	}
+
+	entries, err := checkArchiveReadable(writeFile("backup.tar.gz", archive))
+	is.NoError(err)
+	is.Equal(2, entries)
+	_, err = checkArchiveReadable(writeFile("truncated.tar.gz", archive[:len(archive)/2]))
+	is.Error(err, "Should error on a truncated archive")
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create("dump.sql")
+	w.Write([]byte("create table backups;"))
+	zw.Close()
+	entries, err = checkArchiveReadable(writeFile("backup.zip", zipped.Bytes()))
+	is.NoError(err)
+	is.Equal(1, entries)
+	_, err = checkArchiveReadable(writeFile("corrupt.zip", zipped.Bytes()[10:]))
+	is.Error(err, "Should error on a corrupt zip")
+
+	entries, err = checkArchiveReadable(writeFile("dump.sql", []byte("create table backups;")))
+	is.NoError(err, "Should pass files that aren't archives")
+	is.Equal(0, entries)
+}
+
+func TestVerifyNewestBackup(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	archive := getTestTarGz(t, map[string]string{"etc/hosts": "127.0.0.1 localhost\n"})
+	client, _ := newTestStorageServer(t, "test-matt-server-backups", map[string][]byte{"backup.tar.gz": archive})
+	bucket := newGCSStore(client.Bucket("test-matt-server-backups"))
+	bucketConfig := BucketToProcess{Name: "test-matt-server-backups", Type: "server-backup"}
+	config := Config{FileDownloadLocation: t.TempDir()}
+	localFile := filepath.Join(config.FileDownloadLocation, newestDirectory, "test-matt-server-backups", "backup.tar.gz")
+
+	result := verifyNewestBackup(ctx, bucket, config, bucketConfig, true, false, time.Now())
+	is.True(result.passed(), result.Error)
+	is.Equal("backup.tar.gz", result.ObjectName)
+	is.Equal(int64(len(archive)), result.Size)
+	is.Equal(1, result.Entries)
+	is.NoFileExists(localFile, "Should remove the download once checked")
+
+	result = verifyNewestBackup(ctx, bucket, config, bucketConfig, false, true, time.Now())
+	is.True(result.passed(), result.Error)
+	is.FileExists(localFile, "Should keep the download when asked to")
+
+	config.ServerBackupRules.NewestFileMaxAgeInDays = 5
+	result = verifyNewestBackup(ctx, bucket, config, bucketConfig, false, false, time.Now())
+	is.False(result.passed(), "Should fail a newest backup that's too old")
+	is.Contains(result.Error, "more than 5 days ago")
+
+	bucketConfig.Prefix = "nightly/"
+	result = verifyNewestBackup(ctx, bucket, config, bucketConfig, false, false, time.Now())
+	is.False(result.passed(), "Should fail when there's no backup")
+}
+
+func TestWriteNewestResults(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	results := []NewestResult{
+		{BucketName: "test-matt-server-backups", ObjectName: "backup.tar.gz", Size: 2048, Created: now.Add(-5 * time.Hour), Entries: 12},
+		{BucketName: "test-matt-db-backups", Error: "Unable to find the newest backup in bucket test-matt-db-backups"},
+	}
+	var out bytes.Buffer
+	is.NoError(writeNewestResults(&out, results, now))
+	actual := out.String()
+	is.Regexp(`test-matt-server-backups\s+backup\.tar\.gz\s+2\.0 KiB\s+5h0m0s\s+12\s+passed`, actual)
+	is.Regexp(`test-matt-db-backups\s+0 B\s+0\s+failed`, actual)
+	is.Contains(actual, "\ntest-matt-db-backups: Unable to find the newest backup in bucket test-matt-db-backups\n")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// objectFilter decides whether an object is a candidate for sampling.
+// A nil objectFilter accepts every object.
+type objectFilter func(objAttrs *storage.ObjectAttrs) bool
+
+func (f objectFilter) accepts(objAttrs *storage.ObjectAttrs) bool {
+	return f == nil || f(objAttrs)
+}
+
+// samplingOptions adjusts which objects are picked when sampling a bucket.
+// Objects rejected by filter are never picked, objects named in avoid are only picked when there aren't enough others.
+// When sizer is enabled it decides how many objects are picked instead of the requested count.
+// stratifyBySeason spreads the picks across the seasons of a show, see pickSeasonStratifiedObjectNames.
+// showPrefixDepth is how many directories deep a media bucket's shows are, e.g. 2 for library/show/, 0 meaning top level.
+// preferCheapClasses only picks from colder storage classes when there aren't enough objects in cheaper ones, see preferCheapestClasses.
+type samplingOptions struct {
+	filter             objectFilter
+	avoid              map[string]bool
+	sizer              sampleSizer
+	stratifyBySeason   bool
+	preferCheapClasses bool
+	showPrefixDepth    int
+}
+
+// maxFileSizeFilter rejects objects bigger than maxBytes, or accepts everything when maxBytes isn't positive.
+func maxFileSizeFilter(maxBytes int64) objectFilter {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return func(objAttrs *storage.ObjectAttrs) bool {
+		return objAttrs.Size <= maxBytes
+	}
+}
+
+// chainObjectFilters accepts objects accepted by every one of filters, skipping nil filters.
+func chainObjectFilters(filters ...objectFilter) objectFilter {
+	var chain []objectFilter
+	for _, filter := range filters {
+		if filter != nil {
+			chain = append(chain, filter)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return func(objAttrs *storage.ObjectAttrs) bool {
+		for _, filter := range chain {
+			if !filter(objAttrs) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// sampleFilterBuilders turn each type of SampleFilter into an objectFilter, add a builder here for a new type of filter.
+var sampleFilterBuilders = map[string]func(rule SampleFilter, now time.Time) (objectFilter, error){
+	"size":      newSizeRangeFilter,
+	"age":       newAgeRangeFilter,
+	"extension": newExtensionFilter,
+	"regex":     newRegexFilter,
+}
+
+// newSampleFilterChain builds a bucket's sample filters into a single filter accepting objects that pass all of them.
+func newSampleFilterChain(rules []SampleFilter, now time.Time) (filter objectFilter, err error) {
+	filters := make([]objectFilter, 0, len(rules))
+	for i, rule := range rules {
+		build, found := sampleFilterBuilders[rule.Type]
+		if !found {
+			return nil, errors.NotSupportedf("Sample filter %d type %q, known types are %s", i+1, rule.Type, strings.Join(getSampleFilterTypes(), ", "))
+		}
+		built, err2 := build(rule, now)
+		if err2 != nil {
+			return nil, errors.Annotatef(err2, "Invalid sample filter %d", i+1)
+		}
+		if rule.Invert {
+			built = invertObjectFilter(built)
+		}
+		filters = append(filters, built)
+	}
+	return chainObjectFilters(filters...), nil
+}
+
+func getSampleFilterTypes() (types []string) {
+	for filterType := range sampleFilterBuilders {
+		types = append(types, filterType)
+	}
+	sort.Strings(types)
+	return
+}
+
+func invertObjectFilter(filter objectFilter) objectFilter {
+	return func(objAttrs *storage.ObjectAttrs) bool {
+		return !filter.accepts(objAttrs)
+	}
+}
+
+// newSizeRangeFilter accepts objects of at least MinBytes and at most MaxBytes, a bound of 0 is left open.
+func newSizeRangeFilter(rule SampleFilter, _ time.Time) (objectFilter, error) {
+	if rule.MinBytes < 0 || rule.MaxBytes < 0 || (rule.MaxBytes > 0 && rule.MinBytes > rule.MaxBytes) {
+		return nil, errors.NotValidf("Size range %d to %d bytes", rule.MinBytes, rule.MaxBytes)
+	}
+	return func(objAttrs *storage.ObjectAttrs) bool {
+		return objAttrs.Size >= rule.MinBytes && (rule.MaxBytes == 0 || objAttrs.Size <= rule.MaxBytes)
+	}, nil
+}
+
+// newAgeRangeFilter accepts objects created at least MinDays and at most MaxDays before now, a bound of 0 is left open.
+func newAgeRangeFilter(rule SampleFilter, now time.Time) (objectFilter, error) {
+	if rule.MinDays < 0 || rule.MaxDays < 0 || (rule.MaxDays > 0 && rule.MinDays > rule.MaxDays) {
+		return nil, errors.NotValidf("Age range %d to %d days", rule.MinDays, rule.MaxDays)
+	}
+	newest := now.AddDate(0, 0, -rule.MinDays)
+	oldest := now.AddDate(0, 0, -rule.MaxDays)
+	return func(objAttrs *storage.ObjectAttrs) bool {
+		return !objAttrs.Created.After(newest) && (rule.MaxDays == 0 || !objAttrs.Created.Before(oldest))
+	}, nil
+}
+
+// newExtensionFilter accepts objects whose name ends in one of Extensions, ignoring case. The leading dot is optional.
+func newExtensionFilter(rule SampleFilter, _ time.Time) (objectFilter, error) {
+	if len(rule.Extensions) == 0 {
+		return nil, errors.NotValidf("Extension filter without extensions")
+	}
+	allowed := make(map[string]bool)
+	for _, extension := range rule.Extensions {
+		allowed["."+strings.TrimPrefix(strings.ToLower(extension), ".")] = true
+	}
+	return func(objAttrs *storage.ObjectAttrs) bool {
+		return allowed[strings.ToLower(path.Ext(objAttrs.Name))]
+	}, nil
+}
+
+// newRegexFilter accepts objects whose name matches Pattern.
+func newRegexFilter(rule SampleFilter, _ time.Time) (objectFilter, error) {
+	pattern, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to parse pattern %s", rule.Pattern)
+	}
+	return func(objAttrs *storage.ObjectAttrs) bool {
+		return pattern.MatchString(objAttrs.Name)
+	}, nil
+}
+
+// objectNameMatcher is the compiled form of an ObjectNameFilter, also rejecting names under any of excludedPrefixes.
+// A nil matcher matches every object name.
+type objectNameMatcher struct {
+	include          *regexp.Regexp
+	excludes         []*regexp.Regexp
+	excludedPrefixes []string
+}
+
+func newObjectNameMatcher(filter ObjectNameFilter) (matcher *objectNameMatcher, err error) {
+	matcher = &objectNameMatcher{}
+	if len(filter.NamePattern) > 0 {
+		matcher.include, err = regexp.Compile(filter.NamePattern)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to parse name pattern %s", filter.NamePattern)
+		}
+	}
+	for _, pattern := range filter.ExcludePatterns {
+		exclude, err2 := regexp.Compile(pattern)
+		if err2 != nil {
+			return nil, errors.Annotatef(err2, "Unable to parse exclude pattern %s", pattern)
+		}
+		matcher.excludes = append(matcher.excludes, exclude)
+	}
+	return
+}
+
+func (m *objectNameMatcher) matches(name string) bool {
+	if m == nil {
+		return true
+	}
+	if m.include != nil && !m.include.MatchString(name) {
+		return false
+	}
+	for _, exclude := range m.excludes {
+		if exclude.MatchString(name) {
+			return false
+		}
+	}
+	for _, prefix := range m.excludedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// excludingPrefixes is a copy of the matcher that also rejects names under any of prefixes.
+// It is safe to call on a nil objectNameMatcher, and returns it as it is when there are no prefixes.
+func (m *objectNameMatcher) excludingPrefixes(prefixes []string) *objectNameMatcher {
+	if len(prefixes) == 0 {
+		return m
+	}
+	excluding := &objectNameMatcher{}
+	if m != nil {
+		*excluding = *m
+	}
+	excluding.excludedPrefixes = append(append([]string{}, excluding.excludedPrefixes...), prefixes...)
+	return excluding
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testObjectNameMatcherCases = []struct {
+	filter   ObjectNameFilter
+	name     string
+	expected bool
+}{
+	//an empty filter lets everything through
+	{ObjectNameFilter{}, "README.txt", true},
+	{ObjectNameFilter{NamePattern: `\.tar\.gz$`}, "backup-2018-01-01.tar.gz", true},
+	{ObjectNameFilter{NamePattern: `\.tar\.gz$`}, "README.txt", false},
+	{ObjectNameFilter{ExcludePatterns: []string{`^README`, `\.marker$`}}, "README.txt", false},
+	{ObjectNameFilter{ExcludePatterns: []string{`^README`, `\.marker$`}}, "done.marker", false},
+	{ObjectNameFilter{ExcludePatterns: []string{`^README`, `\.marker$`}}, "backup.tar.gz", true},
+	//excludes win over the include pattern
+	{ObjectNameFilter{NamePattern: `\.gz$`, ExcludePatterns: []string{`^tmp/`}}, "tmp/backup.gz", false},
+}
+
+func TestObjectNameMatcher(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testObjectNameMatcherCases {
+		matcher, err := newObjectNameMatcher(tc.filter)
+		is.NoError(err, "Should not error when compiling valid patterns")
+		is.Equal(tc.expected, matcher.matches(tc.name), "Unexpected match result for %s", tc.name)
+	}
+
+	var nilMatcher *objectNameMatcher
+	is.True(nilMatcher.matches("anything"), "Nil matcher should match every name")
+
+	_, err := newObjectNameMatcher(ObjectNameFilter{NamePattern: "("})
+	is.Error(err, "Should error when name pattern cannot be parsed")
+
+	_, err = newObjectNameMatcher(ObjectNameFilter{ExcludePatterns: []string{"["}})
+	is.Error(err, "Should error when exclude pattern cannot be parsed")
+}
+
+func TestObjectNameMatcherExcludingPrefixes(t *testing.T) {
+	is := assert.New(t)
+	matcher, err := newObjectNameMatcher(ObjectNameFilter{NamePattern: `\.tar\.gz$`})
+	is.NoError(err)
+	excluding := matcher.excludingPrefixes([]string{"import/", "legacy/"})
+	is.True(excluding.matches("nightly/db.tar.gz"))
+	is.False(excluding.matches("import/db.tar.gz"), "Should reject names under an excluded prefix")
+	is.False(excluding.matches("nightly/README"), "Should keep the filter's own patterns")
+	is.True(matcher.matches("import/db.tar.gz"), "Should leave the original matcher alone")
+
+	var nilMatcher *objectNameMatcher
+	is.Nil(nilMatcher.excludingPrefixes(nil))
+	is.False(nilMatcher.excludingPrefixes([]string{"import/"}).matches("import/db.tar.gz"))
+	is.True(nilMatcher.excludingPrefixes([]string{"import/"}).matches("nightly/db.tar.gz"))
+}
+
+func TestMaxFileSizeFilter(t *testing.T) {
+	is := assert.New(t)
+	filter := maxFileSizeFilter(100)
+	is.True(filter.accepts(&storage.ObjectAttrs{Size: 100}), "Should accept objects at the cap")
+	is.False(filter.accepts(&storage.ObjectAttrs{Size: 101}), "Should reject objects over the cap")
+
+	is.Nil(maxFileSizeFilter(0), "Should not filter anything when there is no cap")
+	is.True(maxFileSizeFilter(0).accepts(&storage.ObjectAttrs{Size: 1 << 40}), "Nil filter should accept everything")
+}
+
+func TestChainObjectFilters(t *testing.T) {
+	is := assert.New(t)
+	is.Nil(chainObjectFilters(), "Should not filter anything without filters")
+	is.Nil(chainObjectFilters(nil, nil))
+
+	chain := chainObjectFilters(maxFileSizeFilter(100), nil, func(objAttrs *storage.ObjectAttrs) bool { return objAttrs.Size > 10 })
+	is.True(chain.accepts(&storage.ObjectAttrs{Size: 50}))
+	is.False(chain.accepts(&storage.ObjectAttrs{Size: 5}), "Should reject objects any filter rejects")
+	is.False(chain.accepts(&storage.ObjectAttrs{Size: 500}), "Should reject objects any filter rejects")
+}
+
+var testSampleFilterNow = time.Date(2018, 1, 20, 0, 0, 0, 0, time.UTC)
+
+var testSampleFilterChainCases = []struct {
+	rules    []SampleFilter
+	object   storage.ObjectAttrs
+	expected bool
+}{
+	{rules: nil, object: storage.ObjectAttrs{Name: "anything"}, expected: true},
+	{rules: []SampleFilter{{Type: "size", MinBytes: 100}}, object: storage.ObjectAttrs{Size: 100}, expected: true},
+	{rules: []SampleFilter{{Type: "size", MinBytes: 100}}, object: storage.ObjectAttrs{Size: 99}, expected: false},
+	{rules: []SampleFilter{{Type: "size", MinBytes: 10, MaxBytes: 100}}, object: storage.ObjectAttrs{Size: 101}, expected: false},
+	{rules: []SampleFilter{{Type: "age", MinDays: 7}}, object: storage.ObjectAttrs{Created: testSampleFilterNow.AddDate(0, 0, -7)}, expected: true},
+	{rules: []SampleFilter{{Type: "age", MinDays: 7}}, object: storage.ObjectAttrs{Created: testSampleFilterNow.AddDate(0, 0, -6)}, expected: false},
+	{rules: []SampleFilter{{Type: "age", MaxDays: 30}}, object: storage.ObjectAttrs{Created: testSampleFilterNow.AddDate(0, 0, -31)}, expected: false},
+	{rules: []SampleFilter{{Type: "age", MaxDays: 30}}, object: storage.ObjectAttrs{Created: testSampleFilterNow.AddDate(0, 0, -1)}, expected: true},
+	{rules: []SampleFilter{{Type: "extension", Extensions: []string{".mkv", "mp4"}}}, object: storage.ObjectAttrs{Name: "show/ep1.MKV"}, expected: true},
+	{rules: []SampleFilter{{Type: "extension", Extensions: []string{".mkv", "mp4"}}}, object: storage.ObjectAttrs{Name: "show/ep1.mp4"}, expected: true},
+	{rules: []SampleFilter{{Type: "extension", Extensions: []string{".mkv", "mp4"}}}, object: storage.ObjectAttrs{Name: "show/ep1.srt"}, expected: false},
+	{rules: []SampleFilter{{Type: "extension", Extensions: []string{".mkv"}}}, object: storage.ObjectAttrs{Name: "show.mkv/readme"}, expected: false},
+	{rules: []SampleFilter{{Type: "regex", Pattern: "^show/"}}, object: storage.ObjectAttrs{Name: "show/ep1.mkv"}, expected: true},
+	{rules: []SampleFilter{{Type: "regex", Pattern: "^tmp/", Invert: true}}, object: storage.ObjectAttrs{Name: "tmp/ep1.mkv"}, expected: false},
+	{rules: []SampleFilter{{Type: "regex", Pattern: "^tmp/", Invert: true}}, object: storage.ObjectAttrs{Name: "show/ep1.mkv"}, expected: true},
+	//only .mkv over 100 MB
+	{rules: []SampleFilter{{Type: "extension", Extensions: []string{".mkv"}}, {Type: "size", MinBytes: 100 << 20}},
+		object: storage.ObjectAttrs{Name: "show/ep1.mkv", Size: 200 << 20}, expected: true},
+	{rules: []SampleFilter{{Type: "extension", Extensions: []string{".mkv"}}, {Type: "size", MinBytes: 100 << 20}},
+		object: storage.ObjectAttrs{Name: "show/ep1.mkv", Size: 1 << 20}, expected: false},
+	{rules: []SampleFilter{{Type: "extension", Extensions: []string{".mkv"}}, {Type: "size", MinBytes: 100 << 20}},
+		object: storage.ObjectAttrs{Name: "show/ep1.avi", Size: 200 << 20}, expected: false},
+}
+
+func TestNewSampleFilterChain(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testSampleFilterChainCases {
+		filter, err := newSampleFilterChain(tc.rules, testSampleFilterNow)
+		is.NoError(err, "%+v", tc.rules)
+		object := tc.object
+		is.Equal(tc.expected, filter.accepts(&object), "%+v with %+v", tc.rules, tc.object)
+	}
+}
+
+var testSampleFilterChainErrorCases = [][]SampleFilter{
+	{{Type: "colour"}},
+	{{}},
+	{{Type: "size", MinBytes: -1}},
+	{{Type: "size", MinBytes: 100, MaxBytes: 10}},
+	{{Type: "age", MaxDays: -1}},
+	{{Type: "age", MinDays: 30, MaxDays: 7}},
+	{{Type: "extension"}},
+	{{Type: "regex", Pattern: "("}},
+	{{Type: "size", MinBytes: 1}, {Type: "regex", Pattern: "["}},
+}
+
+func TestNewSampleFilterChainErrors(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testSampleFilterChainErrorCases {
+		_, err := newSampleFilterChain(tc, testSampleFilterNow)
+		is.Error(err, "%+v", tc)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+// objectNameRange is the part of a bucket a listing covers, the names under Prefix from StartOffset up to but not including EndOffset.
+// Offsets narrow a listing server side where a prefix can't, e.g. to just the month directories of a photo year.
+// Like Prefix, the offsets are relative to the bucket prefix, if there is one.
+type objectNameRange struct {
+	Prefix      string
+	StartOffset string
+	EndOffset   string
+}
+
+// getPhotoYearRange covers the month directories of a year of photos, 2015-01/ up to 2015-12/,
+// leaving out anything else whose name starts with the year.
+func getPhotoYearRange(year int) objectNameRange {
+	return objectNameRange{StartOffset: fmt.Sprintf("%d-01/", year), EndOffset: fmt.Sprintf("%d-13", year)}
+}
+
+// isWholeBucket is whether listing the range lists everything under the bucket prefix.
+func (r objectNameRange) isWholeBucket() bool {
+	return len(r.Prefix) == 0 && len(r.StartOffset) == 0 && len(r.EndOffset) == 0
+}
+
+// query lists the range, with the offsets put under the bucket prefix in ctx. listObjects scopes the prefix.
+func (r objectNameRange) query(ctx context.Context) *storage.Query {
+	q := &storage.Query{Prefix: r.Prefix}
+	bucketPrefix := bucketPrefixFromContext(ctx)
+	if len(r.StartOffset) > 0 {
+		q.StartOffset = bucketPrefix + r.StartOffset
+	}
+	if len(r.EndOffset) > 0 {
+		q.EndOffset = bucketPrefix + r.EndOffset
+	}
+	return q
+}
+
+func (r objectNameRange) String() string {
+	if len(r.StartOffset) == 0 && len(r.EndOffset) == 0 {
+		return fmt.Sprintf("%q", r.Prefix)
+	}
+	return fmt.Sprintf("%q from %q to %q", r.Prefix, r.StartOffset, r.EndOffset)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectNameRangeQuery(t *testing.T) {
+	is := assert.New(t)
+	is.True(objectNameRange{}.isWholeBucket())
+	is.False(objectNameRange{Prefix: "show/"}.isWholeBucket())
+	is.False(getPhotoYearRange(2015).isWholeBucket())
+
+	q := getPhotoYearRange(2015).query(context.Background())
+	is.Equal(storage.Query{StartOffset: "2015-01/", EndOffset: "2015-13"}, *q)
+	q = getPhotoYearRange(2015).query(withBucketPrefix(context.Background(), "phone/"))
+	is.Equal(storage.Query{StartOffset: "phone/2015-01/", EndOffset: "phone/2015-13"}, *q, "Should put offsets under the bucket prefix")
+	is.Equal(`"show/"`, objectNameRange{Prefix: "show/"}.String())
+	is.Equal(`"" from "2015-01/" to "2015-13"`, getPhotoYearRange(2015).String())
+}
+
+func TestGetRandomFilesInPhotoYear(t *testing.T) {
+	is := assert.New(t)
+	bs := &BucketSummary{}
+	report := &inventoryReport{objects: []*storage.ObjectAttrs{
+		{Name: "2014-12/IMG_01.jpg"},
+		{Name: "2015-01/IMG_02.jpg"},
+		{Name: "2015-12/IMG_03.jpg"},
+		{Name: "2015-scans/SCAN_04.jpg"},
+		{Name: "2016-01/IMG_05.jpg"},
+	}}
+	ctx := withInventoryReport(withBucketSummary(context.Background(), bs), report)
+	photos, err := getRandomFilesInRange(ctx, nil, 2, getPhotoYearRange(2015), samplingOptions{})
+	is.NoError(err)
+	is.ElementsMatch([]string{"2015-01/IMG_02.jpg", "2015-12/IMG_03.jpg"}, photos, "Should only sample the year's month directories")
+	is.Equal(2, bs.ObjectsExamined, "Should only list the year's month directories")
+	is.False(bs.Inventory.Complete)
+
+	_, err = getRandomFilesInRange(ctx, nil, 3, getPhotoYearRange(2015), samplingOptions{})
+	is.Error(err, "Should error when the year doesn't hold enough photos")
+}
+
+func TestGetServerBackupsToDownloadInWindow(t *testing.T) {
+	is := assert.New(t)
+	now := time.Now()
+	bs := &BucketSummary{}
+	report := &inventoryReport{objects: getTestDatedBackups(now, 100)}
+	ctx, err := withFreshnessWindow(withInventoryReport(withBucketSummary(context.Background(), bs), report),
+		FreshnessWindow{NameLayout: "nightly/2006-01-02", Days: 5}, ServerFileValidationRules{})
+	is.NoError(err)
+
+	backups, err := getServerBackupsToDownload(ctx, nil, FileDownloadRules{ServerBackups: 2}, samplingOptions{})
+	is.NoError(err)
+	is.Equal([]string{report.objects[99].Name, report.objects[98].Name}, backups)
+	is.Equal(6, bs.ObjectsExamined, "Should only list the backups named within the window")
+
+	bs.ObjectsExamined = 0
+	backups, err = getServerBackupsToDownload(ctx, nil, FileDownloadRules{ServerBackups: 8}, samplingOptions{})
+	is.NoError(err)
+	is.Len(backups, 8, "Should list everything when the window doesn't hold enough backups")
+	is.Equal(106, bs.ObjectsExamined)
+	is.True(bs.Inventory.Complete)
+}
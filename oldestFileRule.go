@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// oldestFileScope is where one max age applies, to the objects under prefix matching include, or every one when include is nil.
+type oldestFileScope struct {
+	prefix     string
+	maxAgeDays int
+	include    *regexp.Regexp
+}
+
+// oldestFileChecker is a bucket's OldestFileRule compiled, with oldest_file_max_age_in_days as the age outside any prefix.
+type oldestFileChecker struct {
+	//longest prefix first, ending with the bucket wide scope
+	scopes []oldestFileScope
+	grace  []*regexp.Regexp
+}
+
+func newOldestFileChecker(rule OldestFileRule, maxAgeDays int) (checker *oldestFileChecker, err error) {
+	compile := func(pattern string, inherited *regexp.Regexp) (*regexp.Regexp, error) {
+		if len(pattern) == 0 {
+			return inherited, nil
+		}
+		compiled, err := regexp.Compile(pattern)
+		return compiled, errors.Annotatef(err, "Unable to parse name pattern %s", pattern)
+	}
+	bucketWide := oldestFileScope{maxAgeDays: maxAgeDays}
+	bucketWide.include, err = compile(rule.NamePattern, nil)
+	if err != nil {
+		return nil, err
+	}
+	checker = &oldestFileChecker{}
+	for _, prefixRule := range rule.Prefixes {
+		if len(prefixRule.Prefix) == 0 {
+			return nil, errors.NotValidf("Oldest file rule without a prefix")
+		}
+		if prefixRule.MaxAgeInDays < 0 {
+			return nil, errors.NotValidf("Oldest file max age %d under %s", prefixRule.MaxAgeInDays, prefixRule.Prefix)
+		}
+		scope := oldestFileScope{prefix: prefixRule.Prefix, maxAgeDays: prefixRule.MaxAgeInDays}
+		if scope.maxAgeDays == 0 {
+			scope.maxAgeDays = maxAgeDays
+		}
+		scope.include, err = compile(prefixRule.NamePattern, bucketWide.include)
+		if err != nil {
+			return nil, err
+		}
+		checker.scopes = append(checker.scopes, scope)
+	}
+	sort.SliceStable(checker.scopes, func(i, j int) bool { return len(checker.scopes[i].prefix) > len(checker.scopes[j].prefix) })
+	checker.scopes = append(checker.scopes, bucketWide)
+	for _, pattern := range rule.GracePatterns {
+		grace, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to parse grace pattern %s", pattern)
+		}
+		checker.grace = append(checker.grace, grace)
+	}
+	return
+}
+
+// maxAgeDays is how many days old the object named name, relative to the bucket prefix, can get,
+// going by the longest prefix it is under. applies is false when the rule leaves the object out.
+func (c *oldestFileChecker) maxAgeDays(name string) (days int, applies bool) {
+	for _, grace := range c.grace {
+		if grace.MatchString(name) {
+			return 0, false
+		}
+	}
+	for _, scope := range c.scopes {
+		if strings.HasPrefix(name, scope.prefix) {
+			return scope.maxAgeDays, scope.include == nil || scope.include.MatchString(name)
+		}
+	}
+	return 0, false
+}
+
+// shortestMaxAgeDays is the strictest age anywhere in the bucket, which a freshness window lists archived backups by.
+func (c *oldestFileChecker) shortestMaxAgeDays() (days int) {
+	days = c.scopes[0].maxAgeDays
+	for _, scope := range c.scopes[1:] {
+		days = min(days, scope.maxAgeDays)
+	}
+	return
+}
+
+// findOverdueObject is the oldest object accepted by matcher that is older than the checker allows, nil when there isn't one.
+func findOverdueObject(ctx context.Context, bucket BackupStore, matcher *objectNameMatcher, checker *oldestFileChecker,
+	q *storage.Query, now time.Time) (overdue *storage.ObjectAttrs, maxAgeDays int, err error) {
+	bucketPrefix := bucketPrefixFromContext(ctx)
+	it := listObjects(ctx, bucket, q)
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			if q == nil {
+				markInventoryComplete(ctx)
+			}
+			break
+		}
+		if err2 != nil {
+			return nil, 0, errors.Annotate(err2, "Unable to get oldest object from bucket")
+		}
+		countObjectExamined(ctx, objAttrs)
+		if !matcher.matches(objAttrs.Name) {
+			continue
+		}
+		days, applies := checker.maxAgeDays(strings.TrimPrefix(objAttrs.Name, bucketPrefix))
+		//this may not be 100% accurate due to daylight savings time and whatnot, but close enough
+		if !applies || int(now.Sub(objAttrs.Created)/(time.Hour*24)) < days {
+			continue
+		}
+		if overdue == nil || objAttrs.Created.Before(overdue.Created) {
+			overdue, maxAgeDays = objAttrs, days
+		}
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testOldestFileRule = OldestFileRule{
+	NamePattern:   `\.dump$`,
+	GracePatterns: []string{`^db/2019-keep\.dump$`},
+	Prefixes: []OldestFilePrefixRule{
+		{Prefix: "db/", MaxAgeInDays: 7},
+		{Prefix: "db/weekly/", MaxAgeInDays: 60},
+		{Prefix: "logs/", NamePattern: `\.log\.gz$`},
+	},
+}
+
+var testOldestFileMaxAgeCases = []struct {
+	name    string
+	days    int
+	applies bool
+}{
+	{"db/nightly.dump", 7, true},
+	{"db/weekly/sunday.dump", 60, true},
+	{"db/weekly/README", 60, false},
+	{"db/2019-keep.dump", 0, false},
+	{"logs/app.log.gz", 30, true},
+	{"logs/app.dump", 30, false},
+	{"photos/2015/beach.jpg", 30, false},
+	{"full.dump", 30, true},
+}
+
+func TestOldestFileCheckerMaxAgeDays(t *testing.T) {
+	is := assert.New(t)
+	checker, err := newOldestFileChecker(testOldestFileRule, 30)
+	is.NoError(err)
+	for _, tc := range testOldestFileMaxAgeCases {
+		days, applies := checker.maxAgeDays(tc.name)
+		is.Equal(tc.applies, applies, "case %s", tc.name)
+		if tc.applies {
+			is.Equal(tc.days, days, "case %s", tc.name)
+		}
+	}
+	is.Equal(7, checker.shortestMaxAgeDays())
+
+	checker, err = newOldestFileChecker(OldestFileRule{}, 30)
+	is.NoError(err)
+	days, applies := checker.maxAgeDays("anything")
+	is.True(applies, "Should apply to every object without a rule")
+	is.Equal(30, days)
+}
+
+func TestNewOldestFileCheckerErrors(t *testing.T) {
+	is := assert.New(t)
+	_, err := newOldestFileChecker(OldestFileRule{NamePattern: "("}, 30)
+	is.Error(err)
+	_, err = newOldestFileChecker(OldestFileRule{GracePatterns: []string{"["}}, 30)
+	is.Error(err)
+	_, err = newOldestFileChecker(OldestFileRule{Prefixes: []OldestFilePrefixRule{{MaxAgeInDays: 7}}}, 30)
+	is.True(errors.IsNotValid(err), "Should need a prefix")
+	_, err = newOldestFileChecker(OldestFileRule{Prefixes: []OldestFilePrefixRule{{Prefix: "db/", MaxAgeInDays: -1}}}, 30)
+	is.True(errors.IsNotValid(err), "Should refuse a negative age")
+}
+
+func TestValidateServerBackupsWithOldestFileRule(t *testing.T) {
+	is := assert.New(t)
+	now := time.Now()
+	rules := ServerFileValidationRules{OldestFileMaxAgeInDays: 30, NewestFileMaxAgeInDays: 2}
+	checker, err := newOldestFileChecker(testOldestFileRule, rules.OldestFileMaxAgeInDays)
+	is.NoError(err)
+	report := &inventoryReport{objects: []*storage.ObjectAttrs{
+		{Name: "backups/archive/2015.tar.gz", Created: now.AddDate(-10, 0, 0), Size: 10},
+		{Name: "backups/db/2019-keep.dump", Created: now.AddDate(-5, 0, 0), Size: 10},
+		{Name: "backups/db/nightly.dump", Created: now.AddDate(0, 0, -1), Size: 10},
+		{Name: "backups/db/weekly/sunday.dump", Created: now.AddDate(0, 0, -40), Size: 10},
+	}}
+	ctx := withBucketPrefix(withInventoryReport(context.Background(), report), "backups/")
+	is.NoError(validateServerBackups(ctx, nil, rules, nil, checker, nil),
+		"Should pass when only archives, grace listed dumps and dumps within their prefix's age are old")
+
+	err = validateServerBackups(ctx, nil, rules, nil, nil, nil)
+	is.True(errors.IsNotValid(err), "Should fail on the archive without a rule")
+
+	report.objects = append(report.objects, &storage.ObjectAttrs{Name: "backups/db/stale.dump", Created: now.AddDate(0, 0, -9), Size: 10})
+	err = validateServerBackups(ctx, nil, rules, nil, checker, nil)
+	is.True(errors.IsNotValid(err), "Should fail on a dump older than its prefix allows")
+	is.Contains(err.Error(), "Oldest file backups/db/stale.dump")
+	is.Contains(err.Error(), "more than 7 days ago")
+}
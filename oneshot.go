@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Environment variables read by --oneshot, which runs without any config file.
+const (
+	envOneshot              = "VALIDATEBACKUPS_ONESHOT"
+	envBuckets              = "VALIDATEBACKUPS_BUCKETS"
+	envOldestFileMaxAgeDays = "VALIDATEBACKUPS_OLDEST_FILE_MAX_AGE_DAYS"
+	envNewestFileMaxAgeDays = "VALIDATEBACKUPS_NEWEST_FILE_MAX_AGE_DAYS"
+	envServerBackups        = "VALIDATEBACKUPS_SERVER_BACKUPS"
+	envEpisodesFromEachShow = "VALIDATEBACKUPS_EPISODES_FROM_EACH_SHOW"
+	envPhotosFromThisMonth  = "VALIDATEBACKUPS_PHOTOS_FROM_THIS_MONTH"
+	envPhotosFromEachYear   = "VALIDATEBACKUPS_PHOTOS_FROM_EACH_YEAR"
+	envMaxFileSizeBytes     = "VALIDATEBACKUPS_MAX_FILE_SIZE_BYTES"
+	envMaxDownloadRetries   = "VALIDATEBACKUPS_MAX_DOWNLOAD_RETRIES"
+)
+
+var bucketTypes = []string{"media", "photo", "server-backup"}
+
+// oneshotDefaults keeps a bare container invocation quick: configDefaults, with downloads going to a directory of their own.
+var oneshotDefaults = func() Config {
+	config := configDefaults
+	config.FileDownloadLocation = "downloads"
+	return config
+}()
+
+// getConfigFromEnv builds the whole config from environment variables, falling back to oneshotDefaults for anything not set.
+// VALIDATEBACKUPS_BUCKETS is required and lists the buckets as name=type pairs separated by commas.
+func getConfigFromEnv(getenv func(string) string) (config Config, err error) {
+	config = oneshotDefaults
+	config.Buckets, err = parseBucketList(getenv(envBuckets))
+	if err != nil {
+		return
+	}
+	config, err = normalizeBucketNames(config)
+	if err != nil {
+		return
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{envOldestFileMaxAgeDays, &config.ServerBackupRules.OldestFileMaxAgeInDays},
+		{envNewestFileMaxAgeDays, &config.ServerBackupRules.NewestFileMaxAgeInDays},
+		{envServerBackups, &config.FilesToDownload.ServerBackups},
+		{envEpisodesFromEachShow, &config.FilesToDownload.EpisodesFromEachShow},
+		{envPhotosFromThisMonth, &config.FilesToDownload.PhotosFromThisMonth},
+		{envPhotosFromEachYear, &config.FilesToDownload.PhotosFromEachYear},
+		{envMaxDownloadRetries, &config.MaxDownloadRetries},
+	}
+	for _, setting := range ints {
+		value := getenv(setting.name)
+		if len(value) == 0 {
+			continue
+		}
+		*setting.value, err = strconv.Atoi(value)
+		if err != nil {
+			return config, errors.NotValidf("%s value %q", setting.name, value)
+		}
+	}
+	if value := getenv(envMaxFileSizeBytes); len(value) > 0 {
+		config.FilesToDownload.MaxFileSizeBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return config, errors.NotValidf("%s value %q", envMaxFileSizeBytes, value)
+		}
+	}
+	return applyEnvOverrides(config, getenv), nil
+}
+
+// parseBucketList reads buckets written like "my-media=media,my-backups=server-backup".
+func parseBucketList(value string) (buckets []BucketToProcess, err error) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		//split at the last =, object prefixes may hold one but bucket types don't
+		separator := strings.LastIndex(entry, "=")
+		if separator < 0 {
+			return nil, errors.NotValidf("Bucket %q, expected name=type", entry)
+		}
+		name, bucketType := strings.TrimSpace(entry[:separator]), strings.TrimSpace(entry[separator+1:])
+		if len(name) == 0 {
+			return nil, errors.NotValidf("Bucket %q, expected name=type", entry)
+		}
+		if !isKnownBucketType(bucketType) {
+			return nil, errors.NotValidf("Bucket type %q for %s, expected one of %s", bucketType, name, strings.Join(bucketTypes, ", "))
+		}
+		buckets = append(buckets, BucketToProcess{Name: name, Type: bucketType})
+	}
+	if len(buckets) == 0 {
+		err = errors.NotFoundf("Buckets in %s", envBuckets)
+	}
+	return
+}
+
+func isKnownBucketType(bucketType string) bool {
+	for _, known := range bucketTypes {
+		if bucketType == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testParseBucketListCases = []struct {
+	value    string
+	expected []BucketToProcess
+}{
+	{"test-matt-media=media", []BucketToProcess{{Name: "test-matt-media", Type: "media"}}},
+	{" test-matt-photos = photo , test-matt-server-backups=server-backup ,", []BucketToProcess{
+		{Name: "test-matt-photos", Type: "photo"},
+		{Name: "test-matt-server-backups", Type: "server-backup"},
+	}},
+	{"gs://test-matt-server-backups/host=db/=server-backup", []BucketToProcess{
+		{Name: "gs://test-matt-server-backups/host=db/", Type: "server-backup"},
+	}},
+}
+
+func TestParseBucketList(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testParseBucketListCases {
+		actual, err := parseBucketList(tc.value)
+		is.NoError(err, "Should not error parsing %q", tc.value)
+		is.Equal(tc.expected, actual)
+	}
+
+	_, err := parseBucketList("")
+	is.True(errors.IsNotFound(err), "Should error when there are no buckets")
+	_, err = parseBucketList("test-matt-media")
+	is.True(errors.IsNotValid(err), "Should error when the type is missing")
+	_, err = parseBucketList("=media")
+	is.True(errors.IsNotValid(err), "Should error when the name is missing")
+	_, err = parseBucketList("test-matt-media=movies")
+	is.True(errors.IsNotValid(err), "Should error on an unknown type")
+}
+
+func TestGetConfigFromEnv(t *testing.T) {
+	is := assert.New(t)
+	actual, err := getConfigFromEnv(fakeGetenv(map[string]string{envBuckets: "test-matt-server-backups=server-backup"}))
+	is.NoError(err)
+	expected := oneshotDefaults
+	expected.Buckets = []BucketToProcess{{Name: "test-matt-server-backups", Type: "server-backup"}}
+	is.Equal(expected, actual, "Should use the defaults for anything not set")
+
+	actual, err = getConfigFromEnv(fakeGetenv(map[string]string{
+		envBuckets:              "test-matt-media=media",
+		envOldestFileMaxAgeDays: "90",
+		envNewestFileMaxAgeDays: "7",
+		envServerBackups:        "2",
+		envEpisodesFromEachShow: "3",
+		envPhotosFromThisMonth:  "4",
+		envPhotosFromEachYear:   "5",
+		envMaxDownloadRetries:   "6",
+		envMaxFileSizeBytes:     "1048576",
+		envDownloadDir:          "/downloads",
+	}))
+	is.NoError(err)
+	expected = oneshotDefaults
+	expected.FileDownloadLocation = "/downloads"
+	expected.MaxDownloadRetries = 6
+	expected.ServerBackupRules = ServerFileValidationRules{OldestFileMaxAgeInDays: 90, NewestFileMaxAgeInDays: 7}
+	expected.FilesToDownload = FileDownloadRules{ServerBackups: 2, EpisodesFromEachShow: 3, PhotosFromThisMonth: 4,
+		PhotosFromEachYear: 5, MaxFileSizeBytes: 1048576}
+	expected.Buckets = []BucketToProcess{{Name: "test-matt-media", Type: "media"}}
+	is.Equal(expected, actual)
+
+	_, err = getConfigFromEnv(fakeGetenv(nil))
+	is.Error(err, "Should error without any buckets")
+	_, err = getConfigFromEnv(fakeGetenv(map[string]string{envBuckets: "a=media", envServerBackups: "lots"}))
+	is.True(errors.IsNotValid(err), "Should error on a number that isn't")
+	_, err = getConfigFromEnv(fakeGetenv(map[string]string{envBuckets: "a=media", envMaxFileSizeBytes: "big"}))
+	is.True(errors.IsNotValid(err), "Should error on a size that isn't a number")
+}
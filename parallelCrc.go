@@ -0,0 +1,117 @@
+package main
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// parallelHashChunkSize is big enough that each goroutine spends its time hashing rather than seeking.
+const parallelHashChunkSize = 64 * 1024 * 1024
+
+// castagnoliReversed is the bit reversed Castagnoli polynomial, as used by the crc32 package's tables.
+const castagnoliReversed = 0x82f63b78
+
+// getCrc32CFromFileInChunks calculates the CRC32C of the first size bytes of file by hashing chunkSize pieces on up to workers goroutines,
+// then combining the pieces' checksums in order.
+func getCrc32CFromFileInChunks(file *os.File, size int64, chunkSize int64, workers int) (crc uint32, err error) {
+	if chunkSize <= 0 || workers <= 0 {
+		return 0, errors.NotValidf("Chunk size %d and workers %d", chunkSize, workers)
+	}
+	numChunks := int((size + chunkSize - 1) / chunkSize)
+	chunkCrcs := make([]uint32, numChunks)
+	chunkErrs := make([]error, numChunks)
+	table := crc32.MakeTable(crc32.Castagnoli)
+
+	chunks := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range chunks {
+				offset := int64(i) * chunkSize
+				length := min(chunkSize, size-offset)
+				hash := crc32.New(table)
+				written, err := io.Copy(hash, io.NewSectionReader(file, offset, length))
+				if err == nil && written != length {
+					err = io.ErrUnexpectedEOF
+				}
+				chunkCrcs[i], chunkErrs[i] = hash.Sum32(), err
+			}
+		}()
+	}
+	for i := 0; i < numChunks; i++ {
+		chunks <- i
+	}
+	close(chunks)
+	wg.Wait()
+
+	for i, chunkCrc := range chunkCrcs {
+		if chunkErrs[i] != nil {
+			return 0, errors.Annotatef(chunkErrs[i], "Unable to hash chunk %d of %s", i, file.Name())
+		}
+		length := min(chunkSize, size-int64(i)*chunkSize)
+		crc = crc32Combine(crc, chunkCrc, length)
+	}
+	return
+}
+
+// crc32Combine works out the CRC32C of two pieces of data joined together from the CRC32C of each piece and the length of the second,
+// without needing the data itself. It is a port of zlib's crc32_combine.
+func crc32Combine(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+	var even, odd [32]uint32
+
+	//odd is the operator for a single zero bit
+	odd[0] = castagnoliReversed
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(&even, &odd) //two zero bits
+	gf2MatrixSquare(&odd, &even) //four zero bits
+
+	//apply len2 zero bytes to crc1, squaring the operator for each bit of len2
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) (sum uint32) {
+	for i := 0; vec != 0; i++ {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+		vec >>= 1
+	}
+	return
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
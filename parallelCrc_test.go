@@ -0,0 +1,51 @@
+package main
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrc32Combine(t *testing.T) {
+	is := assert.New(t)
+	table := crc32.MakeTable(crc32.Castagnoli)
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	expected := crc32.Checksum(data, table)
+	for split := 0; split <= len(data); split++ {
+		first := crc32.Checksum(data[:split], table)
+		second := crc32.Checksum(data[split:], table)
+		is.Equal(expected, crc32Combine(first, second, int64(len(data)-split)), "Combined CRC should match when split at %d", split)
+	}
+}
+
+func TestGetCrc32CFromFileInChunks(t *testing.T) {
+	is := assert.New(t)
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(42)).Read(data)
+	filePath := filepath.Join(t.TempDir(), "random.bin")
+	os.WriteFile(filePath, data, 0644)
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Error("Could not open test file")
+	}
+	defer file.Close()
+
+	expected, err := getCrc32CFromFile(filePath)
+	is.NoError(err)
+	for _, chunkSize := range []int64{1, 999, 4096, 100000, 200000} {
+		actual, err := getCrc32CFromFileInChunks(file, int64(len(data)), chunkSize, 4)
+		is.NoError(err, "Should not error hashing in chunks of %d", chunkSize)
+		is.Equal(expected, actual, "Chunked CRC should match sequential CRC for chunks of %d", chunkSize)
+	}
+
+	_, err = getCrc32CFromFileInChunks(file, int64(len(data)), 0, 4)
+	is.Error(err, "Should error on a chunk size of 0")
+
+	//file is shorter than the size we asked for
+	_, err = getCrc32CFromFileInChunks(file, int64(len(data))+10, 4096, 4)
+	is.Error(err, "Should error when the file is truncated")
+}
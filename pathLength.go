@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+)
+
+// maxFileNameLength is the longest name for a single file or directory on NTFS, ext4 and APFS alike.
+const maxFileNameLength = 255
+
+// getLocalPathProblem explains why a file can't be saved at localPath, or is blank when it can.
+// Downloads are written to a .partial file beside localPath first, so that is the path checked.
+func getLocalPathProblem(localPath string, allowLongPaths bool) string {
+	abs, err := filepath.Abs(getPartialFilePath(localPath))
+	if err != nil {
+		abs = getPartialFilePath(localPath)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(abs), "/") {
+		if utf8.RuneCountInString(part) > maxFileNameLength {
+			return fmt.Sprintf("%s is %d characters, over the limit of %d for a single name", part, utf8.RuneCountInString(part), maxFileNameLength)
+		}
+	}
+	length, limit := getLocalPathLength(abs), getMaxLocalPathLength(allowLongPaths)
+	if length > limit {
+		return fmt.Sprintf("%s is %d characters, over the limit of %d", abs, length, limit)
+	}
+	return ""
+}
+
+// checkLocalPathLengths fails up front, listing every sampled object whose local path would be too long to save,
+// rather than part way through the downloads. Checksum only runs save nothing, so they always pass.
+func checkLocalPathLengths(config Config, mapping []BucketAndFiles) error {
+	if config.ActiveProfile.ChecksumOnly {
+		return nil
+	}
+	var problems []string
+	for _, bucketAndFiles := range mapping {
+		for _, file := range bucketAndFiles.Files {
+			localPath := getLocalFilePath(config.FileDownloadLocation, bucketAndFiles.BucketName, file, config.SanitizeFileNames)
+			if problem := getLocalPathProblem(localPath, config.AllowLongPaths); len(problem) > 0 {
+				problems = append(problems, fmt.Sprintf("%s/%s: %s", bucketAndFiles.BucketName, file, problem))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	reported := problems
+	if len(reported) > maxReportedOffenders {
+		reported = append(reported[:maxReportedOffenders:maxReportedOffenders],
+			fmt.Sprintf("and %d more", len(problems)-maxReportedOffenders))
+	}
+	return errors.NotValidf("Local paths for %d files, use a shorter file_download_location or set allow_long_paths. %s",
+		len(problems), strings.Join(reported, "; "))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestLongObjectName makes an object name with a path longer than any local path limit, from names that are each fine.
+func getTestLongObjectName() string {
+	var parts []string
+	for length := 0; length <= getMaxLocalPathLength(true); length += 201 {
+		parts = append(parts, strings.Repeat("d", 200))
+	}
+	return strings.Join(parts, "/") + "/episode.mkv"
+}
+
+func TestGetLocalPathProblem(t *testing.T) {
+	is := assert.New(t)
+	is.Empty(getLocalPathProblem("downloads/test-matt-media/show 1/episode.ogv", false))
+	is.Contains(getLocalPathProblem("downloads/"+strings.Repeat("a", 256)+".mkv", true), "for a single name")
+	partialLength := len(getPartialFilePath(""))
+	is.Empty(getLocalPathProblem("downloads/"+strings.Repeat("ü", maxFileNameLength-partialLength), false),
+		"Should count characters, not bytes, in names")
+	is.Contains(getLocalPathProblem("downloads/"+strings.Repeat("a", maxFileNameLength-partialLength+1), false), "for a single name",
+		"Should leave room in the name for the .partial file it is downloaded to")
+	is.Contains(getLocalPathProblem("downloads/"+getTestLongObjectName(), true), "over the limit of")
+}
+
+// getTestPathOfLength makes an absolute path of exactly length characters, from names that are each fine.
+func getTestPathOfLength(t *testing.T, length int) string {
+	path := t.TempDir()
+	for len(path) < length {
+		name := strings.Repeat("d", min(200, length-len(path)-1))
+		if len(name) == 0 {
+			//a lone separator would be cleaned away, so lengthen the last name instead
+			return path + "d"
+		}
+		path = filepath.Join(path, name)
+	}
+	return path
+}
+
+func TestGetLocalPathProblemAtLimit(t *testing.T) {
+	is := assert.New(t)
+	limit := getMaxLocalPathLength(false) - len(getPartialFilePath(""))
+	is.Empty(getLocalPathProblem(getTestPathOfLength(t, limit), false))
+	is.Contains(getLocalPathProblem(getTestPathOfLength(t, limit+1), false), "over the limit of",
+		"Should leave room in the path for the .partial file it is downloaded to")
+}
+
+func TestCheckLocalPathLengths(t *testing.T) {
+	is := assert.New(t)
+	config := Config{FileDownloadLocation: "downloads"}
+	mapping := []BucketAndFiles{{BucketName: "test-matt-media", Files: []string{"show 1/episode.ogv"}}}
+	is.NoError(checkLocalPathLengths(config, mapping))
+
+	for i := 0; i < maxReportedOffenders+2; i++ {
+		mapping[0].Files = append(mapping[0].Files, getTestLongObjectName()+strings.Repeat("x", i))
+	}
+	err := checkLocalPathLengths(config, mapping)
+	is.Error(err)
+	is.Contains(err.Error(), "Local paths for 12 files")
+	is.Contains(err.Error(), "and 2 more", "Should cap how many paths are listed")
+
+	config.ActiveProfile.ChecksumOnly = true
+	is.NoError(checkLocalPathLengths(config, mapping), "Should not check checksum only runs, they save nothing")
+}
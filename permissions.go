@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// writePermissions are the permissions a validation run never needs, holding any of them means a bug or a
+// mistyped command could change or delete backups.
+var writePermissions = []string{
+	"storage.objects.create",
+	"storage.objects.delete",
+	"storage.objects.update",
+	"storage.objects.setIamPolicy",
+	"storage.buckets.delete",
+	"storage.buckets.update",
+	"storage.buckets.setIamPolicy",
+}
+
+// excessAccessWarning starts the warnings about buckets the credentials could change, the others are about
+// buckets whose permissions couldn't be checked.
+const excessAccessWarning = "Credentials can change bucket"
+
+// permissionTester returns which of permissions the credentials hold on the bucket, like testIamPermissions.
+type permissionTester func(bucketName string, permissions []string) ([]string, error)
+
+func newIamPermissionTester(ctx context.Context, client *storage.Client) permissionTester {
+	return func(bucketName string, permissions []string) ([]string, error) {
+		return client.Bucket(bucketName).IAM().TestPermissions(ctx, permissions)
+	}
+}
+
+// checkLeastPrivilege lists a warning for every bucket the credentials could change, and for every bucket
+// whose permissions couldn't be checked. Checking only needs read access, so it works under --read-only too.
+// S3 buckets are skipped, their policies can't be tested like cloud storage iam.
+func checkLeastPrivilege(buckets []BucketToProcess, testPermissions permissionTester) (warnings []string) {
+	for _, bucket := range buckets {
+		if bucket.Provider == "s3" {
+			continue
+		}
+		granted, err := testPermissions(bucket.Name, writePermissions)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Unable to check permissions on bucket %s: %s", bucket.Name, err))
+			continue
+		}
+		if len(granted) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s %s: %s", excessAccessWarning, bucket.Name, strings.Join(granted, ", ")))
+		}
+	}
+	return
+}
+
+// writePermissionWarnings prints the warnings so they stand out from the rest of the run's output.
+// The header only says the credentials have too much access when at least one bucket showed they do.
+func writePermissionWarnings(w io.Writer, warnings []string, readOnly bool) {
+	if len(warnings) == 0 {
+		return
+	}
+	excess := false
+	for _, warning := range warnings {
+		excess = excess || strings.HasPrefix(warning, excessAccessWarning)
+	}
+	fmt.Fprintln(w, "********************************************************************************")
+	if excess {
+		fmt.Fprintln(w, "WARNING: these credentials have more access than validating backups needs.")
+	} else {
+		fmt.Fprintln(w, "WARNING: unable to check whether these credentials have more access than validating backups needs.")
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "  %s\n", warning)
+	}
+	fmt.Fprintln(w, "Use a service account with only roles/storage.objectViewer on the buckets for validation runs.")
+	if readOnly {
+		fmt.Fprintln(w, "Read only mode is on, so nothing will be written this run.")
+	}
+	fmt.Fprintln(w, "********************************************************************************")
+}
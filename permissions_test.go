@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLeastPrivilege(t *testing.T) {
+	is := assert.New(t)
+	tester := func(bucketName string, permissions []string) ([]string, error) {
+		is.Equal(writePermissions, permissions, "Should only ask about write permissions")
+		switch bucketName {
+		case "test-matt-media":
+			return nil, nil
+		case "test-matt-photos":
+			return []string{"storage.objects.create", "storage.objects.delete"}, nil
+		}
+		return nil, errors.NotFoundf("Bucket %s", bucketName)
+	}
+	actual := checkLeastPrivilege([]BucketToProcess{{Name: "test-matt-media"}, {Name: "test-matt-photos"}, {Name: "not-a-bucket"},
+		{Name: "test-matt-archive", Provider: "s3"}}, tester)
+	is.Equal([]string{
+		"Credentials can change bucket test-matt-photos: storage.objects.create, storage.objects.delete",
+		"Unable to check permissions on bucket not-a-bucket: Bucket not-a-bucket not found",
+	}, actual)
+}
+
+func TestWritePermissionWarnings(t *testing.T) {
+	is := assert.New(t)
+	var actual bytes.Buffer
+	writePermissionWarnings(&actual, nil, false)
+	is.Empty(actual.String(), "Should print nothing for least privilege credentials")
+
+	writePermissionWarnings(&actual, []string{"Credentials can change bucket test-matt-photos: storage.objects.delete"}, true)
+	is.Contains(actual.String(), "WARNING")
+	is.Contains(actual.String(), "  Credentials can change bucket test-matt-photos: storage.objects.delete\n")
+	is.Contains(actual.String(), "roles/storage.objectViewer")
+	is.Contains(actual.String(), "Read only mode is on")
+	is.Contains(actual.String(), "these credentials have more access")
+
+	actual.Reset()
+	writePermissionWarnings(&actual, []string{"Unable to check permissions on bucket test-matt-photos: forbidden"}, false)
+	is.Contains(actual.String(), "unable to check whether these credentials have more access",
+		"Should not say the credentials have too much access when none was found")
+	is.NotContains(actual.String(), "Read only mode is on")
+
+	actual.Reset()
+	writePermissionWarnings(&actual, []string{"Unable to check permissions on bucket test-matt-media: forbidden",
+		"Credentials can change bucket test-matt-photos: storage.objects.delete"}, false)
+	is.Contains(actual.String(), "WARNING: these credentials have more access")
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// PlannedFile is a single object selected for download, along with the details needed to review the selection.
+// Generation, CRC32C and LocalPath pin down exactly what the plan expects to download and where to, so changes can be caught before downloading.
+type PlannedFile struct {
+	BucketName   string    `json:"bucket"`
+	ObjectName   string    `json:"object"`
+	Size         int64     `json:"size"`
+	Created      time.Time `json:"created"`
+	StorageClass string    `json:"storage_class"`
+	Generation   int64     `json:"generation"`
+	CRC32C       uint32    `json:"crc32c"`
+	LocalPath    string    `json:"local_path"`
+	MD5          []byte    `json:"md5,omitempty"`
+}
+
+// Plan is the output of the plan command, the objects to download and what they looked like when the plan was made.
+// Files may be missing or only partly filled in for hand written plans.
+type Plan struct {
+	Buckets []BucketAndFiles `json:"buckets"`
+	Files   []PlannedFile    `json:"files"`
+}
+
+var planCsvHeader = []string{"bucket", "object", "size", "created", "storage_class", "generation", "crc32c", "local_path", "md5"}
+
+// getPlannedFileDetails looks up the attributes of every object in the mapping so the plan can be reviewed before downloading.
+func getPlannedFileDetails(ctx context.Context, client *storage.Client, config Config, mapping []BucketAndFiles) (files []PlannedFile, err error) {
+	for _, bucketAndFiles := range mapping {
+		bucket, err2 := openBackupStoreByName(ctx, client, config, bucketAndFiles.BucketName)
+		if err2 != nil {
+			return nil, err2
+		}
+		for _, objectName := range bucketAndFiles.Files {
+			attrs, err2 := bucket.Object(objectName).Attrs(ctx)
+			if err2 != nil {
+				err = errors.Annotatef(err2, "Unable to get details of %s in bucket %s", objectName, bucketAndFiles.BucketName)
+				return
+			}
+			files = append(files, PlannedFile{
+				BucketName:   bucketAndFiles.BucketName,
+				ObjectName:   objectName,
+				Size:         attrs.Size,
+				Created:      attrs.Created,
+				StorageClass: attrs.StorageClass,
+				Generation:   attrs.Generation,
+				CRC32C:       attrs.CRC32C,
+				LocalPath:    getLocalFilePath(config.FileDownloadLocation, bucketAndFiles.BucketName, objectName, config.SanitizeFileNames),
+				MD5:          attrs.MD5,
+			})
+		}
+	}
+	return
+}
+
+func writePlanCsv(w io.Writer, files []PlannedFile) error {
+	cw := csv.NewWriter(w)
+	cw.Write(planCsvHeader)
+	for _, file := range files {
+		cw.Write([]string{file.BucketName, file.ObjectName, strconv.FormatInt(file.Size, 10),
+			file.Created.UTC().Format(time.RFC3339), file.StorageClass, strconv.FormatInt(file.Generation, 10),
+			strconv.FormatUint(uint64(file.CRC32C), 10), file.LocalPath, hex.EncodeToString(file.MD5)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writePlanJson(w io.Writer, plan Plan) error {
+	jsonEncoder := json.NewEncoder(w)
+	return jsonEncoder.Encode(plan)
+}
+
+// readPlanCsv loads a plan written by writePlanCsv, possibly pruned by hand in a spreadsheet.
+// Only the bucket and object columns are required, everything else is optional and only checked when present.
+// Files are grouped by bucket in the order each bucket first appears.
+func readPlanCsv(r io.Reader) (plan Plan, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		err = errors.Annotate(err, "Unable to parse plan csv")
+		return
+	}
+	if len(records) == 0 {
+		err = errors.NotValidf("Plan csv is empty, expected a header row")
+		return
+	}
+	columns := make(map[string]int)
+	for i, heading := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(heading))] = i
+	}
+	bucketCol, hasBucket := columns["bucket"]
+	objectCol, hasObject := columns["object"]
+	if !hasBucket || !hasObject {
+		err = errors.NotValidf("Plan csv header %v, must have bucket and object columns", records[0])
+		return
+	}
+	//optional columns that are missing or blank on a row are left as zero values
+	getCell := func(record []string, heading string) string {
+		i, found := columns[heading]
+		if !found || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	bucketIndexes := make(map[string]int)
+	for line, record := range records[1:] {
+		if len(record) <= bucketCol || len(record) <= objectCol {
+			err = errors.NotValidf("Plan csv line %d is missing the bucket or object", line+2)
+			return
+		}
+		file := PlannedFile{BucketName: record[bucketCol], ObjectName: record[objectCol],
+			StorageClass: getCell(record, "storage_class"), LocalPath: getCell(record, "local_path")}
+		if len(file.BucketName) == 0 || len(file.ObjectName) == 0 {
+			err = errors.NotValidf("Plan csv line %d has a blank bucket or object", line+2)
+			return
+		}
+		err = parsePlanCsvNumbers(&file, getCell(record, "size"), getCell(record, "generation"), getCell(record, "crc32c"),
+			getCell(record, "md5"))
+		if err != nil {
+			err = errors.Annotatef(err, "Plan csv line %d", line+2)
+			return
+		}
+		plan.Files = append(plan.Files, file)
+
+		i, found := bucketIndexes[file.BucketName]
+		if !found {
+			i = len(plan.Buckets)
+			bucketIndexes[file.BucketName] = i
+			plan.Buckets = append(plan.Buckets, BucketAndFiles{BucketName: file.BucketName})
+		}
+		plan.Buckets[i].Files = append(plan.Buckets[i].Files, file.ObjectName)
+	}
+	return
+}
+
+func parsePlanCsvNumbers(file *PlannedFile, size, generation, crc, md5 string) (err error) {
+	if len(size) > 0 {
+		file.Size, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return errors.NotValidf("Size %q", size)
+		}
+	}
+	if len(generation) > 0 {
+		file.Generation, err = strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return errors.NotValidf("Generation %q", generation)
+		}
+	}
+	if len(crc) > 0 {
+		var parsed uint64
+		parsed, err = strconv.ParseUint(crc, 10, 32)
+		if err != nil {
+			return errors.NotValidf("CRC32C %q", crc)
+		}
+		file.CRC32C = uint32(parsed)
+	}
+	if len(md5) > 0 {
+		file.MD5, err = hex.DecodeString(md5)
+		if err != nil || len(file.MD5) != 16 {
+			return errors.NotValidf("MD5 %q", md5)
+		}
+	}
+	return nil
+}
+
+// loadPlanFile loads a plan from a csv file written by writePlanCsv, or from a json file written by writePlanJson.
+// Json files in the same format as the in progress file work too, they just have no file details to check.
+// The format is chosen by the file extension.
+func loadPlanFile(filePath string) (plan Plan, err error) {
+	if !strings.EqualFold(filepath.Ext(filePath), ".csv") {
+		var state InProgressState
+		state, err = loadInProgressFile(filePath)
+		if err != nil {
+			return
+		}
+		plan.Buckets = state.Buckets
+		contents, _ := os.ReadFile(filePath)
+		if trimmed := bytes.TrimSpace(contents); len(trimmed) > 0 && trimmed[0] == '{' {
+			err = json.Unmarshal(contents, &plan)
+		}
+		return
+	}
+	planFile, err := os.Open(filePath)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to open plan file at %s", filePath)
+		return
+	}
+	defer planFile.Close()
+	return readPlanCsv(planFile)
+}
+
+// validatePlan checks a possibly hand written plan is well formed before anything is downloaded.
+func validatePlan(mapping []BucketAndFiles) error {
+	if len(mapping) == 0 {
+		return errors.NotValidf("Plan has no buckets")
+	}
+	seenBuckets := make(map[string]bool)
+	for i, bucketAndFiles := range mapping {
+		if len(bucketAndFiles.BucketName) == 0 {
+			return errors.NotValidf("Plan entry %d has a blank bucket name", i+1)
+		}
+		if seenBuckets[bucketAndFiles.BucketName] {
+			return errors.NotValidf("Plan lists bucket %s more than once", bucketAndFiles.BucketName)
+		}
+		seenBuckets[bucketAndFiles.BucketName] = true
+		seenFiles := make(map[string]bool)
+		for _, file := range bucketAndFiles.Files {
+			if len(file) == 0 {
+				return errors.NotValidf("Plan has a blank object name in bucket %s", bucketAndFiles.BucketName)
+			}
+			if seenFiles[file] {
+				return errors.NotValidf("Plan lists %s more than once in bucket %s", file, bucketAndFiles.BucketName)
+			}
+			seenFiles[file] = true
+		}
+	}
+	return nil
+}
+
+// validatePlanAgainstBuckets makes sure every bucket and object in the plan still exists and matches what was planned,
+// so a typo in a hand curated plan or a bucket that changed since planning is reported up front instead of partway through the downloads.
+// With force, differences are only printed and objects that no longer exist are left out of the returned mapping.
+func validatePlanAgainstBuckets(ctx context.Context, client *storage.Client, config Config, plan Plan, force bool) (mapping []BucketAndFiles, err error) {
+	planned := make(map[string]PlannedFile)
+	for _, file := range plan.Files {
+		planned[path.Join(file.BucketName, file.ObjectName)] = file
+	}
+
+	var missing, changed []string
+	for _, bucketAndFiles := range plan.Buckets {
+		bucket, err := openBackupStoreByName(ctx, client, config, bucketAndFiles.BucketName)
+		if err != nil {
+			return nil, err
+		}
+		_, err = bucket.Attrs(ctx)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to find bucket %s from plan", bucketAndFiles.BucketName)
+		}
+		remaining := BucketAndFiles{BucketName: bucketAndFiles.BucketName}
+		for _, file := range bucketAndFiles.Files {
+			key := path.Join(bucketAndFiles.BucketName, file)
+			attrs, err2 := bucket.Object(file).Attrs(ctx)
+			if err2 == storage.ErrObjectNotExist {
+				missing = append(missing, key)
+				continue
+			}
+			if err2 != nil {
+				return nil, errors.Annotatef(err2, "Unable to check %s in bucket %s from plan", file, bucketAndFiles.BucketName)
+			}
+			remaining.Files = append(remaining.Files, file)
+			localPath := getLocalFilePath(config.FileDownloadLocation, bucketAndFiles.BucketName, file, config.SanitizeFileNames)
+			for _, difference := range getPlannedFileDifferences(planned[key], attrs, localPath) {
+				changed = append(changed, fmt.Sprintf("%s %s", key, difference))
+			}
+		}
+		mapping = append(mapping, remaining)
+	}
+
+	if len(missing) == 0 && len(changed) == 0 {
+		return
+	}
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("missing %s", strings.Join(missing, ", ")))
+	}
+	if len(changed) > 0 {
+		problems = append(problems, fmt.Sprintf("changed %s", strings.Join(changed, "; ")))
+	}
+	if force {
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Warning: buckets no longer match the plan, downloading anyway: %s.", strings.Join(problems, ", ")))
+		return
+	}
+	return nil, errors.NotValidf("Plan against the live buckets, %s", strings.Join(problems, ", "))
+}
+
+// getPlannedFileDifferences lists how an object, and where it would be downloaded to, differ from the plan.
+// Details the plan doesn't have, like in a hand written plan, are not compared.
+func getPlannedFileDifferences(planned PlannedFile, attrs *storage.ObjectAttrs, localPath string) (differences []string) {
+	if planned.Generation != 0 && planned.Generation != attrs.Generation {
+		differences = append(differences, fmt.Sprintf("generation was %d now %d", planned.Generation, attrs.Generation))
+	}
+	if (planned.Size != 0 || planned.Generation != 0) && planned.Size != attrs.Size {
+		differences = append(differences, fmt.Sprintf("size was %d now %d", planned.Size, attrs.Size))
+	}
+	if planned.CRC32C != 0 && planned.CRC32C != attrs.CRC32C {
+		differences = append(differences, fmt.Sprintf("crc32c was %d now %d", planned.CRC32C, attrs.CRC32C))
+	}
+	//s3 objects don't have a crc32c, so the md5 of their etag is all there is to tell a rewritten one by
+	if planned.CRC32C == 0 && len(planned.MD5) > 0 && !bytes.Equal(planned.MD5, attrs.MD5) {
+		differences = append(differences, fmt.Sprintf("md5 was %x now %x", planned.MD5, attrs.MD5))
+	}
+	if len(planned.LocalPath) > 0 && filepath.Clean(planned.LocalPath) != filepath.Clean(localPath) {
+		differences = append(differences, fmt.Sprintf("local path was %s now %s", planned.LocalPath, localPath))
+	}
+	return
+}
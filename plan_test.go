@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPlannedFiles = []PlannedFile{
+	{"test-matt-photos", "2015-02/IMG_02.gif", 35, testPlanCreated, "STANDARD", 1516410123000001, 1234567890,
+		"downloads/test-matt-photos/2015/IMG_02.gif", nil},
+	{"test-matt-server-backups", "newest.txt", 12, testPlanCreated, "NEARLINE", 1516410123000002, 42,
+		"downloads/test-matt-server-backups/newest.txt", nil},
+	{"test-matt-photos", "2016-10/IMG_10.gif", 35, testPlanCreated, "STANDARD", 1516410123000003, 4294967295,
+		"downloads/test-matt-photos/2016/IMG_10.gif", testPlanMD5},
+}
+
+var testPlanCreated = time.Date(2018, 1, 20, 1, 2, 3, 0, time.UTC)
+
+var testPlanMD5 = []byte{0x9e, 0x10, 0x7d, 0x9d, 0x37, 0x2b, 0xb6, 0x82, 0x6b, 0xd8, 0x1d, 0x35, 0x42, 0xa4, 0x19, 0xd6}
+
+func TestWritePlanCsv(t *testing.T) {
+	is := assert.New(t)
+	expected, err := os.ReadFile(filepath.Join("testdata", "plan.csv"))
+	if err != nil {
+		t.Error("Could not load expected plan csv")
+	}
+
+	var actual bytes.Buffer
+	err = writePlanCsv(&actual, testPlannedFiles)
+	is.NoError(err, "Should not error when writing a plan")
+	is.Equal(string(expected), actual.String())
+}
+
+func TestWritePlanJson(t *testing.T) {
+	is := assert.New(t)
+	expected, err := os.ReadFile(filepath.Join("testdata", "plan.json"))
+	if err != nil {
+		t.Error("Could not load expected plan json")
+	}
+
+	var actual bytes.Buffer
+	err = writePlanJson(&actual, Plan{
+		Buckets: []BucketAndFiles{{"test-matt-server-backups", []string{"newest.txt"}}},
+		Files:   testPlannedFiles[1:2],
+	})
+	is.NoError(err, "Should not error when writing a plan")
+	is.Equal(string(expected), actual.String())
+}
+
+func TestReadPlanCsv(t *testing.T) {
+	is := assert.New(t)
+	planFile, err := os.Open(filepath.Join("testdata", "plan.csv"))
+	if err != nil {
+		t.Error("Could not open test plan csv")
+	}
+	defer planFile.Close()
+
+	expected := []BucketAndFiles{
+		{"test-matt-photos", []string{"2015-02/IMG_02.gif", "2016-10/IMG_10.gif"}},
+		{"test-matt-server-backups", []string{"newest.txt"}},
+	}
+	actual, err := readPlanCsv(planFile)
+	is.NoError(err, "Should not error when reading a good plan")
+	is.Equal(expected, actual.Buckets)
+	//created time is informational only
+	for i := range actual.Files {
+		actual.Files[i].Created = testPlanCreated
+	}
+	is.Equal(testPlannedFiles, actual.Files)
+
+	//columns can be reordered or dropped, as long as bucket and object remain
+	actual, err = readPlanCsv(strings.NewReader("object,Bucket\nnewest.txt,test-matt-server-backups\n"))
+	is.NoError(err, "Should not error when reading a pruned plan")
+	is.Equal([]BucketAndFiles{{"test-matt-server-backups", []string{"newest.txt"}}}, actual.Buckets)
+	is.Equal([]PlannedFile{{BucketName: "test-matt-server-backups", ObjectName: "newest.txt"}}, actual.Files)
+
+	_, err = readPlanCsv(strings.NewReader(""))
+	is.Error(err, "Should error on an empty plan")
+
+	_, err = readPlanCsv(strings.NewReader("bucket,size\ntest-matt-photos,35\n"))
+	is.Error(err, "Should error when the object column is missing")
+
+	_, err = readPlanCsv(strings.NewReader("bucket,object\ntest-matt-photos,\n"))
+	is.Error(err, "Should error when a row has a blank object")
+
+	_, err = readPlanCsv(strings.NewReader("bucket,object\ntest-matt-photos\n"))
+	is.Error(err, "Should error when a row is too short")
+
+	_, err = readPlanCsv(strings.NewReader("bucket,object,generation\ntest-matt-photos,a.gif,latest\n"))
+	is.Error(err, "Should error when a number column isn't a number")
+
+	_, err = readPlanCsv(strings.NewReader("bucket,object,md5\ntest-matt-photos,a.gif,9e107d9d\n"))
+	is.Error(err, "Should error when the md5 column isn't a whole md5")
+}
+
+func TestLoadPlanFile(t *testing.T) {
+	is := assert.New(t)
+	csvPlan, err := loadPlanFile(filepath.Join("testdata", "plan.csv"))
+	is.NoError(err, "Should not error when loading a csv plan")
+	is.Equal(2, len(csvPlan.Buckets))
+	is.Equal(3, len(csvPlan.Files))
+
+	jsonPlan, err := loadPlanFile(filepath.Join("testdata", "plan.json"))
+	is.NoError(err, "Should not error when loading a json plan")
+	is.Equal(1, len(jsonPlan.Buckets))
+	is.Equal(testPlannedFiles[1:2], jsonPlan.Files)
+
+	jsonPlan, err = loadPlanFile(filepath.Join("testdata", "inProgressData.json"))
+	is.NoError(err, "Should not error when loading an in progress file as a plan")
+	is.Equal(2, len(jsonPlan.Buckets))
+	is.Empty(jsonPlan.Files)
+
+	legacyPlan, err := loadPlanFile(filepath.Join("testdata", "inProgressDataLegacy.json"))
+	is.NoError(err, "Should not error when loading a legacy in progress file as a plan")
+	is.Equal(2, len(legacyPlan.Buckets))
+
+	_, err = loadPlanFile(filepath.Join("testdata", "doesNotExist.csv"))
+	is.Error(err, "Should error when the csv plan doesn't exist")
+
+	_, err = loadPlanFile(filepath.Join("testdata", "parseErrorConfig.json"))
+	is.Error(err, "Should error when the json plan cannot be parsed")
+}
+
+func TestGetPlannedFileDifferences(t *testing.T) {
+	is := assert.New(t)
+	planned := testPlannedFiles[1]
+	attrs := &storage.ObjectAttrs{Name: "newest.txt", Size: 12, Generation: 1516410123000002, CRC32C: 42}
+	localPath := filepath.FromSlash("downloads/test-matt-server-backups/newest.txt")
+	is.Empty(getPlannedFileDifferences(planned, attrs, localPath), "Should not differ when nothing changed")
+
+	changed := &storage.ObjectAttrs{Name: "newest.txt", Size: 14, Generation: 1516410123000009, CRC32C: 43}
+	is.Equal([]string{
+		"generation was 1516410123000002 now 1516410123000009",
+		"size was 12 now 14",
+		"crc32c was 42 now 43",
+		"local path was downloads/test-matt-server-backups/newest.txt now elsewhere/newest.txt",
+	}, getPlannedFileDifferences(planned, changed, "elsewhere/newest.txt"))
+
+	is.Empty(getPlannedFileDifferences(PlannedFile{BucketName: "test-matt-server-backups", ObjectName: "newest.txt"}, changed, "elsewhere"),
+		"Should not compare details a hand written plan doesn't have")
+
+	//s3 objects have no generation or crc32c
+	s3Planned := PlannedFile{BucketName: "test-matt-server-backups", ObjectName: "newest.txt", Size: 12, MD5: testPlanMD5}
+	is.Empty(getPlannedFileDifferences(s3Planned, &storage.ObjectAttrs{Name: "newest.txt", Size: 12, MD5: testPlanMD5}, ""),
+		"Should not differ when an s3 object is unchanged")
+	is.Equal([]string{
+		"size was 12 now 14",
+		"md5 was 9e107d9d372bb6826bd81d3542a419d6 now d41d8cd98f00b204e9800998ecf8427e",
+	}, getPlannedFileDifferences(s3Planned, &storage.ObjectAttrs{Name: "newest.txt", Size: 14,
+		MD5: []byte{0xd4, 0x1d, 0x8c, 0xd9, 0x8f, 0x00, 0xb2, 0x04, 0xe9, 0x80, 0x09, 0x98, 0xec, 0xf8, 0x42, 0x7e}}, ""),
+		"Should compare the size and md5 of an s3 object")
+}
+
+func TestGetLocalFilePath(t *testing.T) {
+	is := assert.New(t)
+	is.Equal(filepath.Join("downloads", "test-matt-photos", "2015", "IMG_02.gif"),
+		getLocalFilePath("downloads", "test-matt-photos", "2015-02/IMG_02.gif", false), "Should put photos in a folder per year")
+	is.Equal(filepath.Join("downloads", "test-matt-media", "show 1", "episode.ogv"),
+		getLocalFilePath("downloads", "test-matt-media", "show 1/episode.ogv", false))
+	is.Equal(filepath.Join("downloads", "test-matt-server-backups", "backup-2018-01-01T01%3A02%3A03.tar.gz"),
+		getLocalFilePath("downloads", "test-matt-server-backups", "backup-2018-01-01T01:02:03.tar.gz", true), "Should sanitize when asked")
+	is.Equal(filepath.Join("downloads", "test-matt-photos", "2015", "IMG%3F.gif"),
+		getLocalFilePath("downloads", "test-matt-photos", "2015-02/IMG?.gif", true), "Should sanitize photos too")
+}
+
+var testValidatePlanCases = []struct {
+	mapping []BucketAndFiles
+	valid   bool
+}{
+	{[]BucketAndFiles{{"test-matt-photos", []string{"2015-02/IMG_02.gif"}}}, true},
+	//buckets with nothing to download are fine, they were pruned by hand
+	{[]BucketAndFiles{{"test-matt-photos", nil}}, true},
+	{nil, false},
+	{[]BucketAndFiles{{"", []string{"2015-02/IMG_02.gif"}}}, false},
+	{[]BucketAndFiles{{"test-matt-photos", []string{""}}}, false},
+	{[]BucketAndFiles{{"test-matt-photos", []string{"a.gif", "a.gif"}}}, false},
+	{[]BucketAndFiles{{"test-matt-photos", []string{"a.gif"}}, {"test-matt-photos", []string{"b.gif"}}}, false},
+}
+
+func TestValidatePlan(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testValidatePlanCases {
+		err := validatePlan(tc.mapping)
+		if tc.valid {
+			is.NoError(err, "Should not error on valid plan %v", tc.mapping)
+		} else {
+			is.Error(err, "Should error on invalid plan %v", tc.mapping)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// defaultProbeBytes is how much of the start and end of an object a probe reads when probe_bytes isn't set,
+// and defaultProbeRanges how many ranges of that length from the middle of a backup when probe_ranges isn't.
+const (
+	defaultProbeBytes  = 4 << 20
+	defaultProbeRanges = 4
+)
+
+// probeFormats check the structure of the media files and backups a probe understands, by extension.
+var probeFormats = map[string]func(r *probeReader) error{
+	".gz":   checkGzip,
+	".tgz":  checkGzip,
+	".mkv":  checkMatroska,
+	".webm": checkMatroska,
+	".mp4":  checkMP4,
+	".m4v":  checkMP4,
+	".mov":  checkMP4,
+}
+
+// isProbed says whether a sampled object is probed instead of downloaded, other objects in a probing bucket are downloaded as usual.
+func isProbed(bucketConfig BucketToProcess, objectName string) bool {
+	_, found := probeFormats[strings.ToLower(path.Ext(objectName))]
+	return bucketConfig.Probe && found
+}
+
+func getProbeBytes(bucketConfig BucketToProcess) int64 {
+	if bucketConfig.ProbeBytes > 0 {
+		return bucketConfig.ProbeBytes
+	}
+	return defaultProbeBytes
+}
+
+func getProbeRanges(bucketConfig BucketToProcess) int {
+	if bucketConfig.ProbeRanges > 0 {
+		return bucketConfig.ProbeRanges
+	}
+	return defaultProbeRanges
+}
+
+// probeReader serves reads of an object from its head and tail, fetching any other range it's asked for.
+// ranges is how many ranges from the middle checks that sample the object should read.
+type probeReader struct {
+	size   int64
+	head   []byte
+	tail   []byte
+	ranges int
+	fetch  func(offset, length int64) ([]byte, error)
+}
+
+func (r *probeReader) readAt(offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 || offset+length > r.size {
+		return nil, errors.NotValidf("Read of %d bytes at byte %d of a %d byte file", length, offset, r.size)
+	}
+	if offset+length <= int64(len(r.head)) {
+		return r.head[offset : offset+length], nil
+	}
+	if tailStart := r.size - int64(len(r.tail)); offset >= tailStart {
+		return r.tail[offset-tailStart : offset-tailStart+length], nil
+	}
+	return r.fetch(offset, length)
+}
+
+// probeObject range reads the first and last probeBytes of a media file or backup and checks its headers and structure,
+// which catches truncated or damaged uploads for a fraction of the egress of downloading the whole file.
+// Nothing is saved locally, so it can't be checked against companions, signatures or hooks.
+func probeObject(ctx context.Context, bucket BackupStore, remoteFile string, probeBytes int64, ranges int) (err error) {
+	obj := bucket.Object(remoteFile)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return getObjectAttrsError(err, remoteFile)
+	}
+	//read the generation the attributes came from, in case the object is replaced mid probe
+	obj = obj.Generation(attrs.Generation)
+	fetch := func(offset, length int64) (data []byte, err error) {
+		rc, err := obj.NewRangeReader(ctx, offset, length)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to read %d bytes at byte %d of %s", length, offset, remoteFile)
+		}
+		defer rc.Close()
+		data, err = io.ReadAll(rc)
+		countBytesDownloaded(ctx, int64(len(data)))
+		return data, errors.Annotatef(err, "Error reading %d bytes at byte %d of %s", length, offset, remoteFile)
+	}
+
+	r := &probeReader{size: attrs.Size, ranges: ranges, fetch: fetch}
+	r.head, err = fetch(0, min(probeBytes, attrs.Size))
+	if err != nil {
+		return
+	}
+	if attrs.Size > probeBytes {
+		tailLength := min(probeBytes, attrs.Size-probeBytes)
+		r.tail, err = fetch(attrs.Size-tailLength, tailLength)
+		if err != nil {
+			return
+		}
+	}
+	check := probeFormats[strings.ToLower(path.Ext(remoteFile))]
+	if check == nil {
+		return errors.NotSupportedf("Probing %s", remoteFile)
+	}
+	return errors.Annotatef(check(r), "Probe of %s failed", remoteFile)
+}
+
+var matroskaMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+var matroskaSegmentID = []byte{0x18, 0x53, 0x80, 0x67}
+
+// checkMatroska checks the file starts with an EBML header followed by a segment that fits in the file.
+// Segments written by live recordings have an unknown size, those can only be checked for the header.
+func checkMatroska(r *probeReader) error {
+	magic, err := r.readAt(0, int64(len(matroskaMagic)))
+	if err != nil || string(magic) != string(matroskaMagic) {
+		return errors.NotValidf("Matroska file without an EBML header")
+	}
+	headerSize, sizeLength, _, err := readEBMLSize(r, int64(len(matroskaMagic)))
+	if err != nil {
+		return errors.Annotate(err, "Unable to read EBML header size")
+	}
+	segmentOffset := int64(len(matroskaMagic)) + sizeLength + headerSize
+	id, err := r.readAt(segmentOffset, int64(len(matroskaSegmentID)))
+	if err != nil || string(id) != string(matroskaSegmentID) {
+		return errors.NotValidf("Matroska file without a segment after its EBML header at byte %d", segmentOffset)
+	}
+	segmentSize, sizeLength, unknown, err := readEBMLSize(r, segmentOffset+int64(len(matroskaSegmentID)))
+	if err != nil {
+		return errors.Annotate(err, "Unable to read segment size")
+	}
+	if unknown {
+		return nil
+	}
+	segmentEnd := segmentOffset + int64(len(matroskaSegmentID)) + sizeLength + segmentSize
+	if segmentEnd > r.size {
+		return errors.NotValidf("Matroska segment ending at byte %d of a %d byte file, it was truncated", segmentEnd, r.size)
+	}
+	return nil
+}
+
+// readEBMLSize reads the variable length size at offset, where the number of leading zero bits of the first byte
+// says how many more bytes follow, and a size with every value bit set means unknown.
+func readEBMLSize(r *probeReader, offset int64) (size int64, length int64, unknown bool, err error) {
+	first, err := r.readAt(offset, 1)
+	if err != nil {
+		return
+	}
+	if first[0] == 0 {
+		err = errors.NotValidf("EBML size at byte %d", offset)
+		return
+	}
+	for length = 1; first[0]&(0x80>>(length-1)) == 0; length++ {
+	}
+	data, err := r.readAt(offset, length)
+	if err != nil {
+		return
+	}
+	value := uint64(data[0] & (0xFF >> length))
+	for _, b := range data[1:] {
+		value = value<<8 | uint64(b)
+	}
+	unknown = value == 1<<(7*length)-1
+	return int64(value), length, unknown, nil
+}
+
+// mp4FirstBoxes are the boxes an MP4 or QuickTime file can start with.
+var mp4FirstBoxes = map[string]bool{"ftyp": true, "styp": true, "moov": true, "mdat": true, "free": true, "skip": true, "wide": true, "pnot": true}
+
+// checkMP4 walks the top level boxes, checking none runs past the end of the file and that there is a moov box.
+// Boxes in the middle of the file cost a tiny range read each, a file has a handful unless it is fragmented.
+func checkMP4(r *probeReader) error {
+	sawMoov := false
+	for offset := int64(0); offset < r.size; {
+		if r.size-offset < 8 {
+			return errors.NotValidf("%d stray bytes after the last box", r.size-offset)
+		}
+		header, err := r.readAt(offset, 8)
+		if err != nil {
+			return err
+		}
+		boxSize, boxType, headerSize := int64(binary.BigEndian.Uint32(header[:4])), string(header[4:8]), int64(8)
+		switch boxSize {
+		case 0:
+			//the last box can run to the end of the file
+			boxSize = r.size - offset
+		case 1:
+			largeSize, err := r.readAt(offset+8, 8)
+			if err != nil {
+				return errors.Annotatef(err, "Unable to read size of box %q at byte %d", boxType, offset)
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(largeSize)), 16
+		}
+		if offset == 0 && !mp4FirstBoxes[boxType] {
+			return errors.NotValidf("MP4 file starting with a %q box", boxType)
+		}
+		if !isBoxType(boxType) || boxSize < headerSize {
+			return errors.NotValidf("Box %q of %d bytes at byte %d", boxType, boxSize, offset)
+		}
+		if boxSize > r.size-offset {
+			return errors.NotValidf("Box %q at byte %d running %d bytes past the end of the file, it was truncated",
+				boxType, offset, offset+boxSize-r.size)
+		}
+		sawMoov = sawMoov || boxType == "moov"
+		offset += boxSize
+	}
+	if !sawMoov {
+		return errors.NotValidf("MP4 file without a moov box")
+	}
+	return nil
+}
+
+func isBoxType(boxType string) bool {
+	for _, c := range []byte(boxType) {
+		if c < 0x20 || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// checkGzip checks a gzip backup has a valid header and that its start decompresses, then that ranges from the middle
+// and the end, which holds the trailer, aren't zeroed out. Compressed data is never long runs of zeros, zeroed ranges
+// are what's left of a failed or preallocated write. It's a cheap screen, only a full download checks every byte.
+func checkGzip(r *probeReader) error {
+	zr, err := gzip.NewReader(bytes.NewReader(r.head))
+	if err != nil {
+		return errors.NotValidf("Gzip header, %v", err)
+	}
+	zr.Multistream(false)
+	_, err = io.Copy(io.Discard, zr)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return errors.NotValidf("Compressed data at the start of the file, %v", err)
+	}
+
+	length := int64(len(r.head))
+	middle := r.size - 2*length
+	for i := 0; i < r.ranges && middle > 0; i++ {
+		offset := length + rand.Int63n(middle)
+		data, err := r.readAt(offset, min(length, r.size-length-offset))
+		if err != nil {
+			return err
+		}
+		if isZeroed(data) {
+			return errors.NotValidf("Gzip file zeroed out from byte %d to %d", offset, offset+int64(len(data)))
+		}
+	}
+	if len(r.tail) > 0 && isZeroed(r.tail) {
+		return errors.NotValidf("Gzip file zeroed out in the last %d bytes", len(r.tail))
+	}
+	return nil
+}
+
+// isZeroed says whether data has a run of zeros longer than any compressed data would.
+func isZeroed(data []byte) bool {
+	const zeroRun = 4096
+	run := 0
+	for _, b := range data {
+		if b != 0 {
+			run = 0
+			continue
+		}
+		run++
+		if run >= zeroRun {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestProbeReader probes data as probeObject would, counting the bytes fetched outside the head and tail.
+func newTestProbeReader(data []byte, probeBytes int64, fetched *int64) *probeReader {
+	size := int64(len(data))
+	r := &probeReader{size: size, head: data[:min(probeBytes, size)]}
+	if size > probeBytes {
+		r.tail = data[size-min(probeBytes, size-probeBytes):]
+	}
+	r.fetch = func(offset, length int64) ([]byte, error) {
+		*fetched += length
+		return data[offset : offset+length], nil
+	}
+	return r
+}
+
+func getTestMP4Box(boxType string, size int) []byte {
+	box := make([]byte, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], boxType)
+	return box
+}
+
+func getTestMP4(boxes ...[]byte) (file []byte) {
+	for _, box := range boxes {
+		file = append(file, box...)
+	}
+	return
+}
+
+func getTestMatroska(segmentSize byte, segmentData int) []byte {
+	file := append([]byte{}, matroskaMagic...)
+	//a 3 byte EBML header
+	file = append(file, 0x83, 0x42, 0x86, 0x81)
+	file = append(file, matroskaSegmentID...)
+	file = append(file, segmentSize)
+	return append(file, make([]byte, segmentData)...)
+}
+
+// getTestGzip compresses random data, which like a real backup doesn't compress to runs of zeros.
+func getTestGzip(uncompressed int) []byte {
+	data := make([]byte, uncompressed)
+	rand.New(rand.NewSource(1)).Read(data)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func getTestZeroedGzip(from, to int) []byte {
+	file := getTestGzip(100000)
+	for i := from; i < min(to, len(file)); i++ {
+		file[i] = 0
+	}
+	return file
+}
+
+var testProbeCases = []struct {
+	description string
+	file        []byte
+	check       func(r *probeReader) error
+	valid       bool
+}{
+	{"complete mp4", getTestMP4(getTestMP4Box("ftyp", 24), getTestMP4Box("mdat", 4000), getTestMP4Box("moov", 500)), checkMP4, true},
+	{"mp4 with moov first", getTestMP4(getTestMP4Box("ftyp", 24), getTestMP4Box("moov", 500), getTestMP4Box("mdat", 4000)), checkMP4, true},
+	{"truncated mp4", getTestMP4(getTestMP4Box("ftyp", 24), getTestMP4Box("moov", 500), getTestMP4Box("mdat", 4000))[:3000], checkMP4, false},
+	{"mp4 without moov", getTestMP4(getTestMP4Box("ftyp", 24), getTestMP4Box("mdat", 4000)), checkMP4, false},
+	{"not an mp4", make([]byte, 4000), checkMP4, false},
+	{"mp4 with stray bytes", append(getTestMP4(getTestMP4Box("ftyp", 24), getTestMP4Box("moov", 500)), 0, 0, 0), checkMP4, false},
+	{"complete matroska", getTestMatroska(0x80|100, 100), checkMatroska, true},
+	{"truncated matroska", getTestMatroska(0x80|100, 60), checkMatroska, false},
+	{"matroska of unknown size", getTestMatroska(0xFF, 60), checkMatroska, true},
+	{"complete gzip", getTestGzip(100000), checkGzip, true},
+	{"gzip zeroed in the middle", getTestZeroedGzip(10000, 90000), checkGzip, false},
+	{"gzip zeroed at the end", getTestZeroedGzip(90000, 200000), checkGzip, false},
+	{"gzip with a damaged first block", getTestZeroedGzip(10, 15), checkGzip, false},
+	{"not a gzip", make([]byte, 4000), checkGzip, false},
+	{"not a matroska", getTestMP4(getTestMP4Box("ftyp", 24), getTestMP4Box("moov", 500)), checkMatroska, false},
+}
+
+func TestProbeChecks(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testProbeCases {
+		var fetched int64
+		r := newTestProbeReader(tc.file, 8192, &fetched)
+		r.ranges = defaultProbeRanges
+		err := tc.check(r)
+		if tc.valid {
+			is.NoError(err, tc.description)
+		} else {
+			is.True(errors.IsNotValid(err), "%s should not be valid, got %v", tc.description, err)
+		}
+	}
+}
+
+func TestCheckMP4ReadsOnlyBoxHeaders(t *testing.T) {
+	is := assert.New(t)
+	file := getTestMP4(getTestMP4Box("ftyp", 24), getTestMP4Box("moov", 500), getTestMP4Box("free", 100), getTestMP4Box("mdat", 100000))
+	var fetched int64
+	is.NoError(checkMP4(newTestProbeReader(file, 64, &fetched)))
+	is.Equal(int64(16), fetched, "Should only fetch the headers of boxes outside the head and tail")
+}
+
+func TestReadEBMLSize(t *testing.T) {
+	is := assert.New(t)
+	var fetched int64
+	r := newTestProbeReader([]byte{0x81, 0x40, 0x02, 0x1F, 0xFF, 0xFF, 0xFF, 0x00}, 8, &fetched)
+	size, length, unknown, err := readEBMLSize(r, 0)
+	is.NoError(err)
+	is.Equal([]interface{}{int64(1), int64(1), false}, []interface{}{size, length, unknown})
+	size, length, _, err = readEBMLSize(r, 1)
+	is.NoError(err)
+	is.Equal([]interface{}{int64(2), int64(2)}, []interface{}{size, length})
+	_, length, unknown, err = readEBMLSize(r, 3)
+	is.NoError(err)
+	is.Equal(int64(4), length)
+	is.True(unknown)
+	_, _, _, err = readEBMLSize(r, 7)
+	is.True(errors.IsNotValid(err))
+}
+
+var testIsProbedCases = []struct {
+	bucketConfig BucketToProcess
+	objectName   string
+	expected     bool
+}{
+	{BucketToProcess{Probe: true}, "show 1/season 1/01x01 episode.mkv", true},
+	{BucketToProcess{Probe: true}, "show 1/season 1/01x01 episode.MP4", true},
+	{BucketToProcess{Probe: true}, "show 1/season 1/01x01 episode.ogv", false},
+	{BucketToProcess{Probe: true}, "alpha/2026-03-10.tar.gz", true},
+	{BucketToProcess{}, "show 1/season 1/01x01 episode.mkv", false},
+}
+
+func TestIsProbed(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testIsProbedCases {
+		is.Equal(tc.expected, isProbed(tc.bucketConfig, tc.objectName), "Probing %s", tc.objectName)
+	}
+	is.Equal(int64(defaultProbeBytes), getProbeBytes(BucketToProcess{}))
+	is.Equal(int64(1024), getProbeBytes(BucketToProcess{ProbeBytes: 1024}))
+	is.Equal(defaultProbeRanges, getProbeRanges(BucketToProcess{}))
+	is.Equal(10, getProbeRanges(BucketToProcess{ProbeRanges: 10}))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// defaultRunProfiles cover the usual cadences, a profile of the same name in the config replaces the default.
+var defaultRunProfiles = map[string]RunProfile{
+	"shallow": {SkipDownloads: true},
+	"deep":    {FullDownloads: true},
+	"audit":   {ChecksumOnly: true, SampleMultiplier: 10},
+}
+
+// applyRunProfile adjusts the config for the named profile, looking in the config's profiles before the defaults.
+// A blank name leaves the config as it is.
+func applyRunProfile(config Config, name string) (Config, error) {
+	if len(name) == 0 {
+		return config, nil
+	}
+	profile, found := config.Profiles[name]
+	if !found {
+		profile, found = defaultRunProfiles[name]
+	}
+	if !found {
+		return config, errors.NotFoundf("Run profile %s, known profiles are %s", name, strings.Join(getRunProfileNames(config), ", "))
+	}
+	if profile.SkipDownloads && profile.ChecksumOnly {
+		return config, errors.NotValidf("Run profile %s, it can't both skip downloads and checksum them", name)
+	}
+	if profile.SampleMultiplier > 1 {
+		rules := &config.FilesToDownload
+		for _, count := range []*int{&rules.ServerBackups, &rules.EpisodesFromEachShow, &rules.PhotosFromThisMonth, &rules.PhotosFromEachYear} {
+			*count *= profile.SampleMultiplier
+		}
+	}
+	if profile.FullDownloads {
+		//copy the buckets, they are shared with the config the profile was applied to
+		config.Buckets = append([]BucketToProcess(nil), config.Buckets...)
+		for i := range config.Buckets {
+			config.Buckets[i].Probe = false
+		}
+	}
+	profile.Name = name
+	config.ActiveProfile = profile
+	return config, nil
+}
+
+// getEffectiveProfileName is the name of the run profile as far as what the run does goes, "" for no profile
+// and for one that leaves the config as it is, e.g. a "default": {} profile kept so every schedule passes --profile.
+func getEffectiveProfileName(config Config, name string) string {
+	profile, found := config.Profiles[name]
+	if !found {
+		profile, found = defaultRunProfiles[name]
+	}
+	if found && !profile.SkipDownloads && !profile.ChecksumOnly && !profile.FullDownloads && profile.SampleMultiplier <= 1 {
+		return ""
+	}
+	return name
+}
+
+func getRunProfileNames(config Config) (names []string) {
+	for name := range defaultRunProfiles {
+		names = append(names, name)
+	}
+	for name := range config.Profiles {
+		if _, found := defaultRunProfiles[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// checksumObject reads a sampled object straight from the bucket and checks its CRC32C, without saving it anywhere.
+func checksumObject(ctx context.Context, bucket BackupStore, remoteFile string) error {
+	attrs, err := bucket.Object(remoteFile).Attrs(ctx)
+	if err != nil {
+		return getObjectAttrsError(err, remoteFile)
+	}
+	err = verifyObjectVersionReadable(ctx, bucket, attrs)
+	if err == nil {
+		recordVerifiedObject(ctx, attrs)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testProfileConfig = Config{
+	FilesToDownload: FileDownloadRules{ServerBackups: 1, EpisodesFromEachShow: 2, PhotosFromThisMonth: 0, PhotosFromEachYear: 3},
+	Profiles: map[string]RunProfile{
+		"weekly": {SampleMultiplier: 2},
+		"deep":   {SampleMultiplier: 3},
+		"broken": {SkipDownloads: true, ChecksumOnly: true},
+	},
+}
+
+func TestApplyRunProfile(t *testing.T) {
+	is := assert.New(t)
+	actual, err := applyRunProfile(testProfileConfig, "")
+	is.NoError(err)
+	is.Equal(testProfileConfig, actual, "Should leave the config alone without a profile")
+
+	actual, err = applyRunProfile(testProfileConfig, "shallow")
+	is.NoError(err)
+	is.Equal(RunProfile{Name: "shallow", SkipDownloads: true}, actual.ActiveProfile, "Should fall back to the default profiles")
+	is.Equal(testProfileConfig.FilesToDownload, actual.FilesToDownload)
+
+	actual, err = applyRunProfile(testProfileConfig, "audit")
+	is.NoError(err)
+	is.True(actual.ActiveProfile.ChecksumOnly)
+	is.Equal(FileDownloadRules{ServerBackups: 10, EpisodesFromEachShow: 20, PhotosFromThisMonth: 0, PhotosFromEachYear: 30},
+		actual.FilesToDownload, "Should multiply the sample sizes")
+
+	actual, err = applyRunProfile(testProfileConfig, "deep")
+	is.NoError(err)
+	is.Equal(3, actual.FilesToDownload.ServerBackups, "Should prefer the config's profile over a default of the same name")
+
+	actual, err = applyRunProfile(testProfileConfig, "weekly")
+	is.NoError(err)
+	is.Equal("weekly", actual.ActiveProfile.Name)
+	is.Equal(4, actual.FilesToDownload.EpisodesFromEachShow)
+
+	probing := Config{Buckets: []BucketToProcess{{Name: "test-matt-server-backups", Probe: true}}}
+	actual, err = applyRunProfile(probing, "deep")
+	is.NoError(err)
+	is.False(actual.Buckets[0].Probe, "Should download in full what buckets would otherwise probe")
+	is.True(probing.Buckets[0].Probe, "Should not change the buckets of the config the profile was applied to")
+
+	_, err = applyRunProfile(testProfileConfig, "hourly")
+	is.True(errors.IsNotFound(err), "Should error on an unknown profile")
+	is.Contains(err.Error(), "audit, broken, deep, shallow, weekly", "Should list the known profiles")
+	_, err = applyRunProfile(testProfileConfig, "broken")
+	is.True(errors.IsNotValid(err), "Should error on a profile that contradicts itself")
+}
+
+func TestGetEffectiveProfileName(t *testing.T) {
+	is := assert.New(t)
+	config := Config{Profiles: map[string]RunProfile{"default": {}, "same": {SampleMultiplier: 1}, "weekly": {SampleMultiplier: 2}}}
+	is.Equal("", getEffectiveProfileName(config, ""))
+	is.Equal("", getEffectiveProfileName(config, "default"), "Should treat a profile that changes nothing as no profile")
+	is.Equal("", getEffectiveProfileName(config, "same"), "Should treat a multiplier of 1 as no multiplier")
+	is.Equal("weekly", getEffectiveProfileName(config, "weekly"))
+	is.Equal("shallow", getEffectiveProfileName(config, "shallow"), "Should look at the default profiles too")
+	is.Equal("removed", getEffectiveProfileName(config, "removed"), "Should keep the name of a profile no longer in the config")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+type downloadProgressKey struct{}
+
+// downloadProgressTracker adds the bytes downloaded by this process to those from earlier sessions of the same run,
+// so a resumed run reports progress and an ETA for the whole run.
+type downloadProgressTracker struct {
+	prior        DownloadProgress
+	sessionStart time.Time
+	sessionBytes int64
+	save         func(DownloadProgress) error
+}
+
+// newDownloadProgressTracker starts a session on top of prior. save is called with the updated progress after every file, it can be nil.
+func newDownloadProgressTracker(prior DownloadProgress, now time.Time, save func(DownloadProgress) error) *downloadProgressTracker {
+	return &downloadProgressTracker{prior: prior, sessionStart: now, save: save}
+}
+
+func withDownloadProgress(ctx context.Context, tracker *downloadProgressTracker) context.Context {
+	if tracker == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, downloadProgressKey{}, tracker)
+}
+
+func downloadProgressFromContext(ctx context.Context) *downloadProgressTracker {
+	tracker, _ := ctx.Value(downloadProgressKey{}).(*downloadProgressTracker)
+	return tracker
+}
+
+func (tracker *downloadProgressTracker) addBytes(bytes int64) {
+	if tracker != nil {
+		tracker.sessionBytes += bytes
+	}
+}
+
+// current is the progress over every session, including this one up to now.
+func (tracker *downloadProgressTracker) current(now time.Time) DownloadProgress {
+	return DownloadProgress{
+		TotalBytes:      tracker.prior.TotalBytes,
+		BytesDownloaded: tracker.prior.BytesDownloaded + tracker.sessionBytes,
+		ElapsedSeconds:  tracker.prior.ElapsedSeconds + now.Sub(tracker.sessionStart).Seconds(),
+	}
+}
+
+// estimateRemaining works out how long the rest of the run should take at the average speed so far.
+// ok is false when there is nothing to base an estimate on.
+func (progress DownloadProgress) estimateRemaining() (remaining time.Duration, ok bool) {
+	if progress.BytesDownloaded <= 0 || progress.ElapsedSeconds <= 0 || progress.TotalBytes <= 0 {
+		return 0, false
+	}
+	left := progress.TotalBytes - progress.BytesDownloaded
+	if left < 0 {
+		left = 0
+	}
+	bytesPerSecond := float64(progress.BytesDownloaded) / progress.ElapsedSeconds
+	return time.Duration(float64(left) / bytesPerSecond * float64(time.Second)), true
+}
+
+// describe formats progress for the console, e.g. "1.5 GiB of 3.0 GiB (50%) downloaded in 10m0s, about 10m0s left".
+func (progress DownloadProgress) describe() string {
+	elapsed := time.Duration(progress.ElapsedSeconds * float64(time.Second)).Round(time.Second)
+	if progress.TotalBytes <= 0 {
+		return fmt.Sprintf("%s downloaded in %s", formatBytes(progress.BytesDownloaded), elapsed)
+	}
+	description := fmt.Sprintf("%s of %s (%.0f%%) downloaded in %s", formatBytes(progress.BytesDownloaded),
+		formatBytes(progress.TotalBytes), percentOf(progress.BytesDownloaded, progress.TotalBytes), elapsed)
+	if remaining, ok := progress.estimateRemaining(); ok {
+		description += fmt.Sprintf(", about %s left", remaining.Round(time.Second))
+	}
+	return description
+}
+
+// reportDownloadProgress prints the run's overall progress and saves it, if there is a tracker on ctx.
+func reportDownloadProgress(ctx context.Context, w io.Writer, now time.Time) error {
+	tracker := downloadProgressFromContext(ctx)
+	if tracker == nil {
+		return nil
+	}
+	progress := tracker.current(now)
+	fmt.Fprintln(w, fmt.Sprintf("Run progress: %s.", progress.describe()))
+	if tracker.save == nil {
+		return nil
+	}
+	return tracker.save(progress)
+}
+
+// getTotalPlannedBytes adds up the size of every object in the mapping.
+func getTotalPlannedBytes(ctx context.Context, client *storage.Client, config Config, mapping []BucketAndFiles) (total int64, err error) {
+	files, err := getPlannedFileDetails(ctx, client, config, mapping)
+	if err != nil {
+		return 0, errors.Annotate(err, "Unable to total the size of the files to download")
+	}
+	for _, file := range files {
+		total += file.Size
+	}
+	return
+}
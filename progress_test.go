@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadProgressTrackerCurrent(t *testing.T) {
+	is := assert.New(t)
+	start := time.Date(2018, 1, 20, 1, 0, 0, 0, time.UTC)
+	prior := DownloadProgress{TotalBytes: 1000, BytesDownloaded: 300, ElapsedSeconds: 60}
+	tracker := newDownloadProgressTracker(prior, start, nil)
+	ctx := withDownloadProgress(context.Background(), tracker)
+
+	countBytesDownloaded(ctx, 100)
+	countBytesDownloaded(ctx, 50)
+	actual := tracker.current(start.Add(30 * time.Second))
+	is.Equal(DownloadProgress{TotalBytes: 1000, BytesDownloaded: 450, ElapsedSeconds: 90}, actual,
+		"Should add this session on top of earlier sessions")
+
+	//no tracker on the context is fine
+	countBytesDownloaded(context.Background(), 100)
+	is.NoError(reportDownloadProgress(context.Background(), &bytes.Buffer{}, start))
+}
+
+var testEstimateRemainingCases = []struct {
+	progress DownloadProgress
+	expected time.Duration
+	ok       bool
+}{
+	{DownloadProgress{TotalBytes: 1000, BytesDownloaded: 250, ElapsedSeconds: 60}, 3 * time.Minute, true},
+	{DownloadProgress{TotalBytes: 1000, BytesDownloaded: 1000, ElapsedSeconds: 60}, 0, true},
+	{DownloadProgress{TotalBytes: 1000, BytesDownloaded: 0, ElapsedSeconds: 60}, 0, false},
+	{DownloadProgress{TotalBytes: 0, BytesDownloaded: 250, ElapsedSeconds: 60}, 0, false},
+	{DownloadProgress{TotalBytes: 1000, BytesDownloaded: 250, ElapsedSeconds: 0}, 0, false},
+}
+
+func TestEstimateRemaining(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testEstimateRemainingCases {
+		actual, ok := tc.progress.estimateRemaining()
+		is.Equal(tc.ok, ok, "Unexpected ok for %+v", tc.progress)
+		is.Equal(tc.expected, actual, "Unexpected estimate for %+v", tc.progress)
+	}
+}
+
+func TestDescribeDownloadProgress(t *testing.T) {
+	is := assert.New(t)
+	progress := DownloadProgress{TotalBytes: 4096, BytesDownloaded: 1024, ElapsedSeconds: 60}
+	is.Equal("1.0 KiB of 4.0 KiB (25%) downloaded in 1m0s, about 3m0s left", progress.describe())
+	progress.TotalBytes = 0
+	is.Equal("1.0 KiB downloaded in 1m0s", progress.describe())
+}
+
+func TestReportDownloadProgressSavesState(t *testing.T) {
+	is := assert.New(t)
+	start := time.Date(2018, 1, 20, 1, 0, 0, 0, time.UTC)
+	filePath := filepath.Join(t.TempDir(), inProgressFileName)
+	state := InProgressState{RunID: "20180120T010000Z-1a2b", Started: start,
+		Buckets:  []BucketAndFiles{{BucketName: "test-matt-media", Files: []string{"show 1/episode 1.mp4"}}},
+		Progress: DownloadProgress{TotalBytes: 2048}}
+	tracker := newDownloadProgressTracker(state.Progress, start, func(progress DownloadProgress) error {
+		state.Progress = progress
+		return saveInProgressFile(filePath, state)
+	})
+	ctx := withDownloadProgress(context.Background(), tracker)
+	countBytesDownloaded(ctx, 1024)
+
+	var out bytes.Buffer
+	is.NoError(reportDownloadProgress(ctx, &out, start.Add(10*time.Second)))
+	is.Contains(out.String(), "1.0 KiB of 2.0 KiB (50%)")
+
+	actual, err := loadInProgressFile(filePath)
+	is.NoError(err)
+	is.Equal(DownloadProgress{TotalBytes: 2048, BytesDownloaded: 1024, ElapsedSeconds: 10}, actual.Progress,
+		"Should persist progress so a resumed run can carry on from it")
+	is.Equal(state.Buckets, actual.Buckets)
+}
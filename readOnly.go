@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// envReadOnly turns on --read-only for every command, e.g. in a container pointed at production buckets.
+const envReadOnly = "VALIDATEBACKUPS_READ_ONLY"
+
+// storageHostSuffix matches the storage json and xml api hosts, including bucket subdomains.
+const storageHostSuffix = "storage.googleapis.com"
+
+// globalFlags are registered on every command's flags, see newCommandFlagSet.
+var globalFlags struct {
+	readOnly       bool
+	faultInjection *faultInjector
+}
+
+// readOnlyTransport refuses every request to cloud storage that could change it, before it leaves the machine.
+// Listing, attribute lookups, permission checks and downloads are all GET or HEAD requests, anything else is a write.
+// Requests to other hosts, like fetching tokens, go through untouched. endpointHost is the host of a pinned endpoint, if any,
+// which is checked like the storage hosts.
+type readOnlyTransport struct {
+	base         http.RoundTripper
+	endpointHost string
+}
+
+func (t readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isStorageWrite(req, t.endpointHost) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errors.Forbiddenf("%s %s in read only mode", req.Method, req.URL.Path)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func isStorageWrite(req *http.Request, endpointHost string) bool {
+	return isStorageHost(req, endpointHost) && req.Method != http.MethodGet && req.Method != http.MethodHead
+}
+
+// isStorageHost is whether the request goes to cloud storage, or to the pinned endpoint if there is one.
+func isStorageHost(req *http.Request, endpointHost string) bool {
+	host := strings.ToLower(req.URL.Hostname())
+	isEndpoint := len(endpointHost) > 0 && host == strings.ToLower(endpointHost)
+	return host == storageHostSuffix || strings.HasSuffix(host, "."+storageHostSuffix) || isEndpoint
+}
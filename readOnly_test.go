@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingTransport struct {
+	requests int
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+var testIsStorageWriteCases = []struct {
+	method       string
+	url          string
+	endpointHost string
+	write        bool
+}{
+	{http.MethodGet, "https://storage.googleapis.com/storage/v1/b/test-matt-media/o", "", false},
+	{http.MethodGet, "https://storage.googleapis.com/test-matt-media/show%201/episode.ogv", "", false},
+	{http.MethodHead, "https://test-matt-media.storage.googleapis.com/episode.ogv", "", false},
+	{http.MethodDelete, "https://storage.googleapis.com/storage/v1/b/test-matt-media/o/episode.ogv", "", true},
+	{http.MethodPost, "https://storage.googleapis.com/upload/storage/v1/b/test-matt-media/o?uploadType=multipart", "", true},
+	{http.MethodPatch, "https://STORAGE.googleapis.com/storage/v1/b/test-matt-media/o/episode.ogv", "", true},
+	{http.MethodPut, "https://test-matt-media.storage.googleapis.com/episode.ogv", "", true},
+	//getting a token isn't a storage write
+	{http.MethodPost, "https://oauth2.googleapis.com/token", "", false},
+	{http.MethodPost, "https://notstorage.googleapis.com/", "", false},
+	//a pinned endpoint is checked like the storage hosts
+	{http.MethodDelete, "https://storage-example.p.googleapis.com/storage/v1/b/test-matt-media/o/a", "storage-example.p.googleapis.com", true},
+	{http.MethodGet, "http://localhost:4443/storage/v1/b/test-matt-media/o", "localhost", false},
+	{http.MethodPost, "http://LOCALHOST:4443/upload/storage/v1/b/test-matt-media/o", "localhost", true},
+	{http.MethodPost, "http://localhost:4443/upload/storage/v1/b/test-matt-media/o", "", false},
+}
+
+func TestIsStorageWrite(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testIsStorageWriteCases {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		is.NoError(err)
+		is.Equal(tc.write, isStorageWrite(req, tc.endpointHost), "Should tell if %s %s is a write", tc.method, tc.url)
+	}
+}
+
+func TestReadOnlyTransport(t *testing.T) {
+	is := assert.New(t)
+	base := &recordingTransport{}
+	transport := readOnlyTransport{base: base}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/storage/v1/b/test-matt-media", nil)
+	_, err := transport.RoundTrip(req)
+	is.NoError(err, "Should allow reads")
+	is.Equal(1, base.requests)
+
+	req, _ = http.NewRequest(http.MethodDelete, "https://storage.googleapis.com/storage/v1/b/test-matt-media/o/episode.ogv", nil)
+	_, err = transport.RoundTrip(req)
+	is.True(errors.IsForbidden(err), "Should refuse writes")
+	is.Equal(1, base.requests, "Should not send writes on")
+}
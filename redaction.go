@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/juju/errors"
+)
+
+// redactionNames is the BucketGroup redaction that keeps object names, and anything else that could hold one,
+// out of the group's reports, leaving bucket names, counts, sizes, ages and pass or fail.
+const redactionNames = "names"
+
+// redacted stands in for text that was withheld, so it's clear something was there.
+const redacted = "[redacted]"
+
+func validateRedaction(redaction string) error {
+	if len(redaction) > 0 && redaction != redactionNames {
+		return errors.NotValidf("Redaction %q, expected %s or nothing", redaction, redactionNames)
+	}
+	return nil
+}
+
+// redactRunSummary copies the summary with every object name withheld. Free text such as validation errors, warnings and hook output
+// can quote object names, so it is withheld too, while lists keep their length so counts still add up.
+func redactRunSummary(rs *RunSummary) *RunSummary {
+	copied := &RunSummary{Generated: rs.Generated, Warnings: redactAll(rs.Warnings), APICalls: rs.APICalls}
+	for _, bs := range rs.Buckets {
+		copied.Buckets = append(copied.Buckets, redactBucketSummary(bs))
+	}
+	return copied
+}
+
+func redactBucketSummary(bs *BucketSummary) *BucketSummary {
+	copied := *bs
+	if len(copied.ValidationError) > 0 {
+		copied.ValidationError = redacted
+	}
+	copied.Substitutions = nil
+	for range bs.Substitutions {
+		copied.Substitutions = append(copied.Substitutions, Substitution{redacted, redacted})
+	}
+	copied.HookResults = nil
+	for _, result := range bs.HookResults {
+		result.File, result.Output = redacted, ""
+		if len(result.Error) > 0 {
+			result.Error = redacted
+		}
+		copied.HookResults = append(copied.HookResults, result)
+	}
+	copied.SignatureFailures = redactAll(bs.SignatureFailures)
+	copied.Warnings = redactAll(bs.Warnings)
+	//top level prefixes are directory names, e.g. a show or an album
+	copied.Inventory.Prefixes = nil
+	copied.VerifiedObjects = nil
+	for _, object := range bs.VerifiedObjects {
+		object.Name = redacted
+		copied.VerifiedObjects = append(copied.VerifiedObjects, object)
+	}
+	copied.DownloadStats.Files = nil
+	for _, file := range bs.DownloadStats.Files {
+		file.Name = redacted
+		copied.DownloadStats.Files = append(copied.DownloadStats.Files, file)
+	}
+	return &copied
+}
+
+func redactAll(texts []string) (withheld []string) {
+	for range texts {
+		withheld = append(withheld, redacted)
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestPrivateRunSummary() *RunSummary {
+	summary := getTestRunSummary()
+	bs := summary.Buckets[1]
+	bs.ValidationError = "Newest file 2026-10/IMG_0042.jpg was created on 2026-10-01, too long in the past"
+	bs.Substitutions = []Substitution{{"2026-09/IMG_0001.jpg", "2026-09/IMG_0002.jpg"}}
+	bs.HookResults = []HookResult{{File: "2026-09/IMG_0002.jpg", ExitCode: 1, Output: "IMG_0002.jpg: not a jpeg"}}
+	bs.SignatureFailures = []string{"2026-09/IMG_0003.jpg: signature not valid"}
+	bs.Warnings = []string{"zero-byte object 2026-09/IMG_0004.jpg"}
+	bs.Inventory.Prefixes = map[string]InventoryTotals{"2026-09/": {Objects: 4, Bytes: 100}}
+	bs.DownloadStats.Files = []FileDownloadStat{{"2026-09/IMG_0002.jpg", 100, 1, 0}}
+	bs.VerifiedObjects = []VerifiedObject{{Name: "2026-09/IMG_0002.jpg", Size: 100, CRC32C: "0000002a"}}
+	summary.warn("in progress file lists 2026-09/IMG_0005.jpg")
+	return summary
+}
+
+func TestValidateRedaction(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(validateRedaction(""))
+	is.NoError(validateRedaction(redactionNames))
+	is.True(errors.IsNotValid(validateRedaction("everything")))
+}
+
+func TestRedactRunSummary(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestPrivateRunSummary()
+	actual := redactRunSummary(summary)
+
+	var output bytes.Buffer
+	is.NoError(writeSummaryJson(&output, actual, summary.Generated))
+	is.NotContains(output.String(), "IMG_", "Should withhold every object name")
+	is.NotContains(output.String(), "2026-09/")
+	bs := actual.Buckets[1]
+	is.Equal("test-matt-server-backups", bs.BucketName, "Should keep bucket names")
+	is.Equal(validationFailed, bs.ValidationResult)
+	is.Equal(redacted, bs.ValidationError)
+	is.Len(bs.Substitutions, 1, "Should keep counts")
+	is.Len(bs.HookResults, 1)
+	is.False(bs.HookResults[0].passed())
+	is.Len(bs.SignatureFailures, 1)
+	is.Len(bs.DownloadStats.Files, 1)
+	is.Equal(int64(100), bs.DownloadStats.Files[0].Bytes)
+	is.Equal([]VerifiedObject{{Name: redacted, Size: 100, CRC32C: "0000002a"}}, bs.VerifiedObjects)
+	is.Equal([]string{redacted}, actual.Warnings)
+	is.Empty(actual.Buckets[0].ValidationError, "Should leave a passing bucket without an error")
+
+	is.Contains(summary.Buckets[1].ValidationError, "IMG_0042.jpg", "Should leave the original summary alone")
+	is.Len(summary.Buckets[1].Inventory.Prefixes, 1)
+}
+
+func TestReportForGroup(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestPrivateRunSummary()
+	group := BucketGroup{Name: "parents", Buckets: []string{"test-matt-server-backups"}}
+	is.Contains(summary.reportForGroup(group).Buckets[0].ValidationError, "IMG_0042.jpg")
+	group.Redaction = redactionNames
+	is.Equal(redacted, summary.reportForGroup(group).Buckets[0].ValidationError)
+}
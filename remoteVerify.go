@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// formats of a RemoteVerify manifest
+const (
+	manifestFormatMd5sum = "md5sum"
+	manifestFormatJson   = "json"
+)
+
+const (
+	defaultRemoteVerifyNewest = 10
+	// maxManifestSize keeps a wrong manifest object from being read into memory.
+	maxManifestSize = 64 << 20
+)
+
+// ManifestEntry is an object's checksums as recorded by the backup tool, MD5 in hex and CRC32C as 8 hex digits.
+// Either can be blank.
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	MD5    string `json:"md5"`
+	CRC32C string `json:"crc32c"`
+}
+
+// RemoteVerifyResult lists the newest objects of a bucket whose checksums didn't match the manifest, weren't in it,
+// or couldn't be compared, like a composite object without an md5 in a manifest without crc32c. Error is why the check
+// couldn't be done at all.
+type RemoteVerifyResult struct {
+	BucketName    string
+	Manifest      string
+	Checked       int
+	Mismatched    []string
+	NotInManifest []string
+	Unverifiable  []string
+	Error         string
+}
+
+func (r RemoteVerifyResult) passed() bool {
+	return len(r.Error) == 0 && len(r.Mismatched) == 0
+}
+
+// getRemoteVerifyBuckets picks every bucket with a remote_verify manifest, or just the named bucket if bucketName is set.
+// bucketName can be a URI like gs://bucket/prefix to check the objects under that prefix.
+func getRemoteVerifyBuckets(config Config, bucketName string) (buckets []BucketToProcess, err error) {
+	hasManifest := func(bucketConfig BucketToProcess) bool {
+		return len(bucketConfig.RemoteVerify.Manifest) > 0 || len(bucketConfig.RemoteVerify.ManifestFile) > 0
+	}
+	if len(bucketName) > 0 {
+		bucketConfig, err2 := getBucketConfigFromURI(bucketName, config.Buckets)
+		if err2 != nil {
+			return nil, err2
+		}
+		if !hasManifest(bucketConfig) {
+			return nil, errors.NotFoundf("Remote_verify manifest of bucket %s", bucketConfig.Name)
+		}
+		return []BucketToProcess{bucketConfig}, nil
+	}
+	for _, bucketConfig := range config.Buckets {
+		if hasManifest(bucketConfig) {
+			buckets = append(buckets, bucketConfig)
+		}
+	}
+	if len(buckets) == 0 {
+		err = errors.NotFoundf("Buckets with a remote_verify manifest")
+	}
+	return
+}
+
+// parseManifest reads the manifest's entries by object name.
+func parseManifest(contents []byte, format string) (entries map[string]ManifestEntry, err error) {
+	entries = make(map[string]ManifestEntry)
+	switch format {
+	case manifestFormatMd5sum:
+		for i, line := range strings.Split(string(contents), "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			digest, name, found := strings.Cut(line, " ")
+			if !found {
+				return nil, errors.NotValidf("Manifest line %d %q, expected digest then name", i+1, line)
+			}
+			//md5sum marks files read in binary mode with a *
+			name = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(name), "*"), "./")
+			entries[name] = ManifestEntry{Name: name, MD5: digest}
+		}
+	case manifestFormatJson:
+		var list []ManifestEntry
+		err = json.Unmarshal(contents, &list)
+		if err != nil {
+			return nil, errors.Annotate(err, "Unable to parse json manifest")
+		}
+		for _, entry := range list {
+			entry.Name = strings.TrimPrefix(entry.Name, "./")
+			entries[entry.Name] = entry
+		}
+	default:
+		return nil, errors.NotValidf("Manifest format %q, expected %s or %s", format, manifestFormatMd5sum, manifestFormatJson)
+	}
+	return
+}
+
+// loadRemoteVerifyManifest reads the manifest from the bucket, or from manifest_file when it's set.
+func loadRemoteVerifyManifest(ctx context.Context, bucket BackupStore, bucketConfig BucketToProcess) (entries map[string]ManifestEntry, err error) {
+	verify := bucketConfig.RemoteVerify
+	var contents []byte
+	if len(verify.ManifestFile) > 0 {
+		contents, err = os.ReadFile(verify.ManifestFile)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to read manifest file %s", verify.ManifestFile)
+		}
+	} else {
+		contents, err = readSmallObject(ctx, bucket, bucketConfig.Prefix+verify.Manifest, maxManifestSize)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to read manifest %s", bucketConfig.Prefix+verify.Manifest)
+		}
+	}
+	return parseManifest(contents, verify.Format)
+}
+
+// getNewestObjects lists the num most recently created objects, newest first, leaving out skip, e.g. the manifest itself.
+func getNewestObjects(ctx context.Context, bucket BackupStore, num int, skip string) (newest []*storage.ObjectAttrs, err error) {
+	it := listObjects(ctx, bucket, nil)
+	for {
+		objAttrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if objAttrs.Name == skip {
+			continue
+		}
+		newest = append(newest, objAttrs)
+		if len(newest) > num*2 {
+			sortNewestFirst(newest)
+			newest = newest[:num]
+		}
+	}
+	sortNewestFirst(newest)
+	if len(newest) > num {
+		newest = newest[:num]
+	}
+	return
+}
+
+func sortNewestFirst(objects []*storage.ObjectAttrs) {
+	sort.SliceStable(objects, func(i, j int) bool { return objects[i].Created.After(objects[j].Created) })
+}
+
+// compareManifestEntry compares the checksums cloud storage reports for an object with those in its manifest entry,
+// md5 when both have one and otherwise crc32c. comparable is false when they have no checksum in common.
+func compareManifestEntry(objAttrs *storage.ObjectAttrs, entry ManifestEntry) (matched, comparable bool, err error) {
+	if len(entry.MD5) > 0 && len(objAttrs.MD5) > 0 {
+		digest, err := hex.DecodeString(strings.ToLower(entry.MD5))
+		if err != nil || len(digest) != 16 {
+			return false, false, errors.NotValidf("Md5 %q of %s in manifest", entry.MD5, entry.Name)
+		}
+		return bytes.Equal(digest, objAttrs.MD5), true, nil
+	}
+	if len(entry.CRC32C) > 0 {
+		crc, err := strconv.ParseUint(entry.CRC32C, 16, 32)
+		if err != nil {
+			return false, false, errors.NotValidf("Crc32c %q of %s in manifest", entry.CRC32C, entry.Name)
+		}
+		return uint32(crc) == objAttrs.CRC32C, true, nil
+	}
+	return false, false, nil
+}
+
+// verifyRemoteChecksums checks the newest objects in the bucket against the manifest without downloading them,
+// catching backups corrupted or tampered with after upload for no egress.
+func verifyRemoteChecksums(ctx context.Context, bucket BackupStore, bucketConfig BucketToProcess, newest int) (result RemoteVerifyResult) {
+	result.BucketName = bucketConfig.Name
+	verify := bucketConfig.RemoteVerify
+	result.Manifest = verify.ManifestFile
+	if len(result.Manifest) == 0 {
+		result.Manifest = bucketConfig.Prefix + verify.Manifest
+	}
+	if newest <= 0 {
+		newest = verify.Newest
+	}
+	if newest <= 0 {
+		newest = defaultRemoteVerifyNewest
+	}
+	err := func() error {
+		ctx = withBucketPrefix(ctx, bucketConfig.Prefix)
+		entries, err := loadRemoteVerifyManifest(ctx, bucket, bucketConfig)
+		if err != nil {
+			return err
+		}
+		skip := ""
+		if len(verify.ManifestFile) == 0 {
+			skip = bucketConfig.Prefix + verify.Manifest
+		}
+		objects, err := getNewestObjects(ctx, bucket, newest, skip)
+		if err != nil {
+			return errors.Annotatef(err, "Unable to list objects in bucket %s", bucketConfig.Name)
+		}
+		for _, objAttrs := range objects {
+			entry, found := entries[strings.TrimPrefix(objAttrs.Name, bucketConfig.Prefix)]
+			if !found {
+				result.NotInManifest = append(result.NotInManifest, objAttrs.Name)
+				continue
+			}
+			matched, comparable, err := compareManifestEntry(objAttrs, entry)
+			switch {
+			case err != nil:
+				return err
+			case !comparable:
+				result.Unverifiable = append(result.Unverifiable, objAttrs.Name)
+				continue
+			case !matched:
+				result.Mismatched = append(result.Mismatched, objAttrs.Name)
+			}
+			result.Checked++
+		}
+		return nil
+	}()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return
+}
+
+// writeRemoteVerifyResults prints a row per bucket, then the objects that didn't match, weren't in the manifest or couldn't be checked.
+func writeRemoteVerifyResults(w io.Writer, results []RemoteVerifyResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Bucket\tManifest\tChecked\tMismatched\tNot In Manifest\tUnverifiable\tResult\t")
+	for _, result := range results {
+		outcome := validationPassed
+		if !result.passed() {
+			outcome = validationFailed
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t\n", result.BucketName, result.Manifest, result.Checked,
+			len(result.Mismatched), len(result.NotInManifest), len(result.Unverifiable), outcome)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if len(result.Error) > 0 {
+			fmt.Fprintf(w, "\n%s: %s\n", result.BucketName, result.Error)
+		}
+		for _, name := range result.Mismatched {
+			fmt.Fprintf(w, "%s: %s checksum doesn't match the manifest\n", result.BucketName, name)
+		}
+		for _, name := range result.NotInManifest {
+			fmt.Fprintf(w, "%s: %s not in the manifest\n", result.BucketName, name)
+		}
+		for _, name := range result.Unverifiable {
+			fmt.Fprintf(w, "%s: %s has no checksum in common with the manifest\n", result.BucketName, name)
+		}
+	}
+	return nil
+}
+
+// runVerifyRemote checks the newest objects of each bucket with a manifest against it, exiting non zero if any didn't match.
+func runVerifyRemote(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	configPath := configFlag(flags)
+	bucketName := flags.String("bucket", "", "only check this bucket, or gs://bucket/prefix, defaults to every bucket with a remote_verify manifest")
+	newest := flags.Int("newest", 0, "check this many of the newest objects, overriding newest from the config")
+	return func(ctx context.Context) {
+		config, client := loadConfigAndConnect(ctx, *configPath)
+		buckets, err := getRemoteVerifyBuckets(config, *bucketName)
+		logFatalIfErr(err, "Nothing to check.")
+
+		var results []RemoteVerifyResult
+		failed := false
+		for _, bucketConfig := range buckets {
+			bucket, err := openBackupStore(ctx, client, config, bucketConfig)
+			logFatalIfErr(err, "Unable to open bucket.")
+			result := verifyRemoteChecksums(ctx, bucket, bucketConfig, *newest)
+			failed = failed || !result.passed()
+			results = append(results, result)
+		}
+		err = writeRemoteVerifyResults(console.stdout, results)
+		logFatalIfErr(err, "Unable to print results.")
+		if failed {
+			flushTracing()
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestCrc32c(content string) string {
+	return fmt.Sprintf("%08x", crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli)))
+}
+
+func TestParseManifest(t *testing.T) {
+	is := assert.New(t)
+	entries, err := parseManifest([]byte("# made by restic-upload\nd41d8cd98f00b204e9800998ecf8427e  ./2018-01-01.tar.gz\n"+
+		"0cc175b9c0f1b6a831c399e269772661 *2018-01-02.tar.gz\n\n"), manifestFormatMd5sum)
+	is.NoError(err)
+	is.Equal(map[string]ManifestEntry{
+		"2018-01-01.tar.gz": {Name: "2018-01-01.tar.gz", MD5: "d41d8cd98f00b204e9800998ecf8427e"},
+		"2018-01-02.tar.gz": {Name: "2018-01-02.tar.gz", MD5: "0cc175b9c0f1b6a831c399e269772661"},
+	}, entries)
+
+	entries, err = parseManifest([]byte(`[{"name": "2018-01-01.tar.gz", "crc32c": "e3069283"}]`), manifestFormatJson)
+	is.NoError(err)
+	is.Equal("e3069283", entries["2018-01-01.tar.gz"].CRC32C)
+
+	_, err = parseManifest([]byte("d41d8cd98f00b204e9800998ecf8427e\n"), manifestFormatMd5sum)
+	is.True(errors.IsNotValid(err), "Should error on a line without a name")
+	_, err = parseManifest([]byte("{}"), manifestFormatJson)
+	is.Error(err)
+	_, err = parseManifest(nil, "sha256sum")
+	is.True(errors.IsNotValid(err), "Should error on an unknown format")
+}
+
+func TestCompareManifestEntry(t *testing.T) {
+	is := assert.New(t)
+	digest := md5.Sum([]byte("backup"))
+	objAttrs := &storage.ObjectAttrs{Name: "backup.tar.gz", MD5: digest[:], CRC32C: 0xe3069283}
+
+	matched, comparable, err := compareManifestEntry(objAttrs, ManifestEntry{MD5: fmt.Sprintf("%X", digest)})
+	is.True(matched && comparable && err == nil, "Should compare md5 whatever its case")
+	matched, _, _ = compareManifestEntry(objAttrs, ManifestEntry{MD5: "0cc175b9c0f1b6a831c399e269772661", CRC32C: "e3069283"})
+	is.False(matched, "Should prefer md5 when both have one")
+	matched, comparable, _ = compareManifestEntry(&storage.ObjectAttrs{CRC32C: 0xe3069283}, ManifestEntry{MD5: "0cc175b9c0f1b6a831c399e269772661", CRC32C: "e3069283"})
+	is.True(matched && comparable, "Should fall back to crc32c for composite objects without an md5")
+	_, comparable, _ = compareManifestEntry(&storage.ObjectAttrs{CRC32C: 0xe3069283}, ManifestEntry{MD5: "0cc175b9c0f1b6a831c399e269772661"})
+	is.False(comparable)
+	_, _, err = compareManifestEntry(objAttrs, ManifestEntry{CRC32C: "not hex"})
+	is.True(errors.IsNotValid(err))
+}
+
+func TestGetNewestObjects(t *testing.T) {
+	is := assert.New(t)
+	now := time.Now()
+	report := &inventoryReport{}
+	for i := 0; i < 20; i++ {
+		report.objects = append(report.objects, &storage.ObjectAttrs{Name: fmt.Sprintf("backup-%02d.tar.gz", (i*7)%20), Created: now.Add(time.Duration((i*7)%20) * time.Hour)})
+	}
+	report.objects = append(report.objects, &storage.ObjectAttrs{Name: "manifest.md5", Created: now.Add(time.Hour * 24)})
+	newest, err := getNewestObjects(withInventoryReport(context.Background(), report), nil, 3, "manifest.md5")
+	is.NoError(err)
+	is.Len(newest, 3)
+	is.Equal([]string{"backup-19.tar.gz", "backup-18.tar.gz", "backup-17.tar.gz"}, []string{newest[0].Name, newest[1].Name, newest[2].Name})
+}
+
+func TestVerifyRemoteChecksums(t *testing.T) {
+	is := assert.New(t)
+	objects := map[string][]byte{
+		"nightly/2018-01-01.tar.gz": []byte("first"),
+		"nightly/2018-01-02.tar.gz": []byte("second, bit rotted"),
+		"nightly/2018-01-03.tar.gz": []byte("third"),
+		"nightly/manifest.json": []byte(fmt.Sprintf(`[{"name": "2018-01-01.tar.gz", "crc32c": "%s"}, {"name": "2018-01-02.tar.gz", "crc32c": "%s"}]`,
+			getTestCrc32c("first"), getTestCrc32c("second"))),
+	}
+	client, _ := newTestStorageServer(t, "test-matt-server-backups", objects)
+	bucketConfig := BucketToProcess{Name: "test-matt-server-backups", Prefix: "nightly/",
+		RemoteVerify: RemoteVerify{Manifest: "manifest.json", Format: manifestFormatJson}}
+
+	result := verifyRemoteChecksums(context.Background(), newGCSStore(client.Bucket(bucketConfig.Name)), bucketConfig, 0)
+	is.Empty(result.Error)
+	is.False(result.passed())
+	is.Equal("nightly/manifest.json", result.Manifest)
+	is.Equal(2, result.Checked)
+	is.Equal([]string{"nightly/2018-01-02.tar.gz"}, result.Mismatched)
+	is.Equal([]string{"nightly/2018-01-03.tar.gz"}, result.NotInManifest, "Should not check the manifest against itself")
+
+	var output strings.Builder
+	is.NoError(writeRemoteVerifyResults(&output, []RemoteVerifyResult{result}))
+	is.Contains(output.String(), "test-matt-server-backups: nightly/2018-01-02.tar.gz checksum doesn't match the manifest")
+
+	manifestFile := filepath.Join(t.TempDir(), "manifest.md5")
+	os.WriteFile(manifestFile, []byte("not-hex  2018-01-01.tar.gz\n"), 0600)
+	bucketConfig.RemoteVerify = RemoteVerify{ManifestFile: manifestFile, Format: manifestFormatMd5sum, Newest: 1}
+	result = verifyRemoteChecksums(context.Background(), newGCSStore(client.Bucket(bucketConfig.Name)), bucketConfig, 0)
+	is.Equal(manifestFile, result.Manifest)
+	is.Equal(1, len(result.NotInManifest)+len(result.Unverifiable)+result.Checked, "Should only check the newest objects")
+}
+
+func TestGetRemoteVerifyBuckets(t *testing.T) {
+	is := assert.New(t)
+	config := Config{Buckets: []BucketToProcess{
+		{Name: "test-matt-media", Type: "media"},
+		{Name: "test-matt-server-backups", Type: "server-backup", RemoteVerify: RemoteVerify{Manifest: "manifest.md5", Format: manifestFormatMd5sum}},
+	}}
+	buckets, err := getRemoteVerifyBuckets(config, "")
+	is.NoError(err)
+	is.Len(buckets, 1)
+	buckets, err = getRemoteVerifyBuckets(config, "gs://test-matt-server-backups/nightly/")
+	is.NoError(err)
+	is.Equal("nightly/", buckets[0].Prefix)
+	_, err = getRemoteVerifyBuckets(config, "test-matt-media")
+	is.True(errors.IsNotFound(err), "Should error on a bucket without a manifest")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// kinds of change between two runs, the regressions are the ones that make report diff exit non zero
+const (
+	reportNowFailing    = "now failing"
+	reportNowPassing    = "now passing"
+	reportNewFailures   = "new failures"
+	reportLessFresh     = "less fresh"
+	reportFewerObjects  = "fewer objects"
+	reportNewBucket     = "new bucket"
+	reportMissingBucket = "missing bucket"
+)
+
+var reportRegressions = map[string]bool{
+	reportNowFailing:    true,
+	reportNewFailures:   true,
+	reportLessFresh:     true,
+	reportFewerObjects:  true,
+	reportMissingBucket: true,
+}
+
+// ReportChange is one thing about a bucket that changed from one run's summary to the next.
+type ReportChange struct {
+	Bucket string
+	Kind   string
+	Detail string
+}
+
+func (c ReportChange) isRegression() bool {
+	return reportRegressions[c.Kind]
+}
+
+// runReport works with the json summaries runs write when VALIDATEBACKUPS_SUMMARY_FILE ends in .json.
+func runReport(flags *flag.FlagSet) (run func(ctx context.Context)) {
+	return func(ctx context.Context) {
+		if flags.NArg() != 3 || flags.Arg(0) != "diff" {
+			log.Fatal("The report command takes diff and two json summaries, e.g. report diff old.json new.json.")
+		}
+		old, err := loadRunSummary(flags.Arg(1))
+		logFatalIfErr(err, "Unable to load old summary.")
+		current, err := loadRunSummary(flags.Arg(2))
+		logFatalIfErr(err, "Unable to load new summary.")
+		changes := diffRunSummaries(old, current)
+		writeReportChanges(console.stdout, changes)
+		for _, change := range changes {
+			if change.isRegression() {
+				flushTracing()
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+func loadRunSummary(filePath string) (summary *RunSummary, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to open summary %s", filePath)
+	}
+	defer file.Close()
+	summary = &RunSummary{}
+	err = json.NewDecoder(file).Decode(summary)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to read summary %s, only json summaries can be diffed", filePath)
+	}
+	return
+}
+
+// diffRunSummaries lists what changed for each bucket in the new summary, in its order, then the buckets it no longer has.
+// Freshness is the age of the newest object when each summary was generated, so a backup job that stopped shows as less fresh.
+func diffRunSummaries(old, current *RunSummary) (changes []ReportChange) {
+	oldBuckets := make(map[string]*BucketSummary)
+	for _, bs := range old.Buckets {
+		oldBuckets[bs.BucketName] = bs
+	}
+	seen := make(map[string]bool)
+	for _, bs := range current.Buckets {
+		seen[bs.BucketName] = true
+		previous, found := oldBuckets[bs.BucketName]
+		if !found {
+			changes = append(changes, ReportChange{bs.BucketName, reportNewBucket, bs.ValidationResult})
+			continue
+		}
+		change := func(kind string, format string, args ...interface{}) {
+			changes = append(changes, ReportChange{bs.BucketName, kind, fmt.Sprintf(format, args...)})
+		}
+		switch {
+		case previous.ValidationResult != validationFailed && bs.ValidationResult == validationFailed:
+			change(reportNowFailing, "%s", bs.ValidationError)
+		case previous.ValidationResult == validationFailed && bs.ValidationResult == validationPassed:
+			change(reportNowPassing, "was %s", previous.ValidationError)
+		}
+		if bs.FilesFailed > previous.FilesFailed {
+			change(reportNewFailures, "%d files failed, up from %d", bs.FilesFailed, previous.FilesFailed)
+		}
+		if len(bs.SignatureFailures) > len(previous.SignatureFailures) {
+			change(reportNewFailures, "%d signature failures, up from %d", len(bs.SignatureFailures), len(previous.SignatureFailures))
+		}
+		if !previous.NewestObject.IsZero() && !bs.NewestObject.IsZero() {
+			previousAge := old.Generated.Sub(previous.NewestObject)
+			age := current.Generated.Sub(bs.NewestObject)
+			if age > previousAge {
+				change(reportLessFresh, "newest object %s old, was %s", formatAge(age), formatAge(previousAge))
+			}
+		}
+		if bs.Inventory.Objects < previous.Inventory.Objects {
+			change(reportFewerObjects, "%d objects, down from %d", bs.Inventory.Objects, previous.Inventory.Objects)
+		}
+	}
+	for _, bs := range old.Buckets {
+		if !seen[bs.BucketName] {
+			changes = append(changes, ReportChange{bs.BucketName, reportMissingBucket, "not in the new summary"})
+		}
+	}
+	return
+}
+
+func formatAge(age time.Duration) string {
+	if age >= 48*time.Hour {
+		return fmt.Sprintf("%d days", int(age/(24*time.Hour)))
+	}
+	return age.Round(time.Minute).String()
+}
+
+// writeReportChanges prints one line per change, short enough to paste into a notification.
+func writeReportChanges(w io.Writer, changes []ReportChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s: %s, %s\n", change.Bucket, change.Kind, change.Detail)
+	}
+}
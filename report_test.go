@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testReportGenerated = time.Date(2026, 3, 10, 6, 0, 0, 0, time.UTC)
+
+func getTestReportSummaries() (old, current *RunSummary) {
+	old = &RunSummary{Generated: testReportGenerated, Buckets: []*BucketSummary{
+		{BucketName: "test-matt-server-backups", ValidationResult: validationPassed, NewestObject: testReportGenerated.Add(-time.Hour),
+			Inventory: BucketInventory{InventoryTotals: InventoryTotals{Objects: 30}}},
+		{BucketName: "test-matt-media", ValidationResult: validationFailed, ValidationError: "too old", Inventory: BucketInventory{InventoryTotals: InventoryTotals{Objects: 100}}},
+		{BucketName: "test-matt-photos", ValidationResult: validationPassed},
+	}}
+	current = &RunSummary{Generated: testReportGenerated.AddDate(0, 0, 3), Buckets: []*BucketSummary{
+		{BucketName: "test-matt-server-backups", ValidationResult: validationFailed, ValidationError: "Newest file too old",
+			NewestObject: testReportGenerated.Add(-time.Hour), Inventory: BucketInventory{InventoryTotals: InventoryTotals{Objects: 28}}},
+		{BucketName: "test-matt-media", ValidationResult: validationPassed, FilesFailed: 2, Inventory: BucketInventory{InventoryTotals: InventoryTotals{Objects: 101}}},
+		{BucketName: "test-matt-music", ValidationResult: validationPassed},
+	}}
+	return
+}
+
+func TestDiffRunSummaries(t *testing.T) {
+	is := assert.New(t)
+	old, current := getTestReportSummaries()
+	expected := []ReportChange{
+		{"test-matt-server-backups", reportNowFailing, "Newest file too old"},
+		{"test-matt-server-backups", reportLessFresh, "newest object 3 days old, was 1h0m0s"},
+		{"test-matt-server-backups", reportFewerObjects, "28 objects, down from 30"},
+		{"test-matt-media", reportNowPassing, "was too old"},
+		{"test-matt-media", reportNewFailures, "2 files failed, up from 0"},
+		{"test-matt-music", reportNewBucket, validationPassed},
+		{"test-matt-photos", reportMissingBucket, "not in the new summary"},
+	}
+	is.Equal(expected, diffRunSummaries(old, current))
+	is.Empty(diffRunSummaries(current, current), "The same summary should have no changes")
+	is.False(ReportChange{Kind: reportNowPassing}.isRegression())
+	is.True(ReportChange{Kind: reportLessFresh}.isRegression())
+}
+
+func TestWriteReportChanges(t *testing.T) {
+	is := assert.New(t)
+	var buf bytes.Buffer
+	writeReportChanges(&buf, nil)
+	is.Equal("No changes.\n", buf.String())
+	buf.Reset()
+	writeReportChanges(&buf, []ReportChange{{"test-matt-media", reportNowPassing, "was too old"}})
+	is.Equal("test-matt-media: now passing, was too old\n", buf.String())
+}
+
+func TestLoadRunSummary(t *testing.T) {
+	is := assert.New(t)
+	dir := t.TempDir()
+	old, _ := getTestReportSummaries()
+	summaryPath := filepath.Join(dir, "summary.json")
+	file, err := os.Create(summaryPath)
+	is.NoError(err)
+	is.NoError(writeSummaryJson(file, old, time.Now()))
+	file.Close()
+
+	loaded, err := loadRunSummary(summaryPath)
+	is.NoError(err)
+	is.Equal(old, loaded, "Should read back the summary as written")
+
+	csvPath := filepath.Join(dir, "summary.csv")
+	is.NoError(os.WriteFile(csvPath, []byte("Bucket,Type\n"), 0644))
+	_, err = loadRunSummary(csvPath)
+	is.Error(err, "Only json summaries can be diffed")
+}
+
+func TestWriteSummaryJsonGenerated(t *testing.T) {
+	is := assert.New(t)
+	var buf bytes.Buffer
+	summary := newRunSummary()
+	is.NoError(writeSummaryJson(&buf, summary, testReportGenerated))
+	is.Contains(buf.String(), `"generated": "2026-03-10T06:00:00Z"`)
+	is.True(summary.Generated.IsZero(), "Should not change the summary being written")
+}
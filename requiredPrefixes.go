@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// validateRequiredPrefixes checks every required prefix, e.g. one per backed up host, has at least one object
+// and, when maxAgeDays is set, that its newest object was created less than that many days before now.
+// One host whose backups stopped is easy to miss in a shared bucket where the others keep it looking fresh,
+// so every failing prefix is named in the error.
+func validateRequiredPrefixes(ctx context.Context, bucket BackupStore, prefixes []string, maxAgeDays int,
+	matcher *objectNameMatcher, now time.Time) (err error) {
+	var problems []string
+	for _, prefix := range prefixes {
+		newest, err2 := getNewestObjectUnderPrefix(ctx, bucket, prefix, matcher)
+		if err2 != nil {
+			return errors.Annotatef(err2, "Unable to list required prefix %s", prefix)
+		}
+		if newest == nil {
+			problems = append(problems, fmt.Sprintf("%s has no objects", prefix))
+			continue
+		}
+		ageInDays := int(now.Sub(newest.Created) / (time.Hour * 24))
+		if maxAgeDays > 0 && ageInDays >= maxAgeDays {
+			problems = append(problems, fmt.Sprintf("%s newest object %s was created on %s", prefix, newest.Name, formatTime(newest.Created)))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.NotValidf("Required prefixes (%s)", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateBucketRequiredPrefixes applies the bucket's required_prefixes, which go stale after required_prefix_max_age_days,
+// or newest_file_max_age_in_days of the server backup rules when that isn't set. The freshness filter applies as it does to server backups.
+func validateBucketRequiredPrefixes(ctx context.Context, bucket BackupStore, bucketConfig BucketToProcess,
+	rules ServerFileValidationRules, now time.Time) (err error) {
+	if len(bucketConfig.RequiredPrefixes) == 0 {
+		return nil
+	}
+	matcher, err := newObjectNameMatcher(bucketConfig.FreshnessFilter)
+	if err != nil {
+		return errors.Annotate(err, "Invalid freshness filter")
+	}
+	maxAgeDays := bucketConfig.RequiredPrefixMaxAgeDays
+	if maxAgeDays == 0 {
+		maxAgeDays = rules.NewestFileMaxAgeInDays
+	}
+	return validateRequiredPrefixes(ctx, bucket, bucketConfig.RequiredPrefixes, maxAgeDays, matcher, now)
+}
+
+func getNewestObjectUnderPrefix(ctx context.Context, bucket BackupStore, prefix string, matcher *objectNameMatcher) (newest *storage.ObjectAttrs, err error) {
+	it := listObjects(ctx, bucket, &storage.Query{Prefix: prefix})
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			break
+		}
+		if err2 != nil {
+			return nil, err2
+		}
+		if !matcher.matches(objAttrs.Name) {
+			continue
+		}
+		if newest == nil || objAttrs.Created.After(newest.Created) {
+			newest = objAttrs
+		}
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRequiredPrefixesNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+var testRequiredPrefixesReport = &inventoryReport{objects: []*storage.ObjectAttrs{
+	{Name: "alpha/2026-03-09.tar.gz", Created: testRequiredPrefixesNow.AddDate(0, 0, -1)},
+	{Name: "alpha/2026-03-01.tar.gz", Created: testRequiredPrefixesNow.AddDate(0, 0, -9)},
+	{Name: "beta/2026-03-01.tar.gz", Created: testRequiredPrefixesNow.AddDate(0, 0, -9)},
+	{Name: "beta/README", Created: testRequiredPrefixesNow},
+	{Name: "gammaray/2026-03-09.tar.gz", Created: testRequiredPrefixesNow},
+}}
+
+var testValidateRequiredPrefixesCases = []struct {
+	prefixes   []string
+	maxAgeDays int
+	expected   []string
+}{
+	{[]string{"alpha/"}, 2, nil},
+	{[]string{"alpha/", "beta/"}, 2, []string{"beta/ newest object beta/2026-03-01.tar.gz"}},
+	//without a max age only empty prefixes fail
+	{[]string{"alpha/", "beta/"}, 0, nil},
+	{[]string{"alpha/", "beta/", "gamma/"}, 2, []string{"beta/ newest object", "gamma/ has no objects"}},
+}
+
+func TestValidateRequiredPrefixes(t *testing.T) {
+	is := assert.New(t)
+	ctx := withInventoryReport(context.Background(), testRequiredPrefixesReport)
+	matcher, err := newObjectNameMatcher(ObjectNameFilter{ExcludePatterns: []string{"README$"}})
+	is.NoError(err)
+	for _, tc := range testValidateRequiredPrefixesCases {
+		err := validateRequiredPrefixes(ctx, nil, tc.prefixes, tc.maxAgeDays, matcher, testRequiredPrefixesNow)
+		if len(tc.expected) == 0 {
+			is.NoError(err, "Prefixes %v should pass", tc.prefixes)
+			continue
+		}
+		is.True(errors.IsNotValid(err), "Prefixes %v should fail", tc.prefixes)
+		for _, expected := range tc.expected {
+			is.Contains(err.Error(), expected)
+		}
+	}
+}
+
+func TestValidateBucketRequiredPrefixes(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(validateBucketRequiredPrefixes(context.Background(), nil, BucketToProcess{}, ServerFileValidationRules{}, testRequiredPrefixesNow),
+		"Should not check buckets without required_prefixes")
+
+	ctx := withInventoryReport(context.Background(), testRequiredPrefixesReport)
+	bucketConfig := BucketToProcess{RequiredPrefixes: []string{"alpha/"}, RequiredPrefixMaxAgeDays: 1}
+	rules := ServerFileValidationRules{NewestFileMaxAgeInDays: 2}
+	is.Error(validateBucketRequiredPrefixes(ctx, nil, bucketConfig, rules, testRequiredPrefixesNow), "Should use required_prefix_max_age_days when set")
+	bucketConfig.RequiredPrefixMaxAgeDays = 0
+	is.NoError(validateBucketRequiredPrefixes(ctx, nil, bucketConfig, rules, testRequiredPrefixesNow), "Should fall back to newest_file_max_age_in_days")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// checkRetention simulates the bucket's lifecycle delete rules over the next RetentionCheck.Days days,
+// warning when they would leave fewer than RetentionCheck.MinBackups of the backups there now.
+// Backups are the live objects accepted by the freshness filter. New backups made in the meantime aren't counted,
+// so a warning means the rules delete backups faster than the minimum allows if the backup job stopped.
+func checkRetention(ctx context.Context, bucket BackupStore, bucketConfig BucketToProcess, now time.Time) (warning string, err error) {
+	check := bucketConfig.RetentionCheck
+	if check.Days <= 0 || check.MinBackups <= 0 {
+		return
+	}
+	bucketAttrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return "", errors.Annotate(err, "Unable to get lifecycle rules")
+	}
+	return getRetentionWarning(ctx, bucket, bucketConfig, bucketAttrs.Lifecycle.Rules, now)
+}
+
+func getRetentionWarning(ctx context.Context, bucket BackupStore, bucketConfig BucketToProcess, rules []storage.LifecycleRule,
+	now time.Time) (warning string, err error) {
+	check := bucketConfig.RetentionCheck
+	matcher, err := newObjectNameMatcher(bucketConfig.FreshnessFilter)
+	if err != nil {
+		return "", errors.Annotate(err, "Invalid freshness filter")
+	}
+	horizon := now.AddDate(0, 0, check.Days)
+	present, remaining := 0, 0
+	it := listObjects(ctx, bucket, nil)
+	for {
+		objAttrs, err2 := it.Next()
+		if err2 == iterator.Done {
+			break
+		}
+		if err2 != nil {
+			return "", errors.Annotate(err2, "Unable to list backups")
+		}
+		if !matcher.matches(objAttrs.Name) {
+			continue
+		}
+		present++
+		deleted, found := getLifecycleDeletion(rules, objAttrs)
+		if !found || deleted.After(horizon) {
+			remaining++
+		}
+	}
+	if remaining < check.MinBackups {
+		warning = fmt.Sprintf("lifecycle rules of bucket %s will delete %d of its %d backups within %d days, leaving %d, fewer than the minimum of %d.",
+			bucketConfig.Name, present-remaining, present, check.Days, remaining, check.MinBackups)
+	}
+	return
+}
+
+// getLifecycleDeletion works out the earliest time a delete rule will delete a live object, found is false when none ever will.
+// Rules that only apply to noncurrent versions are skipped, the object is live.
+func getLifecycleDeletion(rules []storage.LifecycleRule, objAttrs *storage.ObjectAttrs) (when time.Time, found bool) {
+	for _, rule := range rules {
+		if rule.Action.Type != storage.DeleteAction {
+			continue
+		}
+		ruleWhen, matches := getLifecycleRuleDeletion(rule.Condition, objAttrs)
+		if matches && (!found || ruleWhen.Before(when)) {
+			when, found = ruleWhen, true
+		}
+	}
+	return
+}
+
+// getLifecycleRuleDeletion is when every condition of the rule holds for a live object, the latest of the times each one starts holding.
+func getLifecycleRuleDeletion(cond storage.LifecycleCondition, objAttrs *storage.ObjectAttrs) (when time.Time, matches bool) {
+	if cond.Liveness == storage.Archived || cond.NumNewerVersions > 0 || cond.DaysSinceNoncurrentTime > 0 || !cond.NoncurrentTimeBefore.IsZero() {
+		return
+	}
+	if len(cond.MatchesPrefix) > 0 && !hasAnyPrefix(objAttrs.Name, cond.MatchesPrefix) {
+		return
+	}
+	if len(cond.MatchesSuffix) > 0 && !hasAnySuffix(objAttrs.Name, cond.MatchesSuffix) {
+		return
+	}
+	if len(cond.MatchesStorageClasses) > 0 && !slices.Contains(cond.MatchesStorageClasses, objAttrs.StorageClass) {
+		return
+	}
+	if !cond.CreatedBefore.IsZero() && !objAttrs.Created.Before(cond.CreatedBefore) {
+		return
+	}
+	if !cond.CustomTimeBefore.IsZero() && (objAttrs.CustomTime.IsZero() || !objAttrs.CustomTime.Before(cond.CustomTimeBefore)) {
+		return
+	}
+	when = objAttrs.Created
+	if cond.AgeInDays > 0 {
+		when = objAttrs.Created.AddDate(0, 0, int(cond.AgeInDays))
+	}
+	if cond.DaysSinceCustomTime > 0 {
+		if objAttrs.CustomTime.IsZero() {
+			return
+		}
+		if customWhen := objAttrs.CustomTime.AddDate(0, 0, int(cond.DaysSinceCustomTime)); customWhen.After(when) {
+			when = customWhen
+		}
+	}
+	return when, true
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRetentionNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+var testGetLifecycleDeletionCases = []struct {
+	description string
+	rules       []storage.LifecycleRule
+	objAttrs    *storage.ObjectAttrs
+	expected    time.Time
+	found       bool
+}{
+	{"no rules", nil, &storage.ObjectAttrs{Created: testRetentionNow}, time.Time{}, false},
+	{"age", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{AgeInDays: 30}}},
+		&storage.ObjectAttrs{Created: testRetentionNow}, testRetentionNow.AddDate(0, 0, 30), true},
+	{"earliest of two rules", []storage.LifecycleRule{
+		{Action: storage.LifecycleAction{Type: storage.DeleteAction}, Condition: storage.LifecycleCondition{AgeInDays: 30}},
+		{Action: storage.LifecycleAction{Type: storage.DeleteAction}, Condition: storage.LifecycleCondition{AgeInDays: 7}}},
+		&storage.ObjectAttrs{Created: testRetentionNow}, testRetentionNow.AddDate(0, 0, 7), true},
+	{"storage class changes don't delete", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.SetStorageClassAction,
+		StorageClass: "COLDLINE"}, Condition: storage.LifecycleCondition{AgeInDays: 30}}},
+		&storage.ObjectAttrs{Created: testRetentionNow}, time.Time{}, false},
+	{"noncurrent versions only", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{NumNewerVersions: 3}}},
+		&storage.ObjectAttrs{Created: testRetentionNow}, time.Time{}, false},
+	{"other prefix", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{AgeInDays: 30, MatchesPrefix: []string{"tmp/"}}}},
+		&storage.ObjectAttrs{Name: "alpha/backup.tar.gz", Created: testRetentionNow}, time.Time{}, false},
+	{"matching suffix and class", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{AgeInDays: 30, MatchesSuffix: []string{".gz"}, MatchesStorageClasses: []string{"STANDARD"}}}},
+		&storage.ObjectAttrs{Name: "alpha/backup.tar.gz", StorageClass: "STANDARD", Created: testRetentionNow},
+		testRetentionNow.AddDate(0, 0, 30), true},
+	{"created before", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{CreatedBefore: testRetentionNow}}},
+		&storage.ObjectAttrs{Created: testRetentionNow.AddDate(0, 0, -1)}, testRetentionNow.AddDate(0, 0, -1), true},
+	{"custom time later than age", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{AgeInDays: 1, DaysSinceCustomTime: 10}}},
+		&storage.ObjectAttrs{Created: testRetentionNow, CustomTime: testRetentionNow.AddDate(0, 0, 2)}, testRetentionNow.AddDate(0, 0, 12), true},
+	{"no custom time", []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{DaysSinceCustomTime: 10}}},
+		&storage.ObjectAttrs{Created: testRetentionNow}, time.Time{}, false},
+}
+
+func TestGetLifecycleDeletion(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetLifecycleDeletionCases {
+		actual, found := getLifecycleDeletion(tc.rules, tc.objAttrs)
+		is.Equal(tc.found, found, tc.description)
+		is.Equal(tc.expected, actual, tc.description)
+	}
+}
+
+func TestGetRetentionWarning(t *testing.T) {
+	is := assert.New(t)
+	var objects []*storage.ObjectAttrs
+	for days := 1; days <= 10; days++ {
+		objects = append(objects, &storage.ObjectAttrs{Name: testRetentionNow.AddDate(0, 0, -days).Format("2006-01-02") + ".tar.gz",
+			Created: testRetentionNow.AddDate(0, 0, -days)})
+	}
+	objects = append(objects, &storage.ObjectAttrs{Name: "README", Created: testRetentionNow.AddDate(-1, 0, 0)})
+	ctx := withInventoryReport(context.Background(), &inventoryReport{objects: objects})
+	rules := []storage.LifecycleRule{{Action: storage.LifecycleAction{Type: storage.DeleteAction},
+		Condition: storage.LifecycleCondition{AgeInDays: 14}}}
+	bucketConfig := BucketToProcess{Name: "test-matt-server-backups", FreshnessFilter: ObjectNameFilter{NamePattern: `\.tar\.gz$`},
+		RetentionCheck: RetentionCheck{Days: 7, MinBackups: 7}}
+
+	warning, err := getRetentionWarning(ctx, nil, bucketConfig, rules, testRetentionNow)
+	is.NoError(err)
+	is.Equal("lifecycle rules of bucket test-matt-server-backups will delete 4 of its 10 backups within 7 days, leaving 6, fewer than the minimum of 7.",
+		warning)
+
+	bucketConfig.RetentionCheck.MinBackups = 6
+	warning, err = getRetentionWarning(ctx, nil, bucketConfig, rules, testRetentionNow)
+	is.NoError(err)
+	is.Empty(warning)
+
+	warning, err = checkRetention(ctx, nil, BucketToProcess{}, testRetentionNow)
+	is.NoError(err)
+	is.Empty(warning, "Should not check buckets without a retention_check")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// statuses of a RunResult, besides validationPassed and validationFailed
+const (
+	runAlreadyRunning = "already running"
+	//stopped early, e.g. when its credentials expired, but a rerun carries on where it stopped
+	runIncomplete = "incomplete"
+)
+
+// RunResult is the one line of json printed last by a run, so wrapper scripts can tell how it went
+// without parsing the summary table or having a summary file. A bucket counts as failed if its validation,
+// any of its downloads or any signature check failed. Warnings don't fail the run, they are only counted.
+type RunResult struct {
+	Status          string  `json:"status"`
+	BucketsFailed   int     `json:"buckets_failed"`
+	FilesDownloaded int     `json:"files_downloaded"`
+	Bytes           int64   `json:"bytes"`
+	Warnings        int     `json:"warnings"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// fatalHook is called with the error before a fatal error ends the run, see logFatalIfErr.
+var fatalHook = func(err error) {}
+
+// reportRunResult makes a fatal error print the run result before exiting,
+// and returns the func that prints it when the run ends normally.
+func reportRunResult(w io.Writer, summary *RunSummary, started time.Time) (done func()) {
+	write := func(err error) {
+		writeRunResult(w, getRunResult(summary, time.Since(started), err))
+	}
+	fatalHook = write
+	return func() {
+		fatalHook = func(err error) {}
+		write(nil)
+	}
+}
+
+func getRunResult(rs *RunSummary, duration time.Duration, err error) (result RunResult) {
+	result.Status = validationPassed
+	result.DurationSeconds = duration.Round(time.Millisecond).Seconds()
+	if rs != nil {
+		result.Warnings = len(rs.getWarnings())
+		for _, bs := range rs.Buckets {
+			if bs.ValidationResult == validationFailed || bs.FilesFailed > 0 || len(bs.SignatureFailures) > 0 {
+				result.BucketsFailed++
+			}
+			result.FilesDownloaded += bs.FilesDownloaded
+			result.Bytes += bs.BytesDownloaded
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if err != nil || result.BucketsFailed > 0 {
+		result.Status = validationFailed
+	}
+	if rs != nil && len(rs.Incomplete) > 0 {
+		result.Status = runIncomplete
+	}
+	return
+}
+
+func writeRunResult(w io.Writer, result RunResult) error {
+	return json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRunResult(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestRunSummary()
+	summary.Buckets[0].FilesDownloaded = 9
+	result := getRunResult(summary, 90*time.Second+1500*time.Microsecond, nil)
+	is.Equal(RunResult{Status: validationFailed, BucketsFailed: 1, FilesDownloaded: 9, Bytes: 1536, DurationSeconds: 90.002}, result)
+
+	summary.Buckets[1].setValidationResult(nil)
+	is.Equal(validationPassed, getRunResult(summary, 0, nil).Status)
+	summary.Buckets[1].Warnings = []string{"3 zero-byte objects in bucket test-matt-server-backups."}
+	result = getRunResult(summary, 0, nil)
+	is.Equal(validationPassed, result.Status, "Warnings shouldn't fail the run")
+	is.Equal(1, result.Warnings)
+	summary.Buckets[1].SignatureFailures = []string{"backup.tar.gz"}
+	is.Equal(1, getRunResult(summary, 0, nil).BucketsFailed, "Signature failures should fail the bucket")
+
+	result = getRunResult(nil, 0, errors.New("no credentials"))
+	is.Equal(RunResult{Status: validationFailed, Error: "no credentials"}, result)
+}
+
+func TestWriteRunResult(t *testing.T) {
+	is := assert.New(t)
+	var buf bytes.Buffer
+	err := writeRunResult(&buf, RunResult{Status: validationPassed, FilesDownloaded: 3, Bytes: 42, DurationSeconds: 1.5})
+	is.Nil(err)
+	is.Equal(`{"status":"passed","buckets_failed":0,"files_downloaded":3,"bytes":42,"warnings":0,"duration_seconds":1.5}`+"\n", buf.String())
+}
+
+func TestReportRunResult(t *testing.T) {
+	is := assert.New(t)
+	var buf bytes.Buffer
+	summary := newRunSummary()
+	done := reportRunResult(&buf, summary, time.Now())
+	summary.bucket("test-matt-media", "media").FilesDownloaded = 2
+	fatalHook(errors.New("disk full"))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	is.Equal(1, len(lines))
+	var result RunResult
+	is.Nil(json.Unmarshal([]byte(lines[0]), &result))
+	is.Equal(validationFailed, result.Status)
+	is.Equal(2, result.FilesDownloaded)
+	is.Equal("disk full", result.Error)
+
+	buf.Reset()
+	done()
+	fatalHook(errors.New("after the run"))
+	result = RunResult{}
+	is.Nil(json.Unmarshal(buf.Bytes(), &result), "Should print one line once the run is done, and nothing on later fatal errors")
+	is.Equal(validationPassed, result.Status)
+}
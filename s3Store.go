@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// environment variables S3 credentials and the region are read from, the same ones the aws cli uses
+const (
+	envAWSAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	envAWSSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+	envAWSSessionToken    = "AWS_SESSION_TOKEN"
+	envAWSRegion          = "AWS_REGION"
+	envAWSDefaultRegion   = "AWS_DEFAULT_REGION"
+)
+
+// s3EmptyPayloadHash is the sha256 of an empty body, every request made to S3 is a GET or HEAD without one.
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3ListPageSize is how many objects are asked for in each listing request, the most S3 returns.
+const s3ListPageSize = 1000
+
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// s3Store is an Amazon S3 bucket, or one on an S3 compatible service, read through the REST api with requests signed
+// with signature version 4. Objects are described with the cloud storage types: S3 has no creation time, so Created is
+// when the object was last written, its CRC32C is only known when it was uploaded with a full object CRC32C checksum,
+// and its MD5 when its ETag is one, which it isn't for multipart uploads or objects encrypted with KMS keys.
+// Only GET and HEAD requests are made, so it is read only whether or not read only mode is on.
+type s3Store struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	pathStyle bool
+	creds     s3Credentials
+	now       func() time.Time
+}
+
+// newS3Store connects to the named bucket with the s3 settings of the config and the credentials in the environment.
+func newS3Store(config Config, bucketName string, getenv func(string) string) (BackupStore, error) {
+	settings := config.S3
+	region := settings.Region
+	for _, key := range []string{envAWSRegion, envAWSDefaultRegion} {
+		if len(region) == 0 {
+			region = getenv(key)
+		}
+	}
+	if len(region) == 0 {
+		return nil, errors.NotValidf("S3 bucket %s without a region, set the s3 region in the config or %s", bucketName, envAWSRegion)
+	}
+	creds := s3Credentials{getenv(envAWSAccessKeyID), getenv(envAWSSecretAccessKey), getenv(envAWSSessionToken)}
+	if len(creds.accessKeyID) == 0 || len(creds.secretAccessKey) == 0 {
+		return nil, errors.NotValidf("S3 bucket %s without credentials, set %s and %s", bucketName, envAWSAccessKeyID, envAWSSecretAccessKey)
+	}
+	endpoint := settings.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || len(parsed.Host) == 0 {
+		return nil, errors.NotValidf("S3 endpoint %q, expected a url like https://s3.eu-west-2.amazonaws.com", endpoint)
+	}
+	base, err := getBaseTransport(config.Transport, "s3")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Store{client: &http.Client{Transport: base}, endpoint: parsed, bucket: bucketName, region: region,
+		pathStyle: settings.PathStyle, creds: creds, now: time.Now}, nil
+}
+
+// validateS3Bucket rejects the bucket settings that check cloud storage features, which S3 buckets don't have.
+func validateS3Bucket(bucket BucketToProcess) error {
+	gcsOnly := []struct {
+		key string
+		set bool
+	}{
+		{"kms_key", len(bucket.KMSKey) > 0},
+		{"verify_deleted_version_days", bucket.VerifyDeletedVersionDays > 0},
+		{"retention_check", bucket.RetentionCheck.Days > 0},
+		{"transfer_job", len(bucket.TransferJob.Job) > 0},
+	}
+	for _, setting := range gcsOnly {
+		if setting.set {
+			return errors.NotSupportedf("%s on S3 bucket %s, it only applies to gs buckets", setting.key, bucket.Name)
+		}
+	}
+	return nil
+}
+
+func (s *s3Store) BucketName() string {
+	return s.bucket
+}
+
+// Attrs only checks the bucket is there, the lifecycle, encryption and versioning settings other checks look at
+// are cloud storage's and are left blank.
+func (s *s3Store) Attrs(ctx context.Context) (*storage.BucketAttrs, error) {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &storage.BucketAttrs{Name: s.bucket, Location: s.region}, nil
+}
+
+// Objects lists the bucket with ListObjectsV2. Listing versions isn't supported.
+func (s *s3Store) Objects(ctx context.Context, q *storage.Query) objectIterator {
+	it := &s3ObjectIterator{ctx: ctx, store: s}
+	if q != nil {
+		it.query = *q
+	}
+	if it.query.Versions {
+		it.err = errors.NotSupportedf("Listing object versions of S3 bucket %s", s.bucket)
+	}
+	return it
+}
+
+func (s *s3Store) Object(name string) BackupObject {
+	return s3Object{s, name}
+}
+
+// do signs and sends a request for the key, or the bucket itself when key is blank, failing on any status but 200 or 206.
+func (s *s3Store) do(ctx context.Context, method string, key string, query url.Values, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.getURL(key, query).String(), nil)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to make S3 request for %s", key)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	signS3Request(req, s.creds, s.region, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to reach S3 bucket %s", s.bucket)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, getS3Error(resp, s.bucket, key)
+	}
+	return resp, nil
+}
+
+// getURL is where the key is, in the bucket's virtual host or under its path with path_style.
+func (s *s3Store) getURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := "/" + key
+	if s.pathStyle {
+		path = "/" + s.bucket + path
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = getS3CanonicalQuery(query)
+	return &u
+}
+
+// s3Error is the body S3 sends with a failed request.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// getS3Error maps a missing bucket or key onto the cloud storage errors for them, which the rest of the checks look for.
+func getS3Error(resp *http.Response, bucket string, key string) error {
+	var body s3Error
+	xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body)
+	switch {
+	case resp.StatusCode == http.StatusNotFound && len(key) == 0:
+		return storage.ErrBucketNotExist
+	case resp.StatusCode == http.StatusNotFound:
+		return storage.ErrObjectNotExist
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		return errors.NewUnauthorized(nil, fmt.Sprintf("S3 refused access to %s/%s, %s %s", bucket, key, body.Code, body.Message))
+	}
+	return errors.Errorf("S3 request for %s/%s failed with %s %s %s", bucket, key, resp.Status, body.Code, body.Message)
+}
+
+// s3Object is an object in an S3 bucket.
+type s3Object struct {
+	store *s3Store
+	name  string
+}
+
+// Attrs heads the object, asking for its checksum too.
+func (o s3Object) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	resp, err := o.store.do(ctx, http.MethodHead, o.name, nil, http.Header{"X-Amz-Checksum-Mode": {"ENABLED"}})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return getS3ObjectAttrs(o.store.bucket, o.name, resp.Header)
+}
+
+// Generation is ignored, S3 version ids aren't numbers, so the object read is always the current one.
+func (o s3Object) Generation(gen int64) BackupObject {
+	return o
+}
+
+func (o s3Object) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	return o.NewRangeReader(ctx, 0, -1)
+}
+
+// NewRangeReader reads length bytes from offset, or to the end when length is negative.
+func (o s3Object) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := o.store.do(ctx, http.MethodGet, o.name, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// getS3ObjectAttrs reads an object's attributes from the headers of a HEAD request.
+func getS3ObjectAttrs(bucket string, key string, header http.Header) (attrs *storage.ObjectAttrs, err error) {
+	attrs = &storage.ObjectAttrs{Bucket: bucket, Name: key, ContentType: header.Get("Content-Type"),
+		StorageClass: header.Get("X-Amz-Storage-Class"), Etag: strings.Trim(header.Get("ETag"), `"`)}
+	attrs.Size, err = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to read the size of S3 object %s", key)
+	}
+	attrs.Updated, err = http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to read when S3 object %s was last written", key)
+	}
+	attrs.Created = attrs.Updated
+	if len(attrs.StorageClass) == 0 {
+		attrs.StorageClass = "STANDARD"
+	}
+	//a composite checksum of a multipart upload is a checksum of its parts' checksums, which says nothing about the whole object
+	if header.Get("X-Amz-Checksum-Type") != "COMPOSITE" {
+		if crc, err2 := base64.StdEncoding.DecodeString(header.Get("X-Amz-Checksum-Crc32c")); err2 == nil && len(crc) == 4 {
+			attrs.CRC32C = binary.BigEndian.Uint32(crc)
+		}
+	}
+	if header.Get("X-Amz-Server-Side-Encryption") != "aws:kms" {
+		attrs.MD5 = getS3ETagMD5(attrs.Etag)
+	}
+	for name, values := range header {
+		if metaKey, found := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); found && len(values) > 0 {
+			if attrs.Metadata == nil {
+				attrs.Metadata = make(map[string]string)
+			}
+			attrs.Metadata[metaKey] = values[0]
+		}
+	}
+	return
+}
+
+// getS3ETagMD5 is the MD5 an ETag holds, nil for a multipart upload's ETag, which isn't one.
+func getS3ETagMD5(etag string) []byte {
+	md5, err := hex.DecodeString(etag)
+	if err != nil || len(md5) != 16 {
+		return nil
+	}
+	return md5
+}
+
+// s3ObjectIterator pages through ListObjectsV2, honouring the query's Prefix, Delimiter, StartOffset and EndOffset.
+// S3 lists from after a key rather than from it, so a StartOffset object is headed first to include it.
+type s3ObjectIterator struct {
+	ctx     context.Context
+	store   *s3Store
+	query   storage.Query
+	page    []*storage.ObjectAttrs
+	token   string
+	started bool
+	done    bool
+	err     error
+}
+
+type s3ListBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+		StorageClass string    `xml:"StorageClass"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+func (it *s3ObjectIterator) Next() (*storage.ObjectAttrs, error) {
+	for {
+		if it.err != nil {
+			return nil, it.err
+		}
+		if len(it.page) > 0 {
+			attrs := it.page[0]
+			it.page = it.page[1:]
+			name := attrs.Name + attrs.Prefix
+			if len(it.query.EndOffset) > 0 && name >= it.query.EndOffset {
+				it.page, it.done = nil, true
+				continue
+			}
+			return attrs, nil
+		}
+		if it.done {
+			return nil, iterator.Done
+		}
+		it.err = it.fetch()
+	}
+}
+
+// fetch gets the next page of the listing, starting with the StartOffset object if there is one.
+func (it *s3ObjectIterator) fetch() error {
+	query := url.Values{"list-type": {"2"}, "max-keys": {strconv.Itoa(s3ListPageSize)}}
+	if len(it.query.Prefix) > 0 {
+		query.Set("prefix", it.query.Prefix)
+	}
+	if len(it.query.Delimiter) > 0 {
+		query.Set("delimiter", it.query.Delimiter)
+	}
+	if len(it.token) > 0 {
+		query.Set("continuation-token", it.token)
+	} else if len(it.query.StartOffset) > 0 {
+		query.Set("start-after", it.query.StartOffset)
+	}
+	if !it.started {
+		it.started = true
+		first, err := it.getStartOffsetObject()
+		if err != nil {
+			return err
+		}
+		if first != nil {
+			it.page = append(it.page, first)
+		}
+	}
+	resp, err := it.store.do(it.ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to list S3 bucket %s", it.store.bucket)
+	}
+	defer resp.Body.Close()
+	var result s3ListBucketResult
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to read listing of S3 bucket %s", it.store.bucket)
+	}
+	//S3 lists objects and common prefixes apart, the api lists them together in name order
+	var page []*storage.ObjectAttrs
+	for _, object := range result.Contents {
+		page = append(page, &storage.ObjectAttrs{Bucket: it.store.bucket, Name: object.Key, Size: object.Size,
+			Created: object.LastModified, Updated: object.LastModified, Etag: strings.Trim(object.ETag, `"`),
+			MD5: getS3ETagMD5(strings.Trim(object.ETag, `"`)), StorageClass: object.StorageClass})
+	}
+	for _, prefix := range result.CommonPrefixes {
+		page = append(page, &storage.ObjectAttrs{Prefix: prefix.Prefix})
+	}
+	sort.SliceStable(page, func(i, j int) bool { return page[i].Name+page[i].Prefix < page[j].Name+page[j].Prefix })
+	it.page = append(it.page, page...)
+	it.token = result.NextContinuationToken
+	it.done = !result.IsTruncated || len(it.token) == 0
+	return nil
+}
+
+// getStartOffsetObject is the object named StartOffset, or the common prefix it is under with a delimiter,
+// nil when there isn't one or it is outside the query's prefix.
+func (it *s3ObjectIterator) getStartOffsetObject() (*storage.ObjectAttrs, error) {
+	start := it.query.StartOffset
+	if len(start) == 0 || !strings.HasPrefix(start, it.query.Prefix) {
+		return nil, nil
+	}
+	if len(it.query.Delimiter) > 0 {
+		if i := strings.Index(start[len(it.query.Prefix):], it.query.Delimiter); i >= 0 {
+			//listing after the start offset still lists the prefix it is under
+			return nil, nil
+		}
+	}
+	attrs, err := it.store.Object(start).Attrs(it.ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	return attrs, err
+}
+
+// s3EscapePath escapes each segment of an object path the way signature version 4 expects, leaving the slashes.
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape percent encodes everything but the unreserved characters, as signature version 4 does.
+func s3Escape(value string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || b == '-' || b == '.' || b == '_' || b == '~' {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// getS3CanonicalQuery is the query string sorted by key, with keys and values escaped, which is how it is both sent and signed.
+func getS3CanonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// signS3Request signs the request with signature version 4, covering its host, range and x-amz headers.
+// Requests have no body, so the payload hash is that of an empty one.
+func signS3Request(req *http.Request, creds s3Credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+	if len(creds.sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonicalHeaders.String(), signedHeaders, s3EmptyPayloadHash}, "\n")
+
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+
+	"github.com/juju/errors"
+)
+
+// sampleSizer works out how many objects to sample from a population to reach a confidence level and margin of error.
+// The zero value is off, and the fixed counts from files_to_download are used instead.
+type sampleSizer struct {
+	z             float64
+	marginOfError float64
+}
+
+// newSampleSizer checks the auto sample rules, a blank confidence leaves automatic sizing off.
+func newSampleSizer(rules AutoSampleRules) (sizer sampleSizer, err error) {
+	if rules.Confidence == 0 {
+		return
+	}
+	if rules.Confidence <= 0 || rules.Confidence >= 1 {
+		err = errors.NotValidf("Auto sample confidence %v, it should be between 0 and 1, e.g. 0.95", rules.Confidence)
+		return
+	}
+	if rules.MarginOfError <= 0 || rules.MarginOfError >= 1 {
+		err = errors.NotValidf("Auto sample margin of error %v, it should be between 0 and 1, e.g. 0.05", rules.MarginOfError)
+		return
+	}
+	sizer.z = math.Sqrt2 * math.Erfinv(rules.Confidence)
+	sizer.marginOfError = rules.MarginOfError
+	return
+}
+
+func (s sampleSizer) enabled() bool {
+	return s.z > 0
+}
+
+// sampleSize is Cochran's sample size for a proportion, assuming the worst case of half the objects being bad,
+// with the finite population correction so small populations aren't oversampled. It never exceeds the population.
+func (s sampleSizer) sampleSize(population int) int {
+	if population <= 0 {
+		return 0
+	}
+	n0 := s.z * s.z * 0.25 / (s.marginOfError * s.marginOfError)
+	n := int(math.Ceil(n0 / (1 + (n0-1)/float64(population))))
+	if n > population {
+		return population
+	}
+	return n
+}
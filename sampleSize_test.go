@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testSampleSizeCases = []struct {
+	rules      AutoSampleRules
+	population int
+	expected   int
+}{
+	{rules: AutoSampleRules{Confidence: 0.95, MarginOfError: 0.05}, population: 0, expected: 0},
+	{rules: AutoSampleRules{Confidence: 0.95, MarginOfError: 0.05}, population: 1, expected: 1},
+	{rules: AutoSampleRules{Confidence: 0.95, MarginOfError: 0.05}, population: 10, expected: 10},
+	{rules: AutoSampleRules{Confidence: 0.95, MarginOfError: 0.05}, population: 100, expected: 80},
+	{rules: AutoSampleRules{Confidence: 0.95, MarginOfError: 0.05}, population: 1000, expected: 278},
+	{rules: AutoSampleRules{Confidence: 0.95, MarginOfError: 0.05}, population: 1000000, expected: 384},
+	{rules: AutoSampleRules{Confidence: 0.99, MarginOfError: 0.1}, population: 1000000, expected: 166},
+	{rules: AutoSampleRules{Confidence: 0.9, MarginOfError: 0.2}, population: 50, expected: 13},
+}
+
+func TestSampleSize(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testSampleSizeCases {
+		sizer, err := newSampleSizer(tc.rules)
+		is.NoError(err)
+		is.True(sizer.enabled())
+		is.Equal(tc.expected, sizer.sampleSize(tc.population), "%+v of %d", tc.rules, tc.population)
+	}
+}
+
+var testNewSampleSizerErrorCases = []AutoSampleRules{
+	{Confidence: -0.5, MarginOfError: 0.05},
+	{Confidence: 1, MarginOfError: 0.05},
+	{Confidence: 95, MarginOfError: 0.05},
+	{Confidence: 0.95},
+	{Confidence: 0.95, MarginOfError: 1.5},
+}
+
+func TestNewSampleSizer(t *testing.T) {
+	is := assert.New(t)
+	sizer, err := newSampleSizer(AutoSampleRules{})
+	is.NoError(err)
+	is.False(sizer.enabled(), "Should be off without a confidence")
+	for _, tc := range testNewSampleSizerErrorCases {
+		_, err = newSampleSizer(tc)
+		is.Error(err, "%+v", tc)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const samplingHistoryFileName = "samplingHistory.json"
+
+// SamplingHistory remembers when each object was last downloaded and verified, so later runs can pick different objects.
+// It is keyed by bucket name, then object name.
+// LastDeepValidation is when each bucket was last validated and had its whole sample downloaded and verified.
+// Inventories hold each bucket's inventory snapshots, oldest first.
+type SamplingHistory struct {
+	Buckets            map[string]map[string]time.Time `json:"buckets"`
+	LastDeepValidation map[string]time.Time            `json:"last_deep_validation"`
+	Inventories        map[string][]InventorySnapshot  `json:"inventories"`
+}
+
+func getSamplingHistoryFilePath(config Config) string {
+	return filepath.Join(getStateDirectory(config), samplingHistoryFileName)
+}
+
+// loadSamplingHistory loads the history file, or starts an empty history if there isn't one yet.
+func loadSamplingHistory(filePath string) (history *SamplingHistory, err error) {
+	history = &SamplingHistory{}
+	contents, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		history.Buckets = make(map[string]map[string]time.Time)
+		return history, nil
+	}
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to open sampling history file at %s", filePath)
+		return
+	}
+	err = json.Unmarshal(contents, history)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to parse sampling history file at %s", filePath)
+		return
+	}
+	if history.Buckets == nil {
+		history.Buckets = make(map[string]map[string]time.Time)
+	}
+	return
+}
+
+func saveSamplingHistory(filePath string, history *SamplingHistory) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open sampling history file %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	return json.NewEncoder(jsonFile).Encode(history)
+}
+
+// recordVerified notes every file in the mapping was verified at the given time.
+func (h *SamplingHistory) recordVerified(mapping []BucketAndFiles, when time.Time) {
+	for _, bucketAndFiles := range mapping {
+		objects, found := h.Buckets[bucketAndFiles.BucketName]
+		if !found {
+			objects = make(map[string]time.Time)
+			h.Buckets[bucketAndFiles.BucketName] = objects
+		}
+		for _, file := range bucketAndFiles.Files {
+			objects[file] = when
+		}
+	}
+}
+
+// recentlySampled lists the objects in the bucket verified within the last memoryDays.
+// It is safe to call on a nil history, which hasn't sampled anything.
+func (h *SamplingHistory) recentlySampled(bucketName string, memoryDays int, now time.Time) map[string]bool {
+	if h == nil || memoryDays <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-time.Duration(memoryDays) * time.Hour * 24)
+	recent := make(map[string]bool)
+	for object, verified := range h.Buckets[bucketName] {
+		if verified.After(cutoff) {
+			recent[object] = true
+		}
+	}
+	return recent
+}
+
+// recordDeepValidation notes every bucket passed validation and had its whole sample verified at the given time.
+func (h *SamplingHistory) recordDeepValidation(buckets []BucketToProcess, when time.Time) {
+	if h.LastDeepValidation == nil {
+		h.LastDeepValidation = make(map[string]time.Time)
+	}
+	for _, bucket := range buckets {
+		h.LastDeepValidation[bucket.Name] = when
+	}
+}
+
+// getOverdueDeepValidations explains which buckets haven't passed a deep validation within maxDays, if any.
+// Nothing is overdue when maxDays isn't set.
+func (h *SamplingHistory) getOverdueDeepValidations(buckets []BucketToProcess, maxDays int, now time.Time) (overdue []string) {
+	if maxDays <= 0 {
+		return nil
+	}
+	for _, bucket := range buckets {
+		last, found := h.LastDeepValidation[bucket.Name]
+		if !found {
+			overdue = append(overdue, fmt.Sprintf("%s has never passed a deep validation", bucket.Name))
+			continue
+		}
+		ageInDays := int(now.Sub(last) / (time.Hour * 24)) //close enough, same as the freshness checks
+		if ageInDays >= maxDays {
+			overdue = append(overdue, fmt.Sprintf("%s last passed a deep validation %d days ago on %s", bucket.Name, ageInDays, formatTime(last)))
+		}
+	}
+	return
+}
+
+// checkDeepValidationAge fails when any bucket is overdue a deep validation, so a schedule that skips a bucket gets noticed.
+func checkDeepValidationAge(history *SamplingHistory, config Config, now time.Time) error {
+	overdue := history.getOverdueDeepValidations(config.Buckets, config.MaxDaysSinceDeepValidation, now)
+	if len(overdue) > 0 {
+		return errors.NotValidf("Deep validation schedule, %s", strings.Join(overdue, "; "))
+	}
+	return nil
+}
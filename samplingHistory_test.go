@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplingHistoryFile(t *testing.T) {
+	is := assert.New(t)
+	filePath := filepath.Join(t.TempDir(), samplingHistoryFileName)
+
+	history, err := loadSamplingHistory(filePath)
+	is.NoError(err, "Should start an empty history when there is no file yet")
+	is.Empty(history.Buckets)
+
+	verified := time.Date(2018, 1, 20, 1, 2, 3, 0, time.UTC)
+	history.recordVerified([]BucketAndFiles{{"test-matt-photos", []string{"2015-02/IMG_02.gif"}}}, verified)
+	is.NoError(saveSamplingHistory(filePath, history), "Should not error when saving history")
+
+	loaded, err := loadSamplingHistory(filePath)
+	is.NoError(err, "Should not error when loading saved history")
+	is.Equal(history, loaded, "Loaded history should match saved history")
+
+	os.WriteFile(filePath, []byte("{"), 0644)
+	_, err = loadSamplingHistory(filePath)
+	is.Error(err, "Should error when the history file cannot be parsed")
+
+	is.Error(saveSamplingHistory("", history), "Should error when saving to a blank path")
+}
+
+func TestSamplingHistoryRecentlySampled(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	history := &SamplingHistory{Buckets: make(map[string]map[string]time.Time)}
+	history.recordVerified([]BucketAndFiles{{"test-matt-photos", []string{"old.gif"}}}, now.AddDate(0, 0, -40))
+	history.recordVerified([]BucketAndFiles{{"test-matt-photos", []string{"new.gif"}}}, now.AddDate(0, 0, -5))
+
+	is.Equal(map[string]bool{"new.gif": true}, history.recentlySampled("test-matt-photos", 30, now))
+	is.Equal(2, len(history.recentlySampled("test-matt-photos", 60, now)))
+	is.Empty(history.recentlySampled("test-matt-media", 30, now), "Should not have sampled an unseen bucket")
+	is.Nil(history.recentlySampled("test-matt-photos", 0, now), "Should not avoid anything when memory is off")
+
+	var nilHistory *SamplingHistory
+	is.Nil(nilHistory.recentlySampled("test-matt-photos", 30, now), "Nil history should not avoid anything")
+}
+
+func TestPickRandomObjectNames(t *testing.T) {
+	is := assert.New(t)
+	objects := []*storage.ObjectAttrs{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	avoid := map[string]bool{"a": true, "b": true}
+
+	for i := 0; i < 20; i++ {
+		actual := pickRandomObjectNames(objects, 2, avoid)
+		is.ElementsMatch([]string{"c", "d"}, actual, "Should pick objects that haven't been sampled recently")
+	}
+
+	actual := pickRandomObjectNames(objects, 3, avoid)
+	is.Equal(3, len(actual))
+	is.Subset(actual, []string{"c", "d"}, "Should pick every object not sampled recently before the others")
+
+	is.Equal([]string{"a", "b", "c", "d"}, pickRandomObjectNames(objects, 4, nil), "Should return everything in order when picking the whole population")
+	is.Equal(1, len(pickRandomObjectNames(objects, 1, nil)))
+}
+
+func TestGetOverdueDeepValidations(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2018, 2, 20, 0, 0, 0, 0, time.UTC)
+	buckets := []BucketToProcess{{Name: "test-matt-media"}, {Name: "test-matt-photos"}, {Name: "test-matt-server-backups"}}
+	history := &SamplingHistory{}
+	history.recordDeepValidation(buckets[:1], time.Date(2018, 2, 15, 0, 0, 0, 0, time.UTC))
+	history.recordDeepValidation(buckets[1:2], time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC))
+
+	is.Empty(history.getOverdueDeepValidations(buckets, 0, now), "Should not check anything without a limit")
+	overdue := history.getOverdueDeepValidations(buckets, 30, now)
+	is.Equal(2, len(overdue))
+	is.Contains(overdue[0], "test-matt-photos last passed a deep validation 81 days ago")
+	is.Equal("test-matt-server-backups has never passed a deep validation", overdue[1])
+
+	config := Config{Buckets: buckets, MaxDaysSinceDeepValidation: 30}
+	is.Error(checkDeepValidationAge(history, config, now), "Should fail the run when buckets are overdue")
+	history.recordDeepValidation(buckets, now)
+	is.NoError(checkDeepValidationAge(history, config, now), "Should pass once every bucket has had a deep validation")
+}
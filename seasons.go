@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// pickSeasonStratifiedObjectNames picks num of a show's episodes, first one from each season, then the rest at random,
+// so a corrupt season can't hide behind a lucky sample. A season is the directory under the show, named by showPrefix,
+// and episodes directly under the show are a season of their own. When num is smaller than the number of seasons,
+// which seasons get an episode is random. Episodes named in avoid are only picked as they would be by pickRandomObjectNames.
+func pickSeasonStratifiedObjectNames(objects []*storage.ObjectAttrs, num int, showPrefix string, avoid map[string]bool) []string {
+	seasons := make(map[string][]*storage.ObjectAttrs)
+	for _, obj := range objects {
+		season := getSeason(obj.Name, showPrefix)
+		seasons[season] = append(seasons[season], obj)
+	}
+	var names []string
+	for season := range seasons {
+		names = append(names, season)
+	}
+	sort.Strings(names)
+
+	files := make([]string, 0, num)
+	picked := make(map[string]bool)
+	for _, i := range rand.Perm(len(names)) {
+		if len(files) >= num {
+			break
+		}
+		for _, name := range pickRandomObjectNames(seasons[names[i]], 1, avoid) {
+			files = append(files, name)
+			picked[name] = true
+		}
+	}
+	var rest []*storage.ObjectAttrs
+	for _, obj := range objects {
+		if !picked[obj.Name] {
+			rest = append(rest, obj)
+		}
+	}
+	return append(files, pickRandomObjectNames(rest, num-len(files), avoid)...)
+}
+
+// getSeason is the directory an episode is in under its show, or blank for episodes directly under the show.
+func getSeason(objectName string, showPrefix string) string {
+	season, _, found := strings.Cut(strings.TrimPrefix(objectName, showPrefix), "/")
+	if !found {
+		return ""
+	}
+	return season
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSeasonEpisodes = []*storage.ObjectAttrs{
+	{Name: "show 1/season 1/01x01 episode.ogv"},
+	{Name: "show 1/season 1/01x02 episode.ogv"},
+	{Name: "show 1/season 1/01x03 episode.ogv"},
+	{Name: "show 1/season 1/01x04 episode.ogv"},
+	{Name: "show 1/season 1/01x05 episode.ogv"},
+	{Name: "show 1/season 2/02x01 episode.ogv"},
+	{Name: "show 1/season 3/03x01 episode.ogv"},
+	{Name: "show 1/season 3/03x02 episode.ogv"},
+	{Name: "show 1/special.ogv"},
+}
+
+var testGetSeasonCases = []struct {
+	objectName, expected string
+}{
+	{"show 1/season 1/01x01 episode.ogv", "season 1"},
+	{"show 1/season 1/extras/making of.ogv", "season 1"},
+	{"show 1/special.ogv", ""},
+}
+
+func TestGetSeason(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetSeasonCases {
+		is.Equal(tc.expected, getSeason(tc.objectName, "show 1/"), "Season of %s", tc.objectName)
+	}
+}
+
+func TestPickSeasonStratifiedObjectNames(t *testing.T) {
+	is := assert.New(t)
+	for i := 0; i < 20; i++ {
+		picked := pickSeasonStratifiedObjectNames(testSeasonEpisodes, 5, "show 1/", nil)
+		is.Equal(5, len(picked))
+		seasons := make(map[string]bool)
+		unique := make(map[string]bool)
+		for _, name := range picked {
+			seasons[getSeason(name, "show 1/")] = true
+			unique[name] = true
+		}
+		is.Equal(4, len(seasons), "Should pick from every season, including episodes directly under the show")
+		is.Equal(5, len(unique), "Should not pick an episode twice")
+	}
+
+	picked := pickSeasonStratifiedObjectNames(testSeasonEpisodes, 2, "show 1/", nil)
+	is.NotEqual(getSeason(picked[0], "show 1/"), getSeason(picked[1], "show 1/"),
+		"Fewer picks than seasons should still come from different seasons")
+
+	avoid := map[string]bool{"show 1/season 3/03x01 episode.ogv": true}
+	for i := 0; i < 20; i++ {
+		picked = pickSeasonStratifiedObjectNames(testSeasonEpisodes, 4, "show 1/", avoid)
+		is.Contains(picked, "show 1/season 3/03x02 episode.ogv", "Should avoid recently sampled episodes within a season")
+	}
+	is.Equal(len(testSeasonEpisodes), len(pickSeasonStratifiedObjectNames(testSeasonEpisodes, 20, "show 1/", nil)))
+}
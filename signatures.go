@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/juju/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	signatureFormatMinisign = "minisign"
+	signatureFormatOpenpgp  = "openpgp"
+)
+
+// signatureVerifier checks a detached signature over the message was made by the configured key.
+type signatureVerifier interface {
+	verify(message io.Reader, signature []byte) error
+}
+
+// newSignatureVerifier loads the public key for the rule, so a bad key fails before anything is downloaded.
+func newSignatureVerifier(rule SignatureRule) (signatureVerifier, error) {
+	if !strings.Contains(rule.Companion, companionNamePlaceholder) {
+		return nil, errors.NotValidf("Signature companion %q, it must contain %s", rule.Companion, companionNamePlaceholder)
+	}
+	key, err := os.ReadFile(rule.PublicKeyFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to read signature public key %s", rule.PublicKeyFile)
+	}
+	switch rule.Format {
+	case signatureFormatMinisign:
+		return parseMinisignPublicKey(key)
+	case signatureFormatOpenpgp:
+		return parseOpenpgpPublicKey(key)
+	}
+	return nil, errors.NotSupportedf("Signature format %q", rule.Format)
+}
+
+// verifySignature checks a downloaded file against its signature companion in the bucket.
+// checked is false when the file has no signature companion, so unsigned files are not counted either way.
+func verifySignature(ctx context.Context, bucket BackupStore, rule SignatureRule, verifier signatureVerifier,
+	remoteFile string, localFile string) (checked bool, err error) {
+	companion := strings.ReplaceAll(rule.Companion, companionNamePlaceholder, remoteFile)
+	signature, err := readSmallObject(ctx, bucket, companion, maxCompanionSize)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return true, errors.Annotatef(err, "Unable to read signature %s", companion)
+	}
+	file, err := os.Open(localFile)
+	if err != nil {
+		return true, errors.Annotatef(err, "Unable to open %s to check its signature", localFile)
+	}
+	defer file.Close()
+	return true, verifier.verify(file, signature)
+}
+
+// recordSignatureResult adds the outcome of a signature check to the bucket summary in ctx, if there is one.
+func recordSignatureResult(ctx context.Context, file string, err error) {
+	if bs := bucketSummaryFromContext(ctx); bs != nil {
+		bs.SignaturesChecked++
+		if err != nil {
+			bs.SignatureFailures = append(bs.SignatureFailures, fmt.Sprintf("%s: %s", file, err.Error()))
+		}
+	}
+}
+
+type minisignPublicKey struct {
+	keyID []byte
+	key   ed25519.PublicKey
+}
+
+// parseMinisignPublicKey reads a minisign .pub file, or just the base64 key line from one.
+func parseMinisignPublicKey(contents []byte) (*minisignPublicKey, error) {
+	lines := getNonCommentLines(contents)
+	if len(lines) == 0 {
+		return nil, errors.NotValidf("Minisign public key, it is empty")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(decoded) != 2+8+ed25519.PublicKeySize || string(decoded[:2]) != "Ed" {
+		return nil, errors.NotValidf("Minisign public key")
+	}
+	return &minisignPublicKey{keyID: decoded[2:10], key: ed25519.PublicKey(decoded[10:])}, nil
+}
+
+// verify checks a minisign signature file, both the signature over the file and the global signature over the trusted comment.
+// Legacy Ed signatures sign the file itself, the default ED signatures sign its BLAKE2b-512 hash.
+func (pk *minisignPublicKey) verify(message io.Reader, signature []byte) error {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(string(signature)), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.NotValidf("Minisign signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.NotValidf("Minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], pk.keyID) {
+		return errors.NotValidf("Minisign signature key id %X, expected %X", sig[2:10], pk.keyID)
+	}
+
+	var signed []byte
+	switch string(sig[:2]) {
+	case "Ed":
+		signed, err = io.ReadAll(message)
+	case "ED":
+		hash, _ := blake2b.New512(nil)
+		_, err = io.Copy(hash, message)
+		signed = hash.Sum(nil)
+	default:
+		return errors.NotSupportedf("Minisign signature algorithm %q", sig[:2])
+	}
+	if err != nil {
+		return errors.Annotate(err, "Unable to read the signed file")
+	}
+	if !ed25519.Verify(pk.key, signed, sig[10:]) {
+		return errors.NotValidf("Minisign signature")
+	}
+
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(pk.key, append(sig[10:], trustedComment...), globalSig) {
+		return errors.NotValidf("Minisign trusted comment signature")
+	}
+	return nil
+}
+
+func getNonCommentLines(contents []byte) (lines []string) {
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "untrusted comment:") {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+type openpgpPublicKey struct {
+	keyring openpgp.EntityList
+}
+
+// parseOpenpgpPublicKey reads an armored or binary openpgp public key.
+func parseOpenpgpPublicKey(contents []byte) (*openpgpPublicKey, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(contents))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(contents))
+	}
+	if err != nil || len(keyring) == 0 {
+		return nil, errors.NotValidf("Openpgp public key")
+	}
+	return &openpgpPublicKey{keyring: keyring}, nil
+}
+
+// verify checks an armored or binary detached openpgp signature.
+func (pk *openpgpPublicKey) verify(message io.Reader, signature []byte) (err error) {
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(pk.keyring, message, bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(pk.keyring, message, bytes.NewReader(signature), nil)
+	}
+	if err != nil {
+		return errors.NewNotValid(err, "Openpgp signature not valid")
+	}
+	return nil
+}
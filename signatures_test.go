@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+// makeMinisignFiles builds a minisign public key file and a signature file for message, the way the minisign tool lays them out.
+func makeMinisignFiles(t *testing.T, message []byte, prehashed bool) (publicKey []byte, signature []byte, privateKey ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal("Could not generate a test key")
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	publicKey = []byte(fmt.Sprintf("untrusted comment: minisign public key 0807060504030201\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), pub...))))
+
+	algorithm, signed := "Ed", message
+	if prehashed {
+		hash := blake2b.Sum512(message)
+		algorithm, signed = "ED", hash[:]
+	}
+	sig := ed25519.Sign(priv, signed)
+	trustedComment := "timestamp:1516410123\tfile:backup.tar.gz"
+	globalSig := ed25519.Sign(priv, append(append([]byte{}, sig...), trustedComment...))
+	signature = []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), sig...)),
+		trustedComment, base64.StdEncoding.EncodeToString(globalSig)))
+	return publicKey, signature, priv
+}
+
+func TestMinisignVerify(t *testing.T) {
+	is := assert.New(t)
+	message := []byte("pretend this is a backup")
+	for _, prehashed := range []bool{true, false} {
+		publicKey, signature, _ := makeMinisignFiles(t, message, prehashed)
+		pk, err := parseMinisignPublicKey(publicKey)
+		is.NoError(err, "Should parse the public key")
+
+		is.NoError(pk.verify(bytes.NewReader(message), signature), "Should verify a good signature, prehashed %v", prehashed)
+		err = pk.verify(strings.NewReader("tampered backup"), signature)
+		is.True(errors.IsNotValid(err), "Should reject a tampered file, prehashed %v", prehashed)
+
+		tamperedComment := bytes.Replace(signature, []byte("backup.tar.gz"), []byte("other.tar.gz"), 1)
+		err = pk.verify(bytes.NewReader(message), tamperedComment)
+		is.True(errors.IsNotValid(err), "Should reject a tampered trusted comment, prehashed %v", prehashed)
+
+		otherKey, _, _ := makeMinisignFiles(t, message, prehashed)
+		other, err := parseMinisignPublicKey(otherKey)
+		is.NoError(err)
+		is.Error(other.verify(bytes.NewReader(message), signature), "Should reject a signature from another key")
+	}
+
+	_, err := parseMinisignPublicKey([]byte("untrusted comment: nothing else\n"))
+	is.Error(err, "Should error on an empty key")
+	_, err = parseMinisignPublicKey([]byte("bm90IGEga2V5"))
+	is.Error(err, "Should error on something that isn't a key")
+}
+
+func TestOpenpgpVerify(t *testing.T) {
+	is := assert.New(t)
+	entity, err := openpgp.NewEntity("Backups", "", "backups@example.com", nil)
+	if err != nil {
+		t.Fatal("Could not generate a test key")
+	}
+	var publicKey bytes.Buffer
+	keyWriter, _ := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	entity.Serialize(keyWriter)
+	keyWriter.Close()
+
+	message := []byte("pretend this is a backup")
+	var armoredSig, binarySig bytes.Buffer
+	is.NoError(openpgp.ArmoredDetachSign(&armoredSig, entity, bytes.NewReader(message), nil))
+	is.NoError(openpgp.DetachSign(&binarySig, entity, bytes.NewReader(message), nil))
+
+	pk, err := parseOpenpgpPublicKey(publicKey.Bytes())
+	is.NoError(err, "Should parse an armored public key")
+	is.NoError(pk.verify(bytes.NewReader(message), armoredSig.Bytes()), "Should verify an armored signature")
+	is.NoError(pk.verify(bytes.NewReader(message), binarySig.Bytes()), "Should verify a binary signature")
+	err = pk.verify(strings.NewReader("tampered backup"), armoredSig.Bytes())
+	is.True(errors.IsNotValid(err), "Should reject a tampered file")
+
+	_, err = parseOpenpgpPublicKey([]byte("not a key"))
+	is.Error(err, "Should error on something that isn't a key")
+}
+
+func TestNewSignatureVerifier(t *testing.T) {
+	is := assert.New(t)
+	publicKey, _, _ := makeMinisignFiles(t, nil, true)
+	keyPath := filepath.Join(t.TempDir(), "backups.pub")
+	os.WriteFile(keyPath, publicKey, 0644)
+
+	verifier, err := newSignatureVerifier(SignatureRule{Companion: "{name}.minisig", Format: "minisign", PublicKeyFile: keyPath})
+	is.NoError(err)
+	is.NotNil(verifier)
+
+	_, err = newSignatureVerifier(SignatureRule{Companion: "backup.minisig", Format: "minisign", PublicKeyFile: keyPath})
+	is.True(errors.IsNotValid(err), "Should error when the companion doesn't depend on the object name")
+	_, err = newSignatureVerifier(SignatureRule{Companion: "{name}.sig", Format: "x509", PublicKeyFile: keyPath})
+	is.True(errors.IsNotSupported(err), "Should error on an unknown format")
+	_, err = newSignatureVerifier(SignatureRule{Companion: "{name}.sig", Format: "minisign", PublicKeyFile: keyPath + ".missing"})
+	is.Error(err, "Should error when the key file doesn't exist")
+}
+
+func TestRecordSignatureResult(t *testing.T) {
+	is := assert.New(t)
+	bs := &BucketSummary{BucketName: "test-matt-server-backups"}
+	ctx := withBucketSummary(context.Background(), bs)
+	recordSignatureResult(ctx, "good.tar.gz", nil)
+	recordSignatureResult(ctx, "bad.tar.gz", errors.NotValidf("Minisign signature"))
+	is.Equal(2, bs.SignaturesChecked)
+	is.Equal([]string{"bad.tar.gz: Minisign signature not valid"}, bs.SignatureFailures)
+
+	var out bytes.Buffer
+	is.NoError(writeSummary(&out, &RunSummary{Buckets: []*BucketSummary{bs}}, "table"))
+	is.Contains(out.String(), "SIGNATURE FAILURES")
+	is.Contains(out.String(), "test-matt-server-backups/bad.tar.gz: Minisign signature not valid")
+}
+
+func TestDownloadFilesFromBucketFailsBadSignatures(t *testing.T) {
+	is := assert.New(t)
+	good, tampered := []byte("pretend this is a backup"), []byte("tampered backup")
+	publicKey, signature, _ := makeMinisignFiles(t, good, true)
+	keyPath := filepath.Join(t.TempDir(), "minisign.pub")
+	is.NoError(os.WriteFile(keyPath, publicKey, 0600))
+	bucket := newMemoryStore("test-matt-server-backups")
+	for name, content := range map[string][]byte{
+		"bad.tar.gz": tampered, "bad.tar.gz.minisig": signature, "good.tar.gz": good, "good.tar.gz.minisig": signature,
+	} {
+		bucket.put(name, content, time.Now())
+	}
+	bs := &BucketSummary{BucketName: "test-matt-server-backups"}
+	ctx := withBucketSummary(context.Background(), bs)
+	config := Config{FileDownloadLocation: t.TempDir(), Buckets: []BucketToProcess{{Name: "test-matt-server-backups", Type: "server-backup",
+		Signature: SignatureRule{Companion: "{name}.minisig", Format: "minisign", PublicKeyFile: keyPath}}}}
+
+	err := downloadFilesFromBucket(ctx, bucket, []string{"bad.tar.gz", "good.tar.gz"}, config)
+	is.True(errors.IsNotValid(err), "Should fail the bucket on a bad signature: %v", err)
+	is.ErrorContains(err, "bad.tar.gz")
+	is.Equal(2, bs.SignaturesChecked, "Should check the rest of the files before failing")
+	is.Len(bs.SignatureFailures, 1)
+
+	bs = &BucketSummary{BucketName: "test-matt-server-backups"}
+	err = downloadFilesFromBucket(withBucketSummary(context.Background(), bs), bucket, []string{"good.tar.gz"}, config)
+	is.NoError(err)
+	is.Equal(1, bs.SignaturesChecked)
+}
@@ -0,0 +1,34 @@
+package main
+
+import "path/filepath"
+
+// smokeMaxFileSizeBytes keeps a smoke run down to a minute or two even on a slow connection.
+const smokeMaxFileSizeBytes = 10 * 1024 * 1024
+
+// applySmokeOverrides shrinks the config for a quick end to end check of config and credential changes.
+// Every sample count that is switched on becomes 1 and files are capped at smokeMaxFileSizeBytes.
+// Smoke runs keep their state in a subdirectory so they can't be mistaken for, or resume, a full run.
+func applySmokeOverrides(config Config) Config {
+	rules := &config.FilesToDownload
+	for _, count := range []*int{&rules.ServerBackups, &rules.EpisodesFromEachShow, &rules.PhotosFromThisMonth, &rules.PhotosFromEachYear} {
+		if *count > 1 {
+			*count = 1
+		}
+	}
+	if rules.MaxFileSizeBytes <= 0 || rules.MaxFileSizeBytes > smokeMaxFileSizeBytes {
+		rules.MaxFileSizeBytes = smokeMaxFileSizeBytes
+	}
+	//automatic sizing would replace the single file counts
+	rules.AutoSample = AutoSampleRules{}
+	config.StateDirectory = filepath.Join(getStateDirectory(config), "smoke")
+	return config
+}
+
+// applyMaxFileSize caps sampled files at maxBytes from the command line, leaving the config's cap alone when it is 0.
+// Objects over the cap are never picked, so another object is sampled in their place.
+func applyMaxFileSize(config Config, maxBytes int64) Config {
+	if maxBytes > 0 {
+		config.FilesToDownload.MaxFileSizeBytes = maxBytes
+	}
+	return config
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySmokeOverrides(t *testing.T) {
+	is := assert.New(t)
+	config := Config{
+		StateDirectory: "state",
+		FilesToDownload: FileDownloadRules{
+			ServerBackups:        4,
+			EpisodesFromEachShow: 3,
+			PhotosFromThisMonth:  0,
+			PhotosFromEachYear:   10,
+		},
+		Buckets: []BucketToProcess{{Name: "bucket-one", Type: "media"}},
+	}
+	expected := FileDownloadRules{
+		ServerBackups:        1,
+		EpisodesFromEachShow: 1,
+		PhotosFromThisMonth:  0,
+		PhotosFromEachYear:   1,
+		MaxFileSizeBytes:     smokeMaxFileSizeBytes,
+	}
+	actual := applySmokeOverrides(config)
+	is.Equal(expected, actual.FilesToDownload, "Should shrink every sample that is switched on")
+	is.Equal(filepath.Join("state", "smoke"), actual.StateDirectory, "Should keep smoke state separate")
+	is.Equal(config.Buckets, actual.Buckets, "Should still check every bucket")
+	is.Equal(4, config.FilesToDownload.ServerBackups, "Should not change the original config")
+
+	config.FilesToDownload.MaxFileSizeBytes = 1024
+	actual = applySmokeOverrides(config)
+	is.Equal(int64(1024), actual.FilesToDownload.MaxFileSizeBytes, "Should keep a smaller configured cap")
+
+	config.FilesToDownload.AutoSample = AutoSampleRules{Confidence: 0.95, MarginOfError: 0.05}
+	is.Equal(AutoSampleRules{}, applySmokeOverrides(config).FilesToDownload.AutoSample, "Smoke runs should sample single files")
+}
+
+func TestApplyMaxFileSize(t *testing.T) {
+	is := assert.New(t)
+	config := Config{FilesToDownload: FileDownloadRules{MaxFileSizeBytes: 100}}
+	is.Equal(int64(100), applyMaxFileSize(config, 0).FilesToDownload.MaxFileSizeBytes, "Should keep the config's cap without a flag")
+	is.Equal(int64(50), applyMaxFileSize(config, 50).FilesToDownload.MaxFileSizeBytes)
+	is.Equal(int64(500), applyMaxFileSize(config, 500).FilesToDownload.MaxFileSizeBytes, "Should let the flag raise the cap")
+	is.Equal(int64(smokeMaxFileSizeBytes), applySmokeOverrides(applyMaxFileSize(config, 1<<40)).FilesToDownload.MaxFileSizeBytes,
+		"Smoke runs should still cap small files")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/iterator"
+)
+
+// ListingSnapshot is every bucket's listing as recorded by a run with --record-snapshot, for replaying validation
+// and sample selection offline with --from-snapshot. Objects are saved with the json api field names.
+type ListingSnapshot struct {
+	Recorded time.Time        `json:"recorded"`
+	Buckets  []BucketSnapshot `json:"buckets"`
+}
+
+// BucketSnapshot is one bucket's listing, only the objects under its prefix when it has one.
+type BucketSnapshot struct {
+	Name     string           `json:"name"`
+	Provider string           `json:"provider,omitempty"`
+	Prefix   string           `json:"prefix,omitempty"`
+	Location string           `json:"location,omitempty"`
+	Objects  []SnapshotObject `json:"objects"`
+}
+
+// SnapshotObject is the attributes validation and sampling use of one object.
+type SnapshotObject struct {
+	Name         string            `json:"name"`
+	Size         int64             `json:"size"`
+	Generation   int64             `json:"generation,omitempty"`
+	TimeCreated  time.Time         `json:"timeCreated"`
+	Updated      time.Time         `json:"updated"`
+	CRC32C       uint32            `json:"crc32c,omitempty"`
+	MD5Hash      []byte            `json:"md5Hash,omitempty"`
+	ContentType  string            `json:"contentType,omitempty"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	KMSKeyName   string            `json:"kmsKeyName,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+func newSnapshotObject(attrs *storage.ObjectAttrs) SnapshotObject {
+	return SnapshotObject{Name: attrs.Name, Size: attrs.Size, Generation: attrs.Generation, TimeCreated: attrs.Created.UTC(),
+		Updated: attrs.Updated.UTC(), CRC32C: attrs.CRC32C, MD5Hash: attrs.MD5, ContentType: attrs.ContentType,
+		StorageClass: attrs.StorageClass, KMSKeyName: attrs.KMSKeyName, Metadata: attrs.Metadata}
+}
+
+// attrs are the object's attributes with its times moved on by shift.
+func (o SnapshotObject) attrs(bucketName string, shift time.Duration) *storage.ObjectAttrs {
+	return &storage.ObjectAttrs{Bucket: bucketName, Name: o.Name, Size: o.Size, Generation: o.Generation,
+		Created: o.TimeCreated.Add(shift), Updated: o.Updated.Add(shift), CRC32C: o.CRC32C, MD5: o.MD5Hash,
+		ContentType: o.ContentType, StorageClass: o.StorageClass, KMSKeyName: o.KMSKeyName, Metadata: o.Metadata}
+}
+
+// recordListingSnapshot lists every bucket in the config in full, under its prefix, and saves the listings to filePath.
+func recordListingSnapshot(ctx context.Context, client *storage.Client, config Config, filePath string, now time.Time) (
+	snapshot *ListingSnapshot, err error) {
+	snapshot = &ListingSnapshot{Recorded: now.UTC()}
+	for _, bucketConfig := range config.Buckets {
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Recording listing of bucket %s.", bucketConfig.Name))
+		bucket, err := openBackupStore(ctx, client, config, bucketConfig)
+		if err != nil {
+			return nil, err
+		}
+		bucketSnapshot, err := getBucketSnapshot(withBucketPrefix(ctx, bucketConfig.Prefix), bucket, bucketConfig)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unable to record listing of bucket %s", bucketConfig.Name)
+		}
+		snapshot.Buckets = append(snapshot.Buckets, bucketSnapshot)
+	}
+	return snapshot, saveListingSnapshot(filePath, snapshot)
+}
+
+func getBucketSnapshot(ctx context.Context, bucket BackupStore, bucketConfig BucketToProcess) (bucketSnapshot BucketSnapshot, err error) {
+	bucketSnapshot = BucketSnapshot{Name: bucketConfig.Name, Provider: bucketConfig.Provider, Prefix: bucketConfig.Prefix}
+	bucketAttrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return
+	}
+	bucketSnapshot.Location = bucketAttrs.Location
+	it := listObjects(ctx, bucket, nil)
+	for {
+		objAttrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return bucketSnapshot, err
+		}
+		bucketSnapshot.Objects = append(bucketSnapshot.Objects, newSnapshotObject(objAttrs))
+	}
+	return bucketSnapshot, nil
+}
+
+func saveListingSnapshot(filePath string, snapshot *ListingSnapshot) error {
+	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	jsonFile, err := os.Create(filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to open listing snapshot file %s for saving data.", filePath)
+	}
+	defer jsonFile.Close()
+	return json.NewEncoder(jsonFile).Encode(snapshot)
+}
+
+func loadListingSnapshot(filePath string) (snapshot *ListingSnapshot, err error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to open listing snapshot file at %s", filePath)
+	}
+	snapshot = &ListingSnapshot{}
+	err = json.Unmarshal(contents, snapshot)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to parse listing snapshot file at %s", filePath)
+	}
+	for _, bucketSnapshot := range snapshot.Buckets {
+		objects := bucketSnapshot.Objects
+		sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	}
+	return
+}
+
+// replayedSnapshot is a snapshot being replayed at some later time. Object times are moved on by shift,
+// the time since it was recorded, so backups are as old as they were then and freshness checks judge them the same way.
+type replayedSnapshot struct {
+	snapshot *ListingSnapshot
+	shift    time.Duration
+}
+
+type listingSnapshotKey struct{}
+
+// withListingSnapshot attaches the snapshot to ctx, so buckets are opened from it instead of the storage provider, see openBackupStore.
+func withListingSnapshot(ctx context.Context, snapshot *ListingSnapshot, now time.Time) context.Context {
+	return context.WithValue(ctx, listingSnapshotKey{}, &replayedSnapshot{snapshot, now.Sub(snapshot.Recorded)})
+}
+
+func listingSnapshotFromContext(ctx context.Context) *replayedSnapshot {
+	replayed, _ := ctx.Value(listingSnapshotKey{}).(*replayedSnapshot)
+	return replayed
+}
+
+// bucket is the named bucket's recorded listing, an error if it wasn't recorded.
+func (r *replayedSnapshot) bucket(bucketName string) (BackupStore, error) {
+	for i := range r.snapshot.Buckets {
+		if r.snapshot.Buckets[i].Name == bucketName {
+			return snapshotStore{&r.snapshot.Buckets[i], r.shift}, nil
+		}
+	}
+	return nil, errors.NotFoundf("Bucket %s in the listing snapshot recorded on %s", bucketName, formatTime(r.snapshot.Recorded))
+}
+
+// snapshotStore is a bucket's recorded listing. Objects can be listed and their attributes looked up, but not read,
+// and there are no noncurrent versions to list.
+type snapshotStore struct {
+	bucket *BucketSnapshot
+	shift  time.Duration
+}
+
+func (s snapshotStore) BucketName() string {
+	return s.bucket.Name
+}
+
+func (s snapshotStore) Attrs(ctx context.Context) (*storage.BucketAttrs, error) {
+	return &storage.BucketAttrs{Name: s.bucket.Name, Location: s.bucket.Location}, nil
+}
+
+func (s snapshotStore) Objects(ctx context.Context, q *storage.Query) objectIterator {
+	it := &inventoryReportIterator{prefixesSeen: make(map[string]bool)}
+	if q != nil {
+		it.query = *q
+	}
+	if it.query.Versions {
+		return errorIterator{errors.NotSupportedf("Listing object versions of bucket %s from a listing snapshot", s.bucket.Name)}
+	}
+	for _, object := range s.bucket.Objects {
+		it.objects = append(it.objects, object.attrs(s.bucket.Name, s.shift))
+	}
+	return it
+}
+
+func (s snapshotStore) Object(name string) BackupObject {
+	return snapshotObject{s, name}
+}
+
+type snapshotObject struct {
+	store snapshotStore
+	name  string
+}
+
+func (o snapshotObject) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	objects := o.store.bucket.Objects
+	i := sort.Search(len(objects), func(i int) bool { return objects[i].Name >= o.name })
+	if i == len(objects) || objects[i].Name != o.name {
+		return nil, storage.ErrObjectNotExist
+	}
+	return objects[i].attrs(o.store.bucket.Name, o.store.shift), nil
+}
+
+func (o snapshotObject) Generation(gen int64) BackupObject {
+	return o
+}
+
+func (o snapshotObject) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	return o.NewRangeReader(ctx, 0, -1)
+}
+
+func (o snapshotObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	return nil, errors.NotSupportedf("Reading %s from a listing snapshot, it only has the listing", o.name)
+}
+
+// errorIterator fails every call to Next with err.
+type errorIterator struct {
+	err error
+}
+
+func (it errorIterator) Next() (*storage.ObjectAttrs, error) {
+	return nil, it.err
+}
+
+// replayListingSnapshot validates the buckets in the config and picks the sample from the snapshot at filePath, offline,
+// then prints what a run would have downloaded. Nothing is downloaded and no state is saved. Transfer jobs can't be checked
+// offline, so a bucket's transfer_job only gets a warning.
+func replayListingSnapshot(ctx context.Context, w io.Writer, config Config, filePath string, summary *RunSummary, now time.Time) (
+	mapping []BucketAndFiles, err error) {
+	snapshot, err := loadListingSnapshot(filePath)
+	if err != nil {
+		return
+	}
+	ctx = withListingSnapshot(ctx, snapshot, now)
+	ctx = withTransferRunLister(ctx, func(ctx context.Context, check TransferJobCheck) ([]TransferRun, error) {
+		return nil, errors.NotSupportedf("Listing transfer runs when replaying a listing snapshot")
+	})
+	fmt.Fprintln(w, fmt.Sprintf("Replaying the listings recorded on %s, nothing will be downloaded.", formatTime(snapshot.Recorded)))
+	_, err = validateBucketsInConfig(ctx, nil, config, summary)
+	if err != nil || config.ActiveProfile.SkipDownloads {
+		return
+	}
+	history, err := loadSamplingHistory(getSamplingHistoryFilePath(config))
+	if err != nil {
+		return
+	}
+	mapping, err = getObjectsToDownloadFromBucketsInConfig(ctx, nil, config, history, summary)
+	if err != nil {
+		return
+	}
+	writeReplayedSample(w, mapping)
+	return
+}
+
+func writeReplayedSample(w io.Writer, mapping []BucketAndFiles) {
+	for _, bucketAndFiles := range mapping {
+		fmt.Fprintf(w, "Would download %d files from bucket %s\n", len(bucketAndFiles.Files), bucketAndFiles.BucketName)
+		for _, file := range bucketAndFiles.Files {
+			fmt.Fprintf(w, "  %s\n", file)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+)
+
+func TestRecordListingSnapshot(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	recorded := time.Date(2026, time.October, 1, 6, 0, 0, 0, time.UTC)
+	server := newTestS3Server(map[string][]byte{
+		"nightly/2026-09-30.dump": []byte("older"), "nightly/2026-10-01.dump": []byte("newest"), "weekly/2026-09-27.dump": []byte("weekly"),
+	}, recorded.Add(-time.Hour))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	t.Setenv(envAWSAccessKeyID, testS3Credentials.accessKeyID)
+	t.Setenv(envAWSSecretAccessKey, testS3Credentials.secretAccessKey)
+	config := Config{S3: S3Settings{Region: "us-east-1", Endpoint: httpServer.URL, PathStyle: true},
+		Buckets: []BucketToProcess{{Name: "test-matt-server-backups", Provider: "s3", Prefix: "nightly/", Type: "server-backup"}}}
+	filePath := filepath.Join(t.TempDir(), "snapshots", "listing.json")
+
+	_, err := recordListingSnapshot(ctx, nil, config, filePath, recorded)
+	is.NoError(err)
+	snapshot, err := loadListingSnapshot(filePath)
+	is.NoError(err)
+	is.Equal(recorded, snapshot.Recorded)
+	is.Len(snapshot.Buckets, 1)
+	is.Equal("us-east-1", snapshot.Buckets[0].Location)
+	is.Equal("s3", snapshot.Buckets[0].Provider)
+	is.Len(snapshot.Buckets[0].Objects, 2, "Should only record objects under the bucket prefix")
+	is.Equal("nightly/2026-09-30.dump", snapshot.Buckets[0].Objects[0].Name)
+	is.Equal(int64(5), snapshot.Buckets[0].Objects[0].Size)
+
+	//replayed ten days later, with the objects just as old as when they were recorded
+	ctx = withListingSnapshot(ctx, snapshot, recorded.AddDate(0, 0, 10))
+	bucket, err := openBackupStore(ctx, nil, config, config.Buckets[0])
+	is.NoError(err)
+	is.Equal("test-matt-server-backups", bucket.BucketName())
+	it := bucket.Objects(ctx, &storage.Query{Prefix: "nightly/2026-10"})
+	attrs, err := it.Next()
+	is.NoError(err)
+	is.Equal("nightly/2026-10-01.dump", attrs.Name)
+	is.Equal(recorded.AddDate(0, 0, 10).Add(-time.Hour), attrs.Created)
+	_, err = it.Next()
+	is.Equal(iterator.Done, err)
+
+	attrs, err = bucket.Object("nightly/2026-09-30.dump").Attrs(ctx)
+	is.NoError(err)
+	is.Equal(int64(5), attrs.Size)
+	_, err = bucket.Object("nightly/missing.dump").Attrs(ctx)
+	is.Equal(storage.ErrObjectNotExist, err)
+	_, err = bucket.Object("nightly/2026-09-30.dump").NewReader(ctx)
+	is.True(errors.IsNotSupported(err), "Should error reading objects, only the listing is recorded")
+	_, err = bucket.Objects(ctx, &storage.Query{Versions: true}).Next()
+	is.True(errors.IsNotSupported(err))
+	_, err = openBackupStore(ctx, nil, config, BucketToProcess{Name: "test-matt-photos"})
+	is.True(errors.IsNotFound(err), "Should error on buckets that weren't recorded")
+
+	_, err = loadListingSnapshot(filepath.Join(t.TempDir(), "missing.json"))
+	is.Error(err)
+}
+
+func TestReplayListingSnapshot(t *testing.T) {
+	is := assert.New(t)
+	recorded := time.Now().AddDate(0, -3, 0).UTC()
+	snapshot := &ListingSnapshot{Recorded: recorded, Buckets: []BucketSnapshot{{Name: "test-matt-server-backups", Objects: []SnapshotObject{
+		{Name: "db/2026-06-01.dump", Size: 10, TimeCreated: recorded.AddDate(0, 0, -20), Updated: recorded.AddDate(0, 0, -20)},
+		{Name: "db/2026-06-20.dump", Size: 10, TimeCreated: recorded.AddDate(0, 0, -1), Updated: recorded.AddDate(0, 0, -1)},
+		{Name: "db/2026-06-21.dump", Size: 10, TimeCreated: recorded.Add(-time.Hour), Updated: recorded.Add(-time.Hour)},
+	}}}}
+	filePath := filepath.Join(t.TempDir(), "listing.json")
+	is.NoError(saveListingSnapshot(filePath, snapshot))
+	config := Config{StateDirectory: t.TempDir(),
+		ServerBackupRules: ServerFileValidationRules{OldestFileMaxAgeInDays: 30, NewestFileMaxAgeInDays: 2},
+		FilesToDownload:   FileDownloadRules{ServerBackups: 2},
+		Buckets:           []BucketToProcess{{Name: "test-matt-server-backups", Type: "server-backup"}}}
+
+	var out bytes.Buffer
+	summary := newRunSummary()
+	mapping, err := replayListingSnapshot(context.Background(), &out, config, filePath, summary, time.Now())
+	is.NoError(err, "Should judge the backups as they were when recorded")
+	is.Equal([]BucketAndFiles{{BucketName: "test-matt-server-backups", Files: []string{"db/2026-06-21.dump", "db/2026-06-20.dump"}}}, mapping)
+	is.Contains(out.String(), "nothing will be downloaded")
+	is.Contains(out.String(), "Would download 2 files from bucket test-matt-server-backups\n  db/2026-06-21.dump\n")
+	is.Equal(validationPassed, summary.bucket("test-matt-server-backups", "server-backup").ValidationResult)
+	_, err = os.Stat(getSamplingHistoryFilePath(config))
+	is.True(os.IsNotExist(err), "Should not save any state")
+
+	config.ServerBackupRules.OldestFileMaxAgeInDays = 10
+	_, err = replayListingSnapshot(context.Background(), io.Discard, config, filePath, newRunSummary(), time.Now())
+	is.True(errors.IsNotValid(err), "Should fail validation of the recorded listing")
+	is.Contains(err.Error(), "db/2026-06-01.dump")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	inProgressFileName = "downloadsInProgress.json"
+	stateLockFileName  = "validatebackups.lock"
+)
+
+// stateLock is written to the lock file so whoever finds it knows which run is holding the state directory.
+type stateLock struct {
+	RunID   string    `json:"run_id"`
+	Pid     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// getStateDirectory is where the in progress file and lock file are kept, the current directory unless configured.
+func getStateDirectory(config Config) string {
+	if len(config.StateDirectory) == 0 {
+		return "."
+	}
+	return config.StateDirectory
+}
+
+func getInProgressFilePath(config Config) string {
+	return filepath.Join(getStateDirectory(config), inProgressFileName)
+}
+
+// newRunID makes a sortable id for a run, e.g. 20180120T010203Z-1a2b.
+// The random suffix keeps runs started in the same second apart.
+func newRunID(now time.Time) string {
+	return fmt.Sprintf("%s-%04x", now.UTC().Format("20060102T150405Z"), rand.Intn(0x10000))
+}
+
+// acquireStateLock takes an exclusive lock on the lock file in stateDir so overlapping runs can't share the in progress file.
+// The lock is held by the operating system, so it goes away with the process even if the run crashes.
+// If another run holds the lock, it retries for up to wait before failing with an AlreadyExists error.
+// The returned release function drops the lock and should be deferred by the caller.
+func acquireStateLock(stateDir string, runID string, wait time.Duration) (release func() error, err error) {
+	err = os.MkdirAll(stateDir, os.ModePerm)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to create state directory %s", stateDir)
+		return
+	}
+	lockPath := filepath.Join(stateDir, stateLockFileName)
+	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to open lock file %s", lockPath)
+		return
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		locked, err2 := tryLockFile(lockFile)
+		if err2 != nil {
+			lockFile.Close()
+			err = errors.Annotatef(err2, "Unable to lock %s", lockPath)
+			return
+		}
+		if locked {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			lockFile.Close()
+			err = errors.AlreadyExistsf("Lock on %s held by %s", lockPath, describeStateLockHolder(lockPath))
+			return
+		}
+		time.Sleep(time.Second)
+	}
+
+	//record who holds the lock, purely for the benefit of whoever finds it locked
+	lockFile.Truncate(0)
+	lockFile.Seek(0, 0)
+	json.NewEncoder(lockFile).Encode(stateLock{RunID: runID, Pid: os.Getpid(), Started: time.Now()})
+
+	release = func() error {
+		unlockFile(lockFile)
+		return lockFile.Close()
+	}
+	return
+}
+
+func describeStateLockHolder(lockPath string) string {
+	contents, err := os.ReadFile(lockPath)
+	var existing stateLock
+	if err != nil || json.Unmarshal(contents, &existing) != nil {
+		return "an unknown run"
+	}
+	return fmt.Sprintf("run %s (pid %d, started %s)", existing.RunID, existing.Pid, formatTime(existing.Started))
+}
+
+// getInProgressStaleReasons explains why resuming the in progress run would be a bad idea, if it would be.
+// The run is stale when it downloads from buckets no longer in the config, was started with a different profile,
+// or was started more than MaxInProgressAgeInDays ago.
+func getInProgressStaleReasons(state InProgressState, config Config, now time.Time) (reasons []string) {
+	var missingBuckets []string
+	for _, bucketAndFiles := range state.Buckets {
+		_, err := getBucketValidationTypeFromNameAndConfig(bucketAndFiles.BucketName, config.Buckets)
+		if err != nil {
+			missingBuckets = append(missingBuckets, bucketAndFiles.BucketName)
+		}
+	}
+	if len(missingBuckets) > 0 {
+		reasons = append(reasons, fmt.Sprintf("buckets %s are no longer in the config", strings.Join(missingBuckets, ", ")))
+	}
+
+	//a profile that changes nothing samples the same as no profile, so a run under either can carry on the other's
+	if getEffectiveProfileName(config, state.Profile) != getEffectiveProfileName(config, config.ActiveProfile.Name) {
+		reasons = append(reasons, fmt.Sprintf("it was started with profile %q, not %q", state.Profile, config.ActiveProfile.Name))
+	}
+
+	if config.MaxInProgressAgeInDays > 0 && !state.Started.IsZero() {
+		ageInDays := int(now.Sub(state.Started) / (time.Hour * 24)) //close enough, same as the freshness checks
+		if ageInDays >= config.MaxInProgressAgeInDays {
+			reasons = append(reasons, fmt.Sprintf("it was started %d days ago on %s", ageInDays, formatTime(state.Started)))
+		}
+	}
+	return
+}
+
+// checkInProgressFile makes sure an in progress file left by an earlier run is safe to resume.
+// When it is stale or can't be loaded, the reasons are returned along with an error, unless discard is set,
+// in which case the file is removed, or trashed as trash_mode asks, so a new sample gets picked.
+func checkInProgressFile(filePath string, config Config, discard bool, now time.Time) (staleReasons []string, err error) {
+	fileInfo, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to check in progress file %s", filePath)
+	}
+
+	state, err := loadInProgressFile(filePath)
+	if err != nil {
+		staleReasons = append(staleReasons, "it could not be loaded")
+	} else {
+		if state.Started.IsZero() {
+			//saved before run ids were added, the file time is close enough
+			state.Started = fileInfo.ModTime()
+		}
+		staleReasons = getInProgressStaleReasons(state, config, now)
+	}
+
+	if discard {
+		err = removeRunArtifact(config, filePath, now)
+		if err != nil {
+			err = errors.Annotatef(err, "Unable to discard in progress file, delete %s manually", filePath)
+		}
+		return
+	}
+	if err != nil {
+		return staleReasons, errors.Annotatef(err, "Unable to load in progress file %s", filePath)
+	}
+	if len(staleReasons) > 0 {
+		err = errors.NotValidf("In progress file %s for run %s", filePath, state.RunID)
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetInProgressFilePath(t *testing.T) {
+	is := assert.New(t)
+	is.Equal(inProgressFileName, getInProgressFilePath(Config{}), "Should default to the current directory")
+	is.Equal(filepath.Join("state", inProgressFileName), getInProgressFilePath(Config{StateDirectory: "state"}))
+}
+
+func TestNewRunID(t *testing.T) {
+	is := assert.New(t)
+	actual := newRunID(time.Date(2018, 1, 20, 1, 2, 3, 0, time.UTC))
+	is.Regexp(regexp.MustCompile(`^20180120T010203Z-[0-9a-f]{4}$`), actual)
+}
+
+func TestAcquireStateLock(t *testing.T) {
+	is := assert.New(t)
+	stateDir := filepath.Join(t.TempDir(), "nested", "state")
+
+	firstRelease, err := acquireStateLock(stateDir, "first-run", 0)
+	is.NoError(err, "Should not error when nobody holds the lock")
+	_, err = os.Stat(filepath.Join(stateDir, stateLockFileName))
+	is.NoError(err, "Should create the lock file")
+
+	_, err = acquireStateLock(stateDir, "second-run", 0)
+	is.True(errors.IsAlreadyExists(err), "Should not be able to take a held lock")
+	is.Contains(err.Error(), "first-run", "Should say which run holds the lock")
+
+	//the lock gets released while the second run is waiting for it
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		firstRelease()
+	}()
+	release, err := acquireStateLock(stateDir, "second-run", 5*time.Second)
+	is.NoError(err, "Should be able to take the lock once released")
+	is.NoError(release(), "Should not error when releasing the lock")
+
+	//a leftover lock file from a crashed run does not block anything
+	release, err = acquireStateLock(stateDir, "third-run", 0)
+	is.NoError(err, "Should be able to take the lock when the lock file is left over")
+	release()
+}
+
+func TestGetInProgressStaleReasons(t *testing.T) {
+	is := assert.New(t)
+	now := time.Date(2018, 2, 20, 0, 0, 0, 0, time.UTC)
+	config := Config{
+		MaxInProgressAgeInDays: 14,
+		Buckets: []BucketToProcess{
+			{Name: "test-matt-media", Type: "media"},
+			{Name: "test-matt-photos", Type: "photo"},
+		},
+	}
+	state := InProgressState{
+		RunID:   "20180210T000000Z-1a2b",
+		Started: time.Date(2018, 2, 10, 0, 0, 0, 0, time.UTC),
+		Buckets: []BucketAndFiles{{BucketName: "test-matt-media"}, {BucketName: "test-matt-photos"}},
+	}
+	is.Empty(getInProgressStaleReasons(state, config, now), "Should resume a recent run of configured buckets")
+
+	state.Buckets = append(state.Buckets, BucketAndFiles{BucketName: "test-matt-removed"})
+	reasons := getInProgressStaleReasons(state, config, now)
+	is.Equal(1, len(reasons))
+	is.Contains(reasons[0], "test-matt-removed", "Should name the buckets missing from config")
+
+	state.Started = time.Date(2018, 1, 20, 0, 0, 0, 0, time.UTC)
+	is.Equal(2, len(getInProgressStaleReasons(state, config, now)), "Should report an old run as well as missing buckets")
+
+	config.MaxInProgressAgeInDays = 0
+	is.Equal(1, len(getInProgressStaleReasons(state, config, now)), "Should not check the age when no limit is configured")
+
+	state.Buckets = state.Buckets[:2]
+	config.ActiveProfile = RunProfile{Name: "audit"}
+	reasons = getInProgressStaleReasons(state, config, now)
+	is.Equal([]string{`it was started with profile "", not "audit"`}, reasons, "Should not resume a run from another profile")
+	state.Profile = "audit"
+	is.Empty(getInProgressStaleReasons(state, config, now))
+
+	config.Profiles = map[string]RunProfile{"default": {}}
+	config.ActiveProfile = RunProfile{Name: "default"}
+	state.Profile = ""
+	is.Empty(getInProgressStaleReasons(state, config, now), "Should resume a run without a profile under one that changes nothing")
+	config.ActiveProfile = RunProfile{}
+	state.Profile = "default"
+	is.Empty(getInProgressStaleReasons(state, config, now))
+}
+
+func TestCheckInProgressFile(t *testing.T) {
+	is := assert.New(t)
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, inProgressFileName)
+	now := time.Date(2018, 2, 20, 0, 0, 0, 0, time.UTC)
+	config := Config{Buckets: []BucketToProcess{{Name: "test-matt-media", Type: "media"}}}
+	state := InProgressState{
+		RunID:   "20180210T000000Z-1a2b",
+		Started: time.Date(2018, 2, 10, 0, 0, 0, 0, time.UTC),
+		Buckets: []BucketAndFiles{{BucketName: "test-matt-media"}},
+	}
+
+	reasons, err := checkInProgressFile(filePath, config, false, now)
+	is.NoError(err, "Should not error when there is no in progress file")
+	is.Empty(reasons)
+
+	saveInProgressFile(filePath, state)
+	reasons, err = checkInProgressFile(filePath, config, false, now)
+	is.NoError(err, "Should not error when the in progress file is fine to resume")
+	is.Empty(reasons)
+	_, err = os.Stat(filePath)
+	is.NoError(err, "Should keep an in progress file that is fine to resume")
+
+	config.Buckets = nil
+	reasons, err = checkInProgressFile(filePath, config, false, now)
+	is.Error(err, "Should error when the in progress file is stale")
+	is.Equal(1, len(reasons))
+
+	reasons, err = checkInProgressFile(filePath, config, true, now)
+	is.NoError(err, "Should not error when discarding a stale in progress file")
+	is.Equal(1, len(reasons))
+	_, err = os.Stat(filePath)
+	is.True(os.IsNotExist(err), "Should remove the discarded in progress file")
+
+	os.WriteFile(filePath, []byte("not json"), 0644)
+	_, err = checkInProgressFile(filePath, config, false, now)
+	is.Error(err, "Should error when the in progress file can't be loaded")
+	_, err = checkInProgressFile(filePath, config, true, now)
+	is.NoError(err, "Should not error when discarding an in progress file that can't be loaded")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// retrievalCostPerGiB is what reading an object back costs by storage class, in US dollars.
+// Standard and the legacy regional and multi regional classes cost nothing to read.
+var retrievalCostPerGiB = map[string]float64{
+	"NEARLINE": 0.01,
+	"COLDLINE": 0.02,
+	"ARCHIVE":  0.05,
+}
+
+func getRetrievalCost(objAttrs *storage.ObjectAttrs) float64 {
+	return retrievalCostPerGiB[strings.ToUpper(objAttrs.StorageClass)] * float64(objAttrs.Size) / (1 << 30)
+}
+
+// preferCheapestClasses narrows the candidates to the cheapest storage classes to read that still hold num objects,
+// so a sample comes from standard storage when it has enough objects, and only reaches into colder classes when it must.
+func preferCheapestClasses(objects []*storage.ObjectAttrs, num int) []*storage.ObjectAttrs {
+	byCost := make(map[float64][]*storage.ObjectAttrs)
+	for _, obj := range objects {
+		cost := retrievalCostPerGiB[strings.ToUpper(obj.StorageClass)]
+		byCost[cost] = append(byCost[cost], obj)
+	}
+	var costs []float64
+	for cost := range byCost {
+		costs = append(costs, cost)
+	}
+	sort.Float64s(costs)
+	var cheapest []*storage.ObjectAttrs
+	for _, cost := range costs {
+		if len(cheapest) >= num {
+			break
+		}
+		cheapest = append(cheapest, byCost[cost]...)
+	}
+	return cheapest
+}
+
+// recordSampleClasses counts the storage classes of the sampled objects named, and adds up their estimated retrieval cost.
+func recordSampleClasses(ctx context.Context, objects []*storage.ObjectAttrs, names []string) {
+	bs := bucketSummaryFromContext(ctx)
+	if bs == nil || len(names) == 0 {
+		return
+	}
+	sampled := make(map[string]bool)
+	for _, name := range names {
+		sampled[name] = true
+	}
+	if bs.SampleClasses == nil {
+		bs.SampleClasses = make(map[string]int)
+	}
+	for _, obj := range objects {
+		if !sampled[obj.Name] {
+			continue
+		}
+		class := obj.StorageClass
+		if len(class) == 0 {
+			class = "STANDARD"
+		}
+		bs.SampleClasses[class]++
+		bs.SampleRetrievalCost += getRetrievalCost(obj)
+	}
+}
+
+// getSampleClassSection lists the storage class mix of each bucket's sample, with its estimated retrieval cost.
+func getSampleClassSection(rs *RunSummary) (section summarySection) {
+	section.heading = "Sample storage classes"
+	for _, bs := range rs.Buckets {
+		if len(bs.SampleClasses) == 0 {
+			continue
+		}
+		classes := make([]string, 0, len(bs.SampleClasses))
+		for class := range bs.SampleClasses {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		counts := make([]string, len(classes))
+		for i, class := range classes {
+			counts[i] = fmt.Sprintf("%s %d", class, bs.SampleClasses[class])
+		}
+		section.items = append(section.items, summaryItem{text: fmt.Sprintf("%s: %s, estimated retrieval cost $%.2f",
+			bs.BucketName, strings.Join(counts, ", "), bs.SampleRetrievalCost)})
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var testStorageClassObjects = []*storage.ObjectAttrs{
+	{Name: "a", StorageClass: "STANDARD", Size: 1 << 30},
+	{Name: "b", StorageClass: "REGIONAL", Size: 1 << 30},
+	{Name: "c", StorageClass: "NEARLINE", Size: 1 << 30},
+	{Name: "d", StorageClass: "COLDLINE", Size: 1 << 30},
+	{Name: "e", StorageClass: "ARCHIVE", Size: 1 << 30},
+	{Name: "f", StorageClass: "ARCHIVE", Size: 1 << 30},
+}
+
+var testPreferCheapestClassesCases = []struct {
+	num      int
+	expected []string
+}{
+	{1, []string{"a", "b"}},
+	{2, []string{"a", "b"}},
+	{3, []string{"a", "b", "c"}},
+	{5, []string{"a", "b", "c", "d", "e", "f"}},
+}
+
+func TestPreferCheapestClasses(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testPreferCheapestClassesCases {
+		var names []string
+		for _, obj := range preferCheapestClasses(testStorageClassObjects, tc.num) {
+			names = append(names, obj.Name)
+		}
+		is.ElementsMatch(tc.expected, names, "Candidates for a sample of %d", tc.num)
+	}
+}
+
+func TestGetRetrievalCost(t *testing.T) {
+	is := assert.New(t)
+	is.Equal(0.0, getRetrievalCost(testStorageClassObjects[0]))
+	is.InDelta(0.05, getRetrievalCost(testStorageClassObjects[4]), 0.0001)
+	is.InDelta(0.01, getRetrievalCost(&storage.ObjectAttrs{StorageClass: "nearline", Size: 1 << 30}), 0.0001)
+}
+
+func TestRecordSampleClasses(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	bs := summary.bucket("test-matt-media", "media")
+	ctx := withBucketSummary(context.Background(), bs)
+	recordSampleClasses(ctx, append(testStorageClassObjects, &storage.ObjectAttrs{Name: "g"}), []string{"a", "d", "e", "f", "g"})
+	is.Equal(map[string]int{"STANDARD": 2, "COLDLINE": 1, "ARCHIVE": 2}, bs.SampleClasses)
+	is.InDelta(0.12, bs.SampleRetrievalCost, 0.0001)
+	recordSampleClasses(context.Background(), testStorageClassObjects, []string{"a"})
+
+	var buf bytes.Buffer
+	writeSummarySection(&buf, getSampleClassSection(summary))
+	is.Equal("\nSample storage classes:\ntest-matt-media: ARCHIVE 2, COLDLINE 1, STANDARD 2, estimated retrieval cost $0.12\n", buf.String())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// storageProviders are the URI schemes buckets can be named with. Google Cloud Storage and Amazon S3 are supported,
+// the others are recognised so they fail with a clear message.
+var storageProviders = map[string]bool{
+	"gs": true,
+	"s3": true,
+	"az": false,
+}
+
+// StorageURI is a bucket named the gcloud way, e.g. gs://bucket/prefix. A plain bucket name is a gs URI without a prefix.
+type StorageURI struct {
+	Provider string
+	Bucket   string
+	Prefix   string
+}
+
+func parseStorageURI(value string) (uri StorageURI, err error) {
+	scheme, rest, found := strings.Cut(value, "://")
+	if !found {
+		scheme, rest = "gs", value
+	}
+	supported, known := storageProviders[strings.ToLower(scheme)]
+	if !known {
+		return uri, errors.NotValidf("Storage URI %q, expected gs://bucket/prefix", value)
+	}
+	if !supported {
+		return uri, errors.NotSupportedf("Storage provider %s in %q, only gs and s3 buckets", scheme, value)
+	}
+	uri.Provider = strings.ToLower(scheme)
+	uri.Bucket, uri.Prefix, _ = strings.Cut(rest, "/")
+	if len(uri.Bucket) == 0 {
+		return uri, errors.NotValidf("Storage URI %q without a bucket", value)
+	}
+	return
+}
+
+func (u StorageURI) String() string {
+	if len(u.Prefix) == 0 {
+		return u.Provider + "://" + u.Bucket
+	}
+	return u.Provider + "://" + u.Bucket + "/" + u.Prefix
+}
+
+// normalizeBucketNames splits buckets named with a URI into the bucket name, provider and the prefix to look under.
+// A prefix can be given in the URI or with prefix, not both, and a provider in the URI has to match provider if that is set too.
+// A bucket can only be listed once, even under different prefixes, as its config, summary and history are looked up by its name.
+func normalizeBucketNames(config Config) (Config, error) {
+	buckets := make([]BucketToProcess, len(config.Buckets))
+	listedAs := make(map[string]string)
+	for i, bucket := range config.Buckets {
+		uri, err := parseStorageURI(bucket.Name)
+		if err != nil {
+			return config, errors.Annotatef(err, "Invalid name for bucket %d", i+1)
+		}
+		if len(uri.Prefix) > 0 && len(bucket.Prefix) > 0 && uri.Prefix != bucket.Prefix {
+			return config, errors.NotValidf("Bucket %s with prefix %q in its name and %q in prefix", bucket.Name, uri.Prefix, bucket.Prefix)
+		}
+		if len(bucket.Provider) > 0 {
+			supported, known := storageProviders[bucket.Provider]
+			if !known || !supported {
+				return config, errors.NotSupportedf("Storage provider %s of bucket %s, only gs and s3 buckets", bucket.Provider, bucket.Name)
+			}
+		}
+		if hasStorageScheme(bucket.Name) {
+			if len(bucket.Provider) > 0 && bucket.Provider != uri.Provider {
+				return config, errors.NotValidf("Bucket %s with provider %s in its name and %s in provider", bucket.Name, uri.Provider, bucket.Provider)
+			}
+			bucket.Provider = uri.Provider
+		}
+		if bucket.Provider == "s3" {
+			err = validateS3Bucket(bucket)
+			if err != nil {
+				return config, err
+			}
+		}
+		if earlier, found := listedAs[uri.Bucket]; found {
+			return config, errors.NotValidf("Bucket %s listed twice, as %s and %s, each bucket can only be listed once",
+				uri.Bucket, earlier, bucket.Name)
+		}
+		listedAs[uri.Bucket] = bucket.Name
+		bucket.Name = uri.Bucket
+		if len(uri.Prefix) > 0 {
+			bucket.Prefix = uri.Prefix
+		}
+		buckets[i] = bucket
+	}
+	config.Buckets = buckets
+	return config, nil
+}
+
+func hasStorageScheme(value string) bool {
+	return strings.Contains(value, "://")
+}
+
+// getBucketConfigFromURI finds the configured bucket a URI names, looking under the URI's prefix if it has one.
+// A plain bucket name matches a bucket on any provider, a URI only one on its own provider.
+func getBucketConfigFromURI(value string, buckets []BucketToProcess) (bucketConfig BucketToProcess, err error) {
+	uri, err := parseStorageURI(value)
+	if err != nil {
+		return
+	}
+	bucketConfig, err = getBucketConfigFromNameAndConfig(uri.Bucket, buckets)
+	if err != nil {
+		return
+	}
+	provider := bucketConfig.Provider
+	if len(provider) == 0 {
+		provider = "gs"
+	}
+	if hasStorageScheme(value) && provider != uri.Provider {
+		return bucketConfig, errors.NotFoundf("Bucket %s on %s, it is a %s bucket in the config", uri.Bucket, uri.Provider, provider)
+	}
+	if len(uri.Prefix) > 0 {
+		bucketConfig.Prefix = uri.Prefix
+	}
+	return
+}
+
+type bucketPrefixKey struct{}
+
+// withBucketPrefix attaches the prefix the bucket is configured with to ctx, so listing only looks under it.
+func withBucketPrefix(ctx context.Context, prefix string) context.Context {
+	if len(prefix) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, bucketPrefixKey{}, prefix)
+}
+
+func bucketPrefixFromContext(ctx context.Context) string {
+	prefix, _ := ctx.Value(bucketPrefixKey{}).(string)
+	return prefix
+}
+
+// scopeQuery puts the query under the bucket prefix in ctx, if there is one.
+// Query prefixes that came from an earlier listing already start with the bucket prefix, and are left as they are.
+func scopeQuery(ctx context.Context, q *storage.Query) *storage.Query {
+	prefix := bucketPrefixFromContext(ctx)
+	if len(prefix) == 0 {
+		return q
+	}
+	var scoped storage.Query
+	if q != nil {
+		scoped = *q
+	}
+	if !strings.HasPrefix(scoped.Prefix, prefix) {
+		scoped.Prefix = prefix + scoped.Prefix
+	}
+	return &scoped
+}
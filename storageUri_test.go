@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testParseStorageURICases = []struct {
+	value    string
+	expected StorageURI
+}{
+	{"test-matt-photos", StorageURI{"gs", "test-matt-photos", ""}},
+	{"gs://test-matt-photos", StorageURI{"gs", "test-matt-photos", ""}},
+	{"gs://test-matt-photos/", StorageURI{"gs", "test-matt-photos", ""}},
+	{"GS://test-matt-photos/2018-01/", StorageURI{"gs", "test-matt-photos", "2018-01/"}},
+	{"gs://test-matt-server-backups/nightly/db", StorageURI{"gs", "test-matt-server-backups", "nightly/db"}},
+	{"s3://test-matt-photos/2018-01/", StorageURI{"s3", "test-matt-photos", "2018-01/"}},
+}
+
+func TestParseStorageURI(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testParseStorageURICases {
+		actual, err := parseStorageURI(tc.value)
+		is.NoError(err, "Should not error parsing %s", tc.value)
+		is.Equal(tc.expected, actual, "Should parse %s", tc.value)
+	}
+
+	_, err := parseStorageURI("az://test-matt-photos")
+	is.True(errors.IsNotSupported(err), "Should error on azure buckets")
+	_, err = parseStorageURI("http://test-matt-photos")
+	is.True(errors.IsNotValid(err), "Should error on unknown schemes")
+	_, err = parseStorageURI("gs:///photos")
+	is.True(errors.IsNotValid(err), "Should error without a bucket")
+}
+
+func TestStorageURIString(t *testing.T) {
+	is := assert.New(t)
+	is.Equal("gs://test-matt-photos", StorageURI{"gs", "test-matt-photos", ""}.String())
+	is.Equal("gs://test-matt-photos/2018-01/", StorageURI{"gs", "test-matt-photos", "2018-01/"}.String())
+}
+
+func TestNormalizeBucketNames(t *testing.T) {
+	is := assert.New(t)
+	config := Config{Buckets: []BucketToProcess{
+		{Name: "test-matt-photos", Type: "photo"},
+		{Name: "gs://test-matt-server-backups/nightly/", Type: "server-backup"},
+		{Name: "test-matt-media", Type: "media", Prefix: "shows/"},
+		{Name: "s3://test-matt-archive/photos/", Type: "photo"},
+		{Name: "test-matt-documents", Type: "media", Provider: "s3"},
+	}}
+	actual, err := normalizeBucketNames(config)
+	is.NoError(err)
+	is.Equal([]BucketToProcess{
+		{Name: "test-matt-photos", Type: "photo"},
+		{Name: "test-matt-server-backups", Type: "server-backup", Prefix: "nightly/", Provider: "gs"},
+		{Name: "test-matt-media", Type: "media", Prefix: "shows/"},
+		{Name: "test-matt-archive", Type: "photo", Prefix: "photos/", Provider: "s3"},
+		{Name: "test-matt-documents", Type: "media", Provider: "s3"},
+	}, actual.Buckets)
+	is.Equal("gs://test-matt-server-backups/nightly/", config.Buckets[1].Name, "Should not change the original config")
+
+	_, err = normalizeBucketNames(Config{Buckets: []BucketToProcess{{Name: "gs://test-matt-media/shows/", Prefix: "movies/"}}})
+	is.True(errors.IsNotValid(err), "Should error when the prefix is given twice")
+	_, err = normalizeBucketNames(Config{Buckets: []BucketToProcess{{Name: "az://test-matt-media"}}})
+	is.True(errors.IsNotSupported(err), "Should error on other storage providers")
+	_, err = normalizeBucketNames(Config{Buckets: []BucketToProcess{{Name: "test-matt-media", Provider: "az"}}})
+	is.True(errors.IsNotSupported(err), "Should error on other storage providers given as provider")
+	_, err = normalizeBucketNames(Config{Buckets: []BucketToProcess{{Name: "gs://test-matt-media", Provider: "s3"}}})
+	is.True(errors.IsNotValid(err), "Should error when the provider is given twice")
+
+	_, err = normalizeBucketNames(Config{Buckets: []BucketToProcess{{Name: "gs://test-matt-media/shows/"}, {Name: "gs://test-matt-media/movies/"}}})
+	is.True(errors.IsNotValid(err), "Should error when a bucket is listed twice under different prefixes")
+	is.ErrorContains(err, "gs://test-matt-media/shows/ and gs://test-matt-media/movies/")
+	_, err = normalizeBucketNames(Config{Buckets: []BucketToProcess{{Name: "test-matt-media", Type: "media"}, {Name: "test-matt-media", Type: "photo"}}})
+	is.True(errors.IsNotValid(err), "Should error when a bucket is listed twice")
+}
+
+func TestGetBucketConfigFromURI(t *testing.T) {
+	is := assert.New(t)
+	buckets := []BucketToProcess{{Name: "test-matt-photos", Type: "photo"}, {Name: "test-matt-archive", Type: "photo", Provider: "s3"}}
+	actual, err := getBucketConfigFromURI("test-matt-archive", buckets)
+	is.NoError(err)
+	is.Equal(buckets[1], actual, "Should match a plain name on any provider")
+	actual, err = getBucketConfigFromURI("s3://test-matt-archive/2018-01/", buckets)
+	is.NoError(err)
+	is.Equal("2018-01/", actual.Prefix)
+	actual, err = getBucketConfigFromURI("gs://test-matt-photos", buckets)
+	is.NoError(err)
+	is.Equal(buckets[0], actual)
+
+	_, err = getBucketConfigFromURI("gs://test-matt-archive", buckets)
+	is.True(errors.IsNotFound(err), "Should error when the bucket is on another provider")
+	_, err = getBucketConfigFromURI("test-matt-missing", buckets)
+	is.True(errors.IsNotFound(err))
+}
+
+func TestScopeQuery(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	q := &storage.Query{Prefix: "2018-01/"}
+	is.Equal(q, scopeQuery(ctx, q), "Should leave the query alone without a bucket prefix")
+	is.Nil(scopeQuery(ctx, nil))
+
+	ctx = withBucketPrefix(ctx, "photos/")
+	is.Equal("photos/", scopeQuery(ctx, nil).Prefix)
+	is.Equal("photos/2018-01/", scopeQuery(ctx, q).Prefix, "Should put the query under the bucket prefix")
+	is.Equal("2018-01/", q.Prefix, "Should not change the original query")
+	is.Equal("photos/2018-01/", scopeQuery(ctx, &storage.Query{Prefix: "photos/2018-01/"}).Prefix,
+		"Should not prefix a query that is already under the bucket prefix")
+	is.Equal("/", scopeQuery(ctx, &storage.Query{Delimiter: "/"}).Delimiter)
+}
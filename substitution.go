@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// Substitution records a sampled object that had gone missing by download time, and the object downloaded in its place.
+type Substitution struct {
+	Missing     string `json:"missing"`
+	Replacement string `json:"replacement"`
+}
+
+// getSamplingFilter combines the size cap and the bucket's sample filters into the filter objects must pass to be sampled.
+func getSamplingFilter(config Config, bucketConfig BucketToProcess, now time.Time) (objectFilter, error) {
+	sampleFilter, err := newSampleFilterChain(bucketConfig.SampleFilters, now)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Invalid sample filters for bucket %s", bucketConfig.Name)
+	}
+	return chainObjectFilters(maxFileSizeFilter(config.FilesToDownload.MaxFileSizeBytes), sampleFilter), nil
+}
+
+// getSubstitutePrefix is the directory of an object name including its slash, e.g. the show or month it belongs to,
+// or blank for objects at the top of the bucket.
+func getSubstitutePrefix(name string) string {
+	return name[:strings.LastIndex(name, "/")+1]
+}
+
+// pickSubstituteObject picks a random object to download in place of the missing one, from the same prefix.
+// It has to pass the same filters as the original sample, and can't already be in the sample.
+func pickSubstituteObject(ctx context.Context, bucket BackupStore, config Config, bucketConfig BucketToProcess,
+	missing string, sample []string) (replacement string, err error) {
+	filter, err := getSamplingFilter(config, bucketConfig, time.Now())
+	if err != nil {
+		return
+	}
+	inSample := make(map[string]bool, len(sample))
+	for _, name := range sample {
+		inSample[name] = true
+	}
+	notInSample := func(objAttrs *storage.ObjectAttrs) bool { return !inSample[objAttrs.Name] }
+	options := samplingOptions{filter: chainObjectFilters(filter, notInSample)}
+
+	picked, err := getRandomFilesFromBucket(ctx, bucket, 1, getSubstitutePrefix(missing), options)
+	if err != nil {
+		return "", errors.Annotatef(err, "Unable to pick a substitute for %s", missing)
+	}
+	if len(picked) == 0 {
+		return "", errors.NotFoundf("Substitute for %s", missing)
+	}
+	return picked[0], nil
+}
+
+// recordSubstitution notes the substitution on the bucket summary in ctx, if there is one.
+func recordSubstitution(ctx context.Context, missing string, replacement string) {
+	if bs := bucketSummaryFromContext(ctx); bs != nil {
+		bs.Substitutions = append(bs.Substitutions, Substitution{Missing: missing, Replacement: replacement})
+	}
+}
+
+// getSubstitutionSection lists every sampled object that was replaced because it had gone missing.
+func getSubstitutionSection(rs *RunSummary) (section summarySection) {
+	section.heading = "Missing objects replaced with substitutes"
+	for _, bs := range rs.Buckets {
+		for _, substitution := range bs.Substitutions {
+			section.items = append(section.items, summaryItem{text: fmt.Sprintf("%s/%s replaced by %s",
+				bs.BucketName, substitution.Missing, substitution.Replacement)})
+		}
+	}
+	return
+}
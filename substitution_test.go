@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testGetSubstitutePrefixCases = []struct {
+	name     string
+	expected string
+}{
+	{name: "show-one/episode1.mkv", expected: "show-one/"},
+	{name: "2018-01/IMG_01.jpg", expected: "2018-01/"},
+	{name: "show/season 1/episode1.mkv", expected: "show/season 1/"},
+	{name: "backup.tar.gz", expected: ""},
+}
+
+func TestGetSubstitutePrefix(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testGetSubstitutePrefixCases {
+		is.Equal(tc.expected, getSubstitutePrefix(tc.name), tc.name)
+	}
+}
+
+func TestPickSubstituteObject(t *testing.T) {
+	is := assert.New(t)
+	report, err := loadInventoryReport("testdata/inventory.csv")
+	is.NoError(err)
+	ctx := withInventoryReport(context.Background(), report)
+	bucketConfig := BucketToProcess{Name: "test-matt-media", Type: "media"}
+
+	replacement, err := pickSubstituteObject(ctx, nil, Config{}, bucketConfig,
+		"show-one/episode3.mkv", []string{"show-one/episode1.mkv", "show-one/episode3.mkv"})
+	is.NoError(err)
+	is.Equal("show-one/episode2.mkv", replacement, "Should pick an object from the same show that isn't in the sample")
+
+	_, err = pickSubstituteObject(ctx, nil, Config{}, bucketConfig,
+		"show-one/episode3.mkv", []string{"show-one/episode1.mkv", "show-one/episode2.mkv"})
+	is.Error(err, "Should error when every object under the prefix is already in the sample")
+
+	_, err = pickSubstituteObject(ctx, nil, Config{FilesToDownload: FileDownloadRules{MaxFileSizeBytes: 100}}, bucketConfig,
+		"show-one/episode3.mkv", nil)
+	is.Error(err, "Should only pick objects that pass the sampling filters")
+
+	bucketConfig.SampleFilters = []SampleFilter{{Type: "colour"}}
+	_, err = pickSubstituteObject(ctx, nil, Config{}, bucketConfig, "show-one/episode3.mkv", nil)
+	is.Error(err, "Should error on invalid sample filters")
+}
+
+func TestGetSamplingFilter(t *testing.T) {
+	is := assert.New(t)
+	filter, err := getSamplingFilter(Config{}, BucketToProcess{}, time.Now())
+	is.NoError(err)
+	is.Nil(filter, "Should not filter anything without a cap or sample filters")
+}
+
+func TestWriteSubstitutions(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	bs := summary.bucket("test-matt-photos", "photo")
+	ctx := withBucketSummary(context.Background(), bs)
+	recordSubstitution(ctx, "2018-01/IMG_01.jpg", "2018-01/IMG_07.jpg")
+	recordSubstitution(context.Background(), "2018-01/IMG_02.jpg", "2018-01/IMG_08.jpg")
+	is.Equal([]Substitution{{Missing: "2018-01/IMG_01.jpg", Replacement: "2018-01/IMG_07.jpg"}}, bs.Substitutions)
+
+	var table bytes.Buffer
+	is.NoError(writeSummary(&table, summary, "table"))
+	is.Contains(table.String(), "test-matt-photos/2018-01/IMG_01.jpg replaced by 2018-01/IMG_07.jpg")
+
+	table.Reset()
+	writeSummarySection(&table, getSubstitutionSection(newRunSummary()))
+	is.Empty(table.String(), "Should not print a heading without substitutions")
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+const (
+	validationNotRun = "not run"
+	validationPassed = "passed"
+	validationFailed = "failed"
+)
+
+// RunSummary collects a BucketSummary for every bucket touched during a run, in the order they were first seen.
+// Generated is when the summary was written out as json, for telling how stale each bucket's newest object was then.
+// Warnings are about the run as a whole, those about a bucket are kept in its summary.
+// APICalls counts the storage api calls the run made, as of when the summary was written, see recordAPICalls.
+// Incomplete is why the run stopped before it was done, like its credentials expiring, blank when it wasn't cut short.
+// ClockSkewSeconds is how far the local clock was ahead of cloud storage's when connecting, negative when behind, see recordClockSkew.
+type RunSummary struct {
+	Generated        time.Time        `json:"generated"`
+	Buckets          []*BucketSummary `json:"buckets"`
+	Warnings         []string         `json:"warnings"`
+	APICalls         APICallStats     `json:"api_calls"`
+	Incomplete       string           `json:"incomplete,omitempty"`
+	ClockSkewSeconds float64          `json:"clock_skew_seconds,omitempty"`
+}
+
+// Warning is a problem worth reporting that doesn't fail the run, Bucket is blank for problems with the run as a whole.
+type Warning struct {
+	Bucket  string `json:"bucket,omitempty"`
+	Message string `json:"message"`
+}
+
+type bucketSummaryKey struct{}
+
+func newRunSummary() *RunSummary {
+	return &RunSummary{}
+}
+
+// bucket gets the summary for the named bucket, creating it if this is the first time the bucket has been seen.
+// It is safe to call on a nil RunSummary, in which case nothing is recorded.
+func (rs *RunSummary) bucket(name string, bucketType string) *BucketSummary {
+	if rs == nil {
+		return nil
+	}
+	for _, bs := range rs.Buckets {
+		if bs.BucketName == name {
+			if len(bs.Type) == 0 {
+				bs.Type = bucketType
+			}
+			return bs
+		}
+	}
+	bs := &BucketSummary{BucketName: name, Type: bucketType, ValidationResult: validationNotRun}
+	rs.Buckets = append(rs.Buckets, bs)
+	return bs
+}
+
+// withBucketSummary attaches bs to ctx so the lower level listing and download functions can record their progress.
+func withBucketSummary(ctx context.Context, bs *BucketSummary) context.Context {
+	if bs == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, bucketSummaryKey{}, bs)
+}
+
+func bucketSummaryFromContext(ctx context.Context) *BucketSummary {
+	bs, _ := ctx.Value(bucketSummaryKey{}).(*BucketSummary)
+	return bs
+}
+
+// countObjectExamined counts every object listed, and adds it to the bucket's inventory.
+func countObjectExamined(ctx context.Context, objAttrs *storage.ObjectAttrs) {
+	if bs := bucketSummaryFromContext(ctx); bs != nil {
+		bs.ObjectsExamined++
+		bs.Inventory.add(objAttrs)
+	}
+}
+
+func countBytesDownloaded(ctx context.Context, bytes int64) {
+	if bs := bucketSummaryFromContext(ctx); bs != nil {
+		bs.BytesDownloaded += bytes
+	}
+	downloadProgressFromContext(ctx).addBytes(bytes)
+}
+
+// timeSince adds the time elapsed since start to the bucket's running duration.
+func (bs *BucketSummary) timeSince(start time.Time) {
+	if bs != nil {
+		bs.Duration += time.Since(start)
+	}
+}
+
+func (bs *BucketSummary) setFilesSampled(num int) {
+	if bs != nil {
+		bs.FilesSampled = num
+	}
+}
+
+// countDownloadOutcome counts a sampled file as downloaded, skipped because it was already downloaded, or failed, going by err.
+func countDownloadOutcome(ctx context.Context, err error) {
+	bs := bucketSummaryFromContext(ctx)
+	switch {
+	case bs == nil:
+	case err == nil:
+		bs.FilesDownloaded++
+	case errors.IsAlreadyExists(err):
+		bs.FilesSkipped++
+	default:
+		bs.FilesFailed++
+	}
+}
+
+// checkSkippedDownloads fails when more than maxPercent of a bucket's sampled files were skipped as already downloaded,
+// which means the run verified little that was new. It is off when maxPercent isn't positive.
+func checkSkippedDownloads(rs *RunSummary, maxPercent int) error {
+	if rs == nil || maxPercent <= 0 {
+		return nil
+	}
+	var offenders []string
+	for _, bs := range rs.Buckets {
+		total := bs.FilesDownloaded + bs.FilesSkipped + bs.FilesFailed
+		if total > 0 && bs.FilesSkipped*100 > maxPercent*total {
+			offenders = append(offenders, fmt.Sprintf("%s skipped %d of %d", bs.BucketName, bs.FilesSkipped, total))
+		}
+	}
+	if len(offenders) > 0 {
+		return errors.NotValidf("Too many already downloaded files, %s", strings.Join(offenders, "; "))
+	}
+	return nil
+}
+
+func (bs *BucketSummary) setValidationResult(err error) {
+	if bs == nil {
+		return
+	}
+	if err != nil {
+		bs.ValidationResult = validationFailed
+		bs.ValidationError = err.Error()
+	} else {
+		bs.ValidationResult = validationPassed
+	}
+}
+
+var summaryHeader = []string{
+	"Bucket", "Type", "Validation", "Objects Examined", "Files Sampled", "Downloaded", "Skipped", "Failed", "Bytes Downloaded", "Duration",
+	"Inventory Objects", "Inventory Bytes",
+}
+
+// recordWarning prints a warning and keeps it in the summary of the bucket in ctx, if there is one, for the report.
+func recordWarning(ctx context.Context, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Fprintln(console.stdout, "Warning:", message)
+	if bs := bucketSummaryFromContext(ctx); bs != nil {
+		bs.Warnings = append(bs.Warnings, message)
+	}
+}
+
+// warn prints a warning about the run as a whole and keeps it for the report. It is safe to call on a nil RunSummary.
+func (rs *RunSummary) warn(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Fprintln(console.stdout, "Warning:", message)
+	if rs != nil {
+		rs.Warnings = append(rs.Warnings, message)
+	}
+}
+
+// getWarnings lists the run's warnings, then each bucket's in order.
+func (rs *RunSummary) getWarnings() (warnings []Warning) {
+	if rs == nil {
+		return
+	}
+	for _, message := range rs.Warnings {
+		warnings = append(warnings, Warning{Message: message})
+	}
+	for _, bs := range rs.Buckets {
+		for _, message := range bs.Warnings {
+			warnings = append(warnings, Warning{Bucket: bs.BucketName, Message: message})
+		}
+	}
+	return
+}
+
+// recordNewestObject notes when the newest object validation looked at was created, for judging freshness between runs.
+func recordNewestObject(ctx context.Context, created time.Time) {
+	if bs := bucketSummaryFromContext(ctx); bs != nil && created.After(bs.NewestObject) {
+		bs.NewestObject = created
+	}
+}
+
+// summaryRenderer writes the run summary in one format.
+type summaryRenderer func(w io.Writer, rs *RunSummary) error
+
+// summaryRenderers are the formats the summary can be written in, by name.
+var summaryRenderers = map[string]summaryRenderer{
+	"table":    writeSummaryTable,
+	"csv":      writeSummaryCsv,
+	"markdown": writeSummaryMarkdown,
+	"json": func(w io.Writer, rs *RunSummary) error {
+		return writeSummaryJson(w, rs, time.Now().UTC())
+	},
+}
+
+// summarySection is a list of notes written after the summary table, e.g. every warning of the run.
+// Alarming sections are about backups that may not be authentic, and stand out where the format allows.
+type summarySection struct {
+	heading  string
+	alarming bool
+	items    []summaryItem
+}
+
+// summaryItem is one note of a summarySection, Detail is extra output written below it, like a failed hook's.
+type summaryItem struct {
+	text   string
+	detail string
+}
+
+// getSummarySections lists the sections written after the summary table, in order. Sections without items aren't written.
+func getSummarySections(rs *RunSummary) []summarySection {
+	return []summarySection{
+		getIncompleteSection(rs),
+		getHookFailureSection(rs),
+		getSubstitutionSection(rs),
+		getSampleClassSection(rs),
+		getDownloadStatsSection(rs),
+		getAPICallSection(rs),
+		getAPICostSection(rs),
+		getWarningSection(rs),
+		getSignatureFailureSection(rs),
+	}
+}
+
+// writeSummary renders the run summary in the requested format, an aligned "table" by default, or any of summaryRenderers.
+func writeSummary(w io.Writer, rs *RunSummary, format string) (err error) {
+	if rs == nil {
+		return
+	}
+	if len(format) == 0 {
+		format = "table"
+	}
+	render, found := summaryRenderers[format]
+	if !found {
+		return errors.NotSupportedf("Summary format %s", format)
+	}
+	return render(w, rs)
+}
+
+func writeSummaryTable(w io.Writer, rs *RunSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	writeRow := func(cells ...string) {
+		for _, cell := range cells {
+			fmt.Fprint(tw, cell, "\t")
+		}
+		fmt.Fprintln(tw)
+	}
+	writeRow(summaryHeader...)
+	for _, bs := range rs.Buckets {
+		writeRow(getSummaryRow(bs)...)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	for _, section := range getSummarySections(rs) {
+		writeSummarySection(w, section)
+	}
+	return nil
+}
+
+// getSummaryRow is a bucket's row of the summary table, formatted for people to read.
+func getSummaryRow(bs *BucketSummary) []string {
+	return []string{bs.BucketName, bs.Type, bs.ValidationResult,
+		strconv.Itoa(bs.ObjectsExamined), strconv.Itoa(bs.FilesSampled),
+		strconv.Itoa(bs.FilesDownloaded), strconv.Itoa(bs.FilesSkipped), strconv.Itoa(bs.FilesFailed),
+		formatBytes(bs.BytesDownloaded), bs.Duration.Round(time.Second).String(),
+		strconv.Itoa(bs.Inventory.Objects), formatBytes(bs.Inventory.Bytes)}
+}
+
+// writeSummarySection writes a section after the summary table as plain text, with each item's detail indented below it.
+func writeSummarySection(w io.Writer, section summarySection) {
+	if len(section.items) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\n"+section.heading+":")
+	for _, item := range section.items {
+		fmt.Fprintln(w, item.text)
+		if len(item.detail) > 0 {
+			fmt.Fprintln(w, "  "+strings.ReplaceAll(item.detail, "\n", "\n  "))
+		}
+	}
+}
+
+// getHookFailureSection lists every post download hook that failed, with its output.
+func getHookFailureSection(rs *RunSummary) (section summarySection) {
+	section.heading = "Post download hook failures"
+	for _, bs := range rs.Buckets {
+		for _, result := range bs.HookResults {
+			if !result.passed() {
+				section.items = append(section.items, summaryItem{
+					fmt.Sprintf("%s: exit code %d %s", result.File, result.ExitCode, result.Error), result.Output})
+			}
+		}
+	}
+	return
+}
+
+// getWarningSection lists every warning of the run, so they aren't lost in the rest of the output.
+func getWarningSection(rs *RunSummary) (section summarySection) {
+	section.heading = "Warnings"
+	for _, warning := range rs.getWarnings() {
+		if len(warning.Bucket) > 0 {
+			section.items = append(section.items, summaryItem{text: fmt.Sprintf("%s: %s", warning.Bucket, warning.Message)})
+		} else {
+			section.items = append(section.items, summaryItem{text: warning.Message})
+		}
+	}
+	return
+}
+
+// getSignatureFailureSection lists every sampled file whose detached signature did not verify, apart from other failures.
+func getSignatureFailureSection(rs *RunSummary) (section summarySection) {
+	section.heading, section.alarming = "SIGNATURE FAILURES, these backups may not be authentic", true
+	for _, bs := range rs.Buckets {
+		for _, failure := range bs.SignatureFailures {
+			section.items = append(section.items, summaryItem{text: fmt.Sprintf("%s/%s", bs.BucketName, failure)})
+		}
+	}
+	return
+}
+
+func writeSummaryCsv(w io.Writer, rs *RunSummary) error {
+	cw := csv.NewWriter(w)
+	cw.Write(summaryHeader)
+	//machine readable, so raw byte counts and seconds
+	for _, bs := range rs.Buckets {
+		cw.Write([]string{bs.BucketName, bs.Type, bs.ValidationResult,
+			strconv.Itoa(bs.ObjectsExamined), strconv.Itoa(bs.FilesSampled),
+			strconv.Itoa(bs.FilesDownloaded), strconv.Itoa(bs.FilesSkipped), strconv.Itoa(bs.FilesFailed),
+			strconv.FormatInt(bs.BytesDownloaded, 10), strconv.FormatFloat(bs.Duration.Seconds(), 'f', 1, 64),
+			strconv.Itoa(bs.Inventory.Objects), strconv.FormatInt(bs.Inventory.Bytes, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatBytes makes byte counts readable, e.g. 1536 becomes 1.5 KiB.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// writeSummaryJson writes the whole summary, stamped with when it was generated unless it already is, for report diff.
+func writeSummaryJson(w io.Writer, rs *RunSummary, now time.Time) error {
+	report := *rs
+	if report.Generated.IsZero() {
+		report.Generated = now
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownEscaper escapes what markdown would otherwise format in object names and messages.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`)
+
+// writeSummaryMarkdown writes the summary as markdown, for notification hooks to post to chat or an issue tracker as it is.
+// Failures are bold, and why each bucket failed validation is listed below the table, which the plain table leaves out.
+func writeSummaryMarkdown(w io.Writer, rs *RunSummary) error {
+	writeRow := func(cells []string) {
+		fmt.Fprintln(w, "| "+strings.Join(cells, " | ")+" |")
+	}
+	writeRow(summaryHeader)
+	separators := make([]string, len(summaryHeader))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	writeRow(separators)
+	var failures []summaryItem
+	for _, bs := range rs.Buckets {
+		cells := getSummaryRow(bs)
+		for i := range cells {
+			cells[i] = markdownEscaper.Replace(cells[i])
+		}
+		if bs.ValidationResult == validationFailed {
+			cells[0], cells[2] = "**"+cells[0]+"**", "**"+cells[2]+"**"
+			failures = append(failures, summaryItem{text: fmt.Sprintf("%s: %s", bs.BucketName, bs.ValidationError)})
+		}
+		if bs.FilesFailed > 0 {
+			cells[7] = "**" + cells[7] + "**"
+		}
+		writeRow(cells)
+	}
+	sections := append([]summarySection{{heading: "Validation failures", items: failures}}, getSummarySections(rs)...)
+	for _, section := range sections {
+		writeMarkdownSection(w, section)
+	}
+	return nil
+}
+
+// writeMarkdownSection writes a section as a list, with each item's detail in a code block below it.
+func writeMarkdownSection(w io.Writer, section summarySection) {
+	if len(section.items) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n### %s\n\n", markdownEscaper.Replace(section.heading))
+	for _, item := range section.items {
+		text := markdownEscaper.Replace(item.text)
+		if section.alarming {
+			text = "**" + text + "**"
+		}
+		fmt.Fprintln(w, "- "+text)
+		if len(item.detail) > 0 {
+			fmt.Fprintln(w, "\n  ```\n  "+strings.ReplaceAll(strings.ReplaceAll(item.detail, "```", "'''"), "\n", "\n  ")+"\n  ```")
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSummaryMarkdown(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestRunSummary()
+	summary.Buckets[1].HookResults = []HookResult{{File: "new2.tar.gz", ExitCode: 2, Output: "gzip: unexpected end of file\n```"}}
+	summary.Buckets[1].SignatureFailures = []string{"bad_backup.tar.gz: Minisign signature not valid"}
+
+	var output bytes.Buffer
+	is.NoError(writeSummary(&output, summary, "markdown"))
+	lines := strings.Split(output.String(), "\n")
+	is.Equal("| Bucket | Type | Validation | Objects Examined | Files Sampled | Downloaded | Skipped | Failed | Bytes Downloaded | Duration | "+
+		"Inventory Objects | Inventory Bytes |", lines[0])
+	is.True(strings.HasPrefix(lines[1], "| --- | --- |"))
+	is.Equal("| test-matt-media | media | passed | 27 | 9 | 0 | 0 | 0 | 1.5 KiB | 1m30s | 0 | 0 B |", lines[2])
+	is.True(strings.HasPrefix(lines[3], "| **test-matt-server-backups** | server-backup | **failed** |"), "Should make failures bold")
+	is.Contains(output.String(), "\n### Validation failures\n\n- test-matt-server-backups: too old\n")
+	is.Contains(output.String(), "\n### Post download hook failures\n\n- new2.tar.gz: exit code 2 \n\n  ```\n  gzip: unexpected end of file\n  '''\n  ```\n",
+		"Should put hook output in a code block it can't end early")
+	is.Contains(output.String(), "- **test-matt-server-backups/bad\\_backup.tar.gz: Minisign signature not valid**",
+		"Should escape object names and make alarming sections bold")
+}
+
+func TestWriteSummaryFormats(t *testing.T) {
+	is := assert.New(t)
+	for format := range summaryRenderers {
+		var output bytes.Buffer
+		is.NoError(writeSummary(&output, getTestRunSummary(), format))
+		is.Contains(output.String(), "test-matt-server-backups", "Should render %s", format)
+	}
+	var output bytes.Buffer
+	is.NoError(writeSummary(&output, getTestRunSummary(), ""))
+	is.True(strings.HasPrefix(output.String(), "Bucket  "), "Should default to a table")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	jujuerrors "github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestRunSummary() *RunSummary {
+	summary := newRunSummary()
+	media := summary.bucket("test-matt-media", "media")
+	media.setValidationResult(nil)
+	media.setFilesSampled(9)
+	media.ObjectsExamined = 27
+	media.BytesDownloaded = 1536
+	media.Duration = 90 * time.Second
+	backups := summary.bucket("test-matt-server-backups", "server-backup")
+	backups.setValidationResult(errors.New("too old"))
+	return summary
+}
+
+func TestRunSummaryBucket(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	first := summary.bucket("bucket-one", "media")
+	is.Equal(validationNotRun, first.ValidationResult, "New buckets should not have run validation yet")
+	is.Same(first, summary.bucket("bucket-one", ""), "Should reuse the summary for a bucket already seen")
+	is.Equal("media", first.Type, "Should not blank out the type of a bucket already seen")
+	summary.bucket("bucket-two", "photo")
+	is.Equal(2, len(summary.Buckets))
+
+	var nilSummary *RunSummary
+	is.Nil(nilSummary.bucket("bucket-one", "media"), "Nil summary should not record anything")
+}
+
+func TestBucketSummaryContext(t *testing.T) {
+	is := assert.New(t)
+	bs := &BucketSummary{}
+	ctx := withBucketSummary(context.Background(), bs)
+	countObjectExamined(ctx, &storage.ObjectAttrs{Name: "a.txt", Size: 1})
+	countObjectExamined(ctx, &storage.ObjectAttrs{Name: "a.txt", Size: 1})
+	countBytesDownloaded(ctx, 42)
+	is.Equal(2, bs.ObjectsExamined)
+	is.Equal(int64(42), bs.BytesDownloaded)
+
+	//counting without a summary attached should be a no-op
+	countObjectExamined(context.Background(), nil)
+	countBytesDownloaded(context.Background(), 42)
+	is.Equal(context.Background(), withBucketSummary(context.Background(), nil))
+}
+
+func TestRecordWarning(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	bs := summary.bucket("test-matt-photos", "photo")
+	recordWarning(withBucketSummary(context.Background(), bs), "year %d missing.", 2013)
+	recordWarning(context.Background(), "not kept without a bucket summary.")
+	summary.warn("in progress run looks stale.")
+	is.Equal([]Warning{
+		{Message: "in progress run looks stale."},
+		{Bucket: "test-matt-photos", Message: "year 2013 missing."},
+	}, summary.getWarnings())
+
+	var output bytes.Buffer
+	is.NoError(writeSummaryTable(&output, summary))
+	is.Contains(output.String(), "\nWarnings:\nin progress run looks stale.\ntest-matt-photos: year 2013 missing.\n")
+
+	var nilSummary *RunSummary
+	nilSummary.warn("nothing to keep it in.")
+	is.Empty(nilSummary.getWarnings())
+}
+
+func TestWriteSummary(t *testing.T) {
+	is := assert.New(t)
+	summary := getTestRunSummary()
+
+	var table bytes.Buffer
+	is.NoError(writeSummary(&table, summary, "table"))
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	is.Equal(3, len(lines), "Should have a header and one line per bucket")
+	is.Equal(strings.Index(lines[0], "Validation"), strings.Index(lines[1], validationPassed), "Columns should be aligned")
+	is.Contains(lines[1], "1.5 KiB")
+	is.Contains(lines[1], "1m30s")
+	is.Contains(lines[2], validationFailed)
+
+	var csvOutput bytes.Buffer
+	is.NoError(writeSummary(&csvOutput, summary, "csv"))
+	is.Equal("Bucket,Type,Validation,Objects Examined,Files Sampled,Downloaded,Skipped,Failed,Bytes Downloaded,Duration,Inventory Objects,Inventory Bytes\n"+
+		"test-matt-media,media,passed,27,9,0,0,0,1536,90.0,0,0\n"+
+		"test-matt-server-backups,server-backup,failed,0,0,0,0,0,0,0.0,0,0\n", csvOutput.String())
+
+	is.Error(writeSummary(&table, summary, "xml"), "Should error on unknown formats")
+
+	summary.Buckets[1].HookResults = []HookResult{
+		{File: "newest.tar.gz", ExitCode: 0},
+		{File: "new2.tar.gz", ExitCode: 2, Output: "gzip: unexpected end of file"},
+	}
+	table.Reset()
+	is.NoError(writeSummary(&table, summary, "table"))
+	is.Contains(table.String(), "new2.tar.gz: exit code 2", "Should list failed hooks after the table")
+	is.Contains(table.String(), "unexpected end of file", "Should include the output of failed hooks")
+	is.NotContains(table.String(), "newest.tar.gz", "Should not list hooks that passed")
+	is.NoError(writeSummary(&table, nil, "table"), "Should not error on a nil summary")
+}
+
+var testFormatBytesCases = []struct {
+	bytes    int64
+	expected string
+}{
+	{0, "0 B"},
+	{1023, "1023 B"},
+	{1024, "1.0 KiB"},
+	{5 * 1024 * 1024, "5.0 MiB"},
+	{60 * 1024 * 1024 * 1024, "60.0 GiB"},
+}
+
+func TestFormatBytes(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testFormatBytesCases {
+		is.Equal(tc.expected, formatBytes(tc.bytes))
+	}
+}
+
+func TestCountDownloadOutcome(t *testing.T) {
+	is := assert.New(t)
+	summary := newRunSummary()
+	bs := summary.bucket("test-bucket", "media")
+	ctx := withBucketSummary(context.Background(), bs)
+	countDownloadOutcome(ctx, nil)
+	countDownloadOutcome(ctx, nil)
+	countDownloadOutcome(ctx, jujuerrors.AlreadyExistsf("file"))
+	countDownloadOutcome(ctx, jujuerrors.NotFoundf("file"))
+	is.Equal(2, bs.FilesDownloaded)
+	is.Equal(1, bs.FilesSkipped)
+	is.Equal(1, bs.FilesFailed)
+	countDownloadOutcome(context.Background(), nil)
+}
+
+var testCheckSkippedDownloadsCases = []struct {
+	downloaded int
+	skipped    int
+	maxPercent int
+	shouldFail bool
+}{
+	{downloaded: 1, skipped: 9, maxPercent: 0, shouldFail: false},
+	{downloaded: 5, skipped: 5, maxPercent: 50, shouldFail: false},
+	{downloaded: 4, skipped: 6, maxPercent: 50, shouldFail: true},
+	{downloaded: 0, skipped: 0, maxPercent: 1, shouldFail: false},
+	{downloaded: 0, skipped: 1, maxPercent: 99, shouldFail: true},
+}
+
+func TestCheckSkippedDownloads(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testCheckSkippedDownloadsCases {
+		summary := newRunSummary()
+		summary.bucket("test-bucket", "media")
+		bs := summary.bucket("other-bucket", "photo")
+		bs.FilesDownloaded, bs.FilesSkipped = tc.downloaded, tc.skipped
+		err := checkSkippedDownloads(summary, tc.maxPercent)
+		if tc.shouldFail {
+			is.Error(err, "%+v", tc)
+			is.Contains(err.Error(), "other-bucket")
+		} else {
+			is.NoError(err, "%+v", tc)
+		}
+	}
+	is.NoError(checkSkippedDownloads(nil, 10))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// defaultTimeLayout is how a time.Time prints itself, which is how reports showed timestamps before time_display.
+const defaultTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// timeLayouts are names a time_display layout can use instead of spelling out a Go reference time layout.
+var timeLayouts = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"rfc1123":  time.RFC1123,
+	"datetime": time.DateTime,
+	"friendly": "Mon Jan 2 2006 3:04 PM MST",
+}
+
+// displayTime is how timestamps are shown in reports and notifications, see setTimeDisplay.
+// A nil location shows each timestamp in the time zone it came with, UTC for everything from cloud storage.
+var displayTime = struct {
+	location *time.Location
+	layout   string
+}{layout: defaultTimeLayout}
+
+// setTimeDisplay shows timestamps in reports and notifications in the configured time zone and layout from now on.
+// Timestamps in json, csv and other output meant for programs stay RFC 3339 in UTC.
+func setTimeDisplay(display TimeDisplay) error {
+	var location *time.Location
+	if len(display.Timezone) > 0 {
+		var err error
+		location, err = time.LoadLocation(display.Timezone)
+		if err != nil {
+			return errors.Annotatef(err, "Unknown time_display time zone %s", display.Timezone)
+		}
+	}
+	layout := defaultTimeLayout
+	if len(display.Layout) > 0 {
+		layout = display.Layout
+		if named, found := timeLayouts[strings.ToLower(layout)]; found {
+			layout = named
+		}
+		//a layout without any of the reference time's parts would show every timestamp the same
+		if time.Date(2017, time.November, 13, 9, 21, 37, 0, time.UTC).Format(layout) == layout {
+			return errors.NotValidf("time_display layout %q, it should be a Go reference time layout like \"Jan 2 2006 3:04 PM\"", display.Layout)
+		}
+	}
+	displayTime.location, displayTime.layout = location, layout
+	return nil
+}
+
+// formatTime is a timestamp as people reading reports and notifications want to see it.
+func formatTime(t time.Time) string {
+	if displayTime.location != nil {
+		t = t.In(displayTime.location)
+	}
+	return t.Format(displayTime.layout)
+}
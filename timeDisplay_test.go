@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSetTimeDisplayCases = []struct {
+	display  TimeDisplay
+	expected string
+	valid    bool
+}{
+	{TimeDisplay{}, "2026-10-16 17:30:00 +0000 UTC", true},
+	{TimeDisplay{Timezone: "America/Chicago"}, "2026-10-16 12:30:00 -0500 CDT", true},
+	{TimeDisplay{Timezone: "America/Chicago", Layout: "friendly"}, "Fri Oct 16 2026 12:30 PM CDT", true},
+	{TimeDisplay{Layout: "RFC3339"}, "2026-10-16T17:30:00Z", true},
+	{TimeDisplay{Timezone: "Europe/London", Layout: "2 Jan 2006 15:04"}, "16 Oct 2026 18:30", true},
+	{TimeDisplay{Timezone: "Mars/Olympus_Mons"}, "", false},
+	{TimeDisplay{Layout: "dd/mm/yyyy"}, "", false},
+}
+
+func TestSetTimeDisplay(t *testing.T) {
+	is := assert.New(t)
+	defer setTimeDisplay(TimeDisplay{})
+	when := time.Date(2026, time.October, 16, 17, 30, 0, 0, time.UTC)
+	for _, tc := range testSetTimeDisplayCases {
+		err := setTimeDisplay(tc.display)
+		if !tc.valid {
+			is.Error(err, "case %+v", tc.display)
+			continue
+		}
+		is.NoError(err, "case %+v", tc.display)
+		is.Equal(tc.expected, formatTime(when), "case %+v", tc.display)
+	}
+	is.True(errors.IsNotValid(setTimeDisplay(TimeDisplay{Layout: "today"})))
+}
+
+func TestFormatTimeInValidationErrors(t *testing.T) {
+	is := assert.New(t)
+	defer setTimeDisplay(TimeDisplay{})
+	is.NoError(setTimeDisplay(TimeDisplay{Timezone: "America/Chicago", Layout: "Jan 2 3:04 PM"}))
+	runs := []TransferRun{{Started: time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC), Status: transferFailed}}
+	description, healthy := describeTransferRuns("1", runs, 2, time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC))
+	is.False(healthy)
+	is.Equal("transfer job 1 last ran on Oct 16 7:00 AM and failed", description)
+}
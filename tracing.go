@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracing is configured with the standard OpenTelemetry environment variables, exporting is off unless an endpoint is set.
+// The exporter also reads OTEL_EXPORTER_OTLP_HEADERS and friends itself.
+var tracingEndpointEnvs = []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"}
+
+// tracingShutdownTimeout bounds how long exiting waits for the last spans to be exported.
+const tracingShutdownTimeout = 10 * time.Second
+
+const tracerName = "github.com/mattgiltaji/validatebackups"
+
+// flushTracing exports any spans still buffered, it is called before exiting, including on fatal errors,
+// so the trace of a failed run isn't lost.
+var flushTracing = func() {}
+
+// setupTracing exports spans over OTLP http when an endpoint is set in the environment.
+// The storage client library traces its own API calls through the same provider, so listing pages and reads show up too.
+func setupTracing(ctx context.Context, getenv func(string) string) (err error) {
+	enabled := false
+	for _, env := range tracingEndpointEnvs {
+		enabled = enabled || len(getenv(env)) > 0
+	}
+	if !enabled {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return errors.Annotate(err, "Unable to create OTLP trace exporter")
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(programName), semconv.ServiceVersion(getBuildInfo().Version))),
+	)
+	otel.SetTracerProvider(provider)
+	flushTracing = func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		provider.Shutdown(shutdownCtx)
+	}
+	return nil
+}
+
+// startSpan starts a span for a pipeline stage, it does nothing unless tracing was set up.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetupTracing(t *testing.T) {
+	is := assert.New(t)
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	defer func() { flushTracing = func() {} }()
+
+	noEnv := func(string) string { return "" }
+	is.NoError(setupTracing(context.Background(), noEnv))
+	is.Equal(previous, otel.GetTracerProvider(), "Should not trace without an endpoint")
+
+	withEndpoint := func(key string) string {
+		if key == "OTEL_EXPORTER_OTLP_ENDPOINT" {
+			return "http://127.0.0.1:1"
+		}
+		return ""
+	}
+	is.NoError(setupTracing(context.Background(), withEndpoint))
+	_, isSdk := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	is.True(isSdk, "Should trace when an endpoint is set")
+}
+
+func TestStartAndEndSpan(t *testing.T) {
+	is := assert.New(t)
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, parent := startSpan(context.Background(), "validate")
+	_, child := startSpan(ctx, "validate bucket", attribute.String("bucket", "test-matt-media"))
+	endSpan(child, errors.NotValidf("Newest file"))
+	endSpan(parent, nil)
+
+	spans := recorder.Ended()
+	is.Len(spans, 2)
+	is.Equal("validate bucket", spans[0].Name())
+	is.Equal(spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID(), "Should nest bucket spans in the stage")
+	is.Contains(spans[0].Attributes(), attribute.String("bucket", "test-matt-media"))
+	is.Equal(codes.Error, spans[0].Status().Code, "Should mark failed stages")
+	is.Equal(codes.Unset, spans[1].Status().Code)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storagetransfer/v1"
+)
+
+const transferJobNamePrefix = "transferJobs/"
+
+// statuses of a TransferRun, as storage transfer service reports them
+const (
+	transferSucceeded = "SUCCESS"
+	transferFailed    = "FAILED"
+	transferAborted   = "ABORTED"
+)
+
+// TransferRun is one run of a storage transfer service job.
+type TransferRun struct {
+	Name          string
+	Status        string
+	Started       time.Time
+	Ended         time.Time
+	ObjectsCopied int64
+	BytesCopied   int64
+	Error         string
+}
+
+// transferRunLister lists the runs of a transfer job, like transferOperations.list.
+type transferRunLister func(ctx context.Context, check TransferJobCheck) ([]TransferRun, error)
+
+type transferRunListerKey struct{}
+
+func withTransferRunLister(ctx context.Context, lister transferRunLister) context.Context {
+	return context.WithValue(ctx, transferRunListerKey{}, lister)
+}
+
+func transferRunListerFromContext(ctx context.Context) transferRunLister {
+	lister, _ := ctx.Value(transferRunListerKey{}).(transferRunLister)
+	return lister
+}
+
+// getTransferJobName accepts a job's number on its own as well as its full transferJobs/ name.
+func getTransferJobName(job string) string {
+	if strings.HasPrefix(job, transferJobNamePrefix) {
+		return job
+	}
+	return transferJobNamePrefix + job
+}
+
+// newTransferApiLister lists runs with the storage transfer api, connecting the first time it is used
+// so configs without transfer jobs never need the api enabled.
+func newTransferApiLister(config Config) transferRunLister {
+	var service *storagetransfer.Service
+	return func(ctx context.Context, check TransferJobCheck) (runs []TransferRun, err error) {
+		if service == nil {
+			service, err = newTransferService(ctx, config)
+			if err != nil {
+				return nil, errors.Annotate(err, "Unable to connect to storage transfer service")
+			}
+		}
+		filter, err := json.Marshal(map[string]interface{}{"projectId": check.ProjectID, "jobNames": []string{getTransferJobName(check.Job)}})
+		if err != nil {
+			return
+		}
+		err = service.TransferOperations.List("transferOperations", string(filter)).Pages(ctx,
+			func(page *storagetransfer.ListOperationsResponse) error {
+				for _, operation := range page.Operations {
+					run, err := parseTransferOperation(operation)
+					if err != nil {
+						return err
+					}
+					runs = append(runs, run)
+				}
+				return nil
+			})
+		return runs, errors.Annotatef(err, "Unable to list runs of transfer job %s", check.Job)
+	}
+}
+
+// newTransferService connects with the same credentials as the storage client, see newStorageClient.
+func newTransferService(ctx context.Context, config Config) (service *storagetransfer.Service, err error) {
+	options, err := getCredentialOptions(os.Getenv)
+	if err != nil {
+		return nil, errors.Annotate(err, "Unable to load credentials from the environment")
+	}
+	if len(options) > 0 {
+		return storagetransfer.NewService(ctx, options...)
+	}
+	service, err = storagetransfer.NewService(ctx)
+	if err != nil {
+		service, err = storagetransfer.NewService(ctx, option.WithCredentialsFile(config.GoogleAuthFileLocation))
+	}
+	return
+}
+
+func parseTransferOperation(operation *storagetransfer.Operation) (run TransferRun, err error) {
+	var metadata storagetransfer.TransferOperation
+	err = json.Unmarshal(operation.Metadata, &metadata)
+	if err != nil {
+		return run, errors.Annotatef(err, "Unable to parse transfer operation %s", operation.Name)
+	}
+	run = TransferRun{Name: operation.Name, Status: metadata.Status}
+	run.Started, _ = time.Parse(time.RFC3339Nano, metadata.StartTime)
+	run.Ended, _ = time.Parse(time.RFC3339Nano, metadata.EndTime)
+	if metadata.Counters != nil {
+		run.ObjectsCopied, run.BytesCopied = metadata.Counters.ObjectsCopiedToSink, metadata.Counters.BytesCopiedToSink
+	}
+	if operation.Error != nil {
+		run.Error = operation.Error.Message
+	}
+	return
+}
+
+// describeTransferRuns says what the job's runs mean for the bucket's freshness. It isn't healthy when the job never ran,
+// its last run failed or its last success is maxAgeDays or more old. A recent success that copied nothing is healthy,
+// transfers only copy what changed, but explains a stale bucket as the source having no new backups.
+func describeTransferRuns(job string, runs []TransferRun, maxAgeDays int, now time.Time) (description string, healthy bool) {
+	if len(runs) == 0 {
+		return fmt.Sprintf("transfer job %s has never run", job), false
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Started.After(runs[j].Started) })
+	latest := runs[0]
+	if latest.Status == transferFailed || latest.Status == transferAborted {
+		reason := strings.ToLower(latest.Status)
+		if len(latest.Error) > 0 {
+			reason += ", " + latest.Error
+		}
+		return fmt.Sprintf("transfer job %s last ran on %s and %s", job, formatTime(latest.Started), reason), false
+	}
+	var success *TransferRun
+	for i := range runs {
+		if runs[i].Status == transferSucceeded {
+			success = &runs[i]
+			break
+		}
+	}
+	switch {
+	case success == nil:
+		return fmt.Sprintf("transfer job %s has never succeeded, its last run on %s is %s", job, formatTime(latest.Started),
+			strings.ToLower(latest.Status)), false
+	case maxAgeDays > 0 && int(now.Sub(success.Ended)/(time.Hour*24)) >= maxAgeDays:
+		return fmt.Sprintf("transfer job %s last succeeded on %s", job, formatTime(success.Ended)), false
+	case success.ObjectsCopied == 0:
+		return fmt.Sprintf("transfer job %s last ran on %s but copied nothing, the source has no new backups", job, formatTime(success.Ended)), true
+	}
+	return fmt.Sprintf("transfer job %s last copied %d objects, %s, on %s", job, success.ObjectsCopied,
+		formatBytes(success.BytesCopied), formatTime(success.Ended)), true
+}
+
+// checkBucketTransferJob correlates the bucket's validation with the runs of the transfer job that fills it,
+// so a stale bucket says whether the transfer never ran or ran with nothing to copy. A failing validation is annotated
+// with what the job did, a passing one warns when the job isn't healthy. The job's max_age_days defaults to
+// newest_file_max_age_in_days of the server backup rules.
+func checkBucketTransferJob(ctx context.Context, bucketConfig BucketToProcess, rules ServerFileValidationRules, validationErr error,
+	now time.Time) error {
+	check := bucketConfig.TransferJob
+	lister := transferRunListerFromContext(ctx)
+	if len(check.Job) == 0 || lister == nil {
+		return validationErr
+	}
+	runs, err := lister(ctx, check)
+	if err != nil {
+		recordWarning(ctx, "unable to check transfer job %s of bucket %s. %v", check.Job, bucketConfig.Name, err)
+		return validationErr
+	}
+	maxAgeDays := check.MaxAgeDays
+	if maxAgeDays == 0 {
+		maxAgeDays = rules.NewestFileMaxAgeInDays
+	}
+	description, healthy := describeTransferRuns(check.Job, runs, maxAgeDays, now)
+	if validationErr != nil {
+		return errors.Annotate(validationErr, description)
+	}
+	if !healthy {
+		recordWarning(ctx, "%s.", description)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/storagetransfer/v1"
+)
+
+var testTransferNow = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+var testDescribeTransferRunsCases = []struct {
+	runs     []TransferRun
+	healthy  bool
+	expected string
+}{
+	{nil, false, "has never run"},
+	{[]TransferRun{
+		{Status: transferSucceeded, Started: testTransferNow.AddDate(0, 0, -2), Ended: testTransferNow.AddDate(0, 0, -2), ObjectsCopied: 3},
+		{Status: transferFailed, Started: testTransferNow.AddDate(0, 0, -1), Error: "permission denied"},
+	}, false, "and failed, permission denied"},
+	{[]TransferRun{{Status: "IN_PROGRESS", Started: testTransferNow}}, false, "has never succeeded"},
+	{[]TransferRun{{Status: transferSucceeded, Started: testTransferNow.AddDate(0, 0, -5), Ended: testTransferNow.AddDate(0, 0, -5)}},
+		false, "last succeeded on"},
+	{[]TransferRun{{Status: transferSucceeded, Started: testTransferNow.AddDate(0, 0, -1), Ended: testTransferNow.AddDate(0, 0, -1)}},
+		true, "but copied nothing"},
+	{[]TransferRun{{Status: transferSucceeded, Started: testTransferNow.AddDate(0, 0, -1), Ended: testTransferNow.AddDate(0, 0, -1),
+		ObjectsCopied: 2, BytesCopied: 2048}}, true, "last copied 2 objects, 2.0 KiB"},
+}
+
+func TestDescribeTransferRuns(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testDescribeTransferRunsCases {
+		description, healthy := describeTransferRuns("transferJobs/1", tc.runs, 3, testTransferNow)
+		is.Equal(tc.healthy, healthy, description)
+		is.Contains(description, tc.expected)
+	}
+}
+
+func TestCheckBucketTransferJob(t *testing.T) {
+	is := assert.New(t)
+	bucketConfig := BucketToProcess{Name: "test-matt-server-backups", TransferJob: TransferJobCheck{Job: "1", ProjectID: "backups"}}
+	rules := ServerFileValidationRules{NewestFileMaxAgeInDays: 3}
+	var listed TransferJobCheck
+	runs := []TransferRun{{Status: transferSucceeded, Started: testTransferNow.AddDate(0, 0, -1), Ended: testTransferNow.AddDate(0, 0, -1)}}
+	ctx := withTransferRunLister(context.Background(), func(ctx context.Context, check TransferJobCheck) ([]TransferRun, error) {
+		listed = check
+		return runs, nil
+	})
+
+	err := checkBucketTransferJob(ctx, bucketConfig, rules, errors.NotValidf("Newest file"), testTransferNow)
+	is.ErrorContains(err, "transfer job 1 last ran on 2026-10-16 12:00:00 +0000 UTC but copied nothing, the source has no new backups: Newest file not valid")
+	is.Equal("backups", listed.ProjectID)
+
+	summary := newRunSummary()
+	bucketCtx := withBucketSummary(ctx, summary.bucket(bucketConfig.Name, "server-backup"))
+	is.NoError(checkBucketTransferJob(bucketCtx, bucketConfig, rules, nil, testTransferNow))
+	is.Empty(summary.getWarnings(), "Should not warn about healthy transfer jobs")
+	runs = nil
+	is.NoError(checkBucketTransferJob(bucketCtx, bucketConfig, rules, nil, testTransferNow))
+	is.Equal([]Warning{{Bucket: bucketConfig.Name, Message: "transfer job 1 has never run."}}, summary.getWarnings())
+
+	bucketConfig.TransferJob = TransferJobCheck{}
+	is.Equal(errors.NotValidf("Newest file").Error(), checkBucketTransferJob(ctx, bucketConfig, rules, errors.NotValidf("Newest file"),
+		testTransferNow).Error(), "Should leave buckets without transfer jobs alone")
+}
+
+func TestParseTransferOperation(t *testing.T) {
+	is := assert.New(t)
+	run, err := parseTransferOperation(&storagetransfer.Operation{Name: "transferOperations/transferJobs-1-2",
+		Metadata: []byte(`{"status":"SUCCESS","startTime":"2026-10-16T02:00:00Z","endTime":"2026-10-16T02:05:00.5Z",
+			"counters":{"objectsCopiedToSink":"4","bytesCopiedToSink":"1024"}}`)})
+	is.NoError(err)
+	is.Equal(TransferRun{Name: "transferOperations/transferJobs-1-2", Status: transferSucceeded,
+		Started: time.Date(2026, 10, 16, 2, 0, 0, 0, time.UTC), Ended: time.Date(2026, 10, 16, 2, 5, 0, 500000000, time.UTC),
+		ObjectsCopied: 4, BytesCopied: 1024}, run)
+	_, err = parseTransferOperation(&storagetransfer.Operation{Metadata: []byte("not json")})
+	is.Error(err)
+	is.Equal("transferJobs/1", getTransferJobName("1"))
+	is.Equal("transferJobs/1", getTransferJobName("transferJobs/1"))
+}
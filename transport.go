@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// apis a ClientTransport can connect to cloud storage with, a blank api means the json one
+const (
+	clientAPIJSON = "json"
+	clientAPIGRPC = "grpc"
+)
+
+// proxyDirect as a provider's proxy connects to it without one, even when HTTPS_PROXY is set.
+const proxyDirect = "direct"
+
+// proxySchemes are the kinds of proxy net/http can connect through, socks5h resolves host names on the proxy.
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// validateClientTransport checks the transport options make sense together. Read only mode guards the http requests
+// the json api makes, so it can't be used over grpc, and the http options don't apply to grpc either.
+func validateClientTransport(transport ClientTransport, readOnly bool) error {
+	switch transport.API {
+	case "", clientAPIJSON:
+		if len(transport.Endpoint) > 0 {
+			endpoint, err := url.Parse(transport.Endpoint)
+			if err != nil || len(endpoint.Host) == 0 {
+				return errors.NotValidf("Endpoint %q, expected a url like https://storage.googleapis.com/storage/v1/", transport.Endpoint)
+			}
+		}
+	case clientAPIGRPC:
+		if transport.HTTP1 || len(transport.Proxy) > 0 || len(transport.Proxies) > 0 ||
+			len(transport.LocalAddress) > 0 || len(transport.LocalAddresses) > 0 || transport.APIQPS > 0 {
+			return errors.NotValidf("Grpc api with http1, proxy, local address or api_qps set, those only apply to the json api")
+		}
+		if readOnly {
+			return errors.NotSupportedf("Read only mode over the grpc api")
+		}
+	default:
+		return errors.NotValidf("Api %q, expected %s or %s", transport.API, clientAPIJSON, clientAPIGRPC)
+	}
+	if transport.APIQPS < 0 {
+		return errors.NotValidf("Api_qps %g, expected a positive limit or 0 for none", transport.APIQPS)
+	}
+	if len(transport.Proxy) > 0 {
+		err := validateProxy(transport.Proxy)
+		if err != nil {
+			return err
+		}
+	}
+	for provider, proxy := range transport.Proxies {
+		if _, known := storageProviders[provider]; !known {
+			return errors.NotValidf("Proxy for storage provider %q", provider)
+		}
+		err := validateProxy(proxy)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid proxy for %s", provider)
+		}
+	}
+	if len(transport.LocalAddress) > 0 {
+		_, err := resolveLocalAddress(transport.LocalAddress)
+		if err != nil {
+			return err
+		}
+	}
+	for provider, address := range transport.LocalAddresses {
+		if _, known := storageProviders[provider]; !known {
+			return errors.NotValidf("Local address for storage provider %q", provider)
+		}
+		_, err := resolveLocalAddress(address)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid local address for %s", provider)
+		}
+	}
+	return nil
+}
+
+func validateProxy(proxy string) error {
+	if proxy == proxyDirect {
+		return nil
+	}
+	parsed, err := url.Parse(proxy)
+	if err != nil || len(parsed.Host) == 0 || !slices.Contains(proxySchemes, parsed.Scheme) {
+		return errors.NotValidf("Proxy %q, expected a url like http://proxy:3128, socks5://proxy:1080 or %s", proxy, proxyDirect)
+	}
+	return nil
+}
+
+// resolveLocalAddress is the address to connect from, given either as an ip address or as the name of a network interface,
+// in which case its first ipv4 address is used, or its first address if it has no ipv4 one.
+func resolveLocalAddress(address string) (ip net.IP, err error) {
+	if ip = net.ParseIP(address); ip != nil {
+		return
+	}
+	iface, err := net.InterfaceByName(address)
+	if err != nil {
+		return nil, errors.NotValidf("Local address %q, expected an ip address or the name of a network interface", address)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to get the addresses of network interface %s", address)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip == nil || (ip.To4() == nil && ipNet.IP.To4() != nil) {
+			ip = ipNet.IP
+		}
+	}
+	if ip == nil {
+		return nil, errors.NotFoundf("Address of network interface %s", address)
+	}
+	return
+}
+
+// getLocalAddress is the address to connect to a storage provider from, its entry in local addresses if it has one
+// or else local address. Blank means whichever the routing table picks.
+func getLocalAddress(transport ClientTransport, provider string) string {
+	if address, found := transport.LocalAddresses[provider]; found {
+		return address
+	}
+	return transport.LocalAddress
+}
+
+// getProxy is the proxy to connect to a storage provider through, its entry in proxies if it has one or else proxy.
+// Blank means the one in HTTPS_PROXY, if that is set.
+func getProxy(transport ClientTransport, provider string) string {
+	if proxy, found := transport.Proxies[provider]; found {
+		return proxy
+	}
+	return transport.Proxy
+}
+
+// getTransportOptions are the client options that apply to either api.
+func getTransportOptions(transport ClientTransport) (options []option.ClientOption) {
+	if len(transport.Endpoint) > 0 {
+		options = append(options, option.WithEndpoint(transport.Endpoint))
+	}
+	if len(transport.UserAgent) > 0 {
+		options = append(options, option.WithUserAgent(transport.UserAgent))
+	}
+	return
+}
+
+// getBaseTransport is the http transport requests to a storage provider go out over, json api requests for gs.
+func getBaseTransport(transport ClientTransport, provider string) (base http.RoundTripper, err error) {
+	proxy := getProxy(transport, provider)
+	localAddress := getLocalAddress(transport, provider)
+	if !transport.HTTP1 && len(proxy) == 0 && len(localAddress) == 0 {
+		return http.DefaultTransport, nil
+	}
+	custom := http.DefaultTransport.(*http.Transport).Clone()
+	switch proxy {
+	case "":
+	case proxyDirect:
+		custom.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Invalid proxy %q", proxy)
+		}
+		custom.Proxy = http.ProxyURL(proxyURL)
+	}
+	if len(localAddress) > 0 {
+		ip, err := resolveLocalAddress(localAddress)
+		if err != nil {
+			return nil, err
+		}
+		//the same timeouts as http.DefaultTransport, a proxy is connected to from the local address too
+		dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}, Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		custom.DialContext = dialer.DialContext
+	}
+	if transport.HTTP1 {
+		//a non nil but empty TLSNextProto turns off http/2
+		custom.ForceAttemptHTTP2 = false
+		custom.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return custom, nil
+}
+
+func getEndpointHost(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+// getClientConstructor picks how to connect to cloud storage from the transport options and read only mode,
+// leaving the credentials to whoever calls it.
+func getClientConstructor(transport ClientTransport, readOnly bool) (
+	newClient func(ctx context.Context, options ...option.ClientOption) (*storage.Client, error), err error) {
+	err = validateClientTransport(transport, readOnly)
+	if err != nil {
+		return nil, errors.Annotate(err, "Invalid transport")
+	}
+	transportOptions := getTransportOptions(transport)
+	if transport.API == clientAPIGRPC {
+		return func(ctx context.Context, options ...option.ClientOption) (*storage.Client, error) {
+			return storage.NewGRPCClient(ctx, slices.Concat(options, transportOptions)...)
+		}, nil
+	}
+	base, err := getBaseTransport(transport, "gs")
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		base = readOnlyTransport{base: base, endpointHost: getEndpointHost(transport.Endpoint)}
+	}
+	apiCalls.setLimit(transport.APIQPS)
+	base = apiLimitTransport{base: base, endpointHost: getEndpointHost(transport.Endpoint), calls: apiCalls}
+	return func(ctx context.Context, options ...option.ClientOption) (*storage.Client, error) {
+		options = slices.Concat(options, transportOptions)
+		if readOnly {
+			//a read only scope as well, in case the credentials ignore scopes it's still guarded by readOnlyTransport
+			options = append(options, option.WithScopes(storage.ScopeReadOnly))
+		}
+		authorized, err := htransport.NewTransport(ctx, base, options...)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewClient(ctx, append([]option.ClientOption{option.WithHTTPClient(&http.Client{Transport: authorized})},
+			transportOptions...)...)
+	}, nil
+}
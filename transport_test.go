@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+)
+
+var testValidateClientTransportCases = []struct {
+	transport ClientTransport
+	readOnly  bool
+	valid     bool
+}{
+	{ClientTransport{}, true, true},
+	{ClientTransport{API: clientAPIJSON, Endpoint: "http://localhost:4443/storage/v1/", HTTP1: true, Proxy: "http://proxy:3128"}, true, true},
+	{ClientTransport{API: clientAPIGRPC, Endpoint: "storage.googleapis.com:443", UserAgent: "backups/1.0"}, false, true},
+	{ClientTransport{API: clientAPIGRPC}, true, false},
+	{ClientTransport{API: clientAPIGRPC, HTTP1: true}, false, false},
+	{ClientTransport{API: clientAPIGRPC, Proxy: "http://proxy:3128"}, false, false},
+	{ClientTransport{API: "xml"}, false, false},
+	{ClientTransport{Endpoint: "localhost"}, false, false},
+	{ClientTransport{Proxy: "proxy:3128"}, false, false},
+	{ClientTransport{Proxy: proxyDirect, Proxies: map[string]string{"gs": "http://proxy:3128"}}, false, true},
+	{ClientTransport{Proxies: map[string]string{"ftp": "http://proxy:3128"}}, false, false},
+	{ClientTransport{Proxies: map[string]string{"gs": "proxy"}}, false, false},
+	{ClientTransport{API: clientAPIGRPC, Proxies: map[string]string{"gs": proxyDirect}}, false, false},
+	{ClientTransport{Proxy: "socks5h://proxy:1080", LocalAddress: "192.168.2.10"}, false, true},
+	{ClientTransport{Proxy: "ftp://proxy:21"}, false, false},
+	{ClientTransport{LocalAddresses: map[string]string{"gs": "::1"}}, false, true},
+	{ClientTransport{LocalAddress: "no-such-interface0"}, false, false},
+	{ClientTransport{LocalAddresses: map[string]string{"ftp": "127.0.0.1"}}, false, false},
+	{ClientTransport{API: clientAPIGRPC, LocalAddress: "127.0.0.1"}, false, false},
+	{ClientTransport{APIQPS: 2.5}, false, true},
+	{ClientTransport{APIQPS: -1}, false, false},
+	{ClientTransport{API: clientAPIGRPC, APIQPS: 10}, false, false},
+}
+
+func TestValidateClientTransport(t *testing.T) {
+	is := assert.New(t)
+	for _, tc := range testValidateClientTransportCases {
+		err := validateClientTransport(tc.transport, tc.readOnly)
+		is.Equal(tc.valid, err == nil, "Should tell if %+v with read only %t is valid: %v", tc.transport, tc.readOnly, err)
+	}
+}
+
+func TestGetBaseTransport(t *testing.T) {
+	is := assert.New(t)
+	base, err := getBaseTransport(ClientTransport{}, "gs")
+	is.NoError(err)
+	is.Equal(http.DefaultTransport, base)
+
+	base, err = getBaseTransport(ClientTransport{HTTP1: true, Proxy: "http://proxy:3128"}, "gs")
+	is.NoError(err)
+	custom := base.(*http.Transport)
+	is.False(custom.ForceAttemptHTTP2)
+	is.NotNil(custom.TLSNextProto, "Should turn off http/2")
+	req, _ := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/storage/v1/b/test-matt-media", nil)
+	proxy, err := custom.Proxy(req)
+	is.NoError(err)
+	is.Equal("proxy:3128", proxy.Host)
+
+	base, err = getBaseTransport(ClientTransport{Proxy: "http://proxy:3128", Proxies: map[string]string{"gs": proxyDirect}}, "gs")
+	is.NoError(err)
+	is.Nil(base.(*http.Transport).Proxy, "Should connect directly when the provider's proxy is direct")
+	base, err = getBaseTransport(ClientTransport{Proxy: "http://proxy:3128", Proxies: map[string]string{"gs": proxyDirect}}, "s3")
+	is.NoError(err)
+	is.NotNil(base.(*http.Transport).Proxy, "Should only connect directly to the provider whose proxy is direct")
+}
+
+func TestGetBaseTransportLocalAddress(t *testing.T) {
+	is := assert.New(t)
+	remoteAddr := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+	defer server.Close()
+
+	base, err := getBaseTransport(ClientTransport{LocalAddress: "0.0.0.0", LocalAddresses: map[string]string{"gs": "127.0.0.1"}}, "gs")
+	is.NoError(err)
+	resp, err := (&http.Client{Transport: base}).Get(server.URL)
+	is.NoError(err)
+	resp.Body.Close()
+	host, _, _ := net.SplitHostPort(remoteAddr)
+	is.Equal("127.0.0.1", host, "Should connect from the provider's local address")
+
+	_, err = getBaseTransport(ClientTransport{LocalAddress: "no-such-interface0"}, "gs")
+	is.True(errors.IsNotValid(err))
+}
+
+func TestGetClientConstructor(t *testing.T) {
+	is := assert.New(t)
+	requests := make(map[string]int)
+	userAgent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method]++
+		userAgent = r.UserAgent()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name": "test-matt-media"}`))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	transport := ClientTransport{Endpoint: server.URL + "/storage/v1/", HTTP1: true, UserAgent: "backups-test"}
+
+	newClient, err := getClientConstructor(transport, true)
+	is.NoError(err)
+	client, err := newClient(ctx, option.WithoutAuthentication())
+	is.NoError(err)
+	defer client.Close()
+	_, err = client.Bucket("test-matt-media").Attrs(ctx)
+	is.NoError(err, "Should read through the pinned endpoint")
+	err = client.Bucket("test-matt-media").Object("episode.ogv").Delete(ctx)
+	is.Error(err, "Should refuse writes to the pinned endpoint")
+	is.Equal(map[string]int{http.MethodGet: 1}, requests, "Should not send writes on")
+	is.Contains(userAgent, "backups-test")
+
+	_, err = getClientConstructor(ClientTransport{API: clientAPIGRPC}, true)
+	is.True(errors.IsNotSupported(errors.Cause(err)))
+}
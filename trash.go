@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	//trashModeDelete deletes artifacts outright, as has always been done
+	trashModeDelete = ""
+	//trashModeDirectory moves them to the trash directory in the state directory
+	trashModeDirectory = "directory"
+	//trashModeOS moves them to the operating system's trash or recycle bin
+	trashModeOS       = "os"
+	trashDirectory    = ".trash"
+	trashedTimeFormat = "20060102T150405Z"
+)
+
+// removeRunArtifact gets rid of a file or directory a run no longer needs, like the in progress file of a finished run,
+// in the way trash_mode in the config asks for, so a cleanup that went too far can be undone.
+func removeRunArtifact(config Config, path string, now time.Time) (err error) {
+	switch config.TrashMode {
+	case trashModeDelete:
+		return os.RemoveAll(path)
+	case trashModeDirectory:
+		trashDir := filepath.Join(getStateDirectory(config), trashDirectory)
+		err = moveToTrashDirectory(trashDir, path, now)
+		if err != nil {
+			return
+		}
+		return purgeTrashDirectory(trashDir, config.TrashRetentionDays, now)
+	case trashModeOS:
+		return errors.Annotatef(moveToOSTrash(path, now), "Unable to move %s to the trash", path)
+	default:
+		return errors.NotValidf("Trash mode %q, expected blank, %s or %s", config.TrashMode, trashModeDirectory, trashModeOS)
+	}
+}
+
+// moveToTrashDirectory moves path into trashDir, named with when it was trashed so the retention can be applied later.
+func moveToTrashDirectory(trashDir string, path string, now time.Time) error {
+	err := os.MkdirAll(trashDir, 0755)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to create trash directory %s", trashDir)
+	}
+	trashedPath, err := getUnusedPath(filepath.Join(trashDir, fmt.Sprintf("%s-%s", now.UTC().Format(trashedTimeFormat), filepath.Base(path))))
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path, trashedPath)
+	return errors.Annotatef(err, "Unable to move %s to the trash", path)
+}
+
+// purgeTrashDirectory deletes whatever was trashed more than retentionDays ago, 0 keeps everything.
+// Entries not named by moveToTrashDirectory are left alone.
+func purgeTrashDirectory(trashDir string, retentionDays int, now time.Time) error {
+	if retentionDays <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(trashDir)
+	if err != nil {
+		return errors.Annotatef(err, "Unable to read trash directory %s", trashDir)
+	}
+	cutoff := now.AddDate(0, 0, -retentionDays)
+	for _, entry := range entries {
+		stamp, _, found := strings.Cut(entry.Name(), "-")
+		if !found {
+			continue
+		}
+		trashed, err := time.Parse(trashedTimeFormat, stamp)
+		if err != nil || !trashed.Before(cutoff) {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(trashDir, entry.Name()))
+		if err != nil {
+			return errors.Annotatef(err, "Unable to purge %s from the trash", entry.Name())
+		}
+	}
+	return nil
+}
+
+// getUnusedPath adds a counter before the extension of path until nothing by that name exists.
+func getUnusedPath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 2; ; i++ {
+		_, err := os.Lstat(candidate)
+		if os.IsNotExist(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", errors.Annotatef(err, "Unable to check %s", candidate)
+		}
+		candidate = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+}
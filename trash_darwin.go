@@ -0,0 +1,24 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// moveToOSTrash moves path to the user's trash, where finder can put it back.
+func moveToOSTrash(path string, now time.Time) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return errors.Annotate(err, "Unable to find the trash")
+	}
+	trashedPath, err := getUnusedPath(filepath.Join(home, ".Trash", filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	return os.Rename(path, trashedPath)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTrashTime = time.Date(2018, 1, 20, 1, 2, 3, 0, time.UTC)
+
+func TestRemoveRunArtifact(t *testing.T) {
+	is := assert.New(t)
+	stateDir := t.TempDir()
+	artifact := filepath.Join(stateDir, inProgressFileName)
+
+	os.WriteFile(artifact, []byte("{}"), 0644)
+	is.NoError(removeRunArtifact(Config{StateDirectory: stateDir}, artifact, testTrashTime))
+	is.NoFileExists(artifact, "Should delete by default")
+	is.NoDirExists(filepath.Join(stateDir, trashDirectory))
+
+	os.WriteFile(artifact, []byte("{}"), 0644)
+	config := Config{StateDirectory: stateDir, TrashMode: trashModeDirectory}
+	is.NoError(removeRunArtifact(config, artifact, testTrashTime))
+	is.NoFileExists(artifact)
+	is.FileExists(filepath.Join(stateDir, trashDirectory, "20180120T010203Z-"+inProgressFileName), "Should move to the trash directory")
+
+	os.WriteFile(artifact, []byte("{}"), 0644)
+	is.NoError(removeRunArtifact(config, artifact, testTrashTime))
+	is.FileExists(filepath.Join(stateDir, trashDirectory, "20180120T010203Z-downloadsInProgress.2.json"), "Should not overwrite earlier trash")
+
+	os.WriteFile(artifact, []byte("{}"), 0644)
+	err := removeRunArtifact(Config{StateDirectory: stateDir, TrashMode: "shred"}, artifact, testTrashTime)
+	is.True(errors.IsNotValid(err), "Should error on unknown trash modes")
+	is.FileExists(artifact, "Should not remove anything with an unknown trash mode")
+}
+
+func TestPurgeTrashDirectory(t *testing.T) {
+	is := assert.New(t)
+	trashDir := t.TempDir()
+	old := filepath.Join(trashDir, "20180101T000000Z-downloadsInProgress.json")
+	recent := filepath.Join(trashDir, "20180119T000000Z-downloadsInProgress.json")
+	oldDir := filepath.Join(trashDir, "20180101T000000Z-drill")
+	other := filepath.Join(trashDir, "notes.txt")
+	for _, path := range []string{old, recent, other} {
+		os.WriteFile(path, []byte("x"), 0644)
+	}
+	os.MkdirAll(filepath.Join(oldDir, "backups"), 0755)
+
+	is.NoError(purgeTrashDirectory(trashDir, 0, testTrashTime))
+	is.FileExists(old, "Should keep everything without a retention")
+
+	is.NoError(purgeTrashDirectory(trashDir, 7, testTrashTime))
+	is.NoFileExists(old, "Should purge trash older than the retention")
+	is.NoDirExists(oldDir, "Should purge whole directories")
+	is.FileExists(recent, "Should keep recent trash")
+	is.FileExists(other, "Should leave files it didn't trash alone")
+}
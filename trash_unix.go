@@ -0,0 +1,76 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// moveToOSTrash moves path to the user's home trash as the freedesktop.org trash spec describes,
+// so desktop file managers list it and can restore it to where it came from.
+// Paths on another file system than the home trash can't be moved there, use the trash directory mode for those.
+func moveToOSTrash(path string, now time.Time) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	trashDir, err := getHomeTrashDirectory(os.Getenv)
+	if err != nil {
+		return err
+	}
+	filesDir, infoDir := filepath.Join(trashDir, "files"), filepath.Join(trashDir, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return errors.Annotatef(err, "Unable to create trash directory %s", dir)
+		}
+	}
+
+	//the info file is created exclusively first, which reserves the name in files too
+	name := filepath.Base(absPath)
+	ext := filepath.Ext(name)
+	for i := 1; ; i++ {
+		infoPath := filepath.Join(infoDir, name+".trashinfo")
+		info, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(filepath.Base(absPath), ext), i+1, ext)
+			continue
+		}
+		if err != nil {
+			return errors.Annotatef(err, "Unable to create trash info %s", infoPath)
+		}
+		_, err = info.WriteString(getTrashInfo(absPath, now))
+		info.Close()
+		if err == nil {
+			err = os.Rename(absPath, filepath.Join(filesDir, name))
+		}
+		if err != nil {
+			os.Remove(infoPath)
+		}
+		return err
+	}
+}
+
+// getHomeTrashDirectory is $XDG_DATA_HOME/Trash, defaulting to ~/.local/share/Trash.
+func getHomeTrashDirectory(getenv func(string) string) (string, error) {
+	if dataHome := getenv("XDG_DATA_HOME"); len(dataHome) > 0 {
+		return filepath.Join(dataHome, "Trash"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Annotate(err, "Unable to find the trash")
+	}
+	return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+func getTrashInfo(absPath string, now time.Time) string {
+	escaped := (&url.URL{Path: absPath}).EscapedPath()
+	return fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", escaped, now.Local().Format("2006-01-02T15:04:05"))
+}
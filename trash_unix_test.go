@@ -0,0 +1,29 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoveToOSTrash(t *testing.T) {
+	is := assert.New(t)
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "in progress.json")
+
+	for _, name := range []string{"in progress.json", "in progress.2.json"} {
+		os.WriteFile(artifact, []byte("{}"), 0644)
+		is.NoError(moveToOSTrash(artifact, testTrashTime))
+		is.NoFileExists(artifact)
+		is.FileExists(filepath.Join(dataHome, "Trash", "files", name))
+		info, err := os.ReadFile(filepath.Join(dataHome, "Trash", "info", name+".trashinfo"))
+		is.NoError(err, "Should write trash info for %s", name)
+		is.Contains(string(info), "Path="+filepath.ToSlash(dir)+"/in%20progress.json\n", "Should record where it came from")
+	}
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"github.com/juju/errors"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	foDelete          = 0x3
+	fofSilent         = 0x4
+	fofNoConfirmation = 0x10
+	fofAllowUndo      = 0x40
+	fofNoErrorUI      = 0x400
+)
+
+var procSHFileOperationW = windows.NewLazySystemDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// shFileOpStruct is SHFILEOPSTRUCTW. Only the fields up to flags are used, and those line up on 32 and 64 bit.
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+// moveToOSTrash sends path to the recycle bin, where it can be restored from explorer.
+func moveToOSTrash(path string, now time.Time) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	//pFrom is a list of paths ended by an extra null
+	from, err := windows.UTF16FromString(absPath)
+	if err != nil {
+		return err
+	}
+	from = append(from, 0)
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+	result, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op)))
+	if result != 0 {
+		return errors.Errorf("SHFileOperation failed with code %#x", result)
+	}
+	return nil
+}
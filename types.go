@@ -1,20 +1,364 @@
 package main
 
+import "time"
+
 // Config represents the configuration options available.
 // It is expected to be parsed from a json file passed in at runtime.
 type Config struct {
-	GoogleAuthFileLocation string                    `json:"google_auth_file_location"`
-	FileDownloadLocation   string                    `json:"file_download_location"`
-	MaxDownloadRetries     int                       `json:"max_download_retries"`
-	ServerBackupRules      ServerFileValidationRules `json:"server_backup_rules"`
-	FilesToDownload        FileDownloadRules         `json:"files_to_download"`
-	Buckets                []BucketToProcess         `json:"buckets"`
+	GoogleAuthFileLocation string `json:"google_auth_file_location"`
+	FileDownloadLocation   string `json:"file_download_location"`
+	MaxDownloadRetries     int    `json:"max_download_retries"`
+	StateDirectory         string `json:"state_directory"`
+	MaxInProgressAgeInDays int    `json:"max_in_progress_age_in_days"`
+	SamplingMemoryDays     int    `json:"sampling_memory_days"`
+	// CatalogObjectsPerRun has every run advance each bucket's catalog by that many objects, see updateCatalog.
+	CatalogObjectsPerRun int `json:"catalog_objects_per_run"`
+	// ClassBlindSampling samples without preferring storage classes that are cheaper to read, see preferCheapestClasses.
+	ClassBlindSampling         bool `json:"class_blind_sampling"`
+	MaxDaysSinceDeepValidation int  `json:"max_days_since_deep_validation"`
+	MaxSkippedPercent          int  `json:"max_skipped_percent"`
+	SubstituteMissingObjects   bool `json:"substitute_missing_objects"`
+	SanitizeFileNames          bool `json:"sanitize_file_names"`
+	AllowLongPaths             bool `json:"allow_long_paths"`
+	// AllowSyncedDownloadLocation allows downloading into a cloud synced folder, see validateDownloadLocation.
+	AllowSyncedDownloadLocation bool `json:"allow_synced_download_location"`
+	WriteMetadataSidecars       bool `json:"write_metadata_sidecars"`
+	// FsyncDownloads flushes each download to disk before it counts as verified, see withFsyncDownloads.
+	FsyncDownloads bool `json:"fsync_downloads"`
+	// MinFreeBytes stops downloading before the download volume has less than that free, see freeSpaceGuard.
+	MinFreeBytes        int64 `json:"min_free_bytes"`
+	LowSpaceWaitMinutes int   `json:"low_space_wait_minutes"`
+	// ReadOnly refuses any request that would change cloud storage, see readOnlyTransport.
+	ReadOnly bool `json:"read_only"`
+	// TrashMode picks what happens to run artifacts once they're done with, see removeRunArtifact.
+	TrashMode          string `json:"trash_mode"`
+	TrashRetentionDays int    `json:"trash_retention_days"`
+	// Transport changes how the storage client connects, see getClientConstructor.
+	Transport ClientTransport `json:"transport"`
+	// AdaptiveDownloads downloads large files in parallel range reads tuned to the network, see downloadTuner.
+	AdaptiveDownloads AdaptiveDownloads `json:"adaptive_downloads"`
+	// IssueTracker files an issue when a bucket keeps failing validation, see fileIssuesForFailures.
+	IssueTracker IssueTracker `json:"issue_tracker"`
+	// Alerting pages someone when a server backup bucket fails validation, see sendAlerts.
+	Alerting Alerting `json:"alerting"`
+	// MetricsFile is where download throughput is saved as prometheus metrics after downloading, see writeDownloadMetrics.
+	MetricsFile string `json:"metrics_file"`
+	// Email sends the run summary by email, see sendGroupEmail.
+	Email EmailNotification `json:"email"`
+	// BucketGroups report some buckets to someone else, with their own notifications, see getBucketGroups.
+	BucketGroups []BucketGroup `json:"bucket_groups"`
+	// ValidatorPluginsDirectory holds compiled validator plugins, each a kind of custom validator, see registerValidatorPlugins.
+	ValidatorPluginsDirectory string `json:"validator_plugins_directory"`
+	// TimeDisplay is the time zone and layout of timestamps in reports and notifications, see setTimeDisplay.
+	TimeDisplay TimeDisplay `json:"time_display"`
+	// Attestation saves an in-toto attestation of the objects each run verified, see saveAttestation.
+	Attestation Attestation `json:"attestation"`
+	// MaxClockSkewSeconds is how far the local clock can be from cloud storage's before freshness results are in doubt.
+	MaxClockSkewSeconds int `json:"max_clock_skew_seconds"`
+	// FailOnClockSkew stops the run when the clock is further off than MaxClockSkewSeconds, see checkClockSkew.
+	FailOnClockSkew bool `json:"fail_on_clock_skew"`
+	// S3 is how buckets with the s3 provider are reached, see newS3Store.
+	S3                S3Settings                  `json:"s3"`
+	ServerBackupRules ServerFileValidationRules   `json:"server_backup_rules"`
+	FilesToDownload   FileDownloadRules           `json:"files_to_download"`
+	Buckets           []BucketToProcess           `json:"buckets"`
+	PostDownloadHooks map[string]PostDownloadHook `json:"post_download_hooks"`
+	Profiles          map[string]RunProfile       `json:"profiles"`
+	//ActiveProfile is set from --profile, not read from the config file
+	ActiveProfile RunProfile `json:"-"`
+}
+
+// S3Settings are the region of s3 buckets, AWS_REGION if not set, and the endpoint to reach them at,
+// https://s3.<region>.amazonaws.com if not set, e.g. for minio or another S3 compatible service.
+// PathStyle puts the bucket name in the path instead of the host name, which most self hosted services need.
+// Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type S3Settings struct {
+	Region    string `json:"region"`
+	Endpoint  string `json:"endpoint"`
+	PathStyle bool   `json:"path_style"`
+}
+
+// Attestation is where each run's attestation is saved, named by the run id, and SigningKeyFile an ed25519 private key
+// in a PKCS #8 PEM file to sign it with. Without a key the attestation is saved unsigned.
+type Attestation struct {
+	Directory      string `json:"directory"`
+	SigningKeyFile string `json:"signing_key_file"`
+}
+
+// TimeDisplay shows timestamps in Timezone, an IANA name like America/Chicago or Local, and in Layout, a Go reference time
+// layout or one of the names in timeLayouts. Blank keeps each timestamp in its own zone, printed the way Go prints times.
+type TimeDisplay struct {
+	Timezone string `json:"timezone"`
+	Layout   string `json:"layout"`
+}
+
+// ClientTransport pins the storage api endpoint, e.g. for an emulator or a private service connect endpoint,
+// and picks the json or grpc api. HTTP1 turns off http/2 and Proxy sends requests through an http or socks5 proxy,
+// for networks whose egress proxies break the default settings. Both only apply to the json api.
+// Proxies overrides Proxy for a storage provider, by URI scheme, and either can be "direct" to ignore HTTPS_PROXY.
+// LocalAddress connects from an ip address or network interface, e.g. a second internet connection, and LocalAddresses overrides it by provider.
+// SkipConnectivityCheck skips the request made at startup to check the endpoint can be reached, see checkConnectivity.
+// APIQPS limits json api calls other than downloads, such as listing objects and getting their attributes, to that many a second
+// across every bucket, so validation doesn't use up the project's quota. It only applies to the json api too.
+type ClientTransport struct {
+	Endpoint              string            `json:"endpoint"`
+	API                   string            `json:"api"`
+	HTTP1                 bool              `json:"http1"`
+	Proxy                 string            `json:"proxy"`
+	Proxies               map[string]string `json:"proxies"`
+	LocalAddress          string            `json:"local_address"`
+	LocalAddresses        map[string]string `json:"local_addresses"`
+	UserAgent             string            `json:"user_agent"`
+	SkipConnectivityCheck bool              `json:"skip_connectivity_check"`
+	APIQPS                float64           `json:"api_qps"`
+}
+
+// AdaptiveDownloads measures throughput during the first large downloads of a run and adjusts how many range reads
+// download each file in parallel, and how big they are, to reach TargetBytesPerSecond without going over it.
+// A target of 0 means as fast as possible. What is learned is kept for the next run under NetworkProfile,
+// so e.g. a laptop can learn its home and office networks apart.
+type AdaptiveDownloads struct {
+	Enabled              bool   `json:"enabled"`
+	TargetBytesPerSecond int64  `json:"target_bytes_per_second"`
+	MaxWorkers           int    `json:"max_workers"`
+	NetworkProfile       string `json:"network_profile"`
+}
+
+// IssueTracker is where issues are filed about buckets failing validation FailureThreshold runs in a row, 3 if not set.
+// Kind is github, gitea or gitlab, and URL is the api, e.g. https://gitea.example.com/api/v1, which github and gitlab.com don't need.
+// Repository is owner/repo, or the project path on gitlab. Labels are added to new issues, except on gitea.
+type IssueTracker struct {
+	Kind             string   `json:"kind"`
+	URL              string   `json:"url"`
+	Repository       string   `json:"repository"`
+	FailureThreshold int      `json:"failure_threshold"`
+	Labels           []string `json:"labels"`
+}
+
+// Alerting sends an alert to pagerduty or opsgenie, the Kind, while a bucket of one of BucketTypes, server-backup if not set,
+// fails validation, and resolves it when the bucket passes again. URL overrides the event api, e.g. for opsgenie's eu instance.
+type Alerting struct {
+	Kind        string   `json:"kind"`
+	URL         string   `json:"url"`
+	BucketTypes []string `json:"bucket_types"`
+}
+
+// EmailNotification mails the run summary through the SMTPServer, a host:port, From one address To others.
+// Username logs in with the password in VALIDATEBACKUPS_SMTP_PASSWORD, it sends without logging in when blank.
+// OnlyFailures only sends when a bucket failed validation or the run stopped early.
+type EmailNotification struct {
+	SMTPServer   string   `json:"smtp_server"`
+	Username     string   `json:"username"`
+	From         string   `json:"from"`
+	To           []string `json:"to"`
+	OnlyFailures bool     `json:"only_failures"`
+}
+
+// BucketGroup is a set of buckets, by name, that are reported apart from the others, e.g. backups validated on someone else's behalf.
+// Its buckets only appear in its own SummaryFile, email, issues and alerts, and the top level notification settings cover the buckets in no group.
+// Redaction "names" keeps object names out of everything the group is sent, see redactRunSummary.
+type BucketGroup struct {
+	Name         string            `json:"name"`
+	Buckets      []string          `json:"buckets"`
+	SummaryFile  string            `json:"summary_file"`
+	Email        EmailNotification `json:"email"`
+	IssueTracker IssueTracker      `json:"issue_tracker"`
+	Alerting     Alerting          `json:"alerting"`
+	Redaction    string            `json:"redaction"`
+}
+
+// RunProfile changes what a run does, so one config can serve e.g. a nightly freshness check and a monthly deep check.
+// SkipDownloads only validates the buckets, ChecksumOnly reads sampled objects to check their CRC32C without saving them,
+// and SampleMultiplier scales every files_to_download count. FullDownloads downloads objects that buckets would otherwise only probe.
+type RunProfile struct {
+	Name             string `json:"-"`
+	SkipDownloads    bool   `json:"skip_downloads"`
+	ChecksumOnly     bool   `json:"checksum_only"`
+	SampleMultiplier int    `json:"sample_multiplier"`
+	FullDownloads    bool   `json:"full_downloads"`
 }
 
 // BucketToProcess is a mapping of bucket names toa type indicating how they should be validated.
 type BucketToProcess struct {
+	// Name can also be a URI like gs://bucket/prefix, in which case, as when Prefix is set, only objects under the prefix are looked at.
 	Name string `json:"name"`
-	Type string `json:"type"`
+	// Provider is gs, the default, or s3 for an Amazon S3 bucket, which can also be given as a URI like s3://bucket/prefix.
+	Provider string `json:"provider"`
+	Prefix   string `json:"prefix"`
+	Type     string `json:"type"`
+	// PerHostFreshness checks the newest file of every host, the first path segment of object names, in a server-backup bucket.
+	PerHostFreshness bool             `json:"per_host_freshness"`
+	FreshnessFilter  ObjectNameFilter `json:"freshness_filter"`
+	MetadataRules    []MetadataRule   `json:"metadata_rules"`
+	// VerifyDeletedVersionDays turns on reading back a noncurrent version deleted within that many days, for server backups in versioned buckets.
+	VerifyDeletedVersionDays int `json:"verify_deleted_version_days"`
+	// RestoreDrill is the command the drill command runs against the newest server backup to prove it restores.
+	RestoreDrill PostDownloadHook `json:"restore_drill"`
+	// CompanionRules list the detached checksums or signatures every matching object must come with.
+	CompanionRules []CompanionRule `json:"companion_rules"`
+	// Signature checks sampled downloads that have a detached signature, it is off when the companion is blank.
+	Signature SignatureRule `json:"signature"`
+	// InventoryFile is an inventory report csv or json listing dump to validate and sample from instead of listing the bucket.
+	InventoryFile string `json:"inventory_file"`
+	// SampleFilters restrict which objects can be sampled, an object must pass every one of them.
+	SampleFilters []SampleFilter `json:"sample_filters"`
+	// KMSKey is the customer managed key the bucket should encrypt with by default, and that the credentials must be able to decrypt with.
+	KMSKey string `json:"kms_key"`
+	// RequiredPrefixes must each hold an object newer than RequiredPrefixMaxAgeDays, e.g. one prefix per host sharing the bucket.
+	RequiredPrefixes         []string `json:"required_prefixes"`
+	RequiredPrefixMaxAgeDays int      `json:"required_prefix_max_age_days"`
+	// Probe range reads the first and last ProbeBytes, 4 MiB if not set, of sampled mkv and mp4 files to check their containers instead of downloading them.
+	Probe      bool  `json:"probe"`
+	ProbeBytes int64 `json:"probe_bytes"`
+	// ProbeRanges is how many random ranges from the middle of sampled gzip backups are probed along with their start and end, 4 if not set.
+	ProbeRanges int `json:"probe_ranges"`
+	// RetentionCheck warns when the bucket's lifecycle rules are about to delete too many of its backups.
+	RetentionCheck RetentionCheck `json:"retention_check"`
+	// MaxTotalBytesWarn warns when the bucket's inventory reaches that many bytes, 0 means no limit.
+	MaxTotalBytesWarn int64 `json:"max_total_bytes_warn"`
+	// BackupWindow is when backups are expected to be uploaded, recent objects created outside it fail validation.
+	BackupWindow BackupWindow `json:"backup_window"`
+	// TransferJob is the storage transfer service job that fills the bucket, whose runs explain why it is or isn't fresh.
+	TransferJob TransferJobCheck `json:"transfer_job"`
+	// FreshnessWindow has freshness checks list only recently named backups, for buckets too big to list in full every run.
+	FreshnessWindow FreshnessWindow `json:"freshness_window"`
+	// Validators are checks of the bucket's object listing that aren't built in, run after the rest of its validation, see runCustomValidators.
+	Validators []CustomValidator `json:"validators"`
+	// RemoteVerify is the manifest the verify-remote command checks the newest objects' checksums against.
+	RemoteVerify RemoteVerify `json:"remote_verify"`
+	// ShowPrefixDepth is how many directories down a media bucket's shows are, 1 if not set, e.g. 2 for library/show/season/episode.
+	ShowPrefixDepth int `json:"show_prefix_depth"`
+	// StaticPrefixes hold objects that never change, like a historic import, which oldest_file_max_age_in_days doesn't apply to.
+	StaticPrefixes []string `json:"static_prefixes"`
+	// OldestFileRule narrows oldest_file_max_age_in_days to some objects, with other ages under some prefixes.
+	OldestFileRule OldestFileRule `json:"oldest_file_rule"`
+}
+
+// OldestFileRule applies oldest_file_max_age_in_days only to objects matching NamePattern, e.g. rotating dumps that should have been
+// archived, so a bucket can also keep backups forever. Objects matching any of GracePatterns are let off. Prefixes override the age
+// and pattern under a prefix, relative to the bucket prefix, the longest prefix an object is under winning.
+type OldestFileRule struct {
+	NamePattern   string                 `json:"name_pattern"`
+	GracePatterns []string               `json:"grace_patterns"`
+	Prefixes      []OldestFilePrefixRule `json:"prefixes"`
+}
+
+// OldestFilePrefixRule is how old objects under Prefix can get. MaxAgeInDays is oldest_file_max_age_in_days when 0,
+// and NamePattern the rule's own when blank.
+type OldestFilePrefixRule struct {
+	Prefix       string `json:"prefix"`
+	MaxAgeInDays int    `json:"max_age_in_days"`
+	NamePattern  string `json:"name_pattern"`
+}
+
+// RemoteVerify checks the checksums cloud storage reports for the Newest objects, 10 if not set, against the ones the backup tool
+// recorded in a manifest at upload time, without downloading anything. Manifest is the manifest's object name under the bucket prefix,
+// or ManifestFile a local copy of it. Format is md5sum, a "digest  name" line per object, or json, a list of objects with their name,
+// md5 in hex and crc32c as 8 hex digits. Names in the manifest are relative to the bucket prefix.
+type RemoteVerify struct {
+	Manifest     string `json:"manifest"`
+	ManifestFile string `json:"manifest_file"`
+	Format       string `json:"format"`
+	Newest       int    `json:"newest"`
+}
+
+// CustomValidator is a check of a bucket's object listing that isn't built in. Kind picks how it runs, exec pipes the listing
+// as one json object per line to Command, which passes the bucket by exiting zero, optionally printing json with errors and warnings.
+// Any other kind names a validator plugin from validator_plugins_directory, which is handed the listing one object at a time.
+// It is killed after TimeoutSeconds, 300 if not set. Name identifies it in errors, the command's name if not set.
+type CustomValidator struct {
+	Name           string   `json:"name"`
+	Kind           string   `json:"kind"`
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+}
+
+// FreshnessWindow is the date in a server-backup bucket's object names, NameLayout being a go time layout like "2006-01/" or "backup-2006-01-02"
+// that the names under the prefix start with and that sorts by date. The newest check then only lists backups named within the last Days days,
+// or newest_file_max_age_in_days of the server backup rules when it isn't set, and the oldest check only those named before its cutoff.
+type FreshnessWindow struct {
+	NameLayout string `json:"name_layout"`
+	Days       int    `json:"days"`
+}
+
+// TransferJobCheck names a storage transfer service job, by number or as transferJobs/number, in the project ProjectID.
+// It is unhealthy when it hasn't succeeded for MaxAgeDays, which defaults to newest_file_max_age_in_days of the server backup rules.
+type TransferJobCheck struct {
+	Job        string `json:"job"`
+	ProjectID  string `json:"project_id"`
+	MaxAgeDays int    `json:"max_age_days"`
+}
+
+// BackupWindow is the time of day backups are uploaded, from Start to End like 02:00 and 05:00, wrapping past midnight when End is earlier.
+// Times are in Timezone, an IANA name like Europe/London, or UTC if it isn't set. Objects created within the last RecentDays days are checked,
+// or within newest_file_max_age_in_days of the server backup rules when it isn't set. The window is off unless Start and End are set.
+type BackupWindow struct {
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Timezone   string `json:"timezone"`
+	RecentDays int    `json:"recent_days"`
+}
+
+// RetentionCheck warns when the lifecycle rules would leave fewer than MinBackups of the backups in a bucket now
+// once Days days have passed. It is off unless both are set.
+type RetentionCheck struct {
+	Days       int `json:"days"`
+	MinBackups int `json:"min_backups"`
+}
+
+// SampleFilter is one filter in a bucket's chain of sample filters. Type picks which of the other fields apply:
+// size uses MinBytes and MaxBytes, age uses MinDays and MaxDays since the object was created,
+// extension uses Extensions, e.g. [".mkv", ".mp4"], and regex matches object names against Pattern.
+// Open ended ranges leave the bound at 0. Invert keeps the objects the filter would otherwise reject.
+type SampleFilter struct {
+	Type       string   `json:"type"`
+	MinBytes   int64    `json:"min_bytes"`
+	MaxBytes   int64    `json:"max_bytes"`
+	MinDays    int      `json:"min_days"`
+	MaxDays    int      `json:"max_days"`
+	Extensions []string `json:"extensions"`
+	Pattern    string   `json:"pattern"`
+	Invert     bool     `json:"invert"`
+}
+
+// CompanionRule requires every object whose name matches the Pattern regex to have a companion object,
+// named by replacing {name} in Companion with the object's name, e.g. {name}.sha256.
+// Verify is sha256 or md5 to also check sampled downloads against the digest in the companion, or blank to only check it exists.
+type CompanionRule struct {
+	Pattern   string `json:"pattern"`
+	Companion string `json:"companion"`
+	Verify    string `json:"verify"`
+}
+
+// SignatureRule verifies sampled downloads against the detached signature object named by replacing {name} in Companion.
+// Format is minisign or openpgp, and PublicKeyFile is the minisign .pub file or the openpgp public key, armored or not.
+type SignatureRule struct {
+	Companion     string `json:"companion"`
+	Format        string `json:"format"`
+	PublicKeyFile string `json:"public_key_file"`
+}
+
+// MetadataRule asserts every object under Prefix has a Content-Type matching ContentType and carries all of RequiredMetadataKeys.
+// ContentType is a pattern like video/*, and metadata keys may be given with or without the x-goog-meta- header prefix.
+type MetadataRule struct {
+	Prefix               string   `json:"prefix"`
+	ContentType          string   `json:"content_type"`
+	RequiredMetadataKeys []string `json:"required_metadata_keys"`
+}
+
+// ObjectNameFilter restricts which objects are considered, based on their names.
+// When NamePattern is set, only matching objects are considered. Objects matching any of ExcludePatterns are always skipped.
+// Both are regular expressions.
+type ObjectNameFilter struct {
+	NamePattern     string   `json:"name_pattern"`
+	ExcludePatterns []string `json:"exclude_patterns"`
+}
+
+// PostDownloadHook is a local command run against each file downloaded from buckets of a given type, automating the manual verification.
+// The downloaded file's path replaces any {file} in Command, or is added as the last argument when there is no placeholder.
+type PostDownloadHook struct {
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
 }
 
 // ServerFileValidationRules contains parameters to adjust validations on server-backup type buckets.
@@ -29,6 +373,20 @@ type FileDownloadRules struct {
 	EpisodesFromEachShow int `json:"episodes_from_each_show"`
 	PhotosFromThisMonth  int `json:"photos_from_this_month"`
 	PhotosFromEachYear   int `json:"photos_from_each_year"`
+	// MaxFileSizeBytes skips objects bigger than that when sampling, 0 means no limit.
+	MaxFileSizeBytes int64 `json:"max_file_size_bytes"`
+	// AutoSample replaces the episode and photo counts with sizes worked out from each show, year or month's population.
+	AutoSample AutoSampleRules `json:"auto_sample"`
+	// StratifyBySeason samples at least one episode from each season of a show where the episode count allows.
+	StratifyBySeason bool `json:"stratify_by_season"`
+}
+
+// AutoSampleRules size random samples so a bad object rate can be estimated to within MarginOfError at the Confidence level,
+// e.g. 0.95 and 0.05. Automatic sizing is off when Confidence is 0.
+// Server backups always sample the newest backups, so their count stays fixed.
+type AutoSampleRules struct {
+	Confidence    float64 `json:"confidence"`
+	MarginOfError float64 `json:"margin_of_error"`
 }
 
 // BucketAndFiles represents a mapping between a bucket and all the files for it to be downloaded for manual verification.
@@ -37,3 +395,59 @@ type BucketAndFiles struct {
 	BucketName string   `json:"bucket_name"`
 	Files      []string `json:"files"`
 }
+
+// InProgressState is saved to the downloadsInProgress.json file so an interrupted run can be resumed.
+// RunID identifies the run across restarts.
+type InProgressState struct {
+	RunID    string           `json:"run_id"`
+	Profile  string           `json:"profile,omitempty"`
+	Started  time.Time        `json:"started"`
+	Buckets  []BucketAndFiles `json:"buckets"`
+	Progress DownloadProgress `json:"progress"`
+}
+
+// DownloadProgress is the download work done so far by every session of a run.
+// Elapsed time only counts time spent downloading, not the gaps between sessions.
+type DownloadProgress struct {
+	TotalBytes      int64   `json:"total_bytes"`
+	BytesDownloaded int64   `json:"bytes_downloaded"`
+	ElapsedSeconds  float64 `json:"elapsed_seconds"`
+}
+
+// BucketSummary records what happened to a single bucket during a run.
+// It is used to build the summary table printed at the end of the run.
+type BucketSummary struct {
+	BucketName       string `json:"bucket_name"`
+	Type             string `json:"type"`
+	ValidationResult string `json:"validation_result"`
+	// ValidationError says why validation failed.
+	ValidationError string `json:"validation_error"`
+	// NewestObject is when the newest object validation looked at was created.
+	NewestObject    time.Time `json:"newest_object"`
+	ObjectsExamined int       `json:"objects_examined"`
+	FilesSampled    int       `json:"files_sampled"`
+	FilesDownloaded int       `json:"files_downloaded"`
+	// FilesSkipped counts sampled files that were already downloaded and so weren't downloaded again.
+	FilesSkipped int `json:"files_skipped"`
+	FilesFailed  int `json:"files_failed"`
+	// Substitutions list sampled objects that had gone missing by download time and what was downloaded instead.
+	Substitutions     []Substitution `json:"substitutions"`
+	BytesDownloaded   int64          `json:"bytes_downloaded"`
+	Duration          time.Duration  `json:"duration"`
+	HookResults       []HookResult   `json:"hook_results"`
+	SignaturesChecked int            `json:"signatures_checked"`
+	// SignatureFailures are kept apart from other errors, a bad signature means the backup may not be authentic.
+	SignatureFailures []string `json:"signature_failures"`
+	// SampleClasses counts the sampled objects of each storage class.
+	SampleClasses map[string]int `json:"sample_classes"`
+	// SampleRetrievalCost estimates what reading the sampled objects back costs in US dollars.
+	SampleRetrievalCost float64 `json:"sample_retrieval_cost"`
+	// Warnings are problems found with the bucket that didn't fail it, see recordWarning.
+	Warnings  []string        `json:"warnings"`
+	Inventory BucketInventory `json:"inventory"`
+	// DownloadStats times each downloaded file and counts retries and failed attempts by error type.
+	DownloadStats DownloadStats `json:"download_stats"`
+	// APIOperations counts the billed operations made on the bucket, downloads included, see recordAPICalls.
+	APIOperations   APIOperations    `json:"api_operations"`
+	VerifiedObjects []VerifiedObject `json:"verified_objects"`
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,12 +11,14 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/juju/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/iterator"
-	"gopkg.in/cheggaaa/pb.v1"
 )
 
 func loadConfigurationFromFile(filePath string) (config Config, err error) {
@@ -25,18 +28,35 @@ func loadConfigurationFromFile(filePath string) (config Config, err error) {
 		err = errors.Annotatef(err, "Unable to open config file at %s", filePath)
 		return
 	}
-	jsonParser := json.NewDecoder(configFile)
-	err = jsonParser.Decode(&config)
-	return
+	return decodeConfiguration(configFile)
 }
 
-func validateBucketsInConfig(ctx context.Context, client *storage.Client, config Config) (success bool, err error) {
+func validateBucketsInConfig(ctx context.Context, client *storage.Client, config Config, summary *RunSummary) (success bool, err error) {
+	ctx, span := startSpan(ctx, "validate")
+	defer func() { endSpan(span, err) }()
 	totalBuckets := len(config.Buckets)
+	if transferRunListerFromContext(ctx) == nil {
+		ctx = withTransferRunLister(ctx, newTransferApiLister(config))
+	}
 	for i, bucketConfig := range config.Buckets {
-		bucket := client.Bucket(bucketConfig.Name)
+		bucket, err2 := openBackupStore(ctx, client, config, bucketConfig)
+		if err2 != nil {
+			return false, err2
+		}
 		//validate the bucket, if the type merits it
-		fmt.Println(fmt.Sprintf("Validating files in bucket %d of %d, %s", i+1, totalBuckets, bucketConfig.Name))
-		err = validateBucket(ctx, bucket, config)
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Validating files in bucket %d of %d, %s", i+1, totalBuckets, bucketConfig.Name))
+		bucketSummary := summary.bucket(bucketConfig.Name, bucketConfig.Type)
+		bucketCtx, err2 := withBucketInventoryReport(withBucketPrefix(withBucketSummary(ctx, bucketSummary), bucketConfig.Prefix), bucketConfig)
+		if err2 != nil {
+			return false, err2
+		}
+		start := time.Now()
+		bucketCtx, bucketSpan := startSpan(bucketCtx, "validate bucket", attribute.String("bucket", bucketConfig.Name),
+			attribute.String("type", bucketConfig.Type))
+		err = validateBucket(bucketCtx, bucket, config)
+		endSpan(bucketSpan, err)
+		bucketSummary.timeSince(start)
+		bucketSummary.setValidationResult(err)
 		//TODO: have this function return success/failure so we only stop processing on an error and not just a failed validation
 		if err != nil {
 			return false, errors.Annotatef(err, "Unable to validate bucket %s", bucketConfig.Name)
@@ -45,22 +65,47 @@ func validateBucketsInConfig(ctx context.Context, client *storage.Client, config
 	return true, nil
 }
 
-func getObjectsToDownloadFromBucketsInConfig(ctx context.Context, client *storage.Client, config Config) ([]BucketAndFiles, error) {
+func getObjectsToDownloadFromBucketsInConfig(ctx context.Context, client *storage.Client, config Config, history *SamplingHistory, summary *RunSummary) ([]BucketAndFiles, error) {
+	ctx, span := startSpan(ctx, "select sample")
+	defer span.End()
 	totalBuckets := len(config.Buckets)
 	bucketToFilesMapping := make([]BucketAndFiles, len(config.Buckets))
 	for i, bucketConfig := range config.Buckets {
-		bucket := client.Bucket(bucketConfig.Name)
-		fmt.Println(fmt.Sprintf("Getting files to download from bucket %d of %d, %s", i+1, totalBuckets, bucketConfig.Name))
-		files, err := getObjectsToDownloadFromBucket(ctx, bucket, config)
+		bucket, err := openBackupStore(ctx, client, config, bucketConfig)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Getting files to download from bucket %d of %d, %s", i+1, totalBuckets, bucketConfig.Name))
+		bucketSummary := summary.bucket(bucketConfig.Name, bucketConfig.Type)
+		bucketCtx, err := withBucketInventoryReport(withBucketPrefix(withBucketSummary(ctx, bucketSummary), bucketConfig.Prefix), bucketConfig)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		bucketCtx, bucketSpan := startSpan(bucketCtx, "select bucket sample", attribute.String("bucket", bucketConfig.Name),
+			attribute.String("type", bucketConfig.Type))
+		files, err := getObjectsToDownloadFromBucket(bucketCtx, bucket, config, history)
+		bucketSpan.SetAttributes(attribute.Int("files", len(files)))
+		endSpan(bucketSpan, err)
+		bucketSummary.timeSince(start)
 		if err != nil {
 			return nil, errors.Annotatef(err, "Could not get objects to download from bucket %s", bucketConfig.Name)
 		}
+		bucketSummary.setFilesSampled(len(files))
+		//listing for validation and sampling fills in the inventory, so by now it has seen any empty uploads
+		if zeroByte := bucketSummary.Inventory.ZeroByteObjects; zeroByte > 0 {
+			recordWarning(bucketCtx, "%d zero-byte objects in bucket %s.", zeroByte, bucketConfig.Name)
+		}
 		bucketToFilesMapping[i] = BucketAndFiles{BucketName: bucketConfig.Name, Files: files}
 	}
+	err := checkLocalPathLengths(config, bucketToFilesMapping)
+	if err != nil {
+		return nil, err
+	}
 	return bucketToFilesMapping, nil
 }
 
-func saveInProgressFile(filePath string, data []BucketAndFiles) error {
+func saveInProgressFile(filePath string, data InProgressState) error {
 	jsonFile, err := os.Create(filePath)
 	if err != nil {
 		return errors.Annotatef(err, "Unable to open downloadsInProgress file %s for saving data.", filePath)
@@ -72,24 +117,64 @@ func saveInProgressFile(filePath string, data []BucketAndFiles) error {
 	return err
 }
 
-func loadInProgressFile(filePath string) (data []BucketAndFiles, err error) {
-	inProgressFile, err := os.Open(filePath)
+// loadInProgressFile loads the state of an interrupted run.
+// Files written before run ids were added only contain the list of buckets and files, these are loaded with a blank RunID.
+func loadInProgressFile(filePath string) (data InProgressState, err error) {
+	contents, err := os.ReadFile(filePath)
 	if err != nil {
 		err = errors.Annotatef(err, "Unable to open in progress file at %s", filePath)
 		return
 	}
-	defer inProgressFile.Close()
-	jsonParser := json.NewDecoder(inProgressFile)
-	err = jsonParser.Decode(&data)
+	if trimmed := bytes.TrimSpace(contents); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(contents, &data.Buckets)
+		return
+	}
+	err = json.Unmarshal(contents, &data)
 	return
 }
 
-func downloadFilesFromBucketAndFiles(ctx context.Context, client *storage.Client, config Config, mapping []BucketAndFiles) (err error) {
+func downloadFilesFromBucketAndFiles(ctx context.Context, client *storage.Client, config Config, mapping []BucketAndFiles, summary *RunSummary) (err error) {
+	ctx, span := startSpan(ctx, "download")
+	defer func() { endSpan(span, err) }()
+	err = checkLocalPathLengths(config, mapping)
+	if err != nil {
+		return
+	}
+	ctx, saveTuning, err := startDownloadTuning(ctx, config, os.Getenv)
+	if err != nil {
+		return
+	}
+	ctx = withFsyncDownloads(ctx, config.FsyncDownloads)
+	if !config.ActiveProfile.ChecksumOnly {
+		ctx = withFreeSpaceGuard(ctx, newFreeSpaceGuard(config))
+	}
+	if credentialRefresherFromContext(ctx) == nil {
+		refresher := newCredentialRefresher(config)
+		defer refresher.close()
+		ctx = withCredentialRefresher(ctx, refresher)
+	}
+	defer func() {
+		err2 := saveTuning()
+		if err == nil && err2 != nil {
+			err = err2
+		}
+	}()
 	totalBuckets := len(mapping)
 	for i, bucketAndFiles := range mapping {
-		bucket := client.Bucket(bucketAndFiles.BucketName)
-		fmt.Println(fmt.Sprintf("Downloading files in bucket %d of %d, %s", i+1, totalBuckets, bucketAndFiles.BucketName))
-		err := downloadFilesFromBucket(ctx, bucket, bucketAndFiles.Files, config)
+		bucket, err2 := openBackupStoreByName(ctx, client, config, bucketAndFiles.BucketName)
+		if err2 != nil {
+			return err2
+		}
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Downloading files in bucket %d of %d, %s", i+1, totalBuckets, bucketAndFiles.BucketName))
+		bucketType, _ := getBucketValidationTypeFromNameAndConfig(bucketAndFiles.BucketName, config.Buckets)
+		bucketSummary := summary.bucket(bucketAndFiles.BucketName, bucketType)
+		bucketSummary.setFilesSampled(len(bucketAndFiles.Files))
+		start := time.Now()
+		bucketCtx, bucketSpan := startSpan(withBucketSummary(ctx, bucketSummary), "download bucket",
+			attribute.String("bucket", bucketAndFiles.BucketName), attribute.Int("files", len(bucketAndFiles.Files)))
+		err := downloadFilesFromBucket(bucketCtx, bucket, bucketAndFiles.Files, config)
+		endSpan(bucketSpan, err)
+		bucketSummary.timeSince(start)
 		if err != nil {
 			return errors.Annotatef(err, "Error while downloading files for bucket %s", bucketAndFiles.BucketName)
 		}
@@ -97,7 +182,7 @@ func downloadFilesFromBucketAndFiles(ctx context.Context, client *storage.Client
 	return
 }
 
-func validateBucket(ctx context.Context, bucket *storage.BucketHandle, config Config) (err error) {
+func validateBucket(ctx context.Context, bucket BackupStore, config Config) (err error) {
 	//match bucket with appropriate validator from config
 	bucketName, err := getBucketName(ctx, bucket)
 	if err != nil {
@@ -105,44 +190,138 @@ func validateBucket(ctx context.Context, bucket *storage.BucketHandle, config Co
 		return
 	}
 	validationType, err := getBucketValidationTypeFromNameAndConfig(bucketName, config.Buckets)
+	bucketConfig, _ := getBucketConfigFromNameAndConfig(bucketName, config.Buckets)
+	defer func() { err = checkBucketTransferJob(ctx, bucketConfig, config.ServerBackupRules, err, time.Now()) }()
 	switch validationType {
 	case "media": //no validations for this type
 	case "photo": //no validations for this type
 	case "server-backup":
-		err = validateServerBackups(ctx, bucket, config.ServerBackupRules)
+		var freshnessMatcher *objectNameMatcher
+		freshnessMatcher, err = newObjectNameMatcher(bucketConfig.FreshnessFilter)
 		if err != nil {
-			err = errors.Annotatef(err, "Error validating bucket %s as type %s", bucketName, validationType)
+			err = errors.Annotatef(err, "Invalid freshness filter for bucket %s", bucketName)
 			return
 		}
+		ctx, err = withFreshnessWindow(ctx, bucketConfig.FreshnessWindow, config.ServerBackupRules)
+		if err != nil {
+			err = errors.Annotatef(err, "Invalid freshness window for bucket %s", bucketName)
+			return
+		}
+		var oldestChecker *oldestFileChecker
+		oldestChecker, err = newOldestFileChecker(bucketConfig.OldestFileRule, config.ServerBackupRules.OldestFileMaxAgeInDays)
+		if err != nil {
+			err = errors.Annotatef(err, "Invalid oldest file rule for bucket %s", bucketName)
+			return
+		}
+		err = validateServerBackups(ctx, bucket, config.ServerBackupRules, freshnessMatcher, oldestChecker, bucketConfig.StaticPrefixes)
+		if err != nil {
+			err = errors.Annotatef(annotateClockSkew(err), "Error validating bucket %s as type %s", bucketName, validationType)
+			return
+		}
+		if bucketConfig.PerHostFreshness {
+			err = validateHostFreshness(ctx, bucket, config.ServerBackupRules, freshnessMatcher, time.Now())
+			if err != nil {
+				err = errors.Annotatef(annotateClockSkew(err), "Error validating per host freshness of bucket %s", bucketName)
+				return
+			}
+		}
+		if bucketConfig.VerifyDeletedVersionDays > 0 {
+			err = validateDeletedVersionRestorable(ctx, bucket, bucketConfig.VerifyDeletedVersionDays, time.Now())
+			if err != nil {
+				err = errors.Annotatef(err, "Error restoring a deleted backup in bucket %s", bucketName)
+				return
+			}
+		}
 	default:
 		err = errors.NotFoundf(
 			"No matching validation logic for bucket %s with validation type %s", bucketName, validationType)
+		return
+	}
+
+	//these apply to every bucket type
+	err = validateObjectMetadata(ctx, bucket, bucketConfig.MetadataRules)
+	if err != nil {
+		err = errors.Annotatef(err, "Error validating object metadata in bucket %s", bucketName)
+		return
+	}
+	err = validateCompanions(ctx, bucket, bucketConfig.CompanionRules)
+	if err != nil {
+		err = errors.Annotatef(err, "Error validating companion objects in bucket %s", bucketName)
+		return
+	}
+	err = runCustomValidators(ctx, bucket, bucketConfig.Validators)
+	if err != nil {
+		err = errors.Annotatef(err, "Error running custom validators on bucket %s", bucketName)
+		return
+	}
+	err = validateBucketRequiredPrefixes(ctx, bucket, bucketConfig, config.ServerBackupRules, time.Now())
+	if err != nil {
+		err = errors.Annotatef(annotateClockSkew(err), "Error validating required prefixes in bucket %s", bucketName)
+		return
+	}
+	err = validateBucketBackupWindow(ctx, bucket, bucketConfig, config.ServerBackupRules, time.Now())
+	if err != nil {
+		err = errors.Annotatef(err, "Error validating backup window of bucket %s", bucketName)
+		return
+	}
+	warning, err := checkRetention(ctx, bucket, bucketConfig, time.Now())
+	if err != nil {
+		err = errors.Annotatef(err, "Error simulating lifecycle rules of bucket %s", bucketName)
+		return
+	}
+	if len(warning) > 0 {
+		recordWarning(ctx, "%s", warning)
+	}
+	err = validateBucketKMSKey(ctx, bucket, bucketConfig.KMSKey)
+	if err != nil {
+		err = errors.Annotatef(err, "Error validating KMS key of bucket %s", bucketName)
 	}
 	return
 }
 
-func getObjectsToDownloadFromBucket(ctx context.Context, bucket *storage.BucketHandle, config Config) (objects []string, err error) {
+func getObjectsToDownloadFromBucket(ctx context.Context, bucket BackupStore, config Config, history *SamplingHistory) (objects []string, err error) {
 	bucketName, err := getBucketName(ctx, bucket)
 	if err != nil {
 		err = errors.Annotate(err, "Unable to determine bucket name when validating.")
 		return
 	}
 	validationType, err := getBucketValidationTypeFromNameAndConfig(bucketName, config.Buckets)
+	bucketConfig, _ := getBucketConfigFromNameAndConfig(bucketName, config.Buckets)
+	filter, err := getSamplingFilter(config, bucketConfig, time.Now())
+	if err != nil {
+		return
+	}
+	sizer, err := newSampleSizer(config.FilesToDownload.AutoSample)
+	if err != nil {
+		return
+	}
+	options := samplingOptions{
+		filter:             filter,
+		avoid:              history.recentlySampled(bucketName, config.SamplingMemoryDays, time.Now()),
+		sizer:              sizer,
+		preferCheapClasses: !config.ClassBlindSampling,
+		showPrefixDepth:    bucketConfig.ShowPrefixDepth,
+	}
 	switch validationType {
 	case "media":
-		objects, err = getMediaFilesToDownload(ctx, bucket, config.FilesToDownload)
+		objects, err = getMediaFilesToDownload(ctx, bucket, config.FilesToDownload, options)
 		if err != nil {
 			err = errors.Annotatef(err, "Error getting list of media files to download from %s", bucketName)
 			return
 		}
 	case "photo":
-		objects, err = getPhotosToDownload(ctx, bucket, config.FilesToDownload)
+		objects, err = getPhotosToDownload(ctx, bucket, config.FilesToDownload, options)
 		if err != nil {
 			err = errors.Annotatef(err, "Error getting list of photos to download from %s", bucketName)
 			return
 		}
 	case "server-backup":
-		objects, err = getServerBackupsToDownload(ctx, bucket, config.FilesToDownload)
+		ctx, err = withFreshnessWindow(ctx, bucketConfig.FreshnessWindow, config.ServerBackupRules)
+		if err != nil {
+			err = errors.Annotatef(err, "Invalid freshness window for bucket %s", bucketName)
+			return
+		}
+		objects, err = getServerBackupsToDownload(ctx, bucket, config.FilesToDownload, options)
 		if err != nil {
 			err = errors.Annotatef(err, "Error getting list of server backups to download from %s", bucketName)
 			return
@@ -154,89 +333,268 @@ func getObjectsToDownloadFromBucket(ctx context.Context, bucket *storage.BucketH
 	return
 }
 
-func downloadFilesFromBucket(ctx context.Context, bucket *storage.BucketHandle, filesToDownload []string, config Config) (err error) {
+func downloadFilesFromBucket(ctx context.Context, bucket BackupStore, filesToDownload []string, config Config) (err error) {
 	bucketName, err := getBucketName(ctx, bucket)
 	if err != nil {
 		err = errors.Annotate(err, "Unabled to load bucket name for determining destination directory.")
 	}
+	bucketConfig, _ := getBucketConfigFromNameAndConfig(bucketName, config.Buckets)
+	//checksum only runs save nothing locally to check against companions or run hooks on
+	localChecks := !config.ActiveProfile.ChecksumOnly
+	hook, hasHook := config.PostDownloadHooks[bucketConfig.Type]
+	hasHook = hasHook && localChecks
+	var verifier signatureVerifier
+	if len(bucketConfig.Signature.Companion) > 0 && localChecks {
+		verifier, err = newSignatureVerifier(bucketConfig.Signature)
+		if err != nil {
+			err = errors.Annotatef(err, "Unable to set up signature checks for bucket %s", bucketName)
+			return
+		}
+	}
+	var fileNames map[string]string
+	fileNamesChanged := false
+	if config.SanitizeFileNames && localChecks {
+		manifestPath := getFileNameManifestPath(config.FileDownloadLocation, bucketName)
+		fileNames, err = loadFileNameManifest(manifestPath)
+		if err != nil {
+			return
+		}
+		defer func() {
+			if !fileNamesChanged {
+				return
+			}
+			err2 := saveFileNameManifest(manifestPath, fileNames)
+			if err == nil && err2 != nil {
+				err = err2
+			}
+		}()
+	}
+	//every file's signature is checked before a bad one fails the bucket, so the summary lists them all
+	var badSignatures []string
+	defer func() {
+		if err == nil && len(badSignatures) > 0 {
+			err = errors.NotValidf("Signatures of %s from bucket %s", strings.Join(badSignatures, ", "), bucketName)
+		}
+	}()
 	totalFiles := len(filesToDownload)
-	photoFileNameRegex, _ := regexp.Compile("([0-9][0-9][0-9][0-9])-[0-9][0-9]/(.*)")
 	for i, remoteFile := range filesToDownload {
+		localFile := getLocalFilePath(config.FileDownloadLocation, bucketName, remoteFile, config.SanitizeFileNames)
+		if config.AllowLongPaths {
+			localFile = toLongPath(localFile)
+		}
 
-		var localFile string
-		//for photos downloads, put them locally in yyyy, not in yyyy-mm
-		if photoFileNameRegex.MatchString(remoteFile) {
-			localFileParts := photoFileNameRegex.FindStringSubmatch(remoteFile)
-			localFile = filepath.Join(config.FileDownloadLocation, bucketName, localFileParts[1], localFileParts[2])
-		} else {
-			localFile = filepath.Join(config.FileDownloadLocation, bucketName, remoteFile)
+		fetch := func() error {
+			switch {
+			case config.ActiveProfile.ChecksumOnly:
+				return checksumObject(ctx, bucket, remoteFile)
+			case isProbed(bucketConfig, remoteFile):
+				return probeObject(ctx, bucket, remoteFile, getProbeBytes(bucketConfig), getProbeRanges(bucketConfig))
+			}
+			return downloadFile(ctx, bucket, remoteFile, localFile)
 		}
 
 		retryCount := 0
-		fmt.Println(fmt.Sprintf("Downloading %d of %d, %s", i+1, totalFiles, remoteFile))
+		substituted := false
+		refreshed := false
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Downloading %d of %d, %s", i+1, totalFiles, remoteFile))
+		timer := startDownloadTimer(ctx, time.Now())
 		for {
-			err2 := downloadFile(ctx, bucket, remoteFile, localFile)
+			err2 := fetch()
 			if err2 == nil {
 				//download successful!
+				countDownloadOutcome(ctx, nil)
+				timer.done(remoteFile, retryCount, time.Now())
 				break
 			}
 			if errors.IsAlreadyExists(err2) {
 				//download successful!
-				fmt.Println("Skipping already downloaded file.")
+				fmt.Fprintln(console.stdout, "Skipping already downloaded file.")
+				countDownloadOutcome(ctx, err2)
 				break
 			}
+			if isCredentialExpiredError(err2) && !refreshed {
+				refreshed = true
+				refreshedBucket, err3 := credentialRefresherFromContext(ctx).refreshBucket(ctx, bucket, err2)
+				if err3 != nil {
+					//what's downloaded so far is kept, and the in progress file resumes from it
+					reportDownloadProgress(ctx, console.stdout, time.Now())
+					err = errors.Annotatef(err3, "Stopped downloading %s", remoteFile)
+					return
+				}
+				bucket = refreshedBucket
+				continue
+			}
+			if isCredentialExpiredError(err2) {
+				reportDownloadProgress(ctx, console.stdout, time.Now())
+				err = errors.NewUnauthorized(err2, fmt.Sprintf("Stopped downloading %s, refreshed credentials were refused too", remoteFile))
+				return
+			}
+			if errors.IsQuotaLimitExceeded(err2) {
+				//retrying won't free any space, and what's downloaded so far is kept for the next run
+				err = errors.Annotate(err2, "Stopped downloading to keep free space on the download volume")
+				return
+			}
+			timer.failed(err2, !errors.IsNotFound(err2) && retryCount < config.MaxDownloadRetries)
+			if errors.IsNotFound(err2) && config.SubstituteMissingObjects && !substituted {
+				//gone since it was sampled, e.g. rotated out by a lifecycle rule, so pick another like it
+				substituted = true
+				replacement, err3 := pickSubstituteObject(ctx, bucket, config, bucketConfig, remoteFile, filesToDownload)
+				if err3 == nil {
+					fmt.Fprintln(console.stdout, fmt.Sprintf("Could not find %s, downloading %s instead.", remoteFile, replacement))
+					recordSubstitution(ctx, remoteFile, replacement)
+					//the files are shared with the in progress state and history, so they follow the substitution
+					filesToDownload[i] = replacement
+					remoteFile = replacement
+					localFile = getLocalFilePath(config.FileDownloadLocation, bucketName, remoteFile, config.SanitizeFileNames)
+					if config.AllowLongPaths {
+						localFile = toLongPath(localFile)
+					}
+					retryCount = 0
+					continue
+				}
+				err2 = errors.Annotatef(err2, "and no substitute, %v", err3)
+			}
 			if errors.IsNotFound(err2) {
 				//no sense retrying if we can't find the file
+				countDownloadOutcome(ctx, err2)
 				err = errors.Annotatef(err2, "Could not find %s to download it", remoteFile)
 				return
 			}
 			retryCount++
 			if retryCount > config.MaxDownloadRetries {
+				countDownloadOutcome(ctx, err2)
 				err = errors.Annotatef(err2, "Could not download %s. Retried max number of times.", remoteFile)
 				return
 			}
-			fmt.Println(fmt.Sprintf("Failed, retry %d of %d.", retryCount, config.MaxDownloadRetries))
+			fmt.Fprintln(console.stdout, fmt.Sprintf("Failed, retry %d of %d.", retryCount, config.MaxDownloadRetries))
+		}
+
+		//a probed file isn't saved either
+		fileChecks := localChecks && !isProbed(bucketConfig, remoteFile)
+		if config.WriteMetadataSidecars && fileChecks {
+			attrs, err2 := bucket.Object(remoteFile).Attrs(ctx)
+			if err2 == nil {
+				err2 = writeMetadataSidecar(attrs, localFile, time.Now())
+			}
+			if err2 != nil {
+				recordWarning(ctx, "unable to save metadata for %s. %v", remoteFile, err2)
+			}
+		}
+		if fileNames != nil && fileChecks && recordSanitizedFileName(fileNames, config.FileDownloadLocation, bucketName, remoteFile) {
+			fileNamesChanged = true
+		}
+		if fileChecks {
+			err = verifyCompanionChecksums(ctx, bucket, bucketConfig.CompanionRules, remoteFile, localFile)
+			if err != nil {
+				err = errors.Annotatef(err, "Downloaded %s does not match its companion checksum", remoteFile)
+				return
+			}
+		}
+		if verifier != nil && fileChecks {
+			checked, err2 := verifySignature(ctx, bucket, bucketConfig.Signature, verifier, remoteFile, localFile)
+			if checked {
+				if err2 != nil {
+					fmt.Fprintln(console.stdout, fmt.Sprintf("Signature check failed for %s.", remoteFile))
+					badSignatures = append(badSignatures, remoteFile)
+				}
+				recordSignatureResult(ctx, remoteFile, err2)
+			}
+		}
+
+		if hasHook && fileChecks {
+			result := runPostDownloadHook(ctx, hook, localFile)
+			if !result.passed() {
+				fmt.Fprintln(console.stdout, fmt.Sprintf("Post download hook failed for %s.", localFile))
+			}
+			recordHookResult(ctx, result)
+		}
+
+		err2 := reportDownloadProgress(ctx, console.stdout, time.Now())
+		if err2 != nil {
+			fmt.Fprintln(console.stdout, "Warning: unable to save download progress.", err2)
 		}
 	}
 	return
 }
 
-func validateServerBackups(ctx context.Context, bucket *storage.BucketHandle, rules ServerFileValidationRules) (err error) {
+var photoFileNameRegex = regexp.MustCompile("([0-9][0-9][0-9][0-9])-[0-9][0-9]/(.*)")
 
-	oldestObjAttrs, err := getOldestObjectFromBucket(ctx, bucket)
-	if err != nil || oldestObjAttrs == nil {
+// getLocalFilePath works out where a downloaded object is saved.
+// With sanitize set, characters that aren't allowed in Windows file names are percent encoded.
+func getLocalFilePath(downloadLocation string, bucketName string, remoteFile string, sanitize bool) string {
+	if sanitize {
+		remoteFile = sanitizeObjectPath(remoteFile)
+	}
+	//for photos downloads, put them locally in yyyy, not in yyyy-mm
+	if photoFileNameRegex.MatchString(remoteFile) {
+		localFileParts := photoFileNameRegex.FindStringSubmatch(remoteFile)
+		return filepath.Join(downloadLocation, bucketName, localFileParts[1], localFileParts[2])
+	}
+	return filepath.Join(downloadLocation, bucketName, remoteFile)
+}
+
+// validateServerBackups checks the oldest and newest objects in the bucket are within the configured ages.
+// Only objects accepted by freshnessMatcher are considered, so marker or readme files do not mask a stalled backup job.
+// With a freshness window in ctx the oldest check only lists backups named past the archive cutoff, and passes when there are none.
+// The oldest check applies oldestChecker, the bucket's oldest_file_rule, and every object is checked against the age of its prefix.
+// A nil oldestChecker applies oldest_file_max_age_in_days to every object.
+// Objects under staticPrefixes, relative to the bucket prefix, are never archived, like a one off import, so the oldest check skips them.
+func validateServerBackups(ctx context.Context, bucket BackupStore, rules ServerFileValidationRules, freshnessMatcher *objectNameMatcher,
+	oldestChecker *oldestFileChecker, staticPrefixes []string) (err error) {
+	if oldestChecker == nil {
+		oldestChecker, err = newOldestFileChecker(OldestFileRule{}, rules.OldestFileMaxAgeInDays)
+		if err != nil {
+			return err
+		}
+	}
+	var excluded []string
+	for _, prefix := range staticPrefixes {
+		excluded = append(excluded, bucketPrefixFromContext(ctx)+prefix)
+	}
+	oldestMatcher := freshnessMatcher.excludingPrefixes(excluded)
+	now := time.Now()
+	overdue, maxAgeDays, err := findOverdueObject(ctx, bucket, oldestMatcher, oldestChecker,
+		getArchivedObjectsQuery(ctx, oldestChecker.shortestMaxAgeDays(), now), now)
+	if err != nil {
 		return errors.Annotate(err, "Unable to get oldest object in bucket")
 	}
-	oldestFileAge := time.Since(oldestObjAttrs.Created)
-	oldestFileAgeInDays := int(oldestFileAge / (time.Hour * 24)) //this may not be 100% accurate due to daylight savings time and whatnot, but close enough
-	if oldestFileAgeInDays >= rules.OldestFileMaxAgeInDays {
-		return errors.NotValidf(
-			"Oldest file %s was created on %v, too long in the past. Check backup file archiving.", oldestObjAttrs.Name, oldestObjAttrs.Created)
+	if overdue != nil {
+		return errors.NotValidf("Oldest file %s was created on %s, more than %d days ago, too long in the past. Check backup file archiving.",
+			overdue.Name, formatTime(overdue.Created), maxAgeDays)
 	}
+	return validateNewestServerBackup(ctx, bucket, rules, freshnessMatcher)
+}
 
-	newestObjAttrs, err := getNewestObjectFromBucket(ctx, bucket)
-	if err != nil || newestObjAttrs == nil {
+func validateNewestServerBackup(ctx context.Context, bucket BackupStore, rules ServerFileValidationRules, freshnessMatcher *objectNameMatcher) (err error) {
+	newestObjAttrs, err := getNewestObjectFromBucket(ctx, bucket, freshnessMatcher)
+	if err != nil {
 		return errors.Annotate(err, "Unable to get newest object in bucket")
 	}
+	if newestObjAttrs == nil {
+		//an empty bucket, or a filter that matches none of it, has no backups at all, which is as bad as stale ones
+		return errors.NotFoundf("Backups in bucket %s matching its filters", bucket.BucketName())
+	}
+	recordNewestObject(ctx, newestObjAttrs.Created)
 	newestFileAge := time.Since(newestObjAttrs.Created)
 	newestFileAgeInDays := int(newestFileAge / (time.Hour * 24)) //this may not be 100% accurate due to daylight savings time and whatnot, but close enough
 	if newestFileAgeInDays >= rules.NewestFileMaxAgeInDays {
 		return errors.NotValidf(
-			"Newest file %s was created on %v, too long in the past. Make sure backups are running", newestObjAttrs.Name, newestObjAttrs.Created)
+			"Newest file %s was created on %s, too long in the past. Make sure backups are running", newestObjAttrs.Name, formatTime(newestObjAttrs.Created))
 	}
 
 	//TODO: should this return a bool up the chain instead of an err?
 	return nil
 }
 
-func getMediaFilesToDownload(ctx context.Context, bucket *storage.BucketHandle, rules FileDownloadRules) (mediaFiles []string, err error) {
-	shows, err := getBucketTopLevelDirs(ctx, bucket) //each top level directory in a media bucket represents a show
+func getMediaFilesToDownload(ctx context.Context, bucket BackupStore, rules FileDownloadRules, options samplingOptions) (mediaFiles []string, err error) {
+	shows, err := getShowDirs(ctx, bucket, options.showPrefixDepth)
 	if err != nil {
 		err = errors.Annotate(err, "Unable to determine shows in media bucket")
 		return
 	}
+	options.stratifyBySeason = rules.StratifyBySeason
 	for _, show := range shows {
-		partialFiles, err2 := getRandomFilesFromBucket(ctx, bucket, rules.EpisodesFromEachShow, show)
+		partialFiles, err2 := getRandomFilesFromBucket(ctx, bucket, rules.EpisodesFromEachShow, show, options)
 		if err2 != nil {
 			err = errors.Annotatef(err2, "Unable to get %d random files from show %s in media bucket", rules.EpisodesFromEachShow, show)
 			return
@@ -246,12 +604,24 @@ func getMediaFilesToDownload(ctx context.Context, bucket *storage.BucketHandle,
 	return
 }
 
-func getPhotosToDownload(ctx context.Context, bucket *storage.BucketHandle, rules FileDownloadRules) (photos []string, err error) {
+// getShowDirs lists a media bucket's shows, each top level directory unless depth puts them further down, e.g. library/show/ at 2.
+func getShowDirs(ctx context.Context, bucket BackupStore, depth int) (shows []string, err error) {
+	if depth == 0 || depth == 1 {
+		return getBucketTopLevelDirs(ctx, bucket)
+	}
+	shows, err = listPrefixes(ctx, bucket, "", depth)
+	if err != nil {
+		err = errors.Annotatef(err, "Unable to get dirs %d levels deep in bucket", depth)
+	}
+	return
+}
+
+func getPhotosToDownload(ctx context.Context, bucket BackupStore, rules FileDownloadRules, options samplingOptions) (photos []string, err error) {
 	currYear := time.Now().Year()
 
 	//each year, get rules.PhotosFromEachYear photos from that yeah, randomly selected
 	for year := 2010; year <= currYear; year++ {
-		partialPhotos, err2 := getRandomFilesFromBucket(ctx, bucket, rules.PhotosFromEachYear, fmt.Sprintf("%d-", year))
+		partialPhotos, err2 := getRandomFilesInRange(ctx, bucket, rules.PhotosFromEachYear, getPhotoYearRange(year), options)
 		if err2 != nil {
 			err = errors.Annotatef(err2, "Unable to get %d random files from year %d in photo bucket", rules.EpisodesFromEachShow, year)
 			return
@@ -260,7 +630,7 @@ func getPhotosToDownload(ctx context.Context, bucket *storage.BucketHandle, rule
 	}
 
 	//for this month, get rules.PhotosFromThisMonth photos from this month, randomly selected
-	partialPhotos, err := getRandomFilesFromBucket(ctx, bucket, rules.PhotosFromThisMonth, fmt.Sprintf("%d-%02d", currYear, time.Now().Month()))
+	partialPhotos, err := getRandomFilesFromBucket(ctx, bucket, rules.PhotosFromThisMonth, fmt.Sprintf("%d-%02d", currYear, time.Now().Month()), options)
 	if err != nil {
 		err = errors.Annotatef(err, "Unable to get %d random files from this month %s in photo bucket",
 			rules.PhotosFromThisMonth, fmt.Sprintf("%d-%02d", currYear, time.Now().Month()))
@@ -271,22 +641,41 @@ func getPhotosToDownload(ctx context.Context, bucket *storage.BucketHandle, rule
 	return
 }
 
-func getServerBackupsToDownload(ctx context.Context, bucket *storage.BucketHandle, rules FileDownloadRules) (backups []string, err error) {
+// getServerBackupsToDownload picks the newest backups accepted by options.filter.
+// The newest backups are always the most interesting, so options.avoid does not apply.
+// With a freshness window in ctx only the backups named within it are listed, unless there aren't enough of them.
+func getServerBackupsToDownload(ctx context.Context, bucket BackupStore, rules FileDownloadRules, options samplingOptions) (backups []string, err error) {
+	q := getRecentObjectsQuery(ctx, time.Now())
+	backups, err = findNewestServerBackups(ctx, bucket, rules, options, q)
+	if errors.IsNotFound(err) && q != nil {
+		backups, err = findNewestServerBackups(ctx, bucket, rules, options, nil)
+	}
+	return
+}
+
+func findNewestServerBackups(ctx context.Context, bucket BackupStore, rules FileDownloadRules, options samplingOptions,
+	q *storage.Query) (backups []string, err error) {
 	//get the most recent rules.ServerBackups backup files
-	//get all the files
-	it := bucket.Objects(ctx, nil)
+	it := listObjects(ctx, bucket, q)
 
 	files := make([]*storage.ObjectAttrs, rules.ServerBackups)
 	for {
 		//TODO: use ctx to cancel this mid-process if requested?
 		objAttrs, err2 := it.Next()
 		if err2 == iterator.Done {
+			if q == nil {
+				markInventoryComplete(ctx)
+			}
 			break
 		}
 		if err2 != nil {
 			err = errors.Annotate(err2, "Unable to get random sample from bucket")
 			return
 		}
+		countObjectExamined(ctx, objAttrs)
+		if !options.filter.accepts(objAttrs) {
+			continue
+		}
 		//if they are part of the nth most recent, save them
 		//TODO: optimize by checking last slot in files and don't loop if objAttrs don't have a chance of getting in
 		for i, file := range files {
@@ -311,10 +700,11 @@ func getServerBackupsToDownload(ctx context.Context, bucket *storage.BucketHandl
 	for _, file := range files {
 		backups = append(backups, file.Name)
 	}
+	recordSampleClasses(ctx, files, backups)
 	return
 }
 
-func getBucketName(ctx context.Context, bucket *storage.BucketHandle) (name string, err error) {
+func getBucketName(ctx context.Context, bucket BackupStore) (name string, err error) {
 	bucketAttrs, err := bucket.Attrs(ctx)
 	if err != nil {
 		err = errors.Annotate(err, "Unable to determine bucket name.")
@@ -324,9 +714,24 @@ func getBucketName(ctx context.Context, bucket *storage.BucketHandle) (name stri
 	return
 }
 
-func getBucketTopLevelDirs(ctx context.Context, bucket *storage.BucketHandle) (dirs []string, err error) {
-	topLevelDirQuery := storage.Query{Delimiter: "/", Versions: false}
-	it := bucket.Objects(ctx, &topLevelDirQuery)
+func getBucketTopLevelDirs(ctx context.Context, bucket BackupStore) (dirs []string, err error) {
+	dirs, err = listPrefixes(ctx, bucket, "", 1)
+	if err != nil {
+		err = errors.Annotate(err, "Unable to get top level dirs of bucket")
+	}
+	return
+}
+
+// listPrefixes lists the directories depth levels below basePrefix, e.g. artist/album/ at depth 2, sorted by name.
+// Objects along the way are ignored, and directories holding nothing but folder placeholders are left out.
+func listPrefixes(ctx context.Context, bucket BackupStore, basePrefix string, depth int) (prefixes []string, err error) {
+	if depth < 1 {
+		return nil, errors.NotValidf("Prefix depth %d, must be at least 1", depth)
+	}
+	if len(basePrefix) > 0 && !strings.HasSuffix(basePrefix, "/") {
+		basePrefix += "/"
+	}
+	it := listObjects(ctx, bucket, &storage.Query{Prefix: basePrefix, Delimiter: "/"})
 	for {
 		//TODO: use ctx to cancel this mid-process if requested?
 		objAttrs, err2 := it.Next()
@@ -334,10 +739,27 @@ func getBucketTopLevelDirs(ctx context.Context, bucket *storage.BucketHandle) (d
 			break
 		}
 		if err2 != nil {
-			err = errors.Annotate(err2, "Unable to get top level dirs of bucket")
-			return
+			return nil, errors.Annotatef(err2, "Unable to list prefixes under %q", basePrefix)
+		}
+		if len(objAttrs.Prefix) == 0 {
+			continue
+		}
+		if depth > 1 {
+			nested, err2 := listPrefixes(ctx, bucket, objAttrs.Prefix, depth-1)
+			if err2 != nil {
+				return nil, err2
+			}
+			prefixes = append(prefixes, nested...)
+			continue
+		}
+		//a placeholder for a directory nothing was put in still shows up as a prefix
+		hasObjects, err2 := prefixHasObjects(ctx, bucket, objAttrs.Prefix)
+		if err2 != nil {
+			return nil, errors.Annotatef(err2, "Unable to check prefix %s", objAttrs.Prefix)
+		}
+		if hasObjects {
+			prefixes = append(prefixes, objAttrs.Prefix)
 		}
-		dirs = append(dirs, objAttrs.Prefix)
 	}
 	return
 }
@@ -351,18 +773,45 @@ func getBucketValidationTypeFromNameAndConfig(name string, configs []BucketToPro
 	return "", errors.NotFoundf("Unable to find validation type for bucket named %s in config %v", name, configs)
 }
 
-func getNewestObjectFromBucket(ctx context.Context, bucket *storage.BucketHandle) (newestObjectAttrs *storage.ObjectAttrs, err error) {
-	it := bucket.Objects(ctx, nil)
+func getBucketConfigFromNameAndConfig(name string, configs []BucketToProcess) (BucketToProcess, error) {
+	for _, config := range configs {
+		if name == config.Name {
+			return config, nil
+		}
+	}
+	return BucketToProcess{}, errors.NotFoundf("Unable to find config for bucket named %s in config %v", name, configs)
+}
+
+// getNewestObjectFromBucket only lists the backups named within the freshness window when ctx has one,
+// and lists everything when none are, so a stale bucket is still failed with its real newest backup.
+func getNewestObjectFromBucket(ctx context.Context, bucket BackupStore, matcher *objectNameMatcher) (newestObjectAttrs *storage.ObjectAttrs, err error) {
+	q := getRecentObjectsQuery(ctx, time.Now())
+	newestObjectAttrs, err = findNewestObject(ctx, bucket, matcher, q)
+	if err == nil && newestObjectAttrs == nil && q != nil {
+		newestObjectAttrs, err = findNewestObject(ctx, bucket, matcher, nil)
+	}
+	return
+}
+
+func findNewestObject(ctx context.Context, bucket BackupStore, matcher *objectNameMatcher, q *storage.Query) (newestObjectAttrs *storage.ObjectAttrs, err error) {
+	it := listObjects(ctx, bucket, q)
 	for {
 		//TODO: use ctx to cancel this mid-process if requested?
 		objAttrs, err2 := it.Next()
 		if err2 == iterator.Done {
+			if q == nil {
+				markInventoryComplete(ctx)
+			}
 			break
 		}
 		if err2 != nil {
 			err = errors.Annotate(err2, "Unable to get newest object from bucket")
 			return
 		}
+		countObjectExamined(ctx, objAttrs)
+		if !matcher.matches(objAttrs.Name) {
+			continue
+		}
 		if newestObjectAttrs == nil || objAttrs.Created.After(newestObjectAttrs.Created) {
 			newestObjectAttrs = objAttrs
 		}
@@ -370,18 +819,29 @@ func getNewestObjectFromBucket(ctx context.Context, bucket *storage.BucketHandle
 	return
 }
 
-func getOldestObjectFromBucket(ctx context.Context, bucket *storage.BucketHandle) (oldestObjectAttrs *storage.ObjectAttrs, err error) {
-	it := bucket.Objects(ctx, nil)
+func getOldestObjectFromBucket(ctx context.Context, bucket BackupStore, matcher *objectNameMatcher) (oldestObjectAttrs *storage.ObjectAttrs, err error) {
+	return findOldestObject(ctx, bucket, matcher, nil)
+}
+
+func findOldestObject(ctx context.Context, bucket BackupStore, matcher *objectNameMatcher, q *storage.Query) (oldestObjectAttrs *storage.ObjectAttrs, err error) {
+	it := listObjects(ctx, bucket, q)
 	for {
 		//TODO: use ctx to cancel this mid-process if requested?
 		objAttrs, err2 := it.Next()
 		if err2 == iterator.Done {
+			if q == nil {
+				markInventoryComplete(ctx)
+			}
 			break
 		}
 		if err2 != nil {
 			err = errors.Annotate(err2, "Unable to get oldest object from bucket")
 			return
 		}
+		countObjectExamined(ctx, objAttrs)
+		if !matcher.matches(objAttrs.Name) {
+			continue
+		}
 		if oldestObjectAttrs == nil || objAttrs.Created.Before(oldestObjectAttrs.Created) {
 			oldestObjectAttrs = objAttrs
 		}
@@ -391,8 +851,14 @@ func getOldestObjectFromBucket(ctx context.Context, bucket *storage.BucketHandle
 
 // GetRandomFilesFromBucket gets a random sample of objects from a bucket with no replacement.
 // The Prefix parameter will filter the objects so all selections will have that prefix; when prefix == nil, objects will be chosen from the entire bucket.
+// Only objects accepted by options.filter are candidates, and objects in options.avoid are only picked when there aren't enough others.
 // Randomness is not cryptographic strength.
-func getRandomFilesFromBucket(ctx context.Context, bucket *storage.BucketHandle, num int, prefix string) (fileNames []string, err error) {
+func getRandomFilesFromBucket(ctx context.Context, bucket BackupStore, num int, prefix string, options samplingOptions) (fileNames []string, err error) {
+	return getRandomFilesInRange(ctx, bucket, num, objectNameRange{Prefix: prefix}, options)
+}
+
+// getRandomFilesInRange is getRandomFilesFromBucket for the objects in a range of names.
+func getRandomFilesInRange(ctx context.Context, bucket BackupStore, num int, nameRange objectNameRange, options samplingOptions) (fileNames []string, err error) {
 	if num < 0 {
 		err = errors.NotValidf("Cannot return negative number of random files.")
 		return
@@ -402,14 +868,7 @@ func getRandomFilesFromBucket(ctx context.Context, bucket *storage.BucketHandle,
 		return
 	}
 	//get the list of matching objects
-
-	var q storage.Query
-	if len(prefix) == 0 {
-		q = storage.Query{Versions: false}
-	} else {
-		q = storage.Query{Prefix: prefix, Versions: false}
-	}
-	it := bucket.Objects(ctx, &q)
+	it := listObjects(ctx, bucket, nameRange.query(ctx))
 
 	//put them into a massive slice
 	var objects []*storage.ObjectAttrs
@@ -418,38 +877,72 @@ func getRandomFilesFromBucket(ctx context.Context, bucket *storage.BucketHandle,
 		//TODO: use ctx to cancel this mid-process if requested?
 		objAttrs, err2 := it.Next()
 		if err2 == iterator.Done {
+			if nameRange.isWholeBucket() {
+				markInventoryComplete(ctx)
+			}
 			break
 		}
 		if err2 != nil {
 			err = errors.Annotate(err2, "Unable to get random sample from bucket")
 			return
 		}
-		if bannedNameRegex.MatchString(objAttrs.Name) {
+		countObjectExamined(ctx, objAttrs)
+		if bannedNameRegex.MatchString(objAttrs.Name) || !options.filter.accepts(objAttrs) {
 			continue
 		}
 		objects = append(objects, objAttrs)
 	}
 	population := len(objects)
+	if options.sizer.enabled() {
+		num = options.sizer.sampleSize(population)
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Sampling %d of %d objects under %v.", num, population, nameRange))
+	}
 	if num > population {
 		err = errors.NotFoundf("Not enough files in bucket to return requested sample size %d.", num)
 		return
 	}
+	if options.preferCheapClasses {
+		objects = preferCheapestClasses(objects, num)
+	}
+	if options.stratifyBySeason {
+		fileNames = pickSeasonStratifiedObjectNames(objects, num, nameRange.Prefix, options.avoid)
+	} else {
+		fileNames = pickRandomObjectNames(objects, num, options.avoid)
+	}
+	recordSampleClasses(ctx, objects, fileNames)
+	return
+}
 
-	files := make([]string, num)
-	//figure out which indices will be selected
-	if num == population {
-		// no need to do randomness, whole population will be returned
-		for i, obj := range objects {
-			files[i] = obj.Name
+// pickRandomObjectNames picks num of the objects at random, only picking objects named in avoid when there aren't enough others.
+// This spreads the spot checks around the bucket instead of re-picking recently verified objects by chance.
+func pickRandomObjectNames(objects []*storage.ObjectAttrs, num int, avoid map[string]bool) []string {
+	var preferred, avoided []*storage.ObjectAttrs
+	for _, obj := range objects {
+		if avoid[obj.Name] {
+			avoided = append(avoided, obj)
+		} else {
+			preferred = append(preferred, obj)
 		}
-		return files, nil
 	}
-	selections := getRandomSampleFromPopulation(num, population)
 
-	for i := 0; i < num; i++ {
-		files[i] = objects[selections[i]].Name
+	files := make([]string, 0, num)
+	for _, group := range [][]*storage.ObjectAttrs{preferred, avoided} {
+		wanted := num - len(files)
+		if wanted <= 0 {
+			break
+		}
+		if wanted >= len(group) {
+			// no need to do randomness, whole group will be returned
+			for _, obj := range group {
+				files = append(files, obj.Name)
+			}
+			continue
+		}
+		for _, selection := range getRandomSampleFromPopulation(wanted, len(group)) {
+			files = append(files, group[selection].Name)
+		}
 	}
-	return files, nil
+	return files
 }
 
 func getRandomSampleFromPopulation(sampleSize, population int) []int {
@@ -481,51 +974,155 @@ func getRandomSampleFromPopulation(sampleSize, population int) []int {
 	return sample
 }
 
-func downloadFile(ctx context.Context, bucket *storage.BucketHandle, remoteFilePath string, localFilePath string) (err error) {
+func downloadFile(ctx context.Context, bucket BackupStore, remoteFilePath string, localFilePath string) (err error) {
+	ctx, span := startSpan(ctx, "download file", attribute.String("bucket", bucket.BucketName()), attribute.String("object", remoteFilePath))
+	defer func() {
+		//already downloaded files are skipped, that's not a failure
+		if errors.IsAlreadyExists(err) {
+			span.SetAttributes(attribute.Bool("skipped", true))
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
 	obj := bucket.Object(remoteFilePath)
 	attrs, err := obj.Attrs(ctx)
 	if err != nil {
-		return errors.NotFoundf("Unable to find file in bucket at %s", remoteFilePath)
+		return getObjectAttrsError(err, remoteFilePath)
 	}
 
 	//if the file already exists and is valid, skip it
-	err = verifyDownloadedFile(attrs, localFilePath)
+	err = verifyDownloadedFile(ctx, attrs, localFilePath)
 	if err == nil {
 		//file already downloaded
+		recordVerifiedObject(ctx, attrs)
 		return errors.AlreadyExistsf("File %s has already been downloaded successfully.", localFilePath)
 	}
 
-	rc, err := obj.NewReader(ctx)
+	//download to a .partial file that is renamed into place once verified, so a crash can't leave a truncated file
+	//at the final path, and carry on from where an earlier attempt at it stopped
+	partialFilePath := getPartialFilePath(localFilePath)
+	os.MkdirAll(filepath.Dir(localFilePath), os.ModePerm)
+	offset := getResumeOffset(partialFilePath, attrs.Size)
+
+	//download in parallel range reads once adaptive downloads have found that's faster
+	tuner := downloadTunerFromContext(ctx)
+	workers, chunkBytes := tuner.settings()
+	chunked := offset == 0 && workers > 1 && attrs.Size >= 2*chunkBytes
+	//read the generation the attributes came from, so a resumed file isn't spliced from two
+	obj = obj.Generation(attrs.Generation)
+	guard := freeSpaceGuardFromContext(ctx)
+	err = guard.check(ctx, attrs.Size-offset)
+	if err != nil {
+		return err
+	}
+	injector := faultInjectorFromContext(ctx)
+	err = injector.failDownload(remoteFilePath)
 	if err != nil {
-		return errors.NotFoundf("Unable to download file at %s", remoteFilePath)
+		return err
+	}
+	var rc io.ReadCloser
+	if !chunked {
+		rc, err = obj.NewRangeReader(ctx, offset, -1)
+		if err != nil {
+			return getObjectReaderError(err, remoteFilePath)
+		}
+		defer rc.Close()
 	}
-	defer rc.Close()
 
 	//prep file
-	os.MkdirAll(filepath.Dir(localFilePath), os.ModePerm)
-	localFile, err := os.Create(localFilePath)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		fmt.Fprintln(console.stdout, fmt.Sprintf("Resuming from byte %d of %d.", offset, attrs.Size))
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	localFile, err := os.OpenFile(partialFilePath, flags, 0666)
 	if err != nil {
-		return errors.Annotatef(err, "Unable to open file %s for saving data from bucket.", localFilePath)
+		return errors.Annotatef(err, "Unable to open file %s for saving data from bucket.", partialFilePath)
 	}
 	defer localFile.Close()
 
 	//prep progress bar
-	bar := pb.New(int(attrs.Size)).SetUnits(pb.U_BYTES)
-	bar.Start()
-	reader := bar.NewProxyReader(rc)
+	bar := console.newProgressBar(remoteFilePath, attrs.Size, offset)
 	//download it
-
-	_, err = io.Copy(localFile, reader)
+	start := time.Now()
+	var written int64
+	if chunked {
+		written, err = downloadChunks(ctx, obj, localFile, attrs.Size, chunkBytes, workers, bar)
+	} else {
+		written, err = io.Copy(guard.wrapWriter(ctx, injector.wrapWriter(localFile, attrs.Size-offset), attrs.Size-offset),
+			bar.proxyReader(injector.wrapReader(rc)))
+	}
+	//a small file downloaded as one stream says nothing about how many workers to use
+	if err == nil && (chunked || workers == 1) {
+		tuner.record(written, time.Since(start), time.Now())
+	}
+	countBytesDownloaded(ctx, written)
+	span.SetAttributes(attribute.Int64("bytes", written))
 	localFile.Close()
-	bar.Finish()
+	bar.finish()
 	if err != nil {
-		return errors.Annotatef(err, "Error saving data to file %s", localFilePath)
+		if chunked {
+			//the chunks that finished aren't a prefix of the object, so there's nothing to resume from
+			os.Remove(partialFilePath)
+		}
+		return errors.Annotatef(err, "Error saving data to file %s", partialFilePath)
 	}
+	//before verifying, so the hash cache sees the final modification time
+	err = setLocalFileTimes(attrs, partialFilePath)
+	if err != nil {
+		return err
+	}
+	if fsyncDownloadsFromContext(ctx) {
+		err = syncFile(partialFilePath)
+		if err != nil {
+			return err
+		}
+	}
+	return finishPartialFile(ctx, attrs, partialFilePath, localFilePath)
+}
 
-	return verifyDownloadedFile(attrs, localFilePath)
+func getPartialFilePath(localFilePath string) string {
+	return localFilePath + ".partial"
 }
 
-func verifyDownloadedFile(objAttrs *storage.ObjectAttrs, filePath string) (err error) {
+// getResumeOffset is how much of the object an orphaned .partial file already holds, 0 to start it over.
+// One that is as big as the object or bigger is started over too, it can't be a prefix of it.
+func getResumeOffset(partialFilePath string, size int64) int64 {
+	info, err := os.Stat(partialFilePath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() >= size {
+		return 0
+	}
+	return info.Size()
+}
+
+// finishPartialFile verifies a downloaded .partial file and renames it to the final path.
+// One that fails verification is removed so the retry starts over, rather than resuming from bad data.
+func finishPartialFile(ctx context.Context, attrs *storage.ObjectAttrs, partialFilePath string, localFilePath string) (err error) {
+	//the partial file's hash is only needed once, cache it under the final path instead
+	err = verifyDownloadedFile(withHashCache(ctx, nil), attrs, partialFilePath)
+	if err != nil {
+		os.Remove(partialFilePath)
+		return err
+	}
+	if fsyncDownloadsFromContext(ctx) {
+		err = renameDurably(partialFilePath, localFilePath)
+	} else {
+		err = os.Rename(partialFilePath, localFilePath)
+	}
+	if err != nil {
+		return errors.Annotatef(err, "Unable to move downloaded file %s into place", partialFilePath)
+	}
+	if fileInfo, err2 := os.Stat(localFilePath); err2 == nil {
+		hashCacheFromContext(ctx).store(localFilePath, fileInfo, attrs.CRC32C)
+	}
+	recordVerifiedObject(ctx, attrs)
+	return nil
+}
+
+// verifyDownloadedFile checks the local file has the object's size and CRC32C.
+// The CRC32C comes from the hash cache in ctx when the file hasn't changed since it was last hashed.
+func verifyDownloadedFile(ctx context.Context, objAttrs *storage.ObjectAttrs, filePath string) (err error) {
 	if objAttrs == nil {
 		return errors.NotValidf("Cannot validate file %s against an invalid object attr record.", filePath)
 	}
@@ -540,8 +1137,24 @@ func verifyDownloadedFile(objAttrs *storage.ObjectAttrs, filePath string) (err e
 		return errors.NotValidf("Size mismatch, expected %d found %d", objAttrs.Size, fileInfo.Size())
 	}
 
+	//S3 objects only have a CRC32C when uploaded with one, otherwise their ETag is usually an MD5
+	if objAttrs.CRC32C == 0 && len(objAttrs.MD5) == 0 {
+		recordWarning(ctx, "%s has no checksum to verify against, only its size was checked", objAttrs.Name)
+		return nil
+	}
+	if objAttrs.CRC32C == 0 {
+		localMD5, err := getFileDigest(filePath, "md5")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(objAttrs.MD5, localMD5) {
+			return errors.NotValidf("Bad MD5, expected %x found %x", objAttrs.MD5, localMD5)
+		}
+		return nil
+	}
+
 	//compare CRC32C expected vs actual
-	localCRC, err := getCrc32CFromFile(filePath)
+	localCRC, err := getCachedCrc32CFromFile(ctx, filePath, fileInfo)
 	remoteCRC := objAttrs.CRC32C
 	if remoteCRC != localCRC {
 		return errors.NotValidf("Bad CRC, expected %d found %d", remoteCRC, localCRC)
@@ -550,6 +1163,7 @@ func verifyDownloadedFile(objAttrs *storage.ObjectAttrs, filePath string) (err e
 }
 
 // getCrc32CFromFile calculates theCRC32 checksum of the file's contents using the Castagnoli93 polynomial
+// Large files are hashed in chunks across all CPU cores.
 func getCrc32CFromFile(filePath string) (crc uint32, err error) {
 	//from http://mrwaggel.be/post/generate-crc32-hash-of-a-file-in-golang-turorial/
 	file, err := os.Open(filePath)
@@ -559,6 +1173,11 @@ func getCrc32CFromFile(filePath string) (crc uint32, err error) {
 	}
 	defer file.Close()
 
+	fileInfo, err := file.Stat()
+	if err == nil && fileInfo.Size() >= 2*parallelHashChunkSize && runtime.NumCPU() > 1 {
+		return getCrc32CFromFileInChunks(file, fileInfo.Size(), parallelHashChunkSize, runtime.NumCPU())
+	}
+
 	tablePolynomial := crc32.MakeTable(crc32.Castagnoli)
 	hash := crc32.New(tablePolynomial)
 
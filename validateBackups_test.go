@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -175,10 +186,21 @@ var testFileConfigCases = []struct {
 			PhotosFromThisMonth:  3,
 			PhotosFromEachYear:   4,
 		},
+		Profiles: map[string]RunProfile{
+			"nightly":   {SkipDownloads: true},
+			"quarterly": {ChecksumOnly: true, SampleMultiplier: 5},
+		},
 		Buckets: []BucketToProcess{
-			{Name: "bucket-one", Type: "media"},
+			{Name: "bucket-one", Type: "media", MetadataRules: []MetadataRule{{
+				Prefix:               "show 1/",
+				ContentType:          "video/*",
+				RequiredMetadataKeys: []string{"x-goog-meta-source-host"},
+			}}},
 			{Name: "bucket-two", Type: "photo"},
-			{Name: "bucket-three", Type: "server-backup"},
+			{Name: "bucket-three", Type: "server-backup", VerifyDeletedVersionDays: 30, FreshnessFilter: ObjectNameFilter{
+				NamePattern:     `\.tar\.gz$`,
+				ExcludePatterns: []string{"^README"},
+			}, RestoreDrill: PostDownloadHook{Command: []string{"tar", "-tzf", "{file}"}, TimeoutSeconds: 900}},
 		}},
 	},
 	//handle values added in any order in the config file
@@ -202,19 +224,20 @@ var testFileConfigCases = []struct {
 			{Name: "bucket-three", Type: "server-backup"},
 		}},
 	},
-	//handle if some values are missing in the config file
+	//handle if some values are missing in the config file, they keep their defaults
 	{"partialConfig.json", Config{
 		GoogleAuthFileLocation: "over-here",
 		ServerBackupRules: ServerFileValidationRules{
 			OldestFileMaxAgeInDays: 10,
 			NewestFileMaxAgeInDays: 2,
 		},
+		FilesToDownload: configDefaults.FilesToDownload,
 		Buckets: []BucketToProcess{
 			{Name: "bucket-a", Type: "photo"},
 		}},
 	},
 	//handle an entirely empty config file
-	{"emptyConfig.json", Config{}},
+	{"emptyConfig.json", configDefaults},
 }
 
 func TestLoadConfigurationFromFile(t *testing.T) {
@@ -271,13 +294,13 @@ func TestValidateBucketsInConfig(t *testing.T) {
 		t.Error("Could not prep test case for validating photos bucket.")
 	}
 
-	actual, err := validateBucketsInConfig(ctx, testClient, config)
+	actual, err := validateBucketsInConfig(ctx, testClient, config, nil)
 	is.NoError(err, "Should not error when validating good bucket types")
 	is.True(actual, "Should return true when validations are successful")
 
 	missingBucketName := "does-not-exist"
 	config.Buckets = []BucketToProcess{{Name: missingBucketName, Type: "media"}}
-	actual, missingBucketErr := validateBucketsInConfig(ctx, testClient, config)
+	actual, missingBucketErr := validateBucketsInConfig(ctx, testClient, config, nil)
 	is.Error(missingBucketErr, "Should error when config has a bucket that doesn't exist")
 	is.False(actual, "Should return false if there is an error during validation")
 
@@ -316,18 +339,18 @@ func TestGetObjectsToDownloadFromBucketsInConfig(t *testing.T) {
 			"newest.txt", "new2.txt", "new3.txt", "new4.txt",
 		}},
 	}
-	actual, err := getObjectsToDownloadFromBucketsInConfig(ctx, testClient, config)
+	actual, err := getObjectsToDownloadFromBucketsInConfig(ctx, testClient, config, nil, nil)
 	is.NoError(err, "Should not error when getting objects from valid buckets")
 	is.Equal(expected, actual)
 
 	missingBucketName := "does-not-exist"
 	config.Buckets = []BucketToProcess{{Name: missingBucketName, Type: "photo"}}
-	_, missingBucketErr := getObjectsToDownloadFromBucketsInConfig(ctx, testClient, config)
+	_, missingBucketErr := getObjectsToDownloadFromBucketsInConfig(ctx, testClient, config, nil, nil)
 	is.Error(missingBucketErr, "Should error when trying to get objects from bucket that doesn't exist")
 
 	missingValidationTypeBucketName := "test-matt-empty"
 	config.Buckets = []BucketToProcess{{Name: missingValidationTypeBucketName, Type: "empty"}}
-	_, missingValidationTypeErr := getObjectsToDownloadFromBucketsInConfig(ctx, testClient, config)
+	_, missingValidationTypeErr := getObjectsToDownloadFromBucketsInConfig(ctx, testClient, config, nil, nil)
 	is.Error(missingValidationTypeErr, "Should error when validation type doesn't have matching get objects logic")
 }
 
@@ -351,7 +374,7 @@ func TestSaveInProgressFile(t *testing.T) {
 	tempFileName := tempF.Name()
 	defer os.RemoveAll(tempDir)
 
-	data := []BucketAndFiles{
+	buckets := []BucketAndFiles{
 		{"test-matt-media", []string{
 			"show 1/season 1/01x01 episode.ogv",
 			"show 1/season 1/S01E22 episode.ogv",
@@ -368,6 +391,13 @@ func TestSaveInProgressFile(t *testing.T) {
 		}},
 	}
 
+	data := InProgressState{
+		RunID:    "20180120T010203Z-1a2b",
+		Started:  time.Date(2018, 1, 20, 1, 2, 3, 0, time.UTC),
+		Buckets:  buckets,
+		Progress: DownloadProgress{TotalBytes: 123456, BytesDownloaded: 2048, ElapsedSeconds: 12.5},
+	}
+
 	err = saveInProgressFile("", data)
 	is.Error(err, "Should error when saving to a blank path")
 
@@ -384,8 +414,9 @@ func TestLoadInProgressFile(t *testing.T) {
 		t.Error("Could not determine current directory")
 	}
 	testFilePath := filepath.Join(workingDir, "testdata", "inProgressData.json")
+	legacyTestFilePath := filepath.Join(workingDir, "testdata", "inProgressDataLegacy.json")
 
-	expected := []BucketAndFiles{
+	expectedBuckets := []BucketAndFiles{
 		{"test-matt-media", []string{
 			"show 1/season 1/01x01 episode.ogv",
 			"show 1/season 1/S01E22 episode.ogv",
@@ -405,9 +436,19 @@ func TestLoadInProgressFile(t *testing.T) {
 	_, err = loadInProgressFile("")
 	is.Error(err, "Should error when loading a file that doesn't exist")
 
+	expected := InProgressState{
+		RunID:    "20180120T010203Z-1a2b",
+		Started:  time.Date(2018, 1, 20, 1, 2, 3, 0, time.UTC),
+		Buckets:  expectedBuckets,
+		Progress: DownloadProgress{TotalBytes: 123456, BytesDownloaded: 2048, ElapsedSeconds: 12.5},
+	}
 	actual, err := loadInProgressFile(testFilePath)
 	is.NoError(err, "Should not error when loading good data from good file path.")
 	is.Equal(expected, actual, "Loaded file contents should match expected.")
+
+	actual, err = loadInProgressFile(legacyTestFilePath)
+	is.NoError(err, "Should not error when loading a file saved before run ids were added.")
+	is.Equal(InProgressState{Buckets: expectedBuckets}, actual, "Loaded legacy file contents should match expected.")
 }
 
 func TestDownloadFilesFromBucketAndFiles(t *testing.T) {
@@ -429,13 +470,13 @@ func TestDownloadFilesFromBucketAndFiles(t *testing.T) {
 			[]string{"2015-02/IMG_02.gif", "2016-10/IMG_10.gif"}},
 	}
 
-	goodBucketErr := downloadFilesFromBucketAndFiles(ctx, testClient, config, mapping)
+	goodBucketErr := downloadFilesFromBucketAndFiles(ctx, testClient, config, mapping, nil)
 	is.NoError(goodBucketErr, "Should not error when downloading good files from good bucket")
 
 	//TODO: figure out why this test fails on travis CI
 	/*
 		config.FileDownloadLocation = "E:/lol/"
-		badLocationErr := downloadFilesFromBucketAndFiles(ctx, testClient, config, mapping)
+		badLocationErr := downloadFilesFromBucketAndFiles(ctx, testClient, config, mapping, nil)
 		is.Error(badLocationErr, "Should error when downloading files to invalid location")
 	*/
 }
@@ -462,25 +503,25 @@ func TestValidateBucket(t *testing.T) {
 	}
 
 	for _, tb := range config.Buckets {
-		bucket := testClient.Bucket(tb.Name)
+		bucket := newGCSStore(testClient.Bucket(tb.Name))
 		err := validateBucket(ctx, bucket, config)
 		is.NoError(err, "Should not error when validating a bucket type that passes validations")
 	}
 
 	missingBucketName := "does-not-exist"
-	missingBucket := testClient.Bucket(missingBucketName)
+	missingBucket := newGCSStore(testClient.Bucket(missingBucketName))
 	missingBucketErr := validateBucket(ctx, missingBucket, config)
 	is.Error(missingBucketErr, "Should error when validating a bucket that doesn't exist")
 
 	missingValidationTypeBucketName := "test-matt-empty"
 	config.Buckets = append(config.Buckets, BucketToProcess{Name: missingValidationTypeBucketName, Type: "empty"})
-	missingValidationTypeBucket := testClient.Bucket(missingValidationTypeBucketName)
+	missingValidationTypeBucket := newGCSStore(testClient.Bucket(missingValidationTypeBucketName))
 	missingValidationTypeErr := validateBucket(ctx, missingValidationTypeBucket, config)
 	is.Error(missingValidationTypeErr, "Should error when validation type doesn't have matching validation logic")
 
 	failBucketName := "test-matt-server-backups"
 	config.Buckets = append(config.Buckets, BucketToProcess{Name: failBucketName, Type: "server-backup"})
-	failBucket := testClient.Bucket(failBucketName)
+	failBucket := newGCSStore(testClient.Bucket(failBucketName))
 	failBucketErr := validateBucket(ctx, failBucket, config)
 	is.Error(failBucketErr, "Should error when validations fail")
 }
@@ -504,37 +545,37 @@ func TestGetObjectsToDownloadFromBucket(t *testing.T) {
 		}}
 
 	for _, tb := range config.Buckets {
-		bucket := testClient.Bucket(tb.Name)
-		_, err := getObjectsToDownloadFromBucket(ctx, bucket, config)
+		bucket := newGCSStore(testClient.Bucket(tb.Name))
+		_, err := getObjectsToDownloadFromBucket(ctx, bucket, config, nil)
 		is.NoError(err, "Should not error when getting objects from valid buckets")
 	}
 
 	missingBucketName := "does-not-exist"
-	missingBucket := testClient.Bucket(missingBucketName)
-	_, missingBucketErr := getObjectsToDownloadFromBucket(ctx, missingBucket, config)
+	missingBucket := newGCSStore(testClient.Bucket(missingBucketName))
+	_, missingBucketErr := getObjectsToDownloadFromBucket(ctx, missingBucket, config, nil)
 	is.Error(missingBucketErr, "Should error when trying to get objects from bucket that doesn't exist")
 
 	missingValidationTypeBucketName := "test-matt-empty"
 	config.Buckets = append(config.Buckets, BucketToProcess{Name: missingValidationTypeBucketName, Type: "empty"})
-	missingValidationTypeBucket := testClient.Bucket(missingValidationTypeBucketName)
-	_, missingValidationTypeErr := getObjectsToDownloadFromBucket(ctx, missingValidationTypeBucket, config)
+	missingValidationTypeBucket := newGCSStore(testClient.Bucket(missingValidationTypeBucketName))
+	_, missingValidationTypeErr := getObjectsToDownloadFromBucket(ctx, missingValidationTypeBucket, config, nil)
 	is.Error(missingValidationTypeErr, "Should error when validation type doesn't have matching get objects logic")
 
 	tooFewFilesBucketName := "test-matt-empty"
-	tooFewFilesBucket := testClient.Bucket(tooFewFilesBucketName)
+	tooFewFilesBucket := newGCSStore(testClient.Bucket(tooFewFilesBucketName))
 	config.Buckets = []BucketToProcess{{Name: tooFewFilesBucketName, Type: "photo"}}
-	_, tooFewFilesErr := getObjectsToDownloadFromBucket(ctx, tooFewFilesBucket, config)
+	_, tooFewFilesErr := getObjectsToDownloadFromBucket(ctx, tooFewFilesBucket, config, nil)
 	is.Error(tooFewFilesErr, "Should error when bucket doesn't have enough files to get")
 
 	config.Buckets = []BucketToProcess{{Name: tooFewFilesBucketName, Type: "server-backup"}}
-	_, tooFewFilesErr = getObjectsToDownloadFromBucket(ctx, tooFewFilesBucket, config)
+	_, tooFewFilesErr = getObjectsToDownloadFromBucket(ctx, tooFewFilesBucket, config, nil)
 	is.Error(tooFewFilesErr, "Should error when bucket doesn't have enough files to get")
 
 	config.FilesToDownload.EpisodesFromEachShow = 7
 	mediaBucketName := "test-matt-media"
-	mediaBucket := testClient.Bucket(mediaBucketName)
+	mediaBucket := newGCSStore(testClient.Bucket(mediaBucketName))
 	config.Buckets = []BucketToProcess{{Name: mediaBucketName, Type: "media"}}
-	_, mediaBucketErr := getObjectsToDownloadFromBucket(ctx, mediaBucket, config)
+	_, mediaBucketErr := getObjectsToDownloadFromBucket(ctx, mediaBucket, config, nil)
 	is.Error(mediaBucketErr, "Should error when bucket doesn't have enough files to get")
 }
 
@@ -556,15 +597,15 @@ func TestDownloadFilesFromBucket(t *testing.T) {
 		"2015-02/IMG_02.gif", "2016-10/IMG_10.gif",
 	}
 
-	missingBucket := testClient.Bucket("does-not-exist")
+	missingBucket := newGCSStore(testClient.Bucket("does-not-exist"))
 	missingBucketErr := downloadFilesFromBucket(ctx, missingBucket, files, config)
 	is.Error(missingBucketErr, "Should error when trying to get objects from bucket that doesn't exist")
 
-	emptyBucket := testClient.Bucket("test-matt-empty")
+	emptyBucket := newGCSStore(testClient.Bucket("test-matt-empty"))
 	emptyBucketErr := downloadFilesFromBucket(ctx, emptyBucket, files, config)
 	is.Error(emptyBucketErr, "Should error when unable to find files in bucket")
 
-	goodBucket := testClient.Bucket("test-matt-photos")
+	goodBucket := newGCSStore(testClient.Bucket("test-matt-photos"))
 	goodBucketErr := downloadFilesFromBucket(ctx, goodBucket, files, config)
 	is.NoError(goodBucketErr, "Should not error when downloading good files from good bucket")
 
@@ -592,11 +633,11 @@ func TestValidateServerBackups(t *testing.T) {
 	if err != nil {
 		t.Error("Could not prep test case for validating server backups.")
 	}
-	happyPathErr := validateServerBackups(ctx, happyPathBucket, rules)
+	happyPathErr := validateServerBackups(ctx, newGCSStore(happyPathBucket), rules, nil, nil, nil)
 	is.NoError(happyPathErr, "Should not error when bucket has a freshly uploaded file")
 
-	badBucket := testClient.Bucket("does-not-exist")
-	badBucketErr := validateServerBackups(ctx, badBucket, rules)
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
+	badBucketErr := validateServerBackups(ctx, badBucket, rules, nil, nil, nil)
 	is.Error(badBucketErr, "Should error when validating a non existent bucket")
 
 	//TODO: figure out why empty bucket is not failing validation as expected
@@ -605,17 +646,38 @@ func TestValidateServerBackups(t *testing.T) {
 		emptyErr := validateServerBackups(emptyBucket, rules)
 		is.Error(emptyErr, "Should error when validating a bucket with no objects")
 	*/
-	veryOldFileBucket := testClient.Bucket("test-matt-server-backups-old")
-	veryOldFileErr := validateServerBackups(ctx, veryOldFileBucket, rules)
+	veryOldFileBucket := newGCSStore(testClient.Bucket("test-matt-server-backups-old"))
+	veryOldFileErr := validateServerBackups(ctx, veryOldFileBucket, rules, nil, nil, nil)
 	is.Error(veryOldFileErr, "Should error when bucket has oldest file past archive cutoff")
 
 	rules.NewestFileMaxAgeInDays = 0
-	newFileTooOldErr := validateServerBackups(ctx, happyPathBucket, rules)
+	newFileTooOldErr := validateServerBackups(ctx, newGCSStore(happyPathBucket), rules, nil, nil, nil)
 	is.Error(newFileTooOldErr, "Should error when bucket has newest file past cutoff")
 
 	//TODO: somehow make checking oldest file pass but fail on figuring out the newest file... how is this branch testable?
 }
 
+func TestValidateServerBackupsWithStaticPrefixes(t *testing.T) {
+	is := assert.New(t)
+	now := time.Now()
+	rules := ServerFileValidationRules{OldestFileMaxAgeInDays: 30, NewestFileMaxAgeInDays: 2}
+	imported := &storage.ObjectAttrs{Name: "servers/import-2015/db.tar.gz", Created: now.AddDate(-11, 0, 0), Size: 10}
+	recent := &storage.ObjectAttrs{Name: "servers/nightly/db.tar.gz", Created: now.AddDate(0, 0, -1), Size: 10}
+	report := &inventoryReport{objects: []*storage.ObjectAttrs{imported, recent}}
+	ctx := withBucketPrefix(withInventoryReport(context.Background(), report), "servers/")
+
+	err := validateServerBackups(ctx, nil, rules, nil, nil, nil)
+	is.True(errors.IsNotValid(err), "Should fail on the import without static prefixes")
+	is.Contains(err.Error(), "Oldest file servers/import-2015/db.tar.gz")
+	is.NoError(validateServerBackups(ctx, nil, rules, nil, nil, []string{"import-2015/"}),
+		"Should leave objects under a static prefix, relative to the bucket prefix, out of the oldest check")
+
+	report.objects = []*storage.ObjectAttrs{imported}
+	err = validateServerBackups(ctx, nil, rules, nil, nil, []string{"import-2015/"})
+	is.True(errors.IsNotValid(err), "Should still check freshness when every object is static")
+	is.Contains(err.Error(), "Newest file servers/import-2015/db.tar.gz")
+}
+
 func TestGetMediaFilesToDownload(t *testing.T) {
 	is := assert.New(t)
 	ctx := context.Background()
@@ -627,21 +689,42 @@ func TestGetMediaFilesToDownload(t *testing.T) {
 		PhotosFromEachYear:   10,
 	}
 
-	happyPathBucket := testClient.Bucket("test-matt-media")
-	actual, err := getMediaFilesToDownload(ctx, happyPathBucket, rules)
+	happyPathBucket := newGCSStore(testClient.Bucket("test-matt-media"))
+	actual, err := getMediaFilesToDownload(ctx, happyPathBucket, rules, samplingOptions{})
 	is.Equal(9, len(actual))
 	is.NoError(err, "Should not error when getting files to download from valid media bucket")
 
 	rules.EpisodesFromEachShow = 4
-	_, notEnoughShowsErr := getMediaFilesToDownload(ctx, happyPathBucket, rules)
+	_, notEnoughShowsErr := getMediaFilesToDownload(ctx, happyPathBucket, rules, samplingOptions{})
 	is.Error(notEnoughShowsErr, "Should error when there are not enough episodes to get of each show")
 
-	badBucket := testClient.Bucket("does-not-exist")
-	_, badBucketErr := getMediaFilesToDownload(ctx, badBucket, rules)
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
+	_, badBucketErr := getMediaFilesToDownload(ctx, badBucket, rules, samplingOptions{})
 	is.Error(badBucketErr, "Should error when getting files to download from a non existent bucket")
 
 }
 
+func TestGetMediaFilesToDownloadAtShowDepth(t *testing.T) {
+	is := assert.New(t)
+	report := &inventoryReport{}
+	for _, name := range []string{"cartoons/show 1/season 1/episode 1.mkv", "cartoons/show 1/season 1/episode 2.mkv",
+		"drama/show 2/season 1/episode 1.mkv", "drama/show 2/season 2/episode 1.mkv", "drama/trailer.mkv"} {
+		report.objects = append(report.objects, &storage.ObjectAttrs{Name: name, Size: 10})
+	}
+	ctx := withInventoryReport(context.Background(), report)
+	rules := FileDownloadRules{EpisodesFromEachShow: 2}
+
+	actual, err := getMediaFilesToDownload(ctx, nil, rules, samplingOptions{showPrefixDepth: 2})
+	is.NoError(err)
+	is.ElementsMatch([]string{"cartoons/show 1/season 1/episode 1.mkv", "cartoons/show 1/season 1/episode 2.mkv",
+		"drama/show 2/season 1/episode 1.mkv", "drama/show 2/season 2/episode 1.mkv"}, actual, "Should sample each show, not each library")
+
+	_, err = getMediaFilesToDownload(ctx, nil, FileDownloadRules{EpisodesFromEachShow: 3}, samplingOptions{})
+	is.ErrorContains(err, "from show cartoons/", "Should treat each library as a show at the default depth")
+	_, err = getMediaFilesToDownload(ctx, nil, rules, samplingOptions{showPrefixDepth: -1})
+	is.True(errors.IsNotValid(errors.Cause(err)), "Should error on a negative depth: %v", err)
+}
+
 func TestGetPhotosToDownload(t *testing.T) {
 	is := assert.New(t)
 	ctx := context.Background()
@@ -653,27 +736,28 @@ func TestGetPhotosToDownload(t *testing.T) {
 		PhotosFromEachYear:   10,
 	}
 
-	happyPathBucket := testClient.Bucket("test-matt-photos")
-	err := uploadThisMonthPhotos(ctx, happyPathBucket)
+	photosBucket := testClient.Bucket("test-matt-photos")
+	err := uploadThisMonthPhotos(ctx, photosBucket)
 	if err != nil {
 		t.Error("Could not prep test case for getting photos to download.")
 	}
+	happyPathBucket := newGCSStore(photosBucket)
 	years := time.Now().Year() - 2009 //
 	expected := years*rules.PhotosFromEachYear + rules.PhotosFromThisMonth
-	actual, err := getPhotosToDownload(ctx, happyPathBucket, rules)
+	actual, err := getPhotosToDownload(ctx, happyPathBucket, rules, samplingOptions{})
 	is.Equal(expected, len(actual))
 	is.NoError(err, "Should not error when getting files to download from valid photos bucket")
 
 	rules.PhotosFromThisMonth = 11
-	_, notEnoughMonthPhotosErr := getPhotosToDownload(ctx, happyPathBucket, rules)
+	_, notEnoughMonthPhotosErr := getPhotosToDownload(ctx, happyPathBucket, rules, samplingOptions{})
 	is.Error(notEnoughMonthPhotosErr, "Should error when there are not enough photos to get of this month")
 
 	rules.PhotosFromEachYear = 11
-	_, notEnoughYearPhotosErr := getPhotosToDownload(ctx, happyPathBucket, rules)
+	_, notEnoughYearPhotosErr := getPhotosToDownload(ctx, happyPathBucket, rules, samplingOptions{})
 	is.Error(notEnoughYearPhotosErr, "Should error when there are not enough photos to get of each year")
 
-	badBucket := testClient.Bucket("does-not-exist")
-	_, badBucketErr := getPhotosToDownload(ctx, badBucket, rules)
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
+	_, badBucketErr := getPhotosToDownload(ctx, badBucket, rules, samplingOptions{})
 	is.Error(badBucketErr, "Should error when getting files to download from a non existent bucket")
 }
 
@@ -688,18 +772,18 @@ func TestGetServerBackupsToDownload(t *testing.T) {
 		PhotosFromEachYear:   10,
 	}
 
-	happyPathBucket := testClient.Bucket("test-matt-server-backups")
+	happyPathBucket := newGCSStore(testClient.Bucket("test-matt-server-backups"))
 	expected := []string{"newest.txt", "new2.txt", "new3.txt", "new4.txt"}
-	actual, err := getServerBackupsToDownload(ctx, happyPathBucket, rules)
+	actual, err := getServerBackupsToDownload(ctx, happyPathBucket, rules, samplingOptions{})
 	is.Equal(expected, actual)
 	is.NoError(err, "Should not error when getting files to download from valid server backup bucket")
 
-	badBucket := testClient.Bucket("does-not-exist")
-	_, badBucketErr := getServerBackupsToDownload(ctx, badBucket, rules)
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
+	_, badBucketErr := getServerBackupsToDownload(ctx, badBucket, rules, samplingOptions{})
 	is.Error(badBucketErr, "Should error when getting files to download from a non existent bucket")
 
-	emptyBucket := testClient.Bucket("test-matt-empty")
-	_, emptyBucketErr := getServerBackupsToDownload(ctx, emptyBucket, rules)
+	emptyBucket := newGCSStore(testClient.Bucket("test-matt-empty"))
+	_, emptyBucketErr := getServerBackupsToDownload(ctx, emptyBucket, rules, samplingOptions{})
 	is.Error(emptyBucketErr, "Should error when getting files to download from an empty bucket")
 }
 
@@ -718,23 +802,76 @@ func TestGetBucketTopLevelDirs(t *testing.T) {
 
 	for _, tc := range testBucketTopLevelDirsCases {
 		expected := tc.expected
-		bucket := testClient.Bucket(tc.bucketName)
+		bucket := newGCSStore(testClient.Bucket(tc.bucketName))
 		actual, err := getBucketTopLevelDirs(ctx, bucket)
 		is.NoError(err, "Should not error when reading from a populated test bucket")
 		is.Equal(expected, actual)
 	}
 
-	emptyBucket := testClient.Bucket("test-matt-empty")
+	emptyBucket := newGCSStore(testClient.Bucket("test-matt-empty"))
 	actual, err := getBucketTopLevelDirs(ctx, emptyBucket)
 	is.Empty(actual, "Should not find any dirs in an empty bucket")
 	is.NoError(err, "Should not error when reading from an empty bucket")
 
-	badBucket := testClient.Bucket("does-not-exist")
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
 	_, err = getBucketTopLevelDirs(ctx, badBucket)
 	is.Error(err, "Should error when reading from a non existent bucket")
 
 }
 
+var testListPrefixesObjects = map[string][]byte{
+	"readme.txt":                            []byte("not in a directory"),
+	"alpha/":                                nil,
+	"alpha/2018-01-01/db.sql.gz":            []byte("db"),
+	"alpha/2018-01-02/db.sql.gz":            []byte("db"),
+	"alpha/notes.txt":                       []byte("not in a date"),
+	"beta/2018-01-01/":                      nil,
+	"beta/2018-01-02/files.tar.gz":          []byte("files"),
+	"gamma/2018-01-01/":                     nil,
+	"library/show 1/season 1/episode 1.mkv": []byte("episode"),
+	"library/show 2/season 1/episode 1.mkv": []byte("episode"),
+}
+
+var testListPrefixesCases = []struct {
+	basePrefix string
+	depth      int
+	expected   []string
+}{
+	{"", 1, []string{"alpha/", "beta/", "library/"}},
+	{"", 2, []string{"alpha/2018-01-01/", "alpha/2018-01-02/", "beta/2018-01-02/", "library/show 1/", "library/show 2/"}},
+	{"library", 1, []string{"library/show 1/", "library/show 2/"}},
+	{"library/", 2, []string{"library/show 1/season 1/", "library/show 2/season 1/"}},
+	{"library/", 3, nil},
+	{"missing/", 1, nil},
+}
+
+func TestListPrefixes(t *testing.T) {
+	is := assert.New(t)
+	client, _ := newTestStorageServer(t, "test-matt-server-backups", testListPrefixesObjects)
+	bucket := newGCSStore(client.Bucket("test-matt-server-backups"))
+	report := &inventoryReport{}
+	for name, content := range testListPrefixesObjects {
+		report.objects = append(report.objects, &storage.ObjectAttrs{Name: name, Size: int64(len(content))})
+	}
+	sort.Slice(report.objects, func(i, j int) bool { return report.objects[i].Name < report.objects[j].Name })
+
+	for _, tc := range testListPrefixesCases {
+		actual, err := listPrefixes(context.Background(), bucket, tc.basePrefix, tc.depth)
+		is.NoError(err, "case %q depth %d", tc.basePrefix, tc.depth)
+		is.Equal(tc.expected, actual, "case %q depth %d", tc.basePrefix, tc.depth)
+
+		actual, err = listPrefixes(withInventoryReport(context.Background(), report), nil, tc.basePrefix, tc.depth)
+		is.NoError(err, "case %q depth %d from an inventory report", tc.basePrefix, tc.depth)
+		is.Equal(tc.expected, actual, "case %q depth %d from an inventory report", tc.basePrefix, tc.depth)
+	}
+
+	actual, err := listPrefixes(withBucketPrefix(context.Background(), "library/"), bucket, "", 1)
+	is.NoError(err)
+	is.Equal([]string{"library/show 1/", "library/show 2/"}, actual, "Should list under the bucket prefix")
+	_, err = listPrefixes(context.Background(), bucket, "", 0)
+	is.True(errors.IsNotValid(err), "Should error on a depth less than 1")
+}
+
 var testGetBucketValidationTypeFromNameAndConfigCases = []struct {
 	name     string
 	expected string
@@ -766,18 +903,18 @@ func TestGetNewestObjectFromBucket(t *testing.T) {
 	is := assert.New(t)
 	ctx := context.Background()
 	testClient := getTestClient(ctx, t)
-	bucket := testClient.Bucket("test-matt-server-backups")
-	actual, err := getNewestObjectFromBucket(ctx, bucket)
+	bucket := newGCSStore(testClient.Bucket("test-matt-server-backups"))
+	actual, err := getNewestObjectFromBucket(ctx, bucket, nil)
 	is.NoError(err, "Should not error when getting latest object from bucket")
 	is.Equal("newest.txt", actual.Name)
 
-	emptyBucket := testClient.Bucket("test-matt-empty")
-	actualEmpty, err := getNewestObjectFromBucket(ctx, emptyBucket)
+	emptyBucket := newGCSStore(testClient.Bucket("test-matt-empty"))
+	actualEmpty, err := getNewestObjectFromBucket(ctx, emptyBucket, nil)
 	is.Nil(actualEmpty, "Should not find any dirs in an empty bucket")
 	is.NoError(err, "Should not error when reading from an empty bucket")
 
-	badBucket := testClient.Bucket("does-not-exist")
-	_, err = getNewestObjectFromBucket(ctx, badBucket)
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
+	_, err = getNewestObjectFromBucket(ctx, badBucket, nil)
 	is.Error(err, "Should error when reading from a non existent bucket")
 }
 
@@ -785,18 +922,18 @@ func TestGetOldestObjectFromBucket(t *testing.T) {
 	is := assert.New(t)
 	ctx := context.Background()
 	testClient := getTestClient(ctx, t)
-	bucket := testClient.Bucket("test-matt-server-backups")
-	actual, err := getOldestObjectFromBucket(ctx, bucket)
+	bucket := newGCSStore(testClient.Bucket("test-matt-server-backups"))
+	actual, err := getOldestObjectFromBucket(ctx, bucket, nil)
 	is.NoError(err, "Should not error when getting latest object from bucket")
 	is.Equal("oldest.txt", actual.Name)
 
-	emptyBucket := testClient.Bucket("test-matt-empty")
-	actualEmpty, err := getOldestObjectFromBucket(ctx, emptyBucket)
+	emptyBucket := newGCSStore(testClient.Bucket("test-matt-empty"))
+	actualEmpty, err := getOldestObjectFromBucket(ctx, emptyBucket, nil)
 	is.Nil(actualEmpty, "Should not find any dirs in an empty bucket")
 	is.NoError(err, "Should not error when reading from an empty bucket")
 
-	badBucket := testClient.Bucket("does-not-exist")
-	_, err = getOldestObjectFromBucket(ctx, badBucket)
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
+	_, err = getOldestObjectFromBucket(ctx, badBucket, nil)
 	is.Error(err, "Should error when reading from a non existent bucket")
 }
 
@@ -805,23 +942,23 @@ func TestGetRandomFilesFromBucket(t *testing.T) {
 	ctx := context.Background()
 	testClient := getTestClient(ctx, t)
 
-	emptyBucket := testClient.Bucket("test-matt-empty")
-	actualEmpty, err := getRandomFilesFromBucket(ctx, emptyBucket, 0, "")
+	emptyBucket := newGCSStore(testClient.Bucket("test-matt-empty"))
+	actualEmpty, err := getRandomFilesFromBucket(ctx, emptyBucket, 0, "", samplingOptions{})
 	is.Nil(actualEmpty, "Should not find any files in an empty bucket")
 	is.NoError(err, "Should not error when reading from an empty bucket")
 
-	badBucket := testClient.Bucket("does-not-exist")
-	_, err = getRandomFilesFromBucket(ctx, badBucket, 1, "")
+	badBucket := newGCSStore(testClient.Bucket("does-not-exist"))
+	_, err = getRandomFilesFromBucket(ctx, badBucket, 1, "", samplingOptions{})
 	is.Error(err, "Should error when reading from a non existent bucket")
 
-	goodBucketFewFiles := testClient.Bucket("test-matt-server-backups-old")
-	_, err = getRandomFilesFromBucket(ctx, goodBucketFewFiles, -1, "")
+	goodBucketFewFiles := newGCSStore(testClient.Bucket("test-matt-server-backups-old"))
+	_, err = getRandomFilesFromBucket(ctx, goodBucketFewFiles, -1, "", samplingOptions{})
 	is.Error(err, "Should error when requesting a negative number of files")
-	_, err = getRandomFilesFromBucket(ctx, goodBucketFewFiles, 10, "")
+	_, err = getRandomFilesFromBucket(ctx, goodBucketFewFiles, 10, "", samplingOptions{})
 	is.Error(err, "Should error when requesting more files than are available")
 
-	goodBucketManyFiles := testClient.Bucket("test-matt-media")
-	manyFiles, err := getRandomFilesFromBucket(ctx, goodBucketManyFiles, 5, "")
+	goodBucketManyFiles := newGCSStore(testClient.Bucket("test-matt-media"))
+	manyFiles, err := getRandomFilesFromBucket(ctx, goodBucketManyFiles, 5, "", samplingOptions{})
 	is.NoError(err, "Should not error when requesting fewer files than are available")
 	is.Equal(5, len(manyFiles), "Should get 5 file names back when requesting 5 files")
 }
@@ -863,8 +1000,8 @@ func TestDownloadFile(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 
 	expectedFileName := filepath.Join(workingDir, "testdata", "Red_1x1.gif")
-	goodBucket := testClient.Bucket("test-matt-photos")
-	emptyBucket := testClient.Bucket("test-matt-empty")
+	goodBucket := newGCSStore(testClient.Bucket("test-matt-photos"))
+	emptyBucket := newGCSStore(testClient.Bucket("test-matt-empty"))
 
 	err = downloadFile(ctx, emptyBucket, "2014-11/IMG_09.gif", tempFileName)
 	is.Error(err, "Should error when downloading a file that doesn't exist.")
@@ -884,6 +1021,127 @@ func TestDownloadFile(t *testing.T) {
 	is.True(equal, "Saved file contents should match expected.")
 }
 
+// newTestStorageServer serves objects of a single bucket like the json api lists them and serves their attributes,
+// and the xml api their contents, recording the range of each read, so downloads can be tested without cloud storage.
+// An object's generation is the CRC32C of its contents, so changing them changes it.
+func newTestStorageServer(t *testing.T, bucketName string, objects map[string][]byte) (client *storage.Client, ranges *[]string) {
+	ranges = &[]string{}
+	getAttrs := func(name string, content []byte) map[string]string {
+		crc := crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+		encoded := make([]byte, 4)
+		binary.BigEndian.PutUint32(encoded, crc)
+		return map[string]string{"bucket": bucketName, "name": name, "generation": strconv.FormatUint(uint64(crc), 10),
+			"size": strconv.Itoa(len(content)), "crc32c": base64.StdEncoding.EncodeToString(encoded)}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/storage/v1/b/"+bucketName+"/o" {
+			prefix, delimiter := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+			var names []string
+			prefixes := []string{}
+			for name := range objects {
+				if !strings.HasPrefix(name, prefix) || name < r.URL.Query().Get("startOffset") {
+					continue
+				}
+				if i := strings.Index(name[len(prefix):], delimiter); len(delimiter) > 0 && i >= 0 {
+					if dir := name[:len(prefix)+i+len(delimiter)]; !slices.Contains(prefixes, dir) {
+						prefixes = append(prefixes, dir)
+					}
+					continue
+				}
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			sort.Strings(prefixes)
+			items := []map[string]string{}
+			for _, name := range names {
+				items = append(items, getAttrs(name, objects[name]))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#objects", "items": items, "prefixes": prefixes})
+			return
+		}
+		name, isAttrs := strings.CutPrefix(r.URL.Path, "/storage/v1/b/"+bucketName+"/o/")
+		if !isAttrs {
+			name = strings.TrimPrefix(r.URL.Path, "/"+bucketName+"/")
+		}
+		content, found := objects[name]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if isAttrs {
+			json.NewEncoder(w).Encode(getAttrs(name, content))
+			return
+		}
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	newClient, err := getClientConstructor(ClientTransport{Endpoint: server.URL + "/storage/v1/"}, false)
+	if err == nil {
+		client, err = newClient(context.Background(), option.WithoutAuthentication())
+	}
+	if err != nil {
+		t.Fatal("Could not connect to test storage server", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return
+}
+
+func TestDownloadFileToPartial(t *testing.T) {
+	is := assert.New(t)
+	ctx := context.Background()
+	content := []byte(strings.Repeat("backup data ", 1000))
+	client, ranges := newTestStorageServer(t, "test-matt-server-backups", map[string][]byte{"backup.tar.gz": content})
+	bucket := newGCSStore(client.Bucket("test-matt-server-backups"))
+	localFilePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+	partialFilePath := getPartialFilePath(localFilePath)
+
+	//an earlier attempt got part way
+	is.NoError(os.WriteFile(partialFilePath, content[:5000], 0644))
+	is.NoError(downloadFile(ctx, bucket, "backup.tar.gz", localFilePath))
+	is.Equal([]string{"bytes=5000-"}, *ranges, "Should resume from the end of the partial file")
+	saved, err := os.ReadFile(localFilePath)
+	is.NoError(err)
+	is.Equal(content, saved)
+	is.NoFileExists(partialFilePath, "Should rename the partial file into place")
+
+	//one that doesn't match what's in the bucket is started over
+	*ranges = nil
+	is.NoError(os.WriteFile(partialFilePath, []byte("something else"), 0644))
+	os.Remove(localFilePath)
+	err = downloadFile(ctx, bucket, "backup.tar.gz", localFilePath)
+	is.True(errors.IsNotValid(err), "Should fail verification of a resumed file spliced from other data")
+	is.NoFileExists(partialFilePath, "Should remove a partial file that fails verification so the retry starts over")
+	is.NoFileExists(localFilePath, "Should not move a file that fails verification into place")
+	is.NoError(downloadFile(ctx, bucket, "backup.tar.gz", localFilePath))
+	is.Equal([]string{"bytes=14-", ""}, *ranges)
+}
+
+func TestDownloadFileWithFsync(t *testing.T) {
+	is := assert.New(t)
+	ctx := withFsyncDownloads(context.Background(), true)
+	content := []byte("photo data")
+	client, _ := newTestStorageServer(t, "test-matt-photos", map[string][]byte{"2014-11/IMG_09.gif": content})
+	localFilePath := filepath.Join(t.TempDir(), "IMG_09.gif")
+	is.True(fsyncDownloadsFromContext(ctx))
+	is.False(fsyncDownloadsFromContext(withFsyncDownloads(context.Background(), false)))
+
+	is.NoError(downloadFile(ctx, newGCSStore(client.Bucket("test-matt-photos")), "2014-11/IMG_09.gif", localFilePath))
+	saved, err := os.ReadFile(localFilePath)
+	is.NoError(err)
+	is.Equal(content, saved)
+	is.NoFileExists(getPartialFilePath(localFilePath))
+}
+
+func TestGetResumeOffset(t *testing.T) {
+	is := assert.New(t)
+	partialFilePath := filepath.Join(t.TempDir(), "backup.tar.gz.partial")
+	is.Equal(int64(0), getResumeOffset(partialFilePath, 100), "Should start a file without a partial file from the beginning")
+	is.NoError(os.WriteFile(partialFilePath, make([]byte, 40), 0644))
+	is.Equal(int64(40), getResumeOffset(partialFilePath, 100))
+	is.Equal(int64(0), getResumeOffset(partialFilePath, 40), "Should start over when the partial file can't be a prefix")
+}
+
 func TestVerifyDownloadedFile(t *testing.T) {
 	is := assert.New(t)
 	workingDir, err := os.Getwd()
@@ -902,22 +1160,22 @@ func TestVerifyDownloadedFile(t *testing.T) {
 		t.Error("Could not load remote test file")
 	}
 
-	err = verifyDownloadedFile(nil, diffSizeTestFile)
+	err = verifyDownloadedFile(ctx, nil, diffSizeTestFile)
 	is.Error(err, "Should error but not panic when passed a bad objAttrs")
 	is.True(errors.IsNotValid(err), "Should return NotValid error when passed a bad objAttrs")
 
-	err = verifyDownloadedFile(testObj, "/does/not/exist")
+	err = verifyDownloadedFile(ctx, testObj, "/does/not/exist")
 	is.Error(err, "Should error but not panic when passed a bad file path")
 	is.True(errors.IsNotFound(err), "Should return NotFound error when passed a bad file path")
 
-	err = verifyDownloadedFile(testObj, sameContentsTestFile)
+	err = verifyDownloadedFile(ctx, testObj, sameContentsTestFile)
 	is.NoError(err, "Should verify that same contents mean same file")
 
-	err = verifyDownloadedFile(testObj, diffSizeTestFile)
+	err = verifyDownloadedFile(ctx, testObj, diffSizeTestFile)
 	is.Error(err, "Should verify that different sizes mean different file")
 	is.True(errors.IsNotValid(err), "Should return NotValid error when file has a different size")
 
-	err = verifyDownloadedFile(testObj, sameSizeDiffContentsTestFile)
+	err = verifyDownloadedFile(ctx, testObj, sameSizeDiffContentsTestFile)
 	is.Error(err, "Should verify that different contents mean different file")
 	is.True(errors.IsNotValid(err), "Should return NotValid error when file has different contents")
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+const (
+	validatorPluginExtension = ".so"
+	// validatorPluginSymbol is the function a validator plugin exports, see ValidatorPluginFunc.
+	validatorPluginSymbol = "Validate"
+)
+
+// ValidatorPluginFunc is the Validate function of a validator plugin. It calls next for each object of the bucket in turn,
+// until next returns iterator.Done, and fails the bucket by returning an error. Its warnings are added to the bucket's summary.
+type ValidatorPluginFunc = func(ctx context.Context, next func() (*storage.ObjectAttrs, error)) (warnings []string, err error)
+
+// registerValidatorPlugins opens each plugin in the directory and registers it as a kind of custom validator named after its file,
+// e.g. noTempFiles.so is the validator kind noTempFiles. It returns the kinds registered, and does nothing without a directory.
+func registerValidatorPlugins(dir string) (kinds []string, err error) {
+	if len(dir) == 0 {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to read validator plugins directory %s", dir)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != validatorPluginExtension {
+			continue
+		}
+		kind := strings.TrimSuffix(entry.Name(), validatorPluginExtension)
+		if _, found := validatorRegistry[kind]; found {
+			return kinds, errors.AlreadyExistsf("Validator kind %s of plugin %s", kind, entry.Name())
+		}
+		validate, err := openValidatorPlugin(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return kinds, err
+		}
+		validatorRegistry[kind] = func(validator CustomValidator) (customValidatorFunc, error) {
+			return getPluginValidator(validate), nil
+		}
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return
+}
+
+// getPluginValidator streams the listing to the plugin.
+func getPluginValidator(validate ValidatorPluginFunc) customValidatorFunc {
+	return func(ctx context.Context, objects objectIterator) error {
+		warnings, err := validate(ctx, objects.Next)
+		for _, warning := range warnings {
+			recordWarning(ctx, "%s", warning)
+		}
+		if err != nil {
+			return errors.NewNotValid(err, "Objects failed validation")
+		}
+		return nil
+	}
+}
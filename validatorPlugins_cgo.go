@@ -0,0 +1,30 @@
+//go:build (linux || darwin) && cgo
+
+package main
+
+import (
+	"context"
+	"plugin"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+)
+
+// openValidatorPlugin loads a plugin built with go build -buildmode=plugin against the same versions of the storage library.
+func openValidatorPlugin(filePath string) (ValidatorPluginFunc, error) {
+	loaded, err := plugin.Open(filePath)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unable to load validator plugin %s", filePath)
+	}
+	symbol, err := loaded.Lookup(validatorPluginSymbol)
+	if err != nil {
+		return nil, errors.NotFoundf("Function %s in validator plugin %s", validatorPluginSymbol, filePath)
+	}
+	switch validate := symbol.(type) {
+	case func(ctx context.Context, next func() (*storage.ObjectAttrs, error)) ([]string, error):
+		return validate, nil
+	case *ValidatorPluginFunc:
+		return *validate, nil
+	}
+	return nil, errors.NotValidf("%s in validator plugin %s, expected a ValidatorPluginFunc", validatorPluginSymbol, filePath)
+}
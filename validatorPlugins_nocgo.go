@@ -0,0 +1,12 @@
+//go:build !((linux || darwin) && cgo)
+
+package main
+
+import (
+	"github.com/juju/errors"
+)
+
+// openValidatorPlugin can't load go plugins, they need cgo on linux or macOS.
+func openValidatorPlugin(filePath string) (ValidatorPluginFunc, error) {
+	return nil, errors.NotSupportedf("Validator plugin %s, this build can't load plugins", filePath)
+}
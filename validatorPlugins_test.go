@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+)
+
+func TestRegisterValidatorPlugins(t *testing.T) {
+	is := assert.New(t)
+	kinds, err := registerValidatorPlugins("")
+	is.NoError(err)
+	is.Empty(kinds, "Should do nothing without a plugins directory")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a plugin"), 0600)
+	kinds, err = registerValidatorPlugins(dir)
+	is.NoError(err)
+	is.Empty(kinds, "Should only load .so files")
+
+	_, err = registerValidatorPlugins(filepath.Join(dir, "missing"))
+	is.Error(err)
+
+	os.WriteFile(filepath.Join(dir, "exec.so"), []byte("not a plugin"), 0600)
+	_, err = registerValidatorPlugins(dir)
+	is.True(errors.IsAlreadyExists(err), "Should not replace a built in validator kind: %v", err)
+	os.Remove(filepath.Join(dir, "exec.so"))
+
+	os.WriteFile(filepath.Join(dir, "broken.so"), []byte("not a plugin"), 0600)
+	_, err = registerValidatorPlugins(dir)
+	is.ErrorContains(err, "broken.so")
+	_, found := validatorRegistry["broken"]
+	is.False(found, "Should not register a plugin that didn't load")
+}
+
+// testNoTempFilesPlugin is what a plugin's Validate could look like.
+func testNoTempFilesPlugin(ctx context.Context, next func() (*storage.ObjectAttrs, error)) (warnings []string, err error) {
+	for {
+		attrs, err := next()
+		if err == iterator.Done {
+			return warnings, nil
+		}
+		if err != nil {
+			return warnings, err
+		}
+		if attrs.Size == 0 {
+			warnings = append(warnings, "empty "+attrs.Name)
+		}
+		if strings.HasSuffix(attrs.Name, ".tmp") {
+			return warnings, errors.Errorf("left over %s", attrs.Name)
+		}
+	}
+}
+
+func TestGetPluginValidator(t *testing.T) {
+	is := assert.New(t)
+	defer delete(validatorRegistry, "noTempFiles")
+	validatorRegistry["noTempFiles"] = func(validator CustomValidator) (customValidatorFunc, error) {
+		return getPluginValidator(testNoTempFilesPlugin), nil
+	}
+	validators := []CustomValidator{{Kind: "noTempFiles"}}
+
+	bs := &BucketSummary{}
+	ctx := withInventoryReport(withBucketSummary(context.Background(), bs), &inventoryReport{objects: []*storage.ObjectAttrs{
+		{Name: "2018-01/IMG_01.jpg", Size: 10}, {Name: "2018-01/IMG_02.jpg"}}})
+	is.NoError(runCustomValidators(ctx, nil, validators))
+	is.Equal([]string{"empty 2018-01/IMG_02.jpg"}, bs.Warnings, "Should record the plugin's warnings")
+
+	ctx = withInventoryReport(ctx, &inventoryReport{objects: []*storage.ObjectAttrs{{Name: "2018-02/upload.tmp", Size: 10}}})
+	err := runCustomValidators(ctx, nil, validators)
+	is.True(errors.IsNotValid(err))
+	is.ErrorContains(err, "Objects failed validation: left over 2018-02/upload.tmp")
+}
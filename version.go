@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// BuildInfo describes the running binary. Revision and Modified come from the version control details
+// go build stamps into binaries built from a checkout, they are blank for go run and go test.
+type BuildInfo struct {
+	Version   string
+	Revision  string
+	Time      string
+	Modified  bool
+	GoVersion string
+	Platform  string
+}
+
+func getBuildInfo() (info BuildInfo) {
+	info.Version = "(devel)"
+	info.GoVersion = runtime.Version()
+	info.Platform = runtime.GOOS + "/" + runtime.GOARCH
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if len(buildInfo.Main.Version) > 0 {
+		info.Version = buildInfo.Main.Version
+	}
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return
+}
+
+// getEnabledProviders lists the storage URI schemes buckets can use.
+func getEnabledProviders() (providers []string) {
+	for provider, supported := range storageProviders {
+		if supported {
+			providers = append(providers, provider)
+		}
+	}
+	sort.Strings(providers)
+	return
+}
+
+func writeVersion(w io.Writer, info BuildInfo, providers []string) error {
+	revision := info.Revision
+	if len(revision) == 0 {
+		revision = "unknown"
+	}
+	if info.Modified {
+		revision += " (modified)"
+	}
+	if len(info.Time) > 0 {
+		revision += " built from a commit at " + info.Time
+	}
+	_, err := fmt.Fprintf(w, "%s %s\nrevision: %s\ngo: %s %s\nproviders: %s\n", programName, info.Version,
+		revision, info.GoVersion, info.Platform, strings.Join(providers, ", "))
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteVersion(t *testing.T) {
+	is := assert.New(t)
+	var actual bytes.Buffer
+	err := writeVersion(&actual, BuildInfo{Version: "v1.2.0", Revision: "abc123", Time: "2018-01-20T01:02:03Z", Modified: true,
+		GoVersion: "go1.22.8", Platform: "windows/amd64"}, []string{"gs"})
+	is.NoError(err)
+	is.Equal("validatebackups v1.2.0\nrevision: abc123 (modified) built from a commit at 2018-01-20T01:02:03Z\n"+
+		"go: go1.22.8 windows/amd64\nproviders: gs\n", actual.String())
+
+	actual.Reset()
+	writeVersion(&actual, BuildInfo{Version: "(devel)", GoVersion: "go1.22.8", Platform: "linux/amd64"}, nil)
+	is.Contains(actual.String(), "revision: unknown\n")
+}
+
+func TestGetBuildInfo(t *testing.T) {
+	is := assert.New(t)
+	info := getBuildInfo()
+	is.NotEmpty(info.Version)
+	is.NotEmpty(info.GoVersion)
+	is.Contains(info.Platform, "/")
+	is.Equal([]string{"gs", "s3"}, getEnabledProviders())
+}